@@ -0,0 +1,38 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import "errors"
+
+// AbortError is a modifier error that aborts the exchange. Instead of
+// continuing with a warning header, the proxy responds to the client with its
+// error response, see Proxy.ErrorResponse.
+type AbortError struct {
+	Err error
+}
+
+// Abort wraps err so that it aborts the exchange when returned by a
+// modifier. It returns nil if err is nil.
+func Abort(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &AbortError{Err: err}
+}
+
+// Error returns the message of the wrapped error.
+func (e *AbortError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+// IsAbort reports whether err, or any error it wraps, is an *AbortError.
+func IsAbort(err error) bool {
+	var aerr *AbortError
+	return errors.As(err, &aerr)
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package accesslog provides a modifier that writes one access log line per
+// request and response pair in the Common or Combined Log Format.
+package accesslog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+// Format is an access log line format.
+type Format int
+
+const (
+	// Common is the Common Log Format:
+	//	host ident user [time] "request line" status bytes
+	Common Format = iota
+	// Combined is the Combined Log Format, the Common Log Format followed by
+	// the quoted Referer and User-Agent headers.
+	Combined
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case Common:
+		return "common"
+	case Combined:
+		return "combined"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// ParseFormat returns the format named s, "common" or "combined".
+func ParseFormat(s string) (Format, error) {
+	for _, f := range []Format{Common, Combined} {
+		if f.String() == s {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("accesslog: unknown format %q", s)
+}
+
+const timeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// entryKey is the context key of the request part of an access log line.
+const entryKey = "accesslog.Entry"
+
+func init() {
+	parse.Register("accesslog.Logger", loggerFromJSON)
+}
+
+// Logger is a modifier that writes an access log line for every request and
+// response pair. Lines are written once the response body is closed, so that
+// the number of body bytes and the duration of the exchange are known. The
+// duration is appended to every line in microseconds, as Apache's %D.
+type Logger struct {
+	format Format
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type entry struct {
+	host    string
+	user    string
+	start   time.Time
+	line    string
+	referer string
+	agent   string
+}
+
+type loggerJSON struct {
+	Scope  []parse.ModifierType `json:"scope"`
+	Format string               `json:"format"`
+}
+
+// NewLogger returns a logger that writes access log lines in the Common Log
+// Format to w. If w is nil, lines are logged with log.Infof.
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{
+		w: w,
+	}
+}
+
+// SetFormat sets the format of the lines.
+func (l *Logger) SetFormat(f Format) {
+	l.format = f
+}
+
+// ModifyRequest records the request part of the access log line.
+func (l *Logger) ModifyRequest(req *http.Request) error {
+	ctx := martian.NewContext(req)
+	if ctx.SkippingLogging() {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ctx.Set(entryKey, &entry{
+		host:    host,
+		user:    proxyUser(req),
+		start:   time.Now(),
+		line:    fmt.Sprintf("%s %s %s", req.Method, req.URL, req.Proto),
+		referer: req.Referer(),
+		agent:   req.UserAgent(),
+	})
+
+	return nil
+}
+
+// ModifyResponse writes the access log line once the body of res is closed.
+func (l *Logger) ModifyResponse(res *http.Response) error {
+	ctx := martian.NewContext(res.Request)
+	if ctx.SkippingLogging() {
+		return nil
+	}
+
+	v, ok := ctx.Get(entryKey)
+	if !ok {
+		return nil
+	}
+	e := v.(*entry)
+
+	if res.Body == nil || res.Body == http.NoBody {
+		l.write(res.Request, e, res.StatusCode, 0)
+		return nil
+	}
+
+	res.Body = &countingBody{
+		ReadCloser: res.Body,
+		done: func(n int64) {
+			l.write(res.Request, e, res.StatusCode, n)
+		},
+	}
+
+	return nil
+}
+
+func (l *Logger) write(req *http.Request, e *entry, status int, n int64) {
+	size := "-"
+	if n > 0 {
+		size = fmt.Sprint(n)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		e.host, orDash(e.user), e.start.Format(timeFormat), e.line, status, size)
+	if l.format == Combined {
+		line += fmt.Sprintf(" %q %q", orDash(e.referer), orDash(e.agent))
+	}
+	line += fmt.Sprintf(" %d", time.Since(e.start).Microseconds())
+
+	if l.w == nil {
+		log.FromContext(req.Context()).Infof("%s", line)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := io.WriteString(l.w, line+"\n"); err != nil {
+		log.Errorf("accesslog: failed to write access log: %v", err)
+	}
+}
+
+// proxyUser returns the user name of the Basic Proxy-Authorization of req, or
+// an empty string.
+func proxyUser(req *http.Request) string {
+	auth := req.Header.Get("Proxy-Authorization")
+	if len(auth) < 6 || !strings.EqualFold(auth[:6], "Basic ") {
+		return ""
+	}
+
+	b, err := base64.StdEncoding.DecodeString(auth[6:])
+	if err != nil {
+		return ""
+	}
+	user, _, _ := strings.Cut(string(b), ":")
+
+	return user
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// countingBody counts the bytes read from the body and calls done with the
+// count when closed.
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.done(b.n)
+	})
+	return err
+}
+
+// loggerFromJSON builds an access logger from JSON. Lines are logged with
+// log.Infof.
+//
+// Example JSON:
+//
+//	{
+//	  "accesslog.Logger": {
+//	    "scope": ["request", "response"],
+//	    "format": "combined"
+//	  }
+//	}
+func loggerFromJSON(b []byte) (*parse.Result, error) {
+	msg := &loggerJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	l := NewLogger(nil)
+	if msg.Format != "" {
+		f, err := ParseFormat(msg.Format)
+		if err != nil {
+			return nil, err
+		}
+		l.SetFormat(f)
+	}
+
+	return parse.NewResult(l, msg.Scope)
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package accesslog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: Common,
+			want: `^192\.0\.2\.1 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] ` +
+				`"GET http://example.com/path HTTP/1\.1" 201 5 \d+\n$`,
+		},
+		{
+			format: Combined,
+			want: `^192\.0\.2\.1 - alice \[[^]]+\] "GET http://example.com/path HTTP/1\.1" 201 5 ` +
+				`"http://example.com/" "test-agent" \d+\n$`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.format.String(), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			l := NewLogger(buf)
+			l.SetFormat(tc.format)
+
+			req, err := http.NewRequest("GET", "http://example.com/path", nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("Proxy-Authorization", "Basic YWxpY2U6c2VjcmV0")
+			req.Header.Set("Referer", "http://example.com/")
+			req.Header.Set("User-Agent", "test-agent")
+			martian.TestContext(req, nil, nil)
+
+			if err := l.ModifyRequest(req); err != nil {
+				t.Fatalf("ModifyRequest(): got %v, want no error", err)
+			}
+			res := proxyutil.NewResponse(201, strings.NewReader("hello"), req)
+			if err := l.ModifyResponse(res); err != nil {
+				t.Fatalf("ModifyResponse(): got %v, want no error", err)
+			}
+
+			if got := buf.String(); got != "" {
+				t.Fatalf("access log before body closed: got %q, want none", got)
+			}
+			if _, err := ioutil.ReadAll(res.Body); err != nil {
+				t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+			}
+			res.Body.Close()
+			res.Body.Close()
+
+			if got := buf.String(); !regexp.MustCompile(tc.want).MatchString(got) {
+				t.Errorf("access log: got %q, want to match %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, want := range []Format{Common, Combined} {
+		got, err := ParseFormat(want.String())
+		if err != nil {
+			t.Fatalf("ParseFormat(%q): got %v, want no error", want, err)
+		}
+		if got != want {
+			t.Errorf("ParseFormat(%q): got %v, want %v", want, got, want)
+		}
+	}
+
+	if _, err := ParseFormat("unknown"); err == nil {
+		t.Errorf("ParseFormat(%q): got nil error, want error", "unknown")
+	}
+}
+
+func TestLoggerFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"accesslog.Logger": {
+			"scope": ["request", "response"],
+			"format": "combined"
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	l, ok := r.RequestModifier().(*Logger)
+	if !ok {
+		t.Fatal("r.RequestModifier().(*Logger): got !ok, want ok")
+	}
+	if got, want := l.format, Combined; got != want {
+		t.Errorf("l.format: got %v, want %v", got, want)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"accesslog.Logger": {"scope": ["request"], "format": "short"}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil error, want error for unknown format")
+	}
+}
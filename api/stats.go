@@ -0,0 +1,183 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/connmetric"
+	"github.com/google/martian/v3/log"
+)
+
+// defaultTopHosts is the number of hosts reported in Stats.TopHosts unless set
+// with StatsHandler.SetTopHosts.
+const defaultTopHosts = 10
+
+// Stats are the current statistics of a proxy.
+type Stats struct {
+	// ActiveConnections is the number of open client connections,
+	// Connections the number of client connections accepted.
+	ActiveConnections int64 `json:"activeConnections"`
+	Connections       int64 `json:"connections"`
+	// Requests is the number of round trips, StatusClasses the number of round
+	// trips by the class of the response status, such as "2xx", or "error"
+	// for failed round trips.
+	Requests      int64            `json:"requests"`
+	StatusClasses map[string]int64 `json:"statusClasses"`
+	// TopHosts are the upstream hosts with the most bytes transferred over
+	// closed connections, in descending order.
+	TopHosts []HostTraffic `json:"topHosts"`
+}
+
+// HostTraffic holds the traffic of the connections to an upstream host.
+type HostTraffic struct {
+	Host         string `json:"host"`
+	Connections  int64  `json:"connections"`
+	BytesRead    int64  `json:"bytesRead"`
+	BytesWritten int64  `json:"bytesWritten"`
+}
+
+// StatsHandler collects the statistics of a proxy and serves them as JSON. It
+// implements martian.Observer, to be set as martian.Proxy.Observer, and
+// connmetric.Tracker, to be set with martian.Proxy.SetConnTracker, for the
+// traffic of upstream hosts.
+type StatsHandler struct {
+	mu       sync.Mutex
+	topHosts int
+	stats    Stats
+	hosts    map[string]*HostTraffic
+}
+
+var (
+	_ martian.Observer   = (*StatsHandler)(nil)
+	_ connmetric.Tracker = (*StatsHandler)(nil)
+)
+
+// NewStatsHandler returns a new StatsHandler reporting the 10 upstream hosts
+// with the most traffic.
+func NewStatsHandler() *StatsHandler {
+	return &StatsHandler{
+		topHosts: defaultTopHosts,
+		stats: Stats{
+			StatusClasses: make(map[string]int64),
+		},
+		hosts: make(map[string]*HostTraffic),
+	}
+}
+
+// SetTopHosts sets the number of upstream hosts with the most traffic that
+// are reported.
+func (h *StatsHandler) SetTopHosts(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.topHosts = n
+}
+
+// ConnOpened counts an accepted client connection.
+func (h *StatsHandler) ConnOpened() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stats.Connections++
+	h.stats.ActiveConnections++
+}
+
+// ConnClosed counts a closed client connection.
+func (h *StatsHandler) ConnClosed(read, written int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stats.ActiveConnections--
+}
+
+// MITMHandshake does nothing.
+func (h *StatsHandler) MITMHandshake(err error) {}
+
+// RoundTrip counts a round trip by the class of its response status.
+func (h *StatsHandler) RoundTrip(req *http.Request, res *http.Response, d time.Duration, err error) {
+	class := "error"
+	if err == nil && res != nil {
+		class = strconv.Itoa(res.StatusCode/100) + "xx"
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stats.Requests++
+	h.stats.StatusClasses[class]++
+}
+
+// ModifierError does nothing.
+func (h *StatsHandler) ModifierError(req *http.Request, err error) {}
+
+// TrackConn adds the traffic of a closed upstream connection to that of its
+// host. Accepted client connections are ignored.
+func (h *StatsHandler) TrackConn(e *connmetric.StatsEntry) {
+	if e.Accepted {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ht, ok := h.hosts[e.Host]
+	if !ok {
+		ht = &HostTraffic{Host: e.Host}
+		h.hosts[e.Host] = ht
+	}
+	ht.Connections++
+	ht.BytesRead += e.BytesRead
+	ht.BytesWritten += e.BytesWritten
+}
+
+// Stats returns the current statistics.
+func (h *StatsHandler) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.stats
+	s.StatusClasses = make(map[string]int64, len(h.stats.StatusClasses))
+	for c, n := range h.stats.StatusClasses {
+		s.StatusClasses[c] = n
+	}
+
+	s.TopHosts = make([]HostTraffic, 0, len(h.hosts))
+	for _, ht := range h.hosts {
+		s.TopHosts = append(s.TopHosts, *ht)
+	}
+	sort.Slice(s.TopHosts, func(i, j int) bool {
+		ti := s.TopHosts[i].BytesRead + s.TopHosts[i].BytesWritten
+		tj := s.TopHosts[j].BytesRead + s.TopHosts[j].BytesWritten
+		if ti != tj {
+			return ti > tj
+		}
+		return s.TopHosts[i].Host < s.TopHosts[j].Host
+	})
+	if len(s.TopHosts) > h.topHosts {
+		s.TopHosts = s.TopHosts[:h.topHosts]
+	}
+
+	return s
+}
+
+// ServeHTTP writes the current statistics as JSON.
+func (h *StatsHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		rw.Header().Set("Allow", "GET")
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		log.Errorf("api: invalid request method: %s", req.Method)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(rw).Encode(h.Stats()); err != nil {
+		log.Errorf("api: failed to write stats: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/connmetric"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestStatsHandler(t *testing.T) {
+	h := NewStatsHandler()
+	h.SetTopHosts(2)
+
+	h.ConnOpened()
+	h.ConnOpened()
+	h.ConnClosed(10, 20)
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	for _, code := range []int{200, 204, 404} {
+		h.RoundTrip(req, proxyutil.NewResponse(code, nil, req), time.Millisecond, nil)
+	}
+	h.RoundTrip(req, nil, time.Millisecond, errors.New("connection refused"))
+
+	for _, e := range []*connmetric.StatsEntry{
+		{Host: "a.example.com", BytesRead: 100, BytesWritten: 10},
+		{Host: "b.example.com", BytesRead: 500},
+		{Host: "a.example.com", BytesRead: 100, BytesWritten: 10},
+		{Host: "c.example.com", BytesRead: 1},
+		{Host: "127.0.0.1", BytesRead: 1000, Accepted: true},
+	} {
+		h.TrackConn(e)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "http://martian.proxy/stats", nil))
+	if got, want := rw.Code, 200; got != want {
+		t.Fatalf("rw.Code: got %d, want %d", got, want)
+	}
+	if got, want := rw.Header().Get("Content-Type"), "application/json; charset=utf-8"; got != want {
+		t.Errorf("Content-Type: got %q, want %q", got, want)
+	}
+
+	var got Stats
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal(): got %v, want no error", err)
+	}
+	want := Stats{
+		ActiveConnections: 1,
+		Connections:       2,
+		Requests:          4,
+		StatusClasses:     map[string]int64{"2xx": 2, "4xx": 1, "error": 1},
+		TopHosts: []HostTraffic{
+			{Host: "b.example.com", Connections: 1, BytesRead: 500},
+			{Host: "a.example.com", Connections: 2, BytesRead: 200, BytesWritten: 20},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stats: got %+v, want %+v", got, want)
+	}
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("POST", "http://martian.proxy/stats", nil))
+	if got, want := rw.Code, 405; got != want {
+		t.Errorf("rw.Code: got %d, want %d", got, want)
+	}
+}
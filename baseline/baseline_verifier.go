@@ -0,0 +1,331 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package baseline provides a verifier that compares live responses against
+// stored baseline bodies and reports drift through the verify API.
+package baseline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/messageview"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("baseline.Verifier", verifierFromJSON)
+}
+
+const diffErrFormat = "response(%s) baseline verify failure: %s"
+
+// Endpoint is a baseline for the responses of a single endpoint.
+type Endpoint struct {
+	// Method matches the request method, if set.
+	Method string
+	// URL matches the request URL.
+	URL *regexp.Regexp
+	// Body is the expected response body.
+	Body []byte
+	// Ignore lists JSON paths that are excluded from comparison when both
+	// bodies are valid JSON. Path segments are separated by dots and "*"
+	// matches any object key or array index, e.g. "meta.timestamp" or
+	// "items.*.id".
+	Ignore []string
+}
+
+// Verifier compares response bodies of matching endpoints against their
+// baselines.
+type Verifier struct {
+	mu        sync.RWMutex
+	endpoints []*Endpoint
+	reserr    *martian.MultiError
+}
+
+type verifierJSON struct {
+	Endpoints []endpointJSON       `json:"endpoints"`
+	Scope     []parse.ModifierType `json:"scope"`
+}
+
+type endpointJSON struct {
+	Method string   `json:"method"`
+	URL    string   `json:"url"`
+	Body   []byte   `json:"body"` // Body is expected to be a Base64 encoded string.
+	File   string   `json:"file"`
+	Ignore []string `json:"ignore"`
+}
+
+// NewVerifier returns a new baseline verifier with no endpoints.
+func NewVerifier() *Verifier {
+	return &Verifier{
+		reserr: martian.NewMultiError(),
+	}
+}
+
+// AddEndpoint adds a baseline. Responses are compared against the first
+// matching endpoint only.
+func (v *Verifier) AddEndpoint(e *Endpoint) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.endpoints = append(v.endpoints, e)
+}
+
+// ModifyResponse compares the response body with the baseline of the first
+// matching endpoint. An error is added to the contained *MultiError for every
+// response that differs from its baseline.
+func (v *Verifier) ModifyResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil {
+		return nil
+	}
+
+	e := v.match(req)
+	if e == nil {
+		return nil
+	}
+
+	mv := messageview.New()
+	if err := mv.SnapshotResponse(res); err != nil {
+		return err
+	}
+	br, err := mv.BodyReader(messageview.Decode())
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+
+	got, err := io.ReadAll(br)
+	if err != nil {
+		return err
+	}
+
+	if diff := compare(got, e.Body, e.Ignore); diff != "" {
+		v.mu.Lock()
+		v.reserr.Add(fmt.Errorf(diffErrFormat, req.URL, diff))
+		v.mu.Unlock()
+	}
+
+	return nil
+}
+
+// VerifyResponses returns an error if any response differed from its baseline.
+// If an error is returned it will be of type *martian.MultiError.
+func (v *Verifier) VerifyResponses() error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.reserr.Empty() {
+		return nil
+	}
+
+	return v.reserr
+}
+
+// ResetResponseVerifications clears all failed response verifications.
+func (v *Verifier) ResetResponseVerifications() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.reserr = martian.NewMultiError()
+}
+
+func (v *Verifier) match(req *http.Request) *Endpoint {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	for _, e := range v.endpoints {
+		if e.Method != "" && !strings.EqualFold(e.Method, req.Method) {
+			continue
+		}
+		if e.URL != nil && !e.URL.MatchString(req.URL.String()) {
+			continue
+		}
+		return e
+	}
+
+	return nil
+}
+
+// compare returns a description of the first difference between got and
+// want, or an empty string if they are equal. JSON bodies are compared
+// structurally with the ignored paths removed.
+func compare(got, want []byte, ignore []string) string {
+	var gotv, wantv any
+	if json.Unmarshal(got, &gotv) != nil || json.Unmarshal(want, &wantv) != nil {
+		if bytes.Equal(got, want) {
+			return ""
+		}
+		return fmt.Sprintf("body differs: got %d bytes, want %d bytes", len(got), len(want))
+	}
+
+	for _, p := range ignore {
+		path := strings.Split(p, ".")
+		gotv = prune(gotv, path)
+		wantv = prune(wantv, path)
+	}
+
+	return diff("$", gotv, wantv)
+}
+
+// prune returns v with the values at path removed.
+func prune(v any, path []string) any {
+	if len(path) == 0 {
+		return nil
+	}
+
+	switch tv := v.(type) {
+	case map[string]any:
+		for k, cv := range tv {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				delete(tv, k)
+				continue
+			}
+			tv[k] = prune(cv, path[1:])
+		}
+	case []any:
+		for i, cv := range tv {
+			if path[0] != "*" && path[0] != strconv.Itoa(i) {
+				continue
+			}
+			if len(path) == 1 {
+				tv[i] = nil
+				continue
+			}
+			tv[i] = prune(cv, path[1:])
+		}
+	}
+
+	return v
+}
+
+func diff(path string, got, want any) string {
+	switch wv := want.(type) {
+	case map[string]any:
+		gv, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(wv)+len(gv))
+		for k := range wv {
+			keys = append(keys, k)
+		}
+		for k := range gv {
+			if _, ok := wv[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := path + "." + k
+			gcv, gok := gv[k]
+			wcv, wok := wv[k]
+			switch {
+			case !gok:
+				return fmt.Sprintf("%s: got no value, want %s", p, marshal(wcv))
+			case !wok:
+				return fmt.Sprintf("%s: got %s, want no value", p, marshal(gcv))
+			}
+			if d := diff(p, gcv, wcv); d != "" {
+				return d
+			}
+		}
+		return ""
+	case []any:
+		gv, ok := got.([]any)
+		if !ok {
+			break
+		}
+		if len(gv) != len(wv) {
+			return fmt.Sprintf("%s: got %d elements, want %d elements", path, len(gv), len(wv))
+		}
+		for i := range wv {
+			if d := diff(path+"."+strconv.Itoa(i), gv[i], wv[i]); d != "" {
+				return d
+			}
+		}
+		return ""
+	}
+
+	if reflect.DeepEqual(got, want) {
+		return ""
+	}
+	return fmt.Sprintf("%s: got %s, want %s", path, marshal(got), marshal(want))
+}
+
+func marshal(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// verifierFromJSON builds a baseline.Verifier from JSON. The baseline body
+// is either given inline as a Base64 encoded string or read from file.
+//
+// Example JSON:
+//
+//	{
+//	  "baseline.Verifier": {
+//	    "scope": ["response"],
+//	    "endpoints": [
+//	      {
+//	        "method": "GET",
+//	        "url": "^https://api\\.example\\.com/v1/users$",
+//	        "file": "/baselines/users.json",
+//	        "ignore": ["meta.timestamp", "items.*.id"]
+//	      }
+//	    ]
+//	  }
+//	}
+func verifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &verifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	v := NewVerifier()
+	for _, ej := range msg.Endpoints {
+		e := &Endpoint{
+			Method: ej.Method,
+			Body:   ej.Body,
+			Ignore: ej.Ignore,
+		}
+
+		if ej.URL != "" {
+			re, err := regexp.Compile(ej.URL)
+			if err != nil {
+				return nil, err
+			}
+			e.URL = re
+		}
+
+		if ej.File != "" {
+			body, err := os.ReadFile(ej.File)
+			if err != nil {
+				return nil, fmt.Errorf("baseline: failed to read baseline: %w", err)
+			}
+			e.Body = body
+		}
+
+		v.AddEndpoint(e)
+	}
+
+	return parse.NewResult(v, msg.Scope)
+}
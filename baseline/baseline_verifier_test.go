@@ -0,0 +1,161 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package baseline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+	"github.com/google/martian/v3/verify"
+)
+
+func TestVerifyResponses(t *testing.T) {
+	v := NewVerifier()
+	v.AddEndpoint(&Endpoint{
+		Method: "GET",
+		URL:    regexp.MustCompile(`^http://example\.com/users$`),
+		Body:   []byte(`{"meta":{"timestamp":1},"items":[{"id":1,"name":"alice"}]}`),
+		Ignore: []string{"meta.timestamp", "items.*.id"},
+	})
+	v.AddEndpoint(&Endpoint{
+		URL:  regexp.MustCompile(`/plain$`),
+		Body: []byte("hello"),
+	})
+
+	tt := []struct {
+		method, url, body string
+		want              string
+	}{
+		{"GET", "http://example.com/users", `{"meta":{"timestamp":2},"items":[{"id":7,"name":"alice"}]}`, ""},
+		{"GET", "http://example.com/users", `{"meta":{"timestamp":2},"items":[{"id":7,"name":"bob"}]}`, `$.items.0.name: got "bob", want "alice"`},
+		{"GET", "http://example.com/users", `{"items":[]}`, "$.items: got 0 elements, want 1 elements"},
+		{"GET", "http://example.com/users", `{"items":[{"name":"alice"}],"extra":true}`, "$.extra: got true, want no value"},
+		{"POST", "http://example.com/users", `not checked`, ""},
+		{"GET", "http://example.com/plain", "hello", ""},
+		{"GET", "http://example.com/plain", "goodbye", "body differs: got 7 bytes, want 5 bytes"},
+		{"GET", "http://example.com/other", "anything", ""},
+	}
+
+	for i, tc := range tt {
+		v.ResetResponseVerifications()
+
+		req, err := http.NewRequest(tc.method, tc.url, nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		res := proxyutil.NewResponse(200, strings.NewReader(tc.body), req)
+
+		if err := v.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		err = v.VerifyResponses()
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("%d. VerifyResponses(): got %v, want no error", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%d. VerifyResponses(): got no error, want %q", i, tc.want)
+			continue
+		}
+		if got := err.Error(); !strings.HasSuffix(got, tc.want) {
+			t.Errorf("%d. VerifyResponses(): got %q, want suffix %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestVerifyResponsesDecodesBody(t *testing.T) {
+	v := NewVerifier()
+	v.AddEndpoint(&Endpoint{
+		Body: []byte(`{"ok":true}`),
+	})
+
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	gw.Write([]byte(`{"ok": true}`))
+	gw.Close()
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, bytes.NewReader(buf.Bytes()), req)
+	res.Header.Set("Content-Encoding", "gzip")
+
+	if err := v.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if err := v.VerifyResponses(); err != nil {
+		t.Errorf("VerifyResponses(): got %v, want no error", err)
+	}
+
+	// The body is still readable after verification.
+	got := new(bytes.Buffer)
+	got.ReadFrom(res.Body)
+	if !bytes.Equal(got.Bytes(), buf.Bytes()) {
+		t.Error("res.Body: got modified body, want original body")
+	}
+}
+
+func TestVerifierFromJSON(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(file, []byte(`{"name":"alice"}`), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(): got %v, want no error", err)
+	}
+
+	msg := []byte(`{
+		"baseline.Verifier": {
+			"scope": ["response"],
+			"endpoints": [
+				{
+					"method": "GET",
+					"url": "/users$",
+					"file": "` + file + `"
+				}
+			]
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("r.ResponseModifier(): got nil, want not nil")
+	}
+	resv, ok := resmod.(verify.ResponseVerifier)
+	if !ok {
+		t.Fatal("resmod.(verify.ResponseVerifier): got !ok, want ok")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/users", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, strings.NewReader(`{"name":"bob"}`), req)
+
+	if err := resv.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	merr, ok := resv.VerifyResponses().(*martian.MultiError)
+	if !ok {
+		t.Fatal("VerifyResponses(): got nil, want *martian.MultiError")
+	}
+	if got, want := len(merr.Errors()), 1; got != want {
+		t.Errorf("len(merr.Errors()): got %d, want %d", got, want)
+	}
+}
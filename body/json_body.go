@@ -0,0 +1,108 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/google/martian/v3/log"
+)
+
+// DefaultMaxJSONBodySize is the default size above which the JSON body
+// modifiers leave bodies unchanged.
+const DefaultMaxJSONBodySize = 10 << 20
+
+// isJSON returns whether the media type of contentType is JSON.
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// modifyJSON replaces the body of a message, with header h, body and content
+// length cl, with the document returned by fn for the decoded body.
+//
+// Bodies are only modified if they are declared as JSON by their Content-Type,
+// are not encoded or encoded with gzip, and their encoded and decoded sizes
+// are at most max bytes; other bodies are left unchanged. The new body is not
+// encoded. If the body is not valid JSON or fn fails, the body is left
+// unchanged and the error is returned.
+func modifyJSON(h http.Header, body *io.ReadCloser, cl *int64, max int64, fn func(doc any) (any, error)) error {
+	if *body == nil || *body == http.NoBody || !isJSON(h.Get("Content-Type")) {
+		return nil
+	}
+
+	ce := strings.ToLower(h.Get("Content-Encoding"))
+	if ce != "" && ce != "identity" && ce != "gzip" {
+		log.Debugf("body: not modifying JSON body with Content-Encoding %q", ce)
+		return nil
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(*body, max+1))
+	if err != nil {
+		return err
+	}
+	// restore puts back the body as read so far, followed by the rest.
+	restore := func() {
+		*body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(raw), *body), *body}
+	}
+	if int64(len(raw)) > max {
+		log.Debugf("body: not modifying JSON body larger than %d bytes", max)
+		restore()
+		return nil
+	}
+
+	data := raw
+	if ce == "gzip" {
+		gr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			restore()
+			return err
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(gr, max+1))
+		if err != nil {
+			restore()
+			return err
+		}
+		if int64(len(data)) > max {
+			log.Debugf("body: not modifying JSON body larger than %d bytes once decoded", max)
+			restore()
+			return nil
+		}
+	}
+
+	doc, err := decodeJSON(data)
+	if err != nil {
+		restore()
+		return err
+	}
+	if doc, err = fn(doc); err != nil {
+		restore()
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := encodeJSON(buf, doc); err != nil {
+		restore()
+		return err
+	}
+
+	(*body).Close()
+	h.Del("Content-Encoding")
+	*body = ioutil.NopCloser(buf)
+	*cl = int64(buf.Len())
+
+	return nil
+}
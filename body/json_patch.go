@@ -0,0 +1,379 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("body.JSONPatch", jsonPatchModifierFromJSON)
+}
+
+// JSONPatchModifier applies a JSON Patch (RFC 6902) to JSON bodies. Bodies
+// that are not JSON, are encoded with an encoding other than gzip, or are
+// larger than the maximum body size are left unchanged. Patched bodies are
+// sent without encoding, with their Content-Length updated.
+//
+// As the patch is applied atomically, a failing operation, for instance a
+// "test" operation, leaves the body unchanged; the error is returned.
+type JSONPatchModifier struct {
+	ops     []patchOp
+	maxSize int64
+}
+
+type patchOp struct {
+	op    string
+	path  []string
+	from  []string
+	value any
+}
+
+type patchOpJSON struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+type jsonPatchModifierJSON struct {
+	Patch        json.RawMessage      `json:"patch"`
+	MaxBodyBytes int64                `json:"maxBodyBytes"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+// NewJSONPatchModifier returns a modifier applying the JSON Patch document
+// patch.
+func NewJSONPatchModifier(patch []byte) (*JSONPatchModifier, error) {
+	var msgs []patchOpJSON
+	if err := json.Unmarshal(patch, &msgs); err != nil {
+		return nil, fmt.Errorf("body: invalid JSON patch: %v", err)
+	}
+
+	m := &JSONPatchModifier{
+		maxSize: DefaultMaxJSONBodySize,
+	}
+	for i, msg := range msgs {
+		op, err := parsePatchOp(msg)
+		if err != nil {
+			return nil, fmt.Errorf("body: invalid JSON patch operation %d: %v", i, err)
+		}
+		m.ops = append(m.ops, op)
+	}
+
+	return m, nil
+}
+
+func parsePatchOp(msg patchOpJSON) (patchOp, error) {
+	op := patchOp{op: msg.Op}
+
+	if msg.Path == nil {
+		return op, fmt.Errorf("missing path")
+	}
+	path, err := parsePointer(*msg.Path)
+	if err != nil {
+		return op, err
+	}
+	op.path = path
+
+	switch msg.Op {
+	case "add", "replace", "test":
+		if msg.Value == nil {
+			return op, fmt.Errorf("missing value")
+		}
+		if op.value, err = decodeJSON(*msg.Value); err != nil {
+			return op, err
+		}
+	case "move", "copy":
+		if msg.From == nil {
+			return op, fmt.Errorf("missing from")
+		}
+		if op.from, err = parsePointer(*msg.From); err != nil {
+			return op, err
+		}
+		if msg.Op == "move" && len(op.from) < len(op.path) && isPrefix(op.from, op.path) {
+			return op, fmt.Errorf("cannot move %q into itself", *msg.From)
+		}
+	case "remove":
+	default:
+		return op, fmt.Errorf("unknown op %q", msg.Op)
+	}
+
+	return op, nil
+}
+
+// SetMaxBodySize sets the size above which bodies are left unchanged. It
+// defaults to DefaultMaxJSONBodySize.
+func (m *JSONPatchModifier) SetMaxBodySize(size int64) {
+	m.maxSize = size
+}
+
+// ModifyRequest patches the JSON body of req.
+func (m *JSONPatchModifier) ModifyRequest(req *http.Request) error {
+	return modifyJSON(req.Header, &req.Body, &req.ContentLength, m.maxSize, m.apply)
+}
+
+// ModifyResponse patches the JSON body of res.
+func (m *JSONPatchModifier) ModifyResponse(res *http.Response) error {
+	return modifyJSON(res.Header, &res.Body, &res.ContentLength, m.maxSize, m.apply)
+}
+
+func (m *JSONPatchModifier) apply(doc any) (any, error) {
+	var err error
+	for i, op := range m.ops {
+		if doc, err = op.apply(doc); err != nil {
+			return nil, fmt.Errorf("body: JSON patch operation %d (%s %s): %v", i, op.op, formatPointer(op.path), err)
+		}
+	}
+
+	return doc, nil
+}
+
+func (op patchOp) apply(doc any) (any, error) {
+	switch op.op {
+	case "add":
+		return addJSON(doc, op.path, cloneJSON(op.value))
+	case "remove":
+		doc, _, err := removeJSON(doc, op.path)
+		return doc, err
+	case "replace":
+		return replaceJSON(doc, op.path, cloneJSON(op.value))
+	case "move":
+		doc, v, err := removeJSON(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return addJSON(doc, op.path, v)
+	case "copy":
+		v, err := getJSON(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return addJSON(doc, op.path, cloneJSON(v))
+	case "test":
+		v, err := getJSON(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		if !equalJSON(v, op.value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("unknown op %q", op.op)
+}
+
+// parsePointer parses a JSON Pointer (RFC 6901) into its reference tokens.
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", s)
+	}
+
+	toks := strings.Split(s[1:], "/")
+	for i, tok := range toks {
+		toks[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+	}
+
+	return toks, nil
+}
+
+func formatPointer(toks []string) string {
+	var sb strings.Builder
+	for _, tok := range toks {
+		sb.WriteByte('/')
+		sb.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(tok))
+	}
+
+	return sb.String()
+}
+
+func isPrefix(prefix, toks []string) bool {
+	for i := range prefix {
+		if prefix[i] != toks[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// arrayIndex parses tok as an index of an array of length n. If end is set,
+// the index may be n, which "-" also refers to.
+func arrayIndex(tok string, n int, end bool) (int, error) {
+	if tok == "-" && end {
+		return n, nil
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') || strings.TrimLeft(tok, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+
+	i, err := strconv.Atoi(tok)
+	if err != nil || i > n || (i == n && !end) {
+		return 0, fmt.Errorf("array index %q out of bounds", tok)
+	}
+
+	return i, nil
+}
+
+// getJSON returns the value at path in doc.
+func getJSON(doc any, path []string) (any, error) {
+	v := doc
+	for _, tok := range path {
+		switch c := v.(type) {
+		case *jsonObject:
+			var ok bool
+			if v, ok = c.get(tok); !ok {
+				return nil, fmt.Errorf("member %q not found", tok)
+			}
+		case *jsonArray:
+			i, err := arrayIndex(tok, len(c.vals), false)
+			if err != nil {
+				return nil, err
+			}
+			v = c.vals[i]
+		default:
+			return nil, fmt.Errorf("cannot traverse %q of a scalar", tok)
+		}
+	}
+
+	return v, nil
+}
+
+// addJSON adds v at path in doc as the "add" operation does, and returns the
+// resulting document.
+func addJSON(doc any, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+
+	parent, err := getJSON(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	tok := path[len(path)-1]
+	switch c := parent.(type) {
+	case *jsonObject:
+		c.set(tok, v)
+	case *jsonArray:
+		i, err := arrayIndex(tok, len(c.vals), true)
+		if err != nil {
+			return nil, err
+		}
+		c.vals = append(c.vals, nil)
+		copy(c.vals[i+1:], c.vals[i:])
+		c.vals[i] = v
+	default:
+		return nil, fmt.Errorf("cannot add %q to a scalar", tok)
+	}
+
+	return doc, nil
+}
+
+// replaceJSON replaces the existing value at path in doc with v, and returns
+// the resulting document. Object members keep their position.
+func replaceJSON(doc any, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+
+	parent, err := getJSON(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	tok := path[len(path)-1]
+	switch c := parent.(type) {
+	case *jsonObject:
+		if _, ok := c.get(tok); !ok {
+			return nil, fmt.Errorf("member %q not found", tok)
+		}
+		c.set(tok, v)
+	case *jsonArray:
+		i, err := arrayIndex(tok, len(c.vals), false)
+		if err != nil {
+			return nil, err
+		}
+		c.vals[i] = v
+	default:
+		return nil, fmt.Errorf("cannot replace %q of a scalar", tok)
+	}
+
+	return doc, nil
+}
+
+// removeJSON removes the value at path in doc, and returns the resulting
+// document and the value.
+func removeJSON(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	parent, err := getJSON(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tok := path[len(path)-1]
+	switch c := parent.(type) {
+	case *jsonObject:
+		v, ok := c.get(tok)
+		if !ok {
+			return nil, nil, fmt.Errorf("member %q not found", tok)
+		}
+		c.del(tok)
+		return doc, v, nil
+	case *jsonArray:
+		i, err := arrayIndex(tok, len(c.vals), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := c.vals[i]
+		c.vals = append(c.vals[:i], c.vals[i+1:]...)
+		return doc, v, nil
+	}
+
+	return nil, nil, fmt.Errorf("cannot remove %q from a scalar", tok)
+}
+
+// jsonPatchModifierFromJSON builds a body.JSONPatch modifier from JSON. The
+// patch is a JSON Patch document.
+//
+// Example JSON:
+//
+//	{
+//	  "body.JSONPatch": {
+//	    "scope": ["response"],
+//	    "patch": [
+//	      { "op": "replace", "path": "/features/beta", "value": true },
+//	      { "op": "remove", "path": "/ads" }
+//	    ],
+//	    "maxBodyBytes": 1048576
+//	  }
+//	}
+func jsonPatchModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &jsonPatchModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod, err := NewJSONPatchModifier(msg.Patch)
+	if err != nil {
+		return nil, err
+	}
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestJSONPatch(t *testing.T) {
+	// The examples of RFC 6902, appendix A.
+	tt := []struct {
+		doc, patch, want string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"foo":"bar","baz":"qux"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"copy","from":"/~1","path":"/a~1b"}]`, `{"/":9,"~1":10,"a/b":9}`},
+		{`{"foo":1}`, `[{"op":"replace","path":"","value":[1e2,"<&>"]}]`, `[1e2,"<&>"]`},
+	}
+
+	for i, tc := range tt {
+		m, err := NewJSONPatchModifier([]byte(tc.patch))
+		if err != nil {
+			t.Fatalf("%d. NewJSONPatchModifier(): got %v, want no error", i, err)
+		}
+
+		res := proxyutil.NewResponse(200, strings.NewReader(tc.doc), nil)
+		res.Header.Set("Content-Type", "application/json; charset=utf-8")
+		if err := m.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		got, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. res.Body: got %s, want %s", i, got, tc.want)
+		}
+		if got, want := res.ContentLength, int64(len(tc.want)); got != want {
+			t.Errorf("%d. res.ContentLength: got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	tt := []struct {
+		doc, patch string
+	}{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{`{"foo":[1]}`, `[{"op":"replace","path":"/foo/1","value":2}]`},
+		{`{"foo":[1]}`, `[{"op":"add","path":"/foo/01","value":2}]`},
+		// The patch is applied atomically.
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":1},{"op":"test","path":"/baz","value":2}]`},
+	}
+
+	for i, tc := range tt {
+		m, err := NewJSONPatchModifier([]byte(tc.patch))
+		if err != nil {
+			t.Fatalf("%d. NewJSONPatchModifier(): got %v, want no error", i, err)
+		}
+
+		res := proxyutil.NewResponse(200, strings.NewReader(tc.doc), nil)
+		res.Header.Set("Content-Type", "application/json")
+		if err := m.ModifyResponse(res); err == nil {
+			t.Errorf("%d. ModifyResponse(): got nil, want error", i)
+		}
+
+		got, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.doc {
+			t.Errorf("%d. res.Body: got %s, want %s", i, got, tc.doc)
+		}
+	}
+
+	for i, patch := range []string{
+		`{"op":"add","path":"/a","value":1}`,
+		`[{"op":"add","path":"/a"}]`,
+		`[{"op":"add","value":1}]`,
+		`[{"op":"add","path":"a","value":1}]`,
+		`[{"op":"move","path":"/a"}]`,
+		`[{"op":"move","from":"/a","path":"/a/b"}]`,
+		`[{"op":"merge","path":"/a"}]`,
+	} {
+		if _, err := NewJSONPatchModifier([]byte(patch)); err == nil {
+			t.Errorf("%d. NewJSONPatchModifier(%s): got nil, want error", i, patch)
+		}
+	}
+}
+
+func TestJSONPatchGuards(t *testing.T) {
+	m, err := NewJSONPatchModifier([]byte(`[{"op":"add","path":"/patched","value":true}]`))
+	if err != nil {
+		t.Fatalf("NewJSONPatchModifier(): got %v, want no error", err)
+	}
+	m.SetMaxBodySize(32)
+
+	gz := func(s string) string {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		gw.Write([]byte(s))
+		gw.Close()
+		return buf.String()
+	}
+
+	tt := []struct {
+		contentType, encoding, body string
+		want                        string
+	}{
+		{"text/plain", "", `{}`, `{}`},
+		{"application/problem+json", "", `{}`, `{"patched":true}`},
+		{"application/json", "br", `{}`, `{}`},
+		{"application/json", "gzip", gz(`{}`), `{"patched":true}`},
+		{"application/json", "", `{"a":"` + strings.Repeat("a", 32) + `"}`, `{"a":"` + strings.Repeat("a", 32) + `"}`},
+		{"application/json", "gzip", gz(`["` + strings.Repeat("a", 64) + `"]`), gz(`["` + strings.Repeat("a", 64) + `"]`)},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("POST", "http://example.com", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		req.Header.Set("Content-Type", tc.contentType)
+		req.Header.Set("Content-Encoding", tc.encoding)
+
+		if err := m.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+
+		got, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. req.Body: got %q, want %q", i, got, tc.want)
+		}
+		if tc.want != tc.body && req.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%d. req.Header.Get(%q): got %q, want no value", i, "Content-Encoding", req.Header.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestJSONPatchModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "body.JSONPatch": {
+      "scope": ["response"],
+      "patch": [
+        { "op": "replace", "path": "/features/beta", "value": true }
+      ]
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("resmod: got nil, want not nil")
+	}
+
+	res := proxyutil.NewResponse(200, strings.NewReader(`{"features":{"beta":false}}`), nil)
+	res.Header.Set("Content-Type", "application/json")
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := `{"features":{"beta":true}}`; string(got) != want {
+		t.Errorf("res.Body: got %s, want %s", got, want)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"body.JSONPatch": {"patch": [{"op": "nope", "path": ""}]}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error")
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("body.JSONPath", jsonPathModifierFromJSON)
+}
+
+// JSONPathModifier sets, replaces or removes the values matched by JSONPath
+// expressions in JSON bodies. It leaves the same bodies unchanged as
+// JSONPatchModifier.
+//
+// Expressions start at the root, "$", followed by members (".name" or
+// "['name']"), array indices ("[0]", "[-1]" for the last element), wildcards
+// (".*" or "[*]") and recursive descents ("..name", "..*").
+type JSONPathModifier struct {
+	rules   []pathRule
+	maxSize int64
+}
+
+// JSONPathOp is the operation of a JSONPath rule.
+type JSONPathOp string
+
+const (
+	// JSONPathSet sets the matched values, creating the members named by the
+	// expression that are missing.
+	JSONPathSet JSONPathOp = "set"
+	// JSONPathReplace replaces the matched values.
+	JSONPathReplace JSONPathOp = "replace"
+	// JSONPathRemove removes the matched values.
+	JSONPathRemove JSONPathOp = "remove"
+)
+
+type pathRule struct {
+	op    JSONPathOp
+	path  []pathSegment
+	value any
+}
+
+type pathSegment struct {
+	// name is the member name, or "" for an index or a wildcard.
+	name      string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+// named returns whether the segment names a single member.
+func (seg pathSegment) named() bool {
+	return !seg.isIndex && !seg.wildcard
+}
+
+type pathRuleJSON struct {
+	Op    JSONPathOp      `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonPathModifierJSON struct {
+	Rules        []pathRuleJSON       `json:"rules"`
+	MaxBodyBytes int64                `json:"maxBodyBytes"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+// NewJSONPathModifier returns a modifier without rules.
+func NewJSONPathModifier() *JSONPathModifier {
+	return &JSONPathModifier{
+		maxSize: DefaultMaxJSONBodySize,
+	}
+}
+
+// AddRule adds a rule applying op to the values matched by path. value is the
+// JSON value set by JSONPathSet and JSONPathReplace. Rules are applied in the
+// order they were added.
+func (m *JSONPathModifier) AddRule(op JSONPathOp, path string, value []byte) error {
+	r := pathRule{op: op}
+
+	var err error
+	if r.path, err = parseJSONPath(path); err != nil {
+		return err
+	}
+
+	switch op {
+	case JSONPathSet, JSONPathReplace:
+		if r.value, err = decodeJSON(value); err != nil {
+			return fmt.Errorf("body: invalid value for %q: %v", path, err)
+		}
+	case JSONPathRemove:
+		if len(r.path) == 0 {
+			return fmt.Errorf("body: cannot remove the root")
+		}
+	default:
+		return fmt.Errorf("body: unknown JSONPath op %q", op)
+	}
+
+	m.rules = append(m.rules, r)
+	return nil
+}
+
+// SetMaxBodySize sets the size above which bodies are left unchanged. It
+// defaults to DefaultMaxJSONBodySize.
+func (m *JSONPathModifier) SetMaxBodySize(size int64) {
+	m.maxSize = size
+}
+
+// ModifyRequest applies the rules to the JSON body of req.
+func (m *JSONPathModifier) ModifyRequest(req *http.Request) error {
+	return modifyJSON(req.Header, &req.Body, &req.ContentLength, m.maxSize, m.apply)
+}
+
+// ModifyResponse applies the rules to the JSON body of res.
+func (m *JSONPathModifier) ModifyResponse(res *http.Response) error {
+	return modifyJSON(res.Header, &res.Body, &res.ContentLength, m.maxSize, m.apply)
+}
+
+func (m *JSONPathModifier) apply(doc any) (any, error) {
+	for _, r := range m.rules {
+		doc = r.apply(doc)
+	}
+
+	return doc, nil
+}
+
+// pathLocation is the location of a value matched by a path: a member of an
+// object, or an element of an array.
+type pathLocation struct {
+	parent any
+	name   string
+	index  int
+}
+
+func (r pathRule) apply(doc any) any {
+	if len(r.path) == 0 {
+		// Rules of the root can only set or replace it.
+		return cloneJSON(r.value)
+	}
+
+	locs := matchJSONPath(doc, r.path, r.op == JSONPathSet)
+
+	// Removing elements in reverse order keeps the indices of the remaining
+	// locations valid.
+	for i := len(locs) - 1; i >= 0; i-- {
+		loc := locs[i]
+		switch p := loc.parent.(type) {
+		case *jsonObject:
+			if r.op == JSONPathRemove {
+				p.del(loc.name)
+				continue
+			}
+			if _, ok := p.get(loc.name); ok || r.op == JSONPathSet {
+				p.set(loc.name, cloneJSON(r.value))
+			}
+		case *jsonArray:
+			if loc.index >= len(p.vals) {
+				continue
+			}
+			if r.op == JSONPathRemove {
+				p.vals = append(p.vals[:loc.index], p.vals[loc.index+1:]...)
+				continue
+			}
+			p.vals[loc.index] = cloneJSON(r.value)
+		}
+	}
+
+	return doc
+}
+
+// matchJSONPath returns the locations in doc matched by path. If create is
+// set, the locations of missing members named by the last segment are
+// included, and missing members followed by a named member are created as
+// empty objects.
+func matchJSONPath(doc any, path []pathSegment, create bool) []pathLocation {
+	var locs []pathLocation
+
+	var walk func(v any, path []pathSegment)
+	walk = func(v any, path []pathSegment) {
+		seg, last := path[0], len(path) == 1
+
+		visit := func(loc pathLocation, child any) {
+			if last {
+				locs = append(locs, loc)
+				return
+			}
+			walk(child, path[1:])
+		}
+
+		switch c := v.(type) {
+		case *jsonObject:
+			switch {
+			case seg.wildcard:
+				for _, k := range c.keys {
+					visit(pathLocation{parent: c, name: k}, c.vals[k])
+				}
+			case seg.named():
+				child, ok := c.get(seg.name)
+				switch {
+				case ok:
+					visit(pathLocation{parent: c, name: seg.name}, child)
+				case create && !seg.recursive && last:
+					visit(pathLocation{parent: c, name: seg.name}, nil)
+				case create && !seg.recursive && path[1].named():
+					child = newJSONObject()
+					c.set(seg.name, child)
+					visit(pathLocation{parent: c, name: seg.name}, child)
+				}
+			}
+		case *jsonArray:
+			switch {
+			case seg.wildcard:
+				for i, e := range c.vals {
+					visit(pathLocation{parent: c, index: i}, e)
+				}
+			case seg.isIndex:
+				i := seg.index
+				if i < 0 {
+					i += len(c.vals)
+				}
+				if i >= 0 && i < len(c.vals) {
+					visit(pathLocation{parent: c, index: i}, c.vals[i])
+				}
+			}
+		}
+
+		if !seg.recursive {
+			return
+		}
+
+		// A recursive segment also applies to all descendants.
+		switch c := v.(type) {
+		case *jsonObject:
+			for _, k := range c.keys {
+				walk(c.vals[k], path)
+			}
+		case *jsonArray:
+			for _, e := range c.vals {
+				walk(e, path)
+			}
+		}
+	}
+	walk(doc, path)
+
+	return locs
+}
+
+// parseJSONPath parses a JSONPath expression.
+func parseJSONPath(s string) ([]pathSegment, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("body: JSONPath %q must start with $", s)
+	}
+
+	var path []pathSegment
+	rest := s[1:]
+	for rest != "" {
+		var seg pathSegment
+		var err error
+
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			seg.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				seg, rest, err = parseBracket(rest, seg)
+			} else {
+				seg, rest, err = parseDotted(rest, seg)
+			}
+		case strings.HasPrefix(rest, "."):
+			seg, rest, err = parseDotted(rest[1:], seg)
+		case strings.HasPrefix(rest, "["):
+			seg, rest, err = parseBracket(rest, seg)
+		default:
+			err = fmt.Errorf("unexpected %q", rest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("body: invalid JSONPath %q: %v", s, err)
+		}
+
+		path = append(path, seg)
+	}
+
+	return path, nil
+}
+
+func parseDotted(s string, seg pathSegment) (pathSegment, string, error) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		end = len(s)
+	}
+
+	name := s[:end]
+	switch name {
+	case "":
+		return seg, "", fmt.Errorf("empty member name")
+	case "*":
+		seg.wildcard = true
+	default:
+		seg.name = name
+	}
+
+	return seg, s[end:], nil
+}
+
+func parseBracket(s string, seg pathSegment) (pathSegment, string, error) {
+	s = s[1:]
+
+	if strings.HasPrefix(s, "'") || strings.HasPrefix(s, `"`) {
+		q := s[0]
+		var name strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if i+1 == len(s) {
+					return seg, "", fmt.Errorf("unterminated name")
+				}
+				i++
+				name.WriteByte(s[i])
+			case q:
+				if !strings.HasPrefix(s[i+1:], "]") {
+					return seg, "", fmt.Errorf("missing ]")
+				}
+				seg.name = name.String()
+				return seg, s[i+2:], nil
+			default:
+				name.WriteByte(s[i])
+			}
+		}
+
+		return seg, "", fmt.Errorf("unterminated name")
+	}
+
+	end := strings.Index(s, "]")
+	if end < 0 {
+		return seg, "", fmt.Errorf("missing ]")
+	}
+
+	if tok := s[:end]; tok == "*" {
+		seg.wildcard = true
+	} else {
+		i, err := strconv.Atoi(tok)
+		if err != nil {
+			return seg, "", fmt.Errorf("invalid index %q", tok)
+		}
+		seg.index, seg.isIndex = i, true
+	}
+
+	return seg, s[end+1:], nil
+}
+
+// jsonPathModifierFromJSON builds a body.JSONPath modifier from JSON. Rules
+// have an op, "set", "replace" or "remove", a JSONPath expression and, unless
+// removing, a value.
+//
+// Example JSON:
+//
+//	{
+//	  "body.JSONPath": {
+//	    "scope": ["response"],
+//	    "rules": [
+//	      { "op": "set", "path": "$.feature_flags.x", "value": true },
+//	      { "op": "remove", "path": "$..tracking" }
+//	    ],
+//	    "maxBodyBytes": 1048576
+//	  }
+//	}
+func jsonPathModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &jsonPathModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewJSONPathModifier()
+	for _, r := range msg.Rules {
+		if err := mod.AddRule(r.Op, r.Path, r.Value); err != nil {
+			return nil, err
+		}
+	}
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestJSONPathModifier(t *testing.T) {
+	tt := []struct {
+		op          JSONPathOp
+		path, value string
+		doc, want   string
+	}{
+		{JSONPathSet, "$.feature_flags.x", "true", `{"feature_flags":{"x":false,"y":1}}`, `{"feature_flags":{"x":true,"y":1}}`},
+		{JSONPathSet, "$.feature_flags.x", "true", `{"a":1}`, `{"a":1,"feature_flags":{"x":true}}`},
+		{JSONPathReplace, "$.feature_flags.x", "true", `{"a":1}`, `{"a":1}`},
+		{JSONPathReplace, "$['a b'].c", `"d"`, `{"a b":{"c":"e"}}`, `{"a b":{"c":"d"}}`},
+		{JSONPathSet, "$.items[*].price", "0", `{"items":[{"price":1},{"price":2}]}`, `{"items":[{"price":0},{"price":0}]}`},
+		{JSONPathReplace, "$.items[-1]", "null", `{"items":[1,2,3]}`, `{"items":[1,2,null]}`},
+		{JSONPathReplace, "$.items[3]", "null", `{"items":[1,2,3]}`, `{"items":[1,2,3]}`},
+		{JSONPathRemove, "$..tracking", "", `{"tracking":1,"a":[{"tracking":2,"b":3}]}`, `{"a":[{"b":3}]}`},
+		{JSONPathRemove, "$.a[*]", "", `{"a":[1,2,3]}`, `{"a":[]}`},
+		{JSONPathRemove, "$.a.*", "", `{"a":{"b":1,"c":2},"d":3}`, `{"a":{},"d":3}`},
+		{JSONPathSet, "$..id", `"x"`, `[{"id":1},{"id":2,"c":{"id":3}}]`, `[{"id":"x"},{"id":"x","c":{"id":"x"}}]`},
+		{JSONPathSet, "$", `{"replaced":true}`, `[1]`, `{"replaced":true}`},
+	}
+
+	for i, tc := range tt {
+		m := NewJSONPathModifier()
+		if err := m.AddRule(tc.op, tc.path, []byte(tc.value)); err != nil {
+			t.Fatalf("%d. AddRule(): got %v, want no error", i, err)
+		}
+
+		res := proxyutil.NewResponse(200, strings.NewReader(tc.doc), nil)
+		res.Header.Set("Content-Type", "application/json")
+		if err := m.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		got, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. res.Body: got %s, want %s", i, got, tc.want)
+		}
+	}
+}
+
+func TestJSONPathModifierAddRuleErrors(t *testing.T) {
+	tt := []struct {
+		op          JSONPathOp
+		path, value string
+	}{
+		{JSONPathSet, "a.b", "1"},
+		{JSONPathSet, "$.", "1"},
+		{JSONPathSet, "$[1", "1"},
+		{JSONPathSet, "$['a", "1"},
+		{JSONPathSet, "$[x]", "1"},
+		{JSONPathSet, "$.a", ""},
+		{JSONPathRemove, "$", ""},
+		{"append", "$.a", "1"},
+	}
+
+	for i, tc := range tt {
+		if err := NewJSONPathModifier().AddRule(tc.op, tc.path, []byte(tc.value)); err == nil {
+			t.Errorf("%d. AddRule(%q, %q): got nil, want error", i, tc.op, tc.path)
+		}
+	}
+}
+
+func TestJSONPathModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "body.JSONPath": {
+      "scope": ["response"],
+      "rules": [
+        { "op": "set", "path": "$.feature_flags.x", "value": true },
+        { "op": "remove", "path": "$..tracking" }
+      ]
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("resmod: got nil, want not nil")
+	}
+
+	res := proxyutil.NewResponse(200, strings.NewReader(`{"tracking":"abc","feature_flags":{}}`), nil)
+	res.Header.Set("Content-Type", "application/json")
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := `{"feature_flags":{"x":true}}`; string(got) != want {
+		t.Errorf("res.Body: got %s, want %s", got, want)
+	}
+}
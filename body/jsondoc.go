@@ -0,0 +1,228 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// JSON documents modified by the JSON body modifiers are decoded into nil,
+// bool, json.Number, string, *jsonArray and *jsonObject values. Objects keep
+// the order of their members, so that the modified documents only differ from
+// the original ones where they were modified.
+
+type jsonObject struct {
+	keys []string
+	vals map[string]any
+}
+
+type jsonArray struct {
+	vals []any
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{
+		vals: make(map[string]any),
+	}
+}
+
+func (o *jsonObject) get(key string) (any, bool) {
+	v, ok := o.vals[key]
+	return v, ok
+}
+
+// set sets the member key to v, in place if it exists and last otherwise.
+func (o *jsonObject) set(key string, v any) {
+	if _, ok := o.vals[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.vals[key] = v
+}
+
+func (o *jsonObject) del(key string) bool {
+	if _, ok := o.vals[key]; !ok {
+		return false
+	}
+
+	delete(o.vals, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
+
+// decodeJSON decodes a single JSON document.
+func decodeJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	v, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("body: invalid JSON: data after document")
+	}
+
+	return v, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		o := newJSONObject()
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			o.set(tok.(string), v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		return o, nil
+	case json.Delim('['):
+		a := &jsonArray{}
+		for dec.More() {
+			v, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			a.vals = append(a.vals, v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		return a, nil
+	}
+
+	return tok, nil
+}
+
+// encodeJSON writes v as compact JSON.
+func encodeJSON(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case *jsonObject:
+		buf.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, v.vals[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case *jsonArray:
+		buf.WriteByte('[')
+		for i, e := range v.vals {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		// Drop the newline written by Encode.
+		buf.Truncate(buf.Len() - 1)
+	}
+
+	return nil
+}
+
+// cloneJSON returns a deep copy of v.
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case *jsonObject:
+		o := newJSONObject()
+		for _, k := range v.keys {
+			o.set(k, cloneJSON(v.vals[k]))
+		}
+		return o
+	case *jsonArray:
+		a := &jsonArray{vals: make([]any, len(v.vals))}
+		for i, e := range v.vals {
+			a.vals[i] = cloneJSON(e)
+		}
+		return a
+	}
+
+	return v
+}
+
+// equalJSON returns whether a and b are equal JSON values. Numbers are equal
+// if their values are, and objects regardless of the order of their members.
+func equalJSON(a, b any) bool {
+	switch a := a.(type) {
+	case *jsonObject:
+		b, ok := b.(*jsonObject)
+		if !ok || len(a.keys) != len(b.keys) {
+			return false
+		}
+		for k, av := range a.vals {
+			bv, ok := b.vals[k]
+			if !ok || !equalJSON(av, bv) {
+				return false
+			}
+		}
+		return true
+	case *jsonArray:
+		b, ok := b.(*jsonArray)
+		if !ok || len(a.vals) != len(b.vals) {
+			return false
+		}
+		for i := range a.vals {
+			if !equalJSON(a.vals[i], b.vals[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, _, aerr := big.ParseFloat(string(a), 10, 256, big.ToNearestEven)
+		bf, _, berr := big.ParseFloat(string(b), 10, 256, big.ToNearestEven)
+		if aerr != nil || berr != nil {
+			return a == b
+		}
+		return af.Cmp(bf) == 0
+	}
+
+	return a == b
+}
@@ -0,0 +1,275 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("body.Multipart", multipartModifierFromJSON)
+}
+
+// DefaultMaxMultipartBodySize is the default size above which
+// MultipartModifier leaves bodies unchanged.
+const DefaultMaxMultipartBodySize = 32 << 20
+
+// MultipartModifier adds, replaces and removes the fields and files of
+// multipart/form-data request bodies, for example to test how uploads with
+// missing or unexpected parts are handled. Operations are applied in the
+// order they were added. The modified body is written with a new boundary and
+// its Content-Length.
+//
+// Bodies that are not multipart/form-data, are encoded, or are larger than
+// the maximum body size are left unchanged.
+type MultipartModifier struct {
+	ops     []multipartOp
+	maxSize int64
+}
+
+type multipartOp struct {
+	remove bool
+	name   string
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+type multipartOpJSON struct {
+	Op          string `json:"op"`
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Content     []byte `json:"content"`
+}
+
+type multipartModifierJSON struct {
+	Ops          []multipartOpJSON    `json:"ops"`
+	MaxBodyBytes int64                `json:"maxBodyBytes"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+type multipartPart struct {
+	name   string
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// NewMultipartModifier returns a MultipartModifier without operations.
+func NewMultipartModifier() *MultipartModifier {
+	return &MultipartModifier{
+		maxSize: DefaultMaxMultipartBodySize,
+	}
+}
+
+// SetMaxBodySize sets the size above which bodies are left unchanged. It
+// defaults to DefaultMaxMultipartBodySize.
+func (m *MultipartModifier) SetMaxBodySize(size int64) {
+	m.maxSize = size
+}
+
+// SetField replaces the parts named name with a field of value, or adds the
+// field if there is no such part.
+func (m *MultipartModifier) SetField(name, value string) {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(name)))
+
+	m.ops = append(m.ops, multipartOp{name: name, header: h, body: []byte(value)})
+}
+
+// SetFile replaces the parts named name with a file named filename of
+// contentType and content, or adds the file if there is no such part. If
+// contentType is empty, application/octet-stream is used.
+func (m *MultipartModifier) SetFile(name, filename, contentType string, content []byte) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(name), quoteEscaper.Replace(filename)))
+	h.Set("Content-Type", contentType)
+
+	m.ops = append(m.ops, multipartOp{name: name, header: h, body: content})
+}
+
+// Remove removes the parts named name.
+func (m *MultipartModifier) Remove(name string) {
+	m.ops = append(m.ops, multipartOp{remove: true, name: name})
+}
+
+// ModifyRequest applies the operations to the multipart/form-data body of
+// req. If the body cannot be parsed, it is left unchanged and the error is
+// returned.
+func (m *MultipartModifier) ModifyRequest(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	mt, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/form-data" || params["boundary"] == "" {
+		return nil
+	}
+	if ce := strings.ToLower(req.Header.Get("Content-Encoding")); ce != "" && ce != "identity" {
+		log.Debugf("body: not modifying multipart body with Content-Encoding %q", ce)
+		return nil
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(req.Body, m.maxSize+1))
+	if err != nil {
+		return err
+	}
+	// restore puts back the body as read so far, followed by the rest.
+	body := req.Body
+	restore := func() {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(raw), body), body}
+	}
+	if int64(len(raw)) > m.maxSize {
+		log.Debugf("body: not modifying multipart body larger than %d bytes", m.maxSize)
+		restore()
+		return nil
+	}
+
+	parts, err := readParts(raw, params["boundary"])
+	if err != nil {
+		restore()
+		return fmt.Errorf("body: invalid multipart body: %v", err)
+	}
+	for _, op := range m.ops {
+		parts = op.apply(parts)
+	}
+
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for _, p := range parts {
+		pw, err := mw.CreatePart(p.header)
+		if err != nil {
+			restore()
+			return err
+		}
+		pw.Write(p.body)
+	}
+	if err := mw.Close(); err != nil {
+		restore()
+		return err
+	}
+
+	body.Close()
+	params["boundary"] = mw.Boundary()
+	req.Header.Set("Content-Type", mime.FormatMediaType(mt, params))
+	req.Header.Del("Content-Length")
+	req.TransferEncoding = nil
+	req.Body = ioutil.NopCloser(buf)
+	req.ContentLength = int64(buf.Len())
+
+	return nil
+}
+
+func (op multipartOp) apply(parts []multipartPart) []multipartPart {
+	out := parts[:0]
+	added := op.remove
+	for _, p := range parts {
+		if p.name != op.name {
+			out = append(out, p)
+			continue
+		}
+		if !added {
+			out = append(out, multipartPart{name: op.name, header: op.header, body: op.body})
+			added = true
+		}
+	}
+	if !added {
+		out = append(out, multipartPart{name: op.name, header: op.header, body: op.body})
+	}
+
+	return out
+}
+
+// readParts returns the parts of the multipart body raw, without decoding
+// them.
+func readParts(raw []byte, boundary string) ([]multipartPart, error) {
+	var parts []multipartPart
+
+	mr := multipart.NewReader(bytes.NewReader(raw), boundary)
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, multipartPart{name: p.FormName(), header: p.Header, body: b})
+	}
+}
+
+// multipartModifierFromJSON builds a body.Multipart modifier from JSON. "ops"
+// are applied in order; each has an "op" of set, file or remove and the
+// "name" of the parts. set replaces the parts with a field of "value"; file
+// replaces them with a file of "filename", "contentType" and the base64
+// encoded "content". "maxBodyBytes" overrides the size above which bodies are
+// left unchanged.
+//
+// Example JSON:
+//
+//	{
+//	  "body.Multipart": {
+//	    "scope": ["request"],
+//	    "ops": [
+//	      {"op": "set", "name": "title", "value": "test upload"},
+//	      {"op": "remove", "name": "csrf_token"},
+//	      {"op": "file", "name": "avatar", "filename": "empty.png", "contentType": "image/png", "content": ""}
+//	    ]
+//	  }
+//	}
+func multipartModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &multipartModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewMultipartModifier()
+	for i, op := range msg.Ops {
+		if op.Name == "" {
+			return nil, fmt.Errorf("body: multipart operation %d: missing name", i)
+		}
+
+		switch op.Op {
+		case "set":
+			mod.SetField(op.Name, op.Value)
+		case "file":
+			mod.SetFile(op.Name, op.Filename, op.ContentType, op.Content)
+		case "remove":
+			mod.Remove(op.Name)
+		default:
+			return nil, fmt.Errorf("body: multipart operation %d: unknown op %q", i, op.Op)
+		}
+	}
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func newMultipartRequest(t *testing.T) *http.Request {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	mw.WriteField("title", "original")
+	mw.WriteField("csrf_token", "secret")
+	fw, err := mw.CreateFormFile("upload", "a.txt")
+	if err != nil {
+		t.Fatalf("mw.CreateFormFile(): got %v, want no error", err)
+	}
+	fw.Write([]byte("file content"))
+	mw.WriteField("tag", "a")
+	mw.WriteField("tag", "b")
+	mw.Close()
+
+	req, err := http.NewRequest("POST", "http://example.com/upload", buf)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return req
+}
+
+func TestMultipartModifier(t *testing.T) {
+	mod := NewMultipartModifier()
+	mod.SetField("title", "replaced")
+	mod.Remove("csrf_token")
+	mod.SetField("tag", "c")
+	mod.SetFile("upload", "b.png", "image/png", []byte("png"))
+	mod.SetField("added", "1")
+
+	req := newMultipartRequest(t)
+	oldType := req.Header.Get("Content-Type")
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got := req.Header.Get("Content-Type"); got == oldType {
+		t.Errorf("Content-Type: got %q, want new boundary", got)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if got, want := req.ContentLength, int64(len(body)); got != want {
+		t.Errorf("req.ContentLength: got %d, want %d", got, want)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatalf("req.ParseMultipartForm(): got %v, want no error", err)
+	}
+
+	for name, want := range map[string]string{
+		"title": "replaced",
+		"tag":   "c",
+		"added": "1",
+	} {
+		if got := req.MultipartForm.Value[name]; len(got) != 1 || got[0] != want {
+			t.Errorf("req.MultipartForm.Value[%q]: got %q, want [%q]", name, got, want)
+		}
+	}
+	if got, ok := req.MultipartForm.Value["csrf_token"]; ok {
+		t.Errorf("req.MultipartForm.Value[%q]: got %q, want none", "csrf_token", got)
+	}
+
+	fhs := req.MultipartForm.File["upload"]
+	if len(fhs) != 1 {
+		t.Fatalf("req.MultipartForm.File[%q]: got %d files, want 1", "upload", len(fhs))
+	}
+	if got, want := fhs[0].Filename, "b.png"; got != want {
+		t.Errorf("Filename: got %q, want %q", got, want)
+	}
+	if got, want := fhs[0].Header.Get("Content-Type"), "image/png"; got != want {
+		t.Errorf("Content-Type: got %q, want %q", got, want)
+	}
+
+	// Parts keep their order, replaced parts take the place of the first one.
+	var names []string
+	for _, line := range strings.Split(string(body), "\r\n") {
+		if strings.HasPrefix(line, "Content-Disposition: form-data; name=") {
+			name := strings.TrimPrefix(line, "Content-Disposition: form-data; name=\"")
+			names = append(names, name[:strings.Index(name, "\"")])
+		}
+	}
+	if got, want := strings.Join(names, ","), "title,upload,tag,added"; got != want {
+		t.Errorf("part names: got %s, want %s", got, want)
+	}
+}
+
+func TestMultipartModifierUnchanged(t *testing.T) {
+	mod := NewMultipartModifier()
+	mod.SetField("title", "replaced")
+
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("title=x"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, _ := ioutil.ReadAll(req.Body); string(got) != "title=x" {
+		t.Errorf("req.Body: got %q, want %q", got, "title=x")
+	}
+
+	// Too large bodies are restored.
+	mod.SetMaxBodySize(10)
+	req = newMultipartRequest(t)
+	want := req.ContentLength
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if int64(len(got)) != want || !bytes.Contains(got, []byte("original")) {
+		t.Errorf("req.Body: got %d bytes, want original %d bytes", len(got), want)
+	}
+
+	// Invalid bodies are restored and the error returned.
+	req, err = http.NewRequest("POST", "http://example.com/", strings.NewReader("--b\r\nbroken"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+	if err := NewMultipartModifier().ModifyRequest(req); err == nil {
+		t.Error("ModifyRequest(): got nil, want error for invalid body")
+	}
+	if got, _ := ioutil.ReadAll(req.Body); string(got) != "--b\r\nbroken" {
+		t.Errorf("req.Body: got %q, want original", got)
+	}
+}
+
+func TestMultipartModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"body.Multipart": {
+			"scope": ["request"],
+			"ops": [
+				{"op": "set", "name": "title", "value": "from json"},
+				{"op": "file", "name": "upload", "filename": "x.bin", "content": "AAEC"}
+			]
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	req := newMultipartRequest(t)
+	if err := r.RequestModifier().ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatalf("req.ParseMultipartForm(): got %v, want no error", err)
+	}
+	if got, want := req.FormValue("title"), "from json"; got != want {
+		t.Errorf("req.FormValue(%q): got %q, want %q", "title", got, want)
+	}
+
+	f, fh, err := req.FormFile("upload")
+	if err != nil {
+		t.Fatalf("req.FormFile(): got %v, want no error", err)
+	}
+	defer f.Close()
+	content, _ := ioutil.ReadAll(f)
+	if !bytes.Equal(content, []byte{0, 1, 2}) || fh.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("upload: got %v of %q, want [0 1 2] of application/octet-stream", content, fh.Header.Get("Content-Type"))
+	}
+
+	for _, msg := range []string{
+		`{"body.Multipart": {"scope": ["request"], "ops": [{"op": "rename", "name": "a"}]}}`,
+		`{"body.Multipart": {"scope": ["request"], "ops": [{"op": "set", "value": "a"}]}}`,
+	} {
+		if _, err := parse.FromJSON([]byte(msg)); err == nil {
+			t.Errorf("parse.FromJSON(%s): got nil, want error", msg)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("body.Replace", replaceModifierFromJSON)
+}
+
+// DefaultMaxMatchSize is the default maximum length of the matches of
+// regular expressions replaced by ReplaceModifier.
+const DefaultMaxMatchSize = 4096
+
+// readSize is the size of the reads from bodies by ReplaceModifier.
+const readSize = 32 << 10
+
+// ReplaceModifier replaces the matches of a regular expression or a literal
+// in bodies as they are streamed, without buffering them.
+//
+// Bodies are searched through a window that retains the last bytes read, so
+// that matches spanning reads are found, up to a maximum length; longer
+// matches may be missed or cut. As the window slides, assertions about the
+// start of the text, such as ^, \A and \b, may also hold at the start of the
+// window. Patterns matching the empty string are not supported.
+//
+// Bodies encoded with gzip are decoded; other encodings are left unchanged,
+// as are partial content responses. Modified bodies are sent unencoded and
+// without a Content-Length, that is chunked.
+type ReplaceModifier struct {
+	re           *regexp.Regexp
+	repl         []byte
+	literal      bool
+	maxMatch     int
+	contentTypes []string
+}
+
+type replaceModifierJSON struct {
+	Regex         string               `json:"regex"`
+	Literal       string               `json:"literal"`
+	Replacement   string               `json:"replacement"`
+	MaxMatchBytes int                  `json:"maxMatchBytes"`
+	ContentTypes  []string             `json:"contentTypes"`
+	Scope         []parse.ModifierType `json:"scope"`
+}
+
+// NewReplaceModifier returns a modifier replacing the matches of re with
+// repl, in which $1 and ${name} are expanded as in regexp.Regexp.Expand.
+func NewReplaceModifier(re *regexp.Regexp, repl string) (*ReplaceModifier, error) {
+	if re.MatchString("") {
+		return nil, fmt.Errorf("body: pattern %q matches the empty string", re)
+	}
+
+	return &ReplaceModifier{
+		re:       re,
+		repl:     []byte(repl),
+		maxMatch: DefaultMaxMatchSize,
+	}, nil
+}
+
+// NewLiteralReplaceModifier returns a modifier replacing old with repl.
+func NewLiteralReplaceModifier(old, repl string) (*ReplaceModifier, error) {
+	if old == "" {
+		return nil, fmt.Errorf("body: empty literal")
+	}
+
+	return &ReplaceModifier{
+		re:       regexp.MustCompile(regexp.QuoteMeta(old)),
+		repl:     []byte(repl),
+		literal:  true,
+		maxMatch: len(old),
+	}, nil
+}
+
+// SetMaxMatchSize sets the maximum length of matches. It defaults to
+// DefaultMaxMatchSize for regular expressions and to the length of literals.
+func (m *ReplaceModifier) SetMaxMatchSize(size int) {
+	m.maxMatch = size
+}
+
+// SetContentTypes restricts the modifier to bodies of the given media types.
+// Types ending with "/" match all their subtypes, as "text/" does. By default
+// all bodies are modified.
+func (m *ReplaceModifier) SetContentTypes(types ...string) {
+	m.contentTypes = types
+}
+
+// ModifyRequest replaces the matches in the body of req.
+func (m *ReplaceModifier) ModifyRequest(req *http.Request) error {
+	return m.modify(req.Header, &req.Body, &req.ContentLength)
+}
+
+// ModifyResponse replaces the matches in the body of res.
+func (m *ReplaceModifier) ModifyResponse(res *http.Response) error {
+	if res.StatusCode == http.StatusPartialContent {
+		return nil
+	}
+
+	return m.modify(res.Header, &res.Body, &res.ContentLength)
+}
+
+func (m *ReplaceModifier) modify(h http.Header, body *io.ReadCloser, cl *int64) error {
+	if *body == nil || *body == http.NoBody || !m.matchesContentType(h.Get("Content-Type")) {
+		return nil
+	}
+
+	var r io.Reader = *body
+	switch ce := strings.ToLower(h.Get("Content-Encoding")); ce {
+	case "", "identity":
+	case "gzip":
+		gr, err := gzip.NewReader(*body)
+		if err != nil {
+			return err
+		}
+		r = gr
+	default:
+		log.Debugf("body: not replacing in body with Content-Encoding %q", ce)
+		return nil
+	}
+
+	h.Del("Content-Encoding")
+	h.Del("Content-Length")
+	*cl = -1
+	*body = struct {
+		io.Reader
+		io.Closer
+	}{m.Reader(r), *body}
+
+	return nil
+}
+
+func (m *ReplaceModifier) matchesContentType(contentType string) bool {
+	if len(m.contentTypes) == 0 {
+		return true
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range m.contentTypes {
+		if mt == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reader returns a reader of r with the matches of the modifier replaced.
+func (m *ReplaceModifier) Reader(r io.Reader) io.Reader {
+	return &replaceReader{
+		m:   m,
+		src: r,
+	}
+}
+
+type replaceReader struct {
+	m   *ReplaceModifier
+	src io.Reader
+	// buf holds the bytes read but not yet searched for matches ending before
+	// the window.
+	buf []byte
+	out bytes.Buffer
+	err error
+}
+
+func (r *replaceReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+
+	return r.out.Read(p)
+}
+
+// fill reads from src and writes to out the bytes that can no longer be part
+// of a match ending after the window.
+func (r *replaceReader) fill() {
+	n := len(r.buf)
+	r.buf = append(r.buf, make([]byte, readSize)...)
+	read, err := r.src.Read(r.buf[n:])
+	r.buf = r.buf[:n+read]
+	if err != nil && err != io.EOF {
+		r.err = err
+		return
+	}
+
+	final := err == io.EOF
+	// Matches ending within the window may be longer with more bytes, and
+	// are left for the next fill. Matches longer than the window are
+	// replaced as they are, which bounds the bytes retained.
+	limit := len(r.buf) - r.m.maxMatch
+	cut := limit
+	if final {
+		cut = len(r.buf)
+	}
+
+	pos := 0
+	for _, loc := range r.m.re.FindAllSubmatchIndex(r.buf, -1) {
+		if !final && loc[1] >= limit && loc[1]-loc[0] <= r.m.maxMatch {
+			if loc[0] < cut {
+				cut = loc[0]
+			}
+			break
+		}
+
+		r.out.Write(r.buf[pos:loc[0]])
+		if r.m.literal {
+			r.out.Write(r.m.repl)
+		} else {
+			r.out.Write(r.m.re.Expand(nil, r.m.repl, r.buf, loc))
+		}
+		pos = loc[1]
+	}
+
+	if pos < cut {
+		r.out.Write(r.buf[pos:cut])
+		pos = cut
+	}
+	r.buf = append(r.buf[:0], r.buf[pos:]...)
+
+	if final {
+		r.err = io.EOF
+	}
+}
+
+// replaceModifierFromJSON builds a body.Replace modifier from JSON, with
+// either a regular expression or a literal. Content types are as described by
+// SetContentTypes.
+//
+// Example JSON:
+//
+//	{
+//	  "body.Replace": {
+//	    "scope": ["response"],
+//	    "regex": "https://cdn\\.example\\.com/(\\w+)",
+//	    "replacement": "https://staging.example.com/$1",
+//	    "maxMatchBytes": 256,
+//	    "contentTypes": ["text/", "application/javascript"]
+//	  }
+//	}
+func replaceModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &replaceModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	var mod *ReplaceModifier
+	switch {
+	case msg.Regex != "" && msg.Literal != "":
+		return nil, fmt.Errorf("body: both regex and literal are set")
+	case msg.Literal != "":
+		var err error
+		if mod, err = NewLiteralReplaceModifier(msg.Literal, msg.Replacement); err != nil {
+			return nil, err
+		}
+	default:
+		re, err := regexp.Compile(msg.Regex)
+		if err != nil {
+			return nil, err
+		}
+		if mod, err = NewReplaceModifier(re, msg.Replacement); err != nil {
+			return nil, err
+		}
+	}
+
+	if msg.MaxMatchBytes > 0 {
+		mod.SetMaxMatchSize(msg.MaxMatchBytes)
+	}
+	mod.SetContentTypes(msg.ContentTypes...)
+
+	return parse.NewResult(mod, msg.Scope)
+}
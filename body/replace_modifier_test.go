@@ -0,0 +1,194 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package body
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestReplaceReader(t *testing.T) {
+	long := strings.Repeat("lorem ipsum ", 10000)
+
+	tt := []struct {
+		regex, literal, repl string
+		in, want             string
+	}{
+		{"", "foo", "bar", "foo food fo", "bar bard fo"},
+		{"", "foo", "$1", "foo", "$1"},
+		{`cdn\.example\.com/(\w+)`, "", "static.example.com/v2/$1", "<img src=//cdn.example.com/a.png>", "<img src=//static.example.com/v2/a.png>"},
+		{`(?P<n>\d+)px`, "", "${n}em", "1px 20px", "1em 20em"},
+		{"ab+", "", "X", "abbbb ab a b", "X X a b"},
+		{"needle", "", "pin", long + "needle" + long + "needle", long + "pin" + long + "pin"},
+		{"", "ipsum", "dolor", long, strings.Replace(long, "ipsum", "dolor", -1)},
+	}
+
+	for i, tc := range tt {
+		var m *ReplaceModifier
+		var err error
+		if tc.literal != "" {
+			m, err = NewLiteralReplaceModifier(tc.literal, tc.repl)
+		} else {
+			m, err = NewReplaceModifier(regexp.MustCompile(tc.regex), tc.repl)
+		}
+		if err != nil {
+			t.Fatalf("%d. NewReplaceModifier(): got %v, want no error", i, err)
+		}
+
+		// Reading one byte at a time splits every match across reads.
+		got, err := ioutil.ReadAll(m.Reader(iotest.OneByteReader(strings.NewReader(tc.in))))
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. one byte reads: got %.100q, want %.100q", i, got, tc.want)
+		}
+
+		got, err = ioutil.ReadAll(m.Reader(strings.NewReader(tc.in)))
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. reads: got %.100q, want %.100q", i, got, tc.want)
+		}
+	}
+}
+
+func TestReplaceReaderLongMatches(t *testing.T) {
+	m, err := NewReplaceModifier(regexp.MustCompile("a+"), "a")
+	if err != nil {
+		t.Fatalf("NewReplaceModifier(): got %v, want no error", err)
+	}
+	m.SetMaxMatchSize(8)
+
+	r := m.Reader(strings.NewReader(strings.Repeat("a", 1<<20))).(*replaceReader)
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	// The match is cut at reads, but the retained bytes stay bounded.
+	if len(got) == 0 || len(got) > (1<<20)/readSize+1 {
+		t.Errorf("len(got): got %d, want between 1 and %d", len(got), (1<<20)/readSize+1)
+	}
+	if cap(r.buf) > 2*readSize+16 {
+		t.Errorf("cap(r.buf): got %d, want at most %d", cap(r.buf), 2*readSize+16)
+	}
+}
+
+func TestReplaceModifier(t *testing.T) {
+	m, err := NewLiteralReplaceModifier("prod.example.com", "staging.example.com")
+	if err != nil {
+		t.Fatalf("NewLiteralReplaceModifier(): got %v, want no error", err)
+	}
+	m.SetContentTypes("text/", "application/javascript")
+
+	gz := func(s string) []byte {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		gw.Write([]byte(s))
+		gw.Close()
+		return buf.Bytes()
+	}
+
+	body := `fetch("https://prod.example.com/api")`
+	tt := []struct {
+		status      int
+		contentType string
+		encoding    string
+		body        []byte
+		want        string
+	}{
+		{200, "application/javascript", "", []byte(body), `fetch("https://staging.example.com/api")`},
+		{200, "text/html; charset=utf-8", "gzip", gz(body), `fetch("https://staging.example.com/api")`},
+		{200, "image/png", "", []byte(body), body},
+		{200, "text/plain", "br", []byte(body), body},
+		{206, "text/plain", "", []byte(body), body},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		res := proxyutil.NewResponse(tc.status, bytes.NewReader(tc.body), req)
+		res.Header.Set("Content-Type", tc.contentType)
+		res.Header.Set("Content-Encoding", tc.encoding)
+		res.ContentLength = int64(len(tc.body))
+
+		if err := m.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		got, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. res.Body: got %q, want %q", i, got, tc.want)
+		}
+
+		modified := tc.want != body
+		if got := res.ContentLength == -1; got != modified {
+			t.Errorf("%d. res.ContentLength: got %d, want -1 iff modified", i, res.ContentLength)
+		}
+		if got := res.Header.Get("Content-Encoding") == ""; modified && !got {
+			t.Errorf("%d. res.Header.Get(%q): got %q, want no value", i, "Content-Encoding", res.Header.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestReplaceModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "body.Replace": {
+      "scope": ["request"],
+      "regex": "secret=\\w+",
+      "replacement": "secret=redacted",
+      "maxMatchBytes": 64
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com", strings.NewReader("a=1&secret=hunter2"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "a=1&secret=redacted"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+
+	for i, msg := range []string{
+		`{"body.Replace": {"regex": "x*"}}`,
+		`{"body.Replace": {"regex": "("}}`,
+		`{"body.Replace": {"regex": "a", "literal": "b"}}`,
+	} {
+		if _, err := parse.FromJSON([]byte(msg)); err == nil {
+			t.Errorf("%d. parse.FromJSON(%s): got nil, want error", i, msg)
+		}
+	}
+}
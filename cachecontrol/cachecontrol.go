@@ -0,0 +1,160 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package cachecontrol provides a modifier that overrides the caching headers
+// of responses.
+package cachecontrol
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("cachecontrol.Modifier", modifierFromJSON)
+}
+
+// Modifier is a response modifier that overrides the caching headers of
+// responses, for example to disable caching during test runs, or to make
+// responses cacheable for a long time to test the cache behavior of clients.
+type Modifier struct {
+	url *regexp.Regexp
+
+	cacheControl    string
+	expires         *time.Duration
+	stripExpires    bool
+	stripValidators bool
+
+	now func() time.Time
+}
+
+type modifierJSON struct {
+	URL             string               `json:"url"`
+	CacheControl    string               `json:"cacheControl"`
+	ExpiresSeconds  *int64               `json:"expiresSeconds"`
+	StripExpires    bool                 `json:"stripExpires"`
+	StripValidators bool                 `json:"stripValidators"`
+	Scope           []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a Modifier that leaves the caching headers unchanged.
+func NewModifier() *Modifier {
+	return &Modifier{now: time.Now}
+}
+
+// NewNoStoreModifier returns a Modifier that disables caching: it sets
+// Cache-Control to no-store and strips Expires, ETag and Last-Modified.
+func NewNoStoreModifier() *Modifier {
+	m := NewModifier()
+	m.SetCacheControl("no-store")
+	m.SetStripExpires(true)
+	m.SetStripValidators(true)
+	return m
+}
+
+// NewMaxAgeModifier returns a Modifier that makes responses cacheable for
+// maxAge: it sets Cache-Control to "public, max-age" and Expires accordingly.
+func NewMaxAgeModifier(maxAge time.Duration) *Modifier {
+	m := NewModifier()
+	m.SetCacheControl(fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second)))
+	m.SetExpires(maxAge)
+	return m
+}
+
+// SetURL restricts the modifier to responses to requests whose URL matches
+// re.
+func (m *Modifier) SetURL(re *regexp.Regexp) {
+	m.url = re
+}
+
+// SetCacheControl sets the Cache-Control header to cacheControl, replacing
+// that of the origin. Pragma is removed, as it is superseded.
+func (m *Modifier) SetCacheControl(cacheControl string) {
+	m.cacheControl = cacheControl
+}
+
+// SetExpires sets the Expires header to d after the time of the response.
+func (m *Modifier) SetExpires(d time.Duration) {
+	m.expires = &d
+}
+
+// SetStripExpires sets whether the Expires header is removed.
+func (m *Modifier) SetStripExpires(strip bool) {
+	m.stripExpires = strip
+}
+
+// SetStripValidators sets whether the ETag and Last-Modified headers are
+// removed, so that clients cannot revalidate responses.
+func (m *Modifier) SetStripValidators(strip bool) {
+	m.stripValidators = strip
+}
+
+// ModifyResponse overrides the caching headers of res.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if m.url != nil && (res.Request == nil || !m.url.MatchString(res.Request.URL.String())) {
+		return nil
+	}
+
+	h := res.Header
+	if m.cacheControl != "" {
+		h.Set("Cache-Control", m.cacheControl)
+		h.Del("Pragma")
+	}
+	switch {
+	case m.expires != nil:
+		h.Set("Expires", m.now().Add(*m.expires).UTC().Format(http.TimeFormat))
+	case m.stripExpires:
+		h.Del("Expires")
+	}
+	if m.stripValidators {
+		h.Del("ETag")
+		h.Del("Last-Modified")
+	}
+
+	return nil
+}
+
+// modifierFromJSON builds a cachecontrol.Modifier from JSON. "url" is a
+// regular expression restricting the modifier to some URLs. "cacheControl"
+// replaces the Cache-Control header, "expiresSeconds" sets the Expires header
+// relative to the time of the response, "stripExpires" removes it and
+// "stripValidators" removes the ETag and Last-Modified headers.
+//
+// Example JSON:
+//
+//	{
+//	  "cachecontrol.Modifier": {
+//	    "scope": ["response"],
+//	    "url": "^https://static\\.example\\.com/",
+//	    "cacheControl": "no-store",
+//	    "stripExpires": true,
+//	    "stripValidators": true
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewModifier()
+	if msg.URL != "" {
+		re, err := regexp.Compile(msg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("cachecontrol: invalid url %q: %w", msg.URL, err)
+		}
+		mod.SetURL(re)
+	}
+	mod.SetCacheControl(msg.CacheControl)
+	if msg.ExpiresSeconds != nil {
+		mod.SetExpires(time.Duration(*msg.ExpiresSeconds) * time.Second)
+	}
+	mod.SetStripExpires(msg.StripExpires)
+	mod.SetStripValidators(msg.StripValidators)
+
+	return parse.NewResult(mod, msg.Scope)
+}
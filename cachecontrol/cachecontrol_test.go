@@ -0,0 +1,166 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package cachecontrol
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+var now = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// header is the header of the responses from the origin.
+var header = http.Header{
+	"Cache-Control": {"private, max-age=60"},
+	"Pragma":        {"no-cache"},
+	"Expires":       {"Mon, 01 May 2023 12:01:00 GMT"},
+	"Etag":          {`"v1"`},
+	"Last-Modified": {"Mon, 01 May 2023 11:00:00 GMT"},
+}
+
+func TestModifier(t *testing.T) {
+	tt := []struct {
+		name string
+		mod  *Modifier
+		want map[string]string
+	}{
+		{
+			name: "unchanged",
+			mod:  NewModifier(),
+			want: map[string]string{
+				"Cache-Control": "private, max-age=60",
+				"Pragma":        "no-cache",
+				"Expires":       "Mon, 01 May 2023 12:01:00 GMT",
+				"ETag":          `"v1"`,
+			},
+		},
+		{
+			name: "no-store",
+			mod:  NewNoStoreModifier(),
+			want: map[string]string{
+				"Cache-Control": "no-store",
+				"Pragma":        "",
+				"Expires":       "",
+				"ETag":          "",
+				"Last-Modified": "",
+			},
+		},
+		{
+			name: "max-age",
+			mod:  NewMaxAgeModifier(24 * time.Hour),
+			want: map[string]string{
+				"Cache-Control": "public, max-age=86400",
+				"Expires":       "Tue, 02 May 2023 12:00:00 GMT",
+				"ETag":          `"v1"`,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.mod.now = func() time.Time { return now }
+
+			req, err := http.NewRequest("GET", "http://example.com/", nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			res := proxyutil.NewResponse(200, nil, req)
+			res.Header = header.Clone()
+
+			if err := tc.mod.ModifyResponse(res); err != nil {
+				t.Fatalf("ModifyResponse(): got %v, want no error", err)
+			}
+			for name, want := range tc.want {
+				if got := res.Header.Get(name); got != want {
+					t.Errorf("res.Header.Get(%q): got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestModifierURL(t *testing.T) {
+	mod := NewNoStoreModifier()
+	mod.SetURL(regexp.MustCompile(`^https://static\.example\.com/`))
+
+	req, err := http.NewRequest("GET", "https://www.example.com/app.js", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+	res.Header = header.Clone()
+
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Cache-Control"), "private, max-age=60"; got != want {
+		t.Errorf("Cache-Control: got %q, want %q", got, want)
+	}
+
+	req, err = http.NewRequest("GET", "https://static.example.com/app.js", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res = proxyutil.NewResponse(200, nil, req)
+	res.Header = header.Clone()
+
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Cache-Control"), "no-store"; got != want {
+		t.Errorf("Cache-Control: got %q, want %q", got, want)
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"cachecontrol.Modifier": {
+			"scope": ["response"],
+			"url": "example\\.com",
+			"cacheControl": "max-age=0, must-revalidate",
+			"expiresSeconds": 0,
+			"stripValidators": true
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	mod, ok := r.ResponseModifier().(*Modifier)
+	if !ok {
+		t.Fatalf("r.ResponseModifier(): got %T, want *Modifier", r.ResponseModifier())
+	}
+	mod.now = func() time.Time { return now }
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+	res.Header = header.Clone()
+
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	for name, want := range map[string]string{
+		"Cache-Control": "max-age=0, must-revalidate",
+		"Expires":       "Mon, 01 May 2023 12:00:00 GMT",
+		"ETag":          "",
+		"Last-Modified": "",
+	} {
+		if got := res.Header.Get(name); got != want {
+			t.Errorf("res.Header.Get(%q): got %q, want %q", name, got, want)
+		}
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"cachecontrol.Modifier": {"scope": ["response"], "url": "("}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for invalid url")
+	}
+}
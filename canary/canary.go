@@ -0,0 +1,253 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package canary provides a filter and a matcher that select a percentage of
+// requests, for canary fault injection and A/B testing of responses.
+package canary
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/filter"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("canary.Filter", filterFromJSON)
+	filter.RegisterCondition("canary", matcherFromJSON)
+}
+
+// Matcher is a conditional evaluator that matches a percentage of requests,
+// to be used in filters that take conditionals.
+//
+// By default requests are selected at random. With a key, they are selected
+// by a hash of a value of the request, so that all requests sharing the value
+// are either selected or not. Keys are:
+//
+//	session       the proxy session, that is the client connection
+//	clientip      the client IP address
+//	header:NAME   the value of the NAME request header
+//	cookie:NAME   the value of the NAME cookie
+//
+// Requests lacking the value of the key are selected at random.
+//
+// A response matches iff its request did.
+type Matcher struct {
+	percent float64
+	key     string
+	salt    string
+	ctxkey  string
+}
+
+type matcherJSON struct {
+	Percent float64 `json:"percent"`
+	Key     string  `json:"key"`
+	Salt    string  `json:"salt"`
+}
+
+// NewMatcher returns a matcher selecting percent, from 0 to 100, of requests
+// by key, or at random if key is empty.
+func NewMatcher(percent float64, key string) (*Matcher, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("canary: percent must be between 0 and 100, got %v", percent)
+	}
+
+	switch {
+	case key == "", key == "session", key == "clientip":
+	case strings.HasPrefix(key, "header:") && len(key) > len("header:"):
+	case strings.HasPrefix(key, "cookie:") && len(key) > len("cookie:"):
+	default:
+		return nil, fmt.Errorf("canary: invalid key %q", key)
+	}
+
+	m := &Matcher{
+		percent: percent,
+		key:     key,
+	}
+	m.ctxkey = fmt.Sprintf("canary.Matcher.%p", m)
+
+	return m, nil
+}
+
+// SetSalt sets a salt added to the values of keys before hashing them, so
+// that matchers with the same percentage and key can select different
+// requests.
+func (m *Matcher) SetSalt(salt string) {
+	m.salt = salt
+}
+
+// MatchRequest returns whether req is selected.
+func (m *Matcher) MatchRequest(req *http.Request) bool {
+	ctx := martian.NewContext(req)
+	if ctx != nil {
+		if v, ok := ctx.Get(m.ctxkey); ok {
+			return v.(bool)
+		}
+	}
+
+	matched := m.selects(req)
+	if matched {
+		log.Debugf("canary.Matcher.MatchRequest: selected %s", req.URL)
+	}
+	if ctx != nil {
+		ctx.Set(m.ctxkey, matched)
+	}
+
+	return matched
+}
+
+// MatchResponse returns whether res.Request was selected.
+func (m *Matcher) MatchResponse(res *http.Response) bool {
+	if res.Request == nil {
+		return false
+	}
+
+	return m.MatchRequest(res.Request)
+}
+
+func (m *Matcher) selects(req *http.Request) bool {
+	v, ok := m.value(req)
+	if !ok {
+		return rand.Float64()*100 < m.percent
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(m.salt))
+	h.Write([]byte{0})
+	h.Write([]byte(v))
+
+	return float64(h.Sum64()%10000) < m.percent*100
+}
+
+// value returns the value of the key of the matcher for req.
+func (m *Matcher) value(req *http.Request) (string, bool) {
+	switch {
+	case m.key == "session":
+		ctx := martian.NewContext(req)
+		if ctx == nil {
+			return "", false
+		}
+		return ctx.Session().ID(), true
+	case m.key == "clientip":
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		return ip, ip != ""
+	case strings.HasPrefix(m.key, "header:"):
+		v := req.Header.Get(strings.TrimPrefix(m.key, "header:"))
+		return v, v != ""
+	case strings.HasPrefix(m.key, "cookie:"):
+		c, err := req.Cookie(strings.TrimPrefix(m.key, "cookie:"))
+		if err != nil {
+			return "", false
+		}
+		return c.Value, true
+	}
+
+	return "", false
+}
+
+// matcherFromJSON builds a canary.Matcher condition from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "percent": 5,
+//	  "key": "cookie:session",
+//	  "salt": "checkout-errors"
+//	}
+func matcherFromJSON(b []byte) (filter.Condition, error) {
+	msg := &matcherJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return newMatcher(msg)
+}
+
+func newMatcher(msg *matcherJSON) (*Matcher, error) {
+	m, err := NewMatcher(msg.Percent, msg.Key)
+	if err != nil {
+		return nil, err
+	}
+	m.SetSalt(msg.Salt)
+
+	return m, nil
+}
+
+// Filter runs modifiers iff a Matcher selects the request.
+type Filter struct {
+	*filter.Filter
+}
+
+type filterJSON struct {
+	matcherJSON
+	Modifier     json.RawMessage      `json:"modifier"`
+	ElseModifier json.RawMessage      `json:"else"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+// NewFilter builds a filter that runs modifiers iff m selects the request.
+func NewFilter(m *Matcher) *Filter {
+	f := filter.New()
+	f.SetRequestCondition(m)
+	f.SetResponseCondition(m)
+	return &Filter{f}
+}
+
+// filterFromJSON builds a canary.Filter from JSON, with the fields of the
+// matcher as described by matcherFromJSON.
+//
+// Example JSON:
+//
+//	{
+//	  "canary.Filter": {
+//	    "scope": ["request", "response"],
+//	    "percent": 10,
+//	    "key": "header:X-User-ID",
+//	    "modifier": { ... },
+//	    "else": { ... }
+//	  }
+//	}
+func filterFromJSON(b []byte) (*parse.Result, error) {
+	msg := &filterJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	m, err := newMatcher(&msg.matcherJSON)
+	if err != nil {
+		return nil, err
+	}
+	filter := NewFilter(m)
+
+	r, err := parse.FromJSON(msg.Modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.RequestWhenTrue(r.RequestModifier())
+	filter.ResponseWhenTrue(r.ResponseModifier())
+
+	if len(msg.ElseModifier) > 0 {
+		em, err := parse.FromJSON(msg.ElseModifier)
+		if err != nil {
+			return nil, err
+		}
+
+		if em != nil {
+			filter.RequestWhenFalse(em.RequestModifier())
+			filter.ResponseWhenFalse(em.ResponseModifier())
+		}
+	}
+
+	return parse.NewResult(filter, msg.Scope)
+}
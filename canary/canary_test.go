@@ -0,0 +1,168 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package canary
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+
+	// Import to register header.Modifier with JSON parser.
+	_ "github.com/google/martian/v3/header"
+)
+
+func TestMatcherPercentage(t *testing.T) {
+	tt := []struct {
+		percent  float64
+		key      string
+		min, max int
+	}{
+		{0, "", 0, 0},
+		{100, "", 1000, 1000},
+		{50, "", 400, 600},
+		{0, "header:X-User", 0, 0},
+		{100, "header:X-User", 1000, 1000},
+		{20, "header:X-User", 120, 280},
+	}
+
+	for i, tc := range tt {
+		m, err := NewMatcher(tc.percent, tc.key)
+		if err != nil {
+			t.Fatalf("%d. NewMatcher(): got %v, want no error", i, err)
+		}
+
+		var n int
+		for j := 0; j < 1000; j++ {
+			req, err := http.NewRequest("GET", "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+			}
+			req.Header.Set("X-User", fmt.Sprintf("user-%d", j))
+
+			if m.MatchRequest(req) {
+				n++
+			}
+		}
+		if n < tc.min || n > tc.max {
+			t.Errorf("%d. selected requests: got %d, want between %d and %d", i, n, tc.min, tc.max)
+		}
+	}
+}
+
+func TestMatcherDeterministic(t *testing.T) {
+	m, err := NewMatcher(50, "cookie:session")
+	if err != nil {
+		t.Fatalf("NewMatcher(): got %v, want no error", err)
+	}
+	salted, err := NewMatcher(50, "cookie:session")
+	if err != nil {
+		t.Fatalf("NewMatcher(): got %v, want no error", err)
+	}
+	salted.SetSalt("other")
+
+	var differ bool
+	for i := 0; i < 100; i++ {
+		var got []bool
+		for j := 0; j < 3; j++ {
+			req, err := http.NewRequest("GET", "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			req.AddCookie(&http.Cookie{Name: "session", Value: fmt.Sprint(i)})
+			got = append(got, m.MatchRequest(req))
+
+			if j == 0 && salted.MatchRequest(req) != got[0] {
+				differ = true
+			}
+		}
+
+		if got[0] != got[1] || got[0] != got[2] {
+			t.Errorf("%d. m.MatchRequest(): got %v, want the same results", i, got)
+		}
+	}
+	if !differ {
+		t.Error("salted.MatchRequest(): got the same results as unsalted matcher, want different")
+	}
+}
+
+func TestMatcherResponseFollowsRequest(t *testing.T) {
+	m, err := NewMatcher(50, "")
+	if err != nil {
+		t.Fatalf("NewMatcher(): got %v, want no error", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		martian.TestContext(req, nil, nil)
+
+		want := m.MatchRequest(req)
+		if got := m.MatchResponse(proxyutil.NewResponse(200, nil, req)); got != want {
+			t.Fatalf("%d. m.MatchResponse(): got %t, want %t", i, got, want)
+		}
+	}
+}
+
+func TestNewMatcherErrors(t *testing.T) {
+	tt := []struct {
+		percent float64
+		key     string
+	}{
+		{-1, ""},
+		{101, ""},
+		{10, "header:"},
+		{10, "query:q"},
+	}
+
+	for i, tc := range tt {
+		if _, err := NewMatcher(tc.percent, tc.key); err == nil {
+			t.Errorf("%d. NewMatcher(%v, %q): got nil, want error", i, tc.percent, tc.key)
+		}
+	}
+}
+
+func TestFilterFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "canary.Filter": {
+      "scope": ["request"],
+      "percent": 100,
+      "key": "clientip",
+      "modifier": {
+        "header.Modifier": {
+          "scope": ["request"],
+          "name": "X-Canary",
+          "value": "true"
+        }
+      }
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Canary"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Canary", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package certauth provides a martian.Modifier that sets auth based on the
+// verified TLS client certificate of the session.
+package certauth
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/auth"
+)
+
+var noop = martian.Noop("certauth.Modifier")
+
+// Modifier is the client certificate authentication modifier.
+type Modifier struct {
+	reqmod martian.RequestModifier
+	resmod martian.ResponseModifier
+	id     func(*x509.Certificate) string
+}
+
+// NewModifier returns a new client certificate authentication modifier that
+// uses the subject common name of the certificate as auth ID.
+func NewModifier() *Modifier {
+	return &Modifier{
+		reqmod: noop,
+		resmod: noop,
+		id:     commonName,
+	}
+}
+
+func commonName(cert *x509.Certificate) string {
+	if cn := cert.Subject.CommonName; cn != "" {
+		return cn
+	}
+	return cert.Subject.String()
+}
+
+// SetIDFunc sets the function used to derive the auth ID from the client
+// certificate.
+func (m *Modifier) SetIDFunc(id func(*x509.Certificate) string) {
+	if id == nil {
+		id = commonName
+	}
+
+	m.id = id
+}
+
+// SetRequestModifier sets the request modifier.
+func (m *Modifier) SetRequestModifier(reqmod martian.RequestModifier) {
+	if reqmod == nil {
+		reqmod = noop
+	}
+
+	m.reqmod = reqmod
+}
+
+// SetResponseModifier sets the response modifier.
+func (m *Modifier) SetResponseModifier(resmod martian.ResponseModifier) {
+	if resmod == nil {
+		resmod = noop
+	}
+
+	m.resmod = resmod
+}
+
+// ModifyRequest sets the auth ID in the context from the verified client
+// certificate of the session, if any, and runs reqmod.ModifyRequest. If the
+// underlying modifier has indicated via auth error that no valid auth
+// credentials have been found we set ctx.SkipRoundTrip.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	ctx := martian.NewContext(req)
+	actx := auth.FromContext(ctx)
+
+	if cert := ctx.Session().ClientCertificate(); cert != nil {
+		actx.SetID(m.id(cert))
+	}
+
+	err := m.reqmod.ModifyRequest(req)
+
+	if actx.Error() != nil {
+		ctx.SkipRoundTrip()
+	}
+
+	return err
+}
+
+// ModifyResponse runs resmod.ModifyResponse.
+//
+// If an error is returned from resmod.ModifyResponse it is returned.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	ctx := martian.NewContext(res.Request)
+	actx := auth.FromContext(ctx)
+
+	err := m.resmod.ModifyResponse(res)
+
+	if actx.Error() != nil {
+		res.StatusCode = 403
+		res.Status = http.StatusText(403)
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package certauth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/auth"
+	"github.com/google/martian/v3/martiantest"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestModifyRequest(t *testing.T) {
+	m := NewModifier()
+	m.SetRequestModifier(nil)
+
+	req, err := http.NewRequest("GET", "https://www.example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	actx := auth.FromContext(ctx)
+	if got, want := actx.ID(), ""; got != want {
+		t.Errorf("actx.ID(): got %q, want %q", got, want)
+	}
+
+	ctx.Session().SetClientCertificate(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "client-1", Organization: []string{"Martian"}},
+	})
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := actx.ID(), "client-1"; got != want {
+		t.Errorf("actx.ID(): got %q, want %q", got, want)
+	}
+
+	m.SetIDFunc(func(cert *x509.Certificate) string {
+		return cert.Subject.Organization[0]
+	})
+
+	// Modifier with auth error.
+	authErr := errors.New("auth error")
+	tm := martiantest.NewModifier()
+	tm.RequestFunc(func(req *http.Request) {
+		auth.FromContext(martian.NewContext(req)).SetError(authErr)
+	})
+	m.SetRequestModifier(tm)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := actx.Error(), authErr; got != want {
+		t.Errorf("actx.Error(): got %v, want %v", got, want)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got false, want true")
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, 403; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package client provides a Go client for the martian configuration API and
+// builders for the modifier JSON messages it accepts, so that test frameworks
+// can configure remote proxies without hand-writing JSON.
+//
+// Example:
+//
+//	c, err := client.New("http://localhost:8181")
+//	if err != nil {
+//		return err
+//	}
+//
+//	err = c.Configure(ctx, client.FIFOGroup(
+//		client.URLRegexFilter(`example\.com`).Then(
+//			client.HeaderModifier("Martian-Test", "true").RequestOnly()),
+//		client.Logger(),
+//	))
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/martian/v3/har"
+)
+
+// Client talks to the configuration API of a running proxy.
+type Client struct {
+	base *url.URL
+	hc   *http.Client
+}
+
+// New returns a client for the API at apiURL, e.g. "http://localhost:8181" or
+// "http://martian.proxy" when used through the proxy.
+func New(apiURL string) (*Client, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("client: invalid API URL: %s", apiURL)
+	}
+
+	return &Client{
+		base: u,
+		hc:   http.DefaultClient,
+	}, nil
+}
+
+// SetHTTPClient sets the HTTP client used to send API requests. To reach the
+// API through the proxy set the client's transport to use the proxy.
+func (c *Client) SetHTTPClient(hc *http.Client) {
+	c.hc = hc
+}
+
+// StatusError is returned when the API responds with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error returns a formatted error message for a StatusError.
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("client: unexpected status: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("client: unexpected status: %d: %s", e.StatusCode, e.Message)
+}
+
+// Configure sets the modifier of the proxy.
+func (c *Client) Configure(ctx context.Context, m *Modifier) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return c.ConfigureJSON(ctx, b)
+}
+
+// ConfigureJSON sets the modifier of the proxy from a raw JSON message.
+func (c *Client) ConfigureJSON(ctx context.Context, b []byte) error {
+	res, err := c.Do(ctx, http.MethodPost, "/configure", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Configuration returns the JSON message of the current proxy configuration.
+func (c *Client) Configuration(ctx context.Context) ([]byte, error) {
+	res, err := c.Do(ctx, http.MethodGet, "/configure", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return io.ReadAll(res.Body)
+}
+
+// Verify returns the verification failures reported by the proxy. A nil error
+// with no messages means all verifications passed.
+func (c *Client) Verify(ctx context.Context) ([]string, error) {
+	res, err := c.Do(ctx, http.MethodGet, "/verify", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var msg struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&msg); err != nil {
+		return nil, err
+	}
+
+	var errs []string
+	for _, e := range msg.Errors {
+		errs = append(errs, e.Message)
+	}
+
+	return errs, nil
+}
+
+// ResetVerifications resets the verifiers of the proxy.
+func (c *Client) ResetVerifications(ctx context.Context) error {
+	res, err := c.Do(ctx, http.MethodPost, "/verify/reset", nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// HAR returns the HAR log of the proxy.
+func (c *Client) HAR(ctx context.Context) (*har.HAR, error) {
+	res, err := c.Do(ctx, http.MethodGet, "/logs", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	hl := &har.HAR{}
+	if err := json.NewDecoder(res.Body).Decode(hl); err != nil {
+		return nil, err
+	}
+
+	return hl, nil
+}
+
+// ResetHAR clears the HAR log of the proxy.
+func (c *Client) ResetHAR(ctx context.Context) error {
+	res, err := c.Do(ctx, http.MethodDelete, "/logs/reset", nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// ShapeTraffic sets the traffic shaping configuration of the proxy from a
+// JSON message with a top-level "trafficshape" object.
+func (c *Client) ShapeTraffic(ctx context.Context, b []byte) error {
+	res, err := c.Do(ctx, http.MethodPost, "/shape-traffic", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Do sends a request to the API endpoint at path and returns the response if
+// it has a 2xx status, otherwise a *StatusError. It can be used for endpoints
+// without a dedicated method.
+func (c *Client) Do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, &StatusError{
+			StatusCode: res.StatusCode,
+			Message:    strings.TrimSpace(string(b)),
+		}
+	}
+
+	return res, nil
+}
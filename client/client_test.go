@@ -0,0 +1,178 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/har"
+	"github.com/google/martian/v3/martianhttp"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+	"github.com/google/martian/v3/verify"
+
+	_ "github.com/google/martian/v3/failure"
+	_ "github.com/google/martian/v3/fifo"
+	_ "github.com/google/martian/v3/header"
+	_ "github.com/google/martian/v3/martianurl"
+	_ "github.com/google/martian/v3/priority"
+)
+
+func TestModifierJSON(t *testing.T) {
+	m := FIFOGroup(
+		URLFilter(&url.URL{Host: "example.com"}).Then(
+			HeaderModifier("Martian-Test", "true").RequestOnly()),
+		PriorityGroup(PriorityModifier{
+			Priority: 10,
+			Modifier: HeaderBlacklist("Cookie"),
+		}),
+	)
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("json.Marshal(): got %v, want no error", err)
+	}
+
+	var got any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal(): got %v, want no error", err)
+	}
+
+	var want any
+	json.Unmarshal([]byte(`{
+		"fifo.Group": {
+			"modifiers": [
+				{
+					"url.Filter": {
+						"scheme": "",
+						"host": "example.com",
+						"path": "",
+						"query": "",
+						"modifier": {
+							"header.Modifier": {
+								"scope": ["request"],
+								"name": "Martian-Test",
+								"value": "true"
+							}
+						}
+					}
+				},
+				{
+					"priority.Group": {
+						"modifiers": [
+							{
+								"priority": 10,
+								"modifier": {
+									"header.Blacklist": {
+										"names": ["Cookie"]
+									}
+								}
+							}
+						]
+					}
+				}
+			]
+		}
+	}`), &want)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("json.Marshal(): got %s, want %v", b, want)
+	}
+
+	if _, err := parse.FromJSON(b); err != nil {
+		t.Errorf("parse.FromJSON(): got %v, want no error", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	m := martianhttp.NewModifier()
+	hl := har.NewLogger()
+
+	mux := http.NewServeMux()
+	mux.Handle("/configure", m)
+	vh := verify.NewHandler()
+	vh.SetRequestVerifier(m)
+	vh.SetResponseVerifier(m)
+	mux.Handle("/verify", vh)
+	rh := verify.NewResetHandler()
+	rh.SetRequestVerifier(m)
+	rh.SetResponseVerifier(m)
+	mux.Handle("/verify/reset", rh)
+	mux.Handle("/logs", har.NewExportHandler(hl))
+	mux.Handle("/logs/reset", har.NewResetHandler(hl))
+
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c, err := New(s.URL)
+	if err != nil {
+		t.Fatalf("New(): got %v, want no error", err)
+	}
+	ctx := context.Background()
+
+	if err := c.ConfigureJSON(ctx, []byte(`{"unknown.Modifier": {}}`)); err == nil {
+		t.Error("c.ConfigureJSON(): got nil, want error")
+	} else if serr, ok := err.(*StatusError); !ok || serr.StatusCode != 400 {
+		t.Errorf("c.ConfigureJSON(): got %v, want *StatusError with status 400", err)
+	}
+
+	if err := c.Configure(ctx, FailureVerifier("unexpected request")); err != nil {
+		t.Fatalf("c.Configure(): got %v, want no error", err)
+	}
+
+	b, err := c.Configuration(ctx)
+	if err != nil {
+		t.Fatalf("c.Configuration(): got %v, want no error", err)
+	}
+	if len(b) == 0 {
+		t.Error("c.Configuration(): got empty configuration, want configuration")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	martian.TestContext(req, nil, nil)
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	hl.ModifyRequest(req)
+	hl.ModifyResponse(proxyutil.NewResponse(200, nil, req))
+
+	errs, err := c.Verify(ctx)
+	if err != nil {
+		t.Fatalf("c.Verify(): got %v, want no error", err)
+	}
+	if got, want := len(errs), 1; got != want {
+		t.Fatalf("len(errs): got %d, want %d", got, want)
+	}
+
+	if err := c.ResetVerifications(ctx); err != nil {
+		t.Fatalf("c.ResetVerifications(): got %v, want no error", err)
+	}
+	if errs, err := c.Verify(ctx); err != nil || len(errs) != 0 {
+		t.Errorf("c.Verify(): got %v, %v, want no errors", errs, err)
+	}
+
+	l, err := c.HAR(ctx)
+	if err != nil {
+		t.Fatalf("c.HAR(): got %v, want no error", err)
+	}
+	if got, want := len(l.Log.Entries), 1; got != want {
+		t.Errorf("len(l.Log.Entries): got %d, want %d", got, want)
+	}
+
+	if err := c.ResetHAR(ctx); err != nil {
+		t.Fatalf("c.ResetHAR(): got %v, want no error", err)
+	}
+	if l, err := c.HAR(ctx); err != nil || len(l.Log.Entries) != 0 {
+		t.Errorf("c.HAR(): got %v, %v, want no entries", l, err)
+	}
+}
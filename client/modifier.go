@@ -0,0 +1,226 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package client
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"github.com/google/martian/v3/parse"
+)
+
+// Modifier builds the JSON message of a single modifier as accepted by
+// parse.FromJSON. Modifiers are created with the typed constructors in this
+// package and refined with the chainable methods below.
+type Modifier struct {
+	name   string
+	params map[string]any
+}
+
+// NewModifier returns a modifier message for the modifier registered as name,
+// e.g. "header.Modifier". It can be used for modifiers that have no typed
+// constructor in this package.
+func NewModifier(name string) *Modifier {
+	return &Modifier{
+		name:   name,
+		params: make(map[string]any),
+	}
+}
+
+// Name returns the registered name of the modifier.
+func (m *Modifier) Name() string {
+	return m.name
+}
+
+// Set sets the attribute key to v.
+func (m *Modifier) Set(key string, v any) *Modifier {
+	m.params[key] = v
+	return m
+}
+
+// Scope limits the modifier to the given scopes.
+func (m *Modifier) Scope(scope ...parse.ModifierType) *Modifier {
+	return m.Set("scope", scope)
+}
+
+// RequestOnly limits the modifier to requests.
+func (m *Modifier) RequestOnly() *Modifier {
+	return m.Scope(parse.Request)
+}
+
+// ResponseOnly limits the modifier to responses.
+func (m *Modifier) ResponseOnly() *Modifier {
+	return m.Scope(parse.Response)
+}
+
+// Then sets the modifier run by a filter when its condition matches.
+func (m *Modifier) Then(mod *Modifier) *Modifier {
+	return m.Set("modifier", mod)
+}
+
+// Else sets the modifier run by a filter when its condition does not match.
+func (m *Modifier) Else(mod *Modifier) *Modifier {
+	return m.Set("else", mod)
+}
+
+// MarshalJSON returns the modifier message.
+func (m *Modifier) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]map[string]any{
+		m.name: m.params,
+	})
+}
+
+// HeaderModifier sets the header name to value.
+func HeaderModifier(name, value string) *Modifier {
+	return NewModifier("header.Modifier").Set("name", name).Set("value", value)
+}
+
+// HeaderAppend appends value to the header name.
+func HeaderAppend(name, value string) *Modifier {
+	return NewModifier("header.Append").Set("name", name).Set("value", value)
+}
+
+// HeaderBlacklist removes the named headers.
+func HeaderBlacklist(names ...string) *Modifier {
+	return NewModifier("header.Blacklist").Set("names", names)
+}
+
+// HeaderCopy copies the value of header from to header to.
+func HeaderCopy(from, to string) *Modifier {
+	return NewModifier("header.Copy").Set("from", from).Set("to", to)
+}
+
+// HeaderFilter matches messages with the header name; if value is non-empty
+// the header must also have the value.
+func HeaderFilter(name, value string) *Modifier {
+	return NewModifier("header.Filter").Set("name", name).Set("value", value)
+}
+
+// HeaderRegexFilter matches messages where the value of header matches regex.
+func HeaderRegexFilter(header, regex string) *Modifier {
+	return NewModifier("header.RegexFilter").Set("header", header).Set("regex", regex)
+}
+
+// HeaderVerifier verifies that messages have the header name; if value is
+// non-empty the header must also have the value.
+func HeaderVerifier(name, value string) *Modifier {
+	return NewModifier("header.Verifier").Set("name", name).Set("value", value)
+}
+
+// URLFilter matches requests whose URL has the non-empty components of u.
+func URLFilter(u *url.URL) *Modifier {
+	return urlMessage("url.Filter", u)
+}
+
+// URLRegexFilter matches requests whose URL matches regex.
+func URLRegexFilter(regex string) *Modifier {
+	return NewModifier("url.RegexFilter").Set("regex", regex)
+}
+
+// URLModifier sets the non-empty components of u on the request URL.
+func URLModifier(u *url.URL) *Modifier {
+	return urlMessage("url.Modifier", u)
+}
+
+// URLVerifier verifies that request URLs have the non-empty components of u.
+func URLVerifier(u *url.URL) *Modifier {
+	return urlMessage("url.Verifier", u)
+}
+
+func urlMessage(name string, u *url.URL) *Modifier {
+	return NewModifier(name).
+		Set("scheme", u.Scheme).
+		Set("host", u.Host).
+		Set("path", u.Path).
+		Set("query", u.RawQuery)
+}
+
+// MethodFilter matches requests with method.
+func MethodFilter(method string) *Modifier {
+	return NewModifier("method.Filter").Set("method", method)
+}
+
+// MethodVerifier verifies that requests have method.
+func MethodVerifier(method string) *Modifier {
+	return NewModifier("method.Verifier").Set("method", method)
+}
+
+// QueryStringModifier sets the query parameter name to value.
+func QueryStringModifier(name, value string) *Modifier {
+	return NewModifier("querystring.Modifier").Set("name", name).Set("value", value)
+}
+
+// QueryStringFilter matches requests with the query parameter name; if value
+// is non-empty the parameter must also have the value.
+func QueryStringFilter(name, value string) *Modifier {
+	return NewModifier("querystring.Filter").Set("name", name).Set("value", value)
+}
+
+// QueryStringVerifier verifies that requests have the query parameter name;
+// if value is non-empty the parameter must also have the value.
+func QueryStringVerifier(name, value string) *Modifier {
+	return NewModifier("querystring.Verifier").Set("name", name).Set("value", value)
+}
+
+// CookieModifier sets the cookie name to value.
+func CookieModifier(name, value string) *Modifier {
+	return NewModifier("cookie.Modifier").Set("name", name).Set("value", value)
+}
+
+// CookieFilter matches messages with the cookie name; if value is non-empty
+// the cookie must also have the value.
+func CookieFilter(name, value string) *Modifier {
+	return NewModifier("cookie.Filter").Set("name", name).Set("value", value)
+}
+
+// StatusModifier sets the response status code.
+func StatusModifier(code int) *Modifier {
+	return NewModifier("status.Modifier").Set("statusCode", code)
+}
+
+// StatusVerifier verifies that responses have the status code.
+func StatusVerifier(code int) *Modifier {
+	return NewModifier("status.Verifier").Set("statusCode", code)
+}
+
+// BodyModifier replaces the message body.
+func BodyModifier(contentType string, body []byte) *Modifier {
+	return NewModifier("body.Modifier").Set("contentType", contentType).Set("body", body)
+}
+
+// SkipRoundTrip skips the round trip to the destination server.
+func SkipRoundTrip() *Modifier {
+	return NewModifier("skip.RoundTrip")
+}
+
+// FailureVerifier fails verification with message for every request.
+func FailureVerifier(message string) *Modifier {
+	return NewModifier("failure.Verifier").Set("message", message)
+}
+
+// Logger logs requests and responses.
+func Logger() *Modifier {
+	return NewModifier("log.Logger")
+}
+
+// FIFOGroup runs mods in order.
+func FIFOGroup(mods ...*Modifier) *Modifier {
+	if mods == nil {
+		mods = []*Modifier{}
+	}
+	return NewModifier("fifo.Group").Set("modifiers", mods)
+}
+
+// PriorityModifier is a modifier with a priority in a priority group.
+type PriorityModifier struct {
+	Priority int64     `json:"priority"`
+	Modifier *Modifier `json:"modifier"`
+}
+
+// PriorityGroup runs mods in order of descending priority.
+func PriorityGroup(mods ...PriorityModifier) *Modifier {
+	if mods == nil {
+		mods = []PriorityModifier{}
+	}
+	return NewModifier("priority.Group").Set("modifiers", mods)
+}
@@ -0,0 +1,236 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package clientip provides a filter and a matcher for the IP address of the
+// client of the proxy.
+package clientip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/google/martian/v3/filter"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("clientip.Filter", filterFromJSON)
+	filter.RegisterCondition("clientip", matcherFromJSON)
+}
+
+// Matcher is a conditional evaluator of the client IP address of requests to
+// be used in filters that take conditionals.
+//
+// By default, the client address is the remote address of the connection the
+// request was received on. When the proxy runs behind trusted proxies, the
+// address is instead taken from the X-Forwarded-For header: with n trusted
+// proxies, it is the n-th address from the end of the header, the one
+// appended by the farthest trusted proxy. Addresses further left are set by
+// the client and are not used.
+type Matcher struct {
+	prefixes []netip.Prefix
+	hops     int
+}
+
+type matcherJSON struct {
+	CIDRs         []string `json:"cidrs"`
+	ForwardedHops int      `json:"forwardedHops"`
+}
+
+// NewMatcher builds a matcher of client addresses in any of cidrs. An entry
+// without a prefix length matches a single address.
+func NewMatcher(cidrs []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, c := range cidrs {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return nil, err
+		}
+		m.prefixes = append(m.prefixes, p)
+	}
+
+	return m, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("clientip: invalid address %q: %v", s, err)
+		}
+		return netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()), nil
+	}
+
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("clientip: invalid CIDR %q: %v", s, err)
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+
+	return p.Masked(), nil
+}
+
+// SetForwardedHops sets the number of trusted proxies in front of the proxy,
+// which determines the address taken from the X-Forwarded-For header. If
+// hops is 0, the default, the header is ignored.
+func (m *Matcher) SetForwardedHops(hops int) {
+	m.hops = hops
+}
+
+// MatchRequest returns true if the client address of req is in any of the
+// CIDRs of the matcher.
+func (m *Matcher) MatchRequest(req *http.Request) bool {
+	a, ok := m.clientAddr(req)
+	if !ok {
+		return false
+	}
+
+	for _, p := range m.prefixes {
+		if p.Contains(a) {
+			log.Debugf("clientip.Matcher.MatchRequest: matched %s in %s: %s", a, p, req.URL)
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchResponse returns true if the client address of res.Request is in any
+// of the CIDRs of the matcher.
+func (m *Matcher) MatchResponse(res *http.Response) bool {
+	if res.Request == nil {
+		return false
+	}
+
+	return m.MatchRequest(res.Request)
+}
+
+// clientAddr returns the client address of req, or false if it cannot be
+// determined.
+func (m *Matcher) clientAddr(req *http.Request) (netip.Addr, bool) {
+	s := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+
+	if m.hops > 0 {
+		var addrs []string
+		for _, v := range req.Header.Values("X-Forwarded-For") {
+			addrs = append(addrs, strings.Split(v, ",")...)
+		}
+		if len(addrs) < m.hops {
+			return netip.Addr{}, false
+		}
+		s = strings.TrimSpace(addrs[len(addrs)-m.hops])
+	}
+
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return a.Unmap(), true
+}
+
+// matcherFromJSON builds a clientip.Matcher condition from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "cidrs": ["10.0.0.0/8", "2001:db8::/32", "192.0.2.1"],
+//	  "forwardedHops": 1
+//	}
+func matcherFromJSON(b []byte) (filter.Condition, error) {
+	msg := &matcherJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return newMatcher(msg)
+}
+
+func newMatcher(msg *matcherJSON) (*Matcher, error) {
+	m, err := NewMatcher(msg.CIDRs)
+	if err != nil {
+		return nil, err
+	}
+	m.SetForwardedHops(msg.ForwardedHops)
+
+	return m, nil
+}
+
+// Filter runs modifiers iff the client address of the request matches a
+// Matcher.
+type Filter struct {
+	*filter.Filter
+}
+
+type filterJSON struct {
+	matcherJSON
+	Modifier     json.RawMessage      `json:"modifier"`
+	ElseModifier json.RawMessage      `json:"else"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+// NewFilter builds a filter that runs modifiers iff the client address of
+// the request matches m.
+func NewFilter(m *Matcher) *Filter {
+	f := filter.New()
+	f.SetRequestCondition(m)
+	f.SetResponseCondition(m)
+	return &Filter{f}
+}
+
+// filterFromJSON builds a clientip.Filter from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "clientip.Filter": {
+//	    "scope": ["request", "response"],
+//	    "cidrs": ["10.0.0.0/8"],
+//	    "forwardedHops": 0,
+//	    "modifier": { ... },
+//	    "else": { ... }
+//	  }
+//	}
+func filterFromJSON(b []byte) (*parse.Result, error) {
+	msg := &filterJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	m, err := newMatcher(&msg.matcherJSON)
+	if err != nil {
+		return nil, err
+	}
+	filter := NewFilter(m)
+
+	r, err := parse.FromJSON(msg.Modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.RequestWhenTrue(r.RequestModifier())
+	filter.ResponseWhenTrue(r.ResponseModifier())
+
+	if len(msg.ElseModifier) > 0 {
+		em, err := parse.FromJSON(msg.ElseModifier)
+		if err != nil {
+			return nil, err
+		}
+
+		if em != nil {
+			filter.RequestWhenFalse(em.RequestModifier())
+			filter.ResponseWhenFalse(em.ResponseModifier())
+		}
+	}
+
+	return parse.NewResult(filter, msg.Scope)
+}
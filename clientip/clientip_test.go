@@ -0,0 +1,135 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package clientip
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+
+	// Import to register header.Modifier with JSON parser.
+	_ "github.com/google/martian/v3/header"
+)
+
+func TestMatcher(t *testing.T) {
+	m, err := NewMatcher([]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1", "::ffff:198.51.100.0/120"})
+	if err != nil {
+		t.Fatalf("NewMatcher(): got %v, want no error", err)
+	}
+
+	tt := []struct {
+		remoteAddr string
+		xff        []string
+		hops       int
+		want       bool
+	}{
+		{"10.1.2.3:1234", nil, 0, true},
+		{"10.1.2.3", nil, 0, true},
+		{"[::ffff:10.1.2.3]:1234", nil, 0, true},
+		{"11.1.2.3:1234", nil, 0, false},
+		{"[2001:db8::1]:1234", nil, 0, true},
+		{"[2001:db9::1]:1234", nil, 0, false},
+		{"192.0.2.1:1234", nil, 0, true},
+		{"192.0.2.2:1234", nil, 0, false},
+		{"198.51.100.7:1234", nil, 0, true},
+		{"not an address", nil, 0, false},
+		// The header is ignored without trusted proxies.
+		{"11.1.2.3:1234", []string{"10.1.2.3"}, 0, false},
+		{"11.1.2.3:1234", []string{"10.1.2.3"}, 1, true},
+		// Addresses set by the client are not used.
+		{"11.1.2.3:1234", []string{"10.1.2.3, 11.1.2.4"}, 1, false},
+		{"11.1.2.3:1234", []string{"11.1.2.4, 10.1.2.3", "11.1.2.5"}, 2, true},
+		{"10.1.2.3:1234", []string{"10.1.2.4"}, 2, false},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		req.RemoteAddr = tc.remoteAddr
+		for _, v := range tc.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+
+		m.SetForwardedHops(tc.hops)
+		if got := m.MatchRequest(req); got != tc.want {
+			t.Errorf("%d. m.MatchRequest(): got %t, want %t", i, got, tc.want)
+		}
+		if got := m.MatchResponse(proxyutil.NewResponse(200, nil, req)); got != tc.want {
+			t.Errorf("%d. m.MatchResponse(): got %t, want %t", i, got, tc.want)
+		}
+	}
+}
+
+func TestNewMatcherErrors(t *testing.T) {
+	for i, cidr := range []string{"10.0.0.0/33", "example.com", "10.0.0"} {
+		if _, err := NewMatcher([]string{cidr}); err == nil {
+			t.Errorf("%d. NewMatcher(%q): got nil, want error", i, cidr)
+		}
+	}
+}
+
+func TestFilterFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "clientip.Filter": {
+      "scope": ["request"],
+      "cidrs": ["10.0.0.0/8"],
+      "forwardedHops": 1,
+      "modifier": {
+        "header.Modifier": {
+          "scope": ["request"],
+          "name": "X-Internal",
+          "value": "true"
+        }
+      },
+      "else": {
+        "header.Modifier": {
+          "scope": ["request"],
+          "name": "X-Internal",
+          "value": "false"
+        }
+      }
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	tt := []struct {
+		xff  string
+		want string
+	}{
+		{"10.0.0.1", "true"},
+		{"192.0.2.1", "false"},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		req.RemoteAddr = "192.0.2.100:1234"
+		req.Header.Set("X-Forwarded-For", tc.xff)
+
+		if err := reqmod.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+		if got := req.Header.Get("X-Internal"); got != tc.want {
+			t.Errorf("%d. req.Header.Get(%q): got %q, want %q", i, "X-Internal", got, tc.want)
+		}
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"clientip.Filter": {"cidrs": ["bad"], "modifier": {}}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error")
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// martianctl controls a running martian proxy through its HTTP API.
+//
+// Usage:
+//
+//	martianctl [flags] <command> [arguments]
+//
+// The commands are:
+//
+//	configure <file>
+//	  applies the modifier configuration in file; "-" reads from stdin
+//	config
+//	  prints the current modifier configuration
+//	verify
+//	  prints the verification failures; exits with status 1 if there are any
+//	verify-reset
+//	  resets the verifiers
+//	har [file]
+//	  writes the HAR log to file or stdout; requires the proxy to run with -har
+//	har-reset
+//	  clears the HAR log
+//	tail
+//	  prints a line for every new entry in the HAR log until interrupted;
+//	  requires the proxy to run with -har
+//	shape <file|off>
+//	  applies the traffic shaping profile in file, or removes all shaping with
+//	  "off"; requires the proxy to run with -traffic-shaping
+//
+// The flags are:
+//
+//	-api="http://localhost:8181"
+//	  URL of the proxy API
+//	-proxy=""
+//	  URL of the proxy to send API requests through, e.g.
+//	  "http://localhost:8080" together with -api="http://martian.proxy"
+//	-interval="1s"
+//	  polling interval of the tail command
+//	-timeout="30s"
+//	  timeout of a single API request
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/google/martian/v3/client"
+)
+
+var (
+	apiURL   = flag.String("api", "http://localhost:8181", "URL of the proxy API")
+	proxyURL = flag.String("proxy", "", "URL of the proxy to send API requests through")
+	interval = flag.Duration("interval", time.Second, "polling interval of the tail command")
+	timeout  = flag.Duration("timeout", 30*time.Second, "timeout of a single API request")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("martianctl: ")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: martianctl [flags] <configure|config|verify|verify-reset|har|har-reset|tail|shape> [arguments]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := client.New(*apiURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hc := &http.Client{Timeout: *timeout}
+	if *proxyURL != "" {
+		u, err := url.Parse(*proxyURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hc.Transport = &http.Transport{Proxy: http.ProxyURL(u)}
+	}
+	c.SetHTTPClient(hc)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "configure":
+		err = configure(ctx, c, args)
+	case "config":
+		err = config(ctx, c)
+	case "verify":
+		err = verify(ctx, c)
+	case "verify-reset":
+		err = c.ResetVerifications(ctx)
+	case "har":
+		err = exportHAR(ctx, c, args)
+	case "har-reset":
+		err = c.ResetHAR(ctx)
+	case "tail":
+		err = tail(ctx, c)
+	case "shape":
+		err = shape(ctx, c, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+}
+
+func readArg(args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected exactly one file argument")
+	}
+	if args[0] == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(args[0])
+}
+
+func configure(ctx context.Context, c *client.Client, args []string) error {
+	b, err := readArg(args)
+	if err != nil {
+		return err
+	}
+
+	return c.ConfigureJSON(ctx, b)
+}
+
+func config(ctx context.Context, c *client.Client) error {
+	b, err := c.Configuration(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Println(string(b))
+	return err
+}
+
+func verify(ctx context.Context, c *client.Client) error {
+	errs, err := c.Verify(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range errs {
+		fmt.Println(e)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+
+	return nil
+}
+
+func exportHAR(ctx context.Context, c *client.Client, args []string) error {
+	hl, err := c.HAR(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if len(args) > 0 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(hl)
+}
+
+func tail(ctx context.Context, c *client.Client) error {
+	seen := make(map[string]bool)
+
+	t := time.NewTicker(*interval)
+	defer t.Stop()
+
+	for {
+		hl, err := c.HAR(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		for _, e := range hl.Log.Entries {
+			if seen[e.ID] || e.Response == nil {
+				continue
+			}
+			seen[e.ID] = true
+
+			fmt.Printf("%s %s %s %d %dms\n", e.StartedDateTime.Format(time.RFC3339),
+				e.Request.Method, e.Request.URL, e.Response.Status, e.Time)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func shape(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) == 1 && args[0] == "off" {
+		return c.ShapeTraffic(ctx, []byte(`{"trafficshape": {}}`))
+	}
+
+	b, err := readArg(args)
+	if err != nil {
+		return err
+	}
+
+	return c.ShapeTraffic(ctx, b)
+}
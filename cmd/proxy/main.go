@@ -15,7 +15,8 @@
 // proxy is an HTTP/S proxy configurable via an HTTP API.
 //
 // It can be dynamically configured/queried at runtime by issuing requests to
-// proxy specific paths using JSON.
+// proxy specific paths using JSON; /configure also accepts messages in YAML
+// sent with a YAML Content-Type, such as "application/yaml".
 //
 // Supported configuration endpoints:
 //
@@ -126,9 +127,21 @@
 // a failure state (e.g., pingback.Verifier is failed if no requests have been
 // seen by the proxy)
 //
+//	POST http://martian.proxy/preconnect
+//
+//	{
+//	  "origins": ["https://www.example.com"]
+//	}
+//
+// establishes connections to the origins before a test starts, so that the
+// first requests do not pay for connection setup
+//
 //	GET http://martian.proxy/authority.cer
 //
-// prompts the user to install the CA certificate used by the proxy if MITM is enabled
+// prompts the user to install the CA certificate used by the proxy if MITM is
+// enabled; the certificate is served in PEM format, also available at
+// /authority.pem, in DER format at /authority.der, and as an iOS
+// configuration profile at /authority.mobileconfig
 //
 //	GET http://martian.proxy/logs
 //
@@ -140,6 +153,20 @@
 // reset the in-memory HAR log; note that the log will grow unbounded unless it
 // is periodically reset
 //
+//	GET http://martian.proxy/logs/preview
+//
+// lists the HAR log entries with size-capped previews of the request and
+// response bodies in place of the full bodies; the "id" query parameter
+// selects a single entry and "max" sets the length of text excerpts
+//
+// requests carrying an X-Martian-Labels header, such as
+//
+//	X-Martian-Labels: device=pixel7, build=1234
+//
+// label their connection; the labels are recorded in the logs and the HAR
+// entries of its requests, and those named by -metrics-labels in the metrics,
+// and the header is removed before the request is sent upstream
+//
 // passing the -cors flag will enable CORS support for the endpoints so that they
 // may be called via AJAX
 //
@@ -175,26 +202,107 @@
 //	  window of time around the time of request that the dynamically-generated
 //	  certificate is valid for; the duration is set such that the total valid
 //	  timeframe is double the value of validity (1h before & 1h after)
+//	-ocsp-stapling=false
+//	  staple an OCSP response signed by the CA to dynamically-generated
+//	  certificates
+//	-copy-origin-cert=false
+//	  fetch the certificate of the origin server, through the upstream proxy
+//	  if any, and copy its subject alternative names and extended key usages
+//	  to dynamically-generated certificates
+//	-ech-policy=""
+//	  how to handle connections offering Encrypted Client Hello:
+//	  "passthrough" to tunnel them unmodified or "reject" to close them; by
+//	  default they are man-in-the-middled like any other, which only works
+//	  for clients sending GREASE ECH
+//	-expect-continue="relay"
+//	  how to handle requests with "Expect: 100-continue": "relay" to forward
+//	  the header to the origin, "immediate" to answer 100 Continue right away
+//	  or "buffer" to answer 100 Continue and buffer the request body
+//	-strict-parsing=false
+//	  reject ambiguous requests that could be used for request smuggling with
+//	  400 Bad Request and forward chunked request bodies with a Content-Length
+//	-max-header-bytes=0
+//	  maximum size of request headers, larger requests are rejected with
+//	  431 Request Header Fields Too Large; 0 means 1 MB
+//	-max-header-count=0
+//	  maximum number of request header fields, requests with more fields are
+//	  rejected with 431 Request Header Fields Too Large; 0 means no limit
+//	-preserve-header-order=false
+//	  forward requests with the header fields in the order and casing they
+//	  were received in
+//	-wire-capture-dir=""
+//	  directory to write the raw bytes exchanged over client and upstream
+//	  connections to, one file per connection and direction
+//	-access-log=""
+//	  file to append an access log line per request to
+//	-access-log-format="combined"
+//	  format of access log lines: "common" or "combined"
+//	-proto-descriptor-sets=""
+//	  comma separated descriptor set files, as written by
+//	  "protoc --include_imports --descriptor_set_out", used to log protocol
+//	  buffer and gRPC bodies as text
+//	-plugins=""
+//	  comma separated Go plugins, built with "go build -buildmode=plugin",
+//	  or directories of plugins with the .so extension, loaded at startup to
+//	  register additional modifiers; see package pluginloader
+//	-config-file=""
+//	  JSON, or YAML if named *.yaml or *.yml, modifier configuration file
+//	  applied in addition to the modifiers set with /configure; the file is
+//	  reloaded when it changes, keeping the previous modifiers if its
+//	  configuration is invalid, and ${NAME} and ${file:path} references in
+//	  it are expanded
+//	-expand-config-refs=false
+//	  expand ${NAME} and ${file:path} references to environment variables and
+//	  files in modifier configurations posted to /configure; only enable
+//	  when the API is reachable by trusted clients
 //	-cors=false
 //	  allow the proxy to be configured via CORS requests; such as when
 //	  configuring the proxy via AJAX
 //	-har=false
 //	  enable logging endpoints for retrieving full request/response logs in
 //	  HAR format.
+//	-metrics=false
+//	  enable the /metrics endpoint serving proxy metrics in the Prometheus
+//	  text format
+//	-metrics-labels=""
+//	  comma separated session labels, such as "device,build", recorded as
+//	  dimensions of the request metrics of /metrics and StatsD
+//	-stats=false
+//	  enable the /stats endpoint serving active connections, requests by
+//	  status class and the upstream hosts with the most traffic as JSON
+//	-statsd-addr=""
+//	  UDP host:port of a StatsD endpoint to emit request and connection
+//	  metrics to
+//	-statsd-format="statsd"
+//	  format of StatsD metrics: "statsd" or "dogstatsd"
+//	-statsd-tags=""
+//	  comma separated tags, such as "env:ci", added to DogStatsD metrics
 //	-traffic-shaping=false
 //	  enable traffic shaping endpoints for simulating latency and constrained
 //	  bandwidth conditions (e.g. mobile, exotic network infrastructure, the
-//	  90's)
+//	  90's); shapes can also be applied with "trafficshape.Listener"
+//	  messages in the modifier configuration
 //	-skip-tls-verify=false
 //	  skip TLS server verification; insecure and intended for testing only
+//	-ssl-key-log-file=""
+//	  file to append TLS master secrets to in NSS key log format for both the
+//	  client-facing MITM and upstream connections; defaults to the value of the
+//	  SSLKEYLOGFILE environment variable; insecure and intended for debugging only
 //	-v=0
 //	  log level for console logs; defaults to error only.
+//	-log-levels=""
+//	  comma separated log levels per module overriding -v, such as
+//	  "mitm=debug,h2=info"; the module of the proxy core is "martian"
+//	-log-rate-limit=0s
+//	  log messages with the same level and text at most once per interval;
+//	  0 disables rate limiting
 package main
 
 import (
 	"crypto/tls"
 	"crypto/x509"
 	"flag"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -202,38 +310,70 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/accesslog"
 	mapi "github.com/google/martian/v3/api"
+	"github.com/google/martian/v3/configloader"
+	"github.com/google/martian/v3/connmetric"
 	"github.com/google/martian/v3/cors"
 	"github.com/google/martian/v3/fifo"
 	"github.com/google/martian/v3/har"
 	"github.com/google/martian/v3/httpspec"
+	"github.com/google/martian/v3/labels"
 	mlog "github.com/google/martian/v3/log"
 	"github.com/google/martian/v3/marbl"
 	"github.com/google/martian/v3/martianhttp"
 	"github.com/google/martian/v3/martianlog"
+	"github.com/google/martian/v3/messageview"
+	"github.com/google/martian/v3/metrics"
 	"github.com/google/martian/v3/mitm"
+	"github.com/google/martian/v3/pluginloader"
 	"github.com/google/martian/v3/servemux"
+	"github.com/google/martian/v3/statsd"
+	"github.com/google/martian/v3/tlspolicy"
 	"github.com/google/martian/v3/trafficshape"
+	"github.com/google/martian/v3/trafficshape/shapeconfig"
 	"github.com/google/martian/v3/verify"
 
+	_ "github.com/google/martian/v3/baseline"
 	_ "github.com/google/martian/v3/body"
+	_ "github.com/google/martian/v3/cachecontrol"
+	_ "github.com/google/martian/v3/canary"
+	_ "github.com/google/martian/v3/clientip"
+	_ "github.com/google/martian/v3/compress"
 	_ "github.com/google/martian/v3/cookie"
+	_ "github.com/google/martian/v3/csp"
+	_ "github.com/google/martian/v3/dictionary"
 	_ "github.com/google/martian/v3/failure"
+	_ "github.com/google/martian/v3/fronting"
+	_ "github.com/google/martian/v3/graphql"
+	_ "github.com/google/martian/v3/hsts"
+	_ "github.com/google/martian/v3/htmlrewrite"
+	_ "github.com/google/martian/v3/js"
+	_ "github.com/google/martian/v3/jwt"
 	_ "github.com/google/martian/v3/martianurl"
 	_ "github.com/google/martian/v3/method"
 	_ "github.com/google/martian/v3/pingback"
+	_ "github.com/google/martian/v3/placeholder"
 	_ "github.com/google/martian/v3/port"
 	_ "github.com/google/martian/v3/priority"
 	_ "github.com/google/martian/v3/querystring"
+	_ "github.com/google/martian/v3/redirect"
+	_ "github.com/google/martian/v3/remote"
+	_ "github.com/google/martian/v3/script"
+	_ "github.com/google/martian/v3/signing"
 	_ "github.com/google/martian/v3/skip"
 	_ "github.com/google/martian/v3/stash"
 	_ "github.com/google/martian/v3/static"
 	_ "github.com/google/martian/v3/status"
+	_ "github.com/google/martian/v3/timewindow"
+	_ "github.com/google/martian/v3/wasm"
+	_ "github.com/google/martian/v3/webhook"
 )
 
 var (
@@ -246,23 +386,83 @@ var (
 	key            = flag.String("key", "", "filepath to the private key of the CA used to sign MITM certificates")
 	organization   = flag.String("organization", "Martian Proxy", "organization name for MITM certificates")
 	validity       = flag.Duration("validity", time.Hour, "window of time that MITM certificates are valid")
+	ocspStapling   = flag.Bool("ocsp-stapling", false, "staple OCSP responses to MITM certificates")
+	copyOriginCert = flag.Bool("copy-origin-cert", false, "copy SANs and EKUs of origin certificates to MITM certificates")
+	echPolicy      = flag.String("ech-policy", "", "handling of connections offering ECH: passthrough or reject; by default they are MITM'd")
+	expectContinue = flag.String("expect-continue", "relay", "handling of Expect: 100-continue: relay, immediate or buffer")
+	maxHeaderBytes = flag.Int("max-header-bytes", 0, "maximum size of request headers, 0 means 1 MB")
+	maxHeaderCount = flag.Int("max-header-count", 0, "maximum number of request header fields, 0 means no limit")
+	maxBufferBody  = flag.Int64("max-buffered-body-bytes", 0, "maximum size of request bodies read into memory, 0 means 10 MB")
+	preserveOrder  = flag.Bool("preserve-header-order", false, "forward requests with the header fields in the order and casing they were received in")
+	wireCaptureDir = flag.String("wire-capture-dir", "", "directory to write the raw bytes exchanged over client and upstream connections to")
+	strictParsing  = flag.Bool("strict-parsing", false, "reject ambiguous requests that could be used for request smuggling")
+	followRedirect = flag.Int("follow-redirects", 0, "maximum number of redirects the proxy follows itself, 0 returns redirects to the client")
+	accessLog      = flag.String("access-log", "", "file to append an access log line per request to")
+	accessLogFmt   = flag.String("access-log-format", "combined", "format of access log lines: \"common\" or \"combined\"")
+	protoSets      = flag.String("proto-descriptor-sets", "", "comma separated descriptor set files used to log protocol buffer bodies as text")
+	plugins        = flag.String("plugins", "", "comma separated Go plugins, or directories of plugins, registering additional modifiers")
+	configFile     = flag.String("config-file", "", "JSON or YAML modifier configuration file, reloaded when it changes")
+	expandRefs     = flag.Bool("expand-config-refs", false, "expand environment variable and file references in posted modifier configurations")
 	allowCORS      = flag.Bool("cors", false, "allow CORS requests to configure the proxy")
 	harLogging     = flag.Bool("har", false, "enable HAR logging API")
+	harSample      = flag.Int("har-sample-percent", 100, "percentage of requests recorded in HAR logs")
+	harInclude     = flag.String("har-include-urls", "", "regular expression of the URLs of the requests recorded in HAR logs")
+	harExclude     = flag.String("har-exclude-urls", "", "regular expression of the URLs of the requests not recorded in HAR logs")
 	marblLogging   = flag.Bool("marbl", false, "enable MARBL logging API")
+	metricsAPI     = flag.Bool("metrics", false, "enable the Prometheus metrics API")
+	metricsLabels  = flag.String("metrics-labels", "", "comma separated session labels, such as \"device,build\", recorded as dimensions of request metrics")
+	statsAPI       = flag.Bool("stats", false, "enable the JSON proxy statistics API")
+	statsdAddr     = flag.String("statsd-addr", "", "UDP host:port of a StatsD endpoint to emit request and connection metrics to")
+	statsdFormat   = flag.String("statsd-format", "statsd", "format of StatsD metrics: \"statsd\" or \"dogstatsd\"")
+	statsdTags     = flag.String("statsd-tags", "", "comma separated tags, such as \"env:ci\", added to DogStatsD metrics")
 	trafficShaping = flag.Bool("traffic-shaping", false, "enable traffic shaping API")
 	skipTLSVerify  = flag.Bool("skip-tls-verify", false, "skip TLS server verification; insecure")
 	usProxyURL     = flag.String("upstream-proxy-url", "", "URL of upstream proxy")
+	keyLogFile     = flag.String("ssl-key-log-file", os.Getenv("SSLKEYLOGFILE"), "file to write TLS master secrets to in NSS key log format; insecure")
 	level          = flag.Int("v", 0, "log level")
+	logRateLimit   = flag.Duration("log-rate-limit", 0, "log messages with the same level and text at most once per interval")
+	logLevels      = flag.String("log-levels", "", "comma separated log levels per module, such as \"mitm=debug,h2=info\"")
 )
 
 func main() {
 
 	flag.Parse()
 	mlog.SetLevel(*level)
+	if err := mlog.SetLevels(*logLevels); err != nil {
+		log.Fatal(err)
+	}
+	mlog.SetRateLimit(*logRateLimit)
+
+	if *plugins != "" {
+		for _, path := range strings.Split(*plugins, ",") {
+			load := pluginloader.Load
+			if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+				load = pluginloader.LoadDir
+			}
+			if err := load(path); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
 
 	p := martian.NewProxy()
 	defer p.Close()
 
+	ec, err := martian.ParseExpectContinueMode(*expectContinue)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p.ExpectContinue = ec
+	p.StrictParsing = *strictParsing
+	p.MaxHeaderBytes = *maxHeaderBytes
+	p.MaxHeaderCount = *maxHeaderCount
+	p.MaxBufferedBodyBytes = *maxBufferBody
+	p.PreserveHeaderOrder = *preserveOrder
+	p.FollowRedirects = *followRedirect
+	if *wireCaptureDir != "" {
+		p.WireCapture = martian.NewWireCaptureDir(*wireCaptureDir)
+	}
+
 	l, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatal(err)
@@ -286,7 +486,21 @@ func main() {
 			InsecureSkipVerify: *skipTLSVerify,
 		},
 	}
+	var keyLog io.Writer
+	if *keyLogFile != "" {
+		f, err := os.OpenFile(*keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		log.Printf("martian: writing TLS key log to %s", *keyLogFile)
+		keyLog = f
+		tr.TLSClientConfig.KeyLogWriter = keyLog
+	}
+
 	p.SetRoundTripper(tr)
+	tlspolicy.SetProxy(p)
 
 	if *usProxyURL != "" {
 		u, err := url.Parse(*usProxyURL)
@@ -329,12 +543,26 @@ func main() {
 		mc.SetValidity(*validity)
 		mc.SetOrganization(*organization)
 		mc.SkipTLSVerify(*skipTLSVerify)
+		mc.SetKeyLogWriter(keyLog)
+		mc.SetOCSPStapling(*ocspStapling)
+		if *copyOriginCert {
+			mc.SetOriginCertificateFunc(mitm.NewOriginCertificateFetcher(p.DialTunnel).Fetch)
+		}
+		ep, err := mitm.ParseECHPolicy(*echPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mc.SetECHPolicy(ep)
 
 		p.SetMITM(mc)
+		tlspolicy.SetMITM(mc)
 
 		// Expose certificate authority.
-		ah := martianhttp.NewAuthorityHandler(x509c)
+		ah := martianhttp.NewConfigAuthorityHandler(mc)
 		configure("/authority.cer", ah, mux)
+		configure("/authority.pem", ah, mux)
+		configure("/authority.der", ah, mux)
+		configure("/authority.mobileconfig", ah, mux)
 
 		// Start TLS listener for transparent MITM.
 		tl, err := net.Listen("tcp", *tlsAddr)
@@ -374,11 +602,45 @@ func main() {
 	p.SetResponseModifier(topg)
 
 	m := martianhttp.NewModifier()
+	m.SetExpandReferences(*expandRefs)
 	fg.AddRequestModifier(m)
 	fg.AddResponseModifier(m)
 
+	if *configFile != "" {
+		cl, err := configloader.NewLoader(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := cl.Watch(); err != nil {
+			log.Fatal(err)
+		}
+		defer cl.Close()
+
+		fg.AddRequestModifier(cl)
+		fg.AddResponseModifier(cl)
+	}
+
+	// Attach labels from the X-Martian-Labels header to sessions before they
+	// are logged.
+	stack.AddRequestModifier(labels.NewModifier())
+
 	if *harLogging {
 		hl := har.NewLogger()
+		hl.SetOption(har.SamplePercent(*harSample))
+		if *harInclude != "" {
+			re, err := regexp.Compile(*harInclude)
+			if err != nil {
+				log.Fatal(err)
+			}
+			hl.SetOption(har.IncludeURLs(re))
+		}
+		if *harExclude != "" {
+			re, err := regexp.Compile(*harExclude)
+			if err != nil {
+				log.Fatal(err)
+			}
+			hl.SetOption(har.ExcludeURLs(re))
+		}
 		muxf := servemux.NewFilter(mux)
 		// Only append to HAR logs when the requests are not API requests,
 		// that is, they are not matched in http.DefaultServeMux
@@ -390,14 +652,90 @@ func main() {
 
 		configure("/logs", har.NewExportHandler(hl), mux)
 		configure("/logs/reset", har.NewResetHandler(hl), mux)
+		configure("/logs/preview", har.NewPreviewHandler(hl), mux)
+	}
+
+	var (
+		observers martian.MultiObserver
+		trackers  connmetric.MultiTracker
+	)
+	if *metricsAPI {
+		m := metrics.New()
+		if *metricsLabels != "" {
+			m.SetLabels(strings.Split(*metricsLabels, ",")...)
+		}
+		observers = append(observers, m)
+
+		configure("/metrics", m, mux)
+	}
+	if *statsdAddr != "" {
+		sd, err := statsd.New(*statsdAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer sd.Close()
+
+		format, err := statsd.ParseFormat(*statsdFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sd.SetFormat(format)
+		if *statsdTags != "" {
+			sd.SetTags(strings.Split(*statsdTags, ",")...)
+		}
+		if *metricsLabels != "" {
+			sd.SetLabels(strings.Split(*metricsLabels, ",")...)
+		}
+		observers = append(observers, sd)
+		trackers = append(trackers, sd)
+	}
+	if *statsAPI {
+		sh := mapi.NewStatsHandler()
+		observers = append(observers, sh)
+		trackers = append(trackers, sh)
+
+		configure("/stats", sh, mux)
+	}
+	if len(observers) > 0 {
+		p.Observer = observers
+	}
+	if len(trackers) > 0 {
+		p.SetConnTracker(trackers)
 	}
 
 	logger := martianlog.NewLogger()
 	logger.SetDecode(true)
+	if *protoSets != "" {
+		pt := messageview.NewProtoTypes()
+		for _, path := range strings.Split(*protoSets, ",") {
+			if err := pt.LoadDescriptorSet(path); err != nil {
+				log.Fatal(err)
+			}
+		}
+		logger.SetProtoTypes(pt)
+	}
 
 	stack.AddRequestModifier(logger)
 	stack.AddResponseModifier(logger)
 
+	if *accessLog != "" {
+		f, err := os.OpenFile(*accessLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		format, err := accesslog.ParseFormat(*accessLogFmt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		al := accesslog.NewLogger(f)
+		al.SetFormat(format)
+
+		stack.AddRequestModifier(al)
+		stack.AddResponseModifier(al)
+	}
+
 	if *marblLogging {
 		lsh := marbl.NewHandler()
 		lsm := marbl.NewModifier(lsh)
@@ -426,10 +764,14 @@ func main() {
 	rh.SetResponseVerifier(m)
 	configure("/verify/reset", rh, mux)
 
+	// Preconnect to origins before tests start.
+	configure("/preconnect", martianhttp.NewPreconnectHandler(p), mux)
+
 	if *trafficShaping {
 		tsl := trafficshape.NewListener(l)
 		tsh := trafficshape.NewHandler(tsl)
 		configure("/shape-traffic", tsh, mux)
+		shapeconfig.SetListener(tsl)
 
 		l = tsl
 	}
@@ -0,0 +1,522 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package compress
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// brotliWindowBits is the base 2 logarithm of the window size.
+	brotliWindowBits = 22
+	// brotliBlockSize is the size of the input buffered for a meta-block.
+	brotliBlockSize = 1 << 20
+	brotliMinMatch  = 4
+	brotliHashBits  = 15
+)
+
+var errBrotliClosed = errors.New("compress: write to closed brotli writer")
+
+// brotliWriter is a brotli (RFC 7932) encoder. Its input is buffered in
+// blocks, each written as a meta-block of greedy LZ77 matches within the
+// block, with prefix codes built for the block. It favors speed and
+// simplicity over ratio: it uses no static dictionary, context modeling or
+// block splitting.
+type brotliWriter struct {
+	w      io.Writer
+	bw     bitWriter
+	buf    []byte
+	closed bool
+	err    error
+}
+
+// newBrotliWriter returns a writer compressing to w with brotli.
+func newBrotliWriter(w io.Writer) io.WriteCloser {
+	z := &brotliWriter{w: w}
+	// WBITS: a 1 bit followed by WBITS - 17 in 3 bits.
+	z.bw.writeBits(1, 1)
+	z.bw.writeBits(3, brotliWindowBits-17)
+
+	return z
+}
+
+// Write compresses p, writing the meta-blocks of the blocks it fills.
+func (z *brotliWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errBrotliClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		k := brotliBlockSize - len(z.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+
+		if len(z.buf) == brotliBlockSize {
+			if err := z.writeMetaBlock(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Close writes the buffered input and ends the stream. It does not close the
+// underlying writer.
+func (z *brotliWriter) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+
+	if len(z.buf) > 0 {
+		if err := z.writeMetaBlock(); err != nil {
+			return err
+		}
+	}
+
+	// ISLAST and ISLASTEMPTY.
+	z.bw.writeBits(1, 1)
+	z.bw.writeBits(1, 1)
+	z.bw.align()
+
+	return z.flush()
+}
+
+// flush writes the complete bytes of the bit writer.
+func (z *brotliWriter) flush() error {
+	if _, err := z.w.Write(z.bw.out); err != nil {
+		z.err = err
+		return err
+	}
+	z.bw.out = z.bw.out[:0]
+
+	return nil
+}
+
+// brotliCommand is an insert-and-copy command: insert literals are followed
+// by copy bytes at dist bytes back. The last command of a meta-block may have
+// no copy.
+type brotliCommand struct {
+	insert, copy, dist int
+	sym                int
+	insCode, copyCode  int
+	distSym            int
+	distBits           uint
+	distExtra          uint32
+}
+
+// writeMetaBlock writes the buffered input as a compressed meta-block.
+func (z *brotliWriter) writeMetaBlock() error {
+	data := z.buf
+	cmds := brotliMatch(data)
+
+	var litHist [256]int
+	var cmdHist [704]int
+	var distHist [64]int
+	pos := 0
+	for i := range cmds {
+		c := &cmds[i]
+		for _, b := range data[pos : pos+c.insert] {
+			litHist[b]++
+		}
+		pos += c.insert + c.copy
+
+		c.insCode = brotliLengthCode(&brotliInsertBase, c.insert)
+		if c.copy > 0 {
+			c.copyCode = brotliLengthCode(&brotliCopyBase, c.copy)
+			c.distSym, c.distBits, c.distExtra = brotliDistanceCode(c.dist)
+			distHist[c.distSym]++
+		}
+		c.sym = brotliCommandBase[c.insCode>>3][c.copyCode>>3] + (c.insCode&7)<<3 | c.copyCode&7
+		cmdHist[c.sym]++
+	}
+
+	bw := &z.bw
+	// ISLAST, MNIBBLES - 4, MLEN - 1 and ISUNCOMPRESSED.
+	mlen := uint64(len(data) - 1)
+	nibbles := uint(4)
+	for mlen>>(4*nibbles) != 0 {
+		nibbles++
+	}
+	bw.writeBits(1, 0)
+	bw.writeBits(2, uint64(nibbles-4))
+	bw.writeBits(4*nibbles, mlen)
+	bw.writeBits(1, 0)
+	// A single block type for literals, commands and distances, NPOSTFIX and
+	// NDIRECT of 0, the context mode of the literal block type, and a single
+	// prefix code for literals and distances, which need no context maps.
+	bw.writeBits(3, 0)
+	bw.writeBits(2, 0)
+	bw.writeBits(4, 0)
+	bw.writeBits(2, 0)
+	bw.writeBits(2, 0)
+
+	lit := bw.writePrefixCode(litHist[:], 8)
+	cmd := bw.writePrefixCode(cmdHist[:], 10)
+	dist := bw.writePrefixCode(distHist[:], 6)
+
+	pos = 0
+	for _, c := range cmds {
+		cmd.write(bw, c.sym)
+		bw.writeBits(brotliInsertExtra[c.insCode], uint64(c.insert-int(brotliInsertBase[c.insCode])))
+		if c.copy > 0 {
+			bw.writeBits(brotliCopyExtra[c.copyCode], uint64(c.copy-int(brotliCopyBase[c.copyCode])))
+		}
+		for _, b := range data[pos : pos+c.insert] {
+			lit.write(bw, int(b))
+		}
+		pos += c.insert
+		if c.copy > 0 {
+			dist.write(bw, c.distSym)
+			bw.writeBits(c.distBits, uint64(c.distExtra))
+			pos += c.copy
+		}
+	}
+
+	z.buf = z.buf[:0]
+
+	return z.flush()
+}
+
+// brotliMatch splits data into commands with greedy LZ77 matching.
+func brotliMatch(data []byte) []brotliCommand {
+	var cmds []brotliCommand
+	table := make([]int32, 1<<brotliHashBits)
+	hash := func(i int) uint32 {
+		v := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		return (v * 0x1e35a7bd) >> (32 - brotliHashBits)
+	}
+
+	start := 0
+	for i := 0; i+brotliMinMatch <= len(data); {
+		h := hash(i)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if cand < 0 || data[cand] != data[i] || data[cand+1] != data[i+1] || data[cand+2] != data[i+2] || data[cand+3] != data[i+3] {
+			i++
+			continue
+		}
+
+		n := brotliMinMatch
+		for i+n < len(data) && data[cand+n] == data[i+n] {
+			n++
+		}
+		cmds = append(cmds, brotliCommand{insert: i - start, copy: n, dist: i - cand})
+		for j := i + 1; j < i+n && j+brotliMinMatch <= len(data); j++ {
+			table[hash(j)] = int32(j + 1)
+		}
+		i += n
+		start = i
+	}
+	if start < len(data) {
+		cmds = append(cmds, brotliCommand{insert: len(data) - start})
+	}
+
+	return cmds
+}
+
+var (
+	brotliInsertBase  = [24]uint32{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	brotliInsertExtra = [24]uint{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	brotliCopyBase    = [24]uint32{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	brotliCopyExtra   = [24]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+
+	// brotliCommandBase is the first insert-and-copy symbol of the cells of
+	// insert and copy length codes in groups of 8, excluding the cells using
+	// the last distance.
+	brotliCommandBase = [3][3]int{{128, 192, 384}, {256, 320, 512}, {448, 576, 640}}
+
+	// brotliCodeLengthOrder is the order of the code lengths of the code
+	// length code.
+	brotliCodeLengthOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	// brotliCodeLengthCodes are the static codes and lengths of the code
+	// lengths of the code length code.
+	brotliCodeLengthCodes = [6]struct {
+		code uint64
+		n    uint
+	}{{0, 2}, {7, 4}, {3, 3}, {2, 2}, {1, 2}, {15, 4}}
+)
+
+// brotliLengthCode returns the insert or copy length code of n.
+func brotliLengthCode(base *[24]uint32, n int) int {
+	c := len(base) - 1
+	for int(base[c]) > n {
+		c--
+	}
+
+	return c
+}
+
+// brotliDistanceCode returns the distance symbol of dist, with neither
+// postfix bits nor direct distance codes, and its extra bits.
+func brotliDistanceCode(dist int) (sym int, n uint, extra uint32) {
+	v := uint32(dist + 3)
+	n = uint(bits.Len32(v) - 2)
+	hi := (v >> n) & 1
+
+	return 16 + 2*int(n-1) + int(hi), n, v - (2+hi)<<n
+}
+
+// bitWriter writes bits starting from the least significant bit of each byte.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (b *bitWriter) writeBits(n uint, v uint64) {
+	b.bits |= v << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.nbits -= 8
+	}
+}
+
+// align pads the bits to a byte boundary with zeros.
+func (b *bitWriter) align() {
+	if b.nbits > 0 {
+		b.writeBits(8-b.nbits, 0)
+	}
+}
+
+// prefixCode is the canonical prefix code of an alphabet, with the codes bit
+// reversed to be written starting from their most significant bit.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func (pc prefixCode) write(b *bitWriter, sym int) {
+	b.writeBits(uint(pc.lengths[sym]), uint64(pc.codes[sym]))
+}
+
+// writePrefixCode writes a prefix code for the symbol counts hist of an
+// alphabet whose symbols have alphabetBits bits, and returns it.
+func (b *bitWriter) writePrefixCode(hist []int, alphabetBits uint) prefixCode {
+	var used []int
+	for s, c := range hist {
+		if c > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) < 2 {
+		// A simple prefix code of one symbol, which takes no bits.
+		sym := 0
+		if len(used) == 1 {
+			sym = used[0]
+		}
+		b.writeBits(2, 1)
+		b.writeBits(2, 0)
+		b.writeBits(alphabetBits, uint64(sym))
+
+		return prefixCode{lengths: make([]uint8, len(hist)), codes: make([]uint16, len(hist))}
+	}
+
+	lengths := huffmanLengths(hist, 15)
+	last := len(lengths) - 1
+	for lengths[last] == 0 {
+		last--
+	}
+
+	// The code lengths up to the last used symbol, with runs of zeros as
+	// repeat codes 17. Consecutive repeat codes would multiply their counts,
+	// so longer runs are split by a zero.
+	type token struct {
+		sym   int
+		extra uint64
+	}
+	var tokens []token
+	var clHist [18]int
+	for i := 0; i <= last; {
+		if lengths[i] != 0 {
+			tokens = append(tokens, token{sym: int(lengths[i])})
+			clHist[lengths[i]]++
+			i++
+			continue
+		}
+
+		run := 0
+		for i+run <= last && lengths[i+run] == 0 {
+			run++
+		}
+		i += run
+		for run > 0 {
+			if run < 3 {
+				tokens = append(tokens, token{sym: 0})
+				clHist[0]++
+				run--
+				continue
+			}
+			k := run
+			if k > 10 {
+				k = 10
+			}
+			tokens = append(tokens, token{sym: 17, extra: uint64(k - 3)})
+			clHist[17]++
+			run -= k
+			if run > 0 {
+				tokens = append(tokens, token{sym: 0})
+				clHist[0]++
+				run--
+			}
+		}
+	}
+
+	// The code length code must have two symbols to be complete.
+	nused := 0
+	for _, c := range clHist {
+		if c > 0 {
+			nused++
+		}
+	}
+	if nused < 2 {
+		if clHist[0] == 0 {
+			clHist[0] = 1
+		} else {
+			clHist[1] = 1
+		}
+	}
+	clLengths := huffmanLengths(clHist[:], 5)
+	cl := prefixCode{lengths: clLengths, codes: huffmanCodes(clLengths)}
+
+	// HSKIP of 0, and the code lengths of the code length code up to the one
+	// completing the code.
+	b.writeBits(2, 0)
+	space := 32
+	for _, s := range brotliCodeLengthOrder {
+		l := clLengths[s]
+		b.writeBits(brotliCodeLengthCodes[l].n, brotliCodeLengthCodes[l].code)
+		if l != 0 {
+			space -= 32 >> l
+			if space == 0 {
+				break
+			}
+		}
+	}
+
+	for _, t := range tokens {
+		cl.write(b, t.sym)
+		if t.sym == 17 {
+			b.writeBits(3, t.extra)
+		}
+	}
+
+	return prefixCode{lengths: lengths, codes: huffmanCodes(lengths)}
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for the symbol
+// counts hist of at least two symbols, at most maxLen bits long. Codes too
+// long are shortened by raising the smallest counts until they fit.
+func huffmanLengths(hist []int, maxLen int) []uint8 {
+	lengths := make([]uint8, len(hist))
+	for floor := 1; ; floor *= 2 {
+		if huffmanBuild(hist, floor, lengths) <= maxLen {
+			return lengths
+		}
+	}
+}
+
+// huffmanBuild sets the code lengths of a Huffman code for hist, with counts
+// raised to floor, and returns the longest.
+func huffmanBuild(hist []int, floor int, lengths []uint8) int {
+	type node struct {
+		count, parent int
+	}
+	var nodes []node
+	var syms []int
+	for s, c := range hist {
+		if c > 0 {
+			if c < floor {
+				c = floor
+			}
+			nodes = append(nodes, node{count: c})
+			syms = append(syms, s)
+		}
+	}
+
+	leaves := make([]int, len(nodes))
+	for i := range leaves {
+		leaves[i] = i
+	}
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return nodes[leaves[i]].count < nodes[leaves[j]].count
+	})
+
+	// Merge the two smallest of the sorted leaves and of the internal nodes,
+	// which are created in order of count.
+	var internal []int
+	pop := func() int {
+		var i int
+		if len(leaves) > 0 && (len(internal) == 0 || nodes[leaves[0]].count <= nodes[internal[0]].count) {
+			i, leaves = leaves[0], leaves[1:]
+		} else {
+			i, internal = internal[0], internal[1:]
+		}
+		return i
+	}
+	for n := len(syms); n > 1; n-- {
+		a, b := pop(), pop()
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count})
+		p := len(nodes) - 1
+		nodes[a].parent, nodes[b].parent = p, p
+		internal = append(internal, p)
+	}
+
+	// Parents are created after their children, and the root last.
+	depth := make([]int, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[nodes[i].parent] + 1
+	}
+	longest := 0
+	for i, s := range syms {
+		lengths[s] = uint8(depth[i])
+		if depth[i] > longest {
+			longest = depth[i]
+		}
+	}
+
+	return longest
+}
+
+// huffmanCodes returns the canonical codes of the code lengths, bit reversed.
+func huffmanCodes(lengths []uint8) []uint16 {
+	var count [16]int
+	for _, l := range lengths {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [16]int
+	code := 0
+	for l := 1; l < len(next); l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint16, len(lengths))
+	for s, l := range lengths {
+		if l > 0 {
+			codes[s] = bits.Reverse16(uint16(next[l])) >> (16 - l)
+			next[l]++
+		}
+	}
+
+	return codes
+}
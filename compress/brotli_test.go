@@ -0,0 +1,77 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// brotliDecode decodes b with the brotli decoder of Node.js, skipping the
+// test if it is not installed.
+func brotliDecode(t *testing.T, b []byte) string {
+	t.Helper()
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("skipping brotli decoding: node not found")
+	}
+	cmd := exec.Command(node, "-e", `process.stdout.write(require("zlib").brotliDecompressSync(require("fs").readFileSync(0)))`)
+	cmd.Stdin = bytes.NewReader(b)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("brotli decoding: got %v, want no error", err)
+	}
+
+	return string(out)
+}
+
+func TestBrotliWriter(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 64<<10)
+	r.Read(random)
+	letters := make([]byte, brotliBlockSize+100)
+	for i := range letters {
+		letters[i] = "abcdefgh"[r.Intn(8)]
+	}
+
+	tt := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"one byte", "a"},
+		{"short", "hello"},
+		{"repeated", strings.Repeat("hello ", 1000)},
+		{"one symbol", strings.Repeat("x", 1000)},
+		{"random", string(random)},
+		{"several blocks", string(letters)},
+	}
+
+	for _, tc := range tt {
+		buf := &bytes.Buffer{}
+		w := newBrotliWriter(buf)
+		if _, err := w.Write([]byte(tc.data)); err != nil {
+			t.Fatalf("%s: w.Write(): got %v, want no error", tc.name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: w.Close(): got %v, want no error", tc.name, err)
+		}
+
+		if got := brotliDecode(t, buf.Bytes()); got != tc.data {
+			t.Errorf("%s: decoded %d bytes, want %d", tc.name, len(got), len(tc.data))
+		}
+		if tc.name == "repeated" && buf.Len() > 100 {
+			t.Errorf("%s: got %d compressed bytes, want at most 100", tc.name, buf.Len())
+		}
+	}
+
+	w := newBrotliWriter(&bytes.Buffer{})
+	w.Close()
+	if _, err := w.Write([]byte("a")); err == nil {
+		t.Error("w.Write() after Close(): got nil, want error")
+	}
+}
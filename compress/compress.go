@@ -0,0 +1,356 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package compress provides a modifier that compresses response bodies on the
+// fly.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("compress.Modifier", modifierFromJSON)
+}
+
+// DefaultMaxBodySize is the default size above which Modifier leaves bodies
+// uncompressed.
+const DefaultMaxBodySize = 32 << 20
+
+// acceptEncodingKey is the context key of the Accept-Encoding header of the
+// client.
+const acceptEncodingKey = "compress.AcceptEncoding"
+
+// NewWriterFunc returns a writer compressing to w.
+type NewWriterFunc func(w io.Writer) (io.WriteCloser, error)
+
+// defaultContentTypes are the media types compressed by default. Types ending
+// with "/" match all their subtypes.
+var defaultContentTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/wasm",
+	"application/x-javascript",
+	"application/xhtml+xml",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Modifier is a request and response modifier that compresses response bodies
+// with an encoding the client accepts, for testing the decompression paths of
+// clients. Bodies are compressed only if the origin sent them unencoded, and
+// are buffered so that the Content-Length of the compressed body is set.
+//
+// The gzip, deflate and br encodings are supported; br is used only if
+// enabled with SetEncodings, as its encoder favors speed over ratio. Others
+// can be added with SetEncoder. The encoding is negotiated from the
+// Accept-Encoding header of the client, preferring encodings in the order of
+// the modifier on equal quality.
+//
+// As a request modifier, Modifier removes the Accept-Encoding header so that
+// the origin sends unencoded bodies, and keeps it in the context for the
+// response. As a response modifier only, it compresses the bodies the origin
+// sent unencoded according to the Accept-Encoding header of the request.
+type Modifier struct {
+	encodings    []string
+	writers      map[string]NewWriterFunc
+	contentTypes []string
+	minSize      int64
+	maxSize      int64
+}
+
+type modifierJSON struct {
+	Encodings    []string             `json:"encodings"`
+	ContentTypes []string             `json:"contentTypes"`
+	MinBodyBytes int64                `json:"minBodyBytes"`
+	MaxBodyBytes int64                `json:"maxBodyBytes"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a Modifier compressing with gzip or deflate the bodies
+// of text, JavaScript, JSON, XML, SVG and WebAssembly responses. It supports
+// br, which SetEncodings enables.
+func NewModifier() *Modifier {
+	return &Modifier{
+		encodings: []string{"gzip", "deflate"},
+		writers: map[string]NewWriterFunc{
+			"gzip": func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(w), nil
+			},
+			"deflate": func(w io.Writer) (io.WriteCloser, error) {
+				return zlib.NewWriter(w), nil
+			},
+			"br": func(w io.Writer) (io.WriteCloser, error) {
+				return newBrotliWriter(w), nil
+			},
+		},
+		contentTypes: defaultContentTypes,
+		maxSize:      DefaultMaxBodySize,
+	}
+}
+
+// SetEncoder sets the writer of the content coding name, adding the encoding
+// last if the modifier does not have it.
+func (m *Modifier) SetEncoder(name string, newWriter NewWriterFunc) {
+	name = strings.ToLower(name)
+	if _, ok := m.writers[name]; !ok {
+		m.encodings = append(m.encodings, name)
+	}
+	m.writers[name] = newWriter
+}
+
+// SetEncodings restricts the modifier to the encodings, in order of
+// preference. It returns an error if the modifier has no writer for an
+// encoding.
+func (m *Modifier) SetEncodings(names ...string) error {
+	encodings := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := m.writers[name]; !ok {
+			return fmt.Errorf("compress: unsupported encoding %q", name)
+		}
+		encodings = append(encodings, name)
+	}
+	m.encodings = encodings
+
+	return nil
+}
+
+// SetContentTypes sets the media types of the bodies to compress. Types
+// ending with "/" match all their subtypes, as "text/" does.
+func (m *Modifier) SetContentTypes(types ...string) {
+	m.contentTypes = types
+}
+
+// SetMinBodySize sets the size below which bodies are left uncompressed. By
+// default all bodies are compressed.
+func (m *Modifier) SetMinBodySize(size int64) {
+	m.minSize = size
+}
+
+// SetMaxBodySize sets the size above which bodies are left uncompressed. It
+// defaults to DefaultMaxBodySize.
+func (m *Modifier) SetMaxBodySize(size int64) {
+	m.maxSize = size
+}
+
+// ModifyRequest keeps the Accept-Encoding header of req in the context and
+// removes it, so that the origin sends unencoded bodies.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+
+	ctx.Set(acceptEncodingKey, req.Header.Get("Accept-Encoding"))
+	req.Header.Del("Accept-Encoding")
+
+	return nil
+}
+
+// ModifyResponse compresses the body of res with an encoding accepted by the
+// client, if the origin sent it unencoded.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil || req.Method == http.MethodHead || res.StatusCode != http.StatusOK {
+		return nil
+	}
+	if res.Body == nil || res.Body == http.NoBody {
+		return nil
+	}
+	if ce := strings.ToLower(res.Header.Get("Content-Encoding")); ce != "" && ce != "identity" {
+		return nil
+	}
+	if hasToken(res.Header.Get("Cache-Control"), "no-transform") || !m.matchesContentType(res.Header.Get("Content-Type")) {
+		return nil
+	}
+	if res.ContentLength >= 0 && res.ContentLength < m.minSize {
+		return nil
+	}
+
+	accept := req.Header.Get("Accept-Encoding")
+	if ctx := martian.NewContext(req); ctx != nil {
+		if v, ok := ctx.Get(acceptEncodingKey); ok {
+			accept = v.(string)
+		}
+	}
+	enc := m.negotiate(accept)
+	if enc == "" {
+		return nil
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(res.Body, m.maxSize+1))
+	if err != nil {
+		return err
+	}
+	body := res.Body
+	// restore puts back the body as read so far, followed by the rest.
+	restore := func() {
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(raw), body), body}
+	}
+	if int64(len(raw)) > m.maxSize {
+		log.Debugf("compress: not compressing body larger than %d bytes", m.maxSize)
+		restore()
+		return nil
+	}
+	if int64(len(raw)) < m.minSize {
+		restore()
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := m.writers[enc](buf)
+	if err != nil {
+		restore()
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		restore()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		restore()
+		return err
+	}
+
+	body.Close()
+	res.Body = io.NopCloser(buf)
+	res.ContentLength = int64(buf.Len())
+	res.TransferEncoding = nil
+	res.Uncompressed = false
+	res.Header.Set("Content-Encoding", enc)
+	res.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	if !hasToken(res.Header.Get("Vary"), "Accept-Encoding") {
+		res.Header.Add("Vary", "Accept-Encoding")
+	}
+	// The compressed body is a different representation, which a strong
+	// validator must not match.
+	if etag := res.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		res.Header.Set("ETag", "W/"+etag)
+	}
+
+	log.Debugf("compress.Modifier.ModifyResponse: %s: %s compressed %d bytes to %d", req.URL, enc, len(raw), buf.Len())
+
+	return nil
+}
+
+// negotiate returns the encoding of the modifier with the highest quality in
+// accept, or "" if the client accepts none.
+func (m *Modifier) negotiate(accept string) string {
+	qs := make(map[string]float64)
+	for _, item := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		qs[coding] = q
+	}
+
+	var best string
+	var bestQ float64
+	for _, enc := range m.encodings {
+		q, ok := qs[enc]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+
+	return best
+}
+
+func (m *Modifier) matchesContentType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range m.contentTypes {
+		if mt == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasToken reports whether the comma-separated header value v contains token,
+// ignoring case.
+func hasToken(v, token string) bool {
+	for _, t := range strings.Split(v, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// modifierFromJSON builds a compress.Modifier from JSON. "encodings" are the
+// encodings to use in order of preference, of gzip, deflate and br.
+// "contentTypes" overrides the media types to compress, and "minBodyBytes"
+// and "maxBodyBytes" the sizes below and above which bodies are left
+// uncompressed. With the request scope, the origin is asked for unencoded
+// bodies.
+//
+// Example JSON:
+//
+//	{
+//	  "compress.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "encodings": ["br", "deflate"],
+//	    "contentTypes": ["text/", "application/json"],
+//	    "minBodyBytes": 1024
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewModifier()
+	if len(msg.Encodings) > 0 {
+		if err := mod.SetEncodings(msg.Encodings...); err != nil {
+			return nil, err
+		}
+	}
+	if len(msg.ContentTypes) > 0 {
+		mod.SetContentTypes(msg.ContentTypes...)
+	}
+	mod.SetMinBodySize(msg.MinBodyBytes)
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
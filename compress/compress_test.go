@@ -0,0 +1,293 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+const text = "hello hello hello hello hello hello hello hello"
+
+func decode(t *testing.T, enc string, b []byte) string {
+	t.Helper()
+
+	var r io.Reader
+	var err error
+	switch enc {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	case "br":
+		return brotliDecode(t, b)
+	default:
+		return string(b)
+	}
+	if err != nil {
+		t.Fatalf("%s reader: got %v, want no error", enc, err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll(): got %v, want no error", err)
+	}
+
+	return string(got)
+}
+
+func TestModifyResponse(t *testing.T) {
+	tt := []struct {
+		accept      string
+		contentType string
+		want        string
+	}{
+		{"gzip, deflate, br", "text/html; charset=utf-8", "gzip"},
+		{"deflate", "application/json", "deflate"},
+		{"gzip;q=0.5, deflate", "text/plain", "deflate"},
+		{"*", "text/plain", "gzip"},
+		{"*, gzip;q=0", "text/plain", "deflate"},
+		{"br", "text/plain", ""},
+		{"", "text/plain", ""},
+		{"identity", "text/plain", ""},
+		{"gzip", "image/png", ""},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		req.Header.Set("Accept-Encoding", tc.accept)
+
+		res := proxyutil.NewResponse(200, strings.NewReader(text), req)
+		res.Header.Set("Content-Type", tc.contentType)
+		res.ContentLength = int64(len(text))
+		res.Header.Set("ETag", `"v1"`)
+		if err := NewModifier().ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		if got := res.Header.Get("Content-Encoding"); got != tc.want {
+			t.Errorf("%d. Content-Encoding: got %q, want %q", i, got, tc.want)
+		}
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. io.ReadAll(): got %v, want no error", i, err)
+		}
+		if got, want := res.ContentLength, int64(len(b)); got != want {
+			t.Errorf("%d. res.ContentLength: got %d, want %d", i, got, want)
+		}
+		if got := decode(t, tc.want, b); got != text {
+			t.Errorf("%d. decoded body: got %q, want %q", i, got, text)
+		}
+
+		wantETag, wantVary := `"v1"`, ""
+		if tc.want != "" {
+			wantETag, wantVary = `W/"v1"`, "Accept-Encoding"
+		}
+		if got := res.Header.Get("ETag"); got != wantETag {
+			t.Errorf("%d. ETag: got %q, want %q", i, got, wantETag)
+		}
+		if got := res.Header.Get("Vary"); got != wantVary {
+			t.Errorf("%d. Vary: got %q, want %q", i, got, wantVary)
+		}
+	}
+}
+
+func TestModifyResponseIneligible(t *testing.T) {
+	mod := NewModifier()
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res := proxyutil.NewResponse(200, strings.NewReader(text), req)
+	res.Header.Set("Content-Type", "text/plain")
+	res.ContentLength = int64(len(text))
+	res.Header.Set("Content-Encoding", "br")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got := res.Header.Get("Content-Encoding"); got != "br" {
+		t.Errorf("Content-Encoding: got %q, want %q", got, "br")
+	}
+
+	res = proxyutil.NewResponse(200, strings.NewReader(text), req)
+	res.Header.Set("Content-Type", "text/plain")
+	res.ContentLength = int64(len(text))
+	res.Header.Set("Cache-Control", "public, no-transform")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got := res.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding: got %q, want none for no-transform", got)
+	}
+
+	// Bodies larger than the maximum size are restored.
+	mod.SetMaxBodySize(10)
+	res = proxyutil.NewResponse(200, strings.NewReader(text), req)
+	res.Header.Set("Content-Type", "text/plain")
+	res.ContentLength = int64(len(text))
+	res.ContentLength = -1
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, _ := io.ReadAll(res.Body); string(got) != text || res.Header.Get("Content-Encoding") != "" {
+		t.Errorf("res.Body: got %q encoded %q, want original", got, res.Header.Get("Content-Encoding"))
+	}
+
+	mod = NewModifier()
+	mod.SetMinBodySize(1024)
+	res = proxyutil.NewResponse(200, strings.NewReader(text), req)
+	res.Header.Set("Content-Type", "text/plain")
+	res.ContentLength = int64(len(text))
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got := res.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding: got %q, want none below minimum size", got)
+	}
+}
+
+func TestModifyRequest(t *testing.T) {
+	mod := NewModifier()
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Accept-Encoding", "deflate")
+	martian.TestContext(req, nil, nil)
+
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got := req.Header.Get("Accept-Encoding"); got != "" {
+		t.Errorf("Accept-Encoding: got %q, want removed", got)
+	}
+
+	res := proxyutil.NewResponse(200, strings.NewReader(text), req)
+	res.Header.Set("Content-Type", "text/plain")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Content-Encoding"), "deflate"; got != want {
+		t.Errorf("Content-Encoding: got %q, want %q", got, want)
+	}
+}
+
+func TestSetEncoder(t *testing.T) {
+	mod := NewModifier()
+	mod.SetEncoder("x-upper", func(w io.Writer) (io.WriteCloser, error) {
+		return &upperWriter{w: w}, nil
+	})
+	if err := mod.SetEncodings("x-upper", "gzip"); err != nil {
+		t.Fatalf("SetEncodings(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, x-upper")
+
+	res := proxyutil.NewResponse(200, strings.NewReader(text), req)
+	res.Header.Set("Content-Type", "text/plain")
+	res.ContentLength = int64(len(text))
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Content-Encoding"), "x-upper"; got != want {
+		t.Errorf("Content-Encoding: got %q, want %q", got, want)
+	}
+	if got, _ := io.ReadAll(res.Body); string(got) != strings.ToUpper(text) {
+		t.Errorf("res.Body: got %q, want %q", got, strings.ToUpper(text))
+	}
+
+	if err := mod.SetEncodings("zstd"); err == nil {
+		t.Error("SetEncodings(zstd): got nil, want error")
+	}
+}
+
+type upperWriter struct {
+	w io.Writer
+}
+
+func (w *upperWriter) Write(p []byte) (int, error) {
+	return w.w.Write(bytes.ToUpper(p))
+}
+
+func (w *upperWriter) Close() error {
+	return nil
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"compress.Modifier": {
+			"scope": ["response"],
+			"encodings": ["deflate"],
+			"contentTypes": ["application/octet-stream"]
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("r.ResponseModifier(): got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	res := proxyutil.NewResponse(200, strings.NewReader(text), req)
+	res.Header.Set("Content-Type", "application/octet-stream")
+	res.ContentLength = int64(len(text))
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Content-Encoding"), "deflate"; got != want {
+		t.Errorf("Content-Encoding: got %q, want %q", got, want)
+	}
+
+	r, err = parse.FromJSON([]byte(`{"compress.Modifier": {"scope": ["response"], "encodings": ["br"]}}`))
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	res = proxyutil.NewResponse(200, strings.NewReader(text), req)
+	res.Header.Set("Content-Type", "text/plain")
+	res.ContentLength = int64(len(text))
+	if err := r.ResponseModifier().ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Content-Encoding"), "br"; got != want {
+		t.Errorf("Content-Encoding: got %q, want %q", got, want)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("io.ReadAll(): got %v, want no error", err)
+	}
+	if got := decode(t, "br", b); got != text {
+		t.Errorf("decoded body: got %q, want %q", got, text)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"compress.Modifier": {"scope": ["response"], "encodings": ["zstd"]}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for unsupported encoding")
+	}
+}
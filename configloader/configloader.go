@@ -0,0 +1,221 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package configloader provides a modifier configured by a JSON or YAML
+// modifier configuration file that is reloaded when the file changes.
+package configloader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+// reloadDelay is how long a watched file is reloaded after the last change to
+// it, so that a file written in several steps is loaded once.
+const reloadDelay = 100 * time.Millisecond
+
+var noop = martian.Noop("configloader.Loader")
+
+// Loader is a request and response modifier running the modifiers of the
+// JSON modifier configuration in a file, as accepted by parse.FromJSON, or
+// the YAML configuration in a file with a .yaml or .yml extension, as
+// accepted by parse.FromYAML. References to environment variables and files
+// in the configuration are expanded with parse.Expand.
+//
+// When the file changes, the modifiers are rebuilt from it and replaced
+// together. If the new configuration fails to parse, the previous modifiers
+// are kept.
+type Loader struct {
+	path string
+
+	mu     sync.RWMutex
+	reqmod martian.RequestModifier
+	resmod martian.ResponseModifier
+
+	// config is the content of the file last loaded successfully.
+	loadMu sync.Mutex
+	config []byte
+
+	watchOnce sync.Once
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+}
+
+// NewLoader returns a Loader for the configuration file at path, which is
+// loaded before NewLoader returns.
+func NewLoader(path string) (*Loader, error) {
+	l := &Loader{
+		path:    path,
+		reqmod:  noop,
+		resmod:  noop,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if _, err := l.Reload(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Reload loads the configuration file, if its content changed since it was
+// last loaded, and reports whether the modifiers were replaced. If the file
+// cannot be read or its configuration fails to parse, the previous modifiers
+// are kept and the error is returned.
+func (l *Loader) Reload() (bool, error) {
+	l.loadMu.Lock()
+	defer l.loadMu.Unlock()
+
+	b, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return false, err
+	}
+	if l.config != nil && bytes.Equal(b, l.config) {
+		return false, nil
+	}
+
+	r, err := l.parse(b)
+	if err != nil {
+		return false, fmt.Errorf("configloader: %s: %v", l.path, err)
+	}
+
+	l.mu.Lock()
+	l.reqmod = r.RequestModifier()
+	if l.reqmod == nil {
+		l.reqmod = noop
+	}
+	l.resmod = r.ResponseModifier()
+	if l.resmod == nil {
+		l.resmod = noop
+	}
+	l.mu.Unlock()
+
+	l.config = b
+
+	return true, nil
+}
+
+// parse parses the configuration b, converting it from YAML if the file is
+// YAML, after expanding its references.
+func (l *Loader) parse(b []byte) (*parse.Result, error) {
+	switch strings.ToLower(filepath.Ext(l.path)) {
+	case ".yaml", ".yml":
+		var err error
+		if b, err = parse.YAMLToJSON(b); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := parse.Expand(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse.FromJSON(b)
+}
+
+// Watch starts watching the file for changes and reloading it until Close is
+// called. The directory of the file is watched rather than the file itself,
+// so that files saved by renaming a new file over them are followed. Only the
+// first call has an effect.
+func (l *Loader) Watch() error {
+	var err error
+	l.watchOnce.Do(func() {
+		var w *fsnotify.Watcher
+		if w, err = fsnotify.NewWatcher(); err != nil {
+			close(l.done)
+			return
+		}
+		if err = w.Add(filepath.Dir(l.path)); err != nil {
+			w.Close()
+			close(l.done)
+			return
+		}
+
+		go l.watch(w)
+	})
+	if err != nil {
+		return fmt.Errorf("configloader: watching %s: %v", l.path, err)
+	}
+
+	return nil
+}
+
+func (l *Loader) watch(w *fsnotify.Watcher) {
+	defer close(l.done)
+	defer w.Close()
+
+	name := filepath.Clean(l.path)
+	t := time.NewTimer(reloadDelay)
+	t.Stop()
+
+	for {
+		select {
+		case <-l.closing:
+			return
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != name || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			t.Reset(reloadDelay)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("configloader: watching %s: %v", l.path, err)
+		case <-t.C:
+			ok, err := l.Reload()
+			switch {
+			case err != nil:
+				log.Errorf("configloader: keeping previous configuration: %v", err)
+			case ok:
+				log.Infof("configloader: reloaded %s", l.path)
+			}
+		}
+	}
+}
+
+// Close stops watching the file.
+func (l *Loader) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closing)
+
+		started := true
+		l.watchOnce.Do(func() { started = false })
+		if started {
+			<-l.done
+		}
+	})
+
+	return nil
+}
+
+// ModifyRequest runs the request modifier of the configuration.
+func (l *Loader) ModifyRequest(req *http.Request) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.reqmod.ModifyRequest(req)
+}
+
+// ModifyResponse runs the response modifier of the configuration.
+func (l *Loader) ModifyResponse(res *http.Response) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.resmod.ModifyResponse(res)
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package configloader
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/google/martian/v3/header"
+)
+
+func TestLoader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(config string) {
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+		}
+	}
+
+	write(`{"header.Modifier": {"scope": ["request"], "name": "Martian-Test", "value": "first"}}`)
+	l, err := NewLoader(path)
+	if err != nil {
+		t.Fatalf("NewLoader(): got %v, want no error", err)
+	}
+	defer l.Close()
+
+	header := func() string {
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		if err := l.ModifyRequest(req); err != nil {
+			t.Fatalf("l.ModifyRequest(): got %v, want no error", err)
+		}
+		return req.Header.Get("Martian-Test")
+	}
+	if got, want := header(), "first"; got != want {
+		t.Fatalf("req.Header.Get(%q): got %q, want %q", "Martian-Test", got, want)
+	}
+
+	if ok, err := l.Reload(); ok || err != nil {
+		t.Errorf("l.Reload(): got %t, %v, want false, no error", ok, err)
+	}
+
+	write(`{"header.Modifier": {"scope": ["request"], "name": "Martian-Test", "value": "second"}, "unknown": {}}`)
+	if ok, err := l.Reload(); ok || err == nil {
+		t.Errorf("l.Reload(): got %t, %v, want false, error", ok, err)
+	}
+	if got, want := header(), "first"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q after invalid configuration", "Martian-Test", got, want)
+	}
+
+	if err := l.Watch(); err != nil {
+		t.Fatalf("l.Watch(): got %v, want no error", err)
+	}
+	write(`{"header.Modifier": {"scope": ["request"], "name": "Martian-Test", "value": "third"}}`)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for header() != "third" {
+		if time.Now().After(deadline) {
+			t.Fatalf("req.Header.Get(%q): got %q, want %q after change", "Martian-Test", header(), "third")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoaderWatchRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"header.Modifier": {"scope": ["request"], "name": "Martian-Test", "value": "first"}}`), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	l, err := NewLoader(path)
+	if err != nil {
+		t.Fatalf("NewLoader(): got %v, want no error", err)
+	}
+	defer l.Close()
+	if err := l.Watch(); err != nil {
+		t.Fatalf("l.Watch(): got %v, want no error", err)
+	}
+
+	// Save the file the way editors do, by renaming a new file over it.
+	tmp := filepath.Join(dir, ".config.json.tmp")
+	if err := ioutil.WriteFile(tmp, []byte(`{"header.Modifier": {"scope": ["request"], "name": "Martian-Test", "value": "second"}}`), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("os.Rename(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := l.ModifyRequest(req); err != nil {
+			t.Fatalf("l.ModifyRequest(): got %v, want no error", err)
+		}
+		if req.Header.Get("Martian-Test") == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("req.Header.Get(%q): got %q, want %q after rename", "Martian-Test", req.Header.Get("Martian-Test"), "second")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewLoaderInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte("not-json"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	if _, err := NewLoader(path); err == nil {
+		t.Error("NewLoader(): got no error, want error")
+	}
+	if _, err := NewLoader(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("NewLoader(missing): got no error, want error")
+	}
+}
+
+func TestLoaderYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "header.Modifier:\n  scope: [request]\n  name: Martian-Test\n  value: yaml\n"
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	l, err := NewLoader(path)
+	if err != nil {
+		t.Fatalf("NewLoader(): got %v, want no error", err)
+	}
+	defer l.Close()
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := l.ModifyRequest(req); err != nil {
+		t.Fatalf("l.ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("Martian-Test"), "yaml"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "Martian-Test", got, want)
+	}
+}
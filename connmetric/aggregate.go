@@ -0,0 +1,162 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package connmetric
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultMaxSamples is the number of connection durations per host kept for
+// percentiles unless set with Aggregator.SetMaxSamples.
+const defaultMaxSamples = 1024
+
+// HostStats holds the statistics of the connections to a host.
+type HostStats struct {
+	Host string
+	// Count is the number of connections, Errors the number of connections
+	// whose dial, reads or writes failed, and ErrorRate their ratio.
+	Count     int
+	Errors    int
+	ErrorRate float64
+	// BytesRead and BytesWritten are the total number of bytes read from and
+	// written to the connections.
+	BytesRead    int64
+	BytesWritten int64
+	// P50 and P95 are the median and 95th percentile connection durations of
+	// the most recent connections.
+	P50 time.Duration
+	P95 time.Duration
+}
+
+// Aggregator is a Tracker that rolls up the statistics of connections per
+// destination host. Accepted connections are ignored.
+type Aggregator struct {
+	mu         sync.Mutex
+	maxSamples int
+	hosts      map[string]*hostAggregate
+}
+
+type hostAggregate struct {
+	count, errors           int
+	bytesRead, bytesWritten int64
+
+	// durations is a ring buffer of the durations of the most recent
+	// connections, next the index of the oldest once it is full.
+	durations []time.Duration
+	next      int
+}
+
+// NewAggregator returns a new Aggregator. Percentiles are computed from the
+// durations of the last 1024 connections of each host.
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		maxSamples: defaultMaxSamples,
+		hosts:      make(map[string]*hostAggregate),
+	}
+}
+
+// SetMaxSamples sets the number of most recent connection durations per host
+// that percentiles are computed from.
+func (a *Aggregator) SetMaxSamples(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.maxSamples = n
+	for _, h := range a.hosts {
+		if len(h.durations) > n {
+			h.durations = h.samples()[len(h.durations)-n:]
+			h.next = 0
+		}
+	}
+}
+
+// TrackConn adds the statistics of a connection to those of its host.
+func (a *Aggregator) TrackConn(e *StatsEntry) {
+	if e.Accepted {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	h, ok := a.hosts[e.Host]
+	if !ok {
+		h = &hostAggregate{}
+		a.hosts[e.Host] = h
+	}
+
+	h.count++
+	if e.Err != nil {
+		h.errors++
+	}
+	h.bytesRead += e.BytesRead
+	h.bytesWritten += e.BytesWritten
+
+	if len(h.durations) < a.maxSamples {
+		h.durations = append(h.durations, e.Duration)
+		return
+	}
+	h.durations[h.next] = e.Duration
+	h.next = (h.next + 1) % len(h.durations)
+}
+
+// Snapshot returns the current statistics of each host, sorted by host.
+func (a *Aggregator) Snapshot() []HostStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := make([]HostStats, 0, len(a.hosts))
+	for host, h := range a.hosts {
+		s := HostStats{
+			Host:         host,
+			Count:        h.count,
+			Errors:       h.errors,
+			ErrorRate:    float64(h.errors) / float64(h.count),
+			BytesRead:    h.bytesRead,
+			BytesWritten: h.bytesWritten,
+		}
+
+		d := append([]time.Duration(nil), h.durations...)
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		s.P50 = percentile(d, 50)
+		s.P95 = percentile(d, 95)
+
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+
+	return stats
+}
+
+// Reset discards the statistics of all hosts.
+func (a *Aggregator) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.hosts = make(map[string]*hostAggregate)
+}
+
+// samples returns the durations from the oldest to the most recent.
+func (h *hostAggregate) samples() []time.Duration {
+	return append(append([]time.Duration(nil), h.durations[h.next:]...), h.durations[:h.next]...)
+}
+
+// percentile returns the p-th percentile of the sorted durations d using the
+// nearest-rank method, or 0 if d is empty.
+func percentile(d []time.Duration, p int) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+
+	rank := (p*len(d) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return d[rank-1]
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package connmetric
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator()
+
+	for i := 1; i <= 100; i++ {
+		e := &StatsEntry{
+			Host:         "example.com",
+			Duration:     time.Duration(i) * time.Millisecond,
+			BytesRead:    10,
+			BytesWritten: 1,
+		}
+		if i%10 == 0 {
+			e.Err = errors.New("connection reset")
+		}
+		a.TrackConn(e)
+	}
+	a.TrackConn(&StatsEntry{Host: "a.example.com", Duration: time.Second, Err: errors.New("dial error")})
+
+	got := a.Snapshot()
+	want := []HostStats{
+		{
+			Host:      "a.example.com",
+			Count:     1,
+			Errors:    1,
+			ErrorRate: 1,
+			P50:       time.Second,
+			P95:       time.Second,
+		},
+		{
+			Host:         "example.com",
+			Count:        100,
+			Errors:       10,
+			ErrorRate:    0.1,
+			BytesRead:    1000,
+			BytesWritten: 100,
+			P50:          50 * time.Millisecond,
+			P95:          95 * time.Millisecond,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot(): got %+v, want %+v", got, want)
+	}
+
+	a.Reset()
+	if got := a.Snapshot(); len(got) != 0 {
+		t.Errorf("Snapshot() after Reset(): got %+v, want none", got)
+	}
+}
+
+func TestAggregatorMaxSamples(t *testing.T) {
+	a := NewAggregator()
+	a.SetMaxSamples(4)
+
+	// Only the last four durations, 7ms to 10ms, are kept.
+	for i := 1; i <= 10; i++ {
+		a.TrackConn(&StatsEntry{Host: "example.com", Duration: time.Duration(i) * time.Millisecond})
+	}
+
+	s := a.Snapshot()[0]
+	if got, want := s.Count, 10; got != want {
+		t.Errorf("Count: got %d, want %d", got, want)
+	}
+	if s.P50 != 8*time.Millisecond || s.P95 != 10*time.Millisecond {
+		t.Errorf("P50, P95: got %v, %v, want 8ms, 10ms", s.P50, s.P95)
+	}
+
+	a.SetMaxSamples(2)
+	s = a.Snapshot()[0]
+	if s.P50 != 9*time.Millisecond || s.P95 != 10*time.Millisecond {
+		t.Errorf("P50, P95 after SetMaxSamples(2): got %v, %v, want 9ms, 10ms", s.P50, s.P95)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package connmetric collects statistics of network connections, such as the
+// bytes transferred and the connection duration, and reports them to a
+// Tracker when connections are closed. A ThroughputTracker measures the bytes
+// per second of open connections as they transfer bytes.
+package connmetric
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsEntry holds the statistics of a connection.
+type StatsEntry struct {
+	// ID identifies the connection within the process.
+	ID uint64
+	// Accepted reports whether the connection was accepted from a client
+	// rather than dialed.
+	Accepted bool
+	// Network and Addr are the network and address the connection was
+	// dialed with, or of the client of an accepted connection, Host the host
+	// of Addr.
+	Network string
+	Addr    string
+	Host    string
+	// LocalAddr and RemoteAddr are the addresses of the connection.
+	LocalAddr  string
+	RemoteAddr string
+	// Start is the time the connection was established, Duration the time
+	// until it was closed.
+	Start    time.Time
+	Duration time.Duration
+	// DNS, Connect and TLSHandshake are the durations of the phases of
+	// establishing the connection: the resolution of the host, the TCP
+	// connect and the TLS handshake. DNS and Connect are only recorded for
+	// dials by a net.Dialer, TLSHandshake only for TLS handshakes reported
+	// with SetTLSHandshake. They are 0 for phases that did not happen.
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// BytesRead and BytesWritten are the number of bytes read from and
+	// written to the connection.
+	BytesRead    int64
+	BytesWritten int64
+	// Err is the first error other than io.EOF returned by a read or write,
+	// or the error of the dial if it failed.
+	Err error
+}
+
+// Tracker receives the statistics of connections.
+type Tracker interface {
+	// TrackConn is called once for each connection, when it is closed or its
+	// dial failed. It is called concurrently and must not block.
+	TrackConn(e *StatsEntry)
+}
+
+// TrackerFunc is a function that implements Tracker.
+type TrackerFunc func(e *StatsEntry)
+
+// TrackConn calls f(e).
+func (f TrackerFunc) TrackConn(e *StatsEntry) {
+	f(e)
+}
+
+// MultiTracker is a Tracker that passes the statistics to each of its
+// trackers.
+type MultiTracker []Tracker
+
+// TrackConn calls TrackConn(e) on each tracker.
+func (m MultiTracker) TrackConn(e *StatsEntry) {
+	for _, t := range m {
+		t.TrackConn(e)
+	}
+}
+
+var nextID atomic.Uint64
+
+// InstrumentedConn is a net.Conn that counts the bytes read and written and
+// reports its statistics to a Tracker when closed. If the Tracker is a
+// ByteTracker, it is told about bytes as they are read and written as well.
+type InstrumentedConn struct {
+	net.Conn
+
+	tracker Tracker
+	bytes   ByteTracker
+
+	read, written         atomic.Int64
+	readRate, writtenRate *Meter
+	closed                atomic.Bool
+	closeOnce             sync.Once
+
+	mu    sync.Mutex
+	entry StatsEntry
+}
+
+// NewInstrumentedConn returns an InstrumentedConn for conn, dialed with the
+// network and addr, that reports to t.
+func NewInstrumentedConn(conn net.Conn, network, addr string, t Tracker) *InstrumentedConn {
+	bt, _ := t.(ByteTracker)
+
+	return &InstrumentedConn{
+		Conn:        conn,
+		tracker:     t,
+		bytes:       bt,
+		readRate:    NewMeter(DefaultWindow),
+		writtenRate: NewMeter(DefaultWindow),
+		entry: StatsEntry{
+			ID:         nextID.Add(1),
+			Network:    network,
+			Addr:       addr,
+			Host:       hostOf(addr),
+			LocalAddr:  AddrString(conn.LocalAddr()),
+			RemoteAddr: AddrString(conn.RemoteAddr()),
+			Start:      time.Now(),
+		},
+	}
+}
+
+// NewAcceptedConn returns an InstrumentedConn for conn, accepted from a
+// client, that reports to t.
+func NewAcceptedConn(conn net.Conn, t Tracker) *InstrumentedConn {
+	ra := conn.RemoteAddr()
+
+	var network string
+	if ra != nil {
+		network = ra.Network()
+	}
+
+	c := NewInstrumentedConn(conn, network, AddrString(ra), t)
+	c.entry.Accepted = true
+
+	return c
+}
+
+// Read reads from the connection and counts the bytes read.
+func (c *InstrumentedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	c.readRate.Add(int64(n))
+	if c.bytes != nil && n > 0 {
+		c.bytes.TrackBytes(c, n, 0)
+	}
+	c.setErr(err)
+	return n, err
+}
+
+// Write writes to the connection and counts the bytes written.
+func (c *InstrumentedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	c.writtenRate.Add(int64(n))
+	if c.bytes != nil && n > 0 {
+		c.bytes.TrackBytes(c, 0, n)
+	}
+	c.setErr(err)
+	return n, err
+}
+
+// Close closes the connection and reports its statistics to the tracker on
+// the first call.
+func (c *InstrumentedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		e := c.Stats()
+		c.tracker.TrackConn(&e)
+	})
+	return err
+}
+
+// Stats returns the current statistics of the connection. Duration is the
+// time since the connection was established.
+func (c *InstrumentedConn) Stats() StatsEntry {
+	c.mu.Lock()
+	e := c.entry
+	c.mu.Unlock()
+
+	e.Duration = time.Since(e.Start)
+	e.BytesRead = c.read.Load()
+	e.BytesWritten = c.written.Load()
+
+	return e
+}
+
+// Throughput returns the rates in bytes per second read from and written to
+// the connection over DefaultWindow.
+func (c *InstrumentedConn) Throughput() (read, written float64) {
+	return c.readRate.Rate(), c.writtenRate.Rate()
+}
+
+func (c *InstrumentedConn) setErr(err error) {
+	if err == nil || errors.Is(err, io.EOF) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entry.Err == nil {
+		c.entry.Err = err
+	}
+}
+
+// Dialer returns a dial func that dials with dial and returns the connections
+// as InstrumentedConns reporting to t, with the DNS and Connect phases of the
+// dials recorded. Failed dials are reported to t as well.
+func Dialer(dial func(context.Context, string, string) (net.Conn, error), t Tracker) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dt := &dialTrace{}
+		start := time.Now()
+		conn, err := dial(withDialTrace(ctx, dt), network, addr)
+		dns, connect := dt.phases()
+		if err != nil {
+			t.TrackConn(&StatsEntry{
+				ID:       nextID.Add(1),
+				Network:  network,
+				Addr:     addr,
+				Host:     hostOf(addr),
+				Start:    start,
+				Duration: time.Since(start),
+				DNS:      dns,
+				Connect:  connect,
+				Err:      err,
+			})
+			return nil, err
+		}
+
+		c := NewInstrumentedConn(conn, network, addr, t)
+		c.entry.DNS = dns
+		c.entry.Connect = connect
+
+		return c, nil
+	}
+}
+
+// AddrString returns the string form of a, or "" if a is nil.
+func AddrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+// hostOf returns the host of the host:port addr, or addr if it has no port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
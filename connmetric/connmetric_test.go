@@ -0,0 +1,95 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package connmetric
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): got %v, want no error", err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, 4)
+		io.ReadFull(conn, b)
+		conn.Write([]byte("pong!"))
+	}()
+
+	var (
+		mu      sync.Mutex
+		entries []*StatsEntry
+	)
+	tr := TrackerFunc(func(e *StatsEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		entries = append(entries, e)
+	})
+
+	dial := Dialer((&net.Dialer{}).DialContext, tr)
+	conn, err := dial(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial(): got %v, want no error", err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("conn.Write(): got %v, want no error", err)
+	}
+	if _, err := ioutil.ReadAll(conn); err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	conn.Close()
+	conn.Close()
+
+	derr := errors.New("dial error")
+	failing := Dialer(func(context.Context, string, string) (net.Conn, error) {
+		return nil, derr
+	}, tr)
+	if _, err := failing(context.Background(), "tcp", "example.com:443"); err != derr {
+		t.Fatalf("dial(): got %v, want %v", err, derr)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if got, want := len(entries), 2; got != want {
+		t.Fatalf("len(entries): got %d, want %d", got, want)
+	}
+
+	e := entries[0]
+	if got, want := e.Host, "127.0.0.1"; got != want {
+		t.Errorf("Host: got %q, want %q", got, want)
+	}
+	if got, want := e.RemoteAddr, l.Addr().String(); got != want {
+		t.Errorf("RemoteAddr: got %q, want %q", got, want)
+	}
+	if e.BytesRead != 5 || e.BytesWritten != 4 {
+		t.Errorf("BytesRead, BytesWritten: got %d, %d, want 5, 4", e.BytesRead, e.BytesWritten)
+	}
+	if e.Err != nil {
+		t.Errorf("Err: got %v, want nil", e.Err)
+	}
+
+	e = entries[1]
+	if e.Host != "example.com" || e.Err != derr {
+		t.Errorf("failed dial: got host %q, error %v, want %q, %v", e.Host, e.Err, "example.com", derr)
+	}
+	if entries[0].ID == e.ID {
+		t.Errorf("ID: got %d for both connections, want distinct IDs", e.ID)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package connmetric
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWindow is the window over which throughput is measured.
+const DefaultWindow = 5 * time.Second
+
+// meterBuckets is the number of buckets a Meter divides its window into.
+const meterBuckets = 10
+
+// Meter measures the rate of bytes over a sliding window.
+type Meter struct {
+	mu      sync.Mutex
+	width   time.Duration
+	buckets [meterBuckets]int64
+	// last is the index of the current bucket, the time divided by width.
+	last int64
+
+	now func() time.Time
+}
+
+// NewMeter returns a Meter measuring the rate over window.
+func NewMeter(window time.Duration) *Meter {
+	width := window / meterBuckets
+	if width <= 0 {
+		width = 1
+	}
+
+	return &Meter{
+		width: width,
+		now:   time.Now,
+	}
+}
+
+// Add counts n bytes at the current time.
+func (m *Meter) Add(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.advance()
+	m.buckets[i%meterBuckets] += n
+}
+
+// Rate returns the rate in bytes per second over the window.
+func (m *Meter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advance()
+	var sum int64
+	for _, b := range m.buckets {
+		sum += b
+	}
+
+	return float64(sum) / (m.width * meterBuckets).Seconds()
+}
+
+// advance clears the buckets that have left the window and returns the index
+// of the current bucket. The caller must hold m.mu.
+func (m *Meter) advance() int64 {
+	i := m.now().UnixNano() / int64(m.width)
+	if i <= m.last {
+		return m.last
+	}
+
+	if i-m.last >= meterBuckets {
+		m.buckets = [meterBuckets]int64{}
+	} else {
+		for j := m.last + 1; j <= i; j++ {
+			m.buckets[j%meterBuckets] = 0
+		}
+	}
+	m.last = i
+
+	return i
+}
+
+// ByteTracker is a Tracker that is also told about bytes as they are read from
+// and written to InstrumentedConns, rather than only the totals when the
+// connections are closed.
+type ByteTracker interface {
+	Tracker
+
+	// TrackBytes is called after each read and write of c with the number of
+	// bytes read or written. It is called concurrently and must not block.
+	TrackBytes(c *InstrumentedConn, read, written int)
+}
+
+// ConnThroughput holds the throughput of an open connection.
+type ConnThroughput struct {
+	ID       uint64
+	Accepted bool
+	Host     string
+	// Read and Written are the rates in bytes per second over the window.
+	Read    float64
+	Written float64
+}
+
+// ThroughputTracker is a ByteTracker that measures the throughput of all
+// connections and of each open connection, over DefaultWindow, for real-time
+// bandwidth dashboards of long-lived connections such as tunnels.
+type ThroughputTracker struct {
+	read, written *Meter
+
+	mu    sync.Mutex
+	conns map[uint64]*InstrumentedConn
+}
+
+// NewThroughputTracker returns a new ThroughputTracker.
+func NewThroughputTracker() *ThroughputTracker {
+	return &ThroughputTracker{
+		read:    NewMeter(DefaultWindow),
+		written: NewMeter(DefaultWindow),
+		conns:   make(map[uint64]*InstrumentedConn),
+	}
+}
+
+// TrackBytes counts the bytes of c.
+func (t *ThroughputTracker) TrackBytes(c *InstrumentedConn, read, written int) {
+	t.read.Add(int64(read))
+	t.written.Add(int64(written))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Reads and writes may return after the connection was closed.
+	if !c.closed.Load() {
+		t.conns[c.entry.ID] = c
+	}
+}
+
+// TrackConn forgets the closed connection.
+func (t *ThroughputTracker) TrackConn(e *StatsEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, e.ID)
+}
+
+// Throughput returns the rates in bytes per second of all connections.
+func (t *ThroughputTracker) Throughput() (read, written float64) {
+	return t.read.Rate(), t.written.Rate()
+}
+
+// Conns returns the throughput of each open connection that has transferred
+// bytes, sorted by ID.
+func (t *ThroughputTracker) Conns() []ConnThroughput {
+	t.mu.Lock()
+	conns := make([]*InstrumentedConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+
+	ct := make([]ConnThroughput, 0, len(conns))
+	for _, c := range conns {
+		r, w := c.Throughput()
+		ct = append(ct, ConnThroughput{
+			ID:       c.entry.ID,
+			Accepted: c.entry.Accepted,
+			Host:     c.entry.Host,
+			Read:     r,
+			Written:  w,
+		})
+	}
+	sort.Slice(ct, func(i, j int) bool { return ct[i].ID < ct[j].ID })
+
+	return ct
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package connmetric
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := NewMeter(10 * time.Second)
+	m.now = func() time.Time { return now }
+
+	m.Add(100)
+	if got, want := m.Rate(), 10.0; got != want {
+		t.Errorf("m.Rate(): got %v, want %v", got, want)
+	}
+
+	now = now.Add(5 * time.Second)
+	m.Add(50)
+	if got, want := m.Rate(), 15.0; got != want {
+		t.Errorf("m.Rate(): got %v, want %v", got, want)
+	}
+
+	// The first 100 bytes leave the window.
+	now = now.Add(6 * time.Second)
+	if got, want := m.Rate(), 5.0; got != want {
+		t.Errorf("m.Rate(): got %v, want %v", got, want)
+	}
+
+	now = now.Add(time.Minute)
+	if got, want := m.Rate(), 0.0; got != want {
+		t.Errorf("m.Rate(): got %v, want %v", got, want)
+	}
+}
+
+func TestThroughputTracker(t *testing.T) {
+	tt := NewThroughputTracker()
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		b := make([]byte, 4)
+		io.ReadFull(server, b)
+		server.Write([]byte("pong!"))
+	}()
+
+	conn := NewInstrumentedConn(client, "tcp", "example.com:443", tt)
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("conn.Write(): got %v, want no error", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatalf("io.ReadFull(): got %v, want no error", err)
+	}
+
+	window := DefaultWindow.Seconds()
+	r, w := tt.Throughput()
+	if got, want := r, 5/window; got != want {
+		t.Errorf("tt.Throughput(): got read %v, want %v", got, want)
+	}
+	if got, want := w, 4/window; got != want {
+		t.Errorf("tt.Throughput(): got written %v, want %v", got, want)
+	}
+
+	conns := tt.Conns()
+	if got, want := len(conns), 1; got != want {
+		t.Fatalf("len(tt.Conns()): got %d, want %d", got, want)
+	}
+	ct := conns[0]
+	if got, want := ct.Host, "example.com"; got != want {
+		t.Errorf("ct.Host: got %q, want %q", got, want)
+	}
+	if got, want := ct.Read, 5/window; got != want {
+		t.Errorf("ct.Read: got %v, want %v", got, want)
+	}
+	if got, want := ct.Written, 4/window; got != want {
+		t.Errorf("ct.Written: got %v, want %v", got, want)
+	}
+
+	conn.Close()
+	if got := tt.Conns(); len(got) != 0 {
+		t.Errorf("tt.Conns(): got %v, want none after close", got)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package connmetric
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// dialTrace records the DNS resolution and TCP connect phases of a dial.
+type dialTrace struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dns          time.Duration
+	connectStart time.Time
+	connect      time.Duration
+}
+
+// withDialTrace returns ctx with a trace recording the phases of dials with
+// it into dt. Only dials by a net.Dialer report their phases.
+func withDialTrace(ctx context.Context, dt *dialTrace) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dt.mu.Lock()
+			defer dt.mu.Unlock()
+
+			dt.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			dt.mu.Lock()
+			defer dt.mu.Unlock()
+
+			if !dt.dnsStart.IsZero() {
+				dt.dns = time.Since(dt.dnsStart)
+			}
+		},
+		// Connects to several addresses may race, the first to start and
+		// the first to succeed are the ones timed.
+		ConnectStart: func(string, string) {
+			dt.mu.Lock()
+			defer dt.mu.Unlock()
+
+			if dt.connectStart.IsZero() {
+				dt.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			dt.mu.Lock()
+			defer dt.mu.Unlock()
+
+			if err == nil && dt.connect == 0 && !dt.connectStart.IsZero() {
+				dt.connect = time.Since(dt.connectStart)
+			}
+		},
+	})
+}
+
+func (dt *dialTrace) phases() (dns, connect time.Duration) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	return dt.dns, dt.connect
+}
+
+// SetTLSHandshake sets the duration of the TLS handshake performed over the
+// connection, reported as StatsEntry.TLSHandshake.
+func (c *InstrumentedConn) SetTLSHandshake(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entry.TLSHandshake = d
+}
+
+// NetConn returns the underlying connection.
+func (c *InstrumentedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Unwrap returns the InstrumentedConn underlying conn, such as that of a
+// *tls.Conn, following connections with a NetConn method returning the
+// connection they wrap. It returns nil if there is none.
+func Unwrap(conn net.Conn) *InstrumentedConn {
+	for conn != nil {
+		switch c := conn.(type) {
+		case *InstrumentedConn:
+			return c
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// TLSHandshake performs the TLS handshake of tlsconn with ctx and sets its
+// duration on the InstrumentedConn underlying tlsconn, if any.
+func TLSHandshake(ctx context.Context, tlsconn *tls.Conn) error {
+	start := time.Now()
+	err := tlsconn.HandshakeContext(ctx)
+	if c := Unwrap(tlsconn); c != nil {
+		c.SetTLSHandshake(time.Since(start))
+	}
+
+	return err
+}
+
+// WithTLSTrace returns ctx with a trace that sets the duration of the TLS
+// handshakes performed by an http.Transport on the InstrumentedConns
+// underlying the new connections of requests with the context.
+func WithTLSTrace(ctx context.Context) context.Context {
+	var (
+		mu    sync.Mutex
+		start time.Time
+		d     time.Duration
+	)
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			start = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			d = time.Since(start)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if info.Reused || d == 0 {
+				return
+			}
+			if c := Unwrap(info.Conn); c != nil {
+				c.SetTLSHandshake(d)
+			}
+		},
+	})
+}
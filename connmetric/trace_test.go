@@ -0,0 +1,76 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package connmetric
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDialerPhases(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(204)
+	}))
+	defer srv.Close()
+
+	entries := make(chan *StatsEntry, 1)
+	tr := srv.Client().Transport.(*http.Transport)
+	tr.DialContext = Dialer((&net.Dialer{}).DialContext, TrackerFunc(func(e *StatsEntry) {
+		entries <- e
+	}))
+	tr.TLSClientConfig.ServerName = "example.com"
+
+	// Dial by name to resolve the host.
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	req, err := http.NewRequestWithContext(WithTLSTrace(context.Background()), "GET", url, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("tr.RoundTrip(): got %v, want no error", err)
+	}
+	res.Body.Close()
+	tr.CloseIdleConnections()
+
+	e := <-entries
+	if e.DNS <= 0 {
+		t.Errorf("e.DNS: got %v, want > 0", e.DNS)
+	}
+	if e.Connect <= 0 {
+		t.Errorf("e.Connect: got %v, want > 0", e.Connect)
+	}
+	if e.TLSHandshake <= 0 {
+		t.Errorf("e.TLSHandshake: got %v, want > 0", e.TLSHandshake)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	c := NewInstrumentedConn(client, "tcp", "example.com:80", TrackerFunc(func(*StatsEntry) {}))
+	defer c.Close()
+
+	if got := Unwrap(c); got != c {
+		t.Errorf("Unwrap(c): got %v, want %v", got, c)
+	}
+	if got := Unwrap(wrapped{c}); got != c {
+		t.Errorf("Unwrap(wrapped): got %v, want %v", got, c)
+	}
+	if got := Unwrap(client); got != nil {
+		t.Errorf("Unwrap(client): got %v, want nil", got)
+	}
+}
+
+type wrapped struct {
+	net.Conn
+}
+
+func (w wrapped) NetConn() net.Conn {
+	return w.Conn
+}
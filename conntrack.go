@@ -0,0 +1,34 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"net"
+
+	"github.com/google/martian/v3/connmetric"
+	"github.com/google/martian/v3/trafficshape"
+)
+
+// SetConnTracker sets the tracker receiving the statistics of the client
+// connections accepted by Serve and of the upstream connections dialed by the
+// proxy, including those of CONNECT tunnels, which are wrapped in
+// connmetric.InstrumentedConns. Client connections are reported with
+// Accepted set. Traffic shaped connections are not tracked. It must be called
+// before Serve.
+func (p *Proxy) SetConnTracker(t connmetric.Tracker) {
+	p.connTracker = t
+}
+
+// trackConn returns the accepted conn wrapped so that it is reported to the
+// conn tracker, or conn if there is none.
+func (p *Proxy) trackConn(conn net.Conn) net.Conn {
+	if p.connTracker == nil {
+		return conn
+	}
+	// Traffic shaping relies on the type of the connection.
+	if _, ok := conn.(*trafficshape.Conn); ok {
+		return conn
+	}
+
+	return connmetric.NewAcceptedConn(conn, p.connTracker)
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/connmetric"
+)
+
+func TestIntegrationConnTracker(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	l := newListener(t)
+	p := NewProxy()
+	defer p.Close()
+
+	entries := make(chan *connmetric.StatsEntry, 2)
+	p.SetConnTracker(connmetric.TrackerFunc(func(e *connmetric.StatsEntry) {
+		entries <- e
+	}))
+
+	ul, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): got %v, want no error", err)
+	}
+	defer ul.Close()
+
+	go func() {
+		conn, err := ul.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, 4)
+		io.ReadFull(conn, b)
+		conn.Write([]byte("pong!"))
+	}()
+
+	go serve(p, l)
+
+	conn, err := l.dial()
+	if err != nil {
+		t.Fatalf("net.Dial(): got %v, want no error", err)
+	}
+	defer conn.Close()
+
+	req, err := http.NewRequest("CONNECT", "//"+ul.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("req.Write(): got %v, want no error", err)
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, 200; got != want {
+		t.Fatalf("res.StatusCode: got %d, want %d", got, want)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("conn.Write(): got %v, want no error", err)
+	}
+	got, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "pong!"; string(got) != want {
+		t.Errorf("tunnel response: got %q, want %q", got, want)
+	}
+	conn.Close()
+
+	var client, upstream *connmetric.StatsEntry
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-entries:
+			if e.Accepted {
+				client = e
+			} else {
+				upstream = e
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TrackConn: got %d calls, want 2", i)
+		}
+	}
+
+	if client == nil {
+		t.Fatal("client connection: not tracked")
+	}
+	if got, want := client.Addr, conn.LocalAddr().String(); got != want {
+		t.Errorf("client.Addr: got %q, want %q", got, want)
+	}
+	if client.BytesWritten == 0 {
+		t.Error("client.BytesWritten: got 0, want > 0")
+	}
+
+	if upstream == nil {
+		t.Fatal("upstream connection: not tracked")
+	}
+	if got, want := upstream.Addr, ul.Addr().String(); got != want {
+		t.Errorf("upstream.Addr: got %q, want %q", got, want)
+	}
+	if got, want := upstream.BytesWritten, int64(4); got != want {
+		t.Errorf("upstream.BytesWritten: got %d, want %d", got, want)
+	}
+	if got, want := upstream.BytesRead, int64(5); got != want {
+		t.Errorf("upstream.BytesRead: got %d, want %d", got, want)
+	}
+}
+
+func TestIntegrationConnTrackerTransparentMITM(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	testTransparentMITM(t, func(p *Proxy) {
+		p.SetConnTracker(connmetric.TrackerFunc(func(*connmetric.StatsEntry) {}))
+	})
+}
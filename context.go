@@ -17,6 +17,7 @@ package martian
 import (
 	"bufio"
 	"context"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
@@ -24,6 +25,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/google/martian/v3/log"
 )
 
 // Context provides information and storage for a single request/response pair.
@@ -38,10 +41,16 @@ type Context struct {
 	skipRoundTrip bool
 	skipLogging   bool
 	apiRequest    bool
+
+	followRedirects *int
+
+	logOnce sync.Once
+	log     log.Logger
 }
 
 // Session provides information and storage about a connection.
 type Session struct {
+	id       uint64
 	mu       sync.RWMutex
 	secure   bool
 	hijacked bool
@@ -49,6 +58,12 @@ type Session struct {
 	brw      *bufio.ReadWriter
 	rw       http.ResponseWriter
 	vals     map[string]any
+
+	clientCert  *x509.Certificate
+	connectHost string
+	labels      map[string]string
+	headerLimit *headerLimitReader
+	wireConns   []*wireConn
 }
 
 const marianKey string = "martian.Context"
@@ -76,6 +91,11 @@ func TestContext(req *http.Request, conn net.Conn, bw *bufio.ReadWriter) *Contex
 	return ctx
 }
 
+// ID returns the session ID.
+func (s *Session) ID() string {
+	return strconv.FormatUint(s.id, 16)
+}
+
 // IsSecure returns whether the current session is from a secure connection,
 // such as when receiving requests from a TLS connection that has been MITM'd.
 func (s *Session) IsSecure() bool {
@@ -101,6 +121,78 @@ func (s *Session) MarkInsecure() {
 	s.secure = false
 }
 
+// ClientCertificate returns the verified certificate presented by the client
+// on a MITM'd TLS connection, or nil if the client did not present a
+// certificate or it was not verified.
+func (s *Session) ClientCertificate() *x509.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.clientCert
+}
+
+// SetClientCertificate sets the verified client certificate of the session.
+func (s *Session) SetClientCertificate(cert *x509.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clientCert = cert
+}
+
+// ConnectHost returns the host of the CONNECT request that established the
+// MITM'd tunnel of the session, or an empty string if the session is not a
+// MITM'd tunnel.
+func (s *Session) ConnectHost() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.connectHost
+}
+
+// SetConnectHost sets the host of the CONNECT request of the session.
+func (s *Session) SetConnectHost(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connectHost = host
+}
+
+// Labels returns a copy of the labels of the session, which describe the
+// client or test run, such as "device" or "build". Loggers, metrics and
+// webhooks record them as dimensions of the requests of the session.
+func (s *Session) Labels() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.labels) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(s.labels))
+	for k, v := range s.labels {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+// SetLabel sets the label key of the session to value. An empty value removes
+// the label.
+func (s *Session) SetLabel(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value == "" {
+		delete(s.labels, key)
+		return
+	}
+
+	if s.labels == nil {
+		s.labels = make(map[string]string)
+	}
+	s.labels[key] = value
+}
+
 // Hijack takes control of the connection from the proxy. No further action
 // will be taken by the proxy and the connection will be closed following the
 // return of the hijacker.
@@ -188,6 +280,7 @@ func (ctx *Context) addToContext(rctx context.Context) context.Context {
 	if rctx == nil {
 		rctx = context.Background()
 	}
+	rctx = log.NewContext(rctx, ctx.logger())
 	return context.WithValue(rctx, marianKey, ctx)
 }
 
@@ -249,6 +342,19 @@ func (ctx *Context) SkipLogging() {
 	ctx.skipLogging = true
 }
 
+// logger returns the logger for messages about the request / response pair,
+// which adds the session and context IDs to the records, so that the messages
+// of concurrent requests can be correlated. It is carried by the contexts of
+// requests, see log.FromContext. It is built once and reused, as it is used on
+// the hot path of every request.
+func (ctx *Context) logger() log.Logger {
+	ctx.logOnce.Do(func() {
+		ctx.log = log.With("session", ctx.session.ID(), "context", ctx.ID())
+	})
+
+	return ctx.log
+}
+
 // SkippingLogging returns whether the current request / response pair will be logged.
 func (ctx *Context) SkippingLogging() bool {
 	ctx.mu.RLock()
@@ -278,6 +384,7 @@ func (ctx *Context) IsAPIRequest() bool {
 // newSession builds a new session from a [net.Conn].
 func newSession(conn net.Conn, brw *bufio.ReadWriter) *Session {
 	return &Session{
+		id:   nextID.Add(1),
 		conn: conn,
 		brw:  brw,
 	}
@@ -286,6 +393,7 @@ func newSession(conn net.Conn, brw *bufio.ReadWriter) *Session {
 // newSessionWithResponseWriter builds a new session from a [http.ResponseWriter].
 func newSessionWithResponseWriter(rw http.ResponseWriter) *Session {
 	return &Session{
+		id: nextID.Add(1),
 		rw: rw,
 	}
 }
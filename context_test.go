@@ -64,6 +64,24 @@ func TestContexts(t *testing.T) {
 		t.Errorf("s.Get(%q): got %q, want %q", "key", got, want)
 	}
 
+	if labels := s.Labels(); labels != nil {
+		t.Errorf("s.Labels(): got %v, want nil", labels)
+	}
+	s.SetLabel("device", "pixel7")
+	s.SetLabel("build", "1234")
+	s.SetLabel("build", "")
+	labels := s.Labels()
+	if got, want := len(labels), 1; got != want {
+		t.Errorf("len(s.Labels()): got %d, want %d", got, want)
+	}
+	if got, want := labels["device"], "pixel7"; got != want {
+		t.Errorf("s.Labels()[%q]: got %q, want %q", "device", got, want)
+	}
+	labels["device"] = "changed"
+	if got, want := s.Labels()["device"], "pixel7"; got != want {
+		t.Errorf("s.Labels()[%q] after modifying copy: got %q, want %q", "device", got, want)
+	}
+
 	ctx2 := TestContext(req, nil, nil)
 	if ctx != ctx2 {
 		t.Error("TestContext(): got new context, want existing context")
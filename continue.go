@@ -0,0 +1,104 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ExpectContinueMode determines how requests with "Expect: 100-continue"
+// are handled.
+type ExpectContinueMode int
+
+const (
+	// ExpectContinueRelay forwards the Expect header to the origin, which
+	// decides whether the client continues.
+	ExpectContinueRelay ExpectContinueMode = iota
+	// ExpectContinueImmediate answers 100 Continue to the client as soon as
+	// the request headers are read and forwards the request without the
+	// Expect header.
+	ExpectContinueImmediate
+	// ExpectContinueBuffer answers 100 Continue to the client, reads the entire
+	// request body and forwards the request without the Expect header and with
+	// a Content-Length. Requests with bodies larger than
+	// Proxy.MaxBufferedBodyBytes are answered with 413 Content Too Large.
+	ExpectContinueBuffer
+)
+
+// String returns the name of the mode.
+func (m ExpectContinueMode) String() string {
+	switch m {
+	case ExpectContinueRelay:
+		return "relay"
+	case ExpectContinueImmediate:
+		return "immediate"
+	case ExpectContinueBuffer:
+		return "buffer"
+	default:
+		return fmt.Sprintf("ExpectContinueMode(%d)", int(m))
+	}
+}
+
+// ParseExpectContinueMode returns the mode named s, one of "relay",
+// "immediate" or "buffer".
+func ParseExpectContinueMode(s string) (ExpectContinueMode, error) {
+	for _, m := range []ExpectContinueMode{ExpectContinueRelay, ExpectContinueImmediate, ExpectContinueBuffer} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+
+	return 0, fmt.Errorf("martian: unknown expect continue mode %q", s)
+}
+
+// expectContinue applies p.ExpectContinue to req, calling writeContinue to
+// send 100 Continue to the client. It returns errBodyTooLarge if the body
+// to buffer exceeds p.MaxBufferedBodyBytes.
+func (p *Proxy) expectContinue(req *http.Request, writeContinue func() error) error {
+	if p.ExpectContinue == ExpectContinueRelay ||
+		!strings.EqualFold(req.Header.Get("Expect"), "100-continue") ||
+		!req.ProtoAtLeast(1, 1) {
+		return nil
+	}
+
+	max := p.maxBufferedBodyBytes()
+	if p.ExpectContinue == ExpectContinueBuffer && req.ContentLength > max {
+		// The client has not sent the body yet, reject it before it does.
+		return errBodyTooLarge
+	}
+
+	req.Header.Del("Expect")
+	if err := writeContinue(); err != nil {
+		return fmt.Errorf("writing 100 Continue: %w", err)
+	}
+
+	if p.ExpectContinue != ExpectContinueBuffer {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+	if err != nil {
+		return fmt.Errorf("reading request body: %w", err)
+	}
+	if int64(len(body)) > max {
+		return errBodyTooLarge
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return nil
+}
+
+// writeContinue writes a 100 Continue response to w.
+func writeContinue(w io.Writer) error {
+	_, err := io.WriteString(w, "HTTP/1.1 100 Continue\r\n\r\n")
+	return err
+}
@@ -0,0 +1,224 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package cookie
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("cookie.Attributes", attributeModifierFromJSON)
+}
+
+// AttributeModifier is a response modifier that rewrites the attributes of
+// the cookies set by responses. It can force or strip the Secure, HttpOnly,
+// SameSite and Domain attributes, for example to test how clients handle
+// insecure cookies or to make production cookies work on a local test domain.
+// Other attributes, and the name and value of cookies, are kept as sent.
+type AttributeModifier struct {
+	name *regexp.Regexp
+
+	secure   *bool
+	httpOnly *bool
+	sameSite *string
+	domain   *string
+}
+
+type attributeModifierJSON struct {
+	Name     string               `json:"name"`
+	Secure   *bool                `json:"secure"`
+	HTTPOnly *bool                `json:"httpOnly"`
+	SameSite *string              `json:"sameSite"`
+	Domain   *string              `json:"domain"`
+	Scope    []parse.ModifierType `json:"scope"`
+}
+
+// NewAttributeModifier returns an AttributeModifier that leaves all
+// attributes unchanged.
+func NewAttributeModifier() *AttributeModifier {
+	return &AttributeModifier{}
+}
+
+// SetName restricts the modifier to the cookies whose name matches re.
+func (m *AttributeModifier) SetName(re *regexp.Regexp) {
+	m.name = re
+}
+
+// SetSecure forces the Secure attribute if secure is true and strips it
+// otherwise.
+func (m *AttributeModifier) SetSecure(secure bool) {
+	m.secure = &secure
+}
+
+// SetHTTPOnly forces the HttpOnly attribute if httpOnly is true and strips it
+// otherwise.
+func (m *AttributeModifier) SetHTTPOnly(httpOnly bool) {
+	m.httpOnly = &httpOnly
+}
+
+// SetSameSite sets the SameSite attribute to Strict, Lax or None, or strips
+// it if sameSite is empty.
+func (m *AttributeModifier) SetSameSite(sameSite string) error {
+	switch strings.ToLower(sameSite) {
+	case "":
+	case "strict":
+		sameSite = "Strict"
+	case "lax":
+		sameSite = "Lax"
+	case "none":
+		sameSite = "None"
+	default:
+		return fmt.Errorf("cookie: unknown SameSite value %q", sameSite)
+	}
+
+	m.sameSite = &sameSite
+	return nil
+}
+
+// SetDomain sets the Domain attribute to domain, or strips it if domain is
+// empty, which makes the cookies host-only.
+func (m *AttributeModifier) SetDomain(domain string) {
+	m.domain = &domain
+}
+
+// ModifyResponse rewrites the attributes of the Set-Cookie headers of res.
+func (m *AttributeModifier) ModifyResponse(res *http.Response) error {
+	values := res.Header.Values("Set-Cookie")
+	if len(values) == 0 {
+		return nil
+	}
+
+	rewritten := make([]string, len(values))
+	for i, v := range values {
+		rewritten[i] = m.rewrite(v)
+	}
+
+	res.Header.Del("Set-Cookie")
+	for _, v := range rewritten {
+		res.Header.Add("Set-Cookie", v)
+	}
+
+	return nil
+}
+
+// rewrite returns the Set-Cookie value v with the attributes of the modifier.
+// It works on the text of v, rather than on http.Cookie, so that attributes
+// unknown to net/http survive.
+func (m *AttributeModifier) rewrite(v string) string {
+	parts := strings.Split(v, ";")
+
+	pair := strings.TrimSpace(parts[0])
+	name, _, ok := strings.Cut(pair, "=")
+	if !ok {
+		return v
+	}
+	if m.name != nil && !m.name.MatchString(strings.TrimSpace(name)) {
+		return v
+	}
+
+	attrs := []string{pair}
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		attr, _, _ := strings.Cut(p, "=")
+		switch strings.ToLower(strings.TrimSpace(attr)) {
+		case "secure":
+			if m.secure != nil {
+				continue
+			}
+		case "httponly":
+			if m.httpOnly != nil {
+				continue
+			}
+		case "samesite":
+			if m.sameSite != nil {
+				continue
+			}
+		case "domain":
+			if m.domain != nil {
+				continue
+			}
+		}
+		attrs = append(attrs, p)
+	}
+
+	if m.domain != nil && *m.domain != "" {
+		attrs = append(attrs, "Domain="+*m.domain)
+	}
+	if m.secure != nil && *m.secure {
+		attrs = append(attrs, "Secure")
+	}
+	if m.httpOnly != nil && *m.httpOnly {
+		attrs = append(attrs, "HttpOnly")
+	}
+	if m.sameSite != nil && *m.sameSite != "" {
+		attrs = append(attrs, "SameSite="+*m.sameSite)
+	}
+
+	s := strings.Join(attrs, "; ")
+	if s != v {
+		log.Debugf("cookie.AttributeModifier.ModifyResponse: cookie: %s", strings.TrimSpace(name))
+	}
+
+	return s
+}
+
+// attributeModifierFromJSON builds a cookie.Attributes modifier from JSON.
+// "name" is a regular expression restricting the cookies that are rewritten.
+// "secure" and "httpOnly" force their attribute if true and strip it if
+// false. "sameSite" sets the SameSite attribute to Strict, Lax or None, and
+// "domain" sets the Domain attribute; either is stripped if set to "".
+// Attributes that are not set are left unchanged.
+//
+// Example JSON:
+//
+//	{
+//	  "cookie.Attributes": {
+//	    "scope": ["response"],
+//	    "name": "^session",
+//	    "secure": false,
+//	    "sameSite": "Lax",
+//	    "domain": "localhost"
+//	  }
+//	}
+func attributeModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &attributeModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewAttributeModifier()
+	if msg.Name != "" {
+		re, err := regexp.Compile(msg.Name)
+		if err != nil {
+			return nil, fmt.Errorf("cookie: invalid name %q: %w", msg.Name, err)
+		}
+		mod.SetName(re)
+	}
+	if msg.Secure != nil {
+		mod.SetSecure(*msg.Secure)
+	}
+	if msg.HTTPOnly != nil {
+		mod.SetHTTPOnly(*msg.HTTPOnly)
+	}
+	if msg.SameSite != nil {
+		if err := mod.SetSameSite(*msg.SameSite); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Domain != nil {
+		mod.SetDomain(*msg.Domain)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,185 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package cookie
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestAttributeModifier(t *testing.T) {
+	tt := []struct {
+		name string
+		set  func(m *AttributeModifier)
+		in   string
+		want string
+	}{
+		{
+			name: "unchanged",
+			set:  func(m *AttributeModifier) {},
+			in:   "id=1; Path=/; Secure",
+			want: "id=1; Path=/; Secure",
+		},
+		{
+			name: "force secure and httponly",
+			set: func(m *AttributeModifier) {
+				m.SetSecure(true)
+				m.SetHTTPOnly(true)
+			},
+			in:   "id=1; Path=/; secure",
+			want: "id=1; Path=/; Secure; HttpOnly",
+		},
+		{
+			name: "strip secure and httponly",
+			set: func(m *AttributeModifier) {
+				m.SetSecure(false)
+				m.SetHTTPOnly(false)
+			},
+			in:   "id=1; Secure; HttpOnly; Partitioned",
+			want: "id=1; Partitioned",
+		},
+		{
+			name: "samesite",
+			set: func(m *AttributeModifier) {
+				if err := m.SetSameSite("lax"); err != nil {
+					t.Fatalf("SetSameSite(): got %v, want no error", err)
+				}
+			},
+			in:   "id=1; SameSite=None; Secure",
+			want: "id=1; Secure; SameSite=Lax",
+		},
+		{
+			name: "strip samesite",
+			set: func(m *AttributeModifier) {
+				if err := m.SetSameSite(""); err != nil {
+					t.Fatalf("SetSameSite(): got %v, want no error", err)
+				}
+			},
+			in:   "id=1; SameSite=Strict",
+			want: "id=1",
+		},
+		{
+			name: "domain",
+			set:  func(m *AttributeModifier) { m.SetDomain("localhost") },
+			in:   "id=1; Domain=.example.com; Path=/",
+			want: "id=1; Path=/; Domain=localhost",
+		},
+		{
+			name: "strip domain",
+			set:  func(m *AttributeModifier) { m.SetDomain("") },
+			in:   "id=1; Domain=example.com",
+			want: "id=1",
+		},
+		{
+			name: "name mismatch",
+			set: func(m *AttributeModifier) {
+				m.SetName(regexp.MustCompile("^session"))
+				m.SetSecure(true)
+			},
+			in:   "id=1",
+			want: "id=1",
+		},
+		{
+			name: "name match",
+			set: func(m *AttributeModifier) {
+				m.SetName(regexp.MustCompile("^session"))
+				m.SetSecure(true)
+			},
+			in:   "session_id=1",
+			want: "session_id=1; Secure",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mod := NewAttributeModifier()
+			tc.set(mod)
+
+			res := proxyutil.NewResponse(200, nil, nil)
+			res.Header.Set("Set-Cookie", tc.in)
+			if err := mod.ModifyResponse(res); err != nil {
+				t.Fatalf("ModifyResponse(): got %v, want no error", err)
+			}
+			if got := res.Header.Get("Set-Cookie"); got != tc.want {
+				t.Errorf("Set-Cookie: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAttributeModifierMultipleCookies(t *testing.T) {
+	mod := NewAttributeModifier()
+	mod.SetSecure(true)
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	res.Header.Add("Set-Cookie", "a=1")
+	res.Header.Add("Set-Cookie", "b=2; Secure")
+	res.Header.Add("Set-Cookie", "invalid")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	want := []string{"a=1; Secure", "b=2; Secure", "invalid"}
+	if got := res.Header.Values("Set-Cookie"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Set-Cookie: got %q, want %q", got, want)
+	}
+
+	cookies := res.Cookies()
+	if got, want := len(cookies), 2; got != want {
+		t.Fatalf("len(res.Cookies()): got %d, want %d", got, want)
+	}
+	for _, c := range cookies {
+		if !c.Secure {
+			t.Errorf("%s.Secure: got false, want true", c.Name)
+		}
+	}
+}
+
+func TestAttributeModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"cookie.Attributes": {
+			"scope": ["response"],
+			"name": "^session",
+			"secure": false,
+			"httpOnly": true,
+			"sameSite": "None",
+			"domain": ""
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("r.ResponseModifier(): got nil, want not nil")
+	}
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	res.Header.Add("Set-Cookie", "session=1; Domain=example.com; Secure; Path=/")
+	res.Header.Add("Set-Cookie", "other=1; Secure")
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	want := []string{"session=1; Path=/; HttpOnly; SameSite=None", "other=1; Secure"}
+	if got := res.Header.Values("Set-Cookie"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Set-Cookie: got %q, want %q", got, want)
+	}
+
+	for _, msg := range []string{
+		`{"cookie.Attributes": {"scope": ["response"], "sameSite": "Loose"}}`,
+		`{"cookie.Attributes": {"scope": ["response"], "name": "("}}`,
+		`{"cookie.Attributes": {"scope": ["request"], "secure": true}}`,
+	} {
+		if _, err := parse.FromJSON([]byte(msg)); err == nil {
+			t.Errorf("parse.FromJSON(%s): got nil, want error", msg)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package cookie
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+// DefaultJarIdleTimeout is the default time after which the jar of a client
+// that sent no requests is dropped.
+const DefaultJarIdleTimeout = time.Hour
+
+func init() {
+	parse.Register("cookie.Jar", jarModifierFromJSON)
+}
+
+// JarModifier keeps cookie jars inside the proxy, so that clients that do not
+// handle cookies, such as scripts, can traverse login flows. It stores the
+// cookies set by responses and adds the matching cookies to later requests of
+// the same client, following the domain, path, expiry and secure rules of
+// browsers. Cookies that a request already carries take precedence.
+//
+// Requests share a jar by their key:
+//
+//	client       the IP address of the client
+//	session      the session of the request, that is its connection
+//	header:Name  the value of the header Name
+type JarModifier struct {
+	key         string
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	jars  map[string]*jar
+	swept time.Time
+}
+
+type jar struct {
+	*cookiejar.Jar
+	used time.Time
+}
+
+type jarModifierJSON struct {
+	Key           string               `json:"key"`
+	IdleTimeoutMs int64                `json:"idleTimeoutMs"`
+	Scope         []parse.ModifierType `json:"scope"`
+}
+
+// NewJarModifier returns a JarModifier that shares jars between the requests
+// with the same key.
+func NewJarModifier(key string) (*JarModifier, error) {
+	switch {
+	case key == "client", key == "session":
+	case strings.HasPrefix(key, "header:") && len(key) > len("header:"):
+	default:
+		return nil, fmt.Errorf("cookie: unknown jar key %q", key)
+	}
+
+	return &JarModifier{
+		key:         key,
+		idleTimeout: DefaultJarIdleTimeout,
+		jars:        make(map[string]*jar),
+	}, nil
+}
+
+// SetIdleTimeout sets the time after which the jar of a key that was not
+// used is dropped. A timeout of zero keeps jars until Reset.
+func (m *JarModifier) SetIdleTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.idleTimeout = timeout
+}
+
+// Reset drops all jars.
+func (m *JarModifier) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jars = make(map[string]*jar)
+}
+
+// ModifyRequest adds the cookies of the jar of req that match its URL and
+// that it does not already carry.
+func (m *JarModifier) ModifyRequest(req *http.Request) error {
+	j := m.jar(req)
+	if j == nil {
+		return nil
+	}
+
+	have := make(map[string]bool)
+	for _, c := range req.Cookies() {
+		have[c.Name] = true
+	}
+
+	for _, c := range j.Cookies(req.URL) {
+		if have[c.Name] {
+			continue
+		}
+		req.AddCookie(c)
+		log.Debugf("cookie.JarModifier.ModifyRequest: %s: cookie: %s", req.URL, c.Name)
+	}
+
+	return nil
+}
+
+// ModifyResponse stores the cookies set by res in the jar of its request.
+func (m *JarModifier) ModifyResponse(res *http.Response) error {
+	if res.Request == nil {
+		return nil
+	}
+
+	cookies := res.Cookies()
+	if len(cookies) == 0 {
+		return nil
+	}
+
+	j := m.jar(res.Request)
+	if j == nil {
+		return nil
+	}
+	j.SetCookies(res.Request.URL, cookies)
+
+	return nil
+}
+
+// jar returns the jar of req, creating it if needed, or nil if req has no
+// key.
+func (m *JarModifier) jar(req *http.Request) *jar {
+	key := m.keyOf(req)
+	if key == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.idleTimeout > 0 && now.Sub(m.swept) >= m.idleTimeout {
+		for k, j := range m.jars {
+			if now.Sub(j.used) >= m.idleTimeout {
+				delete(m.jars, k)
+			}
+		}
+		m.swept = now
+	}
+
+	j, ok := m.jars[key]
+	if !ok {
+		// cookiejar.New only fails for invalid options.
+		cj, _ := cookiejar.New(nil)
+		j = &jar{Jar: cj}
+		m.jars[key] = j
+	}
+	j.used = now
+
+	return j
+}
+
+func (m *JarModifier) keyOf(req *http.Request) string {
+	switch {
+	case m.key == "client":
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+		return host
+	case m.key == "session":
+		ctx := martian.NewContext(req)
+		if ctx == nil {
+			return ""
+		}
+		return ctx.Session().ID()
+	default:
+		return req.Header.Get(strings.TrimPrefix(m.key, "header:"))
+	}
+}
+
+// jarModifierFromJSON builds a cookie.Jar modifier from JSON. "key" is how
+// requests share jars, by default client. "idleTimeoutMs" overrides the time
+// after which unused jars are dropped.
+//
+// Example JSON:
+//
+//	{
+//	  "cookie.Jar": {
+//	    "scope": ["request", "response"],
+//	    "key": "header:X-Test-Run",
+//	    "idleTimeoutMs": 600000
+//	  }
+//	}
+func jarModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &jarModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if msg.IdleTimeoutMs < 0 {
+		return nil, errors.New("cookie: idleTimeoutMs must not be negative")
+	}
+
+	key := msg.Key
+	if key == "" {
+		key = "client"
+	}
+	mod, err := NewJarModifier(key)
+	if err != nil {
+		return nil, err
+	}
+	if msg.IdleTimeoutMs > 0 {
+		mod.SetIdleTimeout(time.Duration(msg.IdleTimeoutMs) * time.Millisecond)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package cookie
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+// exchange runs mod on a request from addr to url, with a response setting
+// the cookies setCookies, and returns the Cookie header of the request.
+func exchange(t *testing.T, mod *JarModifier, addr, url string, setCookies ...string) string {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.RemoteAddr = addr
+
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	for _, c := range setCookies {
+		res.Header.Add("Set-Cookie", c)
+	}
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	return req.Header.Get("Cookie")
+}
+
+func TestJarModifier(t *testing.T) {
+	mod, err := NewJarModifier("client")
+	if err != nil {
+		t.Fatalf("NewJarModifier(): got %v, want no error", err)
+	}
+
+	const alice, bob = "192.0.2.1:1234", "192.0.2.2:1234"
+
+	if got := exchange(t, mod, alice, "http://example.com/login", "session=abc; Path=/", "tmp=1; Max-Age=-1", "admin=1; Path=/admin"); got != "" {
+		t.Errorf("Cookie: got %q, want none", got)
+	}
+
+	tt := []struct {
+		addr, url, want string
+	}{
+		{alice, "http://example.com/home", "session=abc"},
+		{alice, "http://example.com/admin/users", "admin=1; session=abc"},
+		{alice, "http://other.example/", ""},
+		{bob, "http://example.com/home", ""},
+		// Another connection of the same client shares its jar.
+		{"192.0.2.1:5678", "http://example.com/home", "session=abc"},
+	}
+
+	for i, tc := range tt {
+		if got := exchange(t, mod, tc.addr, tc.url); got != tc.want {
+			t.Errorf("%d. Cookie: got %q, want %q", i, got, tc.want)
+		}
+	}
+
+	// Cookies sent by the client take precedence.
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.RemoteAddr = alice
+	req.AddCookie(&http.Cookie{Name: "session", Value: "own"})
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("Cookie"), "session=own"; got != want {
+		t.Errorf("Cookie: got %q, want %q", got, want)
+	}
+
+	// Deleting a cookie removes it from the jar.
+	exchange(t, mod, alice, "http://example.com/logout", "session=; Path=/; Max-Age=0")
+	if got := exchange(t, mod, alice, "http://example.com/home"); got != "" {
+		t.Errorf("Cookie: got %q, want none", got)
+	}
+
+	mod.Reset()
+	if got := exchange(t, mod, alice, "http://example.com/admin/"); got != "" {
+		t.Errorf("Cookie after Reset(): got %q, want none", got)
+	}
+}
+
+func TestJarModifierKeys(t *testing.T) {
+	if _, err := NewJarModifier("cookie:id"); err == nil {
+		t.Errorf("NewJarModifier(%q): got nil, want error", "cookie:id")
+	}
+
+	mod, err := NewJarModifier("header:X-Test-Run")
+	if err != nil {
+		t.Fatalf("NewJarModifier(): got %v, want no error", err)
+	}
+
+	newRequest := func(run string) *http.Request {
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		if run != "" {
+			req.Header.Set("X-Test-Run", run)
+		}
+		return req
+	}
+
+	req := newRequest("1")
+	res := proxyutil.NewResponse(200, nil, req)
+	res.Header.Set("Set-Cookie", "id=1")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	for i, tc := range []struct{ run, want string }{{"1", "id=1"}, {"2", ""}, {"", ""}} {
+		req := newRequest(tc.run)
+		if err := mod.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+		if got := req.Header.Get("Cookie"); got != tc.want {
+			t.Errorf("%d. Cookie: got %q, want %q", i, got, tc.want)
+		}
+	}
+
+	mod, err = NewJarModifier("session")
+	if err != nil {
+		t.Fatalf("NewJarModifier(): got %v, want no error", err)
+	}
+
+	req = newRequest("")
+	ctx := martian.TestContext(req, nil, nil)
+	res = proxyutil.NewResponse(200, nil, req)
+	res.Header.Set("Set-Cookie", "id=2")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	if got, want := len(mod.jars), 1; got != want {
+		t.Fatalf("len(mod.jars): got %d, want %d", got, want)
+	}
+	if _, ok := mod.jars[ctx.Session().ID()]; !ok {
+		t.Errorf("mod.jars[%q]: got none, want jar of session", ctx.Session().ID())
+	}
+}
+
+func TestJarModifierIdleTimeout(t *testing.T) {
+	mod, err := NewJarModifier("client")
+	if err != nil {
+		t.Fatalf("NewJarModifier(): got %v, want no error", err)
+	}
+	mod.SetIdleTimeout(time.Millisecond)
+
+	exchange(t, mod, "192.0.2.1:1234", "http://example.com/", "id=1")
+	time.Sleep(5 * time.Millisecond)
+
+	if got := exchange(t, mod, "192.0.2.1:1234", "http://example.com/"); got != "" {
+		t.Errorf("Cookie: got %q, want none after idle timeout", got)
+	}
+}
+
+func TestJarModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"cookie.Jar": {
+			"scope": ["request", "response"],
+			"idleTimeoutMs": 600000
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	mod, ok := r.RequestModifier().(*JarModifier)
+	if !ok {
+		t.Fatalf("r.RequestModifier(): got %T, want *JarModifier", r.RequestModifier())
+	}
+	if got, want := mod.key, "client"; got != want {
+		t.Errorf("mod.key: got %q, want %q", got, want)
+	}
+	if got, want := mod.idleTimeout, 10*time.Minute; got != want {
+		t.Errorf("mod.idleTimeout: got %v, want %v", got, want)
+	}
+	if r.ResponseModifier() == nil {
+		t.Error("r.ResponseModifier(): got nil, want not nil")
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"cookie.Jar": {"scope": ["request"], "key": "ip"}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for unknown key")
+	}
+}
@@ -12,7 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package cors provides CORS support for http.Handlers.
+// Package cors provides CORS support for http.Handlers and a modifier adding
+// CORS support to backends through the proxy.
 package cors
 
 import (
@@ -0,0 +1,231 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package cors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("cors.Modifier", modifierFromJSON)
+}
+
+// Modifier lets cross-origin frontends use backends that do not support CORS
+// through the proxy. It answers preflight requests from allowed origins
+// without sending them to the backend, and sets the Access-Control-*
+// headers on the responses to their actual requests, replacing those set by
+// the backend. Requests from other origins are left unchanged.
+type Modifier struct {
+	origins          []string
+	methods          []string
+	headers          []string
+	exposeHeaders    []string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+type modifierJSON struct {
+	Origins          []string             `json:"origins"`
+	Methods          []string             `json:"methods"`
+	Headers          []string             `json:"headers"`
+	ExposeHeaders    []string             `json:"exposeHeaders"`
+	AllowCredentials bool                 `json:"allowCredentials"`
+	MaxAgeSeconds    int                  `json:"maxAgeSeconds"`
+	Scope            []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a Modifier allowing origins. An origin may contain
+// wildcards as in path.Match, such as "https://*.example.com", and "*" allows
+// all origins. By default, preflight requests are allowed the methods and
+// headers they ask for.
+func NewModifier(origins ...string) (*Modifier, error) {
+	for _, o := range origins {
+		if _, err := path.Match(o, ""); err != nil {
+			return nil, fmt.Errorf("cors: invalid origin %q: %w", o, err)
+		}
+	}
+
+	return &Modifier{origins: origins}, nil
+}
+
+// SetMethods sets the methods allowed by preflight responses.
+func (m *Modifier) SetMethods(methods ...string) {
+	m.methods = methods
+}
+
+// SetHeaders sets the request headers allowed by preflight responses.
+func (m *Modifier) SetHeaders(headers ...string) {
+	m.headers = headers
+}
+
+// SetExposeHeaders sets the response headers that frontends may read.
+func (m *Modifier) SetExposeHeaders(headers ...string) {
+	m.exposeHeaders = headers
+}
+
+// AllowCredentials allows cookies to be sent and read by CORS requests.
+func (m *Modifier) AllowCredentials(allow bool) {
+	m.allowCredentials = allow
+}
+
+// SetMaxAge sets how long browsers may cache preflight responses.
+func (m *Modifier) SetMaxAge(maxAge time.Duration) {
+	m.maxAge = maxAge
+}
+
+// ModifyRequest skips the round trip of preflight requests from allowed
+// origins.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	if !isPreflight(req) || !m.allowed(req.Header.Get("Origin")) {
+		return nil
+	}
+
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+	ctx.SkipRoundTrip()
+	log.Debugf("cors.Modifier.ModifyRequest: %s: answering preflight from %s", req.URL, req.Header.Get("Origin"))
+
+	return nil
+}
+
+// ModifyResponse sets the Access-Control-* headers of responses to requests
+// from allowed origins.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil {
+		return nil
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" || !m.allowed(origin) {
+		return nil
+	}
+
+	h := res.Header
+	for k := range h {
+		if strings.HasPrefix(k, "Access-Control-") {
+			h.Del(k)
+		}
+	}
+
+	if m.allowCredentials || !m.allowsAll() {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+	if m.allowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !isPreflight(req) {
+		if len(m.exposeHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(m.exposeHeaders, ", "))
+		}
+		return nil
+	}
+
+	methods := req.Header.Get("Access-Control-Request-Method")
+	if len(m.methods) > 0 {
+		methods = strings.Join(m.methods, ", ")
+	}
+	h.Set("Access-Control-Allow-Methods", methods)
+
+	headers := req.Header.Get("Access-Control-Request-Headers")
+	if len(m.headers) > 0 {
+		headers = strings.Join(m.headers, ", ")
+	}
+	if headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+
+	if m.maxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(m.maxAge/time.Second)))
+	}
+
+	return nil
+}
+
+func (m *Modifier) allowed(origin string) bool {
+	for _, o := range m.origins {
+		if o == "*" {
+			return true
+		}
+		if ok, _ := path.Match(o, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Modifier) allowsAll() bool {
+	for _, o := range m.origins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// modifierFromJSON builds a cors.Modifier from JSON. "origins" are the
+// allowed origins, which may contain wildcards. "methods" and "headers" are
+// allowed by preflight responses, by default those requested.
+// "exposeHeaders" are the response headers frontends may read.
+// "allowCredentials" allows cookies and "maxAgeSeconds" sets how long
+// preflight responses may be cached.
+//
+// Example JSON:
+//
+//	{
+//	  "cors.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "origins": ["http://localhost:3000", "https://*.example.com"],
+//	    "methods": ["GET", "POST", "DELETE"],
+//	    "headers": ["Content-Type", "Authorization"],
+//	    "exposeHeaders": ["X-Request-Id"],
+//	    "allowCredentials": true,
+//	    "maxAgeSeconds": 600
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if len(msg.Origins) == 0 {
+		return nil, errors.New("cors: no origins")
+	}
+	if msg.MaxAgeSeconds < 0 {
+		return nil, errors.New("cors: maxAgeSeconds must not be negative")
+	}
+
+	mod, err := NewModifier(msg.Origins...)
+	if err != nil {
+		return nil, err
+	}
+	mod.SetMethods(msg.Methods...)
+	mod.SetHeaders(msg.Headers...)
+	mod.SetExposeHeaders(msg.ExposeHeaders...)
+	mod.AllowCredentials(msg.AllowCredentials)
+	mod.SetMaxAge(time.Duration(msg.MaxAgeSeconds) * time.Second)
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package cors
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func newCORSRequest(t *testing.T, method, origin string) (*http.Request, *martian.Context) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, "http://api.example.com/items", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if method == "OPTIONS" {
+		req.Header.Set("Access-Control-Request-Method", "DELETE")
+		req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+	}
+
+	return req, martian.TestContext(req, nil, nil)
+}
+
+func TestModifierPreflight(t *testing.T) {
+	mod, err := NewModifier("https://*.example.com")
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	mod.SetMethods("GET", "DELETE")
+	mod.AllowCredentials(true)
+	mod.SetMaxAge(10 * time.Minute)
+
+	req, ctx := newCORSRequest(t, "OPTIONS", "https://app.example.com")
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got false, want true")
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	for _, tc := range []struct{ name, want string }{
+		{"Access-Control-Allow-Origin", "https://app.example.com"},
+		{"Access-Control-Allow-Credentials", "true"},
+		{"Access-Control-Allow-Methods", "GET, DELETE"},
+		{"Access-Control-Allow-Headers", "X-Custom"},
+		{"Access-Control-Max-Age", "600"},
+		{"Vary", "Origin"},
+	} {
+		if got := res.Header.Get(tc.name); got != tc.want {
+			t.Errorf("res.Header.Get(%q): got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestModifierActualRequest(t *testing.T) {
+	mod, err := NewModifier("*")
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	mod.SetExposeHeaders("X-Request-Id", "X-Total")
+
+	req, ctx := newCORSRequest(t, "GET", "http://localhost:3000")
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got true, want false")
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	res.Header.Set("Access-Control-Allow-Origin", "https://prod.example.com")
+	res.Header.Set("Access-Control-Allow-Methods", "GET")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	for _, tc := range []struct{ name, want string }{
+		{"Access-Control-Allow-Origin", "*"},
+		{"Access-Control-Expose-Headers", "X-Request-Id, X-Total"},
+		{"Access-Control-Allow-Methods", ""},
+		{"Access-Control-Allow-Credentials", ""},
+		{"Vary", ""},
+	} {
+		if got := res.Header.Get(tc.name); got != tc.want {
+			t.Errorf("res.Header.Get(%q): got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestModifierDisallowedOrigin(t *testing.T) {
+	mod, err := NewModifier("https://app.example.com")
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	for _, origin := range []string{"https://evil.test", ""} {
+		req, ctx := newCORSRequest(t, "OPTIONS", origin)
+		if err := mod.ModifyRequest(req); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+		if ctx.SkippingRoundTrip() {
+			t.Errorf("%q: ctx.SkippingRoundTrip(): got true, want false", origin)
+		}
+
+		res := proxyutil.NewResponse(200, nil, req)
+		res.Header.Set("Access-Control-Allow-Origin", "https://backend.test")
+		if err := mod.ModifyResponse(res); err != nil {
+			t.Fatalf("ModifyResponse(): got %v, want no error", err)
+		}
+		if got, want := res.Header.Get("Access-Control-Allow-Origin"), "https://backend.test"; got != want {
+			t.Errorf("%q: res.Header.Get(%q): got %q, want %q", origin, "Access-Control-Allow-Origin", got, want)
+		}
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"cors.Modifier": {
+			"scope": ["request", "response"],
+			"origins": ["http://localhost:3000"],
+			"headers": ["Content-Type"],
+			"maxAgeSeconds": 60
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	mod, ok := r.RequestModifier().(*Modifier)
+	if !ok {
+		t.Fatalf("r.RequestModifier(): got %T, want *Modifier", r.RequestModifier())
+	}
+	if r.ResponseModifier() == nil {
+		t.Error("r.ResponseModifier(): got nil, want not nil")
+	}
+	if got, want := mod.maxAge, time.Minute; got != want {
+		t.Errorf("mod.maxAge: got %v, want %v", got, want)
+	}
+
+	for _, msg := range []string{
+		`{"cors.Modifier": {"scope": ["request", "response"]}}`,
+		`{"cors.Modifier": {"scope": ["request", "response"], "origins": ["["]}}`,
+		`{"cors.Modifier": {"scope": ["request", "response"], "origins": ["*"], "maxAgeSeconds": -1}}`,
+	} {
+		if _, err := parse.FromJSON([]byte(msg)); err == nil {
+			t.Errorf("parse.FromJSON(%s): got nil, want error", msg)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package csp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("csp.Headers", headersModifierFromJSON)
+}
+
+// securityHeaders are the headers HeadersModifier sets or removes.
+var securityHeaders = map[string]bool{
+	"Content-Security-Policy":             true,
+	"Content-Security-Policy-Report-Only": true,
+	"Cross-Origin-Embedder-Policy":        true,
+	"Cross-Origin-Opener-Policy":          true,
+	"Cross-Origin-Resource-Policy":        true,
+	"Permissions-Policy":                  true,
+	"Referrer-Policy":                     true,
+	"X-Content-Type-Options":              true,
+	"X-Frame-Options":                     true,
+	"X-Permitted-Cross-Domain-Policies":   true,
+	"X-Xss-Protection":                    true,
+}
+
+// HeadersModifier is a response modifier that sets or removes security
+// headers, such as X-Frame-Options to allow embedding a site in a test
+// harness. The security headers are Content-Security-Policy,
+// Content-Security-Policy-Report-Only, Cross-Origin-Embedder-Policy,
+// Cross-Origin-Opener-Policy, Cross-Origin-Resource-Policy,
+// Permissions-Policy, Referrer-Policy, X-Content-Type-Options,
+// X-Frame-Options, X-Permitted-Cross-Domain-Policies and X-XSS-Protection.
+type HeadersModifier struct {
+	names  []string
+	values map[string]string
+}
+
+type headersModifierJSON struct {
+	Headers map[string]string    `json:"headers"`
+	Scope   []parse.ModifierType `json:"scope"`
+}
+
+// NewHeadersModifier returns a HeadersModifier that leaves all headers
+// unchanged.
+func NewHeadersModifier() *HeadersModifier {
+	return &HeadersModifier{values: make(map[string]string)}
+}
+
+// Set sets the security header name to value, or removes it if value is
+// empty. It returns an error if name is not a security header.
+func (m *HeadersModifier) Set(name, value string) error {
+	name = http.CanonicalHeaderKey(name)
+	if !securityHeaders[name] {
+		return fmt.Errorf("csp: %s is not a security header", name)
+	}
+
+	if _, ok := m.values[name]; !ok {
+		m.names = append(m.names, name)
+	}
+	m.values[name] = value
+
+	return nil
+}
+
+// ModifyResponse sets or removes the security headers of res.
+func (m *HeadersModifier) ModifyResponse(res *http.Response) error {
+	for _, name := range m.names {
+		if v := m.values[name]; v != "" {
+			res.Header.Set(name, v)
+		} else {
+			res.Header.Del(name)
+		}
+	}
+
+	return nil
+}
+
+// headersModifierFromJSON builds a csp.Headers modifier from JSON. "headers"
+// maps security headers to their values; an empty value removes the header.
+//
+// Example JSON:
+//
+//	{
+//	  "csp.Headers": {
+//	    "scope": ["response"],
+//	    "headers": {
+//	      "X-Frame-Options": "",
+//	      "X-Content-Type-Options": "nosniff",
+//	      "Referrer-Policy": "no-referrer"
+//	    }
+//	  }
+//	}
+func headersModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &headersModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(msg.Headers))
+	for name := range msg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mod := NewHeadersModifier()
+	for _, name := range names {
+		if err := mod.Set(name, msg.Headers[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package csp
+
+import (
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestHeadersModifier(t *testing.T) {
+	mod := NewHeadersModifier()
+	if err := mod.Set("x-frame-options", ""); err != nil {
+		t.Fatalf("Set(): got %v, want no error", err)
+	}
+	if err := mod.Set("Referrer-Policy", "no-referrer"); err != nil {
+		t.Fatalf("Set(): got %v, want no error", err)
+	}
+	if err := mod.Set("Content-Type", "text/plain"); err == nil {
+		t.Error("Set(Content-Type): got nil, want error")
+	}
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	res.Header.Set("X-Frame-Options", "DENY")
+	res.Header.Set("X-Content-Type-Options", "nosniff")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	for _, tc := range []struct{ name, want string }{
+		{"X-Frame-Options", ""},
+		{"Referrer-Policy", "no-referrer"},
+		{"X-Content-Type-Options", "nosniff"},
+	} {
+		if got := res.Header.Get(tc.name); got != tc.want {
+			t.Errorf("res.Header.Get(%q): got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHeadersModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"csp.Headers": {
+			"scope": ["response"],
+			"headers": {
+				"X-Frame-Options": "SAMEORIGIN",
+				"Content-Security-Policy": ""
+			}
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	res.Header.Set("Content-Security-Policy", "default-src 'self'")
+	if err := r.ResponseModifier().ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("X-Frame-Options"), "SAMEORIGIN"; got != want {
+		t.Errorf("X-Frame-Options: got %q, want %q", got, want)
+	}
+	if got := res.Header.Get("Content-Security-Policy"); got != "" {
+		t.Errorf("Content-Security-Policy: got %q, want none", got)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"csp.Headers": {"scope": ["response"], "headers": {"Server": ""}}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for non security header")
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package csp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("csp.Modifier", modifierFromJSON)
+}
+
+// Modifier is a response modifier that edits the Content-Security-Policy of
+// responses, for example to allow a test harness to inject scripts, or to
+// tighten the policy of a site under test. Edits are applied in the order
+// they were added to every policy of the response. A response without a
+// policy gets one if the edits add directives.
+type Modifier struct {
+	reportOnly bool
+	edits      []func(p *Policy)
+}
+
+type editJSON struct {
+	Action    string   `json:"action"`
+	Directive string   `json:"directive"`
+	Sources   []string `json:"sources"`
+}
+
+type modifierJSON struct {
+	Edits      []editJSON           `json:"edits"`
+	ReportOnly bool                 `json:"reportOnly"`
+	ReportURI  string               `json:"reportURI"`
+	Scope      []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a Modifier without edits.
+func NewModifier() *Modifier {
+	return &Modifier{}
+}
+
+// SetReportOnly sets whether the modifier edits the
+// Content-Security-Policy-Report-Only header instead of
+// Content-Security-Policy.
+func (m *Modifier) SetReportOnly(reportOnly bool) {
+	m.reportOnly = reportOnly
+}
+
+// Add adds sources to the directive, adding the directive if needed.
+func (m *Modifier) Add(directive string, sources ...string) {
+	m.edits = append(m.edits, func(p *Policy) {
+		for _, s := range sources {
+			p.Add(directive, s)
+		}
+	})
+}
+
+// Remove removes sources from the directive.
+func (m *Modifier) Remove(directive string, sources ...string) {
+	m.edits = append(m.edits, func(p *Policy) {
+		for _, s := range sources {
+			p.Remove(directive, s)
+		}
+	})
+}
+
+// Set replaces the sources of the directive.
+func (m *Modifier) Set(directive string, sources ...string) {
+	m.edits = append(m.edits, func(p *Policy) {
+		p.Set(directive, append([]string(nil), sources...)...)
+	})
+}
+
+// Delete deletes the directive.
+func (m *Modifier) Delete(directive string) {
+	m.edits = append(m.edits, func(p *Policy) {
+		p.Delete(directive)
+	})
+}
+
+// ReportTo sends violation reports to uri, for example the URL of a
+// ReportCollector, replacing the report-uri and report-to directives.
+func (m *Modifier) ReportTo(uri string) {
+	m.edits = append(m.edits, func(p *Policy) {
+		p.Delete("report-to")
+		p.Set("report-uri", uri)
+	})
+}
+
+// ModifyResponse edits the policies of res.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	name := "Content-Security-Policy"
+	if m.reportOnly {
+		name = "Content-Security-Policy-Report-Only"
+	}
+
+	values := res.Header.Values(name)
+	if len(values) == 0 {
+		values = []string{""}
+	}
+
+	res.Header.Del(name)
+	for _, v := range values {
+		p := ParsePolicy(v)
+		for _, edit := range m.edits {
+			edit(p)
+		}
+		if s := p.String(); s != "" {
+			res.Header.Add(name, s)
+		}
+	}
+
+	if res.Request != nil {
+		log.Debugf("csp.Modifier.ModifyResponse: %s: %s: %q", res.Request.URL, name, res.Header.Values(name))
+	}
+
+	return nil
+}
+
+// modifierFromJSON builds a csp.Modifier from JSON. "edits" are applied in
+// order; each has an "action" of add, remove, set or delete, a "directive"
+// and the "sources" to add, remove or set. "reportURI" sends violation
+// reports to a URL, and "reportOnly" edits the
+// Content-Security-Policy-Report-Only header instead.
+//
+// Example JSON:
+//
+//	{
+//	  "csp.Modifier": {
+//	    "scope": ["response"],
+//	    "edits": [
+//	      {"action": "add", "directive": "script-src", "sources": ["https://harness.test"]},
+//	      {"action": "remove", "directive": "script-src", "sources": ["'unsafe-inline'"]},
+//	      {"action": "delete", "directive": "upgrade-insecure-requests"}
+//	    ],
+//	    "reportURI": "http://csp-reports.test/report"
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewModifier()
+	mod.SetReportOnly(msg.ReportOnly)
+	for i, e := range msg.Edits {
+		if e.Directive == "" {
+			return nil, fmt.Errorf("csp: edit %d: no directive", i)
+		}
+
+		switch e.Action {
+		case "add":
+			mod.Add(e.Directive, e.Sources...)
+		case "remove":
+			mod.Remove(e.Directive, e.Sources...)
+		case "set":
+			mod.Set(e.Directive, e.Sources...)
+		case "delete":
+			mod.Delete(e.Directive)
+		default:
+			return nil, fmt.Errorf("csp: edit %d: unknown action %q", i, e.Action)
+		}
+	}
+	if msg.ReportURI != "" {
+		mod.ReportTo(msg.ReportURI)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package csp
+
+import (
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestModifier(t *testing.T) {
+	mod := NewModifier()
+	mod.Add("script-src", "https://harness.test")
+	mod.Remove("script-src", "'unsafe-inline'")
+	mod.Delete("upgrade-insecure-requests")
+	mod.ReportTo("http://csp-reports.test/report")
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	res.Header.Set("Content-Security-Policy", "script-src 'self' 'unsafe-inline'; upgrade-insecure-requests; report-to endpoint")
+	res.Header.Set("Content-Security-Policy-Report-Only", "default-src 'self'")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	if got, want := res.Header.Get("Content-Security-Policy"), "script-src 'self' https://harness.test; report-uri http://csp-reports.test/report"; got != want {
+		t.Errorf("Content-Security-Policy: got %q, want %q", got, want)
+	}
+	if got, want := res.Header.Get("Content-Security-Policy-Report-Only"), "default-src 'self'"; got != want {
+		t.Errorf("Content-Security-Policy-Report-Only: got %q, want %q", got, want)
+	}
+}
+
+func TestModifierWithoutPolicy(t *testing.T) {
+	mod := NewModifier()
+	mod.Remove("script-src", "'self'")
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got := res.Header.Values("Content-Security-Policy"); len(got) != 0 {
+		t.Errorf("Content-Security-Policy: got %q, want none", got)
+	}
+
+	mod.SetReportOnly(true)
+	mod.Set("default-src", "'self'")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Content-Security-Policy-Report-Only"), "default-src 'self'"; got != want {
+		t.Errorf("Content-Security-Policy-Report-Only: got %q, want %q", got, want)
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"csp.Modifier": {
+			"scope": ["response"],
+			"edits": [
+				{"action": "set", "directive": "frame-ancestors", "sources": ["*"]},
+				{"action": "add", "directive": "script-src", "sources": ["https://harness.test"]}
+			],
+			"reportURI": "/report"
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("r.ResponseModifier(): got nil, want not nil")
+	}
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	res.Header.Set("Content-Security-Policy", "frame-ancestors 'none'")
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Content-Security-Policy"), "frame-ancestors *; script-src https://harness.test; report-uri /report"; got != want {
+		t.Errorf("Content-Security-Policy: got %q, want %q", got, want)
+	}
+
+	for _, msg := range []string{
+		`{"csp.Modifier": {"scope": ["response"], "edits": [{"action": "replace", "directive": "script-src"}]}}`,
+		`{"csp.Modifier": {"scope": ["response"], "edits": [{"action": "add", "sources": ["*"]}]}}`,
+	} {
+		if _, err := parse.FromJSON([]byte(msg)); err == nil {
+			t.Errorf("parse.FromJSON(%s): got nil, want error", msg)
+		}
+	}
+}
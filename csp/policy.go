@@ -0,0 +1,127 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package csp provides modifiers to edit Content-Security-Policy and other
+// security headers of responses, and to collect CSP violation reports.
+package csp
+
+import (
+	"strings"
+)
+
+// Policy is a parsed Content-Security-Policy. It keeps the order of
+// directives and sources.
+type Policy struct {
+	directives []directive
+}
+
+type directive struct {
+	name    string
+	sources []string
+}
+
+// ParsePolicy parses a Content-Security-Policy header value. Directive names
+// are lower-cased, sources are kept as they are.
+func ParsePolicy(s string) *Policy {
+	p := &Policy{}
+	for _, d := range strings.Split(s, ";") {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		p.directives = append(p.directives, directive{
+			name:    strings.ToLower(fields[0]),
+			sources: fields[1:],
+		})
+	}
+
+	return p
+}
+
+// Sources returns the sources of the directive name, and whether it is
+// present.
+func (p *Policy) Sources(name string) ([]string, bool) {
+	if d := p.directive(name); d != nil {
+		return d.sources, true
+	}
+	return nil, false
+}
+
+// Set sets the sources of the directive name, adding it if needed.
+func (p *Policy) Set(name string, sources ...string) {
+	if d := p.directive(name); d != nil {
+		d.sources = sources
+		return
+	}
+	p.directives = append(p.directives, directive{name: strings.ToLower(name), sources: sources})
+}
+
+// Add adds source to the directive name, adding the directive if needed.
+// Adding a source other than 'none' removes 'none'.
+func (p *Policy) Add(name, source string) {
+	d := p.directive(name)
+	if d == nil {
+		p.Set(name, source)
+		return
+	}
+
+	if source != "'none'" {
+		d.sources = remove(d.sources, "'none'")
+	}
+	for _, s := range d.sources {
+		if strings.EqualFold(s, source) {
+			return
+		}
+	}
+	d.sources = append(d.sources, source)
+}
+
+// Remove removes source from the directive name.
+func (p *Policy) Remove(name, source string) {
+	if d := p.directive(name); d != nil {
+		d.sources = remove(d.sources, source)
+	}
+}
+
+// Delete deletes the directive name.
+func (p *Policy) Delete(name string) {
+	name = strings.ToLower(name)
+
+	ds := p.directives[:0]
+	for _, d := range p.directives {
+		if d.name != name {
+			ds = append(ds, d)
+		}
+	}
+	p.directives = ds
+}
+
+// String returns the policy as a header value.
+func (p *Policy) String() string {
+	parts := make([]string, 0, len(p.directives))
+	for _, d := range p.directives {
+		parts = append(parts, strings.Join(append([]string{d.name}, d.sources...), " "))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// directive returns the first directive name, as browsers ignore later ones.
+func (p *Policy) directive(name string) *directive {
+	name = strings.ToLower(name)
+	for i := range p.directives {
+		if p.directives[i].name == name {
+			return &p.directives[i]
+		}
+	}
+	return nil
+}
+
+func remove(sources []string, source string) []string {
+	out := sources[:0]
+	for _, s := range sources {
+		if !strings.EqualFold(s, source) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package csp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	p := ParsePolicy("Default-Src 'self'; script-src 'self' 'unsafe-inline';; img-src 'none'; upgrade-insecure-requests")
+
+	if got, want := p.String(), "default-src 'self'; script-src 'self' 'unsafe-inline'; img-src 'none'; upgrade-insecure-requests"; got != want {
+		t.Errorf("String(): got %q, want %q", got, want)
+	}
+
+	p.Add("script-src", "https://cdn.test")
+	p.Add("script-src", "'SELF'")
+	p.Remove("script-src", "'unsafe-inline'")
+	p.Add("img-src", "data:")
+	p.Add("connect-src", "wss://ws.test")
+	p.Delete("upgrade-insecure-requests")
+
+	want := "default-src 'self'; script-src 'self' https://cdn.test; img-src data:; connect-src wss://ws.test"
+	if got := p.String(); got != want {
+		t.Errorf("String(): got %q, want %q", got, want)
+	}
+
+	p.Set("Default-Src", "'none'")
+	if got, ok := p.Sources("default-src"); !ok || !reflect.DeepEqual(got, []string{"'none'"}) {
+		t.Errorf("Sources(%q): got %q, %t, want ['none'], true", "default-src", got, ok)
+	}
+	if _, ok := p.Sources("frame-src"); ok {
+		t.Errorf("Sources(%q): got true, want false", "frame-src")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package csp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/verify"
+)
+
+// maxReportSize is the maximum size of a violation report request body.
+const maxReportSize = 64 << 10
+
+func init() {
+	parse.Register("csp.Reports", reportCollectorFromJSON)
+}
+
+// Report is a CSP violation report.
+type Report struct {
+	DocumentURI        string `json:"documentURI"`
+	Referrer           string `json:"referrer,omitempty"`
+	BlockedURI         string `json:"blockedURI"`
+	ViolatedDirective  string `json:"violatedDirective"`
+	EffectiveDirective string `json:"effectiveDirective,omitempty"`
+	OriginalPolicy     string `json:"originalPolicy,omitempty"`
+	Disposition        string `json:"disposition,omitempty"`
+	SourceFile         string `json:"sourceFile,omitempty"`
+	LineNumber         int    `json:"lineNumber,omitempty"`
+}
+
+// ReportCollector is a request verifier that captures the CSP violation
+// reports that browsers send to its URL, answering them without a round
+// trip. It accepts both the report-uri format and the Reporting API format.
+// Every report is a failed request verification, so that tests can assert
+// that pages cause no violations; see Modifier.ReportTo to send reports to
+// the collector.
+type ReportCollector struct {
+	url *url.URL
+
+	mu      sync.Mutex
+	reports []Report
+}
+
+type reportCollectorJSON struct {
+	URL   string               `json:"url"`
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// legacyReport is the body of a report-uri report.
+type legacyReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+	} `json:"csp-report"`
+}
+
+// apiReport is a report of the Reporting API.
+type apiReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+	} `json:"body"`
+}
+
+var _ verify.RequestVerifier = (*ReportCollector)(nil)
+
+// NewReportCollector returns a ReportCollector capturing the reports sent to
+// rawURL. Requests match if their host and path are those of rawURL.
+func NewReportCollector(rawURL string) (*ReportCollector, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("csp: invalid report URL %q: %w", rawURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("csp: report URL %q has no host", rawURL)
+	}
+
+	return &ReportCollector{url: u}, nil
+}
+
+// Reports returns the captured reports.
+func (c *ReportCollector) Reports() []Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Report(nil), c.reports...)
+}
+
+// ModifyRequest captures the reports of report requests and skips their round
+// trip. Other requests are left unchanged.
+func (c *ReportCollector) ModifyRequest(req *http.Request) error {
+	if req.Method != http.MethodPost || req.URL.Host != c.url.Host || req.URL.Path != c.url.Path {
+		return nil
+	}
+
+	if ctx := martian.NewContext(req); ctx != nil {
+		ctx.SkipRoundTrip()
+	}
+
+	b, err := io.ReadAll(io.LimitReader(req.Body, maxReportSize))
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	reports, err := parseReports(b)
+	if err != nil {
+		log.Errorf("csp.ReportCollector.ModifyRequest: %s: invalid report: %v", req.URL, err)
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reports = append(c.reports, reports...)
+
+	return nil
+}
+
+// VerifyRequests returns an error for every captured report. If an error is
+// returned it will be of type *martian.MultiError.
+func (c *ReportCollector) VerifyRequests() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.reports) == 0 {
+		return nil
+	}
+
+	merr := martian.NewMultiError()
+	for _, r := range c.reports {
+		merr.Add(fmt.Errorf("csp: %s: %s violated by %s", r.DocumentURI, r.ViolatedDirective, r.BlockedURI))
+	}
+
+	return merr
+}
+
+// ResetRequestVerifications drops the captured reports.
+func (c *ReportCollector) ResetRequestVerifications() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reports = nil
+}
+
+// parseReports parses a report-uri report or an array of Reporting API
+// reports, of which only CSP violations are returned.
+func parseReports(b []byte) ([]Report, error) {
+	var api []apiReport
+	if err := json.Unmarshal(b, &api); err == nil {
+		var reports []Report
+		for _, r := range api {
+			if r.Type != "csp-violation" {
+				continue
+			}
+			reports = append(reports, Report{
+				DocumentURI:        r.Body.DocumentURL,
+				Referrer:           r.Body.Referrer,
+				BlockedURI:         r.Body.BlockedURL,
+				ViolatedDirective:  r.Body.EffectiveDirective,
+				EffectiveDirective: r.Body.EffectiveDirective,
+				OriginalPolicy:     r.Body.OriginalPolicy,
+				Disposition:        r.Body.Disposition,
+				SourceFile:         r.Body.SourceFile,
+				LineNumber:         r.Body.LineNumber,
+			})
+		}
+		return reports, nil
+	}
+
+	legacy := &legacyReport{}
+	if err := json.Unmarshal(b, legacy); err != nil {
+		return nil, err
+	}
+	r := legacy.Report
+
+	return []Report{{
+		DocumentURI:        r.DocumentURI,
+		Referrer:           r.Referrer,
+		BlockedURI:         r.BlockedURI,
+		ViolatedDirective:  r.ViolatedDirective,
+		EffectiveDirective: r.EffectiveDirective,
+		OriginalPolicy:     r.OriginalPolicy,
+		Disposition:        r.Disposition,
+		SourceFile:         r.SourceFile,
+		LineNumber:         r.LineNumber,
+	}}, nil
+}
+
+// reportCollectorFromJSON builds a csp.Reports collector from JSON. "url" is
+// the URL reports are sent to.
+//
+// Example JSON:
+//
+//	{
+//	  "csp.Reports": {
+//	    "scope": ["request"],
+//	    "url": "http://csp-reports.test/report"
+//	  }
+//	}
+func reportCollectorFromJSON(b []byte) (*parse.Result, error) {
+	msg := &reportCollectorJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	c, err := NewReportCollector(msg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse.NewResult(c, msg.Scope)
+}
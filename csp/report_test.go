@@ -0,0 +1,93 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package csp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/verify"
+)
+
+func TestReportCollector(t *testing.T) {
+	c, err := NewReportCollector("http://csp-reports.test/report")
+	if err != nil {
+		t.Fatalf("NewReportCollector(): got %v, want no error", err)
+	}
+
+	bodies := []string{
+		`{"csp-report": {"document-uri": "https://app.test/", "blocked-uri": "https://evil.test/x.js", "violated-directive": "script-src"}}`,
+		`[{"type": "csp-violation", "body": {"documentURL": "https://app.test/b", "blockedURL": "inline", "effectiveDirective": "style-src"}}, {"type": "deprecation", "body": {}}]`,
+		`not json`,
+	}
+	for i, body := range bodies {
+		req, err := http.NewRequest("POST", "http://csp-reports.test/report", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		ctx := martian.TestContext(req, nil, nil)
+
+		if err := c.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+		if !ctx.SkippingRoundTrip() {
+			t.Errorf("%d. ctx.SkippingRoundTrip(): got false, want true", i)
+		}
+	}
+
+	// Other requests are not captured.
+	req, err := http.NewRequest("POST", "http://csp-reports.test/other", strings.NewReader(bodies[0]))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	ctx := martian.TestContext(req, nil, nil)
+	if err := c.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got true, want false")
+	}
+
+	reports := c.Reports()
+	if got, want := len(reports), 2; got != want {
+		t.Fatalf("len(Reports()): got %d, want %d", got, want)
+	}
+	if got, want := reports[0], (Report{DocumentURI: "https://app.test/", BlockedURI: "https://evil.test/x.js", ViolatedDirective: "script-src"}); got != want {
+		t.Errorf("Reports()[0]: got %+v, want %+v", got, want)
+	}
+	if got, want := reports[1].ViolatedDirective, "style-src"; got != want {
+		t.Errorf("Reports()[1].ViolatedDirective: got %q, want %q", got, want)
+	}
+
+	err = c.VerifyRequests()
+	var merr *martian.MultiError
+	if !errors.As(err, &merr) {
+		t.Fatalf("VerifyRequests(): got %v, want *martian.MultiError", err)
+	}
+	if got, want := len(merr.Errors()), 2; got != want {
+		t.Errorf("len(merr.Errors()): got %d, want %d", got, want)
+	}
+
+	c.ResetRequestVerifications()
+	if err := c.VerifyRequests(); err != nil {
+		t.Errorf("VerifyRequests(): got %v, want no error after reset", err)
+	}
+}
+
+func TestReportCollectorFromJSON(t *testing.T) {
+	r, err := parse.FromJSON([]byte(`{"csp.Reports": {"scope": ["request"], "url": "http://csp-reports.test/report"}}`))
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	if _, ok := r.RequestModifier().(verify.RequestVerifier); !ok {
+		t.Errorf("r.RequestModifier(): got %T, want verify.RequestVerifier", r.RequestModifier())
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"csp.Reports": {"scope": ["request"], "url": "/report"}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for URL without host")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package dictionary provides a modifier that collects the compression
+// dictionaries of Compression Dictionary Transport (RFC 9842), so that
+// dictionary-compressed bodies can be decoded in logs.
+//
+// Responses marked with the Use-As-Dictionary header are added to the
+// dictionaries of package messageview. Decoding "dcb" and "dcz" bodies further
+// requires decoders registered with messageview.RegisterDecoder. The
+// Available-Dictionary, Use-As-Dictionary and Dictionary-ID headers are passed
+// through unmodified.
+package dictionary
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/messageview"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("dictionary.Modifier", modifierFromJSON)
+}
+
+// Modifier adds the bodies of responses with a Use-As-Dictionary header to
+// the dictionaries available for decoding.
+type Modifier struct{}
+
+type modifierJSON struct {
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a new dictionary modifier.
+func NewModifier() *Modifier {
+	return &Modifier{}
+}
+
+// ModifyResponse adds the decoded body of res as a dictionary if res is a
+// complete response with a Use-As-Dictionary header.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if res.StatusCode != http.StatusOK || res.Header.Get("Use-As-Dictionary") == "" {
+		return nil
+	}
+
+	mv := messageview.New()
+	if err := mv.SnapshotResponse(res); err != nil {
+		return err
+	}
+
+	br, err := mv.BodyReader(messageview.Decode())
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+
+	dict, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+
+	h := messageview.AddDictionary(dict)
+	log.Debugf("dictionary: added dictionary %x of %d bytes", h, len(dict))
+
+	return nil
+}
+
+// modifierFromJSON builds a dictionary.Modifier from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "dictionary.Modifier": {
+//	    "scope": ["response"]
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return parse.NewResult(NewModifier(), msg.Scope)
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package dictionary
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/messageview"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestModifyResponse(t *testing.T) {
+	m := NewModifier()
+
+	// Responses without Use-As-Dictionary are not added.
+	res := proxyutil.NewResponse(200, strings.NewReader("not a dictionary"), nil)
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if _, ok := messageview.Dictionary(sha256.Sum256([]byte("not a dictionary"))); ok {
+		t.Error("messageview.Dictionary(): got true, want false")
+	}
+
+	dict := []byte("shared dictionary")
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	gw.Write(dict)
+	gw.Close()
+	gzipped := buf.Bytes()
+
+	res = proxyutil.NewResponse(200, bytes.NewReader(gzipped), nil)
+	res.Header.Set("Content-Encoding", "gzip")
+	res.Header.Set("Use-As-Dictionary", `match="/app/*.js"`)
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	got, ok := messageview.Dictionary(sha256.Sum256(dict))
+	if !ok {
+		t.Fatal("messageview.Dictionary(): got false, want true")
+	}
+	if !bytes.Equal(got, dict) {
+		t.Errorf("messageview.Dictionary(): got %q, want %q", got, dict)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if !bytes.Equal(body, gzipped) {
+		t.Error("res.Body: got modified body, want original body")
+	}
+	if got, want := res.Header.Get("Use-As-Dictionary"), `match="/app/*.js"`; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Use-As-Dictionary", got, want)
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"dictionary.Modifier": {
+			"scope": ["response"]
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("resmod: got nil, want not nil")
+	}
+	if _, ok := resmod.(*Modifier); !ok {
+		t.Error("resmod.(*Modifier): got !ok, want ok")
+	}
+}
@@ -18,12 +18,29 @@
 // when errror aggregation is enabled (by calling SetAggretateErrors(true)), modifier
 // execution is not halted, and errors are aggretated and returned after all
 // modifiers have been executed.
+//
+// Errors continue the exchange with a warning header by default (fail-open).
+// A group can instead fail closed per scope (by calling SetRequestFailClosed or
+// SetResponseFailClosed), in which case its errors abort the exchange and the
+// proxy responds with its error response.
+//
+// A Group can also guard its modifiers (by calling SetRecoverPanics or
+// SetModifierTimeout), so that a modifier that panics or hangs fails with an
+// error instead of crashing or wedging the proxy.
+//
+// Modifiers added to a Group with an ID can later be removed or replaced by
+// that ID (by calling Remove or Replace) while the group is in use.
+//
+// ParallelGroup instead runs its modifiers concurrently on each message and
+// joins their errors.
 package fifo
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/parse"
@@ -34,6 +51,10 @@ type group struct {
 	reqmods         []martian.RequestModifier
 	resmods         []martian.ResponseModifier
 	aggregateErrors bool
+	reqFailClosed   bool
+	resFailClosed   bool
+	recoverPanics   bool
+	timeout         time.Duration
 }
 
 // ModifyRequest modifies the request. By default, aggregateErrors is false; if an error is
@@ -43,7 +64,7 @@ type group struct {
 func (g *group) ModifyRequest(req *http.Request) error {
 	var merr *martian.MultiError
 	for _, reqmod := range g.reqmods {
-		if err := reqmod.ModifyRequest(req); err != nil {
+		if err := g.modifyRequest(reqmod, req); err != nil {
 			if g.aggregateErrors {
 				if merr == nil {
 					merr = martian.NewMultiError()
@@ -52,7 +73,7 @@ func (g *group) ModifyRequest(req *http.Request) error {
 				continue
 			}
 
-			return err
+			return g.requestError(err)
 		}
 	}
 
@@ -60,7 +81,7 @@ func (g *group) ModifyRequest(req *http.Request) error {
 		return nil
 	}
 
-	return merr
+	return g.requestError(merr)
 }
 
 // ModifyResponse modifies the request. By default, aggregateErrors is false; if an error is
@@ -70,7 +91,7 @@ func (g *group) ModifyRequest(req *http.Request) error {
 func (g *group) ModifyResponse(res *http.Response) error {
 	var merr *martian.MultiError
 	for _, resmod := range g.resmods {
-		if err := resmod.ModifyResponse(res); err != nil {
+		if err := g.modifyResponse(resmod, res); err != nil {
 			if g.aggregateErrors {
 				if merr == nil {
 					merr = martian.NewMultiError()
@@ -79,7 +100,7 @@ func (g *group) ModifyResponse(res *http.Response) error {
 				continue
 			}
 
-			return err
+			return g.responseError(err)
 		}
 	}
 
@@ -87,7 +108,49 @@ func (g *group) ModifyResponse(res *http.Response) error {
 		return nil
 	}
 
-	return merr
+	return g.responseError(merr)
+}
+
+// guarded reports whether the modifiers of the group run guarded by
+// martian.GuardRequest and martian.GuardResponse.
+func (g *group) guarded() bool {
+	return g.recoverPanics || g.timeout > 0
+}
+
+func (g *group) modifyRequest(reqmod martian.RequestModifier, req *http.Request) error {
+	if !g.guarded() {
+		return reqmod.ModifyRequest(req)
+	}
+
+	return martian.GuardRequest(reqmod, req, g.timeout)
+}
+
+func (g *group) modifyResponse(resmod martian.ResponseModifier, res *http.Response) error {
+	if !g.guarded() {
+		return resmod.ModifyResponse(res)
+	}
+
+	return martian.GuardResponse(resmod, res, g.timeout)
+}
+
+// requestError returns err, marked to abort the exchange if the group fails
+// closed for requests.
+func (g *group) requestError(err error) error {
+	if g.reqFailClosed {
+		return martian.Abort(err)
+	}
+
+	return err
+}
+
+// responseError returns err, marked to abort the exchange if the group fails
+// closed for responses.
+func (g *group) responseError(err error) error {
+	if g.resFailClosed {
+		return martian.Abort(err)
+	}
+
+	return err
 }
 
 // Group is a martian.RequestResponseModifier that maintains lists of
@@ -95,14 +158,19 @@ func (g *group) ModifyResponse(res *http.Response) error {
 // The Group allows adding new modifiers on the run.
 type Group struct {
 	group
-	reqmu sync.RWMutex // guards group.reqmods
-	resmu sync.RWMutex // guards group.resmods
+	reqmu  sync.RWMutex // guards group.reqmods and reqIDs
+	resmu  sync.RWMutex // guards group.resmods and resIDs
+	reqIDs []string
+	resIDs []string
 }
 
 type groupJSON struct {
 	Modifiers       []json.RawMessage    `json:"modifiers"`
 	Scope           []parse.ModifierType `json:"scope"`
 	AggregateErrors bool                 `json:"aggregateErrors"`
+	FailClosed      []parse.ModifierType `json:"failClosed"`
+	RecoverPanics   bool                 `json:"recoverPanics"`
+	TimeoutMs       int64                `json:"modifierTimeoutMs"`
 }
 
 func init() {
@@ -124,20 +192,146 @@ func (g *Group) SetAggregateErrors(aggerr bool) {
 	g.aggregateErrors = aggerr
 }
 
+// SetRequestFailClosed sets the error policy for requests. When true, errors
+// returned by request modifiers of the Group abort the exchange: the request is
+// not sent and the proxy responds with its error response. When false, the
+// request continues with a warning header. By default, the Group fails open.
+func (g *Group) SetRequestFailClosed(failClosed bool) {
+	g.reqFailClosed = failClosed
+}
+
+// SetResponseFailClosed sets the error policy for responses. When true, errors
+// returned by response modifiers of the Group abort the exchange: the response
+// is replaced by the error response of the proxy. When false, the response
+// continues with a warning header. By default, the Group fails open.
+func (g *Group) SetResponseFailClosed(failClosed bool) {
+	g.resFailClosed = failClosed
+}
+
+// SetRecoverPanics sets whether panics of the modifiers of the Group are
+// recovered and returned as errors naming the modifier, so that a misbehaving
+// modifier cannot crash the proxy. By default, panics are not recovered.
+func (g *Group) SetRecoverPanics(recoverPanics bool) {
+	g.recoverPanics = recoverPanics
+}
+
+// SetModifierTimeout sets the time each modifier of the Group may run. A
+// modifier that runs longer fails with an error naming it, while it keeps
+// running in the background, and the Group moves on. A positive timeout
+// also recovers panics, see SetRecoverPanics. By default, modifiers run
+// without limit.
+func (g *Group) SetModifierTimeout(timeout time.Duration) {
+	g.timeout = timeout
+}
+
 // AddRequestModifier adds a RequestModifier to the group's list of request modifiers.
-func (g *Group) AddRequestModifier(reqmod martian.RequestModifier) {
+// An optional ID identifies the modifier for Remove and Replace.
+func (g *Group) AddRequestModifier(reqmod martian.RequestModifier, id ...string) {
 	g.reqmu.Lock()
 	defer g.reqmu.Unlock()
 
 	g.reqmods = append(g.reqmods, reqmod)
+	g.reqIDs = append(g.reqIDs, optionalID(id))
 }
 
 // AddResponseModifier adds a ResponseModifier to the group's list of response modifiers.
-func (g *Group) AddResponseModifier(resmod martian.ResponseModifier) {
+// An optional ID identifies the modifier for Remove and Replace.
+func (g *Group) AddResponseModifier(resmod martian.ResponseModifier, id ...string) {
 	g.resmu.Lock()
 	defer g.resmu.Unlock()
 
 	g.resmods = append(g.resmods, resmod)
+	g.resIDs = append(g.resIDs, optionalID(id))
+}
+
+func optionalID(id []string) string {
+	if len(id) == 0 {
+		return ""
+	}
+
+	return id[0]
+}
+
+// Remove removes the request and response modifiers added with id from the
+// group. It reports whether any modifier was removed.
+func (g *Group) Remove(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	g.reqmu.Lock()
+	var reqmods []martian.RequestModifier
+	var reqIDs []string
+	for i, reqmod := range g.reqmods {
+		if g.reqIDs[i] != id {
+			reqmods = append(reqmods, reqmod)
+			reqIDs = append(reqIDs, g.reqIDs[i])
+		}
+	}
+	removed := len(reqmods) != len(g.reqmods)
+	g.reqmods, g.reqIDs = reqmods, reqIDs
+	g.reqmu.Unlock()
+
+	g.resmu.Lock()
+	var resmods []martian.ResponseModifier
+	var resIDs []string
+	for i, resmod := range g.resmods {
+		if g.resIDs[i] != id {
+			resmods = append(resmods, resmod)
+			resIDs = append(resIDs, g.resIDs[i])
+		}
+	}
+	removed = removed || len(resmods) != len(g.resmods)
+	g.resmods, g.resIDs = resmods, resIDs
+	g.resmu.Unlock()
+
+	return removed
+}
+
+// Replace replaces the request and response modifiers added with id by mod,
+// keeping their positions in the group. mod must be a
+// martian.RequestModifier if a request modifier was added with id, and a
+// martian.ResponseModifier if a response modifier was. Replace returns an
+// error, and leaves the group unchanged, if no modifier was added with id or
+// mod is not of the required kind.
+func (g *Group) Replace(id string, mod any) error {
+	g.reqmu.Lock()
+	defer g.reqmu.Unlock()
+	g.resmu.Lock()
+	defer g.resmu.Unlock()
+
+	reqmod, isreq := mod.(martian.RequestModifier)
+	resmod, isres := mod.(martian.ResponseModifier)
+
+	var reqidx, residx []int
+	for i, rid := range g.reqIDs {
+		if id != "" && rid == id {
+			if !isreq {
+				return fmt.Errorf("fifo: replacing request modifier %q: %T is not a request modifier", id, mod)
+			}
+			reqidx = append(reqidx, i)
+		}
+	}
+	for i, rid := range g.resIDs {
+		if id != "" && rid == id {
+			if !isres {
+				return fmt.Errorf("fifo: replacing response modifier %q: %T is not a response modifier", id, mod)
+			}
+			residx = append(residx, i)
+		}
+	}
+	if len(reqidx) == 0 && len(residx) == 0 {
+		return fmt.Errorf("fifo: no modifier with ID %q", id)
+	}
+
+	for _, i := range reqidx {
+		g.reqmods[i] = reqmod
+	}
+	for _, i := range residx {
+		g.resmods[i] = resmod
+	}
+
+	return nil
 }
 
 // ModifyRequest modifies the request. By default, aggregateErrors is false; if an error is
@@ -166,8 +360,12 @@ func (g *Group) VerifyRequests() error {
 	g.reqmu.Lock()
 	defer g.reqmu.Unlock()
 
+	return verifyRequests(g.reqmods)
+}
+
+func verifyRequests(reqmods []martian.RequestModifier) error {
 	var merr *martian.MultiError
-	for _, reqmod := range g.reqmods {
+	for _, reqmod := range reqmods {
 		reqv, ok := reqmod.(verify.RequestVerifier)
 		if !ok {
 			continue
@@ -194,8 +392,12 @@ func (g *Group) VerifyResponses() error {
 	g.resmu.Lock()
 	defer g.resmu.Unlock()
 
+	return verifyResponses(g.resmods)
+}
+
+func verifyResponses(resmods []martian.ResponseModifier) error {
 	var merr *martian.MultiError
-	for _, resmod := range g.resmods {
+	for _, resmod := range resmods {
 		resv, ok := resmod.(verify.ResponseVerifier)
 		if !ok {
 			continue
@@ -221,7 +423,11 @@ func (g *Group) ResetRequestVerifications() {
 	g.reqmu.Lock()
 	defer g.reqmu.Unlock()
 
-	for _, reqmod := range g.reqmods {
+	resetRequestVerifications(g.reqmods)
+}
+
+func resetRequestVerifications(reqmods []martian.RequestModifier) {
+	for _, reqmod := range reqmods {
 		if reqv, ok := reqmod.(verify.RequestVerifier); ok {
 			reqv.ResetRequestVerifications()
 		}
@@ -233,7 +439,11 @@ func (g *Group) ResetResponseVerifications() {
 	g.resmu.Lock()
 	defer g.resmu.Unlock()
 
-	for _, resmod := range g.resmods {
+	resetResponseVerifications(g.resmods)
+}
+
+func resetResponseVerifications(resmods []martian.ResponseModifier) {
+	for _, resmod := range resmods {
 		if resv, ok := resmod.(verify.ResponseVerifier); ok {
 			resv.ResetResponseVerifications()
 		}
@@ -247,6 +457,9 @@ func (g *Group) ResetResponseVerifications() {
 //	{
 //	  "fifo.Group" : {
 //	    "scope": ["request", "result"],
+//	    "failClosed": ["request"],
+//	    "recoverPanics": true,
+//	    "modifierTimeoutMs": 500,
 //	    "modifiers": [
 //	      { ... },
 //	      { ... },
@@ -259,10 +472,26 @@ func groupFromJSON(b []byte) (*parse.Result, error) {
 		return nil, err
 	}
 
+	if msg.TimeoutMs < 0 {
+		return nil, fmt.Errorf("fifo: modifierTimeoutMs must not be negative")
+	}
+
 	g := NewGroup()
 	if msg.AggregateErrors {
 		g.SetAggregateErrors(true)
 	}
+	g.SetRecoverPanics(msg.RecoverPanics)
+	g.SetModifierTimeout(time.Duration(msg.TimeoutMs) * time.Millisecond)
+	for _, scope := range msg.FailClosed {
+		switch scope {
+		case parse.Request:
+			g.SetRequestFailClosed(true)
+		case parse.Response:
+			g.SetResponseFailClosed(true)
+		default:
+			return nil, fmt.Errorf("fifo: unknown failClosed scope %q", scope)
+		}
+	}
 
 	for _, m := range msg.Modifiers {
 		r, err := parse.FromJSON(m)
@@ -286,7 +515,8 @@ func groupFromJSON(b []byte) (*parse.Result, error) {
 
 // ToImmutable creates ImmutableGroup from existing Group.
 // If a Group has a modifier that is another Group it will also become immutable.
-// Moreover, if the aggregateErrors settings match between the two groups the other group's modifiers are inlined.
+// Moreover, if the aggregateErrors and fail-closed settings match between the two groups, and neither group
+// guards its modifiers with panic recovery or a timeout, the other group's modifiers are inlined.
 func (g *Group) ToImmutable() *ImmutableGroup {
 	g.reqmu.Lock()
 	defer g.reqmu.Unlock()
@@ -296,7 +526,7 @@ func (g *Group) ToImmutable() *ImmutableGroup {
 	var reqmods []martian.RequestModifier
 	for _, m := range g.reqmods {
 		if mm, ok := m.(*Group); ok {
-			if im := mm.ToImmutable(); g.aggregateErrors == im.aggregateErrors {
+			if im := mm.ToImmutable(); g.aggregateErrors == im.aggregateErrors && g.reqFailClosed == im.reqFailClosed && !g.guarded() && !im.guarded() {
 				reqmods = append(reqmods, im.reqmods...)
 			} else {
 				reqmods = append(reqmods, im)
@@ -309,7 +539,7 @@ func (g *Group) ToImmutable() *ImmutableGroup {
 	var resmods []martian.ResponseModifier
 	for _, m := range g.resmods {
 		if mm, ok := m.(*Group); ok {
-			if im := mm.ToImmutable(); g.aggregateErrors == im.aggregateErrors {
+			if im := mm.ToImmutable(); g.aggregateErrors == im.aggregateErrors && g.resFailClosed == im.resFailClosed && !g.guarded() && !im.guarded() {
 				resmods = append(resmods, im.resmods...)
 			} else {
 				resmods = append(resmods, im)
@@ -324,6 +554,10 @@ func (g *Group) ToImmutable() *ImmutableGroup {
 			reqmods:         reqmods,
 			resmods:         resmods,
 			aggregateErrors: g.aggregateErrors,
+			reqFailClosed:   g.reqFailClosed,
+			resFailClosed:   g.resFailClosed,
+			recoverPanics:   g.recoverPanics,
+			timeout:         g.timeout,
 		},
 	}
 }
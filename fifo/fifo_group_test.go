@@ -20,6 +20,7 @@ import (
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/martiantest"
@@ -432,3 +433,233 @@ func TestModifyRequestInlineGroupsAggregateErrors(t *testing.T) {
 		t.Fatalf("ig.ModifyRequest(): got %v, want %v", err, want)
 	}
 }
+
+func TestGroupFailClosed(t *testing.T) {
+	for _, aggregate := range []bool{false, true} {
+		fg := NewGroup()
+		fg.SetAggregateErrors(aggregate)
+
+		tm := martiantest.NewModifier()
+		tm.RequestError(errors.New("request error"))
+		tm.ResponseError(errors.New("response error"))
+		fg.AddRequestModifier(tm)
+		fg.AddResponseModifier(tm)
+
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		res := proxyutil.NewResponse(200, nil, req)
+
+		if err := fg.ModifyRequest(req); martian.IsAbort(err) {
+			t.Errorf("fg.ModifyRequest(): got abort error %v, want fail-open error", err)
+		}
+
+		fg.SetRequestFailClosed(true)
+		if err := fg.ModifyRequest(req); !martian.IsAbort(err) {
+			t.Errorf("fg.ModifyRequest(): got %v, want abort error", err)
+		}
+		if err := fg.ModifyResponse(res); martian.IsAbort(err) {
+			t.Errorf("fg.ModifyResponse(): got abort error %v, want fail-open error", err)
+		}
+
+		fg.SetResponseFailClosed(true)
+		if err := fg.ModifyResponse(res); !martian.IsAbort(err) {
+			t.Errorf("fg.ModifyResponse(): got %v, want abort error", err)
+		}
+		if err := fg.ToImmutable().ModifyResponse(res); !martian.IsAbort(err) {
+			t.Errorf("fg.ToImmutable().ModifyResponse(): got %v, want abort error", err)
+		}
+	}
+}
+
+func TestGroupFromJSONFailClosed(t *testing.T) {
+	msg := []byte(`{
+    "fifo.Group": {
+      "scope": ["request", "response"],
+      "failClosed": ["response"],
+      "modifiers": []
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	fg, ok := r.ResponseModifier().(*Group)
+	if !ok {
+		t.Fatal("r.ResponseModifier().(*Group): got !ok, want ok")
+	}
+	if fg.reqFailClosed {
+		t.Error("fg.reqFailClosed: got true, want false")
+	}
+	if !fg.resFailClosed {
+		t.Error("fg.resFailClosed: got false, want true")
+	}
+
+	msg = []byte(`{
+    "fifo.Group": {
+      "failClosed": ["result"],
+      "modifiers": []
+    }
+  }`)
+	if _, err := parse.FromJSON(msg); err == nil {
+		t.Error("parse.FromJSON(): got nil error, want error for unknown scope")
+	}
+}
+
+func TestGroupRemove(t *testing.T) {
+	fg := NewGroup()
+	tm1 := martiantest.NewModifier()
+	tm2 := martiantest.NewModifier()
+	tm3 := martiantest.NewModifier()
+
+	fg.AddRequestModifier(tm1, "first")
+	fg.AddResponseModifier(tm1, "first")
+	fg.AddRequestModifier(tm2)
+	fg.AddRequestModifier(tm3, "third")
+
+	if fg.Remove("missing") {
+		t.Errorf("fg.Remove(%q): got true, want false", "missing")
+	}
+	if fg.Remove("") {
+		t.Errorf("fg.Remove(%q): got true, want false", "")
+	}
+	if !fg.Remove("first") {
+		t.Errorf("fg.Remove(%q): got false, want true", "first")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := fg.ModifyRequest(req); err != nil {
+		t.Fatalf("fg.ModifyRequest(): got %v, want no error", err)
+	}
+	if err := fg.ModifyResponse(proxyutil.NewResponse(200, nil, req)); err != nil {
+		t.Fatalf("fg.ModifyResponse(): got %v, want no error", err)
+	}
+
+	if tm1.RequestModified() {
+		t.Error("tm1.RequestModified(): got true, want false")
+	}
+	if tm1.ResponseModified() {
+		t.Error("tm1.ResponseModified(): got true, want false")
+	}
+	if !tm2.RequestModified() {
+		t.Error("tm2.RequestModified(): got false, want true")
+	}
+	if !tm3.RequestModified() {
+		t.Error("tm3.RequestModified(): got false, want true")
+	}
+}
+
+func TestGroupReplace(t *testing.T) {
+	fg := NewGroup()
+	var order []string
+	add := func(name string, id ...string) {
+		tm := martiantest.NewModifier()
+		tm.RequestFunc(func(*http.Request) { order = append(order, name) })
+		fg.AddRequestModifier(tm, id...)
+	}
+	add("first")
+	add("second", "toggle")
+	add("third")
+
+	tm := martiantest.NewModifier()
+	tm.RequestFunc(func(*http.Request) { order = append(order, "replaced") })
+	if err := fg.Replace("toggle", tm); err != nil {
+		t.Fatalf("fg.Replace(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := fg.ModifyRequest(req); err != nil {
+		t.Fatalf("fg.ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := order, []string{"first", "replaced", "third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order: got %v, want %v", got, want)
+	}
+
+	if err := fg.Replace("missing", tm); err == nil {
+		t.Error("fg.Replace(): got nil, want error for unknown ID")
+	}
+
+	fg.AddResponseModifier(martiantest.NewModifier(), "response")
+	reqonly := martian.RequestModifierFunc(func(*http.Request) error { return nil })
+	if err := fg.Replace("response", reqonly); err == nil {
+		t.Error("fg.Replace(): got nil, want error for request modifier replacing a response modifier")
+	}
+}
+
+func TestGroupGuardsModifiers(t *testing.T) {
+	fg := NewGroup()
+	fg.SetAggregateErrors(true)
+
+	panicking := martian.RequestModifierFunc(func(*http.Request) error { panic("boom") })
+	hanging := martian.RequestModifierFunc(func(*http.Request) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	tm := martiantest.NewModifier()
+	fg.AddRequestModifier(panicking)
+	fg.AddRequestModifier(hanging)
+	fg.AddRequestModifier(tm)
+
+	fg.SetModifierTimeout(10 * time.Millisecond)
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	for _, g := range []martian.RequestModifier{fg, fg.ToImmutable()} {
+		err := g.ModifyRequest(req)
+		merr, ok := err.(*martian.MultiError)
+		if !ok {
+			t.Fatalf("ModifyRequest(): got %v, want *martian.MultiError", err)
+		}
+		want := []string{
+			"martian: martian.RequestModifierFunc panicked: boom",
+			"martian: martian.RequestModifierFunc timed out after 10ms",
+		}
+		if got := merr.Errors(); len(got) != len(want) {
+			t.Fatalf("merr.Errors(): got %v, want %v", got, want)
+		}
+		for i, err := range merr.Errors() {
+			if got := err.Error(); got != want[i] {
+				t.Errorf("merr.Errors()[%d]: got %q, want %q", i, got, want[i])
+			}
+		}
+	}
+	if got, want := tm.RequestCount(), int32(2); got != want {
+		t.Errorf("tm.RequestCount(): got %d, want %d", got, want)
+	}
+}
+
+func TestGroupFromJSONGuards(t *testing.T) {
+	msg := []byte(`{
+    "fifo.Group": {
+      "scope": ["request"],
+      "recoverPanics": true,
+      "modifierTimeoutMs": 250,
+      "modifiers": []
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	fg := r.RequestModifier().(*Group)
+	if !fg.recoverPanics {
+		t.Error("fg.recoverPanics: got false, want true")
+	}
+	if got, want := fg.timeout, 250*time.Millisecond; got != want {
+		t.Errorf("fg.timeout: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package fifo
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+)
+
+// ParallelGroup is a martian.RequestResponseModifier that runs its request
+// and response modifiers concurrently on each message and returns once all of
+// them have returned, with a MultiError of the errors they returned, in the
+// order the modifiers were added.
+//
+// It suits slow and independent modifiers, such as verifiers or callouts.
+// The modifiers of a ParallelGroup share the message, so they must be safe to
+// run concurrently on it: at most one of them may read or replace the body,
+// and they must not modify the same parts of the message.
+type ParallelGroup struct {
+	reqmu   sync.RWMutex
+	reqmods []martian.RequestModifier
+	resmu   sync.RWMutex
+	resmods []martian.ResponseModifier
+}
+
+type parallelGroupJSON struct {
+	Modifiers []json.RawMessage    `json:"modifiers"`
+	Scope     []parse.ModifierType `json:"scope"`
+}
+
+func init() {
+	parse.Register("fifo.ParallelGroup", parallelGroupFromJSON)
+}
+
+// NewParallelGroup returns a parallel modifier group.
+func NewParallelGroup() *ParallelGroup {
+	return &ParallelGroup{}
+}
+
+// AddRequestModifier adds a RequestModifier to the group's list of request modifiers.
+func (g *ParallelGroup) AddRequestModifier(reqmod martian.RequestModifier) {
+	g.reqmu.Lock()
+	defer g.reqmu.Unlock()
+
+	g.reqmods = append(g.reqmods, reqmod)
+}
+
+// AddResponseModifier adds a ResponseModifier to the group's list of response modifiers.
+func (g *ParallelGroup) AddResponseModifier(resmod martian.ResponseModifier) {
+	g.resmu.Lock()
+	defer g.resmu.Unlock()
+
+	g.resmods = append(g.resmods, resmod)
+}
+
+// ModifyRequest runs the request modifiers concurrently on req and returns
+// the errors they returned.
+func (g *ParallelGroup) ModifyRequest(req *http.Request) error {
+	g.reqmu.RLock()
+	defer g.reqmu.RUnlock()
+
+	errs := make([]error, len(g.reqmods))
+	var wg sync.WaitGroup
+	for i, reqmod := range g.reqmods {
+		wg.Add(1)
+		go func(i int, reqmod martian.RequestModifier) {
+			defer wg.Done()
+			errs[i] = reqmod.ModifyRequest(req)
+		}(i, reqmod)
+	}
+	wg.Wait()
+
+	return joinErrors(errs)
+}
+
+// ModifyResponse runs the response modifiers concurrently on res and returns
+// the errors they returned.
+func (g *ParallelGroup) ModifyResponse(res *http.Response) error {
+	g.resmu.RLock()
+	defer g.resmu.RUnlock()
+
+	errs := make([]error, len(g.resmods))
+	var wg sync.WaitGroup
+	for i, resmod := range g.resmods {
+		wg.Add(1)
+		go func(i int, resmod martian.ResponseModifier) {
+			defer wg.Done()
+			errs[i] = resmod.ModifyResponse(res)
+		}(i, resmod)
+	}
+	wg.Wait()
+
+	return joinErrors(errs)
+}
+
+// joinErrors returns a MultiError of the errors that are not nil, or nil if
+// there are none.
+func joinErrors(errs []error) error {
+	merr := martian.NewMultiError()
+	for _, err := range errs {
+		if err != nil {
+			merr.Add(err)
+		}
+	}
+
+	if merr.Empty() {
+		return nil
+	}
+
+	return merr
+}
+
+// VerifyRequests returns a MultiError containing all the
+// verification errors returned by request verifiers.
+func (g *ParallelGroup) VerifyRequests() error {
+	g.reqmu.Lock()
+	defer g.reqmu.Unlock()
+
+	return verifyRequests(g.reqmods)
+}
+
+// VerifyResponses returns a MultiError containing all the
+// verification errors returned by response verifiers.
+func (g *ParallelGroup) VerifyResponses() error {
+	g.resmu.Lock()
+	defer g.resmu.Unlock()
+
+	return verifyResponses(g.resmods)
+}
+
+// ResetRequestVerifications resets the state of the contained request verifiers.
+func (g *ParallelGroup) ResetRequestVerifications() {
+	g.reqmu.Lock()
+	defer g.reqmu.Unlock()
+
+	resetRequestVerifications(g.reqmods)
+}
+
+// ResetResponseVerifications resets the state of the contained response verifiers.
+func (g *ParallelGroup) ResetResponseVerifications() {
+	g.resmu.Lock()
+	defer g.resmu.Unlock()
+
+	resetResponseVerifications(g.resmods)
+}
+
+// parallelGroupFromJSON builds a fifo.ParallelGroup from JSON. To fail closed,
+// nest it in a fifo.Group that does.
+//
+// Example JSON:
+//
+//	{
+//	  "fifo.ParallelGroup" : {
+//	    "scope": ["request", "response"],
+//	    "modifiers": [
+//	      { ... },
+//	      { ... },
+//	    ]
+//	  }
+//	}
+func parallelGroupFromJSON(b []byte) (*parse.Result, error) {
+	msg := &parallelGroupJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	g := NewParallelGroup()
+	for _, m := range msg.Modifiers {
+		r, err := parse.FromJSON(m)
+		if err != nil {
+			return nil, err
+		}
+
+		if reqmod := r.RequestModifier(); reqmod != nil {
+			g.AddRequestModifier(reqmod)
+		}
+		if resmod := r.ResponseModifier(); resmod != nil {
+			g.AddResponseModifier(resmod)
+		}
+	}
+
+	return parse.NewResult(g, msg.Scope)
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package fifo
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/martiantest"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+	"github.com/google/martian/v3/verify"
+)
+
+func TestParallelGroupRunsConcurrently(t *testing.T) {
+	const n = 4
+
+	g := NewParallelGroup()
+	var started sync.WaitGroup
+	started.Add(n)
+	for i := 0; i < n; i++ {
+		g.AddRequestModifier(martian.RequestModifierFunc(func(*http.Request) error {
+			// Each modifier waits for all of them to start, which only
+			// happens if they run concurrently.
+			started.Done()
+			started.Wait()
+			return nil
+		}))
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- g.ModifyRequest(req)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("g.ModifyRequest(): got %v, want no error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("g.ModifyRequest(): modifiers did not run concurrently")
+	}
+}
+
+func TestParallelGroupJoinsErrors(t *testing.T) {
+	g := NewParallelGroup()
+
+	errs := []error{errors.New("first"), nil, errors.New("third")}
+	for i, err := range errs {
+		tm := martiantest.NewModifier()
+		if err != nil {
+			if i == 0 {
+				// The first modifier returns last.
+				tm.ResponseFunc(func(*http.Response) { time.Sleep(10 * time.Millisecond) })
+			}
+			tm.ResponseError(err)
+		}
+		g.AddResponseModifier(tm)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+
+	err = g.ModifyResponse(res)
+	merr, ok := err.(*martian.MultiError)
+	if !ok {
+		t.Fatalf("g.ModifyResponse(): got %v, want *martian.MultiError", err)
+	}
+	if got, want := len(merr.Errors()), 2; got != want {
+		t.Fatalf("len(merr.Errors()): got %d, want %d", got, want)
+	}
+	for i, want := range []error{errs[0], errs[2]} {
+		if got := merr.Errors()[i]; got != want {
+			t.Errorf("merr.Errors()[%d]: got %v, want %v", i, got, want)
+		}
+	}
+
+	if err := NewParallelGroup().ModifyResponse(res); err != nil {
+		t.Errorf("NewParallelGroup().ModifyResponse(): got %v, want no error", err)
+	}
+}
+
+func TestParallelGroupVerifications(t *testing.T) {
+	g := NewParallelGroup()
+
+	for _, msg := range []string{"first", "second"} {
+		tv := &verify.TestVerifier{
+			RequestError: errors.New(msg),
+		}
+		g.AddRequestModifier(tv)
+	}
+
+	merr, ok := g.VerifyRequests().(*martian.MultiError)
+	if !ok {
+		t.Fatal("g.VerifyRequests(): got nil, want *martian.MultiError")
+	}
+	if got, want := len(merr.Errors()), 2; got != want {
+		t.Errorf("len(merr.Errors()): got %d, want %d", got, want)
+	}
+
+	g.ResetRequestVerifications()
+	if err := g.VerifyRequests(); err != nil {
+		t.Errorf("g.VerifyRequests(): got %v, want no error", err)
+	}
+}
+
+func TestParallelGroupFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "fifo.ParallelGroup": {
+      "scope": ["request", "response"],
+      "modifiers": [
+        {
+          "header.Modifier": {
+            "scope": ["request"],
+            "name": "X-Testing",
+            "value": "true"
+          }
+        },
+        {
+          "header.Modifier": {
+            "scope": ["response"],
+            "name": "Y-Testing",
+            "value": "true"
+          }
+        }
+      ]
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Testing"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Testing", got, want)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("resmod: got nil, want not nil")
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Y-Testing"), "true"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Y-Testing", got, want)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/google/martian/v3/parse"
+)
+
+// Condition is a condition that evaluates both requests and responses.
+type Condition interface {
+	RequestCondition
+	ResponseCondition
+}
+
+type allCondition []Condition
+
+// All returns a condition that matches iff all of conds match. Conditions
+// are evaluated in order, up to the first one that does not match.
+func All(conds ...Condition) Condition {
+	return allCondition(conds)
+}
+
+// MatchRequest returns true iff all conditions match req.
+func (c allCondition) MatchRequest(req *http.Request) bool {
+	for _, cond := range c {
+		if !cond.MatchRequest(req) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchResponse returns true iff all conditions match res.
+func (c allCondition) MatchResponse(res *http.Response) bool {
+	for _, cond := range c {
+		if !cond.MatchResponse(res) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type anyCondition []Condition
+
+// Any returns a condition that matches iff any of conds matches. Conditions
+// are evaluated in order, up to the first one that matches.
+func Any(conds ...Condition) Condition {
+	return anyCondition(conds)
+}
+
+// MatchRequest returns true iff any condition matches req.
+func (c anyCondition) MatchRequest(req *http.Request) bool {
+	for _, cond := range c {
+		if cond.MatchRequest(req) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchResponse returns true iff any condition matches res.
+func (c anyCondition) MatchResponse(res *http.Response) bool {
+	for _, cond := range c {
+		if cond.MatchResponse(res) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type notCondition struct {
+	cond Condition
+}
+
+// Not returns a condition that matches iff cond does not match.
+func Not(cond Condition) Condition {
+	return &notCondition{
+		cond: cond,
+	}
+}
+
+// MatchRequest returns true iff the condition does not match req.
+func (c *notCondition) MatchRequest(req *http.Request) bool {
+	return !c.cond.MatchRequest(req)
+}
+
+// MatchResponse returns true iff the condition does not match res.
+func (c *notCondition) MatchResponse(res *http.Response) bool {
+	return !c.cond.MatchResponse(res)
+}
+
+var (
+	condmu sync.RWMutex
+	conds  = make(map[string]func(b []byte) (Condition, error))
+)
+
+// RegisterCondition registers a function that builds a condition from the
+// JSON message keyed by name in condition messages. Packages providing
+// matchers register them in init.
+func RegisterCondition(name string, condFromJSON func(b []byte) (Condition, error)) {
+	condmu.Lock()
+	defer condmu.Unlock()
+
+	conds[name] = condFromJSON
+}
+
+// ConditionFromJSON builds a condition from a JSON message holding a single
+// key: "and" or "or" with a list of conditions, "not" with a condition, or the
+// name of a registered condition with its message.
+//
+// Example JSON:
+//
+//	{
+//	  "and": [
+//	    { "method": { "method": "POST" } },
+//	    { "not": { "header": { "name": "X-Skip", "value": "true" } } }
+//	  ]
+//	}
+func ConditionFromJSON(b []byte) (Condition, error) {
+	msg := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+	if len(msg) != 1 {
+		return nil, fmt.Errorf("filter: condition must have exactly one key, got %d", len(msg))
+	}
+
+	var name string
+	for name, b = range msg {
+	}
+
+	switch name {
+	case "and", "or":
+		var bs []json.RawMessage
+		if err := json.Unmarshal(b, &bs); err != nil {
+			return nil, err
+		}
+
+		cs := make([]Condition, 0, len(bs))
+		for _, b := range bs {
+			c, err := ConditionFromJSON(b)
+			if err != nil {
+				return nil, err
+			}
+			cs = append(cs, c)
+		}
+
+		if name == "and" {
+			return All(cs...), nil
+		}
+		return Any(cs...), nil
+	case "not":
+		c, err := ConditionFromJSON(b)
+		if err != nil {
+			return nil, err
+		}
+
+		return Not(c), nil
+	}
+
+	condmu.RLock()
+	condFromJSON, ok := conds[name]
+	condmu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("filter: no condition registered for %q", name)
+	}
+
+	return condFromJSON(b)
+}
+
+type filterJSON struct {
+	Condition    json.RawMessage      `json:"condition"`
+	Modifier     json.RawMessage      `json:"modifier"`
+	ElseModifier json.RawMessage      `json:"else"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+func init() {
+	parse.Register("filter.Filter", filterFromJSON)
+}
+
+// filterFromJSON builds a filter.Filter from JSON, with a condition as
+// described by ConditionFromJSON.
+//
+// Example JSON:
+//
+//	{
+//	  "filter.Filter": {
+//	    "scope": ["request", "response"],
+//	    "condition": {
+//	      "or": [
+//	        { "url": { "host": "example.com" } },
+//	        { "port": { "port": 8080 } }
+//	      ]
+//	    },
+//	    "modifier": { ... },
+//	    "else": { ... }
+//	  }
+//	}
+func filterFromJSON(b []byte) (*parse.Result, error) {
+	msg := &filterJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	cond, err := ConditionFromJSON(msg.Condition)
+	if err != nil {
+		return nil, err
+	}
+
+	f := New()
+	f.SetRequestCondition(cond)
+	f.SetResponseCondition(cond)
+
+	m, err := parse.FromJSON(msg.Modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	f.RequestWhenTrue(m.RequestModifier())
+	f.ResponseWhenTrue(m.ResponseModifier())
+
+	if len(msg.ElseModifier) > 0 {
+		em, err := parse.FromJSON(msg.ElseModifier)
+		if err != nil {
+			return nil, err
+		}
+
+		if em != nil {
+			f.RequestWhenFalse(em.RequestModifier())
+			f.ResponseWhenFalse(em.ResponseModifier())
+		}
+	}
+
+	return parse.NewResult(f, msg.Scope)
+}
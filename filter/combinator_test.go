@@ -0,0 +1,153 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package filter_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/google/martian/v3/filter"
+	"github.com/google/martian/v3/martiantest"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+
+	// Import to register header.Modifier and the conditions with the JSON
+	// parsers.
+	_ "github.com/google/martian/v3/header"
+	_ "github.com/google/martian/v3/martianurl"
+	_ "github.com/google/martian/v3/method"
+	_ "github.com/google/martian/v3/port"
+)
+
+func matcher(val bool) *martiantest.Matcher {
+	m := martiantest.NewMatcher()
+	m.RequestEvaluatesTo(val)
+	m.ResponseEvaluatesTo(val)
+	return m
+}
+
+func TestCombinators(t *testing.T) {
+	yes, no := matcher(true), matcher(false)
+
+	tt := []struct {
+		cond Condition
+		want bool
+	}{
+		{All(), true},
+		{All(yes, yes), true},
+		{All(yes, no), false},
+		{Any(), false},
+		{Any(no, yes), true},
+		{Any(no, no), false},
+		{Not(yes), false},
+		{Not(All(yes, no)), true},
+		{Any(All(yes, Not(no)), no), true},
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+
+	for i, tc := range tt {
+		if got := tc.cond.MatchRequest(req); got != tc.want {
+			t.Errorf("%d. MatchRequest(): got %t, want %t", i, got, tc.want)
+		}
+		if got := tc.cond.MatchResponse(res); got != tc.want {
+			t.Errorf("%d. MatchResponse(): got %t, want %t", i, got, tc.want)
+		}
+	}
+}
+
+func TestConditionFromJSON(t *testing.T) {
+	tt := []struct {
+		msg  string
+		want bool
+	}{
+		{`{"method": {"method": "POST"}}`, true},
+		{`{"url": {"host": "example.com", "path": "/other"}}`, false},
+		{`{"header": {"name": "x-testing", "value": "true"}}`, true},
+		{`{"port": {"port": 8080}}`, true},
+		{`{"and": [{"method": {"method": "POST"}}, {"port": {"port": 80}}]}`, false},
+		{`{"or": [{"port": {"port": 80}}, {"url": {"host": "example.com:8080"}}]}`, true},
+		{`{"not": {"or": [{"method": {"method": "GET"}}]}}`, true},
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com:8080/path", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-Testing", "true")
+
+	for i, tc := range tt {
+		cond, err := ConditionFromJSON([]byte(tc.msg))
+		if err != nil {
+			t.Fatalf("%d. ConditionFromJSON(): got %v, want no error", i, err)
+		}
+		if got := cond.MatchRequest(req); got != tc.want {
+			t.Errorf("%d. MatchRequest(): got %t, want %t", i, got, tc.want)
+		}
+	}
+
+	for i, msg := range []string{
+		`{}`,
+		`{"port": {"port": 80}, "method": {"method": "GET"}}`,
+		`{"unknown": {}}`,
+		`{"and": {"port": {"port": 80}}}`,
+		`{"not": [{"port": {"port": 80}}]}`,
+	} {
+		if _, err := ConditionFromJSON([]byte(msg)); err == nil {
+			t.Errorf("%d. ConditionFromJSON(%s): got nil, want error", i, msg)
+		}
+	}
+}
+
+func TestFilterFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "filter.Filter": {
+      "scope": ["request"],
+      "condition": {
+        "and": [
+          { "method": { "method": "GET" } },
+          { "not": { "header": { "name": "X-Skip", "value": "true" } } }
+        ]
+      },
+      "modifier": {
+        "header.Modifier": {
+          "scope": ["request"],
+          "name": "X-Testing",
+          "value": "true"
+        }
+      },
+      "else": {
+        "header.Modifier": {
+          "scope": ["request"],
+          "name": "X-Testing",
+          "value": "false"
+        }
+      }
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Testing"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Testing", got, want)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package fronting provides a modifier that detects requests in MITM'd tunnels
+// whose TLS server name, CONNECT target and Host header disagree, as is the
+// case with domain fronting.
+package fronting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("fronting.Modifier", modifierFromJSON)
+}
+
+const (
+	contextKey        = "fronting.Mismatch"
+	mismatchErrFormat = "request(%s) fronting verify failure: %v"
+)
+
+// MismatchError describes a request whose TLS server name, CONNECT host and
+// Host header do not all name the same host. Empty values are not compared.
+type MismatchError struct {
+	ServerName  string
+	ConnectHost string
+	Host        string
+}
+
+// Error returns a formatted error message for a MismatchError.
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("fronting: host mismatch: TLS server name %q, CONNECT host %q, Host %q",
+		e.ServerName, e.ConnectHost, e.Host)
+}
+
+// Check returns a *MismatchError if the TLS server name, the CONNECT host of
+// the session and the Host header of req do not match, otherwise nil.
+func Check(req *http.Request) error {
+	e := &MismatchError{
+		ConnectHost: martian.NewContext(req).Session().ConnectHost(),
+		Host:        req.Host,
+	}
+	if req.TLS != nil {
+		e.ServerName = req.TLS.ServerName
+	}
+
+	var want string
+	for _, h := range []string{e.ServerName, e.ConnectHost, e.Host} {
+		h = hostname(h)
+		if h == "" {
+			continue
+		}
+		if want == "" {
+			want = h
+			continue
+		}
+		if h != want {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// FromContext returns the mismatch detected by a Modifier for the request of
+// ctx, or nil if there was none.
+func FromContext(ctx *martian.Context) *MismatchError {
+	v, ok := ctx.Get(contextKey)
+	if !ok {
+		return nil
+	}
+
+	return v.(*MismatchError)
+}
+
+// Modifier detects host mismatches. Mismatches are logged, stored in the
+// request context and reported as request verification failures. If blocking
+// is enabled the round trip is skipped and the client receives a 421
+// Misdirected Request response.
+type Modifier struct {
+	block bool
+
+	mu     sync.RWMutex
+	reqerr *martian.MultiError
+}
+
+type modifierJSON struct {
+	Block bool                 `json:"block"`
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a new host mismatch modifier that does not block.
+func NewModifier() *Modifier {
+	return &Modifier{
+		reqerr: martian.NewMultiError(),
+	}
+}
+
+// SetBlocking sets whether requests with a host mismatch are blocked.
+func (m *Modifier) SetBlocking(block bool) {
+	m.block = block
+}
+
+// ModifyRequest checks req for a host mismatch.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	err := Check(req)
+	if err == nil {
+		return nil
+	}
+
+	log.Infof("fronting: %s: %v", req.URL, err)
+
+	ctx := martian.NewContext(req)
+	ctx.Set(contextKey, err)
+
+	m.mu.Lock()
+	m.reqerr.Add(fmt.Errorf(mismatchErrFormat, req.URL, err))
+	m.mu.Unlock()
+
+	if m.block {
+		ctx.SkipRoundTrip()
+	}
+
+	return nil
+}
+
+// ModifyResponse sets the status of the response to 421 Misdirected Request
+// if blocking is enabled and a host mismatch was detected for the request.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if !m.block || res.Request == nil {
+		return nil
+	}
+
+	if FromContext(martian.NewContext(res.Request)) != nil {
+		res.StatusCode = http.StatusMisdirectedRequest
+		res.Status = http.StatusText(http.StatusMisdirectedRequest)
+	}
+
+	return nil
+}
+
+// VerifyRequests returns an error if a host mismatch was detected for any
+// request. If an error is returned it will be of type *martian.MultiError.
+func (m *Modifier) VerifyRequests() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.reqerr.Empty() {
+		return nil
+	}
+
+	return m.reqerr
+}
+
+// ResetRequestVerifications clears all failed request verifications.
+func (m *Modifier) ResetRequestVerifications() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reqerr = martian.NewMultiError()
+}
+
+// modifierFromJSON builds a fronting.Modifier from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "fronting.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "block": true
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	m := NewModifier()
+	m.SetBlocking(msg.Block)
+
+	return parse.NewResult(m, msg.Scope)
+}
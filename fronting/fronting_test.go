@@ -0,0 +1,156 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package fronting
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+	"github.com/google/martian/v3/verify"
+)
+
+func TestCheck(t *testing.T) {
+	tt := []struct {
+		serverName  string
+		connectHost string
+		host        string
+		mismatch    bool
+	}{
+		{"", "", "example.com", false},
+		{"example.com", "example.com:443", "example.com", false},
+		{"Example.COM", "example.com:443", "example.com.", false},
+		{"", "example.com:443", "example.com:443", false},
+		{"front.example.com", "front.example.com:443", "hidden.example.com", true},
+		{"front.example.com", "hidden.example.com:443", "hidden.example.com", true},
+		{"", "front.example.com:443", "hidden.example.com", true},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", "https://example.com", nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		req.Host = tc.host
+		if tc.serverName != "" {
+			req.TLS = &tls.ConnectionState{ServerName: tc.serverName}
+		}
+
+		ctx := martian.TestContext(req, nil, nil)
+		ctx.Session().SetConnectHost(tc.connectHost)
+
+		err = Check(req)
+		if got, want := err != nil, tc.mismatch; got != want {
+			t.Errorf("%d. Check(): got %v, want mismatch %t", i, err, want)
+		}
+	}
+}
+
+func TestModifier(t *testing.T) {
+	m := NewModifier()
+
+	req, err := http.NewRequest("GET", "https://hidden.example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.TLS = &tls.ConnectionState{ServerName: "front.example.com"}
+
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	mm := FromContext(ctx)
+	if mm == nil {
+		t.Fatal("FromContext(): got nil, want mismatch")
+	}
+	if got, want := mm.ServerName, "front.example.com"; got != want {
+		t.Errorf("mm.ServerName: got %q, want %q", got, want)
+	}
+	if ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got true, want false")
+	}
+
+	merr, ok := m.VerifyRequests().(*martian.MultiError)
+	if !ok {
+		t.Fatalf("VerifyRequests(): got %T, want *martian.MultiError", m.VerifyRequests())
+	}
+	if got, want := len(merr.Errors()), 1; got != want {
+		t.Errorf("len(merr.Errors()): got %d, want %d", got, want)
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, 200; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+
+	m.ResetRequestVerifications()
+	if err := m.VerifyRequests(); err != nil {
+		t.Errorf("VerifyRequests(): got %v, want no error", err)
+	}
+}
+
+func TestModifierBlocking(t *testing.T) {
+	m := NewModifier()
+	m.SetBlocking(true)
+
+	req, err := http.NewRequest("GET", "https://hidden.example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.TLS = &tls.ConnectionState{ServerName: "front.example.com"}
+
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got false, want true")
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, http.StatusMisdirectedRequest; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"fronting.Modifier": {
+			"scope": ["request", "response"],
+			"block": true
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+	if _, ok := reqmod.(verify.RequestVerifier); !ok {
+		t.Error("reqmod.(verify.RequestVerifier): got !ok, want ok")
+	}
+	if r.ResponseModifier() == nil {
+		t.Fatal("resmod: got nil, want not nil")
+	}
+
+	m := reqmod.(*Modifier)
+	if !m.block {
+		t.Error("m.block: got false, want true")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package graphql
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/martian/v3/filter"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("graphql.Filter", filterFromJSON)
+}
+
+// Filter runs modifiers iff the request is a GraphQL request executing a
+// matching operation.
+type Filter struct {
+	*filter.Filter
+}
+
+type filterJSON struct {
+	OperationType string               `json:"operationType"`
+	OperationName string               `json:"operationName"`
+	Modifier      json.RawMessage      `json:"modifier"`
+	ElseModifier  json.RawMessage      `json:"else"`
+	Scope         []parse.ModifierType `json:"scope"`
+}
+
+// NewFilter builds a graphql.Filter that filters on the operation type and
+// name, see NewMatcher.
+func NewFilter(opType, opName string) *Filter {
+	m := NewMatcher(opType, opName)
+	f := filter.New()
+	f.SetRequestCondition(m)
+	f.SetResponseCondition(m)
+	return &Filter{f}
+}
+
+// filterFromJSON takes a JSON message and returns a graphql.Filter.
+//
+// Example JSON:
+//
+//	{
+//	  "operationType": "mutation",
+//	  "operationName": "CreateUser",
+//	  "scope": ["request", "response"],
+//	  "modifier": { ... }
+//	}
+func filterFromJSON(b []byte) (*parse.Result, error) {
+	msg := &filterJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	f := NewFilter(msg.OperationType, msg.OperationName)
+
+	r, err := parse.FromJSON(msg.Modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	f.RequestWhenTrue(r.RequestModifier())
+	f.ResponseWhenTrue(r.ResponseModifier())
+
+	if len(msg.ElseModifier) > 0 {
+		em, err := parse.FromJSON(msg.ElseModifier)
+		if err != nil {
+			return nil, err
+		}
+
+		if em != nil {
+			f.RequestWhenFalse(em.RequestModifier())
+			f.ResponseWhenFalse(em.ResponseModifier())
+		}
+	}
+
+	return parse.NewResult(f, msg.Scope)
+}
+
+// Matcher is a conditional evaluator of GraphQL operations to be used in
+// filters that take conditionals.
+type Matcher struct {
+	opType string
+	opName string
+}
+
+// NewMatcher builds a new GraphQL operation matcher. It matches GraphQL
+// requests whose operation type matches opType, case-insensitively, and whose
+// operation name is opName. An empty opType or opName matches any type or
+// name.
+func NewMatcher(opType, opName string) *Matcher {
+	return &Matcher{
+		opType: opType,
+		opName: opName,
+	}
+}
+
+// MatchRequest returns true if the operation of req matches.
+func (m *Matcher) MatchRequest(req *http.Request) bool {
+	op, err := FromRequest(req)
+	if err != nil {
+		log.Debugf("graphql: failed to parse operation of %s: %v", req.URL, err)
+		return false
+	}
+
+	return m.matches(op)
+}
+
+// MatchResponse returns true if the operation of res.Request matches.
+func (m *Matcher) MatchResponse(res *http.Response) bool {
+	return m.MatchRequest(res.Request)
+}
+
+func (m *Matcher) matches(op *Operation) bool {
+	if op == nil {
+		return false
+	}
+	if m.opType != "" && !strings.EqualFold(op.Type, m.opType) {
+		return false
+	}
+
+	return m.opName == "" || op.Name == m.opName
+}
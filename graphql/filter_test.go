@@ -0,0 +1,113 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package graphql
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/martiantest"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+
+	// Import to register header.Modifier with JSON parser.
+	_ "github.com/google/martian/v3/header"
+)
+
+func newGraphQLRequest(t *testing.T, query string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", "http://example.com/graphql", strings.NewReader(query))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", "application/graphql")
+	return req
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		opType, opName string
+		query          string
+		want           bool
+	}{
+		{"", "", "{ a }", true},
+		{"mutation", "", "mutation M { a }", true},
+		{"Mutation", "M", "mutation M { a }", true},
+		{"query", "", "mutation M { a }", false},
+		{"", "N", "mutation M { a }", false},
+		{"", "", "not graphql", false},
+	}
+
+	for _, tc := range tests {
+		f := NewFilter(tc.opType, tc.opName)
+		tm := martiantest.NewModifier()
+		f.SetRequestModifier(tm)
+		f.SetResponseModifier(tm)
+
+		req := newGraphQLRequest(t, tc.query)
+		if err := f.ModifyRequest(req); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+		if got := tm.RequestModified(); got != tc.want {
+			t.Errorf("NewFilter(%q, %q) for %q: tm.RequestModified(): got %t, want %t", tc.opType, tc.opName, tc.query, got, tc.want)
+		}
+
+		tm.Reset()
+		res := proxyutil.NewResponse(200, nil, newGraphQLRequest(t, tc.query))
+		if err := f.ModifyResponse(res); err != nil {
+			t.Fatalf("ModifyResponse(): got %v, want no error", err)
+		}
+		if got := tm.ResponseModified(); got != tc.want {
+			t.Errorf("NewFilter(%q, %q) for %q: tm.ResponseModified(): got %t, want %t", tc.opType, tc.opName, tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestFilterFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"graphql.Filter": {
+			"scope": ["request"],
+			"operationType": "mutation",
+			"operationName": "CreateUser",
+			"modifier": {
+				"header.Modifier": {
+					"scope": ["request"],
+					"name": "Martian-Modified",
+					"value": "true"
+				}
+			},
+			"else": {
+				"header.Modifier": {
+					"scope": ["request"],
+					"name": "Martian-Modified",
+					"value": "false"
+				}
+			}
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	for query, want := range map[string]string{
+		"mutation CreateUser { createUser { id } }": "true",
+		"query GetUser { user { id } }":             "false",
+	} {
+		req := newGraphQLRequest(t, query)
+		if err := reqmod.ModifyRequest(req); err != nil {
+			t.Fatalf("reqmod.ModifyRequest(): got %v, want no error", err)
+		}
+		if got := req.Header.Get("Martian-Modified"); got != want {
+			t.Errorf("%q: req.Header.Get(%q): got %q, want %q", query, "Martian-Modified", got, want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package graphql extracts the operation of GraphQL requests, so that
+// requests can be filtered and logged by operation rather than by their
+// opaque JSON bodies.
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/google/martian/v3"
+)
+
+// MaxBodySize is the maximum size of request bodies parsed for GraphQL
+// operations. Larger bodies are forwarded unparsed.
+const MaxBodySize = 1 << 20
+
+// Operation types.
+const (
+	Query        = "query"
+	Mutation     = "mutation"
+	Subscription = "subscription"
+)
+
+// Operation is the GraphQL operation executed by a request.
+type Operation struct {
+	// Type is the operation type, one of Query, Mutation or Subscription.
+	Type string `json:"type"`
+	// Name is the operation name, empty for anonymous operations.
+	Name string `json:"name,omitempty"`
+}
+
+// String returns the operation type followed by the name, if any.
+func (op *Operation) String() string {
+	if op.Name == "" {
+		return op.Type
+	}
+	return op.Type + " " + op.Name
+}
+
+// operationKey is the context key of the parsed operation of a request.
+const operationKey = "graphql.Operation"
+
+// parsed is the result of parsing a request.
+type parsed struct {
+	op  *Operation
+	err error
+}
+
+// FromRequest returns the GraphQL operation executed by req, or nil if req is
+// not a GraphQL request. GraphQL requests are POST requests with an
+// "application/json" body holding the "query" and optional "operationName"
+// members, POST requests with an "application/graphql" body, and GET requests
+// with a "query" and optional "operationName" query parameter. Batched
+// requests are not supported.
+//
+// The body of req is read and replaced by an in-memory copy. The result is
+// stored in the context of req, so that it is parsed only once.
+func FromRequest(req *http.Request) (*Operation, error) {
+	ctx := martian.NewContext(req)
+	if ctx != nil {
+		if v, ok := ctx.Get(operationKey); ok {
+			p := v.(parsed)
+			return p.op, p.err
+		}
+	}
+
+	op, err := parseRequest(req)
+	if ctx != nil {
+		ctx.Set(operationKey, parsed{op: op, err: err})
+	}
+
+	return op, err
+}
+
+// request is the JSON body of a GraphQL POST request.
+type request struct {
+	Query         *string `json:"query"`
+	OperationName string  `json:"operationName"`
+}
+
+func parseRequest(req *http.Request) (*Operation, error) {
+	switch req.Method {
+	case "GET":
+		q := req.URL.Query()
+		if !q.Has("query") {
+			return nil, nil
+		}
+		return ParseDocument(q.Get("query"), q.Get("operationName"))
+	case "POST":
+	default:
+		return nil, nil
+	}
+
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mt != "application/json" && mt != "application/graphql" {
+		return nil, nil
+	}
+
+	body, ok, err := readBody(req)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	if mt == "application/graphql" {
+		return ParseDocument(string(body), req.URL.Query().Get("operationName"))
+	}
+
+	// Other JSON bodies, including batches, are not GraphQL requests.
+	msg := &request{}
+	if err := json.Unmarshal(body, msg); err != nil || msg.Query == nil {
+		return nil, nil
+	}
+
+	return ParseDocument(*msg.Query, msg.OperationName)
+}
+
+// readBody returns the body of req and replaces it with an in-memory copy. It
+// returns false if the body is larger than MaxBodySize.
+func readBody(req *http.Request) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, false, nil
+	}
+	if req.ContentLength > MaxBodySize {
+		return nil, false, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, MaxBodySize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(body) > MaxBodySize {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: io.MultiReader(bytes.NewReader(body), req.Body),
+			Closer: req.Body,
+		}
+		return nil, false, nil
+	}
+
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, true, nil
+}
+
+// ParseDocument returns the operation named name in the GraphQL document doc,
+// or its only operation if name is empty.
+func ParseDocument(doc, name string) (*Operation, error) {
+	ops, err := operations(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" {
+		for _, op := range ops {
+			if op.Name == name {
+				return op, nil
+			}
+		}
+		return nil, fmt.Errorf("graphql: operation %q not found", name)
+	}
+
+	switch len(ops) {
+	case 0:
+		return nil, errors.New("graphql: document without operation")
+	case 1:
+		return ops[0], nil
+	default:
+		return nil, errors.New("graphql: operation name required for document with multiple operations")
+	}
+}
+
+// operations returns the operations defined in the GraphQL document doc. Only
+// the top level of the document is inspected; selection sets, arguments and
+// fragments are skipped.
+func operations(doc string) ([]*Operation, error) {
+	var (
+		ops   []*Operation
+		depth int
+		// inDef is set from the start of a named operation or fragment at
+		// the top level to the end of its selection set.
+		inDef bool
+		// wantName is set after an operation type keyword.
+		wantName bool
+	)
+
+	for i := 0; i < len(doc); {
+		c := doc[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(doc) && doc[i] != '\n' && doc[i] != '\r' {
+				i++
+			}
+		case c == '"':
+			n, err := stringLen(doc[i:])
+			if err != nil {
+				return nil, err
+			}
+			i += n
+			wantName = false
+		case c == '{' || c == '(' || c == '[':
+			if c == '{' && depth == 0 && !inDef {
+				// A shorthand query.
+				ops = append(ops, &Operation{Type: Query})
+			}
+			depth++
+			i++
+			wantName = false
+		case c == '}' || c == ')' || c == ']':
+			if depth == 0 {
+				return nil, fmt.Errorf("graphql: unexpected %q", c)
+			}
+			depth--
+			if c == '}' && depth == 0 {
+				inDef = false
+			}
+			i++
+		case isNameStart(c):
+			j := i + 1
+			for j < len(doc) && isNameContinue(doc[j]) {
+				j++
+			}
+			if depth == 0 {
+				tok := doc[i:j]
+				switch {
+				case wantName:
+					ops[len(ops)-1].Name = tok
+					wantName = false
+				case inDef:
+				case tok == Query || tok == Mutation || tok == Subscription:
+					ops = append(ops, &Operation{Type: tok})
+					inDef = true
+					wantName = true
+				case tok == "fragment":
+					inDef = true
+				default:
+					return nil, fmt.Errorf("graphql: unexpected %q", tok)
+				}
+			}
+			i = j
+		default:
+			// Other punctuators, such as $, !, :, @ and =.
+			i++
+			wantName = false
+		}
+	}
+
+	if depth != 0 {
+		return nil, errors.New("graphql: unterminated document")
+	}
+
+	return ops, nil
+}
+
+// stringLen returns the length of the string value or block string at the
+// start of s.
+func stringLen(s string) (int, error) {
+	if strings.HasPrefix(s, `"""`) {
+		for i := 3; i < len(s); i++ {
+			switch {
+			case strings.HasPrefix(s[i:], `\"""`):
+				i += 3
+			case strings.HasPrefix(s[i:], `"""`):
+				return i + 3, nil
+			}
+		}
+		return 0, errors.New("graphql: unterminated block string")
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		case '\n', '\r':
+			return 0, errors.New("graphql: unterminated string")
+		}
+	}
+	return 0, errors.New("graphql: unterminated string")
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package graphql
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3"
+)
+
+func TestParseDocument(t *testing.T) {
+	tests := []struct {
+		doc     string
+		name    string
+		want    *Operation
+		wantErr bool
+	}{
+		{
+			doc:  "{ user(id: 1) { name } }",
+			want: &Operation{Type: Query},
+		},
+		{
+			doc:  "query { user { name } }",
+			want: &Operation{Type: Query},
+		},
+		{
+			doc:  `query GetUser($id: ID! = "}") @cached { user(id: $id) { ...F } }`,
+			want: &Operation{Type: Query, Name: "GetUser"},
+		},
+		{
+			doc: "# mutation Commented\n" +
+				"fragment F on User { name }\n" +
+				"mutation CreateUser { createUser(bio: \"\"\"a \\\"\"\" }\"\"\") { id } }",
+			want: &Operation{Type: Mutation, Name: "CreateUser"},
+		},
+		{
+			doc:  "query A { a } subscription B { b }",
+			name: "B",
+			want: &Operation{Type: Subscription, Name: "B"},
+		},
+		{
+			doc:     "query A { a } query B { b }",
+			wantErr: true,
+		},
+		{
+			doc:     "query A { a }",
+			name:    "B",
+			wantErr: true,
+		},
+		{
+			doc:     "query A { a",
+			wantErr: true,
+		},
+		{
+			doc:     "fragment F on User { name }",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseDocument(tc.doc, tc.name)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseDocument(%q, %q): got %v, want error", tc.doc, tc.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDocument(%q, %q): got %v, want no error", tc.doc, tc.name, err)
+			continue
+		}
+		if *got != *tc.want {
+			t.Errorf("ParseDocument(%q, %q): got %+v, want %+v", tc.doc, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		want        *Operation
+	}{
+		{
+			name:        "JSON",
+			method:      "POST",
+			url:         "http://example.com/graphql",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"query": "query A { a } mutation B { b }", "operationName": "B", "variables": {}}`,
+			want:        &Operation{Type: Mutation, Name: "B"},
+		},
+		{
+			name:        "application/graphql",
+			method:      "POST",
+			url:         "http://example.com/graphql",
+			contentType: "application/graphql",
+			body:        "query A { a }",
+			want:        &Operation{Type: Query, Name: "A"},
+		},
+		{
+			name:   "GET",
+			method: "GET",
+			url:    "http://example.com/graphql?query=" + url.QueryEscape("{ a }"),
+			want:   &Operation{Type: Query},
+		},
+		{
+			name:        "other JSON",
+			method:      "POST",
+			url:         "http://example.com/api",
+			contentType: "application/json",
+			body:        `{"name": "value"}`,
+		},
+		{
+			name:        "batch",
+			method:      "POST",
+			url:         "http://example.com/graphql",
+			contentType: "application/json",
+			body:        `[{"query": "{ a }"}]`,
+		},
+		{
+			name:   "other GET",
+			method: "GET",
+			url:    "http://example.com/",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			req.Header.Set("Content-Type", tc.contentType)
+
+			got, err := FromRequest(req)
+			if err != nil {
+				t.Fatalf("FromRequest(): got %v, want no error", err)
+			}
+			switch {
+			case got == nil && tc.want == nil:
+			case got == nil || tc.want == nil || *got != *tc.want:
+				t.Errorf("FromRequest(): got %+v, want %+v", got, tc.want)
+			}
+
+			b, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+			}
+			if got, want := string(b), tc.body; got != want {
+				t.Errorf("req.Body: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFromRequestLargeBody(t *testing.T) {
+	body := `{"query": "{ a }", "padding": "` + strings.Repeat("x", MaxBodySize) + `"}`
+	req, err := http.NewRequest("POST", "http://example.com/graphql", ioutil.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if got, err := FromRequest(req); got != nil || err != nil {
+		t.Errorf("FromRequest(): got %v, %v, want nil, nil", got, err)
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if string(b) != body {
+		t.Errorf("req.Body: got %d bytes, want the %d bytes of the original body", len(b), len(body))
+	}
+}
+
+func TestFromRequestCached(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://example.com/graphql", strings.NewReader("query A { a }"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", "application/graphql")
+
+	martian.TestContext(req, nil, nil)
+
+	op, err := FromRequest(req)
+	if err != nil {
+		t.Fatalf("FromRequest(): got %v, want no error", err)
+	}
+
+	// The operation is not parsed from the body again.
+	req.Body = http.NoBody
+	got, err := FromRequest(req)
+	if err != nil {
+		t.Fatalf("FromRequest(): got %v, want no error", err)
+	}
+	if got != op {
+		t.Errorf("FromRequest(): got %v, want %v", got, op)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3/log"
+)
+
+var errGuardTimedOut = errors.New("martian: body used by a modifier that timed out")
+
+// GuardRequest runs reqmod on req, returning a panic of the modifier as an
+// error instead of propagating it. Errors name the type of the modifier.
+//
+// If timeout is positive, the modifier runs on a copy of req, which replaces
+// req only if the modifier returns within timeout. Otherwise GuardRequest
+// returns an error without waiting for the modifier, which keeps running on
+// its copy. If the modifier used the body of req by then, the body is no
+// longer readable and the error aborts the exchange.
+func GuardRequest(reqmod RequestModifier, req *http.Request, timeout time.Duration) error {
+	if timeout <= 0 {
+		_, err := guard(reqmod, 0, func() error {
+			return reqmod.ModifyRequest(req)
+		})
+		return err
+	}
+
+	body := newGuardedBody(req.Body)
+	c := req.Clone(req.Context())
+	if req.Body != nil {
+		c.Body = body
+	}
+
+	done, err := guard(reqmod, timeout, func() error {
+		return reqmod.ModifyRequest(c)
+	})
+	if !done {
+		return body.timedOut(&req.Body, err)
+	}
+
+	if c.Body == body {
+		c.Body = req.Body
+	}
+	*req = *c
+
+	return err
+}
+
+// GuardResponse runs resmod on res, returning a panic of the modifier as an
+// error instead of propagating it. Errors name the type of the modifier.
+//
+// If timeout is positive, the modifier runs on a copy of res and of its
+// request, which replace them only if the modifier returns within timeout.
+// Otherwise GuardResponse returns an error without waiting for the modifier,
+// which keeps running on its copy. If the modifier used the body of res by
+// then, the body is no longer readable and the error aborts the exchange.
+func GuardResponse(resmod ResponseModifier, res *http.Response, timeout time.Duration) error {
+	if timeout <= 0 {
+		_, err := guard(resmod, 0, func() error {
+			return resmod.ModifyResponse(res)
+		})
+		return err
+	}
+
+	body := newGuardedBody(res.Body)
+	c := new(http.Response)
+	*c = *res
+	c.Header = res.Header.Clone()
+	c.Trailer = res.Trailer.Clone()
+	if res.Body != nil {
+		c.Body = body
+	}
+	if res.Request != nil {
+		c.Request = res.Request.Clone(res.Request.Context())
+	}
+
+	done, err := guard(resmod, timeout, func() error {
+		return resmod.ModifyResponse(c)
+	})
+	if !done {
+		return body.timedOut(&res.Body, err)
+	}
+
+	if c.Body == body {
+		c.Body = res.Body
+	}
+	req := res.Request
+	*res = *c
+	if req != nil {
+		*req = *c.Request
+		res.Request = req
+	}
+
+	return err
+}
+
+// guard runs fn, recovering its panics. If timeout is positive and fn does
+// not return within it, guard returns false and an error without waiting.
+func guard(mod any, timeout time.Duration, fn func() error) (done bool, err error) {
+	run := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Errorf("martian: %T panicked: %v\n%s", mod, r, debug.Stack())
+				err = fmt.Errorf("martian: %T panicked: %v", mod, r)
+			}
+		}()
+
+		return fn()
+	}
+
+	if timeout <= 0 {
+		return true, run()
+	}
+
+	donec := make(chan error, 1)
+	go func() {
+		donec <- run()
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case err := <-donec:
+		return true, err
+	case <-t.C:
+		return false, fmt.Errorf("martian: %T timed out after %v", mod, timeout)
+	}
+}
+
+// guardedBody is the body of the copy of a message a guarded modifier runs
+// on. Once the modifier times out, it is detached from the body of the
+// message, so that the modifier can no longer read or close it.
+type guardedBody struct {
+	rc io.ReadCloser
+
+	mu       sync.Mutex
+	used     bool
+	detached bool
+}
+
+func newGuardedBody(rc io.ReadCloser) *guardedBody {
+	return &guardedBody{rc: rc}
+}
+
+// use marks the body as used, and reports whether it is still attached.
+func (b *guardedBody) use() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.detached {
+		return false
+	}
+	b.used = true
+
+	return true
+}
+
+func (b *guardedBody) Read(p []byte) (int, error) {
+	if !b.use() {
+		return 0, errGuardTimedOut
+	}
+
+	return b.rc.Read(p)
+}
+
+func (b *guardedBody) Close() error {
+	if !b.use() {
+		return nil
+	}
+
+	return b.rc.Close()
+}
+
+// timedOut detaches the body after the modifier timed out with err. If the
+// modifier used the body, which may still be in use, the body of the message
+// is replaced by one that fails to read and err aborts the exchange.
+func (b *guardedBody) timedOut(body *io.ReadCloser, err error) error {
+	b.mu.Lock()
+	b.detached = true
+	used := b.used
+	b.mu.Unlock()
+
+	if !used {
+		return err
+	}
+
+	*body = &failedBody{rc: *body}
+	return Abort(err)
+}
+
+// failedBody is a body that fails to read, closing the body it replaces.
+type failedBody struct {
+	rc io.ReadCloser
+}
+
+func (b *failedBody) Read([]byte) (int, error) {
+	return 0, errGuardTimedOut
+}
+
+func (b *failedBody) Close() error {
+	return b.rc.Close()
+}
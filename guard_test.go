@@ -0,0 +1,191 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestGuardRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	want := errors.New("modifier error")
+	tt := []struct {
+		reqmod  RequestModifier
+		timeout time.Duration
+		want    string
+	}{
+		{
+			reqmod: RequestModifierFunc(func(*http.Request) error { return nil }),
+		},
+		{
+			reqmod: RequestModifierFunc(func(*http.Request) error { return want }),
+			want:   "modifier error",
+		},
+		{
+			reqmod: RequestModifierFunc(func(*http.Request) error { panic("boom") }),
+			want:   "martian: martian.RequestModifierFunc panicked: boom",
+		},
+		{
+			reqmod:  RequestModifierFunc(func(*http.Request) error { panic("boom") }),
+			timeout: time.Second,
+			want:    "martian: martian.RequestModifierFunc panicked: boom",
+		},
+		{
+			reqmod: RequestModifierFunc(func(*http.Request) error {
+				time.Sleep(time.Second)
+				return nil
+			}),
+			timeout: 10 * time.Millisecond,
+			want:    "martian: martian.RequestModifierFunc timed out after 10ms",
+		},
+	}
+
+	for i, tc := range tt {
+		err := GuardRequest(tc.reqmod, req, tc.timeout)
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("%d. GuardRequest(): got %v, want no error", i, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%d. GuardRequest(): got %v, want %q", i, err, tc.want)
+		}
+	}
+}
+
+func TestGuardResponse(t *testing.T) {
+	res := &http.Response{StatusCode: 200}
+
+	resmod := ResponseModifierFunc(func(res *http.Response) error {
+		var m map[string]int
+		m["crash"] = res.StatusCode
+		return nil
+	})
+	err := GuardResponse(resmod, res, 0)
+	if err == nil || !strings.Contains(err.Error(), "martian.ResponseModifierFunc panicked: assignment to entry in nil map") {
+		t.Errorf("GuardResponse(): got %v, want panic error", err)
+	}
+}
+
+func TestGuardRequestTimeout(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// The modifier keeps writing headers after it timed out, which must not
+	// race with the users of req, as checked by go test -race.
+	reqmod := RequestModifierFunc(func(req *http.Request) error {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			req.Header.Set("X-Runaway", strconv.Itoa(i))
+			req.URL.Path = "/runaway"
+		}
+	})
+	err = GuardRequest(reqmod, req, 10*time.Millisecond)
+	if err == nil || err.Error() != "martian: martian.RequestModifierFunc timed out after 10ms" {
+		t.Fatalf("GuardRequest(): got %v, want timeout error", err)
+	}
+	if IsAbort(err) {
+		t.Error("IsAbort(): got true, want false for an unused body")
+	}
+
+	for i := 0; i < 100; i++ {
+		req.Header.Set("X-Proxy", strconv.Itoa(i))
+	}
+	if got := req.Header.Get("X-Runaway"); got != "" {
+		t.Errorf("req.Header.Get(%q): got %q, want no value", "X-Runaway", got)
+	}
+	if got, want := req.URL.Path, "/"; got != want {
+		t.Errorf("req.URL.Path: got %q, want %q", got, want)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "body"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestGuardRequestTimeoutBodyUsed(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	reqmod := RequestModifierFunc(func(req *http.Request) error {
+		b := make([]byte, 1)
+		req.Body.Read(b)
+		<-stop
+		return nil
+	})
+	err = GuardRequest(reqmod, req, 10*time.Millisecond)
+	if !IsAbort(err) {
+		t.Fatalf("GuardRequest(): got %v, want abort error", err)
+	}
+	if _, err := ioutil.ReadAll(req.Body); err == nil {
+		t.Error("ioutil.ReadAll(): got no error, want error")
+	}
+}
+
+func TestGuardResponseAdopted(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, strings.NewReader("body"), req)
+
+	resmod := ResponseModifierFunc(func(res *http.Response) error {
+		res.StatusCode = 201
+		res.Header.Set("X-Modified", "true")
+		res.Request.Header.Set("X-Modified", "true")
+		return nil
+	})
+	if err := GuardResponse(resmod, res, time.Second); err != nil {
+		t.Fatalf("GuardResponse(): got %v, want no error", err)
+	}
+
+	if got, want := res.StatusCode, 201; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("X-Modified"), "true"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Modified", got, want)
+	}
+	if res.Request != req {
+		t.Error("res.Request: got a copy, want the original request")
+	}
+	if got, want := req.Header.Get("X-Modified"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Modified", got, want)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "body"; string(got) != want {
+		t.Errorf("res.Body: got %q, want %q", got, want)
+	}
+}
@@ -17,11 +17,13 @@ package h2
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
 	"sync"
 
@@ -48,20 +50,48 @@ type Config struct {
 	// processors. A chain is created for every stream.
 	StreamProcessorFactories []StreamProcessorFactory
 
+	// PushPolicy determines how server pushes are handled. Defaults to PushRelay.
+	PushPolicy PushPolicy
+
 	// EnableDebugLogs turns on fine-grained debug logging for HTTP/2.
 	EnableDebugLogs bool
+
+	// KeyLogWriter optionally specifies a destination for TLS master secrets of the
+	// connections to the server in NSS key log format. Use for debugging only.
+	KeyLogWriter io.Writer
 }
 
 // Proxy proxies HTTP/2 traffic between a client connection, `cc`, and the HTTP/2 `url` assuming
 // h2 is being used. Since no browsers use h2c, it's safe to assume all traffic uses TLS.
 func (c *Config) Proxy(closing chan bool, cc io.ReadWriter, url *url.URL) error {
+	return c.ProxyWithDialer(closing, cc, url, nil, nil)
+}
+
+// ProxyWithDialer is like Proxy, but uses dial to establish the TCP connection to the server,
+// e.g. to tunnel through an upstream proxy. If dial is nil, the server is dialed directly.
+// If tlsConfig is not nil, it is the base of the TLS config of the connection to the server,
+// e.g. to present a client certificate or to restrict TLS versions; RootCAs and KeyLogWriter
+// default to those of c.
+func (c *Config) ProxyWithDialer(closing chan bool, cc io.ReadWriter, url *url.URL,
+	dial func(ctx context.Context, network, addr string) (net.Conn, error), tlsConfig *tls.Config) error {
 	if c.EnableDebugLogs {
 		log.Infof("\u001b[1;35mProxying %v with HTTP/2\u001b[0m", url)
 	}
-	sc, err := tls.Dial("tcp", url.Host, &tls.Config{
-		RootCAs:    c.RootCAs,
-		NextProtos: []string{"h2"},
-	})
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	tc := &tls.Config{}
+	if tlsConfig != nil {
+		tc = tlsConfig.Clone()
+	}
+	if tc.RootCAs == nil {
+		tc.RootCAs = c.RootCAs
+	}
+	if tc.KeyLogWriter == nil {
+		tc.KeyLogWriter = c.KeyLogWriter
+	}
+	tc.NextProtos = []string{"h2"}
+	sc, err := dialTLS(dial, url.Host, tc)
 	if err != nil {
 		return fmt.Errorf("connecting h2 to %v: %w", url, err)
 	}
@@ -77,12 +107,20 @@ func (c *Config) Proxy(closing chan bool, cc io.ReadWriter, url *url.URL) error
 
 	// The client-to-server relay depends on the server-to-client relay and vice versa.
 	cToS.peer, sToC.peer = sToC, cToS
+	cToS.disablePush = c.PushPolicy == PushStrip
+
+	push := &pushes{policy: c.PushPolicy}
 
 	// Creating processors is circular because the create function references the relays and the
 	// relays need to call create.
 	cToS.processors = &streamProcessors{
 		create: func(id uint32) *Processors {
-			p := &Processors{cToS: &relayAdapter{id, cToS}, sToC: &relayAdapter{id, sToC}}
+			promise := push.promise(id)
+			p := &Processors{
+				cToS:    &relayAdapter{id, cToS},
+				sToC:    &relayAdapter{id, sToC},
+				promise: promise,
+			}
 			// Chains the pipeline of processors together.
 			for i := len(c.StreamProcessorFactories) - 1; i >= 0; i-- {
 				cToS, sToC := c.StreamProcessorFactories[i](url, p)
@@ -93,8 +131,10 @@ func (c *Config) Proxy(closing chan bool, cc io.ReadWriter, url *url.URL) error
 				if sToC == nil {
 					sToC = p.ForDirection(ServerToClient)
 				}
-				p = &Processors{cToS: cToS, sToC: sToC}
+				p = &Processors{cToS: cToS, sToC: sToC, promise: promise}
 			}
+			// The push policy is applied before frames reach the stream processors.
+			p.sToC = &pushFilter{id: id, pushes: push, sink: p.sToC, server: cToS}
 			return p
 		},
 	}
@@ -118,6 +158,31 @@ func (c *Config) Proxy(closing chan bool, cc io.ReadWriter, url *url.URL) error
 	return nil
 }
 
+// dialTLS connects to addr using dial and performs the TLS handshake.
+func dialTLS(dial func(ctx context.Context, network, addr string) (net.Conn, error), addr string,
+	config *tls.Config) (*tls.Conn, error) {
+	conn, err := dial(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+
+	tc := tls.Client(conn, config)
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tc, nil
+}
+
 // forwardPreface forwards the connection preface from the client to the server.
 func forwardPreface(server io.Writer, client io.Reader) error {
 	preface := make([]byte, len(connectionPreface))
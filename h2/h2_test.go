@@ -17,9 +17,11 @@ package h2_test
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/url"
 	"sync"
 	"testing"
@@ -30,7 +32,10 @@ import (
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
 	tspb "github.com/google/martian/v3/h2/testservice"
@@ -447,3 +452,154 @@ func TestStream(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyWithDialer(t *testing.T) {
+	u := &url.URL{Scheme: "https", Host: "example.com:443"}
+
+	var gotAddr string
+	dial := func(_ context.Context, _, addr string) (net.Conn, error) {
+		gotAddr = addr
+		return nil, errors.New("dial refused")
+	}
+
+	c := &h2.Config{}
+	err := c.ProxyWithDialer(make(chan bool), nil, u, dial, nil)
+	if err == nil {
+		t.Fatal("ProxyWithDialer(): got nil error, want error")
+	}
+	if got, want := gotAddr, "example.com:443"; got != want {
+		t.Errorf("dial addr: got %q, want %q", got, want)
+	}
+}
+
+type headerModifier struct{}
+
+func (m *headerModifier) ModifyHeaders(
+	_ *h2.Stream,
+	dir h2.Direction,
+	headers []hpack.HeaderField,
+	streamEnded bool,
+) ([]hpack.HeaderField, error) {
+	// Adds a header to the response headers, but not to the trailers.
+	if dir == h2.ServerToClient && !streamEnded {
+		headers = append(headers, hpack.HeaderField{Name: "x-martian", Value: "modified"})
+	}
+	return headers, nil
+}
+
+func (m *headerModifier) ModifyData(
+	_ *h2.Stream,
+	_ h2.Direction,
+	data []byte,
+	_ bool,
+) ([]byte, error) {
+	return data, nil
+}
+
+func TestStreamModifier(t *testing.T) {
+	fixture, err := ht.New([]h2.StreamProcessorFactory{
+		h2.AsStreamProcessorFactory(&headerModifier{}),
+	})
+	if err != nil {
+		t.Fatalf("ht.New(...) = %v, want nil", err)
+	}
+	defer func() {
+		if err := fixture.Close(); err != nil {
+			t.Fatalf("f.Close() = %v, want nil", err)
+		}
+	}()
+
+	ctx := context.Background()
+	req := &tspb.EchoRequest{
+		Payload: "Hello",
+	}
+	var md metadata.MD
+	resp, err := fixture.Echo(ctx, req, grpc.Header(&md))
+	if err != nil {
+		t.Fatalf("fixture.Echo(...) = _, %v, want _, nil", err)
+	}
+	if got, want := resp.GetPayload(), req.GetPayload(); got != want {
+		t.Errorf("resp.GetPayload() = %s, want = %s", got, want)
+	}
+	if got, want := md.Get("x-martian"), []string{"modified"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("md.Get(%q) = %v, want %v", "x-martian", got, want)
+	}
+}
+
+type respondModifier struct {
+	onData bool
+	data   int
+}
+
+func (m *respondModifier) ModifyHeaders(
+	s *h2.Stream,
+	dir h2.Direction,
+	headers []hpack.HeaderField,
+	_ bool,
+) ([]hpack.HeaderField, error) {
+	if dir == h2.ClientToServer && !m.onData {
+		return nil, m.respond(s)
+	}
+	return headers, nil
+}
+
+func (m *respondModifier) ModifyData(
+	s *h2.Stream,
+	dir h2.Direction,
+	data []byte,
+	_ bool,
+) ([]byte, error) {
+	if dir == h2.ServerToClient {
+		m.data++
+	}
+	if dir == h2.ClientToServer && m.onData && !s.Responded() {
+		return nil, m.respond(s)
+	}
+	return data, nil
+}
+
+func (m *respondModifier) respond(s *h2.Stream) error {
+	// A gRPC Trailers-Only response.
+	return s.Respond([]hpack.HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "application/grpc"},
+		{Name: "grpc-status", Value: fmt.Sprint(int(codes.Unavailable))},
+		{Name: "grpc-message", Value: "blocked"},
+	}, nil)
+}
+
+func TestStreamModifierRespond(t *testing.T) {
+	for _, onData := range []bool{false, true} {
+		t.Run(fmt.Sprintf("onData=%t", onData), func(t *testing.T) {
+			m := &respondModifier{onData: onData}
+			fixture, err := ht.New([]h2.StreamProcessorFactory{h2.AsStreamProcessorFactory(m)})
+			if err != nil {
+				t.Fatalf("ht.New(...) = %v, want nil", err)
+			}
+			defer func() {
+				if err := fixture.Close(); err != nil {
+					t.Fatalf("f.Close() = %v, want nil", err)
+				}
+			}()
+
+			ctx := context.Background()
+			_, err = fixture.Echo(ctx, &tspb.EchoRequest{Payload: "Hello"})
+			st, _ := status.FromError(err)
+			if got, want := st.Code(), codes.Unavailable; got != want {
+				t.Fatalf("fixture.Echo(...): got code %v, want %v (err: %v)", got, want, err)
+			}
+			if got, want := st.Message(), "blocked"; got != want {
+				t.Errorf("fixture.Echo(...): got message %q, want %q", got, want)
+			}
+			if m.data != 0 {
+				t.Errorf("server data frames: got %d, want 0", m.data)
+			}
+
+			// The connection remains usable for other streams.
+			_, err = fixture.Echo(ctx, &tspb.EchoRequest{Payload: "Hello"})
+			if got, want := status.Code(err), codes.Unavailable; got != want {
+				t.Errorf("fixture.Echo(...): got code %v, want %v", got, want)
+			}
+		})
+	}
+}
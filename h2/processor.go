@@ -50,6 +50,14 @@ type StreamProcessorFactory func(url *url.URL, sinks *Processors) (Processor, Pr
 // Processors encapsulates the two traffic receiving endpoints.
 type Processors struct {
 	cToS, sToC Processor
+
+	promise []hpack.HeaderField
+}
+
+// Promise returns the request headers of the PUSH_PROMISE that opened the stream, or nil if the
+// stream was opened by the client.
+func (s *Processors) Promise() []hpack.HeaderField {
+	return s.promise
 }
 
 // ForDirection returns the processor receiving traffic in the given direction.
@@ -0,0 +1,187 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package h2
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/martian/v3/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// PushPolicy determines how PUSH_PROMISE frames sent by servers are handled.
+type PushPolicy int
+
+const (
+	// PushRelay relays server pushes to the client.
+	PushRelay PushPolicy = iota
+	// PushStrip drops server pushes. Pushes are also disabled in the SETTINGS sent to the server.
+	PushStrip
+	// PushPreload drops server pushes and instead adds a preload Link header for each pushed
+	// resource to the response headers of the stream it was promised on, so that the client
+	// fetches the resources itself.
+	PushPreload
+)
+
+// String returns the name of the policy.
+func (p PushPolicy) String() string {
+	switch p {
+	case PushRelay:
+		return "relay"
+	case PushStrip:
+		return "strip"
+	case PushPreload:
+		return "preload"
+	default:
+		return fmt.Sprintf("PushPolicy(%d)", int(p))
+	}
+}
+
+// ParsePushPolicy returns the policy named s, one of "relay", "strip" or "preload".
+func ParsePushPolicy(s string) (PushPolicy, error) {
+	for _, p := range []PushPolicy{PushRelay, PushStrip, PushPreload} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+
+	return 0, fmt.Errorf("h2: unknown push policy %q", s)
+}
+
+// pushes holds the server push state of a connection.
+type pushes struct {
+	policy PushPolicy
+
+	// promises stores the request headers of promised streams, keyed by uint32 stream ID, until
+	// the processors of the stream are created.
+	promises sync.Map
+
+	// dropped stores the IDs of promised streams whose frames are dropped.
+	dropped sync.Map
+}
+
+// promise returns the request headers of the PUSH_PROMISE that opened the stream with the given
+// ID, or nil if the stream was not pushed.
+func (p *pushes) promise(id uint32) []hpack.HeaderField {
+	v, ok := p.promises.LoadAndDelete(id)
+	if !ok {
+		return nil
+	}
+	return v.([]hpack.HeaderField)
+}
+
+func (p *pushes) isDropped(id uint32) bool {
+	_, ok := p.dropped.Load(id)
+	return ok
+}
+
+// pushFilter is the outermost server-to-client processor of a stream. It applies the push policy
+// before frames reach the stream processors.
+type pushFilter struct {
+	id     uint32
+	pushes *pushes
+	sink   Processor
+
+	// server resets promised streams that are dropped.
+	server *relay
+
+	// links are the preload Link header values of the promises made on the stream.
+	links       []string
+	headersSent bool
+}
+
+func (f *pushFilter) Header(
+	headers []hpack.HeaderField,
+	streamEnded bool,
+	priority http2.PriorityParam,
+) error {
+	if f.pushes.isDropped(f.id) {
+		if streamEnded {
+			f.pushes.dropped.Delete(f.id)
+		}
+		return nil
+	}
+
+	if !f.headersSent {
+		f.headersSent = true
+		for _, l := range f.links {
+			headers = append(headers, hpack.HeaderField{Name: "link", Value: l})
+		}
+		f.links = nil
+	}
+	return f.sink.Header(headers, streamEnded, priority)
+}
+
+func (f *pushFilter) Data(data []byte, streamEnded bool) error {
+	if f.pushes.isDropped(f.id) {
+		if streamEnded {
+			f.pushes.dropped.Delete(f.id)
+		}
+		return nil
+	}
+	return f.sink.Data(data, streamEnded)
+}
+
+func (f *pushFilter) Priority(priority http2.PriorityParam) error {
+	if f.pushes.isDropped(f.id) {
+		return nil
+	}
+	return f.sink.Priority(priority)
+}
+
+func (f *pushFilter) RSTStream(errCode http2.ErrCode) error {
+	if f.pushes.isDropped(f.id) {
+		f.pushes.dropped.Delete(f.id)
+		return nil
+	}
+	return f.sink.RSTStream(errCode)
+}
+
+func (f *pushFilter) PushPromise(promiseID uint32, headers []hpack.HeaderField) error {
+	if f.pushes.policy == PushRelay {
+		f.pushes.promises.Store(promiseID, headers)
+		return f.sink.PushPromise(promiseID, headers)
+	}
+
+	f.pushes.dropped.Store(promiseID, struct{}{})
+	f.server.rstStream(promiseID, http2.ErrCodeRefusedStream)
+
+	if f.pushes.policy == PushPreload {
+		l, ok := preloadLink(headers)
+		switch {
+		case !ok:
+			log.Debugf("h2: dropping push promise without URL: %v", headers)
+		case f.headersSent:
+			log.Debugf("h2: dropping push promise for %s after response headers", l)
+		default:
+			f.links = append(f.links, l)
+		}
+	}
+	return nil
+}
+
+// preloadLink returns the preload Link header value for the resource of promised request headers.
+func preloadLink(headers []hpack.HeaderField) (string, bool) {
+	var scheme, authority, path string
+	for _, h := range headers {
+		switch h.Name {
+		case ":scheme":
+			scheme = h.Value
+		case ":authority":
+			authority = h.Value
+		case ":path":
+			path = h.Value
+		}
+	}
+	if path == "" {
+		return "", false
+	}
+
+	u := path
+	if scheme != "" && authority != "" {
+		u = scheme + "://" + authority + path
+	}
+	return fmt.Sprintf("<%s>; rel=preload", u), true
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package h2
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// recorder is a Processor that records the frames it receives.
+type recorder struct {
+	headers  [][]hpack.HeaderField
+	data     [][]byte
+	promises []uint32
+}
+
+func (r *recorder) Data(data []byte, _ bool) error {
+	r.data = append(r.data, data)
+	return nil
+}
+
+func (r *recorder) Header(headers []hpack.HeaderField, _ bool, _ http2.PriorityParam) error {
+	r.headers = append(r.headers, headers)
+	return nil
+}
+
+func (r *recorder) Priority(http2.PriorityParam) error { return nil }
+
+func (r *recorder) RSTStream(http2.ErrCode) error { return nil }
+
+func (r *recorder) PushPromise(promiseID uint32, _ []hpack.HeaderField) error {
+	r.promises = append(r.promises, promiseID)
+	return nil
+}
+
+func TestParsePushPolicy(t *testing.T) {
+	for _, want := range []PushPolicy{PushRelay, PushStrip, PushPreload} {
+		got, err := ParsePushPolicy(want.String())
+		if err != nil {
+			t.Fatalf("ParsePushPolicy(%q): got %v, want no error", want, err)
+		}
+		if got != want {
+			t.Errorf("ParsePushPolicy(%q): got %v, want %v", want, got, want)
+		}
+	}
+
+	if _, err := ParsePushPolicy("unknown"); err == nil {
+		t.Errorf("ParsePushPolicy(%q): got nil error, want error", "unknown")
+	}
+}
+
+func TestPushFilter(t *testing.T) {
+	promise := []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":authority", Value: "example.com"},
+		{Name: ":path", Value: "/style.css"},
+	}
+	response := []hpack.HeaderField{{Name: ":status", Value: "200"}}
+	link := hpack.HeaderField{Name: "link", Value: "<https://example.com/style.css>; rel=preload"}
+
+	tests := []struct {
+		policy       PushPolicy
+		wantPromises []uint32
+		wantHeaders  []hpack.HeaderField
+		wantData     int
+		wantReset    bool
+	}{
+		{
+			policy:       PushRelay,
+			wantPromises: []uint32{2},
+			wantHeaders:  response,
+			wantData:     1,
+		},
+		{
+			policy:      PushStrip,
+			wantHeaders: response,
+			wantReset:   true,
+		},
+		{
+			policy:      PushPreload,
+			wantHeaders: append(append([]hpack.HeaderField(nil), response...), link),
+			wantReset:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			enableDebugLogs := false
+			server := newRelay(ClientToServer, "client", "server", nil, nil, &enableDebugLogs)
+			p := &pushes{policy: tc.policy}
+
+			parentSink, pushedSink := &recorder{}, &recorder{}
+			parent := &pushFilter{id: 1, pushes: p, sink: parentSink, server: server}
+			pushed := &pushFilter{id: 2, pushes: p, sink: pushedSink, server: server}
+
+			if err := parent.PushPromise(2, promise); err != nil {
+				t.Fatalf("PushPromise(): got %v, want no error", err)
+			}
+			if err := parent.Header(response, false, http2.PriorityParam{}); err != nil {
+				t.Fatalf("Header(): got %v, want no error", err)
+			}
+			if err := pushed.Data([]byte("body"), true); err != nil {
+				t.Fatalf("Data(): got %v, want no error", err)
+			}
+
+			if got, want := parentSink.promises, tc.wantPromises; !reflect.DeepEqual(got, want) {
+				t.Errorf("promises: got %v, want %v", got, want)
+			}
+			if got, want := parentSink.headers, [][]hpack.HeaderField{tc.wantHeaders}; !reflect.DeepEqual(got, want) {
+				t.Errorf("headers: got %v, want %v", got, want)
+			}
+			if got, want := len(pushedSink.data), tc.wantData; got != want {
+				t.Errorf("len(data): got %d, want %d", got, want)
+			}
+
+			var reset *queuedRSTStreamFrame
+			select {
+			case f := <-server.output:
+				reset, _ = f.(*queuedRSTStreamFrame)
+			default:
+			}
+			if got, want := reset != nil, tc.wantReset; got != want {
+				t.Fatalf("promised stream reset: got %t, want %t", got, want)
+			}
+			if reset != nil && (reset.streamID != 2 || reset.errCode != http2.ErrCodeRefusedStream) {
+				t.Errorf("reset: got %v, want stream 2 with REFUSED_STREAM", reset)
+			}
+
+			wantPromise := promise
+			if tc.policy != PushRelay {
+				wantPromise = nil
+			}
+			if got := p.promise(2); !reflect.DeepEqual(got, wantPromise) {
+				t.Errorf("promise(2): got %v, want %v", got, wantPromise)
+			}
+		})
+	}
+}
+
+func TestDisablePushSettings(t *testing.T) {
+	var in bytes.Buffer
+	if err := http2.NewFramer(&in, nil).WriteSettings(http2.Setting{ID: http2.SettingMaxFrameSize, Val: 32768}); err != nil {
+		t.Fatalf("WriteSettings(): got %v, want no error", err)
+	}
+	f, err := http2.NewFramer(nil, &in).ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame(): got %v, want no error", err)
+	}
+
+	var out bytes.Buffer
+	enableDebugLogs := false
+	cToS := newRelay(ClientToServer, "client", "server", nil, http2.NewFramer(&out, nil), &enableDebugLogs)
+	cToS.peer = newRelay(ServerToClient, "server", "client", nil, nil, &enableDebugLogs)
+	cToS.disablePush = true
+
+	if err := cToS.processFrame(f); err != nil {
+		t.Fatalf("processFrame(): got %v, want no error", err)
+	}
+
+	f, err = http2.NewFramer(nil, &out).ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame(): got %v, want no error", err)
+	}
+	v, ok := f.(*http2.SettingsFrame).Value(http2.SettingEnablePush)
+	if !ok || v != 0 {
+		t.Errorf("SETTINGS_ENABLE_PUSH: got %d, %t, want 0, true", v, ok)
+	}
+}
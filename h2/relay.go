@@ -99,6 +99,9 @@ type relay struct {
 
 	enableDebugLogs *bool
 
+	// disablePush disables server push in the settings relayed to the server.
+	disablePush bool
+
 	// The following fields depend on a circular dependency between the relays in opposite directions
 	// so must be set explicitly after initialization.
 
@@ -259,9 +262,15 @@ func (r *relay) processFrame(f http2.Frame) error {
 				case http2.SettingMaxFrameSize:
 					r.peer.updateMaxFrameSize(s.Val)
 				}
+				if s.ID == http2.SettingEnablePush && r.disablePush {
+					s.Val = 0
+				}
 				settings = append(settings, s)
 				return nil
 			}); err == nil {
+				if _, ok := f.Value(http2.SettingEnablePush); !ok && r.disablePush {
+					settings = append(settings, http2.Setting{ID: http2.SettingEnablePush, Val: 0})
+				}
 				r.destMu.Lock()
 				err = r.dest.WriteSettings(settings...)
 				r.destMu.Unlock()
@@ -403,6 +412,11 @@ func (r *relay) header(
 	streamEnded bool,
 	priority http2.PriorityParam,
 ) error {
+	// Header blocks must be sent in the order they are encoded, so encoding and enqueueing is atomic.
+	// The relay may be called from both threads when a stream is short-circuited.
+	r.encoderMu.Lock()
+	defer r.encoderMu.Unlock()
+
 	encoded, err := r.encodeFull(headers)
 	if err != nil {
 		return fmt.Errorf("encoding headers %v: %w", headers, err)
@@ -442,6 +456,9 @@ func (r *relay) rstStream(id uint32, errCode http2.ErrCode) {
 }
 
 func (r *relay) pushPromise(id, promiseID uint32, headers []hpack.HeaderField) error {
+	r.encoderMu.Lock()
+	defer r.encoderMu.Unlock()
+
 	encoded, err := r.encodeFull(headers)
 	if err != nil {
 		return fmt.Errorf("encoding push promise headers %v: %w", headers, err)
@@ -512,10 +529,8 @@ func (r *relay) decodeFull(data []byte) ([]hpack.HeaderField, error) {
 	return r.decoder.DecodeFull(data)
 }
 
+// encodeFull encodes headers. The caller must hold `encoderMu`.
 func (r *relay) encodeFull(headers []hpack.HeaderField) ([]byte, error) {
-	r.encoderMu.Lock()
-	defer r.encoderMu.Unlock()
-
 	r.reencoded.Reset()
 	var buf bytes.Buffer
 	for _, h := range headers {
@@ -0,0 +1,221 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package h2
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// StreamModifier inspects and edits the HEADERS and DATA frames of HTTP/2 streams. Unlike a
+// Processor, which forwards frames to a sink itself, a StreamModifier returns the edited frame
+// content and may short-circuit a stream with Stream.Respond.
+//
+// Methods are called on the thread of the given direction, see StreamProcessorFactory.
+type StreamModifier interface {
+	// ModifyHeaders is called for the headers and trailers flowing in direction dir and returns the
+	// headers to forward.
+	ModifyHeaders(s *Stream, dir Direction, headers []hpack.HeaderField, streamEnded bool) (
+		[]hpack.HeaderField, error)
+
+	// ModifyData is called for the data flowing in direction dir and returns the data to forward.
+	ModifyData(s *Stream, dir Direction, data []byte, streamEnded bool) ([]byte, error)
+}
+
+// Stream is an HTTP/2 stream seen by a StreamModifier.
+type Stream struct {
+	// URL is the URL of the proxied connection.
+	URL *url.URL
+
+	// Promise holds the request headers of the PUSH_PROMISE that opened the stream, or nil if the
+	// stream was opened by the client. Pushed responses are seen by modifiers only with PushRelay.
+	Promise []hpack.HeaderField
+
+	sinks *Processors
+
+	mu sync.Mutex
+	// inRequest is set while the modifier is called for client-to-server frames.
+	inRequest bool
+	// requestStarted is set once request headers have been forwarded to the server.
+	requestStarted bool
+	// responseStarted is set once response headers have been forwarded to the client.
+	responseStarted bool
+	responded       bool
+}
+
+// Respond short-circuits the stream: it sends a response with headers, which must include the
+// ":status" pseudo-header, and body to the client and stops forwarding frames of the stream in
+// either direction. If the request was already forwarded, the stream is reset towards the server
+// with CANCEL.
+//
+// Respond must be called from ModifyHeaders or ModifyData for client-to-server frames, before the
+// response of the server is forwarded. Frames passed to the modifier call are dropped.
+func (s *Stream) Respond(headers []hpack.HeaderField, body []byte) error {
+	status := false
+	for _, h := range headers {
+		if h.Name == ":status" {
+			status = true
+			break
+		}
+	}
+	if !status {
+		return errors.New("h2: response headers without :status")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.inRequest {
+		return errors.New("h2: Respond called outside of a client-to-server modifier call")
+	}
+	if s.responded {
+		return errors.New("h2: stream already responded")
+	}
+	if s.responseStarted {
+		return errors.New("h2: response already started")
+	}
+	s.responded = true
+
+	if s.requestStarted {
+		if err := s.sinks.ForDirection(ClientToServer).RSTStream(http2.ErrCodeCancel); err != nil {
+			return fmt.Errorf("resetting stream: %w", err)
+		}
+	}
+
+	sToC := s.sinks.ForDirection(ServerToClient)
+	if err := sToC.Header(headers, len(body) == 0, http2.PriorityParam{}); err != nil {
+		return fmt.Errorf("sending response headers: %w", err)
+	}
+	if len(body) > 0 {
+		if err := sToC.Data(body, true); err != nil {
+			return fmt.Errorf("sending response data: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Responded reports whether Respond has been called for the stream.
+func (s *Stream) Responded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.responded
+}
+
+// AsStreamProcessorFactory converts m into a StreamProcessorFactory, so that it is called for every
+// stream when added to Config.StreamProcessorFactories.
+func AsStreamProcessorFactory(m StreamModifier) StreamProcessorFactory {
+	return func(url *url.URL, sinks *Processors) (Processor, Processor) {
+		s := &Stream{
+			URL:     url,
+			Promise: sinks.Promise(),
+			sinks:   sinks,
+		}
+
+		return &streamModifier{s: s, m: m, dir: ClientToServer, sink: sinks.ForDirection(ClientToServer)},
+			&streamModifier{s: s, m: m, dir: ServerToClient, sink: sinks.ForDirection(ServerToClient)}
+	}
+}
+
+// streamModifier adapts a StreamModifier to the Processor interface for one direction.
+type streamModifier struct {
+	s    *Stream
+	m    StreamModifier
+	dir  Direction
+	sink Processor
+}
+
+func (p *streamModifier) Header(
+	headers []hpack.HeaderField,
+	streamEnded bool,
+	priority http2.PriorityParam,
+) error {
+	p.begin()
+	headers, err := p.m.ModifyHeaders(p.s, p.dir, headers, streamEnded)
+	p.end()
+	if err != nil {
+		return err
+	}
+
+	return p.forward(true, func() error {
+		return p.sink.Header(headers, streamEnded, priority)
+	})
+}
+
+func (p *streamModifier) Data(data []byte, streamEnded bool) error {
+	p.begin()
+	data, err := p.m.ModifyData(p.s, p.dir, data, streamEnded)
+	p.end()
+	if err != nil {
+		return err
+	}
+
+	return p.forward(false, func() error {
+		return p.sink.Data(data, streamEnded)
+	})
+}
+
+func (p *streamModifier) Priority(priority http2.PriorityParam) error {
+	return p.forward(false, func() error {
+		return p.sink.Priority(priority)
+	})
+}
+
+func (p *streamModifier) RSTStream(errCode http2.ErrCode) error {
+	return p.forward(false, func() error {
+		return p.sink.RSTStream(errCode)
+	})
+}
+
+func (p *streamModifier) PushPromise(promiseID uint32, headers []hpack.HeaderField) error {
+	return p.forward(false, func() error {
+		return p.sink.PushPromise(promiseID, headers)
+	})
+}
+
+func (p *streamModifier) begin() {
+	if p.dir != ClientToServer {
+		return
+	}
+
+	p.s.mu.Lock()
+	p.s.inRequest = true
+	p.s.mu.Unlock()
+}
+
+func (p *streamModifier) end() {
+	if p.dir != ClientToServer {
+		return
+	}
+
+	p.s.mu.Lock()
+	p.s.inRequest = false
+	p.s.mu.Unlock()
+}
+
+// forward calls send unless the stream has been responded. Sending is serialized with Respond,
+// which may be called from the other thread.
+func (p *streamModifier) forward(headers bool, send func() error) error {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+
+	if p.s.responded {
+		return nil
+	}
+	if headers {
+		switch p.dir {
+		case ClientToServer:
+			p.s.requestStarted = true
+		case ServerToClient:
+			p.s.responseStarted = true
+		}
+	}
+
+	return send()
+}
@@ -81,6 +81,7 @@ func (p proxyHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	session := newSessionWithResponseWriter(rw)
 	if req.TLS != nil {
 		session.MarkSecure()
+		setClientCertificate(session, req.TLS)
 	}
 	ctx := withSession(session)
 
@@ -99,23 +100,30 @@ func (p proxyHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 func (p proxyHandler) handleConnectRequest(ctx *Context, rw http.ResponseWriter, req *http.Request) {
 	session := ctx.Session()
 
+	var abort error
 	if err := p.reqmod.ModifyRequest(req); err != nil {
-		log.Errorf("martian: error modifying CONNECT request: %v", err)
+		ctx.logger().Errorf("martian: error modifying CONNECT request: %v", err)
+		p.observeModifierError(req, err)
 		p.warning(req.Header, err)
+		if IsAbort(err) {
+			abort = err
+		}
 	}
 	if session.Hijacked() {
-		log.Debugf("martian: connection hijacked by request modifier")
+		ctx.logger().Debugf("martian: connection hijacked by request modifier")
 		return
 	}
 
-	log.Debugf("martian: attempting to establish CONNECT tunnel: %s", req.URL.Host)
+	ctx.logger().Debugf("martian: attempting to establish CONNECT tunnel: %s", req.URL.Host)
 	var (
 		res  *http.Response
 		cr   io.Reader
 		cw   io.WriteCloser
 		cerr error
 	)
-	if p.ConnectPassthrough {
+	if abort != nil {
+		cerr = abort
+	} else if p.ConnectPassthrough {
 		pr, pw := io.Pipe()
 		req.Body = pr
 		defer req.Body.Close()
@@ -142,24 +150,30 @@ func (p proxyHandler) handleConnectRequest(ctx *Context, rw http.ResponseWriter,
 	}
 
 	if cerr != nil {
-		log.Errorf("martian: failed to CONNECT: %v", cerr)
+		ctx.logger().Errorf("martian: failed to CONNECT: %v", cerr)
 		res = p.errorResponse(req, cerr)
 		p.warning(res.Header, cerr)
 	}
 	defer res.Body.Close()
 
 	if err := p.resmod.ModifyResponse(res); err != nil {
-		log.Errorf("martian: error modifying CONNECT response: %v", err)
+		ctx.logger().Errorf("martian: error modifying CONNECT response: %v", err)
+		p.observeModifierError(req, err)
 		p.warning(res.Header, err)
+		if IsAbort(err) {
+			res = p.errorResponse(req, err)
+			p.warning(res.Header, err)
+			defer res.Body.Close()
+		}
 	}
 	if session.Hijacked() {
-		log.Debugf("martian: connection hijacked by response modifier")
+		ctx.logger().Debugf("martian: connection hijacked by response modifier")
 		return
 	}
 
 	if res.StatusCode != 200 {
 		if cerr == nil {
-			log.Errorf("martian: CONNECT rejected with status code: %d", res.StatusCode)
+			ctx.logger().Errorf("martian: CONNECT rejected with status code: %d", res.StatusCode)
 		}
 		writeResponse(rw, res)
 		return
@@ -169,30 +183,30 @@ func (p proxyHandler) handleConnectRequest(ctx *Context, rw http.ResponseWriter,
 		res.ContentLength = -1
 	}
 
-	if err := p.tunnel("CONNECT", rw, req, res, cw, cr); err != nil {
-		log.Errorf("martian: CONNECT tunnel: %v", err)
+	if err := p.tunnel(ctx, "CONNECT", rw, req, res, cw, cr); err != nil {
+		ctx.logger().Errorf("martian: CONNECT tunnel: %v", err)
 		panic(http.ErrAbortHandler)
 	}
 }
 
-func (p proxyHandler) handleUpgradeResponse(rw http.ResponseWriter, req *http.Request, res *http.Response) {
+func (p proxyHandler) handleUpgradeResponse(ctx *Context, rw http.ResponseWriter, req *http.Request, res *http.Response) {
 	resUpType := upgradeType(res.Header)
 
 	uconn, ok := res.Body.(io.ReadWriteCloser)
 	if !ok {
-		log.Errorf("martian: %s tunnel: internal error: switching protocols response with non-ReadWriteCloser body", resUpType)
+		ctx.logger().Errorf("martian: %s tunnel: internal error: switching protocols response with non-ReadWriteCloser body", resUpType)
 		panic(http.ErrAbortHandler)
 	}
 
 	res.Body = nil
 
-	if err := p.tunnel(resUpType, rw, req, res, uconn, uconn); err != nil {
-		log.Errorf("martian: %s tunnel: %w", resUpType, err)
+	if err := p.tunnel(ctx, resUpType, rw, req, res, uconn, uconn); err != nil {
+		ctx.logger().Errorf("martian: %s tunnel: %v", resUpType, err)
 		panic(http.ErrAbortHandler)
 	}
 }
 
-func (p proxyHandler) tunnel(name string, rw http.ResponseWriter, req *http.Request, res *http.Response, cw io.WriteCloser, cr io.Reader) error {
+func (p proxyHandler) tunnel(ctx *Context, name string, rw http.ResponseWriter, req *http.Request, res *http.Response, cw io.WriteCloser, cr io.Reader) error {
 	var (
 		rc    = http.NewResponseController(rw)
 		donec = make(chan bool, 2)
@@ -215,8 +229,8 @@ func (p proxyHandler) tunnel(name string, rw http.ResponseWriter, req *http.Requ
 			return fmt.Errorf("got error while draining buffer: %w", err)
 		}
 
-		go copySync("outbound "+name, cw, conn, donec)
-		go copySync("inbound "+name, conn, cr, donec)
+		go copySync(ctx.logger(), "outbound "+name, cw, conn, donec)
+		go copySync(ctx.logger(), "inbound "+name, conn, cr, donec)
 	case 2:
 		copyHeader(rw.Header(), res.Header)
 		rw.WriteHeader(res.StatusCode)
@@ -225,16 +239,16 @@ func (p proxyHandler) tunnel(name string, rw http.ResponseWriter, req *http.Requ
 			return fmt.Errorf("got error while flushing response back to client: %w", err)
 		}
 
-		go copySync("outbound "+name, cw, req.Body, donec)
-		go copySync("inbound "+name, writeFlusher{rw, rc}, cr, donec)
+		go copySync(ctx.logger(), "outbound "+name, cw, req.Body, donec)
+		go copySync(ctx.logger(), "inbound "+name, writeFlusher{rw, rc}, cr, donec)
 	default:
 		return fmt.Errorf("unsupported protocol version: %d", req.ProtoMajor)
 	}
 
-	log.Debugf("martian: established %s tunnel, proxying traffic", name)
+	ctx.logger().Debugf("martian: established %s tunnel, proxying traffic", name)
 	<-donec
 	<-donec
-	log.Debugf("martian: closed %s tunnel", name)
+	ctx.logger().Debugf("martian: closed %s tunnel", name)
 
 	return nil
 }
@@ -244,11 +258,30 @@ func (p proxyHandler) tunnel(name string, rw http.ResponseWriter, req *http.Requ
 func (p proxyHandler) handleRequest(ctx *Context, rw http.ResponseWriter, req *http.Request) {
 	session := ctx.Session()
 
+	if err := p.checkHeaderCount(req); err != nil {
+		ctx.logger().Errorf("martian: rejecting request: %v", err)
+		rw.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+
 	if req.Method == "CONNECT" {
 		p.handleConnectRequest(ctx, rw, req)
 		return
 	}
 
+	if err := p.expectContinue(req, func() error {
+		rw.WriteHeader(http.StatusContinue)
+		return nil
+	}); err != nil {
+		ctx.logger().Errorf("martian: %v", err)
+		if err == errBodyTooLarge {
+			rw.Header().Set("Connection", "close")
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+
 	req.Proto = "HTTP/1.1"
 	req.ProtoMajor = 1
 	req.ProtoMinor = 1
@@ -261,21 +294,27 @@ func (p proxyHandler) handleRequest(ctx *Context, rw http.ResponseWriter, req *h
 		}
 	} else if req.URL.Scheme == "http" {
 		if session.IsSecure() && !p.AllowHTTP {
-			log.Infof("martian: forcing HTTPS inside secure session")
+			ctx.logger().Infof("martian: forcing HTTPS inside secure session")
 			req.URL.Scheme = "https"
 		}
 	}
 
 	reqUpType := upgradeType(req.Header)
 	if reqUpType != "" {
-		log.Debugf("martian: upgrade request: %s", reqUpType)
+		ctx.logger().Debugf("martian: upgrade request: %s", reqUpType)
 	}
+	var res *http.Response
 	if err := p.reqmod.ModifyRequest(req); err != nil {
-		log.Errorf("martian: error modifying request: %v", err)
+		ctx.logger().Errorf("martian: error modifying request: %v", err)
+		p.observeModifierError(req, err)
 		p.warning(req.Header, err)
+		if IsAbort(err) {
+			res = p.errorResponse(req, err)
+			p.warning(res.Header, err)
+		}
 	}
 	if session.Hijacked() {
-		log.Debugf("martian: connection hijacked by request modifier")
+		ctx.logger().Debugf("martian: connection hijacked by request modifier")
 		return
 	}
 
@@ -287,11 +326,17 @@ func (p proxyHandler) handleRequest(ctx *Context, rw http.ResponseWriter, req *h
 	}
 
 	// perform the HTTP roundtrip
-	res, err := p.roundTrip(ctx, req)
-	if err != nil {
-		log.Errorf("martian: failed to round trip: %v", err)
-		res = p.errorResponse(req, err)
-		p.warning(res.Header, err)
+	if res == nil {
+		var err error
+		res, err = p.roundTrip(ctx, req)
+		if err == nil {
+			res, err = p.followRedirects(ctx, req, res)
+		}
+		if err != nil {
+			ctx.logger().Errorf("martian: failed to round trip: %v", err)
+			res = p.errorResponse(req, err)
+			p.warning(res.Header, err)
+		}
 	}
 	defer res.Body.Close()
 
@@ -301,14 +346,21 @@ func (p proxyHandler) handleRequest(ctx *Context, rw http.ResponseWriter, req *h
 
 	resUpType := upgradeType(res.Header)
 	if resUpType != "" {
-		log.Debugf("martian: upgrade response: %s", resUpType)
+		ctx.logger().Debugf("martian: upgrade response: %s", resUpType)
 	}
 	if err := p.resmod.ModifyResponse(res); err != nil {
-		log.Errorf("martian: error modifying response: %v", err)
+		ctx.logger().Errorf("martian: error modifying response: %v", err)
+		p.observeModifierError(req, err)
 		p.warning(res.Header, err)
+		if IsAbort(err) {
+			res = p.errorResponse(req, err)
+			p.warning(res.Header, err)
+			defer res.Body.Close()
+			resUpType = ""
+		}
 	}
 	if session.Hijacked() {
-		log.Debugf("martian: connection hijacked by response modifier")
+		ctx.logger().Debugf("martian: connection hijacked by response modifier")
 		return
 	}
 
@@ -320,7 +372,7 @@ func (p proxyHandler) handleRequest(ctx *Context, rw http.ResponseWriter, req *h
 	}
 
 	if !req.ProtoAtLeast(1, 1) || req.Close || res.Close || p.Closing() {
-		log.Debugf("martian: received close request: %v", req.RemoteAddr)
+		ctx.logger().Debugf("martian: received close request: %v", req.RemoteAddr)
 		res.Close = true
 	}
 	if p.CloseAfterReply {
@@ -329,7 +381,7 @@ func (p proxyHandler) handleRequest(ctx *Context, rw http.ResponseWriter, req *h
 
 	// deal with 101 Switching Protocols responses: (WebSocket, h2c, etc)
 	if res.StatusCode == 101 {
-		p.handleUpgradeResponse(rw, req, res)
+		p.handleUpgradeResponse(ctx, rw, req, res)
 	} else {
 		writeResponse(rw, res)
 	}
@@ -20,6 +20,7 @@ package har
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -27,10 +28,13 @@ import (
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -45,6 +49,11 @@ type Logger struct {
 	bodyLogging     func(*http.Response) bool
 	postDataLogging func(*http.Request) bool
 
+	samplePercent int
+	sampleCount   atomic.Uint64
+	includeURLs   []*regexp.Regexp
+	excludeURLs   []*regexp.Regexp
+
 	creator *Creator
 
 	mu      sync.Mutex
@@ -92,7 +101,36 @@ type Entry struct {
 	// Timings describes various phases within request-response round trip. All
 	// times are specified in milliseconds.
 	Timings *Timings `json:"timings"`
-	next    *Entry
+	// ServerIPAddress is the IP address of the server the request was sent
+	// to, or of the upstream proxy.
+	ServerIPAddress string `json:"serverIPAddress,omitempty"`
+	// Connection identifies the connection the request was sent on.
+	Connection string `json:"connection,omitempty"`
+	// Protocol is the HTTP version of the response of the server, such as
+	// HTTP/2.0.
+	Protocol string `json:"_protocol,omitempty"`
+	// TLSVersion is the TLS version negotiated with the server, such as
+	// TLS 1.3, if the connection was secure.
+	TLSVersion string `json:"_tlsVersion,omitempty"`
+	// Labels are the labels of the session of the request, such as the device
+	// or build of a test run.
+	Labels map[string]string `json:"_labels,omitempty"`
+	// Redirects are the redirects the proxy followed itself before the
+	// response, see martian.Proxy.FollowRedirects.
+	Redirects []Redirect `json:"_redirects,omitempty"`
+	next      *Entry
+}
+
+// Redirect is a redirect response followed by the proxy.
+type Redirect struct {
+	// Method is the method of the request that was redirected.
+	Method string `json:"method"`
+	// URL is the URL of the request that was redirected.
+	URL string `json:"url"`
+	// Status is the status code of the redirect response.
+	Status int `json:"status"`
+	// RedirectURL is the URL the request was redirected to.
+	RedirectURL string `json:"redirectURL"`
 }
 
 // Request holds data about an individual HTTP request.
@@ -152,8 +190,18 @@ type Cache struct {
 }
 
 // Timings describes various phases within request-response round trip. All
-// times are specified in milliseconds
+// times are specified in milliseconds; -1 marks phases that do not apply,
+// such as DNS and connect when a connection is reused.
 type Timings struct {
+	// Blocked is the time spent waiting for a network connection.
+	Blocked int64 `json:"blocked"`
+	// DNS is the time required to resolve the host name.
+	DNS int64 `json:"dns"`
+	// Connect is the time required to create the TCP connection, including
+	// the SSL/TLS handshake.
+	Connect int64 `json:"connect"`
+	// SSL is the time required for the SSL/TLS handshake.
+	SSL int64 `json:"ssl"`
 	// Send is the time required to send HTTP request to the server.
 	Send int64 `json:"send"`
 	// Wait is the time spent waiting for a response from the server.
@@ -449,6 +497,32 @@ func SkipBodyLoggingForContentTypes(cts ...string) Option {
 	}
 }
 
+// SamplePercent returns an option that records only percent of the requests,
+// along with their responses, so that logging can stay enabled for busy
+// proxies. Requests are sampled evenly: with 25, one in four requests is
+// recorded. The default is 100.
+func SamplePercent(percent int) Option {
+	return func(l *Logger) {
+		l.samplePercent = percent
+	}
+}
+
+// IncludeURLs returns an option that records only the requests whose URL
+// matches one of res. By default all requests are recorded.
+func IncludeURLs(res ...*regexp.Regexp) Option {
+	return func(l *Logger) {
+		l.includeURLs = res
+	}
+}
+
+// ExcludeURLs returns an option that does not record the requests whose URL
+// matches one of res, even if it is included.
+func ExcludeURLs(res ...*regexp.Regexp) Option {
+	return func(l *Logger) {
+		l.excludeURLs = res
+	}
+}
+
 // NewLogger returns a HAR logger. The returned
 // logger logs all request post data and response bodies by default.
 func NewLogger() *Logger {
@@ -457,7 +531,8 @@ func NewLogger() *Logger {
 			Name:    "martian proxy",
 			Version: "2.0.0",
 		},
-		entries: make(map[string]*Entry),
+		entries:       make(map[string]*Entry),
+		samplePercent: 100,
 	}
 	l.SetOption(BodyLogging(true))
 	l.SetOption(PostDataLogging(true))
@@ -484,8 +559,13 @@ func (l *Logger) ModifyRequest(req *http.Request) error {
 }
 
 // RecordRequest logs the HTTP request with the given ID. The ID should be unique
-// per request/response pair.
+// per request/response pair. Requests filtered out by URL or sampling are not
+// logged, nor are their responses.
 func (l *Logger) RecordRequest(id string, req *http.Request) error {
+	if !l.matchesURL(req.URL.String()) || !l.sampled() {
+		return nil
+	}
+
 	hreq, err := NewRequest(req, l.postDataLogging(req))
 	if err != nil {
 		return err
@@ -496,7 +576,10 @@ func (l *Logger) RecordRequest(id string, req *http.Request) error {
 		StartedDateTime: time.Now().UTC(),
 		Request:         hreq,
 		Cache:           &Cache{},
-		Timings:         &Timings{},
+		Timings:         &Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+	}
+	if ctx := martian.NewContext(req); ctx != nil {
+		entry.Labels = ctx.Session().Labels()
 	}
 
 	l.mu.Lock()
@@ -516,6 +599,43 @@ func (l *Logger) RecordRequest(id string, req *http.Request) error {
 	return nil
 }
 
+// matchesURL reports whether requests to u are recorded under the URL
+// filters.
+func (l *Logger) matchesURL(u string) bool {
+	for _, re := range l.excludeURLs {
+		if re.MatchString(u) {
+			return false
+		}
+	}
+	if len(l.includeURLs) == 0 {
+		return true
+	}
+	for _, re := range l.includeURLs {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sampled reports whether the next request is recorded under the sample
+// percentage. The nth request is recorded when n*percent/100 reaches the
+// next integer, which spreads the recorded requests evenly.
+func (l *Logger) sampled() bool {
+	if l.samplePercent >= 100 {
+		return true
+	}
+	if l.samplePercent <= 0 {
+		return false
+	}
+
+	n := l.sampleCount.Add(1)
+	p := uint64(l.samplePercent)
+
+	return n*p/100 != (n-1)*p/100
+}
+
 // NewRequest constructs and returns a Request from req. If withBody is true,
 // req.Body is read to EOF and replaced with a copy in a bytes.Buffer. An error
 // is returned (and req.Body may be in an intermediate state) if an error is
@@ -564,10 +684,20 @@ func (l *Logger) ModifyResponse(res *http.Response) error {
 // RecordResponse logs an HTTP response, associating it with the previously-logged
 // HTTP request with the same ID.
 func (l *Logger) RecordResponse(id string, res *http.Response) error {
-	hres, err := NewResponse(res, l.bodyLogging(res))
+	l.mu.Lock()
+	_, ok := l.entries[id]
+	l.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	withBody := l.bodyLogging(res)
+	hres, err := NewResponse(res, withBody)
 	if err != nil {
 		return err
 	}
+	received := time.Now()
+	t := roundTripTiming(res.Request)
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -575,6 +705,16 @@ func (l *Logger) RecordResponse(id string, res *http.Response) error {
 	if e, ok := l.entries[id]; ok {
 		e.Response = hres
 		e.Time = time.Since(e.StartedDateTime).Nanoseconds() / 1000000
+		e.Redirects = redirects(res.Request)
+		e.Protocol = res.Proto
+		if res.TLS != nil {
+			e.TLSVersion = tlsVersion(res.TLS.Version)
+		}
+		if t != nil {
+			e.Timings = timings(t, withBody, received)
+			e.ServerIPAddress = hostIP(t.RemoteAddr)
+			e.Connection = t.ConnID
+		}
 	}
 
 	return nil
@@ -626,6 +766,119 @@ func NewResponse(res *http.Response, withBody bool) (*Response, error) {
 	return r, nil
 }
 
+// roundTripTiming returns the timing of the round trip of req, or nil if it
+// was not recorded.
+func roundTripTiming(req *http.Request) *martian.RoundTripTiming {
+	if req == nil {
+		return nil
+	}
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.RoundTripTiming()
+	return t
+}
+
+// timings returns the HAR timings of the round trip t. The response is
+// received when its body was read, at received, if withBody is true;
+// otherwise the body is streamed to the client later and receive is 0.
+func timings(t *martian.RoundTripTiming, withBody bool, received time.Time) *Timings {
+	ts := &Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+
+	var dialed time.Duration
+	if !t.DNSStart.IsZero() {
+		ts.DNS = millis(t.DNS())
+		dialed += t.DNS()
+	}
+	if !t.ConnectStart.IsZero() {
+		end := t.ConnectDone
+		if !t.TLSHandshakeDone.IsZero() {
+			end = t.TLSHandshakeDone
+		}
+		connect := end.Sub(t.ConnectStart)
+		ts.Connect = millis(connect)
+		dialed += connect
+	}
+	if !t.TLSHandshakeStart.IsZero() {
+		ts.SSL = millis(t.TLSHandshake())
+	}
+	if !t.GetConn.IsZero() && !t.GotConn.IsZero() {
+		if blocked := t.GotConn.Sub(t.GetConn) - dialed; blocked > 0 {
+			ts.Blocked = millis(blocked)
+		} else {
+			ts.Blocked = 0
+		}
+	}
+	if !t.GotConn.IsZero() && !t.WroteRequest.IsZero() {
+		ts.Send = millis(t.WroteRequest.Sub(t.GotConn))
+	}
+	ts.Wait = millis(t.TTFB())
+	if withBody && !t.End.IsZero() {
+		ts.Receive = millis(received.Sub(t.End))
+	}
+
+	return ts
+}
+
+// hostIP returns the IP address of addr, without the port.
+func hostIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// tlsVersion returns the name of the TLS version v.
+func tlsVersion(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", v)
+}
+
+// millis returns d in milliseconds, with negative durations as 0.
+func millis(d time.Duration) int64 {
+	if d < 0 {
+		return 0
+	}
+	return d.Milliseconds()
+}
+
+// redirects returns the redirects followed by the proxy for req.
+func redirects(req *http.Request) []Redirect {
+	if req == nil {
+		return nil
+	}
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+	v, ok := ctx.Get(martian.RedirectsKey)
+	if !ok {
+		return nil
+	}
+
+	var rs []Redirect
+	for _, r := range v.([]*martian.Redirect) {
+		rs = append(rs, Redirect{
+			Method:      r.Method,
+			URL:         r.URL,
+			Status:      r.StatusCode,
+			RedirectURL: r.Location,
+		})
+	}
+	return rs
+}
+
 // Export returns the in-memory log.
 func (l *Logger) Export() *HAR {
 	l.mu.Lock()
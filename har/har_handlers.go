@@ -19,8 +19,10 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/preview"
 )
 
 type exportHandler struct {
@@ -31,6 +33,22 @@ type resetHandler struct {
 	logger *Logger
 }
 
+type previewHandler struct {
+	logger *Logger
+}
+
+// EntryPreview is a log entry with previews of the bodies in place of the
+// full request and response.
+type EntryPreview struct {
+	ID              string           `json:"_id"`
+	StartedDateTime time.Time        `json:"startedDateTime"`
+	Method          string           `json:"method"`
+	URL             string           `json:"url"`
+	Status          int              `json:"status,omitempty"`
+	Request         *preview.Preview `json:"request"`
+	Response        *preview.Preview `json:"response,omitempty"`
+}
+
 // NewExportHandler returns an http.Handler for requesting HAR logs.
 func NewExportHandler(l *Logger) http.Handler {
 	return &exportHandler{
@@ -45,6 +63,16 @@ func NewResetHandler(l *Logger) http.Handler {
 	}
 }
 
+// NewPreviewHandler returns an http.Handler for listing log entries with
+// previews of their bodies, so that clients do not need to download full
+// payloads. The "id" query parameter limits the list to a single entry and the
+// "max" query parameter sets the maximum length of text excerpts.
+func NewPreviewHandler(l *Logger) http.Handler {
+	return &previewHandler{
+		logger: l,
+	}
+}
+
 // ServeHTTP writes the log in HAR format to the response body.
 func (h *exportHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if req.Method != "GET" {
@@ -90,6 +118,70 @@ func (h *resetHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	log.Infof("resetHandler.ServeHTTP: HAR logs cleared")
 }
 
+// ServeHTTP writes the entry previews as JSON to the response body.
+func (h *previewHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		rw.Header().Add("Allow", "GET")
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		log.Errorf("har: method not allowed: %s", req.Method)
+		return
+	}
+
+	var opts []preview.Option
+	if v := req.URL.Query().Get("max"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Errorf("har: invalid value for max param: %s", v)
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		opts = append(opts, preview.MaxText(n))
+	}
+	id := req.URL.Query().Get("id")
+
+	eps := []*EntryPreview{}
+	for _, e := range h.logger.Export().Log.Entries {
+		if id != "" && e.ID != id {
+			continue
+		}
+		eps = append(eps, newEntryPreview(e, opts...))
+	}
+
+	if id != "" && len(eps) == 0 {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(struct {
+		Entries []*EntryPreview `json:"entries"`
+	}{eps})
+}
+
+func newEntryPreview(e *Entry, opts ...preview.Option) *EntryPreview {
+	ep := &EntryPreview{
+		ID:              e.ID,
+		StartedDateTime: e.StartedDateTime,
+		Method:          e.Request.Method,
+		URL:             e.Request.URL,
+	}
+
+	if pd := e.Request.PostData; pd != nil {
+		ep.Request = preview.New(pd.MimeType, []byte(pd.Text), opts...)
+	} else {
+		ep.Request = preview.New("", nil, opts...)
+	}
+
+	if res := e.Response; res != nil {
+		ep.Status = res.Status
+		if c := res.Content; c != nil {
+			ep.Response = preview.New(c.MimeType, c.Text, opts...)
+		}
+	}
+
+	return ep
+}
+
 func parseBoolQueryParam(params url.Values, name string) (bool, error) {
 	if params[name] == nil {
 		return false, nil
@@ -18,9 +18,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/preview"
 	"github.com/google/martian/v3/proxyutil"
 )
 
@@ -144,3 +146,72 @@ func TestExportHandlerServeHTTP(t *testing.T) {
 		t.Errorf("rw.Code: got %d, want %d", got, want)
 	}
 }
+
+func TestPreviewHandlerServeHTTP(t *testing.T) {
+	logger := NewLogger()
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := logger.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(200, strings.NewReader(`{"a": [1, 2]}`), req)
+	res.Header.Set("Content-Type", "application/json")
+	if err := logger.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	h := NewPreviewHandler(logger)
+
+	req, err = http.NewRequest("GET", "/?id="+ctx.ID(), nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if got, want := rw.Code, http.StatusOK; got != want {
+		t.Fatalf("rw.Code: got %d, want %d", got, want)
+	}
+
+	var msg struct {
+		Entries []*EntryPreview `json:"entries"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &msg); err != nil {
+		t.Fatalf("json.Unmarshal(): got %v, want no error", err)
+	}
+	if got, want := len(msg.Entries), 1; got != want {
+		t.Fatalf("len(msg.Entries): got %d, want %d", got, want)
+	}
+
+	ep := msg.Entries[0]
+	if got, want := ep.Status, 200; got != want {
+		t.Errorf("ep.Status: got %d, want %d", got, want)
+	}
+	if got, want := ep.Request.Kind, preview.Empty; got != want {
+		t.Errorf("ep.Request.Kind: got %q, want %q", got, want)
+	}
+	if got, want := ep.Response.Kind, preview.JSON; got != want {
+		t.Errorf("ep.Response.Kind: got %q, want %q", got, want)
+	}
+	if got, want := ep.Response.JSON.Fields[0].Name, "a"; got != want {
+		t.Errorf("ep.Response.JSON.Fields[0].Name: got %q, want %q", got, want)
+	}
+
+	req, err = http.NewRequest("GET", "/?id=unknown", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if got, want := rw.Code, http.StatusNotFound; got != want {
+		t.Errorf("rw.Code: got %d, want %d", got, want)
+	}
+}
@@ -16,10 +16,14 @@ package har
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -669,6 +673,266 @@ func TestSkippingLogging(t *testing.T) {
 	}
 }
 
+func TestSessionLabels(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+
+	ctx := martian.TestContext(req, nil, nil)
+	ctx.Session().SetLabel("device", "pixel7")
+
+	logger := NewLogger()
+	if err := logger.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	log := logger.Export().Log
+	if got, want := len(log.Entries), 1; got != want {
+		t.Fatalf("len(log.Entries): got %d, want %d", got, want)
+	}
+	if got, want := log.Entries[0].Labels["device"], "pixel7"; got != want {
+		t.Errorf("log.Entries[0].Labels[%q]: got %q, want %q", "device", got, want)
+	}
+
+	b, err := json.Marshal(log.Entries[0])
+	if err != nil {
+		t.Fatalf("json.Marshal(): got %v, want no error", err)
+	}
+	if want := `"_labels":{"device":"pixel7"}`; !strings.Contains(string(b), want) {
+		t.Errorf("json.Marshal(): got %s, want to contain %s", b, want)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/a", nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+
+	ctx := martian.TestContext(req, nil, nil)
+
+	logger := NewLogger()
+	if err := logger.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	ctx.Set(martian.RedirectsKey, []*martian.Redirect{{
+		Method:     "GET",
+		URL:        "http://example.com/a",
+		StatusCode: 302,
+		Location:   "http://example.com/b",
+	}})
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := logger.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	log := logger.Export().Log
+	if got, want := len(log.Entries), 1; got != want {
+		t.Fatalf("len(log.Entries): got %d, want %d", got, want)
+	}
+
+	want := []Redirect{{Method: "GET", URL: "http://example.com/a", Status: 302, RedirectURL: "http://example.com/b"}}
+	if got := log.Entries[0].Redirects; !reflect.DeepEqual(got, want) {
+		t.Errorf("log.Entries[0].Redirects: got %+v, want %+v", got, want)
+	}
+}
+
+func TestTimings(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+
+	ctx := martian.TestContext(req, nil, nil)
+
+	logger := NewLogger()
+	logger.SetOption(BodyLogging(false))
+	if err := logger.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	ctx.Set(martian.RoundTripTimingKey, &martian.RoundTripTiming{
+		Start:                at(0),
+		GetConn:              at(1),
+		DNSStart:             at(2),
+		DNSDone:              at(7),
+		ConnectStart:         at(7),
+		ConnectDone:          at(17),
+		TLSHandshakeStart:    at(17),
+		TLSHandshakeDone:     at(37),
+		GotConn:              at(41),
+		WroteHeaders:         at(42),
+		WroteRequest:         at(44),
+		GotFirstResponseByte: at(94),
+		End:                  at(95),
+	})
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := logger.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	log := logger.Export().Log
+	if got, want := len(log.Entries), 1; got != want {
+		t.Fatalf("len(log.Entries): got %d, want %d", got, want)
+	}
+
+	want := &Timings{Blocked: 5, DNS: 5, Connect: 30, SSL: 20, Send: 3, Wait: 50}
+	if got := log.Entries[0].Timings; !reflect.DeepEqual(got, want) {
+		t.Errorf("log.Entries[0].Timings: got %+v, want %+v", got, want)
+	}
+
+	// Reused connections have no DNS, connect and SSL phases.
+	req, err = http.NewRequest("GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+	ctx = martian.TestContext(req, nil, nil)
+
+	logger = NewLogger()
+	if err := logger.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	ctx.Set(martian.RoundTripTimingKey, &martian.RoundTripTiming{
+		Start:                at(0),
+		GetConn:              at(0),
+		GotConn:              at(1),
+		Reused:               true,
+		WroteRequest:         at(2),
+		GotFirstResponseByte: at(12),
+		End:                  at(12),
+	})
+	res = proxyutil.NewResponse(200, nil, req)
+	if err := logger.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	got := logger.Export().Log.Entries[0].Timings
+	if got.Blocked != 1 || got.DNS != -1 || got.Connect != -1 || got.SSL != -1 || got.Send != 1 || got.Wait != 10 {
+		t.Errorf("log.Entries[0].Timings: got %+v, want blocked 1, dns, connect and ssl -1, send 1, wait 10", got)
+	}
+}
+
+func TestConnectionInfo(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+
+	ctx := martian.TestContext(req, nil, nil)
+
+	logger := NewLogger()
+	if err := logger.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	ctx.Set(martian.RoundTripTimingKey, &martian.RoundTripTiming{
+		LocalAddr:  "[::1]:50000",
+		RemoteAddr: "[2001:db8::1]:443",
+		ConnID:     "7",
+	})
+	res := proxyutil.NewResponse(200, nil, req)
+	res.Proto = "HTTP/2.0"
+	res.TLS = &tls.ConnectionState{Version: tls.VersionTLS13}
+	if err := logger.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	e := logger.Export().Log.Entries[0]
+	if got, want := e.ServerIPAddress, "2001:db8::1"; got != want {
+		t.Errorf("e.ServerIPAddress: got %q, want %q", got, want)
+	}
+	if got, want := e.Connection, "7"; got != want {
+		t.Errorf("e.Connection: got %q, want %q", got, want)
+	}
+	if got, want := e.Protocol, "HTTP/2.0"; got != want {
+		t.Errorf("e.Protocol: got %q, want %q", got, want)
+	}
+	if got, want := e.TLSVersion, "TLS 1.3"; got != want {
+		t.Errorf("e.TLSVersion: got %q, want %q", got, want)
+	}
+}
+
+func TestSamplePercent(t *testing.T) {
+	logger := NewLogger()
+	logger.SetOption(SamplePercent(25))
+
+	for i := 0; i < 20; i++ {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://example.com/%d", i), nil)
+		if err != nil {
+			t.Fatalf("NewRequest(): got %v, want no error", err)
+		}
+		martian.TestContext(req, nil, nil)
+
+		if err := logger.ModifyRequest(req); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+		res := proxyutil.NewResponse(200, strings.NewReader("body"), req)
+		if err := logger.ModifyResponse(res); err != nil {
+			t.Fatalf("ModifyResponse(): got %v, want no error", err)
+		}
+		// The bodies of responses to requests that are not recorded are
+		// not read.
+		if got, _ := io.ReadAll(res.Body); string(got) != "body" {
+			t.Errorf("res.Body: got %q, want %q", got, "body")
+		}
+	}
+
+	log := logger.Export().Log
+	if got, want := len(log.Entries), 5; got != want {
+		t.Fatalf("len(log.Entries): got %d, want %d", got, want)
+	}
+	for i, e := range log.Entries {
+		if want := fmt.Sprintf("http://example.com/%d", 4*i+3); e.Request.URL != want {
+			t.Errorf("log.Entries[%d].Request.URL: got %s, want %s", i, e.Request.URL, want)
+		}
+		if e.Response == nil {
+			t.Errorf("log.Entries[%d].Response: got nil, want response", i)
+		}
+	}
+}
+
+func TestURLFilters(t *testing.T) {
+	logger := NewLogger()
+	logger.SetOption(
+		IncludeURLs(regexp.MustCompile(`^https?://example\.com/`), regexp.MustCompile(`^https://api\.test/`)),
+		ExcludeURLs(regexp.MustCompile(`\.(png|css)$`)),
+	)
+
+	for _, u := range []string{
+		"http://example.com/",
+		"http://example.com/style.css",
+		"https://api.test/v1/users",
+		"https://other.test/",
+		"https://example.com/logo.png",
+	} {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatalf("NewRequest(): got %v, want no error", err)
+		}
+		martian.TestContext(req, nil, nil)
+
+		if err := logger.ModifyRequest(req); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+	}
+
+	var got []string
+	for _, e := range logger.Export().Log.Entries {
+		got = append(got, e.Request.URL)
+	}
+	want := []string{"http://example.com/", "https://api.test/v1/users"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recorded URLs: got %v, want %v", got, want)
+	}
+}
+
 func TestOptionResponseBodyLogging(t *testing.T) {
 	req, err := http.NewRequest("GET", "http://example.com", nil)
 	if err != nil {
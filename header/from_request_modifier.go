@@ -0,0 +1,147 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package header
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("header.FromRequest", fromRequestModifierFromJSON)
+}
+
+// FromRequestModifier is a response modifier that sets response headers to
+// values of the request, so that tests can assert on what reached the proxy.
+// The sources of the values are:
+//
+//	header:Name  the values of the request header Name
+//	query:name   the values of the query parameter name
+//	id           the ID of the request, see martian.Context.ID
+//	method       the request method
+//	host         the host the request was sent to
+//	path         the path of the URL
+//	url          the URL
+//
+// A response header whose source has no value is left unchanged.
+type FromRequestModifier struct {
+	copies []fromRequestCopy
+}
+
+type fromRequestCopy struct {
+	source, to string
+}
+
+type fromRequestModifierJSON struct {
+	Headers map[string]string    `json:"headers"`
+	Scope   []parse.ModifierType `json:"scope"`
+}
+
+// NewFromRequestModifier returns a FromRequestModifier that copies nothing.
+func NewFromRequestModifier() *FromRequestModifier {
+	return &FromRequestModifier{}
+}
+
+// Copy sets the response header to to the values of source in the request.
+func (m *FromRequestModifier) Copy(source, to string) error {
+	switch {
+	case source == "id", source == "method", source == "host", source == "path", source == "url":
+	case strings.HasPrefix(source, "header:") && len(source) > len("header:"):
+	case strings.HasPrefix(source, "query:") && len(source) > len("query:"):
+	default:
+		return fmt.Errorf("header: unknown request value %q", source)
+	}
+
+	m.copies = append(m.copies, fromRequestCopy{source: source, to: to})
+	return nil
+}
+
+// ModifyResponse sets the response headers to the values of the request.
+func (m *FromRequestModifier) ModifyResponse(res *http.Response) error {
+	req := res.Request
+	if req == nil {
+		return nil
+	}
+
+	for _, c := range m.copies {
+		var values []string
+		switch {
+		case c.source == "id":
+			if ctx := martian.NewContext(req); ctx != nil {
+				values = []string{ctx.ID()}
+			}
+		case c.source == "method":
+			values = []string{req.Method}
+		case c.source == "host":
+			host := req.Host
+			if host == "" {
+				host = req.URL.Host
+			}
+			values = []string{host}
+		case c.source == "path":
+			values = []string{req.URL.Path}
+		case c.source == "url":
+			values = []string{req.URL.String()}
+		case strings.HasPrefix(c.source, "header:"):
+			values = req.Header.Values(strings.TrimPrefix(c.source, "header:"))
+		default:
+			values = req.URL.Query()[strings.TrimPrefix(c.source, "query:")]
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		res.Header.Del(c.to)
+		for _, v := range values {
+			res.Header.Add(c.to, v)
+		}
+	}
+
+	return nil
+}
+
+// fromRequestModifierFromJSON builds a header.FromRequest modifier from JSON.
+// "headers" maps the response headers to set to the request values they are
+// set to.
+//
+// Example JSON:
+//
+//	{
+//	  "header.FromRequest": {
+//	    "scope": ["response"],
+//	    "headers": {
+//	      "X-Echo-Trace-Id": "header:X-Trace-Id",
+//	      "X-Echo-User": "query:user",
+//	      "X-Request-Id": "id"
+//	    }
+//	  }
+//	}
+func fromRequestModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &fromRequestModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	// Copy in a stable order.
+	tos := make([]string, 0, len(msg.Headers))
+	for to := range msg.Headers {
+		tos = append(tos, to)
+	}
+	sort.Strings(tos)
+
+	mod := NewFromRequestModifier()
+	for _, to := range tos {
+		if err := mod.Copy(msg.Headers[to], to); err != nil {
+			return nil, err
+		}
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package header
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestFromRequestModifier(t *testing.T) {
+	mod := NewFromRequestModifier()
+	for _, c := range []struct{ source, to string }{
+		{"header:X-Trace", "X-Echo-Trace"},
+		{"header:X-Missing", "X-Kept"},
+		{"query:tag", "X-Echo-Tag"},
+		{"id", "X-Request-Id"},
+		{"method", "X-Echo-Method"},
+		{"host", "X-Echo-Host"},
+		{"path", "X-Echo-Path"},
+		{"url", "X-Echo-URL"},
+	} {
+		if err := mod.Copy(c.source, c.to); err != nil {
+			t.Fatalf("Copy(%q, %q): got %v, want no error", c.source, c.to, err)
+		}
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com/path?tag=a&tag=b", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-Trace", "trace")
+	ctx := martian.TestContext(req, nil, nil)
+
+	res := proxyutil.NewResponse(200, nil, req)
+	res.Header.Set("X-Kept", "kept")
+	res.Header.Set("X-Echo-Trace", "overwritten")
+
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	tt := []struct {
+		name string
+		want []string
+	}{
+		{"X-Echo-Trace", []string{"trace"}},
+		{"X-Kept", []string{"kept"}},
+		{"X-Echo-Tag", []string{"a", "b"}},
+		{"X-Request-Id", []string{ctx.ID()}},
+		{"X-Echo-Method", []string{"POST"}},
+		{"X-Echo-Host", []string{"example.com"}},
+		{"X-Echo-Path", []string{"/path"}},
+		{"X-Echo-URL", []string{"http://example.com/path?tag=a&tag=b"}},
+	}
+
+	for i, tc := range tt {
+		if got := res.Header.Values(tc.name); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d. res.Header.Values(%q): got %v, want %v", i, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFromRequestModifierUnknownSource(t *testing.T) {
+	mod := NewFromRequestModifier()
+	for _, source := range []string{"cookie:session", "header:", "query:", ""} {
+		if err := mod.Copy(source, "X-To"); err == nil {
+			t.Errorf("Copy(%q): got nil, want error", source)
+		}
+	}
+}
+
+func TestFromRequestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"header.FromRequest": {
+			"scope": ["response"],
+			"headers": {
+				"X-Echo-User": "query:user",
+				"X-Echo-Method": "method"
+			}
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("resmod: got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/?user=alice", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	if got, want := res.Header.Get("X-Echo-User"), "alice"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Echo-User", got, want)
+	}
+	if got, want := res.Header.Get("X-Echo-Method"), "GET"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Echo-Method", got, want)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"header.FromRequest": {"scope": ["response"], "headers": {"X-To": "body"}}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for unknown request value")
+	}
+}
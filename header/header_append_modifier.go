@@ -17,6 +17,7 @@ package header
 import (
 	"encoding/json"
 	"net/http"
+	"text/template"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/parse"
@@ -29,22 +30,40 @@ func init() {
 
 type appendModifier struct {
 	name, value string
+	tmpl        *template.Template
 }
 
 type appendModifierJSON struct {
-	Name  string               `json:"name"`
-	Value string               `json:"value"`
-	Scope []parse.ModifierType `json:"scope"`
+	Name     string               `json:"name"`
+	Value    string               `json:"value"`
+	Template bool                 `json:"template"`
+	Scope    []parse.ModifierType `json:"scope"`
 }
 
 // ModifyRequest appends the header at name with value to the request.
 func (m *appendModifier) ModifyRequest(req *http.Request) error {
-	return proxyutil.RequestHeader(req).Add(m.name, m.value)
+	value := m.value
+	if m.tmpl != nil {
+		var err error
+		if value, err = executeValueTemplate(m.tmpl, req, nil); err != nil {
+			return err
+		}
+	}
+
+	return proxyutil.RequestHeader(req).Add(m.name, value)
 }
 
 // ModifyResponse appends the header at name with value to the response.
 func (m *appendModifier) ModifyResponse(res *http.Response) error {
-	return proxyutil.ResponseHeader(res).Add(m.name, m.value)
+	value := m.value
+	if m.tmpl != nil {
+		var err error
+		if value, err = executeValueTemplate(m.tmpl, res.Request, res); err != nil {
+			return err
+		}
+	}
+
+	return proxyutil.ResponseHeader(res).Add(m.name, value)
 }
 
 // NewAppendModifier returns an appendModifier that will append a header with
@@ -57,8 +76,24 @@ func NewAppendModifier(name, value string) martian.RequestResponseModifier {
 	}
 }
 
+// NewTemplateAppendModifier returns an appendModifier that will append a
+// header with the given name and the value of the template tmpl, see
+// TemplateData, executed for each request and response.
+func NewTemplateAppendModifier(name, tmpl string) (martian.RequestResponseModifier, error) {
+	t, err := parseValueTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &appendModifier{
+		name: http.CanonicalHeaderKey(name),
+		tmpl: t,
+	}, nil
+}
+
 // appendModifierFromJSON takes a JSON message as a byte slice and returns
-// an appendModifier and an error.
+// an appendModifier and an error. When template is true, the value is a
+// template executed for each message, see TemplateData.
 //
 // Example JSON configuration message:
 // {
@@ -67,11 +102,20 @@ func NewAppendModifier(name, value string) martian.RequestResponseModifier {
 //  "value": "true"
 // }
 func appendModifierFromJSON(b []byte) (*parse.Result, error) {
-	msg := &modifierJSON{}
+	msg := &appendModifierJSON{}
 	if err := json.Unmarshal(b, msg); err != nil {
 		return nil, err
 	}
 
+	if msg.Template {
+		modifier, err := NewTemplateAppendModifier(msg.Name, msg.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return parse.NewResult(modifier, msg.Scope)
+	}
+
 	modifier := NewAppendModifier(msg.Name, msg.Value)
 
 	return parse.NewResult(modifier, msg.Scope)
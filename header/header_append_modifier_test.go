@@ -92,3 +92,44 @@ func TestAppendModifierFromJSON(t *testing.T) {
 		t.Errorf("res.Header[%q]: got len %d, want 2", "X-Martian", n)
 	}
 }
+
+func TestTemplateAppendModifier(t *testing.T) {
+	msg := []byte(`{
+		"header.Append": {
+			"scope": ["request", "response"],
+			"name": "X-Hops",
+			"value": "{{.Request.Method}} {{.Request.URL.Path}}",
+			"template": true
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/path", nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Add("X-Hops", "client")
+
+	if err := r.RequestModifier().ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Values("X-Hops"), []string{"client", "GET /path"}; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("req.Header.Values(%q): got %v, want %v", "X-Hops", got, want)
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := r.ResponseModifier().ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("X-Hops"), "GET /path"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Hops", got, want)
+	}
+
+	if _, err := NewTemplateAppendModifier("X-Bad", "{{end}}"); err == nil {
+		t.Error("NewTemplateAppendModifier(): got nil, want error for invalid template")
+	}
+}
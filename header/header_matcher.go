@@ -15,11 +15,17 @@
 package header
 
 import (
+	"encoding/json"
 	"net/http"
 
+	"github.com/google/martian/v3/filter"
 	"github.com/google/martian/v3/proxyutil"
 )
 
+func init() {
+	filter.RegisterCondition("header", matcherFromJSON)
+}
+
 // Matcher is a conditonal evalutor of request or
 // response headers to be used in structs that take conditions.
 type Matcher struct {
@@ -34,6 +40,28 @@ func NewMatcher(name, value string) *Matcher {
 	}
 }
 
+type matcherJSON struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// matcherFromJSON builds a header.Matcher condition from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "name": "Martian-Testing",
+//	  "value": "true"
+//	}
+func matcherFromJSON(b []byte) (filter.Condition, error) {
+	msg := &matcherJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return NewMatcher(http.CanonicalHeaderKey(msg.Name), msg.Value), nil
+}
+
 // MatchRequest evaluates a request and returns whether or not
 // the request contains a header that matches the provided name
 // and value.
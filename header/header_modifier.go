@@ -17,6 +17,7 @@ package header
 import (
 	"encoding/json"
 	"net/http"
+	"text/template"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/parse"
@@ -29,22 +30,40 @@ func init() {
 
 type modifier struct {
 	name, value string
+	tmpl        *template.Template
 }
 
 type modifierJSON struct {
-	Name  string               `json:"name"`
-	Value string               `json:"value"`
-	Scope []parse.ModifierType `json:"scope"`
+	Name     string               `json:"name"`
+	Value    string               `json:"value"`
+	Template bool                 `json:"template"`
+	Scope    []parse.ModifierType `json:"scope"`
 }
 
 // ModifyRequest sets the header at name with value on the request.
 func (m *modifier) ModifyRequest(req *http.Request) error {
-	return proxyutil.RequestHeader(req).Set(m.name, m.value)
+	value := m.value
+	if m.tmpl != nil {
+		var err error
+		if value, err = executeValueTemplate(m.tmpl, req, nil); err != nil {
+			return err
+		}
+	}
+
+	return proxyutil.RequestHeader(req).Set(m.name, value)
 }
 
 // ModifyResponse sets the header at name with value on the response.
 func (m *modifier) ModifyResponse(res *http.Response) error {
-	return proxyutil.ResponseHeader(res).Set(m.name, m.value)
+	value := m.value
+	if m.tmpl != nil {
+		var err error
+		if value, err = executeValueTemplate(m.tmpl, res.Request, res); err != nil {
+			return err
+		}
+	}
+
+	return proxyutil.ResponseHeader(res).Set(m.name, value)
 }
 
 // NewModifier returns a modifier that will set the header at name with
@@ -57,14 +76,31 @@ func NewModifier(name, value string) martian.RequestResponseModifier {
 	}
 }
 
+// NewTemplateModifier returns a modifier that will set the header at name
+// with the value of the template tmpl, see TemplateData, executed for each
+// request and response.
+func NewTemplateModifier(name, tmpl string) (martian.RequestResponseModifier, error) {
+	t, err := parseValueTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &modifier{
+		name: http.CanonicalHeaderKey(name),
+		tmpl: t,
+	}, nil
+}
+
 // modifierFromJSON takes a JSON message as a byte slice and returns
-// a headerModifier and an error.
+// a headerModifier and an error. When template is true, the value is a
+// template executed for each message, see TemplateData.
 //
 // Example JSON configuration message:
 // {
 //  "scope": ["request", "result"],
-//  "name": "X-Martian",
-//  "value": "true"
+//  "name": "X-Original-Host",
+//  "value": "{{.Request.Host}}",
+//  "template": true
 // }
 func modifierFromJSON(b []byte) (*parse.Result, error) {
 	msg := &modifierJSON{}
@@ -72,6 +108,15 @@ func modifierFromJSON(b []byte) (*parse.Result, error) {
 		return nil, err
 	}
 
+	if msg.Template {
+		modifier, err := NewTemplateModifier(msg.Name, msg.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return parse.NewResult(modifier, msg.Scope)
+	}
+
 	modifier := NewModifier(msg.Name, msg.Value)
 
 	return parse.NewResult(modifier, msg.Scope)
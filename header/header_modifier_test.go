@@ -16,8 +16,10 @@ package header
 
 import (
 	"net/http"
+	"regexp"
 	"testing"
 
+	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/parse"
 	"github.com/google/martian/v3/proxyutil"
 )
@@ -112,3 +114,95 @@ func TestModifierFromJSON(t *testing.T) {
 		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Martian", got, want)
 	}
 }
+
+func TestTemplateModifier(t *testing.T) {
+	mod, err := NewTemplateModifier("X-Original", `{{.Request.Host}}{{.Request.URL.Path}} {{.Request.Header.Get "X-User"}} {{.SessionID}} {{index .Captures "1"}}`)
+	if err != nil {
+		t.Fatalf("NewTemplateModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/path", nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-User", "alice")
+	req.Header.Set("X-Tenant", "tenant-42")
+	ctx := martian.TestContext(req, nil, nil)
+
+	// Captures of a regex matcher are available to templates.
+	if !NewRegexMatcher("X-Tenant", regexp.MustCompile(`tenant-(\d+)`)).MatchRequest(req) {
+		t.Fatal("MatchRequest(): got false, want true")
+	}
+
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	want := "example.com/path alice " + ctx.Session().ID() + " 42"
+	if got := req.Header.Get("X-Original"); got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Original", got, want)
+	}
+
+	mod, err = NewTemplateModifier("X-Status", "{{.Response.StatusCode}} {{.Request.Method}}")
+	if err != nil {
+		t.Fatalf("NewTemplateModifier(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(201, nil, req)
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("X-Status"), "201 GET"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Status", got, want)
+	}
+
+	if _, err := NewTemplateModifier("X-Bad", "{{.Request"); err == nil {
+		t.Error("NewTemplateModifier(): got nil, want error for invalid template")
+	}
+}
+
+func TestTemplateModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"header.Modifier": {
+			"scope": ["request"],
+			"name": "X-Original-Host",
+			"value": "{{.Request.Host}}",
+			"template": true
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+	if err := r.RequestModifier().ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Original-Host"), "example.com"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Original-Host", got, want)
+	}
+
+	// Without template, the value is literal.
+	msg = []byte(`{
+		"header.Modifier": {
+			"scope": ["request"],
+			"name": "X-Literal",
+			"value": "{{.Request.Host}}"
+		}
+	}`)
+
+	r, err = parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	if err := r.RequestModifier().ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Literal"), "{{.Request.Host}}"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Literal", got, want)
+	}
+}
@@ -18,41 +18,62 @@ import (
 	"encoding/json"
 	"net/http"
 	"regexp"
+	"strconv"
 
 	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/filter"
 	"github.com/google/martian/v3/parse"
 )
 
+const regexCapturesKey = "header.RegexCaptures"
+
 // ValueRegexFilter executes resmod and reqmod when the header
 // value matches regex.
 type ValueRegexFilter struct {
-	regex  *regexp.Regexp
-	header string
-	reqmod martian.RequestModifier
-	resmod martian.ResponseModifier
+	matcher *RegexMatcher
+	reqmod  martian.RequestModifier
+	resmod  martian.ResponseModifier
+	freqmod martian.RequestModifier
+	fresmod martian.ResponseModifier
 }
 
 type headerValueRegexFilterJSON struct {
-	Regex      string               `json:"regex"`
-	HeaderName string               `json:"header"`
-	Modifier   json.RawMessage      `json:"modifier"`
-	Scope      []parse.ModifierType `json:"scope"`
+	Regex        string               `json:"regex"`
+	HeaderName   string               `json:"header"`
+	Modifier     json.RawMessage      `json:"modifier"`
+	ElseModifier json.RawMessage      `json:"else"`
+	Scope        []parse.ModifierType `json:"scope"`
 }
 
 func init() {
 	parse.Register("header.RegexFilter", headerValueRegexFilterFromJSON)
+	filter.RegisterCondition("headerRegex", regexMatcherFromJSON)
 }
 
 // NewValueRegexFilter builds a new header value regex filter.
 func NewValueRegexFilter(regex *regexp.Regexp, header string) *ValueRegexFilter {
 	return &ValueRegexFilter{
-		regex:  regex,
-		header: header,
-		reqmod: noop,
-		resmod: noop,
+		matcher: NewRegexMatcher(header, regex),
+		reqmod:  noop,
+		resmod:  noop,
+		freqmod: noop,
+		fresmod: noop,
 	}
 }
 
+// headerValueRegexFilterFromJSON builds a header.RegexFilter from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "header.RegexFilter": {
+//	    "scope": ["request", "response"],
+//	    "header": "Authorization",
+//	    "regex": "^Bearer (?P<token>.+)$",
+//	    "modifier": { ... },
+//	    "else": { ... }
+//	  }
+//	}
 func headerValueRegexFilterFromJSON(b []byte) (*parse.Result, error) {
 	msg := &headerValueRegexFilterJSON{}
 	if err := json.Unmarshal(b, msg); err != nil {
@@ -76,35 +97,41 @@ func headerValueRegexFilterFromJSON(b []byte) (*parse.Result, error) {
 	resmod := r.ResponseModifier()
 	filter.SetResponseModifier(resmod)
 
+	if len(msg.ElseModifier) > 0 {
+		em, err := parse.FromJSON(msg.ElseModifier)
+		if err != nil {
+			return nil, err
+		}
+
+		if em != nil {
+			filter.RequestWhenFalse(em.RequestModifier())
+			filter.ResponseWhenFalse(em.ResponseModifier())
+		}
+	}
+
 	return parse.NewResult(filter, msg.Scope)
 }
 
-// ModifyRequest runs reqmod iff the value of header matches regex.
+// ModifyRequest runs reqmod iff the value of header matches regex, and the
+// else request modifier otherwise. The capture groups of the match are
+// available to reqmod with RegexCaptures.
 func (f *ValueRegexFilter) ModifyRequest(req *http.Request) error {
-	hvalue := req.Header.Get(f.header)
-	if hvalue == "" {
-		return nil
-	}
-
-	if f.regex.MatchString(hvalue) {
+	if f.matcher.MatchRequest(req) {
 		return f.reqmod.ModifyRequest(req)
 	}
 
-	return nil
+	return f.freqmod.ModifyRequest(req)
 }
 
-// ModifyResponse runs resmod iff the value of request header matches regex.
+// ModifyResponse runs resmod iff the value of request header matches regex,
+// and the else response modifier otherwise. The capture groups of the match
+// are available to resmod with RegexCaptures.
 func (f *ValueRegexFilter) ModifyResponse(res *http.Response) error {
-	hvalue := res.Request.Header.Get(f.header)
-	if hvalue == "" {
-		return nil
-	}
-
-	if f.regex.MatchString(hvalue) {
+	if f.matcher.MatchResponse(res) {
 		return f.resmod.ModifyResponse(res)
 	}
 
-	return nil
+	return f.fresmod.ModifyResponse(res)
 }
 
 // SetRequestModifier sets the request modifier of HeaderValueRegexFilter.
@@ -126,3 +153,130 @@ func (f *ValueRegexFilter) SetResponseModifier(resmod martian.ResponseModifier)
 
 	f.resmod = resmod
 }
+
+// RequestWhenFalse sets the request modifier that is run when the header
+// value does not match.
+func (f *ValueRegexFilter) RequestWhenFalse(reqmod martian.RequestModifier) {
+	if reqmod == nil {
+		f.freqmod = noop
+		return
+	}
+
+	f.freqmod = reqmod
+}
+
+// ResponseWhenFalse sets the response modifier that is run when the header
+// value does not match.
+func (f *ValueRegexFilter) ResponseWhenFalse(resmod martian.ResponseModifier) {
+	if resmod == nil {
+		f.fresmod = noop
+		return
+	}
+
+	f.fresmod = resmod
+}
+
+// RegexMatcher is a conditional evaluator of a request header value against a
+// regular expression, to be used in structs that take conditions. Responses
+// are evaluated by their request.
+//
+// When a value matches, the capture groups of the match are stored in the
+// context of the request, see RegexCaptures.
+type RegexMatcher struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+type regexMatcherJSON struct {
+	Regex      string `json:"regex"`
+	HeaderName string `json:"header"`
+}
+
+// NewRegexMatcher builds a new header value regex matcher.
+func NewRegexMatcher(name string, regex *regexp.Regexp) *RegexMatcher {
+	return &RegexMatcher{
+		name:  http.CanonicalHeaderKey(name),
+		regex: regex,
+	}
+}
+
+// regexMatcherFromJSON builds a header.RegexMatcher condition from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "header": "User-Agent",
+//	  "regex": "Chrome/(?P<version>[0-9]+)"
+//	}
+func regexMatcherFromJSON(b []byte) (filter.Condition, error) {
+	msg := &regexMatcherJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	cr, err := regexp.Compile(msg.Regex)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRegexMatcher(msg.HeaderName, cr), nil
+}
+
+// MatchRequest returns whether a value of the header in req matches the
+// regular expression.
+func (m *RegexMatcher) MatchRequest(req *http.Request) bool {
+	for _, v := range req.Header.Values(m.name) {
+		sm := m.regex.FindStringSubmatch(v)
+		if sm == nil {
+			continue
+		}
+
+		if ctx := martian.NewContext(req); ctx != nil {
+			m.setCaptures(ctx, sm)
+		}
+		return true
+	}
+
+	return false
+}
+
+// MatchResponse returns whether a value of the header in res.Request matches
+// the regular expression.
+func (m *RegexMatcher) MatchResponse(res *http.Response) bool {
+	if res.Request == nil {
+		return false
+	}
+
+	return m.MatchRequest(res.Request)
+}
+
+// setCaptures adds the capture groups of a match to the captures of ctx.
+func (m *RegexMatcher) setCaptures(ctx *martian.Context, sm []string) {
+	captures := make(map[string]string)
+	for k, v := range RegexCaptures(ctx) {
+		captures[k] = v
+	}
+
+	for i, name := range m.regex.SubexpNames() {
+		captures[strconv.Itoa(i)] = sm[i]
+		if name != "" {
+			captures[name] = sm[i]
+		}
+	}
+
+	ctx.Set(regexCapturesKey, captures)
+}
+
+// RegexCaptures returns the capture groups of the header values matched by
+// regex filters and matchers for the request of ctx, keyed by their index
+// ("0" being the whole match) and, for named groups, by their name. When
+// several matches set the same key, the last one wins. The returned map must
+// not be modified.
+func RegexCaptures(ctx *martian.Context) map[string]string {
+	v, ok := ctx.Get(regexCapturesKey)
+	if !ok {
+		return nil
+	}
+
+	return v.(map[string]string)
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package header
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/filter"
+	"github.com/google/martian/v3/martiantest"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestValueRegexFilter(t *testing.T) {
+	f := NewValueRegexFilter(regexp.MustCompile(`^Bearer (?P<token>\w+)$`), "authorization")
+
+	var captures map[string]string
+	tm := martiantest.NewModifier()
+	f.SetRequestModifier(tm)
+	f.SetResponseModifier(tm)
+	em := martiantest.NewModifier()
+	f.RequestWhenFalse(em)
+	f.ResponseWhenFalse(em)
+
+	tt := []struct {
+		values []string
+		want   map[string]string
+	}{
+		{nil, nil},
+		{[]string{"Basic Zm9v"}, nil},
+		{[]string{"Basic Zm9v", "Bearer abc"}, map[string]string{"0": "Bearer abc", "1": "abc", "token": "abc"}},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		martian.TestContext(req, nil, nil)
+		for _, v := range tc.values {
+			req.Header.Add("Authorization", v)
+		}
+
+		tm.Reset()
+		em.Reset()
+		captures = nil
+		tm.RequestFunc(func(req *http.Request) {
+			captures = RegexCaptures(martian.NewContext(req))
+		})
+
+		if err := f.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. f.ModifyRequest(): got %v, want no error", i, err)
+		}
+		if err := f.ModifyResponse(proxyutil.NewResponse(200, nil, req)); err != nil {
+			t.Fatalf("%d. f.ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		matched := tc.want != nil
+		if got := tm.RequestModified(); got != matched {
+			t.Errorf("%d. tm.RequestModified(): got %t, want %t", i, got, matched)
+		}
+		if got := tm.ResponseModified(); got != matched {
+			t.Errorf("%d. tm.ResponseModified(): got %t, want %t", i, got, matched)
+		}
+		if got := em.RequestModified(); got == matched {
+			t.Errorf("%d. em.RequestModified(): got %t, want %t", i, got, !matched)
+		}
+		if !reflect.DeepEqual(captures, tc.want) {
+			t.Errorf("%d. RegexCaptures(): got %v, want %v", i, captures, tc.want)
+		}
+	}
+}
+
+func TestRegexCapturesMerge(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	ctx := martian.TestContext(req, nil, nil)
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("User-Agent", "Chrome/115")
+
+	tenant := NewRegexMatcher("X-Tenant", regexp.MustCompile(`(?P<tenant>\w+)`))
+	version := NewRegexMatcher("User-Agent", regexp.MustCompile(`Chrome/(?P<version>\d+)`))
+	if !filter.All(tenant, version).MatchRequest(req) {
+		t.Fatal("MatchRequest(): got false, want true")
+	}
+
+	want := map[string]string{"0": "Chrome/115", "1": "115", "tenant": "acme", "version": "115"}
+	if got := RegexCaptures(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("RegexCaptures(): got %v, want %v", got, want)
+	}
+}
+
+func TestValueRegexFilterFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "header.RegexFilter": {
+      "scope": ["request"],
+      "header": "X-Version",
+      "regex": "^v[0-9]+$",
+      "modifier": {
+        "header.Modifier": {
+          "scope": ["request"],
+          "name": "X-Versioned",
+          "value": "true"
+        }
+      },
+      "else": {
+        "header.Modifier": {
+          "scope": ["request"],
+          "name": "X-Versioned",
+          "value": "false"
+        }
+      }
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	tt := []struct {
+		version string
+		want    string
+	}{
+		{"v2", "true"},
+		{"latest", "false"},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		req.Header.Set("X-Version", tc.version)
+
+		if err := reqmod.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+		if got := req.Header.Get("X-Versioned"); got != tc.want {
+			t.Errorf("%d. req.Header.Get(%q): got %q, want %q", i, "X-Versioned", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package header
+
+import (
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/martian/v3"
+)
+
+// TemplateData is the data that header value templates, see text/template,
+// are executed with. For example:
+//
+//	{{.Request.Host}}
+//	{{.Request.URL.Path}}
+//	{{.Request.Header.Get "X-Forwarded-For"}}
+//	{{.Response.StatusCode}}
+//	{{.SessionID}}
+//	{{.Now.Unix}}
+//	{{index .Captures "1"}}
+type TemplateData struct {
+	// Request is the request, or the request of the response.
+	Request *http.Request
+	// Response is the response, or nil when modifying a request.
+	Response *http.Response
+	// ID is the ID of the request, see martian.Context.ID.
+	ID string
+	// SessionID is the ID of the session of the request.
+	SessionID string
+	// Now is the time the template is executed.
+	Now time.Time
+	// Captures are the capture groups of the header values matched for the
+	// request by a regex filter, see RegexCaptures.
+	Captures map[string]string
+}
+
+// parseValueTemplate parses a header value template.
+func parseValueTemplate(value string) (*template.Template, error) {
+	return template.New("header").Parse(value)
+}
+
+// executeValueTemplate executes tmpl for req, and res if it is not nil.
+func executeValueTemplate(tmpl *template.Template, req *http.Request, res *http.Response) (string, error) {
+	data := &TemplateData{
+		Request:  req,
+		Response: res,
+		Now:      time.Now(),
+	}
+	if req != nil {
+		if ctx := martian.NewContext(req); ctx != nil {
+			data.ID = ctx.ID()
+			data.SessionID = ctx.Session().ID()
+			data.Captures = RegexCaptures(ctx)
+		}
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,236 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/martian/v3/connmetric"
+	"github.com/google/martian/v3/log"
+)
+
+type headerOrderKey struct{}
+
+// HeaderOrder returns the names of the header fields of req in the order and
+// casing they were received from the client, or nil if they were not recorded,
+// see Proxy.PreserveHeaderOrder. Names of repeated fields appear once per
+// occurrence.
+func HeaderOrder(req *http.Request) []string {
+	order, _ := req.Context().Value(headerOrderKey{}).([]string)
+	return order
+}
+
+// parseHeaderOrder returns the field names of the raw request line and header,
+// as returned by peekHeader.
+func parseHeaderOrder(raw []byte) []string {
+	lines := strings.Split(string(raw), "\n")
+
+	var order []string
+	for _, l := range lines[1:] {
+		l = strings.TrimSuffix(l, "\r")
+		if l == "" || l[0] == ' ' || l[0] == '\t' {
+			continue
+		}
+		if name, _, ok := strings.Cut(l, ":"); ok {
+			order = append(order, name)
+		}
+	}
+
+	return order
+}
+
+var headerValueReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// writeOrderedRequest writes req to w as an HTTP/1.1 request with the header
+// fields in order, using the names of order as they are. Fields of req.Header
+// not named in order, such as fields added by modifiers, are written last.
+func writeOrderedRequest(w *bufio.Writer, req *http.Request, order []string) error {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	chunked := hasBody && req.ContentLength < 0
+
+	// Framing fields are written by the proxy, at the position the client
+	// sent them in.
+	framing := map[string]string{
+		"Host": host,
+	}
+	if chunked {
+		framing["Transfer-Encoding"] = "chunked"
+	} else if req.ContentLength > 0 || req.Header.Get("Content-Length") != "" {
+		framing["Content-Length"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+
+	writeField := func(name, value string) {
+		fmt.Fprintf(w, "%s: %s\r\n", name, headerValueReplacer.Replace(value))
+	}
+
+	written := make(map[string]int)
+	for _, name := range order {
+		key := http.CanonicalHeaderKey(name)
+		if v, ok := framing[key]; ok {
+			writeField(name, v)
+			delete(framing, key)
+			continue
+		}
+
+		vs := req.Header[key]
+		if written[key] < len(vs) {
+			writeField(name, vs[written[key]])
+			written[key]++
+		}
+	}
+
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch k {
+		case "Host", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		for _, v := range req.Header[k][written[k]:] {
+			writeField(k, v)
+		}
+	}
+	for _, k := range []string{"Host", "Content-Length", "Transfer-Encoding"} {
+		if v, ok := framing[k]; ok {
+			writeField(k, v)
+		}
+	}
+	io.WriteString(w, "\r\n")
+
+	if !hasBody {
+		return w.Flush()
+	}
+	if chunked {
+		cw := httputil.NewChunkedWriter(w)
+		if _, err := io.Copy(cw, req.Body); err != nil {
+			return err
+		}
+		if err := cw.Close(); err != nil {
+			return err
+		}
+		io.WriteString(w, "\r\n")
+	} else if _, err := io.CopyN(w, req.Body, req.ContentLength); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// orderedRoundTrip sends req to its destination over a new connection,
+// preserving the order and casing of the header fields the client sent. It
+// returns false if req cannot be sent this way and must be sent by the round
+// tripper.
+func (p *Proxy) orderedRoundTrip(req *http.Request) (*http.Response, bool, error) {
+	order := HeaderOrder(req)
+	if order == nil || upgradeType(req.Header) != "" {
+		return nil, false, nil
+	}
+	if _, ok := p.roundTripper.(*http.Transport); !ok {
+		return nil, false, nil
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, false, nil
+	}
+	if p.proxyURL != nil {
+		proxyURL, err := p.proxyURL(req)
+		if err != nil {
+			return nil, true, err
+		}
+		if proxyURL != nil {
+			log.Debugf("martian: not preserving header order for request sent via upstream proxy %s", proxyURL.Host)
+			return nil, false, nil
+		}
+	}
+
+	ctx := req.Context()
+	conn, err := p.dial(ctx, "tcp", canonicalAddr(req.URL))
+	if err != nil {
+		return nil, true, err
+	}
+
+	if req.URL.Scheme == "https" {
+		tc := p.clientTLSConfig()
+		tc.ServerName = req.URL.Hostname()
+		tc.NextProtos = nil
+
+		tlsconn := tls.Client(conn, tc)
+		hctx := context.WithValue(ctx, upstreamHostKey{}, req.URL.Hostname())
+		if err := connmetric.TLSHandshake(hctx, tlsconn); err != nil {
+			conn.Close()
+			return nil, true, err
+		}
+		conn = tlsconn
+	}
+
+	body := &connBody{conn: conn, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-body.done:
+		}
+	}()
+
+	if err := writeOrderedRequest(bufio.NewWriter(conn), req, order); err != nil {
+		body.Close()
+		return nil, true, err
+	}
+
+	br := bufio.NewReader(conn)
+	for {
+		res, err := http.ReadResponse(br, req)
+		if err != nil {
+			body.Close()
+			return nil, true, err
+		}
+		// Interim responses are not relayed.
+		if res.StatusCode >= 100 && res.StatusCode < 200 {
+			continue
+		}
+
+		body.ReadCloser = res.Body
+		res.Body = body
+		return res, true, nil
+	}
+}
+
+// connBody is the body of a response read by orderedRoundTrip, which closes
+// the connection of the response when closed.
+type connBody struct {
+	io.ReadCloser
+	conn io.Closer
+	done chan struct{}
+	once sync.Once
+}
+
+func (b *connBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		if b.ReadCloser != nil {
+			b.ReadCloser.Close()
+		}
+		err = b.conn.Close()
+		close(b.done)
+	})
+	return err
+}
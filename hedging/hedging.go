@@ -0,0 +1,227 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package hedging provides a round tripper that hedges requests to
+// latency-sensitive hosts: if no response has arrived after a delay, a
+// duplicate request is sent and whichever response arrives first is used.
+package hedging
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/martian/v3/log"
+)
+
+// Stats are the hedging metrics of a host.
+type Stats struct {
+	// Requests is the number of hedgeable requests.
+	Requests int64
+	// Hedged is the number of requests for which a duplicate was sent.
+	Hedged int64
+	// HedgeWins is the number of hedged requests answered first by the
+	// duplicate.
+	HedgeWins int64
+}
+
+type hostStats struct {
+	requests  int64
+	hedged    int64
+	hedgeWins int64
+}
+
+// Transport is an http.RoundTripper that hedges requests with safe methods
+// and without a body to hosts it is enabled for. All other requests are
+// passed to the underlying round tripper unchanged.
+type Transport struct {
+	rt http.RoundTripper
+
+	mu     sync.RWMutex
+	delays map[string]time.Duration
+	stats  map[string]*hostStats
+}
+
+// NewTransport returns a new hedging transport that sends requests with rt.
+// Hedging is disabled for all hosts.
+func NewTransport(rt http.RoundTripper) *Transport {
+	return &Transport{
+		rt:     rt,
+		delays: make(map[string]time.Duration),
+		stats:  make(map[string]*hostStats),
+	}
+}
+
+// SetHost enables hedging of requests to host after delay. host is matched
+// against the host of the request URL with and without its port. A delay of
+// zero or less disables hedging for host.
+func (t *Transport) SetHost(host string, delay time.Duration) {
+	host = strings.ToLower(host)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if delay <= 0 {
+		delete(t.delays, host)
+		return
+	}
+
+	t.delays[host] = delay
+	if _, ok := t.stats[host]; !ok {
+		t.stats[host] = &hostStats{}
+	}
+}
+
+// Stats returns the metrics of host.
+func (t *Transport) Stats(host string) Stats {
+	t.mu.RLock()
+	s, ok := t.stats[strings.ToLower(host)]
+	t.mu.RUnlock()
+
+	if !ok {
+		return Stats{}
+	}
+
+	return Stats{
+		Requests:  atomic.LoadInt64(&s.requests),
+		Hedged:    atomic.LoadInt64(&s.hedged),
+		HedgeWins: atomic.LoadInt64(&s.hedgeWins),
+	}
+}
+
+func (t *Transport) policy(req *http.Request) (time.Duration, *hostStats) {
+	host := strings.ToLower(req.URL.Host)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if d, ok := t.delays[host]; ok {
+		return d, t.stats[host]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		if d, ok := t.delays[h]; ok {
+			return d, t.stats[h]
+		}
+	}
+
+	return 0, nil
+}
+
+// hedgeable reports whether req can be sent twice without side effects.
+func hedgeable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+type result struct {
+	res   *http.Response
+	err   error
+	hedge bool
+}
+
+// RoundTrip sends req and, if hedging is enabled for its host and no response
+// has arrived after the delay, a duplicate of req. The first response is
+// returned and the other request is canceled. An error is only returned once
+// all sent requests have failed.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay, stats := t.policy(req)
+	if delay <= 0 || !hedgeable(req) {
+		return t.rt.RoundTrip(req)
+	}
+
+	atomic.AddInt64(&stats.requests, 1)
+
+	// cancels holds the cancel funcs of the original and the hedged request.
+	var cancels [2]context.CancelFunc
+	results := make(chan result, 2)
+	send := func(hedge bool) {
+		ctx, cancel := context.WithCancel(req.Context())
+		if hedge {
+			cancels[1] = cancel
+		} else {
+			cancels[0] = cancel
+		}
+
+		go func() {
+			res, err := t.rt.RoundTrip(req.Clone(ctx))
+			results <- result{res: res, err: err, hedge: hedge}
+		}()
+	}
+
+	send(false)
+	pending := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			log.Debugf("hedging: no response from %s after %s, sending hedged request", req.URL.Host, delay)
+			atomic.AddInt64(&stats.hedged, 1)
+			send(true)
+			pending++
+		case r := <-results:
+			pending--
+
+			win, lose := cancels[0], cancels[1]
+			if r.hedge {
+				win, lose = lose, win
+			}
+
+			if r.err != nil {
+				win()
+				err = r.err
+				continue
+			}
+
+			if r.hedge {
+				atomic.AddInt64(&stats.hedgeWins, 1)
+			}
+
+			if lose != nil {
+				lose()
+			}
+			if pending > 0 {
+				go discard(results, pending)
+			}
+
+			r.res.Body = &cancelBody{ReadCloser: r.res.Body, cancel: win}
+			r.res.Request = req
+			return r.res, nil
+		}
+	}
+
+	return nil, err
+}
+
+// discard closes the responses of n canceled requests.
+func discard(results <-chan result, n int) {
+	for i := 0; i < n; i++ {
+		if r := <-results; r.res != nil {
+			r.res.Body.Close()
+		}
+	}
+}
+
+// cancelBody cancels the context of its request when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package hedging
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/martiantest"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestRoundTripHedgeWins(t *testing.T) {
+	var calls int32
+	canceled := make(chan struct{})
+
+	rt := martiantest.NewTransport()
+	rt.Func(func(req *http.Request) (*http.Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// The original request hangs until it is canceled.
+			<-req.Context().Done()
+			close(canceled)
+			return nil, req.Context().Err()
+		}
+
+		res := proxyutil.NewResponse(200, strings.NewReader("hedged"), req)
+		return res, nil
+	})
+
+	tr := NewTransport(rt)
+	tr.SetHost("example.com", 10*time.Millisecond)
+
+	req, err := http.NewRequest("GET", "http://example.com:80/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip(): got %v, want no error", err)
+	}
+	res.Body.Close()
+
+	if got, want := res.StatusCode, 200; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if res.Request != req {
+		t.Error("res.Request: got hedged request, want original request")
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("original request: got not canceled, want canceled")
+	}
+
+	if got, want := tr.Stats("example.com"), (Stats{Requests: 1, Hedged: 1, HedgeWins: 1}); got != want {
+		t.Errorf("tr.Stats(): got %+v, want %+v", got, want)
+	}
+}
+
+func TestRoundTripNoHedge(t *testing.T) {
+	var calls int32
+
+	rt := martiantest.NewTransport()
+	rt.Func(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return proxyutil.NewResponse(200, nil, req), nil
+	})
+
+	tr := NewTransport(rt)
+	tr.SetHost("example.com", time.Hour)
+
+	tt := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{"GET", "http://example.com/", ""},
+		{"GET", "http://other.example.com/", ""},
+		{"POST", "http://example.com/", "body"},
+	}
+
+	for _, tc := range tt {
+		var req *http.Request
+		var err error
+		if tc.body != "" {
+			req, err = http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		} else {
+			req, err = http.NewRequest(tc.method, tc.url, nil)
+		}
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s %s: RoundTrip(): got %v, want no error", tc.method, tc.url, err)
+		}
+		res.Body.Close()
+	}
+
+	if got, want := atomic.LoadInt32(&calls), int32(len(tt)); got != want {
+		t.Errorf("calls: got %d, want %d", got, want)
+	}
+	if got, want := tr.Stats("example.com"), (Stats{Requests: 1}); got != want {
+		t.Errorf("tr.Stats(): got %+v, want %+v", got, want)
+	}
+}
+
+func TestRoundTripErrors(t *testing.T) {
+	rterr := errors.New("round trip error")
+
+	rt := martiantest.NewTransport()
+	rt.Func(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, rterr
+	})
+
+	tr := NewTransport(rt)
+	tr.SetHost("example.com", 10*time.Millisecond)
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	if _, err := tr.RoundTrip(req); err != rterr {
+		t.Errorf("RoundTrip(): got %v, want %v", err, rterr)
+	}
+	if got, want := tr.Stats("example.com"), (Stats{Requests: 1, Hedged: 1}); got != want {
+		t.Errorf("tr.Stats(): got %+v, want %+v", got, want)
+	}
+
+	tr.SetHost("example.com", 0)
+	if got, want := tr.Stats("example.com"), (Stats{Requests: 1, Hedged: 1}); got != want {
+		t.Errorf("tr.Stats(): got %+v, want %+v after disabling", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package hsts provides a modifier that strips or injects the
+// Strict-Transport-Security header of responses, for testing HTTPS downgrades
+// and HSTS handling of clients.
+package hsts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/martianurl"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("hsts.Modifier", modifierFromJSON)
+}
+
+// Modifier is a response modifier that strips the Strict-Transport-Security
+// header of responses, so that clients can be downgraded to HTTP in the lab,
+// or injects it, so that clients can be tested for HSTS support. Browsers
+// honor the header on HTTPS responses only.
+type Modifier struct {
+	strip bool
+	value string
+	hosts []string
+}
+
+type modifierJSON struct {
+	Mode              string               `json:"mode"`
+	MaxAgeSeconds     int64                `json:"maxAgeSeconds"`
+	IncludeSubDomains bool                 `json:"includeSubDomains"`
+	Preload           bool                 `json:"preload"`
+	Hosts             []string             `json:"hosts"`
+	Scope             []parse.ModifierType `json:"scope"`
+}
+
+// NewStripModifier returns a Modifier that removes the
+// Strict-Transport-Security header.
+func NewStripModifier() *Modifier {
+	return &Modifier{strip: true}
+}
+
+// NewInjectModifier returns a Modifier that sets the Strict-Transport-Security
+// header with maxAge and the includeSubDomains and preload directives,
+// replacing the header set by the origin.
+func NewInjectModifier(maxAge time.Duration, includeSubDomains, preload bool) *Modifier {
+	v := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if includeSubDomains {
+		v += "; includeSubDomains"
+	}
+	if preload {
+		v += "; preload"
+	}
+
+	return &Modifier{value: v}
+}
+
+// SetHosts restricts the modifier to responses to requests for hosts, which
+// may contain wildcards, such as "*.example.com", see martianurl.MatchHost.
+func (m *Modifier) SetHosts(hosts ...string) {
+	m.hosts = hosts
+}
+
+// ModifyResponse strips or injects the Strict-Transport-Security header of
+// res.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if !m.matches(res.Request) {
+		return nil
+	}
+
+	if m.strip {
+		if res.Header.Get("Strict-Transport-Security") != "" && res.Request != nil {
+			log.Debugf("hsts.Modifier.ModifyResponse: %s: stripping Strict-Transport-Security", res.Request.URL)
+		}
+		res.Header.Del("Strict-Transport-Security")
+		return nil
+	}
+
+	res.Header.Set("Strict-Transport-Security", m.value)
+
+	return nil
+}
+
+func (m *Modifier) matches(req *http.Request) bool {
+	if len(m.hosts) == 0 {
+		return true
+	}
+	if req == nil {
+		return false
+	}
+
+	host := req.URL.Hostname()
+	for _, h := range m.hosts {
+		if martianurl.MatchHost(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// modifierFromJSON builds an hsts.Modifier from JSON. "mode" is strip or
+// inject. In inject mode, "maxAgeSeconds", "includeSubDomains" and "preload"
+// set the directives of the header. "hosts" restricts the modifier to some
+// hosts, which may contain wildcards.
+//
+// Example JSON:
+//
+//	{
+//	  "hsts.Modifier": {
+//	    "scope": ["response"],
+//	    "mode": "inject",
+//	    "maxAgeSeconds": 31536000,
+//	    "includeSubDomains": true,
+//	    "hosts": ["*.example.com"]
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	var mod *Modifier
+	switch msg.Mode {
+	case "strip":
+		mod = NewStripModifier()
+	case "inject":
+		if msg.MaxAgeSeconds < 0 {
+			return nil, errors.New("hsts: maxAgeSeconds must not be negative")
+		}
+		mod = NewInjectModifier(time.Duration(msg.MaxAgeSeconds)*time.Second, msg.IncludeSubDomains, msg.Preload)
+	default:
+		return nil, fmt.Errorf("hsts: unknown mode %q", msg.Mode)
+	}
+	mod.SetHosts(msg.Hosts...)
+
+	return parse.NewResult(mod, msg.Scope)
+}
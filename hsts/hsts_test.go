@@ -0,0 +1,107 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package hsts
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestStripModifier(t *testing.T) {
+	mod := NewStripModifier()
+	mod.SetHosts("*.example.com")
+
+	tt := []struct {
+		url, want string
+	}{
+		{"https://www.example.com/", ""},
+		{"https://www.example.com:8443/", ""},
+		{"https://other.test/", "max-age=60"},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		res := proxyutil.NewResponse(200, nil, req)
+		res.Header.Set("Strict-Transport-Security", "max-age=60")
+
+		if err := mod.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+		if got := res.Header.Get("Strict-Transport-Security"); got != tc.want {
+			t.Errorf("%d. Strict-Transport-Security: got %q, want %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestInjectModifier(t *testing.T) {
+	tt := []struct {
+		mod  *Modifier
+		want string
+	}{
+		{NewInjectModifier(time.Hour, false, false), "max-age=3600"},
+		{NewInjectModifier(365*24*time.Hour, true, true), "max-age=31536000; includeSubDomains; preload"},
+		{NewInjectModifier(0, false, false), "max-age=0"},
+	}
+
+	req, err := http.NewRequest("GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	for i, tc := range tt {
+		res := proxyutil.NewResponse(200, nil, req)
+		res.Header.Set("Strict-Transport-Security", "max-age=60")
+
+		if err := tc.mod.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+		if got := res.Header.Get("Strict-Transport-Security"); got != tc.want {
+			t.Errorf("%d. Strict-Transport-Security: got %q, want %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"hsts.Modifier": {
+			"scope": ["response"],
+			"mode": "inject",
+			"maxAgeSeconds": 600,
+			"includeSubDomains": true,
+			"hosts": ["example.com"]
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := r.ResponseModifier().ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.Header.Get("Strict-Transport-Security"), "max-age=600; includeSubDomains"; got != want {
+		t.Errorf("Strict-Transport-Security: got %q, want %q", got, want)
+	}
+
+	for _, msg := range []string{
+		`{"hsts.Modifier": {"scope": ["response"], "mode": "remove"}}`,
+		`{"hsts.Modifier": {"scope": ["response"], "mode": "inject", "maxAgeSeconds": -1}}`,
+	} {
+		if _, err := parse.FromJSON([]byte(msg)); err == nil {
+			t.Errorf("parse.FromJSON(%s): got nil, want error", msg)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package htmlrewrite provides a modifier that rewrites HTML responses,
+// selecting elements with CSS selectors.
+package htmlrewrite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+	"golang.org/x/net/html"
+)
+
+func init() {
+	parse.Register("htmlrewrite.Modifier", modifierFromJSON)
+}
+
+// DefaultMaxBodySize is the default size above which the modifier leaves
+// bodies unchanged.
+const DefaultMaxBodySize = 10 << 20
+
+// Action is the action of a rule on the elements it selects.
+type Action string
+
+const (
+	// Remove removes the elements.
+	Remove Action = "remove"
+	// Replace replaces the elements with HTML.
+	Replace Action = "replace"
+	// SetAttribute sets the attribute Name of the elements to Value.
+	SetAttribute Action = "setAttribute"
+	// RemoveAttribute removes the attribute Name of the elements.
+	RemoveAttribute Action = "removeAttribute"
+	// Append inserts HTML as the last children of the elements.
+	Append Action = "append"
+	// Prepend inserts HTML as the first children of the elements.
+	Prepend Action = "prepend"
+	// Before inserts HTML before the elements.
+	Before Action = "before"
+	// After inserts HTML after the elements.
+	After Action = "after"
+)
+
+// Rule applies an action to the elements matched by a CSS selector, see
+// Selector for the supported selectors.
+type Rule struct {
+	Selector string `json:"selector"`
+	Action   Action `json:"action"`
+	// Name and Value are the attribute name and value of SetAttribute and
+	// RemoveAttribute.
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// HTML is the fragment inserted by Replace, Append, Prepend, Before and
+	// After.
+	HTML string `json:"html"`
+}
+
+type rule struct {
+	Rule
+	sel *Selector
+}
+
+// Modifier rewrites text/html responses. Bodies that are encoded with an
+// encoding other than gzip, or that are larger than the maximum body size,
+// are left unchanged. Rewritten bodies are sent unencoded, with their
+// Content-Length updated.
+//
+// Bodies are parsed as HTML documents, so the rewritten documents are
+// normalized: missing html, head and body elements are added and markup is
+// serialized again.
+type Modifier struct {
+	rules   []rule
+	maxSize int64
+}
+
+type modifierJSON struct {
+	Rules        []Rule               `json:"rules"`
+	MaxBodyBytes int64                `json:"maxBodyBytes"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a modifier without rules.
+func NewModifier() *Modifier {
+	return &Modifier{
+		maxSize: DefaultMaxBodySize,
+	}
+}
+
+// AddRule adds a rule. Rules are applied in the order they were added, each
+// to the document rewritten by the previous ones.
+func (m *Modifier) AddRule(r Rule) error {
+	sel, err := ParseSelector(r.Selector)
+	if err != nil {
+		return err
+	}
+
+	switch r.Action {
+	case Remove, Replace, Append, Prepend, Before, After:
+	case SetAttribute, RemoveAttribute:
+		if r.Name == "" {
+			return fmt.Errorf("htmlrewrite: %s requires an attribute name", r.Action)
+		}
+	default:
+		return fmt.Errorf("htmlrewrite: unknown action %q", r.Action)
+	}
+
+	m.rules = append(m.rules, rule{Rule: r, sel: sel})
+	return nil
+}
+
+// SetMaxBodySize sets the size above which bodies are left unchanged. It
+// defaults to DefaultMaxBodySize.
+func (m *Modifier) SetMaxBodySize(size int64) {
+	m.maxSize = size
+}
+
+// ModifyResponse rewrites the body of res if it is HTML.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if res.Body == nil || res.Body == http.NoBody || res.StatusCode == http.StatusPartialContent {
+		return nil
+	}
+	if mt, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err != nil || mt != "text/html" {
+		return nil
+	}
+
+	ce := strings.ToLower(res.Header.Get("Content-Encoding"))
+	if ce != "" && ce != "identity" && ce != "gzip" {
+		log.Debugf("htmlrewrite: not rewriting body with Content-Encoding %q", ce)
+		return nil
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(res.Body, m.maxSize+1))
+	if err != nil {
+		return err
+	}
+	// restore puts back the body as read so far, followed by the rest.
+	restore := func() {
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(raw), res.Body), res.Body}
+	}
+	if int64(len(raw)) > m.maxSize {
+		log.Debugf("htmlrewrite: not rewriting body larger than %d bytes", m.maxSize)
+		restore()
+		return nil
+	}
+
+	data := raw
+	if ce == "gzip" {
+		gr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			restore()
+			return err
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(gr, m.maxSize+1))
+		if err != nil {
+			restore()
+			return err
+		}
+		if int64(len(data)) > m.maxSize {
+			log.Debugf("htmlrewrite: not rewriting body larger than %d bytes once decoded", m.maxSize)
+			restore()
+			return nil
+		}
+	}
+
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		restore()
+		return err
+	}
+	for _, r := range m.rules {
+		if err := r.apply(doc); err != nil {
+			restore()
+			return err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := html.Render(buf, doc); err != nil {
+		restore()
+		return err
+	}
+
+	res.Body.Close()
+	res.Header.Del("Content-Encoding")
+	res.Body = ioutil.NopCloser(buf)
+	res.ContentLength = int64(buf.Len())
+
+	return nil
+}
+
+// apply applies the rule to the elements of doc that it selects.
+func (r rule) apply(doc *html.Node) error {
+	var nodes []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if r.sel.Match(n) {
+			nodes = append(nodes, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	for _, n := range nodes {
+		if err := r.applyTo(n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r rule) applyTo(n *html.Node) error {
+	switch r.Action {
+	case Remove:
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	case SetAttribute:
+		for i, a := range n.Attr {
+			if a.Namespace == "" && a.Key == r.Name {
+				n.Attr[i].Val = r.Value
+				return nil
+			}
+		}
+		n.Attr = append(n.Attr, html.Attribute{Key: r.Name, Val: r.Value})
+	case RemoveAttribute:
+		attrs := n.Attr[:0]
+		for _, a := range n.Attr {
+			if a.Namespace != "" || a.Key != r.Name {
+				attrs = append(attrs, a)
+			}
+		}
+		n.Attr = attrs
+	case Append, Prepend:
+		frag, err := html.ParseFragment(strings.NewReader(r.HTML), n)
+		if err != nil {
+			return err
+		}
+		first := n.FirstChild
+		for _, c := range frag {
+			if r.Action == Append || first == nil {
+				n.AppendChild(c)
+			} else {
+				n.InsertBefore(c, first)
+			}
+		}
+	case Replace, Before, After:
+		if n.Parent == nil || n.Parent.Type != html.ElementNode {
+			// The root element has no siblings.
+			return nil
+		}
+		frag, err := html.ParseFragment(strings.NewReader(r.HTML), n.Parent)
+		if err != nil {
+			return err
+		}
+		next := n
+		if r.Action == After {
+			next = n.NextSibling
+		}
+		for _, c := range frag {
+			n.Parent.InsertBefore(c, next)
+		}
+		if r.Action == Replace {
+			n.Parent.RemoveChild(n)
+		}
+	}
+
+	return nil
+}
+
+// modifierFromJSON builds an htmlrewrite.Modifier from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "htmlrewrite.Modifier": {
+//	    "scope": ["response"],
+//	    "rules": [
+//	      {
+//	        "selector": "head",
+//	        "action": "append",
+//	        "html": "<script src=\"/instrument.js\"></script>"
+//	      },
+//	      { "selector": "div.ad, iframe[src*=ads]", "action": "remove" },
+//	      {
+//	        "selector": "a[href^=http]",
+//	        "action": "setAttribute",
+//	        "name": "rel",
+//	        "value": "noopener"
+//	      }
+//	    ],
+//	    "maxBodyBytes": 1048576
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewModifier()
+	for _, r := range msg.Rules {
+		if err := mod.AddRule(r); err != nil {
+			return nil, err
+		}
+	}
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package htmlrewrite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+const page = `<html><head><title>T</title></head><body><div class="ad">buy</div><p id="p">hi <a href="http://example.com">x</a></p></body></html>`
+
+func TestModifier(t *testing.T) {
+	tt := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{Selector: "head", Action: Append, HTML: `<script src="/i.js"></script>`}, `<html><head><title>T</title><script src="/i.js"></script></head><body><div class="ad">buy</div><p id="p">hi <a href="http://example.com">x</a></p></body></html>`},
+		{Rule{Selector: "body", Action: Prepend, HTML: `<b>banner</b>`}, `<html><head><title>T</title></head><body><b>banner</b><div class="ad">buy</div><p id="p">hi <a href="http://example.com">x</a></p></body></html>`},
+		{Rule{Selector: ".ad", Action: Remove}, `<html><head><title>T</title></head><body><p id="p">hi <a href="http://example.com">x</a></p></body></html>`},
+		{Rule{Selector: ".ad", Action: Replace, HTML: `<i>no ad</i>`}, `<html><head><title>T</title></head><body><i>no ad</i><p id="p">hi <a href="http://example.com">x</a></p></body></html>`},
+		{Rule{Selector: "#p", Action: Before, HTML: `<hr/>`}, `<html><head><title>T</title></head><body><div class="ad">buy</div><hr/><p id="p">hi <a href="http://example.com">x</a></p></body></html>`},
+		{Rule{Selector: "#p", Action: After, HTML: `<hr/>`}, `<html><head><title>T</title></head><body><div class="ad">buy</div><p id="p">hi <a href="http://example.com">x</a></p><hr/></body></html>`},
+		{Rule{Selector: "a[href^=http]", Action: SetAttribute, Name: "href", Value: "https://example.com"}, `<html><head><title>T</title></head><body><div class="ad">buy</div><p id="p">hi <a href="https://example.com">x</a></p></body></html>`},
+		{Rule{Selector: "a", Action: SetAttribute, Name: "rel", Value: "noopener"}, `<html><head><title>T</title></head><body><div class="ad">buy</div><p id="p">hi <a href="http://example.com" rel="noopener">x</a></p></body></html>`},
+		{Rule{Selector: "p", Action: RemoveAttribute, Name: "id"}, `<html><head><title>T</title></head><body><div class="ad">buy</div><p>hi <a href="http://example.com">x</a></p></body></html>`},
+	}
+
+	for i, tc := range tt {
+		m := NewModifier()
+		if err := m.AddRule(tc.rule); err != nil {
+			t.Fatalf("%d. AddRule(): got %v, want no error", i, err)
+		}
+
+		res := proxyutil.NewResponse(200, strings.NewReader(page), nil)
+		res.Header.Set("Content-Type", "text/html; charset=utf-8")
+		if err := m.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		got, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. res.Body:\ngot  %s\nwant %s", i, got, tc.want)
+		}
+		if got, want := res.ContentLength, int64(len(tc.want)); got != want {
+			t.Errorf("%d. res.ContentLength: got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestModifierGuards(t *testing.T) {
+	m := NewModifier()
+	if err := m.AddRule(Rule{Selector: ".ad", Action: Remove}); err != nil {
+		t.Fatalf("AddRule(): got %v, want no error", err)
+	}
+	m.SetMaxBodySize(int64(len(page)))
+
+	gz := func(s string) string {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		gw.Write([]byte(s))
+		gw.Close()
+		return buf.String()
+	}
+	rewritten := strings.Replace(page, `<div class="ad">buy</div>`, "", 1)
+
+	tt := []struct {
+		status                      int
+		contentType, encoding, body string
+		want                        string
+	}{
+		{200, "text/html", "gzip", gz(page), rewritten},
+		{200, "text/plain", "", page, page},
+		{200, "text/html", "br", page, page},
+		{206, "text/html", "", page, page},
+		{200, "text/html", "", page + " ", page + " "},
+	}
+
+	for i, tc := range tt {
+		res := proxyutil.NewResponse(tc.status, strings.NewReader(tc.body), nil)
+		res.Header.Set("Content-Type", tc.contentType)
+		res.Header.Set("Content-Encoding", tc.encoding)
+		if err := m.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		got, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. res.Body: got %q, want %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestAddRuleErrors(t *testing.T) {
+	for i, r := range []Rule{
+		{Selector: "[", Action: Remove},
+		{Selector: "a", Action: "hide"},
+		{Selector: "a", Action: SetAttribute},
+	} {
+		if err := NewModifier().AddRule(r); err == nil {
+			t.Errorf("%d. AddRule(%v): got nil, want error", i, r)
+		}
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+    "htmlrewrite.Modifier": {
+      "scope": ["response"],
+      "rules": [
+        {
+          "selector": "head",
+          "action": "append",
+          "html": "<script src=\"/instrument.js\"></script>"
+        },
+        { "selector": "div.ad", "action": "remove" }
+      ]
+    }
+  }`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("resmod: got nil, want not nil")
+	}
+
+	res := proxyutil.NewResponse(200, strings.NewReader(page), nil)
+	res.Header.Set("Content-Type", "text/html")
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := `<html><head><title>T</title><script src="/instrument.js"></script></head><body><p id="p">hi <a href="http://example.com">x</a></p></body></html>`; string(got) != want {
+		t.Errorf("res.Body: got %s, want %s", got, want)
+	}
+}
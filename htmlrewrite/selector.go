@@ -0,0 +1,286 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package htmlrewrite
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Selector is a parsed CSS selector. Selectors support type ("div") and
+// universal ("*") selectors, IDs ("#main"), classes (".item"), attributes
+// ("[href]", "[rel=stylesheet]", "[class~=a]", "[src^=http]", "[src$=.js]",
+// "[src*=cdn]"), descendant ("nav a") and child ("ul > li") combinators, and
+// groups ("h1, h2").
+type Selector struct {
+	alts []complexSelector
+}
+
+// complexSelector is a sequence of compound selectors joined by combinators.
+// combinators[i] joins parts[i] and parts[i+1].
+type complexSelector struct {
+	parts       []compoundSelector
+	combinators []byte
+}
+
+type compoundSelector struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+type attrSelector struct {
+	name, op, val string
+}
+
+// ParseSelector parses a CSS selector.
+func ParseSelector(s string) (*Selector, error) {
+	sel := &Selector{}
+	for _, alt := range strings.Split(s, ",") {
+		cs, err := parseComplex(alt)
+		if err != nil {
+			return nil, fmt.Errorf("htmlrewrite: invalid selector %q: %v", s, err)
+		}
+		sel.alts = append(sel.alts, cs)
+	}
+
+	return sel, nil
+}
+
+func parseComplex(s string) (complexSelector, error) {
+	var cs complexSelector
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return cs, fmt.Errorf("empty selector")
+	}
+
+	for {
+		part, rest, err := parseCompound(s)
+		if err != nil {
+			return cs, err
+		}
+		cs.parts = append(cs.parts, part)
+
+		trimmed := strings.TrimLeft(rest, " \t\n")
+		if trimmed == "" {
+			return cs, nil
+		}
+
+		switch {
+		case trimmed[0] == '>':
+			cs.combinators = append(cs.combinators, '>')
+			s = strings.TrimLeft(trimmed[1:], " \t\n")
+		case len(trimmed) < len(rest):
+			cs.combinators = append(cs.combinators, ' ')
+			s = trimmed
+		default:
+			return cs, fmt.Errorf("unexpected %q", trimmed)
+		}
+	}
+}
+
+func isNameByte(c byte) bool {
+	return c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func parseName(s string) (string, string) {
+	i := 0
+	for i < len(s) && isNameByte(s[i]) {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+func parseCompound(s string) (compoundSelector, string, error) {
+	var c compoundSelector
+
+	var parsed bool
+	if strings.HasPrefix(s, "*") {
+		s = s[1:]
+		parsed = true
+	} else {
+		var tag string
+		tag, s = parseName(s)
+		c.tag = strings.ToLower(tag)
+		parsed = tag != ""
+	}
+
+loop:
+	for s != "" {
+		var name string
+		switch s[0] {
+		case '#':
+			name, s = parseName(s[1:])
+			if name == "" {
+				return c, "", fmt.Errorf("empty ID")
+			}
+			c.id = name
+		case '.':
+			name, s = parseName(s[1:])
+			if name == "" {
+				return c, "", fmt.Errorf("empty class")
+			}
+			c.classes = append(c.classes, name)
+		case '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return c, "", fmt.Errorf("missing ]")
+			}
+			a, err := parseAttr(s[1:end])
+			if err != nil {
+				return c, "", err
+			}
+			c.attrs = append(c.attrs, a)
+			s = s[end+1:]
+		default:
+			break loop
+		}
+		parsed = true
+	}
+
+	if !parsed {
+		return c, "", fmt.Errorf("unexpected %q", s)
+	}
+
+	return c, s, nil
+}
+
+func parseAttr(s string) (attrSelector, error) {
+	var a attrSelector
+
+	name, rest := parseName(strings.TrimSpace(s))
+	if name == "" {
+		return a, fmt.Errorf("empty attribute name")
+	}
+	a.name = strings.ToLower(name)
+
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return a, nil
+	}
+
+	for _, op := range []string{"~=", "^=", "$=", "*=", "="} {
+		if strings.HasPrefix(rest, op) {
+			a.op = op
+			a.val = strings.TrimSpace(rest[len(op):])
+			if len(a.val) >= 2 && (a.val[0] == '"' || a.val[0] == '\'') && a.val[len(a.val)-1] == a.val[0] {
+				a.val = a.val[1 : len(a.val)-1]
+			}
+			return a, nil
+		}
+	}
+
+	return a, fmt.Errorf("invalid attribute selector %q", s)
+}
+
+// Match returns whether n is an element matched by the selector.
+func (sel *Selector) Match(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+
+	for _, cs := range sel.alts {
+		if cs.match(n, len(cs.parts)-1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match returns whether n matches the parts of the selector up to i.
+func (cs complexSelector) match(n *html.Node, i int) bool {
+	if !cs.parts[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+
+	if cs.combinators[i-1] == '>' {
+		p := n.Parent
+		return p != nil && p.Type == html.ElementNode && cs.match(p, i-1)
+	}
+
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if cs.match(p, i-1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c compoundSelector) match(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if c.tag != "" && c.tag != n.Data {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	for _, class := range c.classes {
+		if !containsWord(attr(n, "class"), class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.match(n) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (a attrSelector) match(n *html.Node) bool {
+	for _, na := range n.Attr {
+		if na.Namespace != "" || na.Key != a.name {
+			continue
+		}
+
+		switch a.op {
+		case "":
+			return true
+		case "=":
+			return na.Val == a.val
+		case "~=":
+			return containsWord(na.Val, a.val)
+		case "^=":
+			return a.val != "" && strings.HasPrefix(na.Val, a.val)
+		case "$=":
+			return a.val != "" && strings.HasSuffix(na.Val, a.val)
+		case "*=":
+			return a.val != "" && strings.Contains(na.Val, a.val)
+		}
+	}
+
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func containsWord(s, word string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == word {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package htmlrewrite
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestSelector(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+<nav id="top"><ul><li><a href="https://example.com/a" class="link ext">A</a></li></ul></nav>
+<div class="content"><p>text <a href="/b" data-x='1'>B</a></p></div>
+<script src="https://cdn.example.com/app.js"></script>
+</body></html>`))
+	if err != nil {
+		t.Fatalf("html.Parse(): got %v, want no error", err)
+	}
+
+	tt := []struct {
+		sel  string
+		want []string
+	}{
+		{"a", []string{"A", "B"}},
+		{"A", []string{"A", "B"}},
+		{"nav a", []string{"A"}},
+		{"#top > ul > li > a", []string{"A"}},
+		{"#top > a", nil},
+		{"div > a", nil},
+		{".content a", []string{"B"}},
+		{"a.link.ext", []string{"A"}},
+		{"a.link.other", nil},
+		{"[data-x]", []string{"B"}},
+		{"a[href^=https]", []string{"A"}},
+		{"a[href$='/b']", []string{"B"}},
+		{"a[href*=example]", []string{"A"}},
+		{`a[class~="ext"]`, []string{"A"}},
+		{"a[class=link]", nil},
+		{"div *[href]", []string{"B"}},
+		{"nav a, p > a", []string{"A", "B"}},
+		{"script[src*=cdn]", []string{"script"}},
+	}
+
+	for i, tc := range tt {
+		sel, err := ParseSelector(tc.sel)
+		if err != nil {
+			t.Fatalf("%d. ParseSelector(%q): got %v, want no error", i, tc.sel, err)
+		}
+
+		var got []string
+		var walk func(n *html.Node)
+		walk = func(n *html.Node) {
+			if sel.Match(n) {
+				if n.FirstChild != nil {
+					got = append(got, n.FirstChild.Data)
+				} else {
+					got = append(got, n.Data)
+				}
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+		walk(doc)
+
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%d. %q matched: got %v, want %v", i, tc.sel, got, tc.want)
+		}
+	}
+
+	for i, s := range []string{"", "a,", "a >", "#", ".", "[href", "[=x]", "[href!=x]", "a $b"} {
+		if _, err := ParseSelector(s); err == nil {
+			t.Errorf("%d. ParseSelector(%q): got nil, want error", i, s)
+		}
+	}
+}
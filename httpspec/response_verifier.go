@@ -0,0 +1,177 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package httpspec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+	"golang.org/x/net/http/httpguts"
+)
+
+func init() {
+	parse.Register("httpspec.ResponseVerifier", responseVerifierFromJSON)
+}
+
+const violationErrFormat = "response(%s) httpspec verify failure: %v"
+
+// ResponseVerifier checks responses of origin servers for violations of RFC
+// 9110 and RFC 9112 that survive parsing, so that the proxy can be used to
+// qualify server implementations. Violations are logged and reported as
+// response verification failures; responses are not modified.
+type ResponseVerifier struct {
+	mu     sync.RWMutex
+	reserr *martian.MultiError
+}
+
+type responseVerifierJSON struct {
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// NewResponseVerifier returns a new response verifier.
+func NewResponseVerifier() *ResponseVerifier {
+	return &ResponseVerifier{
+		reserr: martian.NewMultiError(),
+	}
+}
+
+// ModifyResponse validates res and records every violation.
+func (v *ResponseVerifier) ModifyResponse(res *http.Response) error {
+	errs := ValidateResponse(res)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	var u string
+	if res.Request != nil {
+		u = res.Request.URL.String()
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, err := range errs {
+		log.Infof("httpspec: %s: %v", u, err)
+		v.reserr.Add(fmt.Errorf(violationErrFormat, u, err))
+	}
+
+	return nil
+}
+
+// VerifyResponses returns an error if any response violated the
+// specification. If an error is returned it will be of type
+// *martian.MultiError.
+func (v *ResponseVerifier) VerifyResponses() error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.reserr.Empty() {
+		return nil
+	}
+
+	return v.reserr
+}
+
+// ResetResponseVerifications clears all failed response verifications.
+func (v *ResponseVerifier) ResetResponseVerifications() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.reserr = martian.NewMultiError()
+}
+
+// ValidateResponse returns the specification violations of res. Violations
+// that prevent a response from being parsed, such as malformed status lines,
+// are reported by the round tripper instead.
+func ValidateResponse(res *http.Response) []error {
+	var errs []error
+
+	if res.StatusCode < 100 || res.StatusCode > 599 {
+		errs = append(errs, fmt.Errorf("status code %d out of range 100-599", res.StatusCode))
+	}
+
+	for name, values := range res.Header {
+		if !httpguts.ValidHeaderFieldName(name) {
+			errs = append(errs, fmt.Errorf("invalid header field name %q", name))
+		}
+		for _, value := range values {
+			if !httpguts.ValidHeaderFieldValue(value) {
+				errs = append(errs, fmt.Errorf("invalid value %q for header %s", value, name))
+			}
+		}
+	}
+
+	_, hasCL := res.Header["Content-Length"]
+	hasTE := len(res.TransferEncoding) > 0 || res.Header.Get("Transfer-Encoding") != ""
+
+	// RFC 9110, section 8.6 and RFC 9112, section 6.1.
+	if res.StatusCode/100 == 1 || res.StatusCode == 204 {
+		if hasCL {
+			errs = append(errs, fmt.Errorf("Content-Length in %d response", res.StatusCode))
+		}
+		if hasTE {
+			errs = append(errs, fmt.Errorf("Transfer-Encoding in %d response", res.StatusCode))
+		}
+	}
+
+	// RFC 9110, section 6.4.1.
+	if (res.StatusCode == 204 || res.StatusCode == 304) && res.ContentLength > 0 {
+		errs = append(errs, fmt.Errorf("body of %d bytes in %d response", res.ContentLength, res.StatusCode))
+	}
+
+	// RFC 9112, section 6.3.
+	if hasCL && hasTE {
+		errs = append(errs, errors.New("both Content-Length and Transfer-Encoding in response"))
+	}
+	if cls := res.Header.Values("Content-Length"); len(cls) > 1 {
+		for _, cl := range cls[1:] {
+			if strings.TrimSpace(cl) != strings.TrimSpace(cls[0]) {
+				errs = append(errs, fmt.Errorf("conflicting Content-Length values %q", cls))
+				break
+			}
+		}
+	}
+
+	// Header fields that a response status requires, RFC 9110, section 15.
+	required := map[int]string{
+		401: "WWW-Authenticate",
+		405: "Allow",
+		407: "Proxy-Authenticate",
+	}
+	if name, ok := required[res.StatusCode]; ok {
+		if _, ok := res.Header[name]; !ok {
+			errs = append(errs, fmt.Errorf("missing %s header in %d response", name, res.StatusCode))
+		}
+	}
+	if res.StatusCode == 206 && res.Header.Get("Content-Range") == "" &&
+		!strings.HasPrefix(res.Header.Get("Content-Type"), "multipart/byteranges") {
+		errs = append(errs, errors.New("missing Content-Range header in 206 response"))
+	}
+
+	return errs
+}
+
+// responseVerifierFromJSON builds a httpspec.ResponseVerifier from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "httpspec.ResponseVerifier": {
+//	    "scope": ["response"]
+//	  }
+//	}
+func responseVerifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &responseVerifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return parse.NewResult(NewResponseVerifier(), msg.Scope)
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package httpspec
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+	"github.com/google/martian/v3/verify"
+)
+
+func TestValidateResponse(t *testing.T) {
+	tt := []struct {
+		name   string
+		status int
+		header http.Header
+		cl     int64
+		want   string
+	}{
+		{"valid", 200, http.Header{"Content-Length": {"10"}}, 10, ""},
+		{"status", 600, nil, 0, "status code 600"},
+		{"header name", 200, http.Header{"Bad Name": {"v"}}, 0, "invalid header field name"},
+		{"header value", 200, http.Header{"X-Test": {"a\nb"}}, 0, "invalid value"},
+		{"204 content length", 204, http.Header{"Content-Length": {"0"}}, 0, "Content-Length in 204 response"},
+		{"204 body", 204, nil, 5, "body of 5 bytes in 204 response"},
+		{"304 body", 304, nil, 5, "body of 5 bytes in 304 response"},
+		{"304 content length", 304, http.Header{"Content-Length": {"5"}}, 0, ""},
+		{"framing", 200, http.Header{"Content-Length": {"5"}, "Transfer-Encoding": {"chunked"}}, -1, "both Content-Length and Transfer-Encoding"},
+		{"conflicting length", 200, http.Header{"Content-Length": {"5", "6"}}, 5, "conflicting Content-Length"},
+		{"401", 401, nil, 0, "missing WWW-Authenticate"},
+		{"405", 405, nil, 0, "missing Allow"},
+		{"405 allow", 405, http.Header{"Allow": {"GET"}}, 0, ""},
+		{"206", 206, nil, 0, "missing Content-Range"},
+		{"206 multipart", 206, http.Header{"Content-Type": {"multipart/byteranges; boundary=x"}}, 0, ""},
+	}
+
+	for _, tc := range tt {
+		res := proxyutil.NewResponse(tc.status, nil, nil)
+		res.Header = tc.header
+		if res.Header == nil {
+			res.Header = make(http.Header)
+		}
+		res.ContentLength = tc.cl
+
+		errs := ValidateResponse(res)
+		if tc.want == "" {
+			if len(errs) != 0 {
+				t.Errorf("%s: ValidateResponse(): got %v, want no errors", tc.name, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 {
+			t.Errorf("%s: ValidateResponse(): got %v, want one error", tc.name, errs)
+			continue
+		}
+		if got := errs[0].Error(); !strings.Contains(got, tc.want) {
+			t.Errorf("%s: ValidateResponse(): got %q, want to contain %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestResponseVerifier(t *testing.T) {
+	v := NewResponseVerifier()
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := v.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if err := v.VerifyResponses(); err != nil {
+		t.Fatalf("VerifyResponses(): got %v, want no error", err)
+	}
+
+	res = proxyutil.NewResponse(405, nil, req)
+	if err := v.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	merr, ok := v.VerifyResponses().(*martian.MultiError)
+	if !ok {
+		t.Fatalf("VerifyResponses(): got %T, want *martian.MultiError", v.VerifyResponses())
+	}
+	errs := merr.Errors()
+	if got, want := len(errs), 1; got != want {
+		t.Fatalf("len(merr.Errors()): got %d, want %d", got, want)
+	}
+	if got, want := errs[0].Error(), "response(http://example.com) httpspec verify failure: missing Allow header in 405 response"; got != want {
+		t.Errorf("errs[0].Error(): got %q, want %q", got, want)
+	}
+
+	v.ResetResponseVerifications()
+	if err := v.VerifyResponses(); err != nil {
+		t.Errorf("VerifyResponses(): got %v, want no error", err)
+	}
+}
+
+func TestResponseVerifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"httpspec.ResponseVerifier": {
+			"scope": ["response"]
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("resmod: got nil, want not nil")
+	}
+	if _, ok := resmod.(verify.ResponseVerifier); !ok {
+		t.Error("resmod.(verify.ResponseVerifier): got !ok, want ok")
+	}
+}
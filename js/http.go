@@ -0,0 +1,337 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package js
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/google/martian/v3"
+)
+
+var requestKeys = []string{"method", "url", "scheme", "host", "path", "query", "remoteAddr", "headers", "body"}
+
+// requestObject exposes an HTTP request to a script.
+type requestObject struct {
+	c   *call
+	req *http.Request
+	// readOnly is set for the request of a response.
+	readOnly bool
+}
+
+var _ goja.DynamicObject = (*requestObject)(nil)
+
+// Get returns the properties of the request.
+func (r *requestObject) Get(name string) goja.Value {
+	vm := r.c.vm
+	switch name {
+	case "method":
+		return vm.ToValue(r.req.Method)
+	case "url":
+		return vm.ToValue(r.req.URL.String())
+	case "scheme":
+		return vm.ToValue(r.req.URL.Scheme)
+	case "host":
+		return vm.ToValue(r.req.Host)
+	case "path":
+		return vm.ToValue(r.req.URL.Path)
+	case "query":
+		return vm.ToValue(r.req.URL.RawQuery)
+	case "remoteAddr":
+		return vm.ToValue(r.req.RemoteAddr)
+	case "headers":
+		return r.c.headers(r.req.Header, r.readOnly)
+	case "body":
+		return r.c.readBody(&r.req.Body)
+	}
+
+	return nil
+}
+
+// Set sets the properties of the request.
+func (r *requestObject) Set(name string, v goja.Value) bool {
+	vm := r.c.vm
+	if r.readOnly {
+		panic(vm.NewTypeError("cannot set property %q of the request of a response", name))
+	}
+
+	s, ok := v.Export().(string)
+	if !ok {
+		panic(vm.NewTypeError("cannot set property %q of request to %s, want string", name, typeOf(v)))
+	}
+
+	switch name {
+	case "method":
+		r.req.Method = s
+	case "url":
+		u, err := url.Parse(s)
+		if err != nil {
+			panic(vm.NewGoError(err))
+		}
+		r.req.URL = u
+		r.req.Host = u.Host
+	case "scheme":
+		r.req.URL.Scheme = s
+	case "host":
+		r.req.URL.Host = s
+		r.req.Host = s
+	case "path":
+		r.req.URL.Path = s
+		r.req.URL.RawPath = ""
+	case "query":
+		r.req.URL.RawQuery = s
+	case "body":
+		if r.req.Body != nil {
+			r.req.Body.Close()
+		}
+		r.req.Body = ioutil.NopCloser(strings.NewReader(s))
+		r.req.ContentLength = int64(len(s))
+		r.req.Header.Del("Content-Encoding")
+	default:
+		panic(vm.NewTypeError("cannot set property %q of request", name))
+	}
+
+	return true
+}
+
+func (r *requestObject) Has(name string) bool {
+	return hasKey(requestKeys, name)
+}
+
+func (r *requestObject) Delete(name string) bool {
+	return !r.Has(name)
+}
+
+func (r *requestObject) Keys() []string {
+	return requestKeys
+}
+
+var responseKeys = []string{"status", "headers", "body", "request"}
+
+// responseObject exposes an HTTP response to a script.
+type responseObject struct {
+	c   *call
+	res *http.Response
+}
+
+var _ goja.DynamicObject = (*responseObject)(nil)
+
+// Get returns the properties of the response.
+func (r *responseObject) Get(name string) goja.Value {
+	vm := r.c.vm
+	switch name {
+	case "status":
+		return vm.ToValue(r.res.StatusCode)
+	case "headers":
+		return r.c.headers(r.res.Header, false)
+	case "body":
+		return r.c.readBody(&r.res.Body)
+	case "request":
+		if r.res.Request == nil {
+			return goja.Null()
+		}
+		return vm.NewDynamicObject(&requestObject{c: r.c, req: r.res.Request, readOnly: true})
+	}
+
+	return nil
+}
+
+// Set sets the properties of the response.
+func (r *responseObject) Set(name string, v goja.Value) bool {
+	vm := r.c.vm
+	switch name {
+	case "status":
+		code := math.NaN()
+		if goja.IsNumber(v) {
+			code = v.ToFloat()
+		}
+		if code != math.Trunc(code) || code < 100 || code > 999 {
+			r.c.throwRangeError(fmt.Sprintf("invalid status %s", v))
+		}
+		r.res.StatusCode = int(code)
+		r.res.Status = fmt.Sprintf("%d %s", int(code), http.StatusText(int(code)))
+	case "body":
+		s, ok := v.Export().(string)
+		if !ok {
+			panic(vm.NewTypeError("cannot set property %q of response to %s, want string", name, typeOf(v)))
+		}
+		if r.res.Body != nil {
+			r.res.Body.Close()
+		}
+		r.res.Body = ioutil.NopCloser(strings.NewReader(s))
+		r.res.ContentLength = int64(len(s))
+		r.res.TransferEncoding = nil
+		r.res.Header.Del("Content-Encoding")
+	default:
+		panic(vm.NewTypeError("cannot set property %q of response", name))
+	}
+
+	return true
+}
+
+func (r *responseObject) Has(name string) bool {
+	return hasKey(responseKeys, name)
+}
+
+func (r *responseObject) Delete(name string) bool {
+	return !r.Has(name)
+}
+
+func (r *responseObject) Keys() []string {
+	return responseKeys
+}
+
+func hasKey(keys []string, name string) bool {
+	for _, k := range keys {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the type of v as named by the typeof operator.
+func typeOf(v goja.Value) string {
+	switch {
+	case goja.IsUndefined(v):
+		return "undefined"
+	case goja.IsNull(v):
+		return "null"
+	}
+	if _, ok := goja.AssertFunction(v); ok {
+		return "function"
+	}
+
+	switch v.Export().(type) {
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case int64, float64:
+		return "number"
+	}
+	return "object"
+}
+
+// readBody reads the body as a string of its bytes. The body is replaced so
+// that it can be read again. Reading a body larger than the maximum body
+// size of the call interrupts the script, and leaves the body unchanged.
+func (c *call) readBody(body *io.ReadCloser) goja.Value {
+	if *body == nil || *body == http.NoBody {
+		return c.vm.ToValue("")
+	}
+
+	rc := *body
+	b, err := ioutil.ReadAll(io.LimitReader(rc, c.maxBody+1))
+	*body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), rc), rc}
+	if err != nil {
+		panic(c.vm.NewGoError(fmt.Errorf("reading body: %v", err)))
+	}
+	if int64(len(b)) > c.maxBody {
+		// An interrupt cannot be caught by the script; it stops the script
+		// before its next instruction.
+		c.vm.Interrupt(fmt.Errorf("body exceeds limit of %d bytes", c.maxBody))
+		return goja.Undefined()
+	}
+
+	return c.vm.ToValue(string(b))
+}
+
+// headers returns an object exposing h to a script, with the methods get,
+// getAll, has and keys, and unless readOnly, set, append and delete.
+func (c *call) headers(h http.Header, readOnly bool) goja.Value {
+	vm := c.vm
+	o := vm.NewObject()
+
+	// get returns the values of a header joined with commas, or null.
+	o.Set("get", func(name string) goja.Value {
+		vs := h.Values(name)
+		if len(vs) == 0 {
+			return goja.Null()
+		}
+		return vm.ToValue(strings.Join(vs, ", "))
+	})
+	o.Set("getAll", func(name string) []string {
+		vs := h.Values(name)
+		if vs == nil {
+			vs = []string{}
+		}
+		return vs
+	})
+	o.Set("has", func(name string) bool {
+		_, ok := h[http.CanonicalHeaderKey(name)]
+		return ok
+	})
+	o.Set("keys", func() []string {
+		names := make([]string, 0, len(h))
+		for name := range h {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	})
+	if readOnly {
+		return o
+	}
+
+	o.Set("set", func(name, value string) { h.Set(name, value) })
+	o.Set("append", func(name, value string) { h.Add(name, value) })
+	o.Set("delete", func(name string) { h.Del(name) })
+
+	return o
+}
+
+// contextPrefix prefixes the keys of the values a script stores in the
+// context of a request.
+const contextPrefix = "js."
+
+// context returns an object exposing ctx to a script, with the methods get
+// and set, and while modifying a request, which is the only time the round
+// trip may be skipped, skipRoundTrip.
+func (c *call) context(ctx *martian.Context, request bool) goja.Value {
+	vm := c.vm
+	o := vm.NewObject()
+
+	check := func(name string) {
+		if ctx == nil {
+			panic(vm.NewGoError(fmt.Errorf("ctx.%s: request has no context", name)))
+		}
+	}
+
+	// get returns a value set with ctx.set for the same request, or
+	// undefined. Values are exported to Go by set, as each call runs in its
+	// own runtime.
+	o.Set("get", func(key string) goja.Value {
+		check("get")
+		v, ok := ctx.Get(contextPrefix + key)
+		if !ok {
+			return goja.Undefined()
+		}
+		return vm.ToValue(v)
+	})
+	o.Set("set", func(key string, v goja.Value) {
+		check("set")
+		ctx.Set(contextPrefix+key, v.Export())
+	})
+	if !request {
+		return o
+	}
+
+	o.Set("skipRoundTrip", func() {
+		check("skipRoundTrip")
+		ctx.SkipRoundTrip()
+	})
+
+	return o
+}
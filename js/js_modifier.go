@@ -0,0 +1,354 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package js provides a modifier that runs user-provided JavaScript on
+// requests and responses, for teams whose test tooling is written in
+// JavaScript.
+//
+// Scripts are run by github.com/dop251/goja, which implements ECMAScript 5.1
+// and most of ECMAScript 6 and later. Besides the standard builtins, scripts
+// may log with console.log, info, warn and error.
+//
+// A script defines the function modifyRequest(req, ctx), modifyResponse(res,
+// ctx) or both. Each call runs in a new copy of the script: its top-level
+// statements run again, so that calls do not share state. Values may be
+// passed from modifyRequest to modifyResponse of the same request with
+// ctx.set(key, value) and ctx.get(key).
+//
+// A request has the properties method, url, scheme, host, path, query and
+// body, which may be set to strings, the read-only property remoteAddr and
+// the property headers. A response has the properties status, a number, and
+// body, which may be set, the property headers and the property request, the
+// read-only request of the response. Headers have the methods get(name),
+// getAll(name), has(name), keys(), set(name, value), append(name, value) and
+// delete(name). Bodies are strings of the bytes of the message as sent,
+// without decoding its Content-Encoding; setting a body sets the
+// Content-Length and removes the Content-Encoding. ctx.skipRoundTrip() skips
+// the round trip of a request.
+//
+// Each call has a budget: it fails if it runs longer than its timeout, calls
+// functions nested too deeply or reads a body larger than the maximum body
+// size. Exceeding the budget cannot be caught by the script.
+//
+// For example:
+//
+//	function modifyRequest(req, ctx) {
+//	  if (req.path.startsWith("/api/")) {
+//	    req.headers.set("Authorization", "Bearer test");
+//	  }
+//	  ctx.set("start", req.headers.get("X-Start"));
+//	}
+//
+//	function modifyResponse(res, ctx) {
+//	  if (res.status === 404 && res.request.path === "/health") {
+//	    res.status = 200;
+//	    res.body = JSON.stringify({ ok: true });
+//	  }
+//	}
+package js
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+// DefaultTimeout is the default time a call into a script may take.
+const DefaultTimeout = 100 * time.Millisecond
+
+// DefaultMaxBodySize is the default size of the largest body a script may
+// read.
+const DefaultMaxBodySize = 1 << 20
+
+// maxDepth is the maximum depth of nested function calls.
+const maxDepth = 200
+
+func init() {
+	parse.Register("js.Modifier", modifierFromJSON)
+}
+
+// Modifier runs the functions of a script on requests and responses.
+type Modifier struct {
+	prog    *goja.Program
+	reqfn   bool
+	resfn   bool
+	timeout time.Duration
+	maxBody int64
+}
+
+type modifierJSON struct {
+	Script       string               `json:"script"`
+	File         string               `json:"file"`
+	TimeoutMs    int64                `json:"timeoutMs"`
+	MaxBodyBytes int64                `json:"maxBodyBytes"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier loads the script src and returns a Modifier calling its
+// modifyRequest and modifyResponse functions. It returns an error if the
+// script fails to parse or its top-level statements fail.
+func NewModifier(src string) (*Modifier, error) {
+	ast, err := parser.ParseFile(nil, "script", src, 0)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	prog, err := goja.CompileAST(ast, false)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+
+	m := &Modifier{
+		prog:    prog,
+		timeout: DefaultTimeout,
+		maxBody: DefaultMaxBodySize,
+	}
+
+	c, err := m.run()
+	if err != nil {
+		return nil, err
+	}
+	defer c.stop()
+
+	for name, fn := range map[string]*bool{
+		"modifyRequest":  &m.reqfn,
+		"modifyResponse": &m.resfn,
+	} {
+		v := c.vm.Get(name)
+		if v == nil || goja.IsUndefined(v) {
+			continue
+		}
+		if _, ok := goja.AssertFunction(v); !ok {
+			return nil, fmt.Errorf("js: %s must be a function", name)
+		}
+		*fn = true
+	}
+
+	return m, nil
+}
+
+// SetTimeout sets the time a call into the script may take. A timeout of
+// zero disables the limit.
+func (m *Modifier) SetTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
+// SetMaxBodySize sets the size of the largest body the script may read.
+func (m *Modifier) SetMaxBodySize(size int64) {
+	m.maxBody = size
+}
+
+// call is a call into a script, which runs in its own runtime.
+type call struct {
+	vm      *goja.Runtime
+	maxBody int64
+	timer   *time.Timer
+}
+
+// run runs the script in a new runtime, whose budget starts now. The call
+// must be stopped when done.
+func (m *Modifier) run() (*call, error) {
+	c := &call{
+		vm:      goja.New(),
+		maxBody: m.maxBody,
+	}
+	c.vm.SetMaxCallStackSize(maxDepth)
+	c.vm.Set("console", c.console())
+	if m.timeout > 0 {
+		timeout := m.timeout
+		c.timer = time.AfterFunc(timeout, func() {
+			c.vm.Interrupt(fmt.Errorf("execution exceeded timeout of %v", timeout))
+		})
+	}
+
+	if _, err := c.vm.RunProgram(m.prog); err != nil {
+		c.stop()
+		return nil, scriptError(err)
+	}
+
+	return c, nil
+}
+
+func (c *call) stop() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// call runs the script and calls its function name with the arguments
+// returned by args.
+func (m *Modifier) call(name string, args func(c *call) []goja.Value) error {
+	c, err := m.run()
+	if err != nil {
+		return err
+	}
+	defer c.stop()
+
+	fn, _ := goja.AssertFunction(c.vm.Get(name))
+	_, err = fn(goja.Undefined(), args(c)...)
+	return scriptError(err)
+}
+
+// ModifyRequest calls the modifyRequest function of the script, if any,
+// with req and its context.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	if !m.reqfn {
+		return nil
+	}
+
+	return m.call("modifyRequest", func(c *call) []goja.Value {
+		return []goja.Value{
+			c.vm.NewDynamicObject(&requestObject{c: c, req: req}),
+			c.context(martian.NewContext(req), true),
+		}
+	})
+}
+
+// ModifyResponse calls the modifyResponse function of the script, if any,
+// with res and the context of its request.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if !m.resfn {
+		return nil
+	}
+
+	var ctx *martian.Context
+	if res.Request != nil {
+		ctx = martian.NewContext(res.Request)
+	}
+	return m.call("modifyResponse", func(c *call) []goja.Value {
+		return []goja.Value{
+			c.vm.NewDynamicObject(&responseObject{c: c, res: res}),
+			c.context(ctx, false),
+		}
+	})
+}
+
+// console returns the console object of a script, which writes to the log.
+func (c *call) console() *goja.Object {
+	o := c.vm.NewObject()
+	for name, logf := range map[string]func(string, ...interface{}){
+		"log":   log.Infof,
+		"info":  log.Infof,
+		"warn":  log.Errorf,
+		"error": log.Errorf,
+	} {
+		logf := logf
+		o.Set(name, func(call goja.FunctionCall) goja.Value {
+			msg := make([]string, len(call.Arguments))
+			for i, arg := range call.Arguments {
+				msg[i] = arg.String()
+			}
+			logf("js: %s", strings.Join(msg, " "))
+			return goja.Undefined()
+		})
+	}
+
+	return o
+}
+
+// throwRangeError throws a RangeError with msg in the script.
+func (c *call) throwRangeError(msg string) {
+	err, nerr := c.vm.New(c.vm.Get("RangeError"), c.vm.ToValue(msg))
+	if nerr != nil {
+		panic(nerr)
+	}
+	panic(err)
+}
+
+// scriptError returns err prefixed with the line of the script it occurred
+// on, if known.
+func scriptError(err error) error {
+	var (
+		line  int
+		msg   string
+		stack []goja.StackFrame
+	)
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *goja.InterruptedError:
+		msg, stack = fmt.Sprint(e.Value()), e.Stack()
+	case *goja.StackOverflowError:
+		msg, stack = fmt.Sprintf("call stack exceeded depth of %d", maxDepth), e.Stack()
+	case *goja.Exception:
+		msg, stack = e.Value().String(), e.Stack()
+	case *goja.CompilerSyntaxError:
+		msg = "SyntaxError: " + e.Message
+		if e.File != nil {
+			line = e.File.Position(e.Offset).Line
+		}
+	case parser.ErrorList:
+		line, msg = e[0].Position.Line, "SyntaxError: "+e[0].Message
+	default:
+		return fmt.Errorf("js: %v", err)
+	}
+
+	// The innermost frames may be native functions, which have no position.
+	for _, fr := range stack {
+		if l := fr.Position().Line; l > 0 {
+			line = l
+			break
+		}
+	}
+
+	if line == 0 {
+		return fmt.Errorf("js: %s", msg)
+	}
+	return fmt.Errorf("js: line %d: %s", line, msg)
+}
+
+// modifierFromJSON builds a js.Modifier from JSON. The script is given
+// inline with "script" or read from "file". "timeoutMs" and "maxBodyBytes"
+// override the default budget of each call.
+//
+// Example JSON:
+//
+//	{
+//	  "js.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "script": "function modifyRequest(req) { req.headers.set('X-Scripted', 'true'); }",
+//	    "timeoutMs": 50,
+//	    "maxBodyBytes": 65536
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	src := msg.Script
+	switch {
+	case msg.File != "" && msg.Script != "":
+		return nil, fmt.Errorf("js: only one of script and file may be set")
+	case msg.File != "":
+		b, err := ioutil.ReadFile(msg.File)
+		if err != nil {
+			return nil, fmt.Errorf("js: %v", err)
+		}
+		src = string(b)
+	}
+	if msg.TimeoutMs < 0 || msg.MaxBodyBytes < 0 {
+		return nil, fmt.Errorf("js: timeoutMs and maxBodyBytes must not be negative")
+	}
+
+	mod, err := NewModifier(src)
+	if err != nil {
+		return nil, err
+	}
+	if msg.TimeoutMs > 0 {
+		mod.SetTimeout(time.Duration(msg.TimeoutMs) * time.Millisecond)
+	}
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,305 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package js
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestModifyRequest(t *testing.T) {
+	m, err := NewModifier(`
+const HOSTS = {"old.example.com": "new.example.com"}
+
+function modifyRequest(req, ctx) {
+  req.host = HOSTS[req.host] ?? req.host
+  if (req.headers.has("X-Remove")) {
+    req.headers.delete("X-Remove")
+  }
+  req.headers.append("Via", "js")
+  if (req.method === "POST") {
+    req.body = req.body.toUpperCase()
+  }
+  if (req.path === "/skip") {
+    ctx.skipRoundTrip()
+  }
+  ctx.set("path", req.path)
+}
+`)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("POST", "http://old.example.com/skip?a=b", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-Remove", "true")
+	req.Header.Set("Via", "1.1 martian")
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got, want := req.Host, "new.example.com"; got != want {
+		t.Errorf("req.Host: got %q, want %q", got, want)
+	}
+	if got, want := req.URL.String(), "http://new.example.com/skip?a=b"; got != want {
+		t.Errorf("req.URL: got %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Remove"); got != "" {
+		t.Errorf("req.Header.Get(%q): got %q, want no value", "X-Remove", got)
+	}
+	if got, want := req.Header.Values("Via"), []string{"1.1 martian", "js"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("req.Header.Values(%q): got %v, want %v", "Via", got, want)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "BODY"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+	if got, want := req.ContentLength, int64(4); got != want {
+		t.Errorf("req.ContentLength: got %d, want %d", got, want)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got false, want true")
+	}
+	if got, _ := ctx.Get("js.path"); got != "/skip" {
+		t.Errorf("ctx.Get(%q): got %v, want %q", "js.path", got, "/skip")
+	}
+}
+
+func TestModifyResponse(t *testing.T) {
+	m, err := NewModifier(`
+let calls = 0
+
+function modifyRequest(req, ctx) {
+  ctx.set("seen", { path: req.path })
+}
+
+function modifyResponse(res, ctx) {
+  calls++
+  if (res.status === 404 && ctx.get("seen").path === "/health") {
+    res.status = 200
+    res.body = JSON.stringify({ ok: true, path: res.request.path })
+  }
+  res.headers.set("X-Status", String(res.status))
+  res.headers.set("X-Calls", String(calls))
+}
+`)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "http://example.com/health", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		martian.TestContext(req, nil, nil)
+		res := proxyutil.NewResponse(404, strings.NewReader("not found"), req)
+
+		if err := m.ModifyRequest(req); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+		if err := m.ModifyResponse(res); err != nil {
+			t.Fatalf("ModifyResponse(): got %v, want no error", err)
+		}
+
+		if got, want := res.StatusCode, 200; got != want {
+			t.Errorf("res.StatusCode: got %d, want %d", got, want)
+		}
+		if got, want := res.Header.Get("X-Status"), "200"; got != want {
+			t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Status", got, want)
+		}
+		// Calls do not share the state of the script.
+		if got, want := res.Header.Get("X-Calls"), "1"; got != want {
+			t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Calls", got, want)
+		}
+		got, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+		}
+		if want := `{"ok":true,"path":"/health"}`; string(got) != want {
+			t.Errorf("res.Body: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestModifyResponseReadOnlyRequest(t *testing.T) {
+	m, err := NewModifier(`
+function modifyResponse(res) {
+  res.request.path = "/other"
+}
+`)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+
+	err = m.ModifyResponse(res)
+	if err == nil {
+		t.Fatal("ModifyResponse(): got nil, want error")
+	}
+	if got, want := err.Error(), `js: line 3: TypeError: cannot set property "path" of the request of a response`; got != want {
+		t.Errorf("err.Error(): got %q, want %q", got, want)
+	}
+}
+
+func TestModifierBudget(t *testing.T) {
+	m, err := NewModifier(`
+function modifyRequest(req) {
+  if (req.path === "/loop") {
+    while (true) {}
+  }
+  try {
+    req.body
+  } catch (e) {
+    req.headers.set("X-Caught", "true")
+  }
+}
+`)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	m.SetTimeout(10 * time.Millisecond)
+	m.SetMaxBodySize(4)
+
+	req, err := http.NewRequest("GET", "http://example.com/loop", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := m.ModifyRequest(req); err == nil || !strings.Contains(err.Error(), "execution exceeded timeout of 10ms") {
+		t.Errorf("ModifyRequest(): got %v, want timeout error", err)
+	}
+
+	req, err = http.NewRequest("POST", "http://example.com/", strings.NewReader("too large"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := m.ModifyRequest(req); err == nil || !strings.Contains(err.Error(), "body exceeds limit of 4 bytes") {
+		t.Errorf("ModifyRequest(): got %v, want body size error", err)
+	}
+	if got := req.Header.Get("X-Caught"); got != "" {
+		t.Errorf("req.Header.Get(%q): got %q, want no value", "X-Caught", got)
+	}
+
+	// The body is left unchanged.
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "too large"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestNewModifierErrors(t *testing.T) {
+	tt := []struct {
+		src  string
+		want string
+	}{
+		{"function modifyRequest(req {\n}", `js: line 1: SyntaxError: Unexpected token {`},
+		{"throw new Error('bad config')", `js: line 1: Error: bad config`},
+		{"var modifyRequest = 1", `js: modifyRequest must be a function`},
+		{"while (true) {}", `js: line 1: execution exceeded timeout of 100ms`},
+		{"function f() {\n  f()\n}\nf()", `js: line 2: call stack exceeded depth of 200`},
+	}
+
+	for i, tc := range tt {
+		_, err := NewModifier(tc.src)
+		if err == nil {
+			t.Errorf("%d. NewModifier(): got nil, want error", i)
+			continue
+		}
+		if got := err.Error(); got != tc.want {
+			t.Errorf("%d. NewModifier(): got error %q, want %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+	  "js.Modifier": {
+	    "scope": ["request"],
+	    "script": "function modifyRequest(req) { req.headers.set('X-Scripted', 'true'); }",
+	    "timeoutMs": 50,
+	    "maxBodyBytes": 65536
+	  }
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("r.RequestModifier(): got nil, want not nil")
+	}
+	if resmod := r.ResponseModifier(); resmod != nil {
+		t.Error("r.ResponseModifier(): got not nil, want nil")
+	}
+
+	m := reqmod.(*Modifier)
+	if got, want := m.timeout, 50*time.Millisecond; got != want {
+		t.Errorf("m.timeout: got %v, want %v", got, want)
+	}
+	if got, want := m.maxBody, int64(65536); got != want {
+		t.Errorf("m.maxBody: got %d, want %d", got, want)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Scripted"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Scripted", got, want)
+	}
+}
+
+func TestModifierFromJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "modify.js")
+	src := "function modifyResponse(res) {\n  res.status = 201\n}\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	msg := []byte(`{
+	  "js.Modifier": {
+	    "scope": ["response"],
+	    "file": "` + path + `"
+	  }
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	if err := r.ResponseModifier().ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, 201; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+}
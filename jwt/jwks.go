@@ -0,0 +1,175 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultJWKSRefresh is the default interval at which a JWKS is fetched
+// again.
+const DefaultJWKSRefresh = time.Hour
+
+// jwksMinRefresh is the shortest interval between fetches of a JWKS for
+// tokens with unknown key IDs.
+const jwksMinRefresh = time.Minute
+
+// jwks is a JSON Web Key Set fetched from a URL and cached.
+type jwks struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string][]any
+	fetched time.Time
+}
+
+type jwkJSON struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func newJWKS(url string) *jwks {
+	return &jwks{
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		refresh: DefaultJWKSRefresh,
+	}
+}
+
+// lookup returns the keys of the set with kid, or all keys if kid is empty.
+// The set is fetched when it is stale, or when it has no key with kid and was
+// not fetched recently.
+func (s *jwks) lookup(kid string, now time.Time) ([]any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stale := s.keys == nil || now.Sub(s.fetched) >= s.refresh
+	if _, ok := s.keys[kid]; !ok && kid != "" && now.Sub(s.fetched) >= jwksMinRefresh {
+		stale = true
+	}
+	if stale {
+		keys, err := s.fetch()
+		if err != nil && s.keys == nil {
+			return nil, err
+		}
+		if err == nil {
+			s.keys = keys
+		}
+		s.fetched = now
+	}
+
+	if kid != "" {
+		return s.keys[kid], nil
+	}
+
+	var all []any
+	for _, keys := range s.keys {
+		all = append(all, keys...)
+	}
+	return all, nil
+}
+
+// fetch fetches the set and returns its signing keys by ID. Keys of unknown
+// types are skipped.
+func (s *jwks) fetch() (map[string][]any, error) {
+	res, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %v", err)
+	}
+
+	keys := make(map[string][]any)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.key()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = append(keys[jwk.Kid], key)
+	}
+
+	return keys, nil
+}
+
+// key returns the key of jwk.
+func (jwk *jwkJSON) key() (any, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unknown curve %q", jwk.Crv)
+		}
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return encoding.DecodeString(jwk.K)
+	default:
+		return nil, fmt.Errorf("unknown key type %q", jwk.Kty)
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := encoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
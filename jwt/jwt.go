@@ -0,0 +1,229 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package jwt provides a modifier that mints JSON Web Tokens and injects them
+// into requests, so that traffic can authenticate against services protected
+// by JWTs without changes to the client, and a verifier that validates the
+// tokens requests carry.
+//
+// Tokens are signed with HMAC (HS256, HS384, HS512), RSA (RS256, RS384,
+// RS512) or ECDSA (ES256, ES384, ES512) keys.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // for crypto.SHA256
+	_ "crypto/sha512" // for crypto.SHA384 and crypto.SHA512
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+type keyKind int
+
+const (
+	hmacKey keyKind = iota
+	rsaKey
+	ecdsaKey
+)
+
+type algorithm struct {
+	kind  keyKind
+	hash  crypto.Hash
+	curve elliptic.Curve
+}
+
+var algorithms = map[string]algorithm{
+	"HS256": {kind: hmacKey, hash: crypto.SHA256},
+	"HS384": {kind: hmacKey, hash: crypto.SHA384},
+	"HS512": {kind: hmacKey, hash: crypto.SHA512},
+	"RS256": {kind: rsaKey, hash: crypto.SHA256},
+	"RS384": {kind: rsaKey, hash: crypto.SHA384},
+	"RS512": {kind: rsaKey, hash: crypto.SHA512},
+	"ES256": {kind: ecdsaKey, hash: crypto.SHA256, curve: elliptic.P256()},
+	"ES384": {kind: ecdsaKey, hash: crypto.SHA384, curve: elliptic.P384()},
+	"ES512": {kind: ecdsaKey, hash: crypto.SHA512, curve: elliptic.P521()},
+}
+
+var encoding = base64.RawURLEncoding
+
+// signingKey returns the key to sign tokens with alg from key, which is the
+// secret for HMAC algorithms and a PEM encoded private key otherwise.
+func signingKey(alg string, key []byte) (any, error) {
+	a, ok := algorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("jwt: unknown algorithm %q", alg)
+	}
+	if a.kind == hmacKey {
+		if len(key) == 0 {
+			return nil, errors.New("jwt: empty HMAC key")
+		}
+		return key, nil
+	}
+
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: %s key is not PEM encoded", alg)
+	}
+
+	var priv any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt: parsing %s key: %v", alg, err)
+	}
+
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		if a.kind == rsaKey {
+			return k, nil
+		}
+	case *ecdsa.PrivateKey:
+		if a.kind == ecdsaKey && k.Curve == a.curve {
+			return k, nil
+		}
+	}
+
+	return nil, fmt.Errorf("jwt: %T is not a %s key", priv, alg)
+}
+
+// sign returns the token with header and claims signed with key, as returned
+// by signingKey for alg.
+func sign(alg string, key any, header, claims any) (string, error) {
+	hb, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := encoding.EncodeToString(hb) + "." + encoding.EncodeToString(cb)
+
+	a := algorithms[alg]
+	h := a.hash.New()
+	if a.kind == hmacKey {
+		h = hmac.New(a.hash.New, key.([]byte))
+	}
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch a.kind {
+	case hmacKey:
+		sig = digest
+	case rsaKey:
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), a.hash, digest); err != nil {
+			return "", err
+		}
+	case ecdsaKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest)
+		if err != nil {
+			return "", err
+		}
+		// JWS signatures are the fixed size big-endian R and S concatenated.
+		size := (a.curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+
+	return input + "." + encoding.EncodeToString(sig), nil
+}
+
+// publicKey parses a PEM encoded public key or certificate.
+func publicKey(b []byte) (any, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("jwt: public key is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: parsing certificate: %v", err)
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: parsing public key: %v", err)
+		}
+		return pub, nil
+	default:
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: parsing public key: %v", err)
+		}
+		return pub, nil
+	}
+}
+
+// verifySignature verifies that sig is the signature of input with alg and
+// key, which is an HMAC secret or a public key. Keys of another kind than alg
+// fail, so that a public key can not be used as an HMAC secret.
+func verifySignature(alg string, key any, input string, sig []byte) error {
+	a, ok := algorithms[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	// An HMAC secret must never verify an RSA or ECDSA algorithm, as the
+	// digest would then be a plain hash anyone can compute.
+	if _, ok := key.([]byte); ok != (a.kind == hmacKey) {
+		return fmt.Errorf("%T is not a %s key", key, alg)
+	}
+
+	h := a.hash.New()
+	if secret, ok := key.([]byte); ok {
+		h = hmac.New(a.hash.New, secret)
+	}
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case []byte:
+		if hmac.Equal(sig, digest) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if a.kind != rsaKey {
+			return fmt.Errorf("%T is not a %s key", key, alg)
+		}
+		if rsa.VerifyPKCS1v15(k, a.hash, digest, sig) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if a.kind != ecdsaKey || k.Curve != a.curve {
+			return fmt.Errorf("%T is not a %s key", key, alg)
+		}
+		size := (a.curve.Params().BitSize + 7) / 8
+		if len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	default:
+		return fmt.Errorf("%T is not a %s key", key, alg)
+	}
+
+	return errors.New("invalid signature")
+}
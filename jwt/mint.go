@@ -0,0 +1,300 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+)
+
+// DefaultTTL is the default lifetime of minted tokens.
+const DefaultTTL = 5 * time.Minute
+
+func init() {
+	parse.Register("jwt.Modifier", modifierFromJSON)
+}
+
+// Modifier is a martian.RequestModifier that mints a token for each request
+// and sets it in the Authorization header, replacing any token there.
+//
+// The string values of claims are templates, see text/template, evaluated
+// against the request:
+//
+//	{{.Method}}              the request method
+//	{{.Host}}                the host the request is sent to
+//	{{.Path}}                the path of the URL
+//	{{.Header "X-User"}}     the first value of the header X-User
+//	{{.Query "user"}}        the first value of the query parameter user
+//	{{.Cookie "session"}}    the value of the cookie session
+//
+// The iat and exp claims are set from the time of minting and the lifetime
+// of tokens.
+type Modifier struct {
+	alg    string
+	key    any
+	kid    string
+	ttl    time.Duration
+	claims map[string]any
+	header string
+	scheme string
+	now    func() time.Time
+}
+
+type modifierJSON struct {
+	Alg    string               `json:"alg"`
+	Key    string               `json:"key"`
+	KeyID  string               `json:"keyId"`
+	TTLMs  int64                `json:"ttlMs"`
+	Claims map[string]any       `json:"claims"`
+	Header string               `json:"header"`
+	Scheme *string              `json:"scheme"`
+	Scope  []parse.ModifierType `json:"scope"`
+}
+
+// requestView is the data the claim templates are evaluated against.
+type requestView struct {
+	Method string
+	Host   string
+	Path   string
+	req    *http.Request
+}
+
+// Header returns the first value of the header name.
+func (v *requestView) Header(name string) string {
+	return v.req.Header.Get(name)
+}
+
+// Query returns the first value of the query parameter name.
+func (v *requestView) Query(name string) string {
+	return v.req.URL.Query().Get(name)
+}
+
+// Cookie returns the value of the cookie name, or "" if there is none.
+func (v *requestView) Cookie(name string) string {
+	c, err := v.req.Cookie(name)
+	if err != nil {
+		return ""
+	}
+
+	return c.Value
+}
+
+// NewModifier returns a Modifier that signs tokens with alg and key, which is
+// the secret for HMAC algorithms and a PEM encoded private key otherwise.
+func NewModifier(alg string, key []byte) (*Modifier, error) {
+	k, err := signingKey(alg, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Modifier{
+		alg:    alg,
+		key:    k,
+		ttl:    DefaultTTL,
+		claims: map[string]any{},
+		header: "Authorization",
+		scheme: "Bearer",
+		now:    time.Now,
+	}, nil
+}
+
+// SetClaims sets the claims of the tokens. String values, including those
+// nested in objects and arrays, are parsed as templates.
+func (m *Modifier) SetClaims(claims map[string]any) error {
+	compiled, err := compileClaims(claims)
+	if err != nil {
+		return err
+	}
+	m.claims = compiled.(map[string]any)
+
+	return nil
+}
+
+// SetTTL sets the lifetime of the tokens.
+func (m *Modifier) SetTTL(ttl time.Duration) {
+	m.ttl = ttl
+}
+
+// SetKeyID sets the kid header of the tokens, which identifies the key to
+// verifiers.
+func (m *Modifier) SetKeyID(kid string) {
+	m.kid = kid
+}
+
+// SetHeader sets the header the token is set in, and the authentication
+// scheme that precedes it. An empty scheme sets the token alone.
+func (m *Modifier) SetHeader(name, scheme string) {
+	m.header = name
+	m.scheme = scheme
+}
+
+// ModifyRequest mints a token for req and sets it in the header.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	view := &requestView{
+		Method: req.Method,
+		Host:   host,
+		Path:   req.URL.Path,
+		req:    req,
+	}
+
+	claims, err := evalClaims(m.claims, view)
+	if err != nil {
+		return fmt.Errorf("jwt: %v", err)
+	}
+	c := claims.(map[string]any)
+	now := m.now()
+	c["iat"] = now.Unix()
+	c["exp"] = now.Add(m.ttl).Unix()
+
+	header := map[string]string{"alg": m.alg, "typ": "JWT"}
+	if m.kid != "" {
+		header["kid"] = m.kid
+	}
+
+	token, err := sign(m.alg, m.key, header, c)
+	if err != nil {
+		return fmt.Errorf("jwt: %v", err)
+	}
+
+	if m.scheme != "" {
+		token = m.scheme + " " + token
+	}
+	req.Header.Set(m.header, token)
+
+	return nil
+}
+
+// compileClaims returns v with the strings in it that contain template
+// actions replaced by their parsed templates.
+func compileClaims(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		return template.New("claim").Parse(v)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			c, err := compileClaims(e)
+			if err != nil {
+				return nil, fmt.Errorf("jwt: claim %q: %v", k, err)
+			}
+			out[k] = c
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			c, err := compileClaims(e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// evalClaims returns a copy of v with its templates executed against view.
+func evalClaims(v any, view *requestView) (any, error) {
+	switch v := v.(type) {
+	case *template.Template:
+		var b strings.Builder
+		if err := v.Execute(&b, view); err != nil {
+			return nil, err
+		}
+		return b.String(), nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			c, err := evalClaims(e, view)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = c
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			c, err := evalClaims(e, view)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// modifierFromJSON builds a jwt.Modifier from JSON. "key" is the HMAC secret
+// or the PEM encoded private key, best given as a reference to a secret file,
+// see parse.Expand. "ttlMs" overrides the default lifetime of tokens, and
+// "header" and "scheme" override where the token is set.
+//
+// Example JSON:
+//
+//	{
+//	  "jwt.Modifier": {
+//	    "scope": ["request"],
+//	    "alg": "RS256",
+//	    "key": "${file:/run/secrets/jwt-key.pem}",
+//	    "keyId": "test-key",
+//	    "ttlMs": 60000,
+//	    "claims": {
+//	      "iss": "martian",
+//	      "aud": "https://api.example.com",
+//	      "sub": "{{.Header \"X-User\"}}",
+//	      "roles": ["tester"]
+//	    }
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if msg.TTLMs < 0 {
+		return nil, errors.New("jwt: ttlMs must not be negative")
+	}
+
+	mod, err := NewModifier(msg.Alg, []byte(msg.Key))
+	if err != nil {
+		return nil, err
+	}
+	if err := mod.SetClaims(msg.Claims); err != nil {
+		return nil, err
+	}
+	if msg.TTLMs > 0 {
+		mod.SetTTL(time.Duration(msg.TTLMs) * time.Millisecond)
+	}
+	mod.SetKeyID(msg.KeyID)
+	if msg.Header != "" || msg.Scheme != nil {
+		header, scheme := "Authorization", "Bearer"
+		if msg.Header != "" {
+			header = msg.Header
+		}
+		if msg.Scheme != nil {
+			scheme = *msg.Scheme
+		}
+		mod.SetHeader(header, scheme)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,269 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+)
+
+// decode splits token into its decoded header, claims, signing input and
+// signature.
+func decode(t *testing.T, token string) (map[string]any, map[string]any, string, []byte) {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token: got %d parts, want 3", len(parts))
+	}
+
+	var header, claims map[string]any
+	for i, v := range []*map[string]any{&header, &claims} {
+		b, err := encoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatalf("encoding.DecodeString(): got %v, want no error", err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatalf("json.Unmarshal(): got %v, want no error", err)
+		}
+	}
+
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatalf("encoding.DecodeString(): got %v, want no error", err)
+	}
+
+	return header, claims, parts[0] + "." + parts[1], sig
+}
+
+func TestModifierHS256(t *testing.T) {
+	mod, err := NewModifier("HS256", []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	mod.now = func() time.Time { return time.Unix(1500000000, 0) }
+	mod.SetTTL(time.Minute)
+	mod.SetKeyID("k1")
+	if err := mod.SetClaims(map[string]any{
+		"iss": "martian",
+		"sub": `{{.Header "X-User"}}`,
+		"ctx": map[string]any{
+			"req":   "{{.Method}} {{.Host}}{{.Path}}",
+			"group": []any{`{{.Query "group"}}`, `{{.Cookie "team"}}`, float64(1)},
+		},
+	}); err != nil {
+		t.Fatalf("SetClaims(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/api?group=qa", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-User", "alice")
+	req.AddCookie(&http.Cookie{Name: "team", Value: "blue"})
+	req.Header.Set("Authorization", "Bearer old")
+
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		t.Fatalf("req.Header.Get(%q): got %q, want Bearer token", "Authorization", auth)
+	}
+	header, claims, input, sig := decode(t, strings.TrimPrefix(auth, "Bearer "))
+
+	if got, want := header, map[string]any{"alg": "HS256", "typ": "JWT", "kid": "k1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("header: got %v, want %v", got, want)
+	}
+
+	want := map[string]any{
+		"iss": "martian",
+		"sub": "alice",
+		"ctx": map[string]any{
+			"req":   "GET example.com/api",
+			"group": []any{"qa", "blue", float64(1)},
+		},
+		"iat": float64(1500000000),
+		"exp": float64(1500000060),
+	}
+	if !reflect.DeepEqual(claims, want) {
+		t.Errorf("claims: got %v, want %v", claims, want)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(input))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		t.Error("signature: got mismatch, want HMAC-SHA256 of signing input")
+	}
+}
+
+func TestModifierRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey(): got %v, want no error", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKCS8PrivateKey(): got %v, want no error", err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if _, err := NewModifier("ES256", pemKey); err == nil {
+		t.Error("NewModifier(ES256, RSA key): got nil, want error")
+	}
+
+	mod, err := NewModifier("RS256", pemKey)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	mod.SetHeader("X-Token", "")
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	_, _, input, sig := decode(t, req.Header.Get("X-Token"))
+	digest := sha256.Sum256([]byte(input))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("rsa.VerifyPKCS1v15(): got %v, want no error", err)
+	}
+}
+
+func TestModifierES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey(): got %v, want no error", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey(): got %v, want no error", err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	if _, err := NewModifier("ES384", pemKey); err == nil {
+		t.Error("NewModifier(ES384, P-256 key): got nil, want error")
+	}
+
+	mod, err := NewModifier("ES256", pemKey)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	_, _, input, sig := decode(t, strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	if got, want := len(sig), 64; got != want {
+		t.Fatalf("len(sig): got %d, want %d", got, want)
+	}
+	digest := sha256.Sum256([]byte(input))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&key.PublicKey, digest[:], r, s) {
+		t.Error("ecdsa.Verify(): got false, want true")
+	}
+}
+
+func TestModifierErrors(t *testing.T) {
+	if _, err := NewModifier("none", nil); err == nil {
+		t.Error("NewModifier(none): got nil, want error")
+	}
+	if _, err := NewModifier("HS256", nil); err == nil {
+		t.Error("NewModifier(HS256, empty key): got nil, want error")
+	}
+	if _, err := NewModifier("RS256", []byte("not pem")); err == nil {
+		t.Error("NewModifier(RS256, not PEM): got nil, want error")
+	}
+
+	mod, err := NewModifier("HS256", []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	if err := mod.SetClaims(map[string]any{"sub": "{{.Header"}); err == nil {
+		t.Error("SetClaims(): got nil, want error for invalid template")
+	}
+	if err := mod.SetClaims(map[string]any{"sub": "{{.Missing}}"}); err != nil {
+		t.Fatalf("SetClaims(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := mod.ModifyRequest(req); err == nil {
+		t.Error("ModifyRequest(): got nil, want error for unknown template field")
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"jwt.Modifier": {
+			"scope": ["request"],
+			"alg": "HS256",
+			"key": "secret",
+			"ttlMs": 60000,
+			"header": "X-Auth",
+			"scheme": "JWT",
+			"claims": {
+				"aud": "api",
+				"sub": "{{.Header \"X-User\"}}"
+			}
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-User", "bob")
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	auth := req.Header.Get("X-Auth")
+	if !strings.HasPrefix(auth, "JWT ") {
+		t.Fatalf("req.Header.Get(%q): got %q, want JWT token", "X-Auth", auth)
+	}
+	_, claims, _, _ := decode(t, strings.TrimPrefix(auth, "JWT "))
+	if got, want := claims["sub"], "bob"; got != want {
+		t.Errorf("claims[%q]: got %v, want %v", "sub", got, want)
+	}
+	if got, want := claims["exp"].(float64)-claims["iat"].(float64), float64(60); got != want {
+		t.Errorf("exp - iat: got %v, want %v", got, want)
+	}
+}
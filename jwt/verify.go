@@ -0,0 +1,408 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+)
+
+const claimsKey = "jwt.Claims"
+
+func init() {
+	parse.Register("jwt.Verifier", verifierFromJSON)
+}
+
+// Verifier is a verify.RequestVerifier that validates the bearer token in the
+// Authorization header of requests: its signature against the configured
+// keys, its exp and nbf claims and, when set, its aud and iss claims. Every
+// request with a missing or invalid token is recorded as a verification
+// failure.
+//
+// When the Verifier rejects requests, a request that fails is not sent
+// upstream and is answered with a 401 Unauthorized instead, which requires
+// the Verifier to modify responses as well.
+type Verifier struct {
+	secrets    map[string][]byte
+	publicKeys map[string]any
+	jwks       *jwks
+	audiences  []string
+	issuer     string
+	leeway     time.Duration
+	reject     bool
+	now        func() time.Time
+
+	mu     sync.Mutex
+	reqerr *martian.MultiError
+}
+
+type verifierJSON struct {
+	Secrets    map[string]string    `json:"secrets"`
+	PublicKeys map[string]string    `json:"publicKeys"`
+	JWKSURL    string               `json:"jwksUrl"`
+	Audience   []string             `json:"audience"`
+	Issuer     string               `json:"issuer"`
+	LeewayMs   int64                `json:"leewayMs"`
+	Reject     bool                 `json:"reject"`
+	Scope      []parse.ModifierType `json:"scope"`
+}
+
+// NewVerifier returns a Verifier with no keys.
+func NewVerifier() *Verifier {
+	return &Verifier{
+		secrets:    make(map[string][]byte),
+		publicKeys: make(map[string]any),
+		now:        time.Now,
+		reqerr:     martian.NewMultiError(),
+	}
+}
+
+// AddSecret adds an HMAC secret that verifies tokens with the key ID kid, or
+// tokens without one if kid is empty.
+func (v *Verifier) AddSecret(kid string, secret []byte) {
+	v.secrets[kid] = secret
+}
+
+// AddPublicKey adds a PEM encoded RSA or ECDSA public key, or a certificate,
+// that verifies tokens with the key ID kid, or tokens without one if kid is
+// empty.
+func (v *Verifier) AddPublicKey(kid string, key []byte) error {
+	pub, err := publicKey(key)
+	if err != nil {
+		return err
+	}
+	v.publicKeys[kid] = pub
+
+	return nil
+}
+
+// SetJWKSURL sets the URL of a JSON Web Key Set whose keys verify tokens. The
+// set is fetched when it is first needed, and again when it is older than
+// refresh or a token has a key ID that is not in it.
+func (v *Verifier) SetJWKSURL(url string, refresh time.Duration) {
+	v.jwks = newJWKS(url)
+	if refresh > 0 {
+		v.jwks.refresh = refresh
+	}
+}
+
+// SetAudience sets the audiences of which the aud claim of tokens must
+// contain at least one.
+func (v *Verifier) SetAudience(audiences ...string) {
+	v.audiences = audiences
+}
+
+// SetIssuer sets the value the iss claim of tokens must have.
+func (v *Verifier) SetIssuer(issuer string) {
+	v.issuer = issuer
+}
+
+// SetLeeway sets the clock skew allowed when checking the exp and nbf claims.
+func (v *Verifier) SetLeeway(leeway time.Duration) {
+	v.leeway = leeway
+}
+
+// SetReject sets whether requests that fail verification are rejected with a
+// 401 Unauthorized.
+func (v *Verifier) SetReject(reject bool) {
+	v.reject = reject
+}
+
+// ModifyRequest verifies the bearer token of req. The claims of a valid token
+// are available to later modifiers with Claims.
+func (v *Verifier) ModifyRequest(req *http.Request) error {
+	ctx := martian.NewContext(req)
+
+	claims, err := v.verify(req)
+	if err != nil {
+		v.mu.Lock()
+		v.reqerr.Add(fmt.Errorf("jwt verify failure: request(%s): %v", req.URL, err))
+		v.mu.Unlock()
+
+		if v.reject && ctx != nil {
+			ctx.Set(v.rejectKey(), err)
+			ctx.SkipRoundTrip()
+		}
+		return nil
+	}
+
+	if ctx != nil {
+		ctx.Set(claimsKey, claims)
+	}
+
+	return nil
+}
+
+// ModifyResponse answers a rejected request with a 401 Unauthorized.
+func (v *Verifier) ModifyResponse(res *http.Response) error {
+	ctx := martian.NewContext(res.Request)
+	if ctx == nil {
+		return nil
+	}
+	rerr, ok := ctx.Get(v.rejectKey())
+	if !ok {
+		return nil
+	}
+
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	res.Body = http.NoBody
+	res.ContentLength = 0
+	res.TransferEncoding = nil
+	res.StatusCode = http.StatusUnauthorized
+	res.Status = fmt.Sprintf("%d %s", http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+	res.Header.Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, error_description=%q", "invalid_token", rerr.(error).Error()))
+
+	return nil
+}
+
+// VerifyRequests returns an error if verification for any request failed.
+// If an error is returned it will be of type *martian.MultiError.
+func (v *Verifier) VerifyRequests() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.reqerr.Empty() {
+		return nil
+	}
+
+	return v.reqerr
+}
+
+// ResetRequestVerifications clears all failed request verifications.
+func (v *Verifier) ResetRequestVerifications() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.reqerr = martian.NewMultiError()
+}
+
+// Claims returns the claims of the token verified for the request of ctx, or
+// nil if there is none.
+func Claims(ctx *martian.Context) map[string]any {
+	if ctx == nil {
+		return nil
+	}
+	claims, ok := ctx.Get(claimsKey)
+	if !ok {
+		return nil
+	}
+
+	return claims.(map[string]any)
+}
+
+func (v *Verifier) rejectKey() string {
+	return fmt.Sprintf("jwt.Verifier.%p", v)
+}
+
+// verify returns the claims of the valid bearer token of req.
+func (v *Verifier) verify(req *http.Request) (map[string]any, error) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, errors.New("no bearer token")
+	}
+
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %v", err)
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %v", err)
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+
+	if _, ok := algorithms[header.Alg]; !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	now := v.now()
+	keys, err := v.keys(header.Kid, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key for kid %q", header.Kid)
+	}
+
+	input := parts[0] + "." + parts[1]
+	err = errors.New("invalid signature")
+	for _, key := range keys {
+		if err = verifySignature(header.Alg, key, input, sig); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if exp, ok := claims["exp"]; ok {
+		t, ok := exp.(float64)
+		if !ok {
+			return nil, errors.New("malformed exp claim")
+		}
+		if !now.Before(time.Unix(int64(t), 0).Add(v.leeway)) {
+			return nil, errors.New("token is expired")
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		t, ok := nbf.(float64)
+		if !ok {
+			return nil, errors.New("malformed nbf claim")
+		}
+		if now.Add(v.leeway).Before(time.Unix(int64(t), 0)) {
+			return nil, errors.New("token is not valid yet")
+		}
+	}
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return nil, fmt.Errorf("iss: got %v, want %s", claims["iss"], v.issuer)
+	}
+	if len(v.audiences) > 0 && !v.hasAudience(claims["aud"]) {
+		return nil, fmt.Errorf("aud: got %v, want one of %s", claims["aud"], strings.Join(v.audiences, ", "))
+	}
+
+	return claims, nil
+}
+
+// keys returns the keys that may verify a token with kid.
+func (v *Verifier) keys(kid string, now time.Time) ([]any, error) {
+	var keys []any
+	if kid == "" {
+		for _, secret := range v.secrets {
+			keys = append(keys, secret)
+		}
+		for _, pub := range v.publicKeys {
+			keys = append(keys, pub)
+		}
+	} else {
+		if secret, ok := v.secrets[kid]; ok {
+			keys = append(keys, secret)
+		}
+		if pub, ok := v.publicKeys[kid]; ok {
+			keys = append(keys, pub)
+		}
+	}
+
+	if v.jwks != nil && len(keys) == 0 {
+		jkeys, err := v.jwks.lookup(kid, now)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jkeys...)
+	}
+
+	return keys, nil
+}
+
+// hasAudience reports whether aud, a string or an array of strings, contains
+// one of the audiences of v.
+func (v *Verifier) hasAudience(aud any) bool {
+	var auds []any
+	switch aud := aud.(type) {
+	case string:
+		auds = []any{aud}
+	case []any:
+		auds = aud
+	}
+
+	for _, a := range auds {
+		for _, want := range v.audiences {
+			if a == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := encoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// verifierFromJSON builds a jwt.Verifier from JSON. "secrets" and
+// "publicKeys" map key IDs, which may be empty, to HMAC secrets and PEM
+// encoded public keys. "jwksUrl" is the URL of a JSON Web Key Set. "reject"
+// answers requests that fail with a 401, and requires the response scope.
+//
+// Example JSON:
+//
+//	{
+//	  "jwt.Verifier": {
+//	    "scope": ["request", "response"],
+//	    "jwksUrl": "https://auth.example.com/.well-known/jwks.json",
+//	    "audience": ["https://api.example.com"],
+//	    "issuer": "https://auth.example.com/",
+//	    "leewayMs": 30000,
+//	    "reject": true
+//	  }
+//	}
+func verifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &verifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if len(msg.Secrets) == 0 && len(msg.PublicKeys) == 0 && msg.JWKSURL == "" {
+		return nil, errors.New("jwt: one of secrets, publicKeys or jwksUrl must be set")
+	}
+	if msg.LeewayMs < 0 {
+		return nil, errors.New("jwt: leewayMs must not be negative")
+	}
+	if msg.Reject && !hasScope(msg.Scope, parse.Response) {
+		return nil, errors.New("jwt: reject requires the response scope")
+	}
+
+	v := NewVerifier()
+	for kid, secret := range msg.Secrets {
+		v.AddSecret(kid, []byte(secret))
+	}
+	for kid, key := range msg.PublicKeys {
+		if err := v.AddPublicKey(kid, []byte(key)); err != nil {
+			return nil, err
+		}
+	}
+	if msg.JWKSURL != "" {
+		v.SetJWKSURL(msg.JWKSURL, 0)
+	}
+	v.SetAudience(msg.Audience...)
+	v.SetIssuer(msg.Issuer)
+	v.SetLeeway(time.Duration(msg.LeewayMs) * time.Millisecond)
+	v.SetReject(msg.Reject)
+
+	return parse.NewResult(v, msg.Scope)
+}
+
+func hasScope(scope []parse.ModifierType, t parse.ModifierType) bool {
+	for _, s := range scope {
+		if s == t {
+			return true
+		}
+	}
+
+	return false
+}
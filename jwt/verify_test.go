@@ -0,0 +1,371 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+// mint returns a request with a token minted by mod with claims at now.
+func mint(t *testing.T, mod *Modifier, claims map[string]any, now time.Time) *http.Request {
+	t.Helper()
+
+	if err := mod.SetClaims(claims); err != nil {
+		t.Fatalf("SetClaims(): got %v, want no error", err)
+	}
+	mod.now = func() time.Time { return now }
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	return req
+}
+
+func TestVerifier(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	mod, err := NewModifier("HS256", []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	other, err := NewModifier("HS256", []byte("other"))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	v := NewVerifier()
+	v.AddSecret("", []byte("secret"))
+	v.SetAudience("api", "admin")
+	v.SetIssuer("martian")
+	v.SetLeeway(time.Second)
+	v.now = func() time.Time { return now }
+
+	valid := map[string]any{"iss": "martian", "aud": []any{"web", "api"}}
+
+	tt := []struct {
+		name  string
+		req   *http.Request
+		valid bool
+	}{
+		{"valid", mint(t, mod, valid, now), true},
+		{"audience string", mint(t, mod, map[string]any{"iss": "martian", "aud": "admin"}, now), true},
+		{"expired within leeway", mint(t, mod, valid, now.Add(-DefaultTTL).Add(time.Second/2)), true},
+		{"expired", mint(t, mod, valid, now.Add(-DefaultTTL-time.Second)), false},
+		{"not valid yet", mint(t, mod, map[string]any{"iss": "martian", "aud": "api", "nbf": float64(now.Unix() + 10)}, now), false},
+		{"wrong audience", mint(t, mod, map[string]any{"iss": "martian", "aud": "web"}, now), false},
+		{"no audience", mint(t, mod, map[string]any{"iss": "martian"}, now), false},
+		{"wrong issuer", mint(t, mod, map[string]any{"iss": "other", "aud": "api"}, now), false},
+		{"wrong key", mint(t, other, valid, now), false},
+	}
+
+	for _, tc := range tt {
+		v.ResetRequestVerifications()
+		ctx := martian.TestContext(tc.req, nil, nil)
+
+		if err := v.ModifyRequest(tc.req); err != nil {
+			t.Fatalf("%s. ModifyRequest(): got %v, want no error", tc.name, err)
+		}
+
+		if err := v.VerifyRequests(); (err == nil) != tc.valid {
+			t.Errorf("%s. VerifyRequests(): got %v, want valid %t", tc.name, err, tc.valid)
+		}
+		if got := Claims(ctx) != nil; got != tc.valid {
+			t.Errorf("%s. Claims(ctx) != nil: got %t, want %t", tc.name, got, tc.valid)
+		}
+	}
+}
+
+func TestVerifierMalformedTokens(t *testing.T) {
+	v := NewVerifier()
+	v.AddSecret("", []byte("secret"))
+
+	mod, err := NewModifier("HS256", []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	token := strings.TrimPrefix(mint(t, mod, nil, time.Now()).Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+
+	none := encoding.EncodeToString([]byte(`{"alg":"none"}`))
+
+	for i, auth := range []string{
+		"",
+		"Basic dXNlcjpwYXNz",
+		"Bearer " + parts[0] + "." + parts[1],
+		"Bearer " + parts[0] + "." + parts[1] + ".",
+		"Bearer " + none + "." + parts[1] + ".",
+		"Bearer " + parts[0] + "." + parts[1] + "x." + parts[2],
+	} {
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		req.Header.Set("Authorization", auth)
+
+		v.ResetRequestVerifications()
+		if err := v.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+		if err := v.VerifyRequests(); err == nil {
+			t.Errorf("%d. VerifyRequests(): got nil, want error for %q", i, auth)
+		}
+	}
+}
+
+func TestVerifierAlgorithmConfusion(t *testing.T) {
+	v := NewVerifier()
+	v.AddSecret("", []byte("secret"))
+
+	payload := encoding.EncodeToString([]byte(`{"sub":"attacker"}`))
+	for _, alg := range []string{"RS256", "ES256"} {
+		// Signed with a plain, unkeyed hash, which anyone can compute.
+		input := encoding.EncodeToString([]byte(`{"alg":"`+alg+`","typ":"JWT"}`)) + "." + payload
+		sum := sha256.Sum256([]byte(input))
+		token := input + "." + encoding.EncodeToString(sum[:])
+
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		ctx := martian.TestContext(req, nil, nil)
+
+		v.ResetRequestVerifications()
+		if err := v.ModifyRequest(req); err != nil {
+			t.Fatalf("%s. ModifyRequest(): got %v, want no error", alg, err)
+		}
+		if err := v.VerifyRequests(); err == nil {
+			t.Errorf("%s. VerifyRequests(): got nil, want error for token signed with a plain hash", alg)
+		}
+		if claims := Claims(ctx); claims != nil {
+			t.Errorf("%s. Claims(ctx): got %v, want nil", alg, claims)
+		}
+	}
+}
+
+func TestVerifierPublicKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey(): got %v, want no error", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey(): got %v, want no error", err)
+	}
+	pub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	v := NewVerifier()
+	if err := v.AddPublicKey("k1", pub); err != nil {
+		t.Fatalf("AddPublicKey(): got %v, want no error", err)
+	}
+
+	mod, err := NewModifier("RS256", priv)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	mod.SetKeyID("k1")
+	req := mint(t, mod, nil, time.Now())
+	if err := v.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if err := v.VerifyRequests(); err != nil {
+		t.Errorf("VerifyRequests(): got %v, want no error", err)
+	}
+
+	// A token signed with HS256 and the public key as the secret must not
+	// verify with the public key.
+	forged, err := NewModifier("HS256", pub)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	forged.SetKeyID("k1")
+	req = mint(t, forged, nil, time.Now())
+	if err := v.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if err := v.VerifyRequests(); err == nil {
+		t.Error("VerifyRequests(): got nil, want error for HS256 token signed with public key")
+	}
+}
+
+func TestVerifierJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey(): got %v, want no error", err)
+	}
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(rw).Encode(map[string]any{
+			"keys": []map[string]string{
+				{"kty": "EC", "kid": "unknown-curve", "crv": "P-192"},
+				{
+					"kty": "RSA",
+					"kid": "k1",
+					"use": "sig",
+					"n":   encoding.EncodeToString(key.N.Bytes()),
+					"e":   encoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	v := NewVerifier()
+	v.SetJWKSURL(server.URL, time.Hour)
+	v.now = func() time.Time { return now }
+
+	mod, err := NewModifier("RS256", priv)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	for _, kid := range []string{"k1", "k1", ""} {
+		mod.SetKeyID(kid)
+		if err := v.ModifyRequest(mint(t, mod, nil, now)); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+	}
+	if err := v.VerifyRequests(); err != nil {
+		t.Errorf("VerifyRequests(): got %v, want no error", err)
+	}
+	if got, want := atomic.LoadInt32(&fetches), int32(1); got != want {
+		t.Errorf("fetches: got %d, want %d", got, want)
+	}
+
+	// An unknown key ID fetches the set again, at most once a minute.
+	mod.SetKeyID("k2")
+	for i := 0; i < 2; i++ {
+		if err := v.ModifyRequest(mint(t, mod, nil, now)); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+	}
+	if got, want := atomic.LoadInt32(&fetches), int32(1); got != want {
+		t.Errorf("fetches: got %d, want %d", got, want)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := v.ModifyRequest(mint(t, mod, nil, now)); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := atomic.LoadInt32(&fetches), int32(2); got != want {
+		t.Errorf("fetches: got %d, want %d", got, want)
+	}
+	if err := v.VerifyRequests(); err == nil {
+		t.Error("VerifyRequests(): got nil, want error for unknown key ID")
+	}
+}
+
+func TestVerifierReject(t *testing.T) {
+	v := NewVerifier()
+	v.AddSecret("", []byte("secret"))
+	v.SetReject(true)
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := v.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got false, want true")
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := v.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("WWW-Authenticate"), `Bearer error="invalid_token", error_description="no bearer token"`; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "WWW-Authenticate", got, want)
+	}
+
+	// A valid token is not rejected.
+	mod, err := NewModifier("HS256", []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	req = mint(t, mod, nil, time.Now())
+	ctx = martian.TestContext(req, nil, nil)
+	if err := v.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got true, want false")
+	}
+
+	res = proxyutil.NewResponse(200, nil, req)
+	if err := v.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+}
+
+func TestVerifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"jwt.Verifier": {
+			"scope": ["request", "response"],
+			"secrets": {"": "secret"},
+			"audience": ["api"],
+			"reject": true
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	v, ok := r.RequestModifier().(*Verifier)
+	if !ok {
+		t.Fatalf("r.RequestModifier(): got %T, want *Verifier", r.RequestModifier())
+	}
+	if r.ResponseModifier() == nil {
+		t.Error("r.ResponseModifier(): got nil, want not nil")
+	}
+	if !v.reject {
+		t.Error("v.reject: got false, want true")
+	}
+
+	for i, b := range []string{
+		`{"jwt.Verifier": {"scope": ["request"]}}`,
+		`{"jwt.Verifier": {"scope": ["request"], "secrets": {"": "secret"}, "reject": true}}`,
+		`{"jwt.Verifier": {"scope": ["request"], "publicKeys": {"": "not pem"}}}`,
+	} {
+		if _, err := parse.FromJSON([]byte(b)); err == nil {
+			t.Errorf("%d. parse.FromJSON(): got nil, want error", i)
+		}
+	}
+}
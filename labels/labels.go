@@ -0,0 +1,155 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package labels provides a modifier that attaches labels, such as
+// device=pixel7 or build=1234, to sessions so that logged traffic can be
+// sliced per test run.
+package labels
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("labels.Modifier", modifierFromJSON)
+}
+
+// DefaultHeader is the header from which labels are read by default.
+const DefaultHeader = "X-Martian-Labels"
+
+// Modifier sets labels on the session of each request: the labels it is
+// configured with, followed by those listed in the label header of the
+// request as comma-separated key=value pairs. The label header is removed
+// before the request is sent upstream.
+type Modifier struct {
+	header string
+
+	mu     sync.RWMutex
+	labels map[string]string
+}
+
+type modifierJSON struct {
+	Labels map[string]string    `json:"labels"`
+	Header string               `json:"header"`
+	Scope  []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a new labels modifier that reads labels from
+// DefaultHeader.
+func NewModifier() *Modifier {
+	return &Modifier{
+		header: DefaultHeader,
+	}
+}
+
+// SetHeader sets the header from which labels are read. An empty name
+// disables reading labels from requests.
+func (m *Modifier) SetHeader(name string) {
+	m.header = name
+}
+
+// SetLabels sets the labels attached to every session.
+func (m *Modifier) SetLabels(labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.labels = make(map[string]string, len(labels))
+	for k, v := range labels {
+		m.labels[k] = v
+	}
+}
+
+// ModifyRequest attaches the labels to the session of req.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	ctx := martian.NewContext(req)
+	if ctx == nil {
+		return nil
+	}
+	s := ctx.Session()
+
+	m.mu.RLock()
+	for k, v := range m.labels {
+		s.SetLabel(k, v)
+	}
+	m.mu.RUnlock()
+
+	if m.header == "" {
+		return nil
+	}
+
+	values := req.Header.Values(m.header)
+	if len(values) == 0 {
+		return nil
+	}
+	req.Header.Del(m.header)
+
+	labels, err := Parse(strings.Join(values, ","))
+	for k, v := range labels {
+		s.SetLabel(k, v)
+	}
+
+	return err
+}
+
+// Parse parses comma-separated key=value pairs, such as
+// "device=pixel7, build=1234". It returns the valid pairs along with an error
+// for the invalid ones.
+func Parse(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	var invalid []string
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" {
+			invalid = append(invalid, pair)
+			continue
+		}
+		labels[k] = v
+	}
+
+	if len(invalid) > 0 {
+		return labels, fmt.Errorf("labels: invalid labels %q, want key=value", invalid)
+	}
+
+	return labels, nil
+}
+
+// modifierFromJSON builds a labels.Modifier from JSON. "labels" are attached
+// to every session, "header" overrides the header labels are read from.
+//
+// Example JSON:
+//
+//	{
+//	  "labels.Modifier": {
+//	    "scope": ["request"],
+//	    "labels": {
+//	      "build": "1234"
+//	    }
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	m := NewModifier()
+	m.SetLabels(msg.Labels)
+	if msg.Header != "" {
+		m.SetHeader(msg.Header)
+	}
+
+	return parse.NewResult(m, msg.Scope)
+}
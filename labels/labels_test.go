@@ -0,0 +1,125 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package labels
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+)
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"device=pixel7", map[string]string{"device": "pixel7"}, false},
+		{" device = pixel7 , build=1234,", map[string]string{"device": "pixel7", "build": "1234"}, false},
+		{"device=pixel7, invalid, =x", map[string]string{"device": "pixel7"}, true},
+	}
+
+	for _, tc := range tt {
+		got, err := Parse(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Parse(%q): got error %v, want error %t", tc.in, err, tc.wantErr)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q): got %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestModifyRequest(t *testing.T) {
+	m := NewModifier()
+	m.SetLabels(map[string]string{
+		"build":  "1234",
+		"device": "default",
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set(DefaultHeader, "device=pixel7")
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	want := map[string]string{
+		"build":  "1234",
+		"device": "pixel7",
+	}
+	if got := ctx.Session().Labels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Session().Labels(): got %v, want %v", got, want)
+	}
+	if got := req.Header.Get(DefaultHeader); got != "" {
+		t.Errorf("req.Header.Get(%q): got %q, want no header", DefaultHeader, got)
+	}
+
+	// Labels of the header persist for the session.
+	req2, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	*req2 = *req2.WithContext(req.Context())
+
+	if err := m.ModifyRequest(req2); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got := ctx.Session().Labels(); got["build"] != "1234" {
+		t.Errorf("Session().Labels(): got %v, want build=1234", got)
+	}
+
+	req.Header.Set(DefaultHeader, "invalid")
+	if err := m.ModifyRequest(req); err == nil {
+		t.Error("ModifyRequest(): got nil error, want error for invalid labels")
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"labels.Modifier": {
+			"scope": ["request"],
+			"header": "Test-Labels",
+			"labels": {
+				"build": "1234"
+			}
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Test-Labels", "device=pixel7")
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	want := map[string]string{
+		"build":  "1234",
+		"device": "pixel7",
+	}
+	if got := ctx.Session().Labels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Session().Labels(): got %v, want %v", got, want)
+	}
+}
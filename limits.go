@@ -0,0 +1,93 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// headerLimitReader limits the number of bytes read from r while a request
+// header is read.
+type headerLimitReader struct {
+	r io.Reader
+	// remaining is the number of bytes that may be read, or -1 if reads are
+	// not limited.
+	remaining int64
+	hit       bool
+}
+
+func newHeaderLimitReader(r io.Reader) *headerLimitReader {
+	return &headerLimitReader{
+		r:         r,
+		remaining: -1,
+	}
+}
+
+func (l *headerLimitReader) Read(b []byte) (int, error) {
+	if l.remaining < 0 {
+		return l.r.Read(b)
+	}
+	if l.remaining == 0 {
+		l.hit = true
+		return 0, io.EOF
+	}
+
+	if int64(len(b)) > l.remaining {
+		b = b[:l.remaining]
+	}
+	n, err := l.r.Read(b)
+	l.remaining -= int64(n)
+
+	return n, err
+}
+
+// setLimit limits the following reads to n bytes, or lifts the limit if n is
+// negative.
+func (l *headerLimitReader) setLimit(n int64) {
+	l.remaining = n
+	l.hit = false
+}
+
+// limitHeader wraps r, the reader of the client connection of the session,
+// so that the size of the request headers read from it can be limited.
+func (s *Session) limitHeader(r io.Reader) io.Reader {
+	s.headerLimit = newHeaderLimitReader(r)
+	return s.headerLimit
+}
+
+func (p *Proxy) maxHeaderBytes() int64 {
+	if p.MaxHeaderBytes > 0 {
+		return int64(p.MaxHeaderBytes)
+	}
+	return http.DefaultMaxHeaderBytes
+}
+
+// defaultMaxBufferedBodyBytes is the default of Proxy.MaxBufferedBodyBytes.
+const defaultMaxBufferedBodyBytes = 10 << 20
+
+func (p *Proxy) maxBufferedBodyBytes() int64 {
+	if p.MaxBufferedBodyBytes > 0 {
+		return p.MaxBufferedBodyBytes
+	}
+	return defaultMaxBufferedBodyBytes
+}
+
+// checkHeaderCount returns an error if req has more header fields than
+// allowed by p.MaxHeaderCount.
+func (p *Proxy) checkHeaderCount(req *http.Request) error {
+	if p.MaxHeaderCount <= 0 {
+		return nil
+	}
+
+	n := 0
+	for _, vs := range req.Header {
+		n += len(vs)
+	}
+	if n > p.MaxHeaderCount {
+		return fmt.Errorf("request has %d header fields, limit is %d", n, p.MaxHeaderCount)
+	}
+
+	return nil
+}
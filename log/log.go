@@ -16,9 +16,13 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -34,17 +38,45 @@ const (
 
 // Default log level is Error.
 var (
-	level      = Error
-	lock       sync.Mutex
-	currLogger Logger = &logger{}
+	level        = Error
+	moduleLevels map[string]int
+	rateLimit    time.Duration
+	limited      map[limitKey]*limitState
+	lock         sync.Mutex
+	currLogger   Logger = &logger{}
 )
 
+// maxLimited is the number of messages tracked for rate limiting. Once
+// reached, entries that are no longer limited are removed, and messages that
+// do not fit are logged without being tracked.
+const maxLimited = 1024
+
+type limitKey struct {
+	level int
+	msg   string
+}
+
+type limitState struct {
+	last       time.Time
+	suppressed int
+}
+
 type Logger interface {
 	Infof(format string, args ...any)
 	Debugf(format string, args ...any)
 	Errorf(format string, args ...any)
 }
 
+// FieldLogger is a Logger that adds key-value pairs to the records it emits,
+// such as the logger returned by NewSlogLogger.
+type FieldLogger interface {
+	Logger
+
+	// With returns a Logger that adds the alternating keys and values of args
+	// to its records.
+	With(args ...any) Logger
+}
+
 // SetLogger changes the default logger. This must be called very first,
 // before interacting with rest of the martian package. Changing it at
 // runtime is not supported.
@@ -60,6 +92,193 @@ func SetLevel(l int) {
 	level = l
 }
 
+// With returns a logger that adds the alternating keys and values of args to
+// its records if the current logger is a FieldLogger, or the current logger
+// otherwise.
+func With(args ...any) Logger {
+	if fl, ok := currLogger.(FieldLogger); ok {
+		return fl.With(args...)
+	}
+	return currLogger
+}
+
+// SetModuleLevel sets the log level of the messages of module, overriding the
+// global log level. The module of a message is the prefix before the first
+// colon, such as "martian" for "martian: closing connection" or "mitm" for
+// "mitm: creating certificate". A negative level removes the override.
+func SetModuleLevel(module string, l int) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if l < 0 {
+		delete(moduleLevels, module)
+		return
+	}
+	if moduleLevels == nil {
+		moduleLevels = make(map[string]int)
+	}
+	moduleLevels[module] = l
+}
+
+// SetLevels sets the global and per module log levels from spec, a comma
+// separated list of levels and "module=level" pairs, such as
+// "error,mitm=debug,h2=info". Levels are "silent", "error", "info", "debug" or
+// their numeric values. Module levels not named in spec are kept.
+func SetLevels(spec string) error {
+	global := -1
+	modules := make(map[string]int)
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		module, name, ok := strings.Cut(f, "=")
+		if !ok {
+			module, name = "", f
+		}
+		l, err := ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		if module == "" {
+			global = l
+		} else {
+			modules[module] = l
+		}
+	}
+
+	if global >= 0 {
+		SetLevel(global)
+	}
+	for m, l := range modules {
+		SetModuleLevel(m, l)
+	}
+
+	return nil
+}
+
+// ParseLevel returns the log level named s, one of "silent", "error", "info"
+// and "debug", or given by its numeric value.
+func ParseLevel(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "silent":
+		return Silent, nil
+	case "error":
+		return Error, nil
+	case "info":
+		return Info, nil
+	case "debug":
+		return Debug, nil
+	}
+
+	if l, err := strconv.Atoi(s); err == nil && l >= Silent && l <= Debug {
+		return l, nil
+	}
+
+	return 0, fmt.Errorf("log: unknown level %q", s)
+}
+
+// SetRateLimit limits messages with the same level and text, such as
+// repeated errors for failing connections, to one per d. The number of
+// suppressed messages is added to the next message logged. A zero d disables
+// rate limiting. It applies to the default logger and the logger returned by
+// NewSlogLogger.
+func SetRateLimit(d time.Duration) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	rateLimit = d
+	limited = nil
+}
+
+// allow reports whether the formatted message msg at level l is logged under
+// the rate limit, and returns the number of messages suppressed since the
+// last one was logged. The lock must be held.
+func allow(l int, msg string) (int, bool) {
+	if rateLimit <= 0 {
+		return 0, true
+	}
+
+	now := time.Now()
+	k := limitKey{level: l, msg: msg}
+	st, ok := limited[k]
+	if !ok {
+		if limited == nil {
+			limited = make(map[limitKey]*limitState)
+		}
+		if len(limited) >= maxLimited {
+			for k, st := range limited {
+				if now.Sub(st.last) >= rateLimit {
+					delete(limited, k)
+				}
+			}
+			if len(limited) >= maxLimited {
+				return 0, true
+			}
+		}
+		limited[k] = &limitState{last: now}
+		return 0, true
+	}
+
+	if now.Sub(st.last) < rateLimit {
+		st.suppressed++
+		return 0, false
+	}
+
+	n := st.suppressed
+	st.last = now
+	st.suppressed = 0
+	return n, true
+}
+
+// enabled reports whether messages with the format at level l are logged.
+// The lock must be held.
+func enabled(l int, format string) bool {
+	if len(moduleLevels) > 0 {
+		if ml, ok := moduleLevels[moduleOf(format)]; ok {
+			return ml >= l
+		}
+	}
+
+	return level >= l
+}
+
+// moduleOf returns the module of a message, or an empty string if it has
+// none.
+func moduleOf(msg string) string {
+	m, _, ok := strings.Cut(msg, ": ")
+	if !ok || m == "" {
+		return ""
+	}
+	for _, c := range m {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return ""
+		}
+	}
+
+	return m
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying l, so that code handling a request
+// logs with the logger of the request, see FromContext.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the current logger if ctx
+// carries none.
+func FromContext(ctx context.Context) Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+			return l
+		}
+	}
+	return currLogger
+}
+
 // Infof logs an info message.
 func Infof(format string, args ...any) {
 	currLogger.Infof(format, args...)
@@ -75,51 +294,66 @@ func Errorf(format string, args ...any) {
 	currLogger.Errorf(format, args...)
 }
 
-type logger struct{}
-
-func (l *logger) Infof(format string, args ...any) {
-	lock.Lock()
-	defer lock.Unlock()
+// logger is the default logger. It writes the key-value pairs added with With
+// in brackets after the level.
+type logger struct {
+	attrs string
+}
 
-	if level < Info {
-		return
+func (l *logger) With(args ...any) Logger {
+	var b strings.Builder
+	b.WriteString(l.attrs)
+	for i := 0; i < len(args); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, "%v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, "%v", args[i])
+		}
 	}
 
-	msg := fmt.Sprintf("INFO: %s", format)
-	if len(args) > 0 {
-		msg = fmt.Sprintf(msg, args...)
-	}
+	return &logger{attrs: b.String()}
+}
 
-	log.Println(msg)
+func (l *logger) Infof(format string, args ...any) {
+	l.log(Info, "INFO", format, args)
 }
 
 func (l *logger) Debugf(format string, args ...any) {
+	l.log(Debug, "DEBUG", format, args)
+}
+
+func (l *logger) Errorf(format string, args ...any) {
+	l.log(Error, "ERROR", format, args)
+}
+
+// log logs the message at level lvl, prefixed by the level name and the
+// key-value pairs of the logger.
+func (l *logger) log(lvl int, name, format string, args []any) {
 	lock.Lock()
 	defer lock.Unlock()
 
-	if level < Debug {
+	if !enabled(lvl, format) {
 		return
 	}
 
-	msg := fmt.Sprintf("DEBUG: %s", format)
+	msg := format
 	if len(args) > 0 {
-		msg = fmt.Sprintf(msg, args...)
+		msg = fmt.Sprintf(format, args...)
 	}
-
-	log.Println(msg)
-}
-
-func (l *logger) Errorf(format string, args ...any) {
-	lock.Lock()
-	defer lock.Unlock()
-
-	if level < Error {
+	suppressed, ok := allow(lvl, msg)
+	if !ok {
 		return
 	}
 
-	msg := fmt.Sprintf("ERROR: %s", format)
-	if len(args) > 0 {
-		msg = fmt.Sprintf(msg, args...)
+	if l.attrs != "" {
+		msg = "[" + l.attrs + "] " + msg
+	}
+	msg = name + ": " + msg
+	if suppressed > 0 {
+		msg += fmt.Sprintf(" (%d similar messages suppressed)", suppressed)
 	}
 
 	log.Println(msg)
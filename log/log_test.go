@@ -16,9 +16,11 @@ package log
 
 import (
 	"bytes"
+	"context"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	stdlog "log"
 )
@@ -48,3 +50,112 @@ func TestLog(t *testing.T) {
 		t.Errorf("Errorf(): got %q, want to contain %q", got, want)
 	}
 }
+
+func TestModuleLevels(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	stdlog.SetOutput(buf)
+	defer stdlog.SetOutput(os.Stdout)
+
+	defer func(l int, ml map[string]int) { level, moduleLevels = l, ml }(level, moduleLevels)
+
+	if err := SetLevels("error, mitm=debug,h2=silent"); err != nil {
+		t.Fatalf("SetLevels(): got %v, want no error", err)
+	}
+
+	Debugf("mitm: debug test")
+	Debugf("martian: debug test")
+	Errorf("h2: error test")
+	Errorf("martian: error test")
+
+	want := []string{"DEBUG: mitm: debug test", "ERROR: martian: error test"}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("log lines: got %q, want %d lines", lines, len(want))
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(lines[i], w) {
+			t.Errorf("line %d: got %q, want to end with %q", i, lines[i], w)
+		}
+	}
+
+	if err := SetLevels("mitm=verbose"); err == nil {
+		t.Error("SetLevels(\"mitm=verbose\"): got nil error, want error")
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	stdlog.SetOutput(buf)
+	defer stdlog.SetOutput(os.Stdout)
+
+	defer func(l int) { level = l }(level)
+	level = Info
+
+	ctx := NewContext(context.Background(), With("session", "1a", "context", "2b"))
+	FromContext(ctx).Infof("martian: %d%% done", 100)
+	if got, want := buf.String(), "INFO: [session=1a context=2b] martian: 100% done\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("FromContext().Infof(): got %q, want to contain %q", got, want)
+	}
+
+	buf.Reset()
+	With("progress", "50%").Infof("martian: started")
+	if got, want := buf.String(), "INFO: [progress=50%] martian: started\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("With().Infof(): got %q, want to contain %q", got, want)
+	}
+
+		if got, want := FromContext(context.Background()), currLogger; got != want {
+		t.Errorf("FromContext(): got %v, want current logger %v", got, want)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	stdlog.SetOutput(buf)
+	defer stdlog.SetOutput(os.Stdout)
+
+	defer func(l int) { level = l }(level)
+	level = Error
+
+	SetRateLimit(time.Hour)
+	defer SetRateLimit(0)
+
+	for i := 0; i < 3; i++ {
+		Errorf("martian: error %d", 1)
+	}
+	Errorf("martian: error %d", 2)
+
+	want := []string{"ERROR: martian: error 1", "ERROR: martian: error 2"}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("log lines: got %q, want %d lines", lines, len(want))
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(lines[i], w) {
+			t.Errorf("line %d: got %q, want to end with %q", i, lines[i], w)
+		}
+	}
+
+	// Pretend the interval passed.
+	lock.Lock()
+	limited[limitKey{level: Error, msg: "martian: error 1"}].last = time.Now().Add(-time.Hour)
+	lock.Unlock()
+
+	buf.Reset()
+	Errorf("martian: error %d", 1)
+	if got, want := buf.String(), "ERROR: martian: error 1 (2 similar messages suppressed)\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("Errorf(): got %q, want to contain %q", got, want)
+	}
+
+	for i := 0; i < 2*maxLimited; i++ {
+		Errorf("martian: error %d", 100+i)
+	}
+	lock.Lock()
+	n := len(limited)
+	lock.Unlock()
+	if n > maxLimited {
+		t.Errorf("len(limited): got %d, want at most %d", n, maxLimited)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+//go:build go1.21
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// NewSlogLogger returns a logger that emits structured records via h, to be
+// set with SetLogger. The module of a message, the prefix before the first
+// colon as in "martian: closing connection", is removed from the message and
+// added as the "module" attribute. The level set with SetLevel applies in
+// addition to the levels enabled by h, see also SetModuleLevel.
+func NewSlogLogger(h slog.Handler) FieldLogger {
+	return &slogLogger{h: h}
+}
+
+type slogLogger struct {
+	h slog.Handler
+}
+
+func (l *slogLogger) With(args ...any) Logger {
+	return &slogLogger{h: slog.New(l.h).With(args...).Handler()}
+}
+
+func (l *slogLogger) Infof(format string, args ...any) {
+	l.log(Info, slog.LevelInfo, format, args)
+}
+
+func (l *slogLogger) Debugf(format string, args ...any) {
+	l.log(Debug, slog.LevelDebug, format, args)
+}
+
+func (l *slogLogger) Errorf(format string, args ...any) {
+	l.log(Error, slog.LevelError, format, args)
+}
+
+func (l *slogLogger) log(lvl int, slvl slog.Level, format string, args []any) {
+	ctx := context.Background()
+	if !l.h.Enabled(ctx, slvl) {
+		return
+	}
+
+	lock.Lock()
+	ok := enabled(lvl, format)
+	lock.Unlock()
+	if !ok {
+		return
+	}
+
+	msg := format
+	if len(args) > 0 {
+		msg = fmt.Sprintf(format, args...)
+	}
+
+	lock.Lock()
+	suppressed, ok := allow(lvl, msg)
+	lock.Unlock()
+	if !ok {
+		return
+	}
+
+	module := moduleOf(msg)
+	if module != "" {
+		msg = strings.TrimPrefix(msg, module+": ")
+	}
+
+	r := slog.NewRecord(time.Now(), slvl, msg, 0)
+	if module != "" {
+		r.AddAttrs(slog.String("module", module))
+	}
+	if suppressed > 0 {
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	l.h.Handle(ctx, r)
+}
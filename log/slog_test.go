@@ -0,0 +1,40 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+//go:build go1.21
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	defer func(l Logger) { currLogger = l }(currLogger)
+	defer func(l int) { level = l }(level)
+
+	buf := new(bytes.Buffer)
+	SetLogger(NewSlogLogger(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	level = Info
+
+	With("session", "1a").Infof("martian: %s test", "info")
+	Debugf("martian: debug test")
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal(): got %v, want no error", err)
+	}
+	want := map[string]string{
+		"level":   "INFO",
+		"msg":     "info test",
+		"module":  "martian",
+		"session": "1a",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("record[%q]: got %v, want %q", k, got[k], v)
+		}
+	}
+}
@@ -15,28 +15,153 @@
 package martianhttp
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
+	"fmt"
 	"net/http"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/google/martian/v3/mitm"
 )
 
 type authorityHandler struct {
-	cert []byte
+	ca func() *x509.Certificate
 }
 
 // NewAuthorityHandler returns an http.Handler that will present the client
 // with the CA certificate to use in browser.
+//
+// The format of the certificate is selected by the extension of the request
+// path or the "format" query parameter: "pem" (default), "der" or
+// "mobileconfig" for an iOS/macOS configuration profile that installs the
+// certificate.
 func NewAuthorityHandler(ca *x509.Certificate) http.Handler {
 	return &authorityHandler{
-		cert: pem.EncodeToMemory(&pem.Block{
+		ca: func() *x509.Certificate { return ca },
+	}
+}
+
+// NewConfigAuthorityHandler is like NewAuthorityHandler, but presents the
+// current CA certificate of mc, which may change with mc.Rotate.
+func NewConfigAuthorityHandler(mc *mitm.Config) http.Handler {
+	return &authorityHandler{
+		ca: mc.CA,
+	}
+}
+
+// ServeHTTP writes the CA certificate in the requested format to the client.
+func (h *authorityHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	ca := h.ca()
+
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = strings.TrimPrefix(path.Ext(req.URL.Path), ".")
+	}
+
+	switch format {
+	case "der":
+		rw.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		rw.Write(ca.Raw)
+	case "mobileconfig":
+		b, err := mobileConfig(ca)
+		if err != nil {
+			http.Error(rw, err.Error(), 500)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/x-apple-aspen-config")
+		rw.Header().Set("Content-Disposition", `attachment; filename="martian.mobileconfig"`)
+		rw.Write(b)
+	default:
+		rw.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		rw.Write(pem.EncodeToMemory(&pem.Block{
 			Type:  "CERTIFICATE",
 			Bytes: ca.Raw,
-		}),
+		}))
 	}
 }
 
-// ServeHTTP writes the CA certificate in PEM format to the client.
-func (h *authorityHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	rw.Header().Set("Content-Type", "application/x-x509-ca-cert")
-	rw.Write(h.cert)
+var mobileConfigTmpl = template.Must(template.New("mobileconfig").Funcs(template.FuncMap{
+	"xml": template.HTMLEscapeString,
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadCertificateFileName</key>
+			<string>authority.cer</string>
+			<key>PayloadContent</key>
+			<data>{{.Cert}}</data>
+			<key>PayloadDescription</key>
+			<string>Adds a CA root certificate</string>
+			<key>PayloadDisplayName</key>
+			<string>{{xml .Name}}</string>
+			<key>PayloadIdentifier</key>
+			<string>com.apple.security.root.{{.CertUUID}}</string>
+			<key>PayloadType</key>
+			<string>com.apple.security.root</string>
+			<key>PayloadUUID</key>
+			<string>{{.CertUUID}}</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+		</dict>
+	</array>
+	<key>PayloadDisplayName</key>
+	<string>{{xml .Name}}</string>
+	<key>PayloadIdentifier</key>
+	<string>martian.proxy.{{.UUID}}</string>
+	<key>PayloadRemovalDisallowed</key>
+	<false/>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>{{.UUID}}</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`))
+
+// mobileConfig returns a configuration profile that installs ca as a root
+// certificate. The payload identifiers are derived from the certificate, so
+// that downloading the profile again replaces the installed one.
+func mobileConfig(ca *x509.Certificate) ([]byte, error) {
+	sum := sha256.Sum256(ca.Raw)
+
+	name := ca.Subject.CommonName
+	if name == "" {
+		name = "Martian Proxy CA"
+	}
+
+	var buf bytes.Buffer
+	err := mobileConfigTmpl.Execute(&buf, struct {
+		Name, Cert, UUID, CertUUID string
+	}{
+		Name:     name,
+		Cert:     base64.StdEncoding.EncodeToString(ca.Raw),
+		UUID:     uuid(sum[:16]),
+		CertUUID: uuid(sum[16:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// uuid formats b as a version 4 variant 1 UUID.
+func uuid(b []byte) string {
+	u := make([]byte, 16)
+	copy(u, b)
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%X-%X-%X-%X-%X", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
 }
@@ -15,10 +15,13 @@
 package martianhttp
 
 import (
+	"bytes"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -60,3 +63,75 @@ func TestAuthorityHandler(t *testing.T) {
 		t.Errorf("cert.Subject.CommonName: got %q, want %q", got, want)
 	}
 }
+
+func TestAuthorityHandlerFormats(t *testing.T) {
+	ca, priv, err := mitm.NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+
+	mc, err := mitm.NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("mitm.NewConfig(): got %v, want no error", err)
+	}
+	h := NewConfigAuthorityHandler(mc)
+
+	tt := []struct {
+		url         string
+		contentType string
+	}{
+		{"/authority.der", "application/x-x509-ca-cert"},
+		{"/authority.cer?format=der", "application/x-x509-ca-cert"},
+		{"/authority.mobileconfig", "application/x-apple-aspen-config"},
+	}
+
+	for _, tc := range tt {
+		rw := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+
+		h.ServeHTTP(rw, req)
+
+		if got, want := rw.Code, 200; got != want {
+			t.Errorf("%s: rw.Code: got %d, want %d", tc.url, got, want)
+		}
+		if got, want := rw.Header().Get("Content-Type"), tc.contentType; got != want {
+			t.Errorf("%s: rw.Header().Get(%q): got %q, want %q", tc.url, "Content-Type", got, want)
+		}
+
+		switch tc.contentType {
+		case "application/x-x509-ca-cert":
+			if !bytes.Equal(rw.Body.Bytes(), ca.Raw) {
+				t.Errorf("%s: rw.Body: got %d bytes, want DER of CA", tc.url, rw.Body.Len())
+			}
+		default:
+			body := rw.Body.String()
+			if want := base64.StdEncoding.EncodeToString(ca.Raw); !strings.Contains(body, want) {
+				t.Errorf("%s: rw.Body: got %q, want to contain CA", tc.url, body)
+			}
+			if want := "<string>com.apple.security.root</string>"; !strings.Contains(body, want) {
+				t.Errorf("%s: rw.Body: got %q, want to contain %q", tc.url, body, want)
+			}
+		}
+	}
+
+	// The handler presents the rotated CA.
+	ca2, priv2, err := mitm.NewAuthority("martian.rotated", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+	mc.Rotate(ca2, priv2)
+
+	rw := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/authority.der", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	h.ServeHTTP(rw, req)
+
+	if !bytes.Equal(rw.Body.Bytes(), ca2.Raw) {
+		t.Error("rw.Body: got previous CA, want rotated CA")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/google/martian/v3/parse"
+)
+
+// Change is a difference between two modifier configurations.
+type Change struct {
+	// Op is "add", "remove" or "replace".
+	Op string `json:"op"`
+	// Path is the JSON pointer (RFC 6901) of the changed value, such as
+	// "/fifo.Group/modifiers/0/header.Modifier/value".
+	Path string `json:"path"`
+	// Old and New are the JSON values before and after the change, Old is
+	// unset for added values and New for removed values.
+	Old json.RawMessage `json:"old,omitempty"`
+	New json.RawMessage `json:"new,omitempty"`
+}
+
+// diffConfig returns the changes from the JSON configuration old to new,
+// sorted by path. An empty old configuration has no values.
+func diffConfig(old, new []byte) ([]Change, error) {
+	ov, err := flatten(old)
+	if err != nil {
+		return nil, err
+	}
+	nv, err := flatten(new)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	for p, o := range ov {
+		n, ok := nv[p]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Op: "remove", Path: p, Old: o})
+		case !bytes.Equal(o, n):
+			changes = append(changes, Change{Op: "replace", Path: p, Old: o, New: n})
+		}
+	}
+	for p, n := range nv {
+		if _, ok := ov[p]; !ok {
+			changes = append(changes, Change{Op: "add", Path: p, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes, nil
+}
+
+// flatten returns the leaf values of the JSON document b, that are neither
+// non-empty objects nor non-empty arrays, by JSON pointer.
+func flatten(b []byte) (map[string]json.RawMessage, error) {
+	leaves := make(map[string]json.RawMessage)
+	if len(bytes.TrimSpace(b)) == 0 {
+		return leaves, nil
+	}
+
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if err := flattenValue("", v, leaves); err != nil {
+		return nil, err
+	}
+
+	return leaves, nil
+}
+
+func flattenValue(path string, v any, leaves map[string]json.RawMessage) error {
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) > 0 {
+			for k, e := range v {
+				if err := flattenValue(path+"/"+parse.EscapePointer(k), e, leaves); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case []any:
+		if len(v) > 0 {
+			for i, e := range v {
+				if err := flattenValue(path+"/"+strconv.Itoa(i), e, leaves); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	leaves[path] = b
+
+	return nil
+}
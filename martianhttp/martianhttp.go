@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"sync"
 
@@ -36,6 +37,7 @@ type Modifier struct {
 	config []byte
 	reqmod martian.RequestModifier
 	resmod martian.ResponseModifier
+	expand bool
 }
 
 // NewModifier returns a new martianhttp.Modifier.
@@ -46,6 +48,18 @@ func NewModifier() *Modifier {
 	}
 }
 
+// SetExpandReferences sets whether the references to environment variables
+// and files in posted messages are expanded with parse.Expand. The messages
+// returned by GET requests keep the references. As the proxy then sends
+// their values, expanding should only be enabled when the API is reachable by
+// trusted clients.
+func (m *Modifier) SetExpandReferences(expand bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expand = expand
+}
+
 // SetRequestModifier sets the request modifier.
 func (m *Modifier) SetRequestModifier(reqmod martian.RequestModifier) {
 	m.mu.Lock()
@@ -143,8 +157,13 @@ func (m *Modifier) ResetResponseVerifications() {
 // ServeHTTP sets or retrieves the JSON-encoded modifier configuration
 // depending on request method. POST requests are expected to provide a JSON
 // modifier message in the body which will be used to update the contained
-// request and response modifiers. GET requests will return the JSON
-// (pretty-printed) for the most recent configuration.
+// request and response modifiers; messages with a YAML Content-Type, such as
+// "application/yaml", are converted with parse.YAMLToJSON first. The message
+// is validated fully before the modifiers are replaced together, so that an
+// invalid message leaves the current configuration unchanged; the response
+// is a JSON object with the list of changes from the previous configuration
+// under "changes". GET requests will return the JSON (pretty-printed) for the
+// most recent configuration.
 func (m *Modifier) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "POST":
@@ -168,11 +187,12 @@ func (m *Modifier) servePOST(rw http.ResponseWriter, req *http.Request) {
 	}
 	req.Body.Close()
 
-	r, err := parse.FromJSON(body)
-	if err != nil {
-		http.Error(rw, err.Error(), 400)
-		log.Errorf("martianhttp: error parsing JSON: %v", err)
-		return
+	if isYAML(req.Header.Get("Content-Type")) {
+		if body, err = parse.YAMLToJSON(body); err != nil {
+			http.Error(rw, err.Error(), 400)
+			log.Errorf("martianhttp: error converting YAML: %v", err)
+			return
+		}
 	}
 
 	buf := new(bytes.Buffer)
@@ -182,12 +202,58 @@ func (m *Modifier) servePOST(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.mu.RLock()
+	expand := m.expand
+	m.mu.RUnlock()
+
+	msg := body
+	if expand {
+		if msg, err = parse.Expand(body); err != nil {
+			http.Error(rw, err.Error(), 400)
+			log.Errorf("martianhttp: error expanding references: %v", err)
+			return
+		}
+	}
 
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		http.Error(rw, err.Error(), 400)
+		log.Errorf("martianhttp: error parsing JSON: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	old := m.config
 	m.config = buf.Bytes()
 	m.setRequestModifier(r.RequestModifier())
 	m.setResponseModifier(r.ResponseModifier())
+	m.mu.Unlock()
+
+	changes, err := diffConfig(old, buf.Bytes())
+	if err != nil {
+		log.Errorf("martianhttp: error computing configuration changes: %v", err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(struct {
+		Changes []Change `json:"changes"`
+	}{changes})
+}
+
+// isYAML reports whether the media type of the Content-Type ct is YAML.
+func isYAML(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+
+	switch mt {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	default:
+		return false
+	}
 }
 
 func (m *Modifier) serveGET(rw http.ResponseWriter, req *http.Request) {
@@ -26,6 +26,7 @@ import (
 	"github.com/google/martian/v3/proxyutil"
 	"github.com/google/martian/v3/verify"
 
+	_ "github.com/google/martian/v3/fifo"
 	_ "github.com/google/martian/v3/header"
 )
 
@@ -244,3 +245,149 @@ func TestServeHTTP(t *testing.T) {
 		t.Errorf("rw.Body: got %q, want %q", got.Bytes(), want.Bytes())
 	}
 }
+
+func TestServeHTTPChanges(t *testing.T) {
+	m := NewModifier()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/configure", bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		rw := httptest.NewRecorder()
+		m.ServeHTTP(rw, req)
+		return rw
+	}
+	changes := func(rw *httptest.ResponseRecorder) []Change {
+		var body struct {
+			Changes []Change `json:"changes"`
+		}
+		if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+			t.Fatalf("json.Unmarshal(): got %v, want no error", err)
+		}
+		return body.Changes
+	}
+
+	rw := post(`{"header.Modifier": {"scope": ["request"], "name": "Martian-Test", "value": "true"}}`)
+	if got, want := rw.Code, 200; got != want {
+		t.Fatalf("rw.Code: got %d, want %d", got, want)
+	}
+	if got, want := len(changes(rw)), 3; got != want {
+		t.Errorf("len(changes): got %d, want %d", got, want)
+	}
+
+	rw = post(`{"fifo.Group": {"scope": ["request"], "modifiers": [{"header.Modifier": {"name": "Martian-Test", "value": "false"}}, {"header.Nope": {}}]}}`)
+	if got, want := rw.Code, 400; got != want {
+		t.Fatalf("rw.Code: got %d, want %d", got, want)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("m.ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("Martian-Test"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "Martian-Test", got, want)
+	}
+
+	rw = post(`{"header.Modifier": {"scope": ["request", "response"], "name": "Martian-Test", "value": "false"}}`)
+	if got, want := rw.Code, 200; got != want {
+		t.Fatalf("rw.Code: got %d, want %d", got, want)
+	}
+	got, err := json.Marshal(changes(rw))
+	if err != nil {
+		t.Fatalf("json.Marshal(): got %v, want no error", err)
+	}
+	want := `[{"op":"add","path":"/header.Modifier/scope/1","new":"response"},` +
+		`{"op":"replace","path":"/header.Modifier/value","old":"true","new":"false"}]`
+	if string(got) != want {
+		t.Errorf("changes: got %s, want %s", got, want)
+	}
+}
+
+func TestServeHTTPYAML(t *testing.T) {
+	m := NewModifier()
+
+	body := "header.Modifier:\n  scope: [request]\n  name: Martian-Test\n  value: \"true\"\n"
+	req, err := http.NewRequest("POST", "/configure", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", "application/yaml")
+	rw := httptest.NewRecorder()
+
+	m.ServeHTTP(rw, req)
+	if got, want := rw.Code, 200; got != want {
+		t.Fatalf("rw.Code: got %d, want %d", got, want)
+	}
+
+	req, err = http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("m.ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("Martian-Test"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "Martian-Test", got, want)
+	}
+
+	req, err = http.NewRequest("GET", "/configure", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	rw = httptest.NewRecorder()
+	m.ServeHTTP(rw, req)
+
+	var config map[string]any
+	if err := json.Unmarshal(rw.Body.Bytes(), &config); err != nil {
+		t.Errorf("json.Unmarshal(): got %v, want configuration as JSON", err)
+	}
+}
+
+func TestServeHTTPExpandReferences(t *testing.T) {
+	t.Setenv("MARTIAN_TEST_VALUE", "expanded")
+
+	body := `{"header.Modifier": {"scope": ["request"], "name": "Martian-Test", "value": "${MARTIAN_TEST_VALUE}"}}`
+	for _, expand := range []bool{false, true} {
+		m := NewModifier()
+		m.SetExpandReferences(expand)
+
+		req, err := http.NewRequest("POST", "/configure", bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		rw := httptest.NewRecorder()
+		m.ServeHTTP(rw, req)
+		if got, want := rw.Code, 200; got != want {
+			t.Fatalf("rw.Code: got %d, want %d", got, want)
+		}
+
+		req, err = http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		if err := m.ModifyRequest(req); err != nil {
+			t.Fatalf("m.ModifyRequest(): got %v, want no error", err)
+		}
+		want := "${MARTIAN_TEST_VALUE}"
+		if expand {
+			want = "expanded"
+		}
+		if got := req.Header.Get("Martian-Test"); got != want {
+			t.Errorf("expand %t: req.Header.Get(%q): got %q, want %q", expand, "Martian-Test", got, want)
+		}
+
+		req, err = http.NewRequest("GET", "/configure", nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		rw = httptest.NewRecorder()
+		m.ServeHTTP(rw, req)
+		if !bytes.Contains(rw.Body.Bytes(), []byte("${MARTIAN_TEST_VALUE}")) {
+			t.Errorf("expand %t: GET /configure: got %s, want the reference kept", expand, rw.Body.Bytes())
+		}
+	}
+}
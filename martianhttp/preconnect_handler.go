@@ -0,0 +1,57 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianhttp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+)
+
+type preconnectHandler struct {
+	p *martian.Proxy
+}
+
+type preconnectJSON struct {
+	Origins []string `json:"origins"`
+}
+
+// NewPreconnectHandler returns an http.Handler that connects the proxy to
+// origins ahead of time with martian.Proxy.Preconnect. POST requests are
+// expected to provide a JSON message in the body listing the origins:
+//
+//	{
+//	  "origins": ["https://example.com", "http://example.com:8080"]
+//	}
+//
+// The response is sent once all connections have been attempted; failures are
+// reported with a 502 status and the errors in the body.
+func NewPreconnectHandler(p *martian.Proxy) http.Handler {
+	return &preconnectHandler{
+		p: p,
+	}
+}
+
+// ServeHTTP preconnects to the origins in the request body.
+func (h *preconnectHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		rw.Header().Set("Allow", "POST")
+		rw.WriteHeader(405)
+		return
+	}
+
+	msg := &preconnectJSON{}
+	if err := json.NewDecoder(req.Body).Decode(msg); err != nil {
+		http.Error(rw, err.Error(), 400)
+		log.Errorf("martianhttp: error parsing JSON: %v", err)
+		return
+	}
+
+	if err := h.p.Preconnect(req.Context(), msg.Origins...); err != nil {
+		http.Error(rw, err.Error(), 502)
+		log.Errorf("martianhttp: error preconnecting: %v", err)
+		return
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianhttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3"
+)
+
+func TestPreconnectHandler(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): got %v, want no error", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan struct{}, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		accepted <- struct{}{}
+	}()
+
+	p := martian.NewProxy()
+	defer p.Close()
+
+	h := NewPreconnectHandler(p)
+
+	tt := []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{"GET", "", 405},
+		{"POST", "not json", 400},
+		{"POST", `{"origins": ["ftp://example.com"]}`, 502},
+		{"POST", `{"origins": ["http://` + l.Addr().String() + `"]}`, 200},
+	}
+
+	for _, tc := range tt {
+		req, err := http.NewRequest(tc.method, "/preconnect", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if got := rw.Code; got != tc.want {
+			t.Errorf("%s %s: rw.Code: got %d, want %d", tc.method, tc.body, got, tc.want)
+		}
+	}
+
+	<-accepted
+}
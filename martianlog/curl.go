@@ -0,0 +1,103 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianlog
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SetCurlProxy sets the proxy URL passed with --proxy in the curl commands of
+// CurlLogMode, so that the commands send the requests through the proxy.
+func (l *Logger) SetCurlProxy(proxyURL string) {
+	l.curlProxy = proxyURL
+}
+
+// logCurl logs req as a curl command. The body of req is read and replaced
+// with an in-memory copy.
+func (l *Logger) logCurl(req *http.Request) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("curl")
+	if l.curlProxy != "" {
+		fmt.Fprintf(b, " --proxy %s", shellQuote([]byte(l.curlProxy)))
+	}
+	if req.Method != http.MethodGet || len(body) > 0 {
+		fmt.Fprintf(b, " -X %s", shellQuote([]byte(req.Method)))
+	}
+	fmt.Fprintf(b, " %s", shellQuote([]byte(req.URL.String())))
+
+	if req.Host != "" && req.Host != req.URL.Host {
+		fmt.Fprintf(b, " \\\n  -H %s", shellQuote([]byte("Host: "+req.Host)))
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for n := range req.Header {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if n == "Content-Length" {
+			continue
+		}
+		for _, v := range req.Header[n] {
+			v, _ = l.redactHeader(n, v)
+			fmt.Fprintf(b, " \\\n  -H %s", shellQuote([]byte(n+": "+v)))
+		}
+	}
+
+	if len(body) > 0 {
+		fmt.Fprintf(b, " \\\n  --data-binary %s", shellQuote(l.redactJSON(body)))
+	}
+
+	l.logLine(req, b.String())
+
+	return nil
+}
+
+// shellQuote quotes s for POSIX shells, using ANSI-C quoting for values that
+// are not printable UTF-8.
+func shellQuote(s []byte) string {
+	printable := utf8.Valid(s)
+	for _, c := range s {
+		if c < ' ' && c != '\n' && c != '\t' || c == 0x7f {
+			printable = false
+			break
+		}
+	}
+
+	if printable {
+		return "'" + strings.ReplaceAll(string(s), "'", `'\''`) + "'"
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("$'")
+	for _, c := range s {
+		switch {
+		case c == '\'' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= ' ' && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(b, `\x%02x`, c)
+		}
+	}
+	b.WriteString("'")
+
+	return b.String()
+}
@@ -0,0 +1,38 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianlog
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/google/martian/v3/graphql"
+)
+
+// SetGraphQL sets whether the operation of GraphQL requests, see
+// graphql.FromRequest, is logged: as a "GraphQL:" line after the URL, or as
+// the "graphql" member of the entries of JSONLogMode.
+func (l *Logger) SetGraphQL(enabled bool) {
+	l.graphql = enabled
+}
+
+// graphQLOperation returns the GraphQL operation of req, or nil if logging
+// operations is disabled or req is not a GraphQL request.
+func (l *Logger) graphQLOperation(req *http.Request) *graphql.Operation {
+	if !l.graphql {
+		return nil
+	}
+
+	// Requests that fail to parse are logged like other requests.
+	op, _ := graphql.FromRequest(req)
+	return op
+}
+
+// writeGraphQL writes the GraphQL operation of req, if any, as a "GraphQL:"
+// line.
+func (l *Logger) writeGraphQL(b *bytes.Buffer, req *http.Request) {
+	if op := l.graphQLOperation(req); op != nil {
+		fmt.Fprintf(b, "GraphQL: %s\n", op)
+	}
+}
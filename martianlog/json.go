@@ -0,0 +1,188 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianlog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/graphql"
+	"github.com/google/martian/v3/log"
+)
+
+// LogMode determines what the logger logs for requests and responses.
+type LogMode int
+
+const (
+	// BodyLogMode logs requests and responses as text including the bodies.
+	BodyLogMode LogMode = iota
+	// HeaderLogMode logs requests and responses as text without the bodies,
+	// as SetHeadersOnly(true).
+	HeaderLogMode
+	// JSONLogMode logs one JSON object per request and response pair, see
+	// JSONEntry.
+	JSONLogMode
+	// CurlLogMode logs each request as a runnable curl command, see
+	// SetCurlProxy. Responses are not logged.
+	CurlLogMode
+)
+
+// SetMode sets the log mode.
+func (l *Logger) SetMode(m LogMode) {
+	l.headersOnly = m == HeaderLogMode
+	l.json = m == JSONLogMode
+	l.curl = m == CurlLogMode
+}
+
+// SetJSONHeaders sets the names of the request and response headers included
+// in the JSON objects of JSONLogMode.
+func (l *Logger) SetJSONHeaders(names ...string) {
+	l.jsonHeaders = make([]string, len(names))
+	for i, n := range names {
+		l.jsonHeaders[i] = http.CanonicalHeaderKey(n)
+	}
+}
+
+// JSONEntry is the JSON object logged for a request and response pair in
+// JSONLogMode. It is logged once the response body is closed.
+type JSONEntry struct {
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"sessionId"`
+	ContextID string    `json:"contextId"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	// RequestSize and ResponseSize are the number of body bytes read.
+	RequestSize  int64 `json:"requestSize"`
+	ResponseSize int64 `json:"responseSize"`
+	// ResponseMillis is the time from the request to the response headers,
+	// DurationMillis the time to the end of the response body.
+	ResponseMillis float64 `json:"responseMs"`
+	DurationMillis float64 `json:"durationMs"`
+	// RequestHeaders and ResponseHeaders contain the headers selected with
+	// SetJSONHeaders.
+	RequestHeaders  map[string][]string `json:"requestHeaders,omitempty"`
+	ResponseHeaders map[string][]string `json:"responseHeaders,omitempty"`
+	Labels          map[string]string   `json:"labels,omitempty"`
+	// GraphQL is the operation of GraphQL requests, see SetGraphQL.
+	GraphQL *graphql.Operation `json:"graphql,omitempty"`
+}
+
+// jsonEntryKey is the context key of the JSON entry of a request.
+const jsonEntryKey = "martianlog.Logger.jsonEntry"
+
+type jsonRequest struct {
+	entry *JSONEntry
+	start time.Time
+	body  *countingBody
+}
+
+func (l *Logger) recordJSON(ctx *martian.Context, req *http.Request) {
+	r := &jsonRequest{
+		entry: &JSONEntry{
+			SessionID:      ctx.Session().ID(),
+			ContextID:      ctx.ID(),
+			Method:         req.Method,
+			URL:            req.URL.String(),
+			Proto:          req.Proto,
+			RequestHeaders: l.selectHeaders(req.Header),
+			Labels:         ctx.Session().Labels(),
+		},
+		start: time.Now(),
+	}
+	r.entry.Time = r.start
+	r.entry.GraphQL = l.graphQLOperation(req)
+
+	if req.Body != nil && req.Body != http.NoBody {
+		r.body = &countingBody{ReadCloser: req.Body}
+		req.Body = r.body
+	}
+
+	ctx.Set(jsonEntryKey, r)
+}
+
+func (l *Logger) logJSON(ctx *martian.Context, res *http.Response) {
+	v, ok := ctx.Get(jsonEntryKey)
+	if !ok {
+		return
+	}
+	r := v.(*jsonRequest)
+
+	e := r.entry
+	e.Status = res.StatusCode
+	e.ResponseMillis = millisSince(r.start)
+	e.ResponseHeaders = l.selectHeaders(res.Header)
+
+	done := func(n int64) {
+		if r.body != nil {
+			e.RequestSize = r.body.count()
+		}
+		e.ResponseSize = n
+		e.DurationMillis = millisSince(r.start)
+
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Errorf("martianlog: failed to marshal JSON log entry: %v", err)
+			return
+		}
+		l.logLine(res.Request, string(b))
+	}
+
+	if res.Body == nil || res.Body == http.NoBody {
+		done(0)
+		return
+	}
+	res.Body = &countingBody{ReadCloser: res.Body, done: done}
+}
+
+func (l *Logger) selectHeaders(h http.Header) map[string][]string {
+	var sel map[string][]string
+	for _, n := range l.jsonHeaders {
+		if vs, ok := h[n]; ok {
+			if sel == nil {
+				sel = make(map[string][]string)
+			}
+			sel[n] = vs
+		}
+	}
+	return l.redactHeaders(sel)
+}
+
+func millisSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
+
+// countingBody counts the bytes read from the body and calls done, if set,
+// with the count when closed.
+type countingBody struct {
+	io.ReadCloser
+	n    atomic.Int64
+	once sync.Once
+	done func(n int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.done != nil {
+		b.once.Do(func() {
+			b.done(b.count())
+		})
+	}
+	return err
+}
+
+func (b *countingBody) count() int64 {
+	return b.n.Load()
+}
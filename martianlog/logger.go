@@ -19,9 +19,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/log"
@@ -34,28 +36,77 @@ type Logger struct {
 	log         func(line string)
 	headersOnly bool
 	decode      bool
+	sampleRate  int
+	count       atomic.Uint64
+	json        bool
+	jsonHeaders []string
+	curl        bool
+	curlProxy   string
+	maxBody     int
+	hexMax      int
+	protos      *messageview.ProtoTypes
+	graphql     bool
+	redact      redaction
 }
 
 type loggerJSON struct {
 	Scope       []parse.ModifierType `json:"scope"`
 	HeadersOnly bool                 `json:"headersOnly"`
 	Decode      bool                 `json:"decode"`
+	SampleRate  int                  `json:"sampleRate"`
+	JSON        bool                 `json:"json"`
+	Curl        bool                 `json:"curl"`
+	CurlProxy   string               `json:"curlProxy"`
+	MaxBodyLog  int                  `json:"maxBodyLog"`
+	HexDumpMax  *int                 `json:"hexDumpMax"`
+	Protos      []string             `json:"descriptorSets"`
+	GraphQL     bool                 `json:"graphql"`
+	Headers     []string             `json:"headers"`
+	Redact      *redactionJSON       `json:"redact"`
 }
 
+type redactionJSON struct {
+	Headers    []string `json:"headers"`
+	Cookies    []string `json:"cookies"`
+	JSONFields []string `json:"jsonFields"`
+	Patterns   []string `json:"patterns"`
+}
+
+// sampledKey is the context key of the sampling decision of a request.
+const sampledKey = "martianlog.Logger.sampled"
+
 func init() {
 	parse.Register("log.Logger", loggerFromJSON)
 }
 
 // NewLogger returns a logger that logs requests and responses, optionally
-// logging the body. Log function defaults to martian.Infof.
+// logging the body. Log function defaults to log.Infof of the logger of the
+// request, which includes the IDs of the session and context.
 func NewLogger() *Logger {
 	return &Logger{
-		log: func(line string) {
-			log.Infof(line)
-		},
+		hexMax: defaultHexDumpMax,
 	}
 }
 
+// defaultHexDumpMax is the number of bytes of binary bodies logged as a
+// hexdump unless set with SetHexDumpMax.
+const defaultHexDumpMax = 1024
+
+// SetHexDumpMax sets the number of bytes of bodies with a binary content type,
+// such as images or protocol buffers, that are logged as a hexdump instead of
+// the raw bytes. The default is 1024; a negative n logs binary bodies as raw
+// bytes.
+func (l *Logger) SetHexDumpMax(n int) {
+	l.hexMax = n
+}
+
+// SetProtoTypes sets the message types used to log protocol buffer and gRPC
+// bodies in the text format, see messageview.ProtoReader. Bodies of unknown
+// types are logged as a hexdump.
+func (l *Logger) SetProtoTypes(pt *messageview.ProtoTypes) {
+	l.protos = pt
+}
+
 // SetHeadersOnly sets whether to log the request/response body in the log.
 func (l *Logger) SetHeadersOnly(headersOnly bool) {
 	l.headersOnly = headersOnly
@@ -66,12 +117,36 @@ func (l *Logger) SetDecode(decode bool) {
 	l.decode = decode
 }
 
+// SetSampleRate sets the logger to log only one in n requests, along with
+// their responses, to reduce the volume of logs of busy proxies. Values less
+// than 2 log all requests.
+func (l *Logger) SetSampleRate(n int) {
+	l.sampleRate = n
+}
+
+// sampled reports whether the request of ctx is logged under the sample rate,
+// deciding for new requests.
+func (l *Logger) sampled(ctx *martian.Context) bool {
+	if l.sampleRate < 2 {
+		return true
+	}
+	if v, ok := ctx.Get(sampledKey); ok {
+		return v.(bool)
+	}
+
+	s := l.count.Add(1)%uint64(l.sampleRate) == 1
+	ctx.Set(sampledKey, s)
+	return s
+}
+
 // SetLogFunc sets the logging function for the logger.
 func (l *Logger) SetLogFunc(logFunc func(line string)) {
 	l.log = logFunc
 }
 
-// ModifyRequest logs the request, optionally including the body.
+// ModifyRequest logs the request, optionally including the body. The labels of
+// the session and the GraphQL operation, see SetGraphQL, if any, are logged
+// after the URL.
 //
 // The format logged is:
 // --------------------------------------------------------------------------------
@@ -86,17 +161,30 @@ func (l *Logger) SetLogFunc(logFunc func(line string)) {
 // --------------------------------------------------------------------------------
 func (l *Logger) ModifyRequest(req *http.Request) error {
 	ctx := martian.NewContext(req)
-	if ctx.SkippingLogging() {
+	if ctx.SkippingLogging() || !l.sampled(ctx) {
 		return nil
 	}
+	if l.json {
+		l.recordJSON(ctx, req)
+		return nil
+	}
+	if l.curl {
+		return l.logCurl(req)
+	}
 
 	b := &bytes.Buffer{}
 
 	fmt.Fprintln(b, "")
 	fmt.Fprintln(b, strings.Repeat("-", 80))
 	fmt.Fprintf(b, "Request to %s\n", req.URL)
+	writeLabels(b, ctx)
+	l.writeGraphQL(b, req)
 	fmt.Fprintln(b, strings.Repeat("-", 80))
 
+	if l.maxBody > 0 && !l.headersOnly {
+		return l.logTruncated(b, req, nil)
+	}
+
 	mv := messageview.New()
 	mv.SkipBody(l.headersOnly)
 	if err := mv.SnapshotRequest(req); err != nil {
@@ -107,23 +195,28 @@ func (l *Logger) ModifyRequest(req *http.Request) error {
 	if l.decode {
 		opts = append(opts, messageview.Decode())
 	}
+	if l.protos != nil {
+		opts = append(opts, messageview.ProtoReader(l.protos))
+	}
+	if l.hexMax >= 0 {
+		opts = append(opts, messageview.HexReader(l.hexMax))
+	}
 
-	r, err := mv.Reader(opts...)
-	if err != nil {
+	if err := l.writeMessage(b, mv, opts); err != nil {
 		return err
 	}
 
-	io.Copy(b, r)
-
 	fmt.Fprintln(b, "")
 	fmt.Fprintln(b, strings.Repeat("-", 80))
 
-	l.log(b.String())
+	l.logLine(req, b.String())
 
 	return nil
 }
 
-// ModifyResponse logs the response, optionally including the body.
+// ModifyResponse logs the response, optionally including the body. The labels
+// of the session and the GraphQL operation, see SetGraphQL, if any, are logged
+// after the URL.
 //
 // The format logged is:
 // --------------------------------------------------------------------------------
@@ -137,7 +230,14 @@ func (l *Logger) ModifyRequest(req *http.Request) error {
 // --------------------------------------------------------------------------------
 func (l *Logger) ModifyResponse(res *http.Response) error {
 	ctx := martian.NewContext(res.Request)
-	if ctx.SkippingLogging() {
+	if ctx.SkippingLogging() || !l.sampled(ctx) {
+		return nil
+	}
+	if l.json {
+		l.logJSON(ctx, res)
+		return nil
+	}
+	if l.curl {
 		return nil
 	}
 
@@ -145,8 +245,14 @@ func (l *Logger) ModifyResponse(res *http.Response) error {
 	fmt.Fprintln(b, "")
 	fmt.Fprintln(b, strings.Repeat("-", 80))
 	fmt.Fprintf(b, "Response from %s\n", res.Request.URL)
+	writeLabels(b, ctx)
+	l.writeGraphQL(b, res.Request)
 	fmt.Fprintln(b, strings.Repeat("-", 80))
 
+	if l.maxBody > 0 && !l.headersOnly {
+		return l.logTruncated(b, res.Request, res)
+	}
+
 	mv := messageview.New()
 	mv.SkipBody(l.headersOnly)
 	if err := mv.SnapshotResponse(res); err != nil {
@@ -157,22 +263,53 @@ func (l *Logger) ModifyResponse(res *http.Response) error {
 	if l.decode {
 		opts = append(opts, messageview.Decode())
 	}
+	if l.protos != nil {
+		opts = append(opts, messageview.ProtoReader(l.protos))
+	}
+	if l.hexMax >= 0 {
+		opts = append(opts, messageview.HexReader(l.hexMax))
+	}
 
-	r, err := mv.Reader(opts...)
-	if err != nil {
+	if err := l.writeMessage(b, mv, opts); err != nil {
 		return err
 	}
 
-	io.Copy(b, r)
-
 	fmt.Fprintln(b, "")
 	fmt.Fprintln(b, strings.Repeat("-", 80))
 
-	l.log(b.String())
+	l.logLine(res.Request, b.String())
 
 	return nil
 }
 
+// logLine logs line with the log function, or the logger of req if none is
+// set, after redacting the matches of the patterns.
+func (l *Logger) logLine(req *http.Request, line string) {
+	line = l.redactPatterns(line)
+	if l.log != nil {
+		l.log(line)
+		return
+	}
+	log.FromContext(req.Context()).Infof(line)
+}
+
+// writeLabels writes the labels of the session of ctx as a "Labels:" line
+// of sorted key=value pairs.
+func writeLabels(b *bytes.Buffer, ctx *martian.Context) {
+	labels := ctx.Session().Labels()
+	if len(labels) == 0 {
+		return
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	fmt.Fprintf(b, "Labels: %s\n", strings.Join(pairs, ", "))
+}
+
 // loggerFromJSON builds a logger from JSON.
 //
 // Example JSON:
@@ -180,7 +317,22 @@ func (l *Logger) ModifyResponse(res *http.Response) error {
 //   "log.Logger": {
 //     "scope": ["request", "response"],
 //		 "headersOnly": true,
-//		 "decode": true
+//		 "decode": true,
+//		 "sampleRate": 10,
+//		 "maxBodyLog": 4096,
+//		 "hexDumpMax": 1024,
+//		 "descriptorSets": ["service.protoset"],
+//		 "graphql": true,
+//		 "json": false,
+//		 "curl": false,
+//		 "curlProxy": "http://localhost:8080",
+//		 "headers": ["User-Agent"],
+//		 "redact": {
+//		   "headers": ["Authorization"],
+//		   "cookies": ["session"],
+//		   "jsonFields": ["user.password"],
+//		   "patterns": ["token=[^&]*"]
+//		 }
 //   }
 // }
 func loggerFromJSON(b []byte) (*parse.Result, error) {
@@ -192,6 +344,41 @@ func loggerFromJSON(b []byte) (*parse.Result, error) {
 	l := NewLogger()
 	l.SetHeadersOnly(msg.HeadersOnly)
 	l.SetDecode(msg.Decode)
+	l.SetSampleRate(msg.SampleRate)
+	l.SetMaxBodyLog(msg.MaxBodyLog)
+	if msg.HexDumpMax != nil {
+		l.SetHexDumpMax(*msg.HexDumpMax)
+	}
+	if len(msg.Protos) > 0 {
+		pt := messageview.NewProtoTypes()
+		for _, path := range msg.Protos {
+			if err := pt.LoadDescriptorSet(path); err != nil {
+				return nil, err
+			}
+		}
+		l.SetProtoTypes(pt)
+	}
+	if msg.JSON {
+		l.SetMode(JSONLogMode)
+	}
+	if msg.Curl {
+		l.SetMode(CurlLogMode)
+		l.SetCurlProxy(msg.CurlProxy)
+	}
+	l.SetGraphQL(msg.GraphQL)
+	l.SetJSONHeaders(msg.Headers...)
+	if r := msg.Redact; r != nil {
+		l.RedactHeaders(r.Headers...)
+		l.RedactCookies(r.Cookies...)
+		l.RedactJSONFields(r.JSONFields...)
+		for _, p := range r.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, err
+			}
+			l.RedactPattern(re)
+		}
+	}
 
 	return parse.NewResult(l, msg.Scope)
 }
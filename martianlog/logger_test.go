@@ -17,8 +17,14 @@ package martianlog
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -89,6 +95,457 @@ func ExampleLogger() {
 	// --------------------------------------------------------------------------------
 }
 
+func TestLoggerLabels(t *testing.T) {
+	var lines []string
+	l := NewLogger()
+	l.SetHeadersOnly(true)
+	l.SetLogFunc(func(line string) {
+		lines = append(lines, line)
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	ctx := martian.TestContext(req, nil, nil)
+	ctx.Session().SetLabel("device", "pixel7")
+	ctx.Session().SetLabel("build", "1234")
+
+	if err := l.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if err := l.ModifyResponse(proxyutil.NewResponse(200, nil, req)); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("len(lines): got %d, want %d", got, want)
+	}
+	for _, line := range lines {
+		if want := "Labels: build=1234, device=pixel7\n"; !strings.Contains(line, want) {
+			t.Errorf("line: got %q, want to contain %q", line, want)
+		}
+	}
+}
+
+func TestLoggerSampleRate(t *testing.T) {
+	var lines []string
+	l := NewLogger()
+	l.SetHeadersOnly(true)
+	l.SetSampleRate(3)
+	l.SetLogFunc(func(line string) {
+		lines = append(lines, line)
+	})
+
+	for i := 0; i < 6; i++ {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://example.com/%d", i), nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		martian.TestContext(req, nil, nil)
+
+		if err := l.ModifyRequest(req); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+		if err := l.ModifyResponse(proxyutil.NewResponse(200, nil, req)); err != nil {
+			t.Fatalf("ModifyResponse(): got %v, want no error", err)
+		}
+	}
+
+	want := []string{
+		"Request to http://example.com/0\n",
+		"Response from http://example.com/0\n",
+		"Request to http://example.com/3\n",
+		"Response from http://example.com/3\n",
+	}
+	if got := len(lines); got != len(want) {
+		t.Fatalf("len(lines): got %d, want %d", got, len(want))
+	}
+	for i, w := range want {
+		if !strings.Contains(lines[i], w) {
+			t.Errorf("lines[%d]: got %q, want to contain %q", i, lines[i], w)
+		}
+	}
+}
+
+func TestLoggerJSONMode(t *testing.T) {
+	var lines []string
+	l := NewLogger()
+	l.SetMode(JSONLogMode)
+	l.SetJSONHeaders("user-agent", "Content-Type")
+	l.SetLogFunc(func(line string) {
+		lines = append(lines, line)
+	})
+
+	req, err := http.NewRequest("POST", "http://example.com/path", strings.NewReader("request"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("Authorization", "secret")
+	ctx := martian.TestContext(req, nil, nil)
+	ctx.Session().SetLabel("device", "pixel7")
+
+	if err := l.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if _, err := ioutil.ReadAll(req.Body); err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(201, strings.NewReader("response!"), req)
+	res.Header.Set("Content-Type", "text/plain")
+	if err := l.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got := len(lines); got != 0 {
+		t.Fatalf("len(lines) before body closed: got %d, want 0", got)
+	}
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	res.Body.Close()
+
+	if got, want := len(lines), 1; got != want {
+		t.Fatalf("len(lines): got %d, want %d", got, want)
+	}
+
+	var e JSONEntry
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatalf("json.Unmarshal(): got %v, want no error", err)
+	}
+	if got, want := e.SessionID, ctx.Session().ID(); got != want {
+		t.Errorf("SessionID: got %q, want %q", got, want)
+	}
+	if got, want := e.Method+" "+e.URL, "POST http://example.com/path"; got != want {
+		t.Errorf("request: got %q, want %q", got, want)
+	}
+	if got, want := e.Status, 201; got != want {
+		t.Errorf("Status: got %d, want %d", got, want)
+	}
+	if got, want := e.RequestSize, int64(7); got != want {
+		t.Errorf("RequestSize: got %d, want %d", got, want)
+	}
+	if got, want := e.ResponseSize, int64(9); got != want {
+		t.Errorf("ResponseSize: got %d, want %d", got, want)
+	}
+	wantReq := map[string][]string{"User-Agent": {"test-agent"}}
+	if got := e.RequestHeaders; !reflect.DeepEqual(got, wantReq) {
+		t.Errorf("RequestHeaders: got %v, want %v", got, wantReq)
+	}
+	wantRes := map[string][]string{"Content-Type": {"text/plain"}}
+	if got := e.ResponseHeaders; !reflect.DeepEqual(got, wantRes) {
+		t.Errorf("ResponseHeaders: got %v, want %v", got, wantRes)
+	}
+	if got, want := e.Labels["device"], "pixel7"; got != want {
+		t.Errorf("Labels[device]: got %q, want %q", got, want)
+	}
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	var lines []string
+	l := NewLogger()
+	l.SetLogFunc(func(line string) {
+		lines = append(lines, line)
+	})
+	l.RedactHeaders("authorization")
+	l.RedactCookies("session")
+	l.RedactJSONFields("user.password", "items.*.token")
+	l.RedactPattern(regexp.MustCompile(`key=[^&\s]*`))
+
+	body := `{"user":{"name":"alice","password":"secret"},"items":[{"token":"t1"},{"token":"t2"}]}`
+	req, err := http.NewRequest("POST", "http://example.com/path?key=abc", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret; theme=dark")
+	martian.TestContext(req, nil, nil)
+
+	if err := l.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	res.Header.Set("Set-Cookie", "session=secret; Path=/; HttpOnly")
+	if err := l.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("len(lines): got %d, want %d", got, want)
+	}
+	log := strings.Join(lines, "")
+	if strings.Contains(log, "secret") || strings.Contains(log, "t1") || strings.Contains(log, "abc") {
+		t.Errorf("log: got %q, want secrets redacted", log)
+	}
+	for _, want := range []string{
+		"Request to http://example.com/path?[REDACTED]\n",
+		"Authorization: [REDACTED]\r\n",
+		"Cookie: session=[REDACTED]; theme=dark\r\n",
+		`{"items":[{"token":"[REDACTED]"},{"token":"[REDACTED]"}],"user":{"name":"alice","password":"[REDACTED]"}}`,
+		"Set-Cookie: session=[REDACTED]; Path=/; HttpOnly\r\n",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("log: got %q, want to contain %q", log, want)
+		}
+	}
+
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if string(got) != body {
+		t.Errorf("req.Body: got %q, want unmodified %q", got, body)
+	}
+	if got, want := req.Header.Get("Authorization"), "Bearer secret"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want unmodified %q", "Authorization", got, want)
+	}
+}
+
+func TestLoggerMaxBodyLogRedaction(t *testing.T) {
+	var lines []string
+	l := NewLogger()
+	l.SetMaxBodyLog(48)
+	l.RedactJSONFields("password")
+	l.SetLogFunc(func(line string) {
+		lines = append(lines, line)
+	})
+
+	body := `{"user":"alice","password":"secret","padding":"` + strings.Repeat("x", 64) + `"}`
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	martian.TestContext(req, nil, nil)
+
+	if err := l.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if _, err := ioutil.ReadAll(req.Body); err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	req.Body.Close()
+
+	if got, want := len(lines), 1; got != want {
+		t.Fatalf("len(lines): got %d, want %d", got, want)
+	}
+	if strings.Contains(lines[0], "secret") {
+		t.Errorf("lines[0]: got %q, want the password redacted", lines[0])
+	}
+	sum := sha256.Sum256([]byte(body))
+	if want := fmt.Sprintf("[truncated: %d bytes total, sha256 %x]", len(body), sum); !strings.Contains(lines[0], want) {
+		t.Errorf("lines[0]: got %q, want to contain %q", lines[0], want)
+	}
+
+	// Bodies that are not JSON are still logged truncated.
+	lines = nil
+	req, err = http.NewRequest("POST", "http://example.com/", strings.NewReader(strings.Repeat("text ", 10)))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	martian.TestContext(req, nil, nil)
+	if err := l.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if want := strings.Repeat("text ", 10)[:48] + "\n[truncated"; len(lines) != 1 || !strings.Contains(lines[0], want) {
+		t.Errorf("lines: got %q, want to contain %q", lines, want)
+	}
+}
+
+func TestLoggerCurlMode(t *testing.T) {
+	var lines []string
+	l := NewLogger()
+	l.SetMode(CurlLogMode)
+	l.SetCurlProxy("http://localhost:8080")
+	l.SetLogFunc(func(line string) {
+		lines = append(lines, line)
+	})
+
+	req, err := http.NewRequest("POST", "http://example.com/path?q=1", strings.NewReader("it's"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Binary", "a\x01")
+	martian.TestContext(req, nil, nil)
+
+	if err := l.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if err := l.ModifyResponse(proxyutil.NewResponse(200, nil, req)); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	want := "curl --proxy 'http://localhost:8080' -X 'POST' 'http://example.com/path?q=1' \\\n" +
+		"  -H 'Content-Type: text/plain' \\\n" +
+		"  -H $'X-Binary: a\\x01' \\\n" +
+		"  --data-binary 'it'\\''s'"
+	if got := lines; len(got) != 1 || got[0] != want {
+		t.Fatalf("lines: got %q, want [%q]", got, want)
+	}
+
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if string(got) != "it's" {
+		t.Errorf("req.Body: got %q, want %q", got, "it's")
+	}
+}
+
+func TestLoggerMaxBodyLog(t *testing.T) {
+	var lines []string
+	l := NewLogger()
+	l.SetMaxBodyLog(5)
+	l.SetLogFunc(func(line string) {
+		lines = append(lines, line)
+	})
+
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	martian.TestContext(req, nil, nil)
+
+	if err := l.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got := len(lines); got != 0 {
+		t.Fatalf("len(lines) before body read: got %d, want 0", got)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if string(got) != "0123456789" {
+		t.Errorf("req.Body: got %q, want %q", got, "0123456789")
+	}
+	req.Body.Close()
+
+	res := proxyutil.NewResponse(200, strings.NewReader("ok"), req)
+	if err := l.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	res.Body.Close()
+
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("len(lines): got %d, want %d", got, want)
+	}
+	sum := sha256.Sum256([]byte("0123456789"))
+	if want := fmt.Sprintf("\r\n\r\n01234\n[truncated: 10 bytes total, sha256 %x]\n", sum); !strings.Contains(lines[0], want) {
+		t.Errorf("lines[0]: got %q, want to contain %q", lines[0], want)
+	}
+	// The response body is closed unread.
+	if want := "\r\n\r\n\n---"; !strings.Contains(lines[1], want) {
+		t.Errorf("lines[1]: got %q, want to contain %q", lines[1], want)
+	}
+}
+
+func TestLoggerHexDump(t *testing.T) {
+	body := []byte{0x00, 0x01, 0x02, 0xff, 'a', 'b', 'c', 0x7f}
+
+	for _, maxBody := range []int{0, 4} {
+		var lines []string
+		l := NewLogger()
+		l.SetHexDumpMax(6)
+		l.SetMaxBodyLog(maxBody)
+		l.SetLogFunc(func(line string) {
+			lines = append(lines, line)
+		})
+
+		req, err := http.NewRequest("POST", "http://example.com/", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		martian.TestContext(req, nil, nil)
+
+		if err := l.ModifyRequest(req); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+		ioutil.ReadAll(req.Body)
+		req.Body.Close()
+
+		if got, want := len(lines), 1; got != want {
+			t.Fatalf("SetMaxBodyLog(%d): len(lines): got %d, want %d", maxBody, got, want)
+		}
+		want := hex.Dump(body[:6])
+		if maxBody > 0 {
+			want = hex.Dump(body[:4])
+		}
+		if !strings.Contains(lines[0], "\r\n\r\n"+want) {
+			t.Errorf("SetMaxBodyLog(%d): lines[0]: got %q, want to contain %q", maxBody, lines[0], want)
+		}
+		if strings.Contains(lines[0], "abc") {
+			t.Errorf("SetMaxBodyLog(%d): lines[0]: got %q, want no raw body", maxBody, lines[0])
+		}
+	}
+}
+
+func TestLoggerGraphQL(t *testing.T) {
+	for _, mode := range []LogMode{BodyLogMode, JSONLogMode} {
+		var lines []string
+		l := NewLogger()
+		l.SetMode(mode)
+		l.SetGraphQL(true)
+		l.SetLogFunc(func(line string) {
+			lines = append(lines, line)
+		})
+
+		body := `{"query": "mutation CreateUser($name: String!) { createUser(name: $name) { id } }"}`
+		req, err := http.NewRequest("POST", "http://example.com/graphql", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		martian.TestContext(req, nil, nil)
+
+		if err := l.ModifyRequest(req); err != nil {
+			t.Fatalf("ModifyRequest(): got %v, want no error", err)
+		}
+		got, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+		}
+		if string(got) != body {
+			t.Errorf("req.Body: got %q, want %q", got, body)
+		}
+
+		res := proxyutil.NewResponse(200, strings.NewReader("{}"), req)
+		if err := l.ModifyResponse(res); err != nil {
+			t.Fatalf("ModifyResponse(): got %v, want no error", err)
+		}
+		res.Body.Close()
+
+		if mode == JSONLogMode {
+			if got, want := len(lines), 1; got != want {
+				t.Fatalf("%v: len(lines): got %d, want %d", mode, got, want)
+			}
+			var e JSONEntry
+			if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+				t.Fatalf("json.Unmarshal(): got %v, want no error", err)
+			}
+			if e.GraphQL == nil || e.GraphQL.String() != "mutation CreateUser" {
+				t.Errorf("%v: GraphQL: got %v, want mutation CreateUser", mode, e.GraphQL)
+			}
+			continue
+		}
+
+		if got, want := len(lines), 2; got != want {
+			t.Fatalf("%v: len(lines): got %d, want %d", mode, got, want)
+		}
+		for i, line := range lines {
+			if want := "\nGraphQL: mutation CreateUser\n"; !strings.Contains(line, want) {
+				t.Errorf("%v: lines[%d]: got %q, want to contain %q", mode, i, line, want)
+			}
+		}
+	}
+}
+
 func TestLoggerFromJSON(t *testing.T) {
 	msg := []byte(`{
 		"log.Logger": {
@@ -0,0 +1,238 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/martian/v3/messageview"
+)
+
+// redacted replaces redacted values in logs.
+const redacted = "[REDACTED]"
+
+// redaction holds the rules applied to requests and responses before they
+// are logged.
+type redaction struct {
+	headers    map[string]bool
+	cookies    map[string]bool
+	jsonFields [][]string
+	patterns   []*regexp.Regexp
+}
+
+// RedactHeaders sets the logger to replace the values of the headers with the
+// given names with "[REDACTED]", such as "Authorization".
+func (l *Logger) RedactHeaders(names ...string) {
+	if l.redact.headers == nil {
+		l.redact.headers = make(map[string]bool)
+	}
+	for _, n := range names {
+		l.redact.headers[http.CanonicalHeaderKey(n)] = true
+	}
+}
+
+// RedactCookies sets the logger to replace the values of the cookies with the
+// given names in Cookie and Set-Cookie headers with "[REDACTED]".
+func (l *Logger) RedactCookies(names ...string) {
+	if l.redact.cookies == nil {
+		l.redact.cookies = make(map[string]bool)
+	}
+	for _, n := range names {
+		l.redact.cookies[n] = true
+	}
+}
+
+// RedactJSONFields sets the logger to replace the values of fields of JSON
+// bodies with "[REDACTED]". Fields are given by dot-separated paths from the
+// top-level value, such as "user.password"; a "*" element matches any object
+// key or array element, as in "items.*.token". Bodies are only redacted if
+// they are not compressed or logged decoded, see SetDecode.
+func (l *Logger) RedactJSONFields(paths ...string) {
+	for _, p := range paths {
+		l.redact.jsonFields = append(l.redact.jsonFields, strings.Split(p, "."))
+	}
+}
+
+// RedactPattern sets the logger to replace the matches of re anywhere in the
+// logged text, including the URL, with "[REDACTED]".
+func (l *Logger) RedactPattern(re *regexp.Regexp) {
+	l.redact.patterns = append(l.redact.patterns, re)
+}
+
+// writeMessage writes the message of mv to b, applying the header, cookie and
+// JSON field redaction rules.
+func (l *Logger) writeMessage(b *bytes.Buffer, mv *messageview.MessageView, opts []messageview.Option) error {
+	if len(l.redact.headers) == 0 && len(l.redact.cookies) == 0 && len(l.redact.jsonFields) == 0 {
+		r, err := mv.Reader(opts...)
+		if err != nil {
+			return err
+		}
+		io.Copy(b, r)
+		return nil
+	}
+
+	l.writeHeader(b, mv.HeaderReader())
+
+	br, err := mv.BodyReader(opts...)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+
+	body, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+	b.Write(l.redactJSON(body))
+
+	l.writeHeader(b, mv.TrailerReader())
+
+	return nil
+}
+
+// writeHeader copies the header lines of r to b, redacting headers and
+// cookies.
+func (l *Logger) writeHeader(b *bytes.Buffer, r io.Reader) {
+	s := bufio.NewScanner(r)
+	s.Split(scanLinesCRLF)
+	for s.Scan() {
+		line := s.Text()
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			if v, ok := l.redactHeader(http.CanonicalHeaderKey(name), strings.TrimLeft(value, " ")); ok {
+				line = name + ": " + v + "\r\n"
+			}
+		}
+		b.WriteString(line)
+	}
+}
+
+// scanLinesCRLF is a bufio.SplitFunc that returns lines including their line
+// endings.
+func scanLinesCRLF(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// redactHeader returns the redacted value of the header, and whether it was
+// changed.
+func (l *Logger) redactHeader(name, value string) (string, bool) {
+	value = strings.TrimRight(value, "\r\n")
+
+	if l.redact.headers[name] {
+		return redacted, true
+	}
+	if len(l.redact.cookies) == 0 {
+		return value, false
+	}
+
+	switch name {
+	case "Cookie":
+		changed := false
+		cookies := strings.Split(value, ";")
+		for i, c := range cookies {
+			n, _, ok := strings.Cut(c, "=")
+			if ok && l.redact.cookies[strings.TrimSpace(n)] {
+				cookies[i] = n + "=" + redacted
+				changed = true
+			}
+		}
+		return strings.Join(cookies, ";"), changed
+	case "Set-Cookie":
+		c, attrs, _ := strings.Cut(value, ";")
+		n, _, ok := strings.Cut(c, "=")
+		if ok && l.redact.cookies[strings.TrimSpace(n)] {
+			v := n + "=" + redacted
+			if attrs != "" {
+				v += ";" + attrs
+			}
+			return v, true
+		}
+	}
+
+	return value, false
+}
+
+// redactHeaders returns a copy of h with headers and cookies redacted.
+func (l *Logger) redactHeaders(h map[string][]string) map[string][]string {
+	for name, vs := range h {
+		rvs := make([]string, len(vs))
+		for i, v := range vs {
+			rvs[i], _ = l.redactHeader(name, v)
+		}
+		h[name] = rvs
+	}
+	return h
+}
+
+// redactJSON returns body with the JSON fields redacted, or body if it is not
+// JSON.
+func (l *Logger) redactJSON(body []byte) []byte {
+	if len(l.redact.jsonFields) == 0 {
+		return body
+	}
+
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return body
+	}
+
+	changed := false
+	for _, path := range l.redact.jsonFields {
+		v = redactPath(v, path, &changed)
+	}
+	if !changed {
+		return body
+	}
+
+	rb, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return rb
+}
+
+func redactPath(v any, path []string, changed *bool) any {
+	if len(path) == 0 {
+		*changed = true
+		return redacted
+	}
+
+	switch t := v.(type) {
+	case map[string]any:
+		for k, cv := range t {
+			if path[0] == "*" || path[0] == k {
+				t[k] = redactPath(cv, path[1:], changed)
+			}
+		}
+	case []any:
+		if path[0] == "*" {
+			for i, cv := range t {
+				t[i] = redactPath(cv, path[1:], changed)
+			}
+		}
+	}
+
+	return v
+}
+
+// redactPatterns returns s with the matches of the patterns redacted.
+func (l *Logger) redactPatterns(s string) string {
+	for _, re := range l.redact.patterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
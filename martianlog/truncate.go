@@ -0,0 +1,150 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/martian/v3/messageview"
+)
+
+// SetMaxBodyLog limits the logged body of requests and responses to the first
+// n bytes. Larger bodies are logged as the prefix followed by their length and
+// SHA-256 digest. Bodies are then not read into memory; instead the message is
+// logged once its body has been read or closed. Bodies are logged as sent,
+// regardless of SetDecode. Zero or less disables the limit.
+func (l *Logger) SetMaxBodyLog(n int) {
+	l.maxBody = n
+}
+
+// logTruncated writes the header of res, or of req if res is nil, to b, and
+// logs b along with the body once it has been read or closed.
+func (l *Logger) logTruncated(b *bytes.Buffer, req *http.Request, res *http.Response) error {
+	mv := messageview.New()
+	mv.SkipBody(true)
+
+	body, ct := &req.Body, req.Header.Get("Content-Type")
+	if res != nil {
+		if err := mv.SnapshotResponse(res); err != nil {
+			return err
+		}
+		body, ct = &res.Body, res.Header.Get("Content-Type")
+	} else if err := mv.SnapshotRequest(req); err != nil {
+		return err
+	}
+	l.writeHeader(b, mv.HeaderReader())
+
+	hexdump := l.hexMax >= 0 && messageview.BinaryContentType(ct)
+	finish := func(prefix []byte, n int64, sum []byte) {
+		switch {
+		case hexdump:
+			if len(prefix) > l.hexMax {
+				prefix = prefix[:l.hexMax]
+			}
+			b.WriteString(hex.Dump(prefix))
+			if n > int64(len(prefix)) {
+				fmt.Fprintf(b, "[truncated: %d bytes total, sha256 %x]", n, sum)
+			}
+		case n > int64(len(prefix)):
+			if rp, ok := l.redactJSONPrefix(prefix); ok {
+				b.Write(rp)
+				b.WriteString("\n")
+			} else {
+				b.WriteString("[JSON prefix omitted: fields are redacted]\n")
+			}
+			fmt.Fprintf(b, "[truncated: %d bytes total, sha256 %x]", n, sum)
+		default:
+			b.Write(l.redactJSON(prefix))
+		}
+
+		fmt.Fprintln(b, "")
+		fmt.Fprintln(b, strings.Repeat("-", 80))
+
+		l.logLine(req, b.String())
+	}
+
+	if *body == nil || *body == http.NoBody {
+		finish(nil, 0, nil)
+		return nil
+	}
+
+	*body = &truncatedBody{
+		ReadCloser: *body,
+		max:        l.maxBody,
+		h:          sha256.New(),
+		finish:     finish,
+	}
+
+	return nil
+}
+
+// redactJSONPrefix returns prefix, the start of a truncated body, with its JSON
+// fields redacted. As a truncated JSON document can not be parsed, it returns
+// false if JSON fields are redacted and prefix looks like JSON, so that the
+// prefix is omitted rather than logged unredacted.
+func (l *Logger) redactJSONPrefix(prefix []byte) ([]byte, bool) {
+	if len(l.redact.jsonFields) == 0 {
+		return prefix, true
+	}
+	if json.Valid(prefix) {
+		return l.redactJSON(prefix), true
+	}
+
+	trimmed := bytes.TrimLeft(prefix, " \t\r\n")
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return nil, false
+	}
+
+	return prefix, true
+}
+
+// truncatedBody keeps the first max bytes read from the body and calls finish
+// with them, the number of bytes read and their digest at EOF or when closed.
+type truncatedBody struct {
+	io.ReadCloser
+	max    int
+	prefix []byte
+	n      int64
+	h      hash.Hash
+	once   sync.Once
+	finish func(prefix []byte, n int64, sum []byte)
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	if keep := b.max - len(b.prefix); keep > 0 {
+		if keep > n {
+			keep = n
+		}
+		b.prefix = append(b.prefix, p[:keep]...)
+	}
+	b.n += int64(n)
+	b.h.Write(p[:n])
+
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *truncatedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+func (b *truncatedBody) done() {
+	b.once.Do(func() {
+		b.finish(b.prefix, b.n, b.h.Sum(nil))
+	})
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianurl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("url.Rewrite", rewriteModifierFromJSON)
+}
+
+// RewriteModifier is a request modifier that rewrites the complete request
+// URL with regular expressions, in the manner of nginx rewrite rules. Each
+// rule matches the URL, for example "http://example.com/path?query", and
+// replaces it with a replacement that may refer to capture groups, such as
+// "https://$1.example.org/$2". Rules are applied in order, each to the
+// result of the previous ones, until a rule marked last matches.
+//
+// If the rewrite changes the host, the Host header is changed as well.
+type RewriteModifier struct {
+	rules []rewriteRule
+}
+
+type rewriteRule struct {
+	re   *regexp.Regexp
+	repl string
+	last bool
+}
+
+type rewriteRuleJSON struct {
+	Match       string `json:"match"`
+	Replacement string `json:"replacement"`
+	Last        bool   `json:"last"`
+}
+
+type rewriteModifierJSON struct {
+	Rules []rewriteRuleJSON    `json:"rules"`
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// NewRewriteModifier returns a RewriteModifier without rules.
+func NewRewriteModifier() *RewriteModifier {
+	return &RewriteModifier{}
+}
+
+// Rewrite adds a rule replacing the matches of re in the URL with repl. If
+// last is true, no further rules are applied after a match.
+func (m *RewriteModifier) Rewrite(re *regexp.Regexp, repl string, last bool) {
+	m.rules = append(m.rules, rewriteRule{re: re, repl: repl, last: last})
+}
+
+// ModifyRequest rewrites the URL of req. It returns an error if a rewritten
+// URL cannot be parsed or is not absolute.
+func (m *RewriteModifier) ModifyRequest(req *http.Request) error {
+	orig := req.URL.String()
+
+	s := orig
+	for _, r := range m.rules {
+		if !r.re.MatchString(s) {
+			continue
+		}
+		s = r.re.ReplaceAllString(s, r.repl)
+		if r.last {
+			break
+		}
+	}
+	if s == orig {
+		return nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("martianurl: rewritten URL %q: %w", s, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("martianurl: rewritten URL %q is not absolute", s)
+	}
+
+	log.Debugf("martianurl.RewriteModifier.ModifyRequest: %s -> %s", orig, u)
+
+	if u.Host != req.URL.Host {
+		req.Host = u.Host
+	}
+	req.URL = u
+
+	return nil
+}
+
+// rewriteModifierFromJSON builds a url.Rewrite modifier from JSON. "rules"
+// are applied in order; each replaces the matches of the regular expression
+// "match" in the URL with "replacement". A rule with "last" set stops the
+// rewriting when it matches.
+//
+// Example JSON:
+//
+//	{
+//	  "url.Rewrite": {
+//	    "scope": ["request"],
+//	    "rules": [
+//	      {
+//	        "match": "^http://([a-z]+)\\.example\\.com/v1/(.*)$",
+//	        "replacement": "https://$1.staging.example.com/v2/$2",
+//	        "last": true
+//	      }
+//	    ]
+//	  }
+//	}
+func rewriteModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &rewriteModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewRewriteModifier()
+	for i, r := range msg.Rules {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("martianurl: rule %d: invalid match %q: %w", i, r.Match, err)
+		}
+		mod.Rewrite(re, r.Replacement, r.Last)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martianurl
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func TestRewriteModifier(t *testing.T) {
+	mod := NewRewriteModifier()
+	mod.Rewrite(regexp.MustCompile(`^http://([a-z]+)\.example\.com/v1/(.*)$`), "https://$1.staging.example.com/v2/$2", false)
+	mod.Rewrite(regexp.MustCompile(`/v2/old/`), "/v2/new/", true)
+	mod.Rewrite(regexp.MustCompile(`/v2/`), "/v3/", false)
+	mod.Rewrite(regexp.MustCompile(`[?&]debug=1`), "", false)
+
+	tt := []struct {
+		url, want, host string
+	}{
+		{"http://api.example.com/v1/users?id=1", "https://api.staging.example.com/v3/users?id=1", "api.staging.example.com"},
+		{"http://api.example.com/v1/old/users", "https://api.staging.example.com/v2/new/users", "api.staging.example.com"},
+		{"http://other.test/v1/users?debug=1", "http://other.test/v1/users", "other.test"},
+		{"http://other.test/unchanged", "http://other.test/unchanged", "other.test"},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		if err := mod.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+		if got := req.URL.String(); got != tc.want {
+			t.Errorf("%d. req.URL: got %q, want %q", i, got, tc.want)
+		}
+		if got := req.Host; got != tc.host {
+			t.Errorf("%d. req.Host: got %q, want %q", i, got, tc.host)
+		}
+	}
+}
+
+func TestRewriteModifierInvalidURL(t *testing.T) {
+	mod := NewRewriteModifier()
+	mod.Rewrite(regexp.MustCompile(`^http://example\.com`), "", false)
+
+	req, err := http.NewRequest("GET", "http://example.com/path", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := mod.ModifyRequest(req); err == nil {
+		t.Error("ModifyRequest(): got nil, want error for relative URL")
+	}
+	if got, want := req.URL.String(), "http://example.com/path"; got != want {
+		t.Errorf("req.URL: got %q, want %q", got, want)
+	}
+}
+
+func TestRewriteModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"url.Rewrite": {
+			"scope": ["request"],
+			"rules": [
+				{
+					"match": "^http://([a-z]+)\\.example\\.com/(.*)$",
+					"replacement": "https://example.com/$1/$2",
+					"last": true
+				}
+			]
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("r.RequestModifier(): got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://docs.example.com/index.html", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.URL.String(), "https://example.com/docs/index.html"; got != want {
+		t.Errorf("req.URL: got %q, want %q", got, want)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"url.Rewrite": {"scope": ["request"], "rules": [{"match": "("}]}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for invalid match")
+	}
+}
@@ -15,12 +15,18 @@
 package martianurl
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/url"
 
+	"github.com/google/martian/v3/filter"
 	"github.com/google/martian/v3/log"
 )
 
+func init() {
+	filter.RegisterCondition("url", matcherFromJSON)
+}
+
 // Matcher is a conditional evaluator of request urls to be used in
 // filters that take conditionals.
 type Matcher struct {
@@ -34,6 +40,37 @@ func NewMatcher(url *url.URL) *Matcher {
 	}
 }
 
+type matcherJSON struct {
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"`
+	Path   string `json:"path"`
+	Query  string `json:"query"`
+}
+
+// matcherFromJSON builds a martianurl.Matcher condition from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "scheme": "https",
+//	  "host": "example.com",
+//	  "path": "/foo/bar",
+//	  "query": "q=value"
+//	}
+func matcherFromJSON(b []byte) (filter.Condition, error) {
+	msg := &matcherJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return NewMatcher(&url.URL{
+		Scheme:   msg.Scheme,
+		Host:     msg.Host,
+		Path:     msg.Path,
+		RawQuery: msg.Query,
+	}), nil
+}
+
 // MatchRequest retuns true if all non-empty URL segments in m.url match the
 // request URL.
 func (m *Matcher) MatchRequest(req *http.Request) bool {
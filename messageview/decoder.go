@@ -0,0 +1,127 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package messageview
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Decoder returns a reader of the content decoded from r. For the
+// dictionary-compressed encodings of Compression Dictionary Transport, "dcb"
+// and "dcz", dict is the dictionary the content was compressed with and r
+// reads the compressed stream without the dictionary header. For other
+// encodings dict is nil.
+type Decoder func(r io.Reader, dict []byte) (io.ReadCloser, error)
+
+// maxDictionaries is the number of dictionaries kept by AddDictionary.
+const maxDictionaries = 64
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = make(map[string]Decoder)
+
+	dictsMu    sync.RWMutex
+	dicts      = make(map[[sha256.Size]byte][]byte)
+	dictsOrder [][sha256.Size]byte
+)
+
+// Headers of dictionary-compressed content, RFC 9842, sections 4 and 5,
+// followed by the SHA-256 hash of the dictionary.
+var (
+	dcbMagic = []byte{0xff, 0x44, 0x43, 0x42}
+	dczMagic = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+)
+
+// RegisterDecoder registers d to decode bodies with the Content-Encoding
+// encoding, such as "br", "zstd", "dcb" or "dcz", when the Decode option is
+// used. The built-in decoders for gzip and deflate cannot be replaced. Bodies
+// with encodings that have no decoder are not decoded.
+func RegisterDecoder(encoding string, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+
+	decoders[encoding] = d
+}
+
+func decoder(encoding string) (Decoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	d, ok := decoders[encoding]
+	return d, ok
+}
+
+// AddDictionary makes dict available for decoding dictionary-compressed
+// bodies and returns its SHA-256 hash. Only the most recently added
+// dictionaries are kept.
+func AddDictionary(dict []byte) [sha256.Size]byte {
+	h := sha256.Sum256(dict)
+
+	dictsMu.Lock()
+	defer dictsMu.Unlock()
+
+	if _, ok := dicts[h]; ok {
+		return h
+	}
+
+	if len(dictsOrder) == maxDictionaries {
+		delete(dicts, dictsOrder[0])
+		dictsOrder = dictsOrder[1:]
+	}
+	dicts[h] = dict
+	dictsOrder = append(dictsOrder, h)
+
+	return h
+}
+
+// Dictionary returns the dictionary with the SHA-256 hash h.
+func Dictionary(h [sha256.Size]byte) ([]byte, bool) {
+	dictsMu.RLock()
+	defer dictsMu.RUnlock()
+
+	dict, ok := dicts[h]
+	return dict, ok
+}
+
+// decode returns a reader of the body read from r decoded with a registered
+// decoder. Bodies without a decoder, or whose dictionary is not available,
+// are returned as is.
+func decode(encoding string, r io.Reader) (io.ReadCloser, error) {
+	d, ok := decoder(encoding)
+	if !ok {
+		return ioutil.NopCloser(r), nil
+	}
+
+	var magic []byte
+	switch encoding {
+	case "dcb":
+		magic = dcbMagic
+	case "dcz":
+		magic = dczMagic
+	default:
+		return d(r, nil)
+	}
+
+	hdr := make([]byte, len(magic)+sha256.Size)
+	n, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return ioutil.NopCloser(io.MultiReader(bytes.NewReader(hdr[:n]), r)), nil
+	}
+	if !bytes.Equal(hdr[:len(magic)], magic) {
+		return nil, fmt.Errorf("messageview: invalid %s header", encoding)
+	}
+
+	var h [sha256.Size]byte
+	copy(h[:], hdr[len(magic):])
+	dict, ok := Dictionary(h)
+	if !ok {
+		return ioutil.NopCloser(io.MultiReader(bytes.NewReader(hdr), r)), nil
+	}
+
+	return d(r, dict)
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package messageview
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestDecodeRegisteredDecoder(t *testing.T) {
+	RegisterDecoder("x-upper", func(r io.Reader, dict []byte) (io.ReadCloser, error) {
+		if dict != nil {
+			t.Errorf("dict: got %q, want nil", dict)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(bytes.ToUpper(b))), nil
+	})
+
+	for _, tc := range []struct {
+		encoding string
+		want     string
+	}{
+		{"x-upper", "BODY"},
+		{"x-unknown", "body"},
+	} {
+		res := proxyutil.NewResponse(200, strings.NewReader("body"), nil)
+		res.Header.Set("Content-Encoding", tc.encoding)
+
+		mv := New()
+		if err := mv.SnapshotResponse(res); err != nil {
+			t.Fatalf("SnapshotResponse(): got %v, want no error", err)
+		}
+
+		br, err := mv.BodyReader(Decode())
+		if err != nil {
+			t.Fatalf("BodyReader(): got %v, want no error", err)
+		}
+		got, err := ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: body: got %q, want %q", tc.encoding, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeDictionaryCompressed(t *testing.T) {
+	// The fake dcb decoder prefixes the content with the dictionary.
+	RegisterDecoder("dcb", func(r io.Reader, dict []byte) (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.MultiReader(bytes.NewReader(dict), r)), nil
+	})
+
+	dict := []byte("dictionary ")
+	h := AddDictionary(dict)
+	if got, ok := Dictionary(h); !ok || !bytes.Equal(got, dict) {
+		t.Fatalf("Dictionary(): got %q, %t, want %q, true", got, ok, dict)
+	}
+
+	unknown := bytes.Repeat([]byte{1}, 32)
+
+	tt := []struct {
+		name    string
+		body    []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "known dictionary",
+			body: append(append(append([]byte{}, dcbMagic...), h[:]...), "content"...),
+			want: "dictionary content",
+		},
+		{
+			name: "unknown dictionary",
+			body: append(append(append([]byte{}, dcbMagic...), unknown...), "content"...),
+			want: string(dcbMagic) + string(unknown) + "content",
+		},
+		{
+			name: "short body",
+			body: []byte("short"),
+			want: "short",
+		},
+		{
+			name:    "invalid header",
+			body:    append(bytes.Repeat([]byte{0}, 36), "content"...),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		res := proxyutil.NewResponse(200, bytes.NewReader(tc.body), nil)
+		res.Header.Set("Content-Encoding", "dcb")
+
+		mv := New()
+		if err := mv.SnapshotResponse(res); err != nil {
+			t.Fatalf("%s: SnapshotResponse(): got %v, want no error", tc.name, err)
+		}
+
+		br, err := mv.BodyReader(Decode())
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: BodyReader(): got no error, want error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: BodyReader(): got %v, want no error", tc.name, err)
+		}
+
+		got, err := ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("%s: ioutil.ReadAll(): got %v, want no error", tc.name, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: body: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+}
+
+func TestAddDictionaryEvicts(t *testing.T) {
+	first := AddDictionary([]byte("evicted"))
+	for i := 0; i < maxDictionaries; i++ {
+		AddDictionary([]byte{byte(i), byte(i >> 8), 'x'})
+	}
+
+	if _, ok := Dictionary(first); ok {
+		t.Error("Dictionary(): got true for oldest dictionary, want false")
+	}
+}
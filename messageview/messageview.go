@@ -20,12 +20,14 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"strings"
+	"sync"
 )
 
 // MessageView is a static view of an HTTP request or response.
@@ -35,12 +37,31 @@ type MessageView struct {
 	chunked       bool
 	skipBody      bool
 	compress      string
+	contentType   string
+	grpcEncoding  string
+	path          string
+	response      bool
 	bodyoffset    int64
 	traileroffset int64
+	maxBody       int64
+	streaming     bool
+
+	// mu guards the fields below, which are updated as streamed bodies are
+	// read.
+	mu         sync.Mutex
+	truncated  bool
+	header     []byte
+	body       []byte
+	done       bool
+	incomplete bool
+	trailer    func() http.Header
 }
 
 type config struct {
 	decode bool
+	hex    bool
+	hexMax int
+	protos *ProtoTypes
 }
 
 // Option is a configuration option for a MessageView.
@@ -53,6 +74,49 @@ func Decode() Option {
 	}
 }
 
+// HexReader sets an option to render bodies with a binary content type, see
+// BinaryContentType, as a hexdump of at most max bytes instead of the raw bytes.
+// It applies after decoding.
+func HexReader(max int) Option {
+	return func(c *config) {
+		c.hex = true
+		c.hexMax = max
+	}
+}
+
+// ProtoReader sets an option to render protocol buffer and gRPC bodies whose
+// message type is resolved by pt in the protocol buffer text format. Bodies
+// that fail to parse are read as if the option was not set.
+func ProtoReader(pt *ProtoTypes) Option {
+	return func(c *config) {
+		c.protos = pt
+	}
+}
+
+// BinaryContentType reports whether the media type of the Content-Type ct is
+// binary, such as application/octet-stream, images or protocol buffers.
+func BinaryContentType(ct string) bool {
+	mt, _, _ := strings.Cut(ct, ";")
+	mt = strings.ToLower(strings.TrimSpace(mt))
+
+	for _, prefix := range []string{"image/", "audio/", "video/", "font/"} {
+		if strings.HasPrefix(mt, prefix) {
+			return !strings.HasPrefix(mt, "image/svg")
+		}
+	}
+	if strings.HasPrefix(mt, "application/grpc") && !strings.HasPrefix(mt, "application/grpc-web-text") {
+		return true
+	}
+	switch mt {
+	case "application/octet-stream", "application/protobuf", "application/x-protobuf",
+		"application/vnd.google.protobuf", "application/zip", "application/gzip",
+		"application/pdf", "application/wasm":
+		return true
+	}
+
+	return false
+}
+
 // New returns a new MessageView.
 func New() *MessageView {
 	return &MessageView{}
@@ -64,6 +128,36 @@ func (mv *MessageView) SkipBody(skipBody bool) {
 	mv.skipBody = skipBody
 }
 
+// SetMaxBodySize sets the maximum number of body bytes read into the view when
+// it is loaded with a request or response, so that snapshots of large or
+// streaming bodies do not exhaust memory. Longer bodies are truncated in the
+// view, whose body readers then end with a truncation marker, while the
+// request or response keeps its full body. A max of 0, the default, reads
+// bodies fully.
+func (mv *MessageView) SetMaxBodySize(max int64) {
+	mv.maxBody = max
+}
+
+// Truncated reports whether the body was truncated to the maximum body size
+// when the view was loaded, or as it was streamed.
+func (mv *MessageView) Truncated() bool {
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+
+	return mv.truncated
+}
+
+// SetStreaming sets whether loading the view with a request or response wraps
+// the body with a tee instead of reading it upfront, so that the view only
+// holds the bytes of the body consumed by its readers, up to the maximum body
+// size. This avoids the latency of reading large bodies before they are
+// forwarded. The readers of the view return the body read so far, ending with
+// a marker while the body is incomplete, and the trailers once it was read to
+// the end.
+func (mv *MessageView) SetStreaming(streaming bool) {
+	mv.streaming = streaming
+}
+
 // SkipBodyUnlessContentType will skip reading the body unless the
 // Content-Type matches one in cts.
 func (mv *MessageView) SkipBodyUnlessContentType(cts ...string) {
@@ -93,6 +187,8 @@ func (mv *MessageView) SnapshotRequest(req *http.Request) error {
 	}
 
 	mv.compress = req.Header.Get("Content-Encoding")
+	mv.grpcEncoding = req.Header.Get("Grpc-Encoding")
+	mv.path = req.URL.Path
 
 	req.Header.WriteSubset(buf, map[string]bool{
 		"Host":              true,
@@ -106,16 +202,21 @@ func (mv *MessageView) SnapshotRequest(req *http.Request) error {
 	mv.traileroffset = int64(buf.Len())
 
 	ct := req.Header.Get("Content-Type")
+	mv.contentType = ct
 	if mv.skipBody && !mv.matchContentType(ct) || req.Body == nil {
 		mv.message = buf.Bytes()
 		return nil
 	}
 
-	data, err := ioutil.ReadAll(req.Body)
+	if mv.streaming {
+		mv.streamBody(buf.Bytes(), &req.Body, func() http.Header { return req.Trailer })
+		return nil
+	}
+
+	data, body, err := mv.readBody(req.Body)
 	if err != nil {
 		return err
 	}
-	req.Body.Close()
 
 	if mv.chunked {
 		cw := httputil.NewChunkedWriter(buf)
@@ -127,7 +228,7 @@ func (mv *MessageView) SnapshotRequest(req *http.Request) error {
 
 	mv.traileroffset = int64(buf.Len())
 
-	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.Body = body
 
 	if req.Trailer != nil {
 		req.Trailer.Write(buf)
@@ -157,6 +258,11 @@ func (mv *MessageView) SnapshotResponse(res *http.Response) error {
 	}
 
 	mv.compress = res.Header.Get("Content-Encoding")
+	mv.grpcEncoding = res.Header.Get("Grpc-Encoding")
+	mv.response = true
+	if res.Request != nil {
+		mv.path = res.Request.URL.Path
+	}
 	// Do not uncompress if we have don't have the full contents.
 	if res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusPartialContent {
 		mv.compress = ""
@@ -173,16 +279,21 @@ func (mv *MessageView) SnapshotResponse(res *http.Response) error {
 	mv.traileroffset = int64(buf.Len())
 
 	ct := res.Header.Get("Content-Type")
+	mv.contentType = ct
 	if mv.skipBody && !mv.matchContentType(ct) || res.Body == nil {
 		mv.message = buf.Bytes()
 		return nil
 	}
 
-	data, err := ioutil.ReadAll(res.Body)
+	if mv.streaming {
+		mv.streamBody(buf.Bytes(), &res.Body, func() http.Header { return res.Trailer })
+		return nil
+	}
+
+	data, body, err := mv.readBody(res.Body)
 	if err != nil {
 		return err
 	}
-	res.Body.Close()
 
 	if mv.chunked {
 		cw := httputil.NewChunkedWriter(buf)
@@ -194,7 +305,7 @@ func (mv *MessageView) SnapshotResponse(res *http.Response) error {
 
 	mv.traileroffset = int64(buf.Len())
 
-	res.Body = ioutil.NopCloser(bytes.NewReader(data))
+	res.Body = body
 
 	if res.Trailer != nil {
 		res.Trailer.Write(buf)
@@ -207,14 +318,124 @@ func (mv *MessageView) SnapshotResponse(res *http.Response) error {
 	return nil
 }
 
+// readBody reads body up to the maximum body size. It returns the bytes read
+// and a body replacing the original, which reads the full body.
+func (mv *MessageView) readBody(body io.ReadCloser) ([]byte, io.ReadCloser, error) {
+	if mv.maxBody <= 0 {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		body.Close()
+
+		return data, ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, mv.maxBody+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) <= mv.maxBody {
+		body.Close()
+		return data, ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	mv.truncated = true
+	rest := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), body), body}
+
+	return data[:mv.maxBody], rest, nil
+}
+
+// streamBody replaces *body with a reader that copies the bytes read into the
+// view. header is the message up to the body, and trailer returns the
+// trailers once the body was read to the end.
+func (mv *MessageView) streamBody(header []byte, body *io.ReadCloser, trailer func() http.Header) {
+	mv.header = header
+	mv.trailer = trailer
+	mv.message = header
+	*body = &teeBody{mv: mv, rc: *body}
+}
+
+// teeBody is a body copying the bytes read into a view.
+type teeBody struct {
+	mv *MessageView
+	rc io.ReadCloser
+}
+
+func (tb *teeBody) Read(p []byte) (int, error) {
+	n, err := tb.rc.Read(p)
+
+	mv := tb.mv
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+
+	data := p[:n]
+	if mv.maxBody > 0 {
+		if room := mv.maxBody - int64(len(mv.body)); int64(len(data)) > room {
+			data = data[:room]
+			mv.truncated = true
+		}
+	}
+	mv.body = append(mv.body, data...)
+	if err == io.EOF {
+		mv.done = true
+	}
+
+	return n, err
+}
+
+func (tb *teeBody) Close() error {
+	return tb.rc.Close()
+}
+
+// materialize builds the message of a streaming view from the body read so
+// far.
+func (mv *MessageView) materialize() {
+	if !mv.streaming || mv.trailer == nil {
+		return
+	}
+
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+
+	buf := bytes.NewBuffer(append([]byte(nil), mv.header...))
+	if mv.chunked {
+		cw := httputil.NewChunkedWriter(buf)
+		cw.Write(mv.body)
+		if mv.done {
+			cw.Close()
+		}
+	} else {
+		buf.Write(mv.body)
+	}
+
+	mv.traileroffset = int64(buf.Len())
+
+	if mv.done {
+		if t := mv.trailer(); t != nil {
+			t.Write(buf)
+		} else if mv.chunked {
+			fmt.Fprint(buf, "\r\n")
+		}
+	}
+
+	mv.message = buf.Bytes()
+	mv.incomplete = !mv.done
+}
+
 // Reader returns the an io.ReadCloser that reads the full HTTP message.
 func (mv *MessageView) Reader(opts ...Option) (io.ReadCloser, error) {
+	mv.materialize()
+
 	hr := mv.HeaderReader()
-	br, err := mv.BodyReader(opts...)
+	br, err := mv.markedBodyReader(opts...)
 	if err != nil {
 		return nil, err
 	}
-	tr := mv.TrailerReader()
+	tr := mv.trailerReader()
 
 	return struct {
 		io.Reader
@@ -237,18 +458,84 @@ func (mv *MessageView) HeaderReader() io.Reader {
 //
 // If the Decode option is passed the body will be unchunked if
 // Transfer-Encoding is set to "chunked", and will decode the following
-// Content-Encodings: gzip, deflate.
+// Content-Encodings: gzip, deflate and those with a decoder registered with
+// RegisterDecoder.
+//
+// If the body was truncated to the maximum body size, or a streamed body was
+// not yet read to the end, the reader ends with a marker, and decoding stops
+// at the end of the body read.
 func (mv *MessageView) BodyReader(opts ...Option) (io.ReadCloser, error) {
-	var r io.Reader
+	mv.materialize()
 
+	return mv.markedBodyReader(opts...)
+}
+
+// markedBodyReader returns the body reader, ending with a marker if the body
+// is truncated or incomplete.
+func (mv *MessageView) markedBodyReader(opts ...Option) (io.ReadCloser, error) {
 	conf := &config{}
 	for _, o := range opts {
 		o(conf)
 	}
 
+	r, err := mv.bodyReader(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	mv.mu.Lock()
+	var marker string
+	switch {
+	case mv.truncated:
+		marker = fmt.Sprintf("\n... (snapshot truncated to %d bytes)\n", mv.maxBody)
+	case mv.incomplete:
+		marker = "\n... (snapshot incomplete, body not read to the end)\n"
+	}
+	mv.mu.Unlock()
+
+	if marker == "" {
+		return r, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(truncatedReader{r}, strings.NewReader(marker)), r}, nil
+}
+
+// truncatedReader reads a decoded truncated or incomplete body, ending it without an error
+// where it is cut.
+type truncatedReader struct {
+	r io.Reader
+}
+
+func (tr truncatedReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (mv *MessageView) bodyReader(conf *config) (io.ReadCloser, error) {
+	var r io.Reader
+
 	br := bytes.NewReader(mv.message)
 	r = io.NewSectionReader(br, mv.bodyoffset, mv.traileroffset-mv.bodyoffset)
 
+	if conf.protos != nil {
+		if md := conf.protos.messageType(mv.contentType, mv.path, mv.response); md != nil {
+			if pr, err := mv.protoReader(md); err == nil {
+				return pr, nil
+			}
+		}
+	}
+
+	if conf.hex && BinaryContentType(mv.contentType) {
+		return mv.hexReader(r, conf)
+	}
+
 	if !conf.decode {
 		return ioutil.NopCloser(r), nil
 	}
@@ -266,13 +553,46 @@ func (mv *MessageView) BodyReader(opts ...Option) (io.ReadCloser, error) {
 	case "deflate":
 		return flate.NewReader(r), nil
 	default:
-		return ioutil.NopCloser(r), nil
+		return decode(mv.compress, r)
 	}
 }
 
+// hexReader returns a reader of the hexdump of the body read from r.
+func (mv *MessageView) hexReader(r io.Reader, conf *config) (io.ReadCloser, error) {
+	if conf.decode {
+		dr, err := mv.bodyReader(&config{decode: true})
+		if err != nil {
+			return nil, err
+		}
+		defer dr.Close()
+		r = dr
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(conf.hexMax)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if len(data) > conf.hexMax {
+		buf.WriteString(hex.Dump(data[:conf.hexMax]))
+		fmt.Fprintf(buf, "... (truncated to %d bytes)\n", conf.hexMax)
+	} else {
+		buf.WriteString(hex.Dump(data))
+	}
+
+	return ioutil.NopCloser(buf), nil
+}
+
 // TrailerReader returns an io.Reader that reads the HTTP request or response
 // trailers, if present.
 func (mv *MessageView) TrailerReader() io.Reader {
+	mv.materialize()
+
+	return mv.trailerReader()
+}
+
+func (mv *MessageView) trailerReader() io.Reader {
 	r := bytes.NewReader(mv.message)
 	end := int64(len(mv.message)) - mv.traileroffset
 
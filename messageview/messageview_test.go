@@ -19,6 +19,8 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -757,3 +759,327 @@ func TestResponseViewDecodeDeflateContentEncoding(t *testing.T) {
 		t.Fatalf("mv.Read(): got %q, want %q", got, want)
 	}
 }
+
+func TestResponseViewHexReader(t *testing.T) {
+	body := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0x01}
+
+	tests := []struct {
+		contentType string
+		max         int
+		want        string
+	}{
+		{
+			contentType: "image/png",
+			max:         16,
+			want:        hex.Dump(body),
+		},
+		{
+			contentType: "image/png",
+			max:         4,
+			want:        hex.Dump(body[:4]) + "... (truncated to 4 bytes)\n",
+		},
+		{
+			contentType: "text/plain",
+			max:         4,
+			want:        string(body),
+		},
+	}
+
+	for _, tc := range tests {
+		res := proxyutil.NewResponse(200, bytes.NewReader(body), nil)
+		res.Header.Set("Content-Type", tc.contentType)
+
+		mv := New()
+		if err := mv.SnapshotResponse(res); err != nil {
+			t.Fatalf("SnapshotResponse(): got %v, want no error", err)
+		}
+
+		br, err := mv.BodyReader(HexReader(tc.max))
+		if err != nil {
+			t.Fatalf("mv.BodyReader(): got %v, want no error", err)
+		}
+		got, err := ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll(mv.BodyReader()): got %v, want no error", err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s, HexReader(%d): got %q, want %q", tc.contentType, tc.max, got, tc.want)
+		}
+	}
+}
+
+func TestBinaryContentType(t *testing.T) {
+	tests := []struct {
+		ct   string
+		want bool
+	}{
+		{"application/octet-stream", true},
+		{"image/png", true},
+		{"image/svg+xml", false},
+		{"application/x-protobuf", true},
+		{"application/grpc+proto", true},
+		{"application/grpc-web-text", false},
+		{"text/html; charset=utf-8", false},
+		{"application/json", false},
+		{"", false},
+	}
+
+	for _, tc := range tests {
+		if got := BinaryContentType(tc.ct); got != tc.want {
+			t.Errorf("BinaryContentType(%q): got %t, want %t", tc.ct, got, tc.want)
+		}
+	}
+}
+
+func TestRequestViewMaxBodySize(t *testing.T) {
+	body := strings.NewReader("body content")
+	req, err := http.NewRequest("POST", "http://example.com/path", body)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.ContentLength = int64(body.Len())
+
+	mv := New()
+	mv.SetMaxBodySize(4)
+	if err := mv.SnapshotRequest(req); err != nil {
+		t.Fatalf("SnapshotRequest(): got %v, want no error", err)
+	}
+	if !mv.Truncated() {
+		t.Error("mv.Truncated(): got false, want true")
+	}
+
+	br, err := mv.BodyReader()
+	if err != nil {
+		t.Fatalf("mv.BodyReader(): got %v, want no error", err)
+	}
+	got, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(mv.BodyReader()): got %v, want no error", err)
+	}
+	if want := "body\n... (snapshot truncated to 4 bytes)\n"; string(got) != want {
+		t.Errorf("mv.BodyReader(): got %q, want %q", got, want)
+	}
+
+	// The request keeps its full body.
+	got, err = ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(req.Body): got %v, want no error", err)
+	}
+	if want := "body content"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+
+	// Bodies within the maximum size are not truncated.
+	req, err = http.NewRequest("POST", "http://example.com/path", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	mv = New()
+	mv.SetMaxBodySize(4)
+	if err := mv.SnapshotRequest(req); err != nil {
+		t.Fatalf("SnapshotRequest(): got %v, want no error", err)
+	}
+	if mv.Truncated() {
+		t.Error("mv.Truncated(): got true, want false")
+	}
+	br, err = mv.BodyReader()
+	if err != nil {
+		t.Fatalf("mv.BodyReader(): got %v, want no error", err)
+	}
+	if got, _ := ioutil.ReadAll(br); string(got) != "body" {
+		t.Errorf("mv.BodyReader(): got %q, want %q", got, "body")
+	}
+}
+
+func TestResponseViewMaxBodySizeDecode(t *testing.T) {
+	content := strings.Repeat("response content ", 100)
+
+	gzbuf := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzbuf)
+	gw.Write([]byte(content))
+	gw.Close()
+	compressed := gzbuf.Bytes()
+
+	res := proxyutil.NewResponse(200, bytes.NewReader(compressed), nil)
+	res.TransferEncoding = []string{"chunked"}
+	res.Header.Set("Content-Encoding", "gzip")
+
+	mv := New()
+	mv.SetMaxBodySize(int64(len(compressed) - 8))
+	if err := mv.SnapshotResponse(res); err != nil {
+		t.Fatalf("SnapshotResponse(): got %v, want no error", err)
+	}
+
+	// The decoded body ends where the snapshot is cut, without an error.
+	br, err := mv.BodyReader(Decode())
+	if err != nil {
+		t.Fatalf("mv.BodyReader(): got %v, want no error", err)
+	}
+	got, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(mv.BodyReader()): got %v, want no error", err)
+	}
+	marker := fmt.Sprintf("\n... (snapshot truncated to %d bytes)\n", len(compressed)-8)
+	if !bytes.HasSuffix(got, []byte(marker)) {
+		t.Errorf("mv.BodyReader(): got %q, want suffix %q", got, marker)
+	}
+	if decoded := strings.TrimSuffix(string(got), marker); !strings.HasPrefix(content, decoded) {
+		t.Errorf("mv.BodyReader(): got %q, want prefix of the content", decoded)
+	}
+
+	got, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got %v, want no error", err)
+	}
+	if !bytes.Equal(got, compressed) {
+		t.Errorf("res.Body: got %d bytes, want the full %d bytes", len(got), len(compressed))
+	}
+}
+
+func TestRequestViewStreaming(t *testing.T) {
+	body := strings.NewReader("body content")
+	req, err := http.NewRequest("POST", "http://example.com/path", body)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.ContentLength = int64(body.Len())
+
+	mv := New()
+	mv.SetStreaming(true)
+	if err := mv.SnapshotRequest(req); err != nil {
+		t.Fatalf("SnapshotRequest(): got %v, want no error", err)
+	}
+
+	// Nothing is read upfront.
+	if got, want := body.Len(), len("body content"); got != want {
+		t.Fatalf("body.Len(): got %d, want %d unread", got, want)
+	}
+
+	p := make([]byte, 4)
+	if _, err := io.ReadFull(req.Body, p); err != nil {
+		t.Fatalf("io.ReadFull(req.Body): got %v, want no error", err)
+	}
+
+	br, err := mv.BodyReader()
+	if err != nil {
+		t.Fatalf("mv.BodyReader(): got %v, want no error", err)
+	}
+	got, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(mv.BodyReader()): got %v, want no error", err)
+	}
+	if want := "body\n... (snapshot incomplete, body not read to the end)\n"; string(got) != want {
+		t.Errorf("mv.BodyReader(): got %q, want %q", got, want)
+	}
+
+	rest, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(req.Body): got %v, want no error", err)
+	}
+	if want := " content"; string(rest) != want {
+		t.Errorf("req.Body: got %q, want %q", rest, want)
+	}
+
+	r, err := mv.Reader()
+	if err != nil {
+		t.Fatalf("mv.Reader(): got %v, want no error", err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(mv.Reader()): got %v, want no error", err)
+	}
+	want := "POST http://example.com/path HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Content-Length: 12\r\n\r\n" +
+		"body content"
+	if string(got) != want {
+		t.Errorf("mv.Reader(): got %q, want %q", got, want)
+	}
+}
+
+func TestResponseViewStreamingChunked(t *testing.T) {
+	content := strings.Repeat("response content ", 10)
+
+	gzbuf := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzbuf)
+	gw.Write([]byte(content))
+	gw.Close()
+	compressed := gzbuf.Bytes()
+
+	res := proxyutil.NewResponse(200, bytes.NewReader(compressed), nil)
+	res.TransferEncoding = []string{"chunked"}
+	res.Header.Set("Content-Encoding", "gzip")
+	res.Trailer = http.Header{"Trailer-Header": []string{"true"}}
+
+	mv := New()
+	mv.SetStreaming(true)
+	mv.SetMaxBodySize(int64(len(compressed)))
+	if err := mv.SnapshotResponse(res); err != nil {
+		t.Fatalf("SnapshotResponse(): got %v, want no error", err)
+	}
+
+	if got, err := ioutil.ReadAll(mv.TrailerReader()); err != nil || len(got) != 0 {
+		t.Errorf("mv.TrailerReader(): got %q, %v, want no trailers before the end", got, err)
+	}
+
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got %v, want no error", err)
+	}
+	if !bytes.Equal(got, compressed) {
+		t.Fatalf("res.Body: got %d bytes, want %d bytes", len(got), len(compressed))
+	}
+	if mv.Truncated() {
+		t.Error("mv.Truncated(): got true, want false")
+	}
+
+	br, err := mv.BodyReader(Decode())
+	if err != nil {
+		t.Fatalf("mv.BodyReader(): got %v, want no error", err)
+	}
+	got, err = ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(mv.BodyReader()): got %v, want no error", err)
+	}
+	if string(got) != content {
+		t.Errorf("mv.BodyReader(): got %q, want %q", got, content)
+	}
+
+	if got, _ := ioutil.ReadAll(mv.TrailerReader()); string(got) != "Trailer-Header: true\r\n" {
+		t.Errorf("mv.TrailerReader(): got %q, want %q", got, "Trailer-Header: true\r\n")
+	}
+}
+
+func TestResponseViewStreamingMaxBodySize(t *testing.T) {
+	res := proxyutil.NewResponse(200, strings.NewReader("response content"), nil)
+
+	mv := New()
+	mv.SetStreaming(true)
+	mv.SetMaxBodySize(8)
+	if err := mv.SnapshotResponse(res); err != nil {
+		t.Fatalf("SnapshotResponse(): got %v, want no error", err)
+	}
+
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(res.Body): got %v, want no error", err)
+	}
+	if want := "response content"; string(got) != want {
+		t.Errorf("res.Body: got %q, want %q", got, want)
+	}
+	if !mv.Truncated() {
+		t.Error("mv.Truncated(): got false, want true")
+	}
+
+	br, err := mv.BodyReader()
+	if err != nil {
+		t.Fatalf("mv.BodyReader(): got %v, want no error", err)
+	}
+	got, err = ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(mv.BodyReader()): got %v, want no error", err)
+	}
+	if want := "response\n... (snapshot truncated to 8 bytes)\n"; string(got) != want {
+		t.Errorf("mv.BodyReader(): got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package messageview
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtoTypes resolves the message types of protocol buffer bodies from
+// registered file descriptors, so that they can be rendered as text with the
+// ProtoReader option.
+//
+// The message type of a gRPC body is the input or output type of the method
+// named by the request path. The message type of other protocol buffer bodies
+// is named by the "messageType" or "proto" parameter of the Content-Type, such
+// as "application/x-protobuf; messageType=example.Message".
+//
+// Types linked into the binary are resolved as well.
+type ProtoTypes struct {
+	mu    sync.RWMutex
+	files *protoregistry.Files
+}
+
+// NewProtoTypes returns a new ProtoTypes without registered files.
+func NewProtoTypes() *ProtoTypes {
+	return &ProtoTypes{
+		files: new(protoregistry.Files),
+	}
+}
+
+// RegisterFile registers the file descriptor fd.
+func (pt *ProtoTypes) RegisterFile(fd protoreflect.FileDescriptor) error {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	return pt.files.RegisterFile(fd)
+}
+
+// RegisterDescriptorSet registers the files of b, a serialized
+// google.protobuf.FileDescriptorSet as written by
+// "protoc --include_imports --descriptor_set_out". Imported files must be in
+// the set, registered before or linked into the binary. Files that are
+// already registered are skipped.
+func (pt *ProtoTypes) RegisterDescriptorSet(b []byte) error {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return fmt.Errorf("messageview: parsing descriptor set: %w", err)
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	for _, fdp := range set.GetFile() {
+		if _, err := pt.files.FindFileByPath(fdp.GetName()); err == nil {
+			continue
+		}
+
+		fd, err := protodesc.NewFile(fdp, resolver{pt.files})
+		if err != nil {
+			return fmt.Errorf("messageview: building descriptor of %s: %w", fdp.GetName(), err)
+		}
+		if err := pt.files.RegisterFile(fd); err != nil {
+			return fmt.Errorf("messageview: registering %s: %w", fdp.GetName(), err)
+		}
+	}
+
+	return nil
+}
+
+// LoadDescriptorSet registers the files of the descriptor set file at path,
+// see RegisterDescriptorSet.
+func (pt *ProtoTypes) LoadDescriptorSet(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return pt.RegisterDescriptorSet(b)
+}
+
+// resolver resolves descriptors from registered files and from the files
+// linked into the binary.
+type resolver struct {
+	files *protoregistry.Files
+}
+
+func (r resolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r resolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// messageType returns the descriptor of the message type of a body with the
+// Content-Type ct, of a request to path or of its response, or nil if the
+// type is unknown.
+func (pt *ProtoTypes) messageType(ct, path string, response bool) protoreflect.MessageDescriptor {
+	mt, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil
+	}
+
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+	r := resolver{pt.files}
+
+	if grpcContentType(mt) {
+		// The path of gRPC requests is /package.Service/Method.
+		service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		if !ok {
+			return nil
+		}
+		d, err := r.FindDescriptorByName(protoreflect.FullName(service))
+		if err != nil {
+			return nil
+		}
+		sd, ok := d.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil
+		}
+		m := sd.Methods().ByName(protoreflect.Name(method))
+		if m == nil {
+			return nil
+		}
+		if response {
+			return m.Output()
+		}
+		return m.Input()
+	}
+
+	for _, k := range []string{"messagetype", "proto"} {
+		name := params[k]
+		if name == "" {
+			continue
+		}
+		d, err := r.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil
+		}
+		md, _ := d.(protoreflect.MessageDescriptor)
+		return md
+	}
+
+	return nil
+}
+
+// grpcContentType reports whether the media type mt is of a gRPC body in the
+// binary wire format.
+func grpcContentType(mt string) bool {
+	return mt == "application/grpc" || strings.HasPrefix(mt, "application/grpc+")
+}
+
+// protoReader returns a reader of the body rendered in the protocol buffer text
+// format as a message of type md.
+func (mv *MessageView) protoReader(md protoreflect.MessageDescriptor) (io.ReadCloser, error) {
+	r, err := mv.bodyReader(&config{decode: true})
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	mt, _, _ := mime.ParseMediaType(mv.contentType)
+	if !grpcContentType(mt) {
+		b, err := protoText(md, data)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+
+	// gRPC bodies are a sequence of messages, each prefixed by a compressed
+	// flag and the length of the message.
+	buf := new(bytes.Buffer)
+	for i := 1; len(data) > 0; i++ {
+		if len(data) < 5 {
+			return nil, errors.New("messageview: truncated gRPC message prefix")
+		}
+		compressed := data[0]&1 == 1
+		n := binary.BigEndian.Uint32(data[1:5])
+		if uint64(len(data)-5) < uint64(n) {
+			return nil, errors.New("messageview: truncated gRPC message")
+		}
+		msg := data[5 : 5+n]
+		data = data[5+n:]
+
+		if compressed {
+			if mv.grpcEncoding != "gzip" {
+				return nil, fmt.Errorf("messageview: unsupported gRPC message encoding %q", mv.grpcEncoding)
+			}
+			gr, err := gzip.NewReader(bytes.NewReader(msg))
+			if err != nil {
+				return nil, err
+			}
+			if msg, err = ioutil.ReadAll(gr); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := protoText(md, msg)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "# message %d\n", i)
+		buf.Write(b)
+	}
+
+	return ioutil.NopCloser(buf), nil
+}
+
+// protoText returns the protocol buffer text format of the serialized message
+// data of type md.
+func protoText(md protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	m := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+
+	b, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+
+	return b, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package messageview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/google/martian/v3/proxyutil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// testDescriptorSet returns a serialized descriptor set of a file with the
+// message test.Echo and the service test.EchoService.
+func testDescriptorSet(t *testing.T) []byte {
+	t.Helper()
+
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("test.proto"),
+			Package: proto.String("test"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Echo"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:     proto.String("text"),
+					JsonName: proto.String("text"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				}},
+			}},
+			Service: []*descriptorpb.ServiceDescriptorProto{{
+				Name: proto.String("EchoService"),
+				Method: []*descriptorpb.MethodDescriptorProto{{
+					Name:       proto.String("Echo"),
+					InputType:  proto.String(".test.Echo"),
+					OutputType: proto.String(".test.Echo"),
+				}},
+			}},
+		}},
+	}
+
+	b, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("proto.Marshal(): got %v, want no error", err)
+	}
+	return b
+}
+
+// grpcFrame returns msg prefixed by the gRPC message prefix.
+func grpcFrame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+func TestProtoReader(t *testing.T) {
+	pt := NewProtoTypes()
+	if err := pt.RegisterDescriptorSet(testDescriptorSet(t)); err != nil {
+		t.Fatalf("RegisterDescriptorSet(): got %v, want no error", err)
+	}
+	// Registering the same files again is a no-op.
+	if err := pt.RegisterDescriptorSet(testDescriptorSet(t)); err != nil {
+		t.Fatalf("RegisterDescriptorSet(): got %v, want no error", err)
+	}
+
+	// Field 1, wire type 2, "hello".
+	echo := []byte{0x0a, 0x05, 'h', 'e', 'l', 'l', 'o'}
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        []byte
+		want        string
+	}{
+		{
+			name:        "messageType parameter",
+			contentType: `application/x-protobuf; messageType="test.Echo"`,
+			body:        echo,
+			want:        "text: \"hello\"\n",
+		},
+		{
+			name:        "gRPC",
+			path:        "/test.EchoService/Echo",
+			contentType: "application/grpc",
+			body:        append(grpcFrame(echo), grpcFrame(echo)...),
+			want:        "# message 1\ntext: \"hello\"\n# message 2\ntext: \"hello\"\n",
+		},
+		{
+			name:        "unknown message type",
+			contentType: "application/x-protobuf; messageType=test.Unknown",
+			body:        echo,
+			want:        string(echo),
+		},
+		{
+			name:        "unknown method",
+			path:        "/test.EchoService/Unknown",
+			contentType: "application/grpc",
+			body:        grpcFrame(echo),
+			want:        string(grpcFrame(echo)),
+		},
+		{
+			name:        "malformed body",
+			contentType: "application/x-protobuf; messageType=test.Echo",
+			body:        []byte{0x0a, 0x05},
+			want:        "\x0a\x05",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "http://example.com"+tc.path, nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			res := proxyutil.NewResponse(200, bytes.NewReader(tc.body), req)
+			res.Header.Set("Content-Type", tc.contentType)
+
+			mv := New()
+			if err := mv.SnapshotResponse(res); err != nil {
+				t.Fatalf("SnapshotResponse(): got %v, want no error", err)
+			}
+
+			br, err := mv.BodyReader(ProtoReader(pt))
+			if err != nil {
+				t.Fatalf("mv.BodyReader(): got %v, want no error", err)
+			}
+			got, err := ioutil.ReadAll(br)
+			if err != nil {
+				t.Fatalf("ioutil.ReadAll(mv.BodyReader()): got %v, want no error", err)
+			}
+			// The text format randomly adds whitespace to discourage comparing
+			// its output.
+			got = bytes.ReplaceAll(got, []byte(":  "), []byte(": "))
+			if string(got) != tc.want {
+				t.Errorf("mv.BodyReader(): got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
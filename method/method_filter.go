@@ -29,6 +29,7 @@ var noop = martian.Noop("method.Filter")
 
 func init() {
 	parse.Register("method.Filter", filterFromJSON)
+	filter.RegisterCondition("method", matcherFromJSON)
 }
 
 // Filter runs modifier iff the request method matches the specified method.
@@ -43,6 +44,19 @@ type filterJSON struct {
 	Scope        []parse.ModifierType `json:"scope"`
 }
 
+// filterFromJSON builds a method.Filter from JSON. Methods are matched
+// case-insensitively.
+//
+// Example JSON:
+//
+//	{
+//	  "method.Filter": {
+//	    "scope": ["request", "response"],
+//	    "method": "POST",
+//	    "modifier": { ... },
+//	    "else": { ... }
+//	  }
+//	}
 func filterFromJSON(b []byte) (*parse.Result, error) {
 	msg := &filterJSON{}
 	if err := json.Unmarshal(b, msg); err != nil {
@@ -116,6 +130,26 @@ func (m *Matcher) MatchResponse(res *http.Response) bool {
 	return matched
 }
 
+type matcherJSON struct {
+	Method string `json:"method"`
+}
+
+// matcherFromJSON builds a method.Matcher condition from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "method": "POST"
+//	}
+func matcherFromJSON(b []byte) (filter.Condition, error) {
+	msg := &matcherJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return NewMatcher(msg.Method), nil
+}
+
 func (m *Matcher) matches(method string) bool {
 	return strings.EqualFold(method, m.method)
 }
@@ -0,0 +1,304 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package metrics collects metrics of a proxy, such as requests by status,
+// bytes transferred and round trip latency, and exports them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of the round
+// trip latency histogram.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects metrics of a proxy. It implements martian.Observer, to be
+// set as martian.Proxy.Observer, and http.Handler, serving the metrics in the
+// Prometheus text exposition format, usually on /metrics.
+//
+// The session labels set with SetLabels are dimensions of the metrics of
+// requests, so that they can be sliced per device or test run.
+type Metrics struct {
+	mu sync.Mutex
+
+	// labels are the keys of the session labels recorded as dimensions.
+	labels []string
+
+	// requests counts the round trips by response status code, "error" for
+	// failed round trips, and session labels. Keys are Prometheus label
+	// pairs.
+	requests map[string]uint64
+
+	buckets []float64
+	// latency is the round trip latency histogram by session labels.
+	latency map[string]*histogram
+
+	conns        uint64
+	activeConns  int64
+	bytesRead    uint64
+	bytesWritten uint64
+
+	handshakes      uint64
+	handshakeErrors uint64
+	// modifierErrors counts the errors of modifiers by session labels.
+	modifierErrors map[string]uint64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var _ martian.Observer = (*Metrics)(nil)
+
+// New returns a new Metrics with the latency histogram buckets
+// DefaultBuckets.
+func New() *Metrics {
+	return NewWithBuckets(DefaultBuckets)
+}
+
+// NewWithBuckets returns a new Metrics with the given upper bounds, in
+// seconds, of the buckets of the latency histogram.
+func NewWithBuckets(buckets []float64) *Metrics {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+
+	return &Metrics{
+		requests:       make(map[string]uint64),
+		buckets:        b,
+		latency:        make(map[string]*histogram),
+		modifierErrors: make(map[string]uint64),
+	}
+}
+
+// SetLabels sets the keys of the session labels, such as "device" or "build",
+// recorded as Prometheus labels of the metrics of requests. Characters not
+// allowed in Prometheus label names are replaced by underscores, and requests
+// without a label have it empty. The keys "code" and "le" are reserved.
+func (m *Metrics) SetLabels(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.labels = append([]string(nil), keys...)
+}
+
+// ConnOpened counts an accepted client connection.
+func (m *Metrics) ConnOpened() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.conns++
+	m.activeConns++
+}
+
+// ConnClosed counts a closed client connection and the bytes read from and
+// written to it.
+func (m *Metrics) ConnClosed(read, written int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.activeConns--
+	m.bytesRead += uint64(read)
+	m.bytesWritten += uint64(written)
+}
+
+// MITMHandshake counts a TLS handshake with a client.
+func (m *Metrics) MITMHandshake(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handshakes++
+	if err != nil {
+		m.handshakeErrors++
+	}
+}
+
+// RoundTrip counts a round trip by status code and session labels and
+// observes its latency.
+func (m *Metrics) RoundTrip(req *http.Request, res *http.Response, d time.Duration, err error) {
+	code := "error"
+	if err == nil && res != nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := m.sessionLabels(req)
+	m.requests[join(fmt.Sprintf("code=%q", code), labels)]++
+
+	h, ok := m.latency[labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latency[labels] = h
+	}
+	s := d.Seconds()
+	h.count++
+	h.sum += s
+	for i, le := range m.buckets {
+		if s <= le {
+			h.counts[i]++
+			break
+		}
+	}
+}
+
+// ModifierError counts an error returned by a modifier by session labels.
+func (m *Metrics) ModifierError(req *http.Request, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.modifierErrors[m.sessionLabels(req)]++
+}
+
+// sessionLabels returns the session labels of req recorded as dimensions, as
+// Prometheus label pairs.
+func (m *Metrics) sessionLabels(req *http.Request) string {
+	if len(m.labels) == 0 {
+		return ""
+	}
+
+	var labels map[string]string
+	if ctx := martian.NewContext(req); ctx != nil {
+		labels = ctx.Session().Labels()
+	}
+	pairs := make([]string, len(m.labels))
+	for i, k := range m.labels {
+		pairs[i] = fmt.Sprintf("%s=%q", labelName(k), labels[k])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		rw.Header().Set("Allow", "GET, HEAD")
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	b := &bytes.Buffer{}
+	m.write(b)
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.Write(b.Bytes())
+}
+
+func (m *Metrics) write(b *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(b, "martian_requests_total", "counter", "Round trips by response status code, \"error\" for failed round trips.")
+	for _, labels := range sortedKeys(m.requests) {
+		fmt.Fprintf(b, "martian_requests_total{%s} %d\n", labels, m.requests[labels])
+	}
+
+	header(b, "martian_round_trip_duration_seconds", "histogram", "Time from the start of round trips to the response headers.")
+	latency := m.latency
+	if len(latency) == 0 {
+		latency = map[string]*histogram{"": {counts: make([]uint64, len(m.buckets))}}
+	}
+	keys := make([]string, 0, len(latency))
+	for labels := range latency {
+		keys = append(keys, labels)
+	}
+	sort.Strings(keys)
+	for _, labels := range keys {
+		h := latency[labels]
+		var cum uint64
+		for i, le := range m.buckets {
+			cum += h.counts[i]
+			fmt.Fprintf(b, "martian_round_trip_duration_seconds_bucket{%s} %d\n", join(labels, fmt.Sprintf("le=%q", formatFloat(le))), cum)
+		}
+		fmt.Fprintf(b, "martian_round_trip_duration_seconds_bucket{%s} %d\n", join(labels, `le="+Inf"`), h.count)
+		fmt.Fprintf(b, "martian_round_trip_duration_seconds_sum%s %s\n", braces(labels), formatFloat(h.sum))
+		fmt.Fprintf(b, "martian_round_trip_duration_seconds_count%s %d\n", braces(labels), h.count)
+	}
+
+	header(b, "martian_connections_total", "counter", "Client connections accepted.")
+	fmt.Fprintf(b, "martian_connections_total %d\n", m.conns)
+	header(b, "martian_active_connections", "gauge", "Client connections currently open.")
+	fmt.Fprintf(b, "martian_active_connections %d\n", m.activeConns)
+	header(b, "martian_client_read_bytes_total", "counter", "Bytes read from closed client connections.")
+	fmt.Fprintf(b, "martian_client_read_bytes_total %d\n", m.bytesRead)
+	header(b, "martian_client_written_bytes_total", "counter", "Bytes written to closed client connections.")
+	fmt.Fprintf(b, "martian_client_written_bytes_total %d\n", m.bytesWritten)
+
+	header(b, "martian_mitm_handshakes_total", "counter", "TLS handshakes with clients of MITM'd connections.")
+	fmt.Fprintf(b, "martian_mitm_handshakes_total %d\n", m.handshakes)
+	header(b, "martian_mitm_handshake_errors_total", "counter", "Failed TLS handshakes with clients of MITM'd connections.")
+	fmt.Fprintf(b, "martian_mitm_handshake_errors_total %d\n", m.handshakeErrors)
+
+	header(b, "martian_modifier_errors_total", "counter", "Errors returned by request and response modifiers.")
+	if len(m.modifierErrors) == 0 {
+		fmt.Fprintln(b, "martian_modifier_errors_total 0")
+	}
+	for _, labels := range sortedKeys(m.modifierErrors) {
+		fmt.Fprintf(b, "martian_modifier_errors_total%s %d\n", braces(labels), m.modifierErrors[labels])
+	}
+}
+
+func header(b *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+}
+
+// labelName returns key with the characters not allowed in Prometheus label
+// names replaced by underscores.
+func labelName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9' || i == 0) {
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
+
+// join joins the non-empty label pairs.
+func join(pairs ...string) string {
+	var nonEmpty []string
+	for _, p := range pairs {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+
+	return strings.Join(nonEmpty, ",")
+}
+
+// braces returns the label pairs in braces, or "" if there are none.
+func braces(pairs string) string {
+	if pairs == "" {
+		return ""
+	}
+
+	return "{" + pairs + "}"
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
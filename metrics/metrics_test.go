@@ -0,0 +1,116 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewWithBuckets([]float64{1, 0.1})
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	m.ConnOpened()
+	m.ConnOpened()
+	m.ConnClosed(100, 2000)
+	m.MITMHandshake(nil)
+	m.MITMHandshake(errors.New("bad certificate"))
+	m.RoundTrip(req, proxyutil.NewResponse(200, nil, req), 50*time.Millisecond, nil)
+	m.RoundTrip(req, proxyutil.NewResponse(200, nil, req), 500*time.Millisecond, nil)
+	m.RoundTrip(req, proxyutil.NewResponse(404, nil, req), 2*time.Second, nil)
+	m.RoundTrip(req, nil, time.Second, errors.New("connection refused"))
+	m.ModifierError(req, errors.New("modifier error"))
+
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, req)
+
+	if got, want := rw.Code, 200; got != want {
+		t.Fatalf("rw.Code: got %d, want %d", got, want)
+	}
+	if got, want := rw.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("rw.Header().Get(%q): got %q, want %q", "Content-Type", got, want)
+	}
+
+	body := rw.Body.String()
+	for _, want := range []string{
+		"# TYPE martian_requests_total counter\n",
+		`martian_requests_total{code="200"} 2` + "\n",
+		`martian_requests_total{code="404"} 1` + "\n",
+		`martian_requests_total{code="error"} 1` + "\n",
+		"# TYPE martian_round_trip_duration_seconds histogram\n",
+		`martian_round_trip_duration_seconds_bucket{le="0.1"} 1` + "\n",
+		`martian_round_trip_duration_seconds_bucket{le="1"} 3` + "\n",
+		`martian_round_trip_duration_seconds_bucket{le="+Inf"} 4` + "\n",
+		"martian_round_trip_duration_seconds_sum 3.55\n",
+		"martian_round_trip_duration_seconds_count 4\n",
+		"martian_connections_total 2\n",
+		"martian_active_connections 1\n",
+		"martian_client_read_bytes_total 100\n",
+		"martian_client_written_bytes_total 2000\n",
+		"martian_mitm_handshakes_total 2\n",
+		"martian_mitm_handshake_errors_total 1\n",
+		"martian_modifier_errors_total 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body: got %q, want to contain %q", body, want)
+		}
+	}
+}
+
+func TestMetricsSessionLabels(t *testing.T) {
+	m := NewWithBuckets([]float64{1})
+	m.SetLabels("device", "build-id")
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	ctx := martian.TestContext(req, nil, nil)
+	ctx.Session().SetLabel("device", "pixel7")
+
+	m.RoundTrip(req, proxyutil.NewResponse(200, nil, req), 500*time.Millisecond, nil)
+	m.ModifierError(req, errors.New("modifier error"))
+
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, req)
+
+	body := rw.Body.String()
+	for _, want := range []string{
+		`martian_requests_total{code="200",device="pixel7",build_id=""} 1` + "\n",
+		`martian_round_trip_duration_seconds_bucket{device="pixel7",build_id="",le="1"} 1` + "\n",
+		`martian_round_trip_duration_seconds_bucket{device="pixel7",build_id="",le="+Inf"} 1` + "\n",
+		`martian_round_trip_duration_seconds_sum{device="pixel7",build_id=""} 0.5` + "\n",
+		`martian_round_trip_duration_seconds_count{device="pixel7",build_id=""} 1` + "\n",
+		`martian_modifier_errors_total{device="pixel7",build_id=""} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body: got %q, want to contain %q", body, want)
+		}
+	}
+}
+
+func TestMetricsMethodNotAllowed(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://example.com/metrics", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	rw := httptest.NewRecorder()
+	New().ServeHTTP(rw, req)
+
+	if got, want := rw.Code, 405; got != want {
+		t.Errorf("rw.Code: got %d, want %d", got, want)
+	}
+}
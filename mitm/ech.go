@@ -0,0 +1,127 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package mitm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ECHPolicy determines how connections are handled whose ClientHello offers
+// Encrypted Client Hello. Since the certificate of a MITM'd connection is
+// generated for the outer, public server name, clients that use real ECH
+// reject it, while clients that only send GREASE ECH continue.
+//
+// The zero ECHPolicy handles these connections like any other: they are
+// MITM'd, which only works for clients sending GREASE ECH. The ECH extension
+// can not be stripped, as the ClientHello is part of the handshake transcript.
+type ECHPolicy int
+
+const (
+	// ECHPassthrough tunnels the connection to the destination without MITM.
+	ECHPassthrough ECHPolicy = iota + 1
+	// ECHReject closes the connection.
+	ECHReject
+)
+
+// String returns the name of the policy.
+func (p ECHPolicy) String() string {
+	switch p {
+	case 0:
+		return ""
+	case ECHPassthrough:
+		return "passthrough"
+	case ECHReject:
+		return "reject"
+	default:
+		return fmt.Sprintf("ECHPolicy(%d)", int(p))
+	}
+}
+
+// ParseECHPolicy returns the policy named s, one of "passthrough" or
+// "reject", or the zero ECHPolicy if s is empty.
+func ParseECHPolicy(s string) (ECHPolicy, error) {
+	for _, p := range []ECHPolicy{0, ECHPassthrough, ECHReject} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+
+	return 0, fmt.Errorf("mitm: unknown ECH policy %q", s)
+}
+
+// extensionECH is the TLS extension type of Encrypted Client Hello.
+const extensionECH = 0xfe0d
+
+// HasECH reports whether record, a TLS record holding a ClientHello, offers
+// Encrypted Client Hello. It returns false if record is not a complete
+// ClientHello record.
+func HasECH(record []byte) bool {
+	// TLS record header: type, version and length.
+	if len(record) < 5 || record[0] != 22 {
+		return false
+	}
+	b := record[5:]
+	if n := int(binary.BigEndian.Uint16(record[3:5])); len(b) > n {
+		b = b[:n]
+	}
+
+	// Handshake header: type and length.
+	if len(b) < 4 || b[0] != 1 {
+		return false
+	}
+	b = b[4:]
+
+	// Version and random.
+	if len(b) < 34 {
+		return false
+	}
+	b = b[34:]
+
+	// Session ID, cipher suites and compression methods.
+	var ok bool
+	if b, ok = skip(b, 1); !ok {
+		return false
+	}
+	if b, ok = skip(b, 2); !ok {
+		return false
+	}
+	if b, ok = skip(b, 1); !ok {
+		return false
+	}
+
+	// Extensions.
+	if len(b) < 2 {
+		return false
+	}
+	b = b[2:]
+	for len(b) >= 4 {
+		typ := binary.BigEndian.Uint16(b)
+		if typ == extensionECH {
+			return true
+		}
+		if b, ok = skip(b[2:], 2); !ok {
+			return false
+		}
+	}
+
+	return false
+}
+
+// skip skips a vector with a length prefix of n bytes.
+func skip(b []byte, n int) ([]byte, bool) {
+	if len(b) < n {
+		return nil, false
+	}
+
+	var l int
+	for _, c := range b[:n] {
+		l = l<<8 | int(c)
+	}
+	b = b[n:]
+	if len(b) < l {
+		return nil, false
+	}
+
+	return b[l:], true
+}
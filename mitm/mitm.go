@@ -19,6 +19,11 @@ package mitm
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -26,9 +31,12 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"errors"
+	"fmt"
+	"io"
 	"math/big"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -41,13 +49,53 @@ import (
 // bytes (2^(8*20)-1).
 var MaxSerialNumber = big.NewInt(0).SetBytes(bytes.Repeat([]byte{255}, 20))
 
+// KeyAlgorithm is the public key algorithm used for generated leaf
+// certificates.
+type KeyAlgorithm int
+
+const (
+	// RSA generates 2048-bit RSA leaf keys.
+	RSA KeyAlgorithm = iota
+	// ECDSAP256 generates ECDSA leaf keys on the NIST P-256 curve.
+	ECDSAP256
+	// Ed25519 generates Ed25519 leaf keys.
+	Ed25519
+)
+
+// String returns the name of the key algorithm.
+func (a KeyAlgorithm) String() string {
+	switch a {
+	case RSA:
+		return "RSA"
+	case ECDSAP256:
+		return "ECDSA-P256"
+	case Ed25519:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("KeyAlgorithm(%d)", int(a))
+	}
+}
+
+// leafKey is a private key shared by all leaf certificates of a given
+// algorithm along with its subject key identifier.
+type leafKey struct {
+	priv  crypto.Signer
+	keyID []byte
+}
+
+// certKey identifies a cached leaf certificate.
+type certKey struct {
+	hostname string
+	alg      KeyAlgorithm
+}
+
 // Config is a set of configuration values that are used to build TLS configs
 // capable of MITM.
 type Config struct {
 	ca                     *x509.Certificate
 	capriv                 any
-	priv                   *rsa.PrivateKey
-	keyID                  []byte
+	keys                   map[KeyAlgorithm]*leafKey
+	algs                   []KeyAlgorithm
 	validity               time.Duration
 	org                    string
 	h2Config               *h2.Config
@@ -55,9 +103,24 @@ type Config struct {
 	roots                  *x509.CertPool
 	skipVerify             bool
 	handshakeErrorCallback func(*http.Request, error)
-
-	certmu sync.RWMutex
-	certs  map[string]*tls.Certificate
+	keyLogWriter           io.Writer
+	sessionTicketsDisabled bool
+	clientCAs              *x509.CertPool
+	clientAuth             tls.ClientAuthType
+	ocspStapling           bool
+	originCert             func(ctx context.Context, addr, serverName string) (*x509.Certificate, error)
+	echPolicy              ECHPolicy
+	tlsPolicy              TLSPolicy
+
+	alpnmu sync.RWMutex
+	alpn   map[string][]string
+
+	ticketmu   sync.RWMutex
+	ticketKeys [][32]byte
+
+	certmu      sync.RWMutex
+	certs       map[certKey]*tls.Certificate
+	staticCerts map[string]*tls.Certificate
 }
 
 // NewAuthority creates a new CA certificate and associated
@@ -122,34 +185,144 @@ func NewConfig(ca *x509.Certificate, privateKey any) (*Config, error) {
 	roots := x509.NewCertPool()
 	roots.AddCert(ca)
 
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := newLeafKey(RSA)
+	if err != nil {
+		return nil, err
+	}
+
+	var ticketKey [32]byte
+	if _, err := rand.Read(ticketKey[:]); err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		ca:          ca,
+		capriv:      privateKey,
+		keys:        map[KeyAlgorithm]*leafKey{RSA: key},
+		algs:        []KeyAlgorithm{RSA},
+		validity:    time.Hour,
+		org:         "Martian Proxy",
+		certs:       make(map[certKey]*tls.Certificate),
+		staticCerts: make(map[string]*tls.Certificate),
+		roots:       roots,
+		ticketKeys:  [][32]byte{ticketKey},
+	}, nil
+}
+
+// newLeafKey generates a private key for alg.
+func newLeafKey(alg KeyAlgorithm) (*leafKey, error) {
+	var priv crypto.Signer
+	var err error
+	switch alg {
+	case RSA:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ECDSAP256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("mitm: unsupported key algorithm: %v", alg)
+	}
 	if err != nil {
 		return nil, err
 	}
-	pub := priv.Public()
 
 	// Subject Key Identifier support for end entity certificate.
 	// https://www.ietf.org/rfc/rfc3280.txt (section 4.2.1.2)
-	pkixpub, err := x509.MarshalPKIXPublicKey(pub)
+	pkixpub, err := x509.MarshalPKIXPublicKey(priv.Public())
 	if err != nil {
 		return nil, err
 	}
 	h := sha1.New()
 	h.Write(pkixpub)
-	keyID := h.Sum(nil)
 
-	return &Config{
-		ca:       ca,
-		capriv:   privateKey,
-		priv:     priv,
-		keyID:    keyID,
-		validity: time.Hour,
-		org:      "Martian Proxy",
-		certs:    make(map[string]*tls.Certificate),
-		roots:    roots,
+	return &leafKey{
+		priv:  priv,
+		keyID: h.Sum(nil),
 	}, nil
 }
 
+// SetLeafKeyAlgorithms sets the key algorithms used for generated leaf
+// certificates in order of preference. For each handshake the first algorithm
+// supported by the signature algorithms, curves and cipher suites of the
+// ClientHello is used; if none is supported the first algorithm is used.
+// ECDSA and Ed25519 keys are considerably cheaper for the handshake than RSA.
+// Defaults to RSA only.
+func (c *Config) SetLeafKeyAlgorithms(algs ...KeyAlgorithm) error {
+	if len(algs) == 0 {
+		return errors.New("mitm: no leaf key algorithms provided")
+	}
+
+	c.certmu.Lock()
+	defer c.certmu.Unlock()
+
+	for _, alg := range algs {
+		if _, ok := c.keys[alg]; ok {
+			continue
+		}
+		key, err := newLeafKey(alg)
+		if err != nil {
+			return err
+		}
+		c.keys[alg] = key
+	}
+	c.algs = append([]KeyAlgorithm(nil), algs...)
+
+	return nil
+}
+
+// SetCertificate sets a static certificate to serve for host instead of
+// generating one. Host may be a wildcard such as "*.example.com", which
+// matches any direct subdomain without an exact entry. Setting a certificate
+// with no Certificate data removes the entry for host.
+func (c *Config) SetCertificate(host string, cert tls.Certificate) error {
+	host = strings.ToLower(host)
+
+	c.certmu.Lock()
+	defer c.certmu.Unlock()
+
+	if len(cert.Certificate) == 0 {
+		delete(c.staticCerts, host)
+		return nil
+	}
+
+	if cert.Leaf == nil {
+		x509c, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("mitm: failed to parse certificate for %s: %w", host, err)
+		}
+		cert.Leaf = x509c
+	}
+
+	c.staticCerts[host] = &cert
+
+	return nil
+}
+
+// Rotate atomically replaces the CA certificate and private key used to sign
+// generated certificates and discards all cached certificates. Connections
+// established with certificates signed by the previous CA are not affected.
+func (c *Config) Rotate(ca *x509.Certificate, privateKey any) {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	c.certmu.Lock()
+	defer c.certmu.Unlock()
+
+	c.ca = ca
+	c.capriv = privateKey
+	c.roots = roots
+	c.certs = make(map[certKey]*tls.Certificate)
+}
+
+// CA returns the CA certificate used to sign generated certificates.
+func (c *Config) CA() *x509.Certificate {
+	c.certmu.RLock()
+	defer c.certmu.RUnlock()
+
+	return c.ca
+}
+
 // SetValidity sets the validity window around the current time that the
 // certificate is valid for.
 func (c *Config) SetValidity(validity time.Duration) {
@@ -161,6 +334,157 @@ func (c *Config) SkipTLSVerify(skip bool) {
 	c.skipVerify = skip
 }
 
+// SetKeyLogWriter sets a destination for TLS master secrets of the
+// client-facing MITM handshakes in NSS key log format, as used by the
+// SSLKEYLOGFILE environment variable. It allows decrypting captures of the
+// client connections with tools such as Wireshark and compromises security;
+// use for debugging only.
+func (c *Config) SetKeyLogWriter(w io.Writer) {
+	c.keyLogWriter = w
+}
+
+// SetClientAuth sets the policy for TLS client authentication on client-facing
+// connections and the pool of CAs used to verify client certificates. Use
+// tls.RequireAndVerifyClientCert to require mutual TLS, or
+// tls.VerifyClientCertIfGiven to make it optional. The verified client
+// certificate is available from martian.Session.ClientCertificate.
+func (c *Config) SetClientAuth(auth tls.ClientAuthType, clientCAs *x509.CertPool) {
+	c.clientAuth = auth
+	c.clientCAs = clientCAs
+}
+
+// SetSessionTicketsDisabled disables TLS session resumption for client-facing
+// connections. Resumption is enabled by default.
+func (c *Config) SetSessionTicketsDisabled(disabled bool) {
+	c.sessionTicketsDisabled = disabled
+}
+
+// SetSessionTicketKeys sets the keys used to encrypt and decrypt session
+// tickets of client-facing connections. The first key is used to encrypt new
+// tickets, all keys are tried for decryption, which allows rotating keys
+// without invalidating outstanding tickets. The keys are shared across all
+// hosts and default to a single random key generated by NewConfig. Sharing the
+// keys between proxy instances allows clients to resume sessions across them.
+func (c *Config) SetSessionTicketKeys(keys [][32]byte) error {
+	if len(keys) == 0 {
+		return errors.New("mitm: no session ticket keys provided")
+	}
+
+	c.ticketmu.Lock()
+	defer c.ticketmu.Unlock()
+
+	c.ticketKeys = append([][32]byte(nil), keys...)
+
+	return nil
+}
+
+// SetTLSPolicy sets the TLS versions, cipher suites and curves accepted on
+// client-facing connections.
+func (c *Config) SetTLSPolicy(policy TLSPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	c.tlsPolicy = policy
+
+	return nil
+}
+
+// SetALPNProtocols sets the ALPN protocols offered to clients connecting to
+// host, in order of preference, overriding the default of "h2" and "http/1.1"
+// for hosts allowed by the HTTP/2 config and "http/1.1" otherwise. host is
+// matched with and without port. "h2" is only offered on CONNECT tunnels and
+// when an HTTP/2 config is set. A nil protos restores the default for host.
+//
+// For example, SetALPNProtocols("example.com", []string{"http/1.1"}) forces
+// HTTP/1.1 for a host whose HTTP/2 behavior breaks interception.
+func (c *Config) SetALPNProtocols(host string, protos []string) {
+	host = strings.ToLower(host)
+
+	c.alpnmu.Lock()
+	defer c.alpnmu.Unlock()
+
+	if protos == nil {
+		delete(c.alpn, host)
+		return
+	}
+
+	if c.alpn == nil {
+		c.alpn = make(map[string][]string)
+	}
+	c.alpn[host] = append([]string(nil), protos...)
+}
+
+// alpnProtocols returns the ALPN protocols configured for host and whether
+// there are any.
+func (c *Config) alpnProtocols(host string) ([]string, bool) {
+	host = strings.ToLower(host)
+
+	c.alpnmu.RLock()
+	defer c.alpnmu.RUnlock()
+
+	if protos, ok := c.alpn[host]; ok {
+		return protos, true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		if protos, ok := c.alpn[h]; ok {
+			return protos, true
+		}
+	}
+
+	return nil, false
+}
+
+// nextProtos returns the ALPN protocols offered for host, with "h2" removed
+// unless allowH2 is set.
+func (c *Config) nextProtos(host string, allowH2 bool) []string {
+	protos, ok := c.alpnProtocols(host)
+	if !ok {
+		if allowH2 && c.h2AllowedHost(host) {
+			return []string{"h2", "http/1.1"}
+		}
+		return []string{"http/1.1"}
+	}
+
+	var np []string
+	for _, p := range protos {
+		if p == "h2" && (!allowH2 || c.h2Config == nil) {
+			continue
+		}
+		np = append(np, p)
+	}
+
+	return np
+}
+
+// SetOCSPStapling sets whether generated certificates are served with a
+// stapled OCSP response signed by the CA, for clients that require one.
+func (c *Config) SetOCSPStapling(enabled bool) {
+	c.ocspStapling = enabled
+}
+
+// SetOriginCertificateFunc sets a function that returns the certificate the
+// origin server at addr presents for serverName. When set, generated
+// certificates copy the subject alternative names and extended key usages of
+// the origin certificate. If f returns an error, the certificate is generated
+// for the server name only. It is called while the client waits for the
+// handshake; see OriginCertificateFetcher.
+func (c *Config) SetOriginCertificateFunc(f func(ctx context.Context, addr, serverName string) (*x509.Certificate, error)) {
+	c.originCert = f
+}
+
+// SetECHPolicy sets how connections offering Encrypted Client Hello are
+// handled. By default they are MITM'd like any other, see ECHPolicy.
+func (c *Config) SetECHPolicy(policy ECHPolicy) {
+	c.echPolicy = policy
+}
+
+// ECHPolicy returns the policy for connections offering Encrypted Client
+// Hello.
+func (c *Config) ECHPolicy() ECHPolicy {
+	return c.echPolicy
+}
+
 // SetOrganization sets the organization of the certificate.
 func (c *Config) SetOrganization(org string) {
 	c.org = org
@@ -193,38 +517,68 @@ func (c *Config) HandshakeErrorCallback(r *http.Request, err error) {
 // TLS returns a *tls.Config that will generate certificates on-the-fly using
 // the SNI extension in the TLS ClientHello.
 func (c *Config) TLS() *tls.Config {
-	return &tls.Config{
-		InsecureSkipVerify: c.skipVerify,
-		GetCertificate: func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-			if clientHello.ServerName == "" {
-				return nil, errors.New("mitm: SNI not provided, failed to build certificate")
-			}
-
-			return c.cert(clientHello.ServerName)
-		},
-		NextProtos: []string{"http/1.1"},
+	tc := c.baseTLS()
+	tc.GetCertificate = func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if clientHello.ServerName == "" {
+			return nil, errors.New("mitm: SNI not provided, failed to build certificate")
+		}
+
+		addr := net.JoinHostPort(clientHello.ServerName, "443")
+		return c.certFor(clientHello.Context(), clientHello.ServerName, addr, c.keyAlgorithm(clientHello))
 	}
+	tc.NextProtos = []string{"http/1.1"}
+	tc.GetConfigForClient = func(clientHello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if _, ok := c.alpnProtocols(clientHello.ServerName); !ok {
+			return nil, nil
+		}
+
+		hc := tc.Clone()
+		hc.GetConfigForClient = nil
+		hc.NextProtos = c.nextProtos(clientHello.ServerName, false)
+		return hc, nil
+	}
+
+	return tc
 }
 
 // TLSForHost returns a *tls.Config that will generate certificates on-the-fly
 // using SNI from the connection, or fall back to the provided hostname.
 func (c *Config) TLSForHost(hostname string) *tls.Config {
-	nextProtos := []string{"http/1.1"}
-	if c.h2AllowedHost(hostname) {
-		nextProtos = []string{"h2", "http/1.1"}
-	}
-	return &tls.Config{
-		InsecureSkipVerify: c.skipVerify,
-		GetCertificate: func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-			host := clientHello.ServerName
-			if host == "" {
-				host = hostname
-			}
-
-			return c.cert(host)
-		},
-		NextProtos: nextProtos,
+	tc := c.baseTLS()
+	tc.GetCertificate = func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		host := clientHello.ServerName
+		if host == "" {
+			host = hostname
+		}
+
+		return c.certFor(clientHello.Context(), host, originAddr(hostname), c.keyAlgorithm(clientHello))
+	}
+	tc.NextProtos = c.nextProtos(hostname, true)
+
+	return tc
+}
+
+// baseTLS returns a *tls.Config with the settings shared by all client-facing
+// connections.
+func (c *Config) baseTLS() *tls.Config {
+	tc := &tls.Config{
+		InsecureSkipVerify:     c.skipVerify,
+		KeyLogWriter:           c.keyLogWriter,
+		SessionTicketsDisabled: c.sessionTicketsDisabled,
+		ClientCAs:              c.clientCAs,
+		ClientAuth:             c.clientAuth,
 	}
+	c.tlsPolicy.Apply(tc)
+
+	// Share the session ticket keys across connections to all hosts, so that
+	// clients can resume sessions on new connections.
+	c.ticketmu.RLock()
+	if !c.sessionTicketsDisabled && len(c.ticketKeys) > 0 {
+		tc.SetSessionTicketKeys(c.ticketKeys)
+	}
+	c.ticketmu.RUnlock()
+
+	return tc
 }
 
 func (c *Config) h2AllowedHost(host string) bool {
@@ -233,33 +587,107 @@ func (c *Config) h2AllowedHost(host string) bool {
 		c.h2Config.AllowedHostsFilter(host)
 }
 
+// keyAlgorithm returns the most preferred leaf key algorithm supported by the
+// client.
+func (c *Config) keyAlgorithm(clientHello *tls.ClientHelloInfo) KeyAlgorithm {
+	c.certmu.RLock()
+	defer c.certmu.RUnlock()
+
+	if len(c.algs) == 1 {
+		return c.algs[0]
+	}
+
+	// The server name is only used by SupportsCertificate to match the leaf,
+	// which does not exist yet.
+	chi := *clientHello
+	chi.ServerName = ""
+	for _, alg := range c.algs {
+		if err := chi.SupportsCertificate(&tls.Certificate{PrivateKey: c.keys[alg].priv}); err == nil {
+			return alg
+		}
+	}
+
+	return c.algs[0]
+}
+
+// staticCert returns the static certificate for hostname, if any. It must be
+// called with certmu held.
+func (c *Config) staticCert(hostname string) (*tls.Certificate, bool) {
+	if len(c.staticCerts) == 0 {
+		return nil, false
+	}
+
+	hostname = strings.ToLower(hostname)
+	if tlsc, ok := c.staticCerts[hostname]; ok {
+		return tlsc, true
+	}
+	if i := strings.IndexByte(hostname, '.'); i > 0 {
+		if tlsc, ok := c.staticCerts["*"+hostname[i:]]; ok {
+			return tlsc, true
+		}
+	}
+
+	return nil, false
+}
+
 func (c *Config) cert(hostname string) (*tls.Certificate, error) {
+	c.certmu.RLock()
+	alg := c.algs[0]
+	c.certmu.RUnlock()
+
+	return c.certFor(context.Background(), hostname, originAddr(hostname), alg)
+}
+
+// originAddr returns the address of the origin server for hostname, which may
+// include a port.
+func originAddr(hostname string) string {
+	if _, _, err := net.SplitHostPort(hostname); err == nil {
+		return hostname
+	}
+	return net.JoinHostPort(hostname, "443")
+}
+
+// certFor returns the certificate for hostname with a key of alg, logging with
+// the logger of ctx. The origin certificate, if requested, is fetched from
+// addr.
+func (c *Config) certFor(ctx context.Context, hostname, addr string, alg KeyAlgorithm) (*tls.Certificate, error) {
+	lg := log.FromContext(ctx)
+
 	// Remove the port if it exists.
 	host, _, err := net.SplitHostPort(hostname)
 	if err == nil {
 		hostname = host
 	}
 
+	ck := certKey{hostname: hostname, alg: alg}
+
 	c.certmu.RLock()
-	tlsc, ok := c.certs[hostname]
+	if tlsc, ok := c.staticCert(hostname); ok {
+		c.certmu.RUnlock()
+		lg.Debugf("mitm: static certificate for %s", hostname)
+		return tlsc, nil
+	}
+	tlsc, ok := c.certs[ck]
+	key := c.keys[alg]
+	ca, capriv, roots := c.ca, c.capriv, c.roots
 	c.certmu.RUnlock()
 
 	if ok {
-		log.Debugf("mitm: cache hit for %s", hostname)
+		lg.Debugf("mitm: cache hit for %s", hostname)
 
 		// Check validity of the certificate for hostname match, expiry, etc. In
 		// particular, if the cached certificate has expired, create a new one.
 		if _, err := tlsc.Leaf.Verify(x509.VerifyOptions{
 			DNSName: hostname,
-			Roots:   c.roots,
+			Roots:   roots,
 		}); err == nil {
 			return tlsc, nil
 		}
 
-		log.Debugf("mitm: invalid certificate in cache for %s", hostname)
+		lg.Debugf("mitm: invalid certificate in cache for %s", hostname)
 	}
 
-	log.Debugf("mitm: cache miss for %s", hostname)
+	lg.Debugf("mitm: cache miss for %s (%v)", hostname, alg)
 
 	serial, err := rand.Int(rand.Reader, MaxSerialNumber)
 	if err != nil {
@@ -272,21 +700,34 @@ func (c *Config) cert(hostname string) (*tls.Certificate, error) {
 			CommonName:   hostname,
 			Organization: []string{c.org},
 		},
-		SubjectKeyId:          c.keyID,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		SubjectKeyId:          key.keyID,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		NotBefore:             time.Now().Add(-c.validity),
 		NotAfter:              time.Now().Add(c.validity),
 	}
 
+	// Key encipherment is only meaningful for RSA key transport.
+	if alg == RSA {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
 	if ip := net.ParseIP(hostname); ip != nil {
 		tmpl.IPAddresses = []net.IP{ip}
 	} else {
 		tmpl.DNSNames = []string{hostname}
 	}
 
-	raw, err := x509.CreateCertificate(rand.Reader, tmpl, c.ca, c.priv.Public(), c.capriv)
+	if c.originCert != nil {
+		if origin, err := c.originCert(ctx, addr, hostname); err != nil {
+			lg.Debugf("mitm: failed to get origin certificate for %s: %v", hostname, err)
+		} else {
+			copyOriginCert(tmpl, origin, hostname)
+		}
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.priv.Public(), capriv)
 	if err != nil {
 		return nil, err
 	}
@@ -298,14 +739,47 @@ func (c *Config) cert(hostname string) (*tls.Certificate, error) {
 	}
 
 	tlsc = &tls.Certificate{
-		Certificate: [][]byte{raw, c.ca.Raw},
-		PrivateKey:  c.priv,
+		Certificate: [][]byte{raw, ca.Raw},
+		PrivateKey:  key.priv,
 		Leaf:        x509c,
 	}
 
+	if c.ocspStapling {
+		staple, err := newOCSPStaple(ca, capriv, serial, tmpl.NotBefore, tmpl.NotAfter)
+		if err != nil {
+			return nil, err
+		}
+		tlsc.OCSPStaple = staple
+	}
+
 	c.certmu.Lock()
-	c.certs[hostname] = tlsc
+	// Do not cache certificates signed by a CA that was rotated meanwhile.
+	if c.ca == ca {
+		c.certs[ck] = tlsc
+	}
 	c.certmu.Unlock()
 
 	return tlsc, nil
 }
+
+// copyOriginCert copies the subject alternative names and extended key usages
+// of origin to tmpl, keeping hostname if origin is not valid for it.
+func copyOriginCert(tmpl, origin *x509.Certificate, hostname string) {
+	if len(origin.ExtKeyUsage) > 0 || len(origin.UnknownExtKeyUsage) > 0 {
+		tmpl.ExtKeyUsage = origin.ExtKeyUsage
+		tmpl.UnknownExtKeyUsage = origin.UnknownExtKeyUsage
+	}
+
+	if len(origin.DNSNames) == 0 && len(origin.IPAddresses) == 0 {
+		return
+	}
+
+	dnsNames, ips := origin.DNSNames, origin.IPAddresses
+	if origin.VerifyHostname(hostname) != nil {
+		dnsNames = append(tmpl.DNSNames, dnsNames...)
+		ips = append(tmpl.IPAddresses, ips...)
+	}
+	tmpl.DNSNames = dnsNames
+	tmpl.IPAddresses = ips
+	tmpl.URIs = origin.URIs
+}
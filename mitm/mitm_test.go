@@ -15,12 +15,23 @@
 package mitm
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/google/martian/v3/h2"
 )
 
 func TestMITM(t *testing.T) {
@@ -203,3 +214,661 @@ func TestCert(t *testing.T) {
 		t.Fatalf("x509c.IPAddresses: got %v, want %v", got, want)
 	}
 }
+
+func TestLeafKeyAlgorithms(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+
+	if err := c.SetLeafKeyAlgorithms(); err == nil {
+		t.Error("c.SetLeafKeyAlgorithms(): got nil, want error")
+	}
+	if err := c.SetLeafKeyAlgorithms(KeyAlgorithm(42)); err == nil {
+		t.Error("c.SetLeafKeyAlgorithms(42): got nil, want error")
+	}
+
+	tt := []struct {
+		algs []KeyAlgorithm
+		want x509.PublicKeyAlgorithm
+	}{
+		{[]KeyAlgorithm{ECDSAP256, RSA}, x509.ECDSA},
+		{[]KeyAlgorithm{Ed25519, RSA}, x509.Ed25519},
+		{[]KeyAlgorithm{RSA, ECDSAP256}, x509.RSA},
+	}
+
+	for i, tc := range tt {
+		if err := c.SetLeafKeyAlgorithms(tc.algs...); err != nil {
+			t.Fatalf("%d. c.SetLeafKeyAlgorithms(): got %v, want no error", i, err)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(ca)
+
+		cc, sc := net.Pipe()
+		errc := make(chan error, 1)
+		go func() {
+			errc <- tls.Server(sc, c.TLS()).Handshake()
+			sc.Close()
+		}()
+
+		tlsc := tls.Client(cc, &tls.Config{
+			ServerName: "example.com",
+			RootCAs:    roots,
+		})
+		if err := tlsc.Handshake(); err != nil {
+			t.Fatalf("%d. tlsc.Handshake(): got %v, want no error", i, err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("%d. server handshake: got %v, want no error", i, err)
+		}
+
+		leaf := tlsc.ConnectionState().PeerCertificates[0]
+		if got := leaf.PublicKeyAlgorithm; got != tc.want {
+			t.Errorf("%d. leaf.PublicKeyAlgorithm: got %v, want %v", i, got, tc.want)
+		}
+		cc.Close()
+	}
+
+	// Clients that do not support ECDSA fall back to the next algorithm.
+	if err := c.SetLeafKeyAlgorithms(ECDSAP256, RSA); err != nil {
+		t.Fatalf("c.SetLeafKeyAlgorithms(): got %v, want no error", err)
+	}
+	clientHello := &tls.ClientHelloInfo{
+		ServerName:        "example.com",
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.PSSWithSHA256},
+	}
+	tlsc, err := c.TLS().GetCertificate(clientHello)
+	if err != nil {
+		t.Fatalf("GetCertificate(): got %v, want no error", err)
+	}
+	if got, want := tlsc.Leaf.PublicKeyAlgorithm, x509.RSA; got != want {
+		t.Errorf("tlsc.Leaf.PublicKeyAlgorithm: got %v, want %v", got, want)
+	}
+	if got, want := tlsc.Leaf.KeyUsage, x509.KeyUsageKeyEncipherment; got&want == 0 {
+		t.Error("tlsc.Leaf.KeyUsage: got nothing, want to include x509.KeyUsageKeyEncipherment")
+	}
+}
+
+func TestSetCertificate(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+
+	// Issue the static certificate from a different authority.
+	ca2, priv2, err := NewAuthority("other.proxy", "Other Authority", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+	c2, err := NewConfig(ca2, priv2)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+	static, err := c2.cert("static.example.com")
+	if err != nil {
+		t.Fatalf("c2.cert(): got %v, want no error", err)
+	}
+
+	if err := c.SetCertificate("Static.Example.com", tls.Certificate{Certificate: static.Certificate, PrivateKey: static.PrivateKey}); err != nil {
+		t.Fatalf("c.SetCertificate(): got %v, want no error", err)
+	}
+	if err := c.SetCertificate("*.wild.example.com", *static); err != nil {
+		t.Fatalf("c.SetCertificate(): got %v, want no error", err)
+	}
+	if err := c.SetCertificate("bad.example.com", tls.Certificate{Certificate: [][]byte{[]byte("bad")}}); err == nil {
+		t.Error("c.SetCertificate(): got nil, want error")
+	}
+
+	tt := []struct {
+		host   string
+		static bool
+	}{
+		{"static.example.com:443", true},
+		{"a.wild.example.com", true},
+		{"a.b.wild.example.com", false},
+		{"other.example.com", false},
+	}
+
+	for i, tc := range tt {
+		tlsc, err := c.cert(tc.host)
+		if err != nil {
+			t.Fatalf("%d. c.cert(%q): got %v, want no error", i, tc.host, err)
+		}
+		if got := tlsc.Leaf != nil && tlsc.Leaf.SerialNumber.Cmp(static.Leaf.SerialNumber) == 0; got != tc.static {
+			t.Errorf("%d. c.cert(%q) is static: got %t, want %t", i, tc.host, got, tc.static)
+		}
+	}
+
+	// Removing the certificate falls back to generated certificates.
+	if err := c.SetCertificate("static.example.com", tls.Certificate{}); err != nil {
+		t.Fatalf("c.SetCertificate(): got %v, want no error", err)
+	}
+	tlsc, err := c.cert("static.example.com")
+	if err != nil {
+		t.Fatalf("c.cert(): got %v, want no error", err)
+	}
+	if got, want := tlsc.Leaf.Issuer.CommonName, "martian.proxy"; got != want {
+		t.Errorf("tlsc.Leaf.Issuer.CommonName: got %q, want %q", got, want)
+	}
+}
+
+func TestKeyLogWriter(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+
+	buf := new(bytes.Buffer)
+	c.SetKeyLogWriter(buf)
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tls.Server(sc, c.TLSForHost("example.com")).Handshake()
+		sc.Close()
+	}()
+
+	tlsc := tls.Client(cc, &tls.Config{
+		ServerName:         "example.com",
+		InsecureSkipVerify: true,
+	})
+	if err := tlsc.Handshake(); err != nil {
+		t.Fatalf("tlsc.Handshake(): got %v, want no error", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("server handshake: got %v, want no error", err)
+	}
+
+	if got := buf.String(); !strings.Contains(got, "CLIENT_HANDSHAKE_TRAFFIC_SECRET") && !strings.Contains(got, "CLIENT_RANDOM") {
+		t.Errorf("key log: got %q, want NSS key log entries", got)
+	}
+}
+
+func TestSessionResumption(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	cache := tls.NewLRUClientSessionCache(1)
+
+	handshake := func(host string) bool {
+		cc, sc := net.Pipe()
+		defer cc.Close()
+
+		errc := make(chan error, 1)
+		go func() {
+			errc <- tls.Server(sc, c.TLSForHost(host)).Handshake()
+			sc.Close()
+		}()
+
+		tlsc := tls.Client(cc, &tls.Config{
+			ServerName:         "example.com",
+			RootCAs:            roots,
+			ClientSessionCache: cache,
+			MaxVersion:         tls.VersionTLS12,
+		})
+		if err := tlsc.Handshake(); err != nil {
+			t.Fatalf("tlsc.Handshake(): got %v, want no error", err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("server handshake: got %v, want no error", err)
+		}
+
+		return tlsc.ConnectionState().DidResume
+	}
+
+	if handshake("example.com") {
+		t.Error("first handshake: got resumed, want full handshake")
+	}
+	// Each CONNECT gets its own *tls.Config, which must share ticket keys.
+	if !handshake("example.com") {
+		t.Error("second handshake: got full handshake, want resumed")
+	}
+
+	// Rotating keys invalidates outstanding tickets.
+	if err := c.SetSessionTicketKeys([][32]byte{{1}}); err != nil {
+		t.Fatalf("c.SetSessionTicketKeys(): got %v, want no error", err)
+	}
+	if handshake("example.com") {
+		t.Error("handshake after rotation: got resumed, want full handshake")
+	}
+
+	c.SetSessionTicketsDisabled(true)
+	handshake("example.com")
+	if handshake("example.com") {
+		t.Error("handshake with tickets disabled: got resumed, want full handshake")
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+
+	// Issue a client certificate from a separate authority. Authorities from
+	// NewAuthority are restricted to server auth.
+	clientCAPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey(): got %v, want no error", err)
+	}
+	catmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client.ca"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, catmpl, catmpl, clientCAPriv.Public(), clientCAPriv)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate(): got %v, want no error", err)
+	}
+	clientCA, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate(): got %v, want no error", err)
+	}
+
+	clientPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey(): got %v, want no error", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client-1"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	raw, err = x509.CreateCertificate(rand.Reader, tmpl, clientCA, clientPriv.Public(), clientCAPriv)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate(): got %v, want no error", err)
+	}
+	clientCert := tls.Certificate{
+		Certificate: [][]byte{raw},
+		PrivateKey:  clientPriv,
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA)
+	c.SetClientAuth(tls.RequireAndVerifyClientCert, clientCAs)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	handshake := func(certs []tls.Certificate) (*tls.ConnectionState, error) {
+		cconn, sconn := net.Pipe()
+
+		server := tls.Server(sconn, c.TLSForHost("example.com"))
+		errc := make(chan error, 1)
+		go func() {
+			errc <- server.Handshake()
+			sconn.Close()
+		}()
+
+		tlsc := tls.Client(cconn, &tls.Config{
+			ServerName:   "example.com",
+			RootCAs:      roots,
+			Certificates: certs,
+		})
+		if err := tlsc.Handshake(); err == nil {
+			// Read the server's response to the client certificate.
+			tlsc.Read(make([]byte, 1))
+		}
+		cconn.Close()
+
+		if err := <-errc; err != nil {
+			return nil, err
+		}
+		cs := server.ConnectionState()
+		return &cs, nil
+	}
+
+	if _, err := handshake(nil); err == nil {
+		t.Error("handshake without client certificate: got nil, want error")
+	}
+
+	cs, err := handshake([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("handshake with client certificate: got %v, want no error", err)
+	}
+	if got, want := len(cs.VerifiedChains), 1; got != want {
+		t.Fatalf("len(cs.VerifiedChains): got %d, want %d", got, want)
+	}
+	if got, want := cs.PeerCertificates[0].Subject.CommonName, "client-1"; got != want {
+		t.Errorf("cs.PeerCertificates[0].Subject.CommonName: got %q, want %q", got, want)
+	}
+}
+
+func TestOCSPStapling(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+	c.SetOCSPStapling(true)
+
+	tlsc, err := c.cert("example.com")
+	if err != nil {
+		t.Fatalf("c.cert(%q): got %v, want no error", "example.com", err)
+	}
+	if len(tlsc.OCSPStaple) == 0 {
+		t.Fatal("tlsc.OCSPStaple: got empty, want OCSP response")
+	}
+
+	resp := &ocspResponse{}
+	if _, err := asn1.Unmarshal(tlsc.OCSPStaple, resp); err != nil {
+		t.Fatalf("asn1.Unmarshal(): got %v, want no error", err)
+	}
+	if got, want := resp.ResponseBytes.ResponseType, oidOCSPBasic; !got.Equal(want) {
+		t.Errorf("resp.ResponseBytes.ResponseType: got %v, want %v", got, want)
+	}
+
+	basic := &ocspBasicResponse{}
+	if _, err := asn1.Unmarshal(resp.ResponseBytes.Response, basic); err != nil {
+		t.Fatalf("asn1.Unmarshal(): got %v, want no error", err)
+	}
+	if err := ca.CheckSignature(x509.SHA256WithRSA, basic.TBSResponseData.FullBytes, basic.Signature.Bytes); err != nil {
+		t.Errorf("ca.CheckSignature(): got %v, want no error", err)
+	}
+
+	data := &ocspResponseData{}
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, data); err != nil {
+		t.Fatalf("asn1.Unmarshal(): got %v, want no error", err)
+	}
+	if got, want := len(data.Responses), 1; got != want {
+		t.Fatalf("len(data.Responses): got %d, want %d", got, want)
+	}
+	sr := data.Responses[0]
+	if got, want := sr.CertID.SerialNumber, tlsc.Leaf.SerialNumber; got.Cmp(want) != 0 {
+		t.Errorf("sr.CertID.SerialNumber: got %v, want %v", got, want)
+	}
+	if !sr.Good {
+		t.Error("sr.Good: got false, want true")
+	}
+
+	// The staple is served in the handshake.
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+
+	go func() {
+		defer sconn.Close()
+		tls.Server(sconn, c.TLS()).Handshake()
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	tc := tls.Client(cconn, &tls.Config{
+		ServerName: "example.com",
+		RootCAs:    roots,
+	})
+	if err := tc.Handshake(); err != nil {
+		t.Fatalf("tc.Handshake(): got %v, want no error", err)
+	}
+	if got, want := tc.ConnectionState().OCSPResponse, tlsc.OCSPStaple; !bytes.Equal(got, want) {
+		t.Error("tc.ConnectionState().OCSPResponse: got different response, want stapled response")
+	}
+}
+
+func TestOriginCertificate(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+
+	origin := &x509.Certificate{
+		DNSNames:    []string{"example.com", "www.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	var gotAddr, gotHost string
+	c.SetOriginCertificateFunc(func(_ context.Context, addr, serverName string) (*x509.Certificate, error) {
+		gotAddr, gotHost = addr, serverName
+		return origin, nil
+	})
+
+	tlsc, err := c.cert("www.example.com:8443")
+	if err != nil {
+		t.Fatalf("c.cert(%q): got %v, want no error", "www.example.com:8443", err)
+	}
+	if got, want := gotAddr, "www.example.com:8443"; got != want {
+		t.Errorf("addr: got %q, want %q", got, want)
+	}
+	if got, want := gotHost, "www.example.com"; got != want {
+		t.Errorf("serverName: got %q, want %q", got, want)
+	}
+
+	x509c := tlsc.Leaf
+	if got, want := x509c.DNSNames, origin.DNSNames; !reflect.DeepEqual(got, want) {
+		t.Errorf("x509c.DNSNames: got %v, want %v", got, want)
+	}
+	if got, want := len(x509c.IPAddresses), 1; got != want {
+		t.Errorf("len(x509c.IPAddresses): got %d, want %d", got, want)
+	}
+	if got, want := x509c.ExtKeyUsage, origin.ExtKeyUsage; !reflect.DeepEqual(got, want) {
+		t.Errorf("x509c.ExtKeyUsage: got %v, want %v", got, want)
+	}
+
+	// The hostname is kept when the origin certificate is not valid for it.
+	tlsc, err = c.cert("other.example.com")
+	if err != nil {
+		t.Fatalf("c.cert(%q): got %v, want no error", "other.example.com", err)
+	}
+	if got, want := tlsc.Leaf.DNSNames, []string{"other.example.com", "example.com", "www.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tlsc.Leaf.DNSNames: got %v, want %v", got, want)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+	tc := c.TLS()
+
+	tlsc, err := tc.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("tc.GetCertificate(): got %v, want no error", err)
+	}
+	if got, want := tlsc.Leaf.Issuer.CommonName, "martian.proxy"; got != want {
+		t.Errorf("tlsc.Leaf.Issuer.CommonName: got %q, want %q", got, want)
+	}
+
+	ca2, priv2, err := NewAuthority("martian.rotated", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+	c.Rotate(ca2, priv2)
+
+	tlsc2, err := tc.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("tc.GetCertificate(): got %v, want no error", err)
+	}
+	if got, want := tlsc2.Leaf.Issuer.CommonName, "martian.rotated"; got != want {
+		t.Errorf("tlsc2.Leaf.Issuer.CommonName: got %q, want %q", got, want)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca2)
+	if _, err := tlsc2.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err != nil {
+		t.Errorf("tlsc2.Leaf.Verify(): got %v, want no error", err)
+	}
+	if got, want := tlsc2.Certificate[1], ca2.Raw; !bytes.Equal(got, want) {
+		t.Error("tlsc2.Certificate[1]: got previous CA, want rotated CA")
+	}
+}
+
+// clientHello returns a TLS record holding a minimal ClientHello with the
+// given extensions.
+func clientHello(exts ...uint16) []byte {
+	var ext []byte
+	for _, e := range exts {
+		ext = append(ext, byte(e>>8), byte(e), 0, 1, 0)
+	}
+
+	hs := []byte{0x03, 0x03}
+	hs = append(hs, make([]byte, 32)...) // random
+	hs = append(hs, 0)                   // session ID
+	hs = append(hs, 0, 2, 0x13, 0x01)    // cipher suites
+	hs = append(hs, 1, 0)                // compression methods
+	hs = append(hs, byte(len(ext)>>8), byte(len(ext)))
+	hs = append(hs, ext...)
+
+	msg := append([]byte{1, 0, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+
+	return append([]byte{22, 0x03, 0x01, byte(len(msg) >> 8), byte(len(msg))}, msg...)
+}
+
+func TestHasECH(t *testing.T) {
+	tt := []struct {
+		name   string
+		record []byte
+		want   bool
+	}{
+		{"no extensions", clientHello(), false},
+		{"without ECH", clientHello(0x0000, 0x002b), false},
+		{"with ECH", clientHello(0x0000, 0xfe0d, 0x002b), true},
+		{"truncated", clientHello(0x0000, 0xfe0d)[:50], false},
+		{"not a handshake", append([]byte{23}, clientHello(0xfe0d)[1:]...), false},
+		{"empty", nil, false},
+	}
+
+	for _, tc := range tt {
+		if got := HasECH(tc.record); got != tc.want {
+			t.Errorf("%s: HasECH(): got %t, want %t", tc.name, got, tc.want)
+		}
+	}
+
+	// A ClientHello produced by crypto/tls does not offer ECH by default.
+	cconn, sconn := net.Pipe()
+	defer sconn.Close()
+	go func() {
+		tls.Client(cconn, &tls.Config{ServerName: "example.com"}).Handshake()
+		cconn.Close()
+	}()
+
+	buf := make([]byte, 16*1024)
+	n, err := sconn.Read(buf)
+	if err != nil {
+		t.Fatalf("sconn.Read(): got %v, want no error", err)
+	}
+	if HasECH(buf[:n]) {
+		t.Error("HasECH(): got true for crypto/tls ClientHello, want false")
+	}
+}
+
+func TestALPNProtocols(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+
+	// Without an HTTP/2 config h2 is never offered.
+	c.SetALPNProtocols("example.com", []string{"h2", "http/1.1"})
+	if got, want := c.TLSForHost("example.com:443").NextProtos, []string{"http/1.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TLSForHost().NextProtos: got %v, want %v", got, want)
+	}
+
+	c.SetH2Config(&h2.Config{
+		AllowedHostsFilter: func(string) bool { return true },
+	})
+	if got, want := c.TLSForHost("example.com:443").NextProtos, []string{"h2", "http/1.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TLSForHost().NextProtos: got %v, want %v", got, want)
+	}
+
+	c.SetALPNProtocols("EXAMPLE.com", []string{"http/1.1"})
+	if got, want := c.TLSForHost("example.com:443").NextProtos, []string{"http/1.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TLSForHost().NextProtos: got %v, want %v", got, want)
+	}
+	if got, want := c.TLSForHost("other.example.com:443").NextProtos, []string{"h2", "http/1.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TLSForHost(other).NextProtos: got %v, want %v", got, want)
+	}
+
+	// TLS offers configured protocols by server name, without h2.
+	c.SetALPNProtocols("example.com", []string{"h2", "x-custom"})
+	tc := c.TLS()
+	hc, err := tc.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("GetConfigForClient(): got %v, want no error", err)
+	}
+	if got, want := hc.NextProtos, []string{"x-custom"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetConfigForClient().NextProtos: got %v, want %v", got, want)
+	}
+	if hc, _ := tc.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "other.example.com"}); hc != nil {
+		t.Errorf("GetConfigForClient(other): got %v, want nil", hc)
+	}
+
+	c.SetALPNProtocols("example.com", nil)
+	if got, want := c.TLSForHost("example.com:443").NextProtos, []string{"h2", "http/1.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TLSForHost().NextProtos after reset: got %v, want %v", got, want)
+	}
+}
+
+func TestParseECHPolicy(t *testing.T) {
+	for _, want := range []ECHPolicy{0, ECHPassthrough, ECHReject} {
+		got, err := ParseECHPolicy(want.String())
+		if err != nil {
+			t.Fatalf("ParseECHPolicy(%q): got %v, want no error", want.String(), err)
+		}
+		if got != want {
+			t.Errorf("ParseECHPolicy(%q): got %v, want %v", want.String(), got, want)
+		}
+	}
+
+	// ECH can not be stripped from a ClientHello that is terminated.
+	if _, err := ParseECHPolicy("strip"); err == nil {
+		t.Error("ParseECHPolicy(strip): got nil, want error")
+	}
+}
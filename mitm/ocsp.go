@@ -0,0 +1,165 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package mitm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// ASN.1 structures of an OCSP response as defined in RFC 6960, section 4.2.1.
+
+var (
+	oidOCSPBasic         = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidEd25519           = asn1.ObjectIdentifier{1, 3, 101, 112}
+	ocspStatusSuccessful = asn1.Enumerated(0)
+)
+
+type ocspResponse struct {
+	Status        asn1.Enumerated
+	ResponseBytes ocspResponseBytes `asn1:"explicit,tag:0"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+type ocspResponseData struct {
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag `asn1:"tag:0,optional"`
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+// newOCSPStaple returns a DER encoded OCSP response signed by issuer that
+// reports the certificate with serial as good from thisUpdate until
+// nextUpdate.
+func newOCSPStaple(issuer *x509.Certificate, priv any, serial *big.Int, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("mitm: CA private key does not implement crypto.Signer")
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+
+	responderID, err := asn1.Marshal(keyHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	tbs, err := asn1.Marshal(ocspResponseData{
+		// ResponderID is the byKey choice, [2] EXPLICIT KeyHash.
+		ResponderID: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        2,
+			IsCompound: true,
+			Bytes:      responderID,
+		},
+		ProducedAt: time.Now().UTC().Truncate(time.Second),
+		Responses: []ocspSingleResponse{{
+			CertID: ocspCertID{
+				HashAlgorithm: pkix.AlgorithmIdentifier{
+					Algorithm:  oidSHA1,
+					Parameters: asn1.NullRawValue,
+				},
+				IssuerNameHash: nameHash[:],
+				IssuerKeyHash:  keyHash[:],
+				SerialNumber:   serial,
+			},
+			Good:       true,
+			ThisUpdate: thisUpdate.UTC().Truncate(time.Second),
+			NextUpdate: nextUpdate.UTC().Truncate(time.Second),
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sigAlg pkix.AlgorithmIdentifier
+	var hash crypto.Hash
+	digest := tbs
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue}
+		hash = crypto.SHA256
+	case *ecdsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+		hash = crypto.SHA256
+	case ed25519.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidEd25519}
+	default:
+		return nil, errors.New("mitm: unsupported CA key type for OCSP")
+	}
+	if hash != 0 {
+		sum := sha256.Sum256(tbs)
+		digest = sum[:]
+	}
+
+	sig, err := signer.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: sigAlg,
+		Signature: asn1.BitString{
+			Bytes:     sig,
+			BitLength: 8 * len(sig),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ocspResponse{
+		Status: ocspStatusSuccessful,
+		ResponseBytes: ocspResponseBytes{
+			ResponseType: oidOCSPBasic,
+			Response:     basic,
+		},
+	})
+}
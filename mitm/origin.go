@@ -0,0 +1,134 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package mitm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// originFetchTimeout is the maximum duration of fetching an origin
+	// certificate.
+	originFetchTimeout = 10 * time.Second
+	// originCertTTL is how long fetched origin certificates are cached.
+	originCertTTL = time.Hour
+	// originErrTTL is how long failures to fetch an origin certificate are
+	// cached, so that clients do not wait for unreachable origins on every
+	// handshake.
+	originErrTTL = 5 * time.Minute
+)
+
+// OriginCertificateFetcher fetches the certificates of origin servers, for
+// use with Config.SetOriginCertificateFunc. Results are cached per address
+// and server name, failures included, and concurrent fetches for the same
+// origin are shared.
+type OriginCertificateFetcher struct {
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu      sync.Mutex
+	entries map[originKey]*originEntry
+}
+
+type originKey struct {
+	addr       string
+	serverName string
+}
+
+type originEntry struct {
+	done    chan struct{}
+	cert    *x509.Certificate
+	err     error
+	expires time.Time
+}
+
+// NewOriginCertificateFetcher returns a fetcher that connects to origin
+// servers with dial, such as martian.Proxy.DialTunnel to honor the upstream
+// proxy.
+func NewOriginCertificateFetcher(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *OriginCertificateFetcher {
+	return &OriginCertificateFetcher{
+		dial:    dial,
+		entries: make(map[originKey]*originEntry),
+	}
+}
+
+// Fetch returns the leaf certificate the server at addr presents for
+// serverName. The certificate is not verified. It returns early with the
+// error of ctx if ctx is done, the fetch continues for later calls.
+func (f *OriginCertificateFetcher) Fetch(ctx context.Context, addr, serverName string) (*x509.Certificate, error) {
+	k := originKey{addr: addr, serverName: serverName}
+	now := time.Now()
+
+	f.mu.Lock()
+	e, ok := f.entries[k]
+	if ok && !e.expires.IsZero() && now.After(e.expires) {
+		ok = false
+	}
+	if !ok {
+		for k, e := range f.entries {
+			if !e.expires.IsZero() && now.After(e.expires) {
+				delete(f.entries, k)
+			}
+		}
+
+		e = &originEntry{done: make(chan struct{})}
+		f.entries[k] = e
+		go f.fetch(e, addr, serverName)
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return e.cert, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch fetches the certificate for e, independently of the context of the
+// caller that started it, as other callers share the result.
+func (f *OriginCertificateFetcher) fetch(e *originEntry, addr, serverName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), originFetchTimeout)
+	defer cancel()
+
+	cert, err := f.fetchCert(ctx, addr, serverName)
+
+	ttl := originCertTTL
+	if err != nil {
+		ttl = originErrTTL
+	}
+
+	f.mu.Lock()
+	e.cert, e.err = cert, err
+	e.expires = time.Now().Add(ttl)
+	f.mu.Unlock()
+	close(e.done)
+}
+
+func (f *OriginCertificateFetcher) fetchCert(ctx context.Context, addr, serverName string) (*x509.Certificate, error) {
+	conn, err := f.dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tlsconn := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err := tlsconn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	certs := tlsconn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("mitm: no certificate presented by %s", addr)
+	}
+
+	return certs[0], nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package mitm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOriginCertificateFetcher(t *testing.T) {
+	s := httptest.NewTLSServer(http.NotFoundHandler())
+	defer s.Close()
+
+	var dials int32
+	f := NewOriginCertificateFetcher(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	})
+
+	addr := s.Listener.Addr().String()
+	for i := 0; i < 2; i++ {
+		cert, err := f.Fetch(context.Background(), addr, "example.com")
+		if err != nil {
+			t.Fatalf("%d. f.Fetch(): got %v, want no error", i, err)
+		}
+		if !cert.Equal(s.Certificate()) {
+			t.Errorf("%d. f.Fetch(): got %v, want server certificate", i, cert.Subject)
+		}
+	}
+	if got, want := atomic.LoadInt32(&dials), int32(1); got != want {
+		t.Errorf("dials: got %d, want %d", got, want)
+	}
+}
+
+func TestOriginCertificateFetcherCachesErrors(t *testing.T) {
+	errDial := errors.New("dial failed")
+
+	var dials int32
+	f := NewOriginCertificateFetcher(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return nil, errDial
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := f.Fetch(context.Background(), "example.com:443", "example.com"); err != errDial {
+			t.Fatalf("%d. f.Fetch(): got %v, want %v", i, err, errDial)
+		}
+	}
+	if got, want := atomic.LoadInt32(&dials), int32(1); got != want {
+		t.Errorf("dials: got %d, want %d", got, want)
+	}
+}
+
+func TestOriginCertificateFetcherContext(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	f := NewOriginCertificateFetcher(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-block
+		return nil, errors.New("dial failed")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.Fetch(ctx, "example.com:443", "example.com"); err != context.Canceled {
+		t.Errorf("f.Fetch(): got %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package mitm
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var curves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P-256":  tls.CurveP256,
+	"P-384":  tls.CurveP384,
+	"P-521":  tls.CurveP521,
+}
+
+// TLSPolicy restricts the TLS versions, cipher suites and key exchange curves
+// of connections. Zero values leave the crypto/tls defaults in place.
+type TLSPolicy struct {
+	// MinVersion is the minimum TLS version, such as tls.VersionTLS12.
+	MinVersion uint16
+	// MaxVersion is the maximum TLS version, such as tls.VersionTLS13.
+	MaxVersion uint16
+	// CipherSuites are the enabled TLS 1.0-1.2 cipher suites. TLS 1.3 cipher
+	// suites are not configurable.
+	CipherSuites []uint16
+	// CurvePreferences are the key exchange mechanisms in order of
+	// preference.
+	CurvePreferences []tls.CurveID
+}
+
+// Validate returns an error if the policy cannot be satisfied.
+func (p *TLSPolicy) Validate() error {
+	if p.MinVersion != 0 && p.MaxVersion != 0 && p.MinVersion > p.MaxVersion {
+		return fmt.Errorf("mitm: TLS min version 0x%04x is greater than max version 0x%04x",
+			p.MinVersion, p.MaxVersion)
+	}
+
+	for _, id := range p.CipherSuites {
+		if !knownCipherSuite(id) {
+			return fmt.Errorf("mitm: unknown TLS cipher suite 0x%04x", id)
+		}
+	}
+
+	return nil
+}
+
+// Apply sets the fields of tc that are restricted by the policy.
+func (p *TLSPolicy) Apply(tc *tls.Config) {
+	if p.MinVersion != 0 {
+		tc.MinVersion = p.MinVersion
+	}
+	if p.MaxVersion != 0 {
+		tc.MaxVersion = p.MaxVersion
+	}
+	if len(p.CipherSuites) > 0 {
+		tc.CipherSuites = append([]uint16(nil), p.CipherSuites...)
+	}
+	if len(p.CurvePreferences) > 0 {
+		tc.CurvePreferences = append([]tls.CurveID(nil), p.CurvePreferences...)
+	}
+}
+
+func knownCipherSuite(id uint16) bool {
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if cs.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseTLSVersion returns the TLS version named s, one of "1.0", "1.1", "1.2"
+// or "1.3".
+func ParseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("mitm: unknown TLS version %q", s)
+	}
+
+	return v, nil
+}
+
+// ParseCipherSuite returns the ID of the cipher suite with the standard name
+// s, such as "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+func ParseCipherSuite(s string) (uint16, error) {
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if cs.Name == s {
+			return cs.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("mitm: unknown TLS cipher suite %q", s)
+}
+
+// ParseCurve returns the curve named s, one of "X25519", "P-256", "P-384" or
+// "P-521", or the curve with the decimal ID s for other key exchange
+// mechanisms.
+func ParseCurve(s string) (tls.CurveID, error) {
+	if c, ok := curves[s]; ok {
+		return c, nil
+	}
+
+	id, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("mitm: unknown TLS curve %q", s)
+	}
+
+	return tls.CurveID(id), nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package mitm
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSPolicyValidate(t *testing.T) {
+	tt := []struct {
+		policy  TLSPolicy
+		wantErr bool
+	}{
+		{TLSPolicy{}, false},
+		{TLSPolicy{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS13}, false},
+		{TLSPolicy{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}, true},
+		{TLSPolicy{CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}}, false},
+		{TLSPolicy{CipherSuites: []uint16{0xffff}}, true},
+	}
+
+	for i, tc := range tt {
+		if err := tc.policy.Validate(); (err != nil) != tc.wantErr {
+			t.Errorf("%d. Validate(): got %v, want error %t", i, err, tc.wantErr)
+		}
+	}
+}
+
+func TestParseTLSPolicyNames(t *testing.T) {
+	if v, err := ParseTLSVersion("1.2"); err != nil || v != tls.VersionTLS12 {
+		t.Errorf("ParseTLSVersion(%q): got 0x%04x, %v, want 0x%04x, no error", "1.2", v, err, tls.VersionTLS12)
+	}
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Errorf("ParseTLSVersion(%q): got nil error, want error", "1.4")
+	}
+
+	if id, err := ParseCipherSuite("TLS_AES_128_GCM_SHA256"); err != nil || id != tls.TLS_AES_128_GCM_SHA256 {
+		t.Errorf("ParseCipherSuite(): got 0x%04x, %v, want 0x%04x, no error", id, err, tls.TLS_AES_128_GCM_SHA256)
+	}
+	if _, err := ParseCipherSuite("TLS_NOT_A_CIPHER"); err == nil {
+		t.Error("ParseCipherSuite(): got nil error, want error")
+	}
+
+	if c, err := ParseCurve("P-384"); err != nil || c != tls.CurveP384 {
+		t.Errorf("ParseCurve(%q): got %v, %v, want %v, no error", "P-384", c, err, tls.CurveP384)
+	}
+	if c, err := ParseCurve("4588"); err != nil || c != tls.CurveID(4588) {
+		t.Errorf("ParseCurve(%q): got %v, %v, want 4588, no error", "4588", c, err)
+	}
+	if _, err := ParseCurve("P-999"); err == nil {
+		t.Errorf("ParseCurve(%q): got nil error, want error", "P-999")
+	}
+}
+
+func TestTLSPolicyHandshake(t *testing.T) {
+	ca, priv, err := NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("NewAuthority(): got %v, want no error", err)
+	}
+	c, err := NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("NewConfig(): got %v, want no error", err)
+	}
+	if err := c.SetTLSPolicy(TLSPolicy{MinVersion: tls.VersionTLS13}); err != nil {
+		t.Fatalf("SetTLSPolicy(): got %v, want no error", err)
+	}
+
+	for _, tc := range []struct {
+		maxVersion uint16
+		wantErr    bool
+	}{
+		{tls.VersionTLS12, true},
+		{tls.VersionTLS13, false},
+	} {
+		cconn, sconn := net.Pipe()
+		go func() {
+			tls.Server(sconn, c.TLS()).Handshake()
+			sconn.Close()
+		}()
+
+		tlsc := tls.Client(cconn, &tls.Config{
+			ServerName:         "example.com",
+			InsecureSkipVerify: true,
+			MaxVersion:         tc.maxVersion,
+		})
+		if err := tlsc.Handshake(); (err != nil) != tc.wantErr {
+			t.Errorf("max version 0x%04x: Handshake(): got %v, want error %t", tc.maxVersion, err, tc.wantErr)
+		}
+		cconn.Close()
+	}
+}
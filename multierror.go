@@ -51,6 +51,12 @@ func (merr *MultiError) Errors() []error {
 	return merr.errs
 }
 
+// Unwrap returns the errors of the collection, so that errors.Is and
+// errors.As match any of them.
+func (merr *MultiError) Unwrap() []error {
+	return merr.Errors()
+}
+
 // Add appends an error to the error collection.
 func (merr *MultiError) Add(err error) {
 	merr.mu.Lock()
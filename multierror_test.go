@@ -15,6 +15,7 @@
 package martian
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -46,3 +47,19 @@ func TestMultiError(t *testing.T) {
 		t.Errorf("Error(): got %q, want %q", got, want)
 	}
 }
+
+func TestMultiErrorUnwrap(t *testing.T) {
+	merr := NewMultiError()
+	merr.Add(errors.New("error"))
+	merr.Add(Abort(errors.New("abort")))
+
+	if !IsAbort(merr) {
+		t.Error("IsAbort(merr): got false, want true")
+	}
+	if IsAbort(errors.New("error")) {
+		t.Error("IsAbort(err): got true, want false")
+	}
+	if Abort(nil) != nil {
+		t.Error("Abort(nil): got error, want nil")
+	}
+}
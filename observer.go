@@ -0,0 +1,125 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/martian/v3/trafficshape"
+)
+
+// Observer receives events of the connections and requests handled by a
+// Proxy, such as to export metrics. Its methods are called concurrently and
+// must not block.
+type Observer interface {
+	// ConnOpened is called when a client connection is accepted by Serve.
+	ConnOpened()
+
+	// ConnClosed is called when a client connection accepted by Serve is
+	// closed or hijacked, with the number of bytes read from and written to
+	// it. Bytes of traffic shaped connections are not counted.
+	ConnClosed(read, written int64)
+
+	// MITMHandshake is called after the TLS handshake with the client of a
+	// MITM'd connection, with the error of the handshake, if any.
+	MITMHandshake(err error)
+
+	// RoundTrip is called when the round trip of req returns, with the
+	// response or error and the time to the response headers.
+	RoundTrip(req *http.Request, res *http.Response, d time.Duration, err error)
+
+	// ModifierError is called when a request or response modifier returns an
+	// error for req.
+	ModifierError(req *http.Request, err error)
+}
+
+// observeConn reports conn as opened to the observer and returns the
+// connection to use instead of conn, which counts the bytes read and written,
+// and a func that reports the connection as closed.
+func (p *Proxy) observeConn(conn net.Conn) (net.Conn, func()) {
+	o := p.Observer
+	if o == nil {
+		return conn, func() {}
+	}
+	o.ConnOpened()
+
+	// Traffic shaping relies on the type of the connection.
+	if _, ok := conn.(*trafficshape.Conn); ok {
+		return conn, func() { o.ConnClosed(0, 0) }
+	}
+
+	oc := &observedConn{Conn: conn}
+	return oc, func() { o.ConnClosed(oc.read.Load(), oc.written.Load()) }
+}
+
+func (p *Proxy) observeHandshake(err error) {
+	if p.Observer != nil {
+		p.Observer.MITMHandshake(err)
+	}
+}
+
+func (p *Proxy) observeModifierError(req *http.Request, err error) {
+	if p.Observer != nil {
+		p.Observer.ModifierError(req, err)
+	}
+}
+
+// observedConn counts the bytes read from and written to a connection.
+type observedConn struct {
+	net.Conn
+
+	read, written atomic.Int64
+}
+
+func (c *observedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *observedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// MultiObserver is an Observer that passes events to each of its observers.
+type MultiObserver []Observer
+
+// ConnOpened calls ConnOpened of each observer.
+func (m MultiObserver) ConnOpened() {
+	for _, o := range m {
+		o.ConnOpened()
+	}
+}
+
+// ConnClosed calls ConnClosed of each observer.
+func (m MultiObserver) ConnClosed(read, written int64) {
+	for _, o := range m {
+		o.ConnClosed(read, written)
+	}
+}
+
+// MITMHandshake calls MITMHandshake of each observer.
+func (m MultiObserver) MITMHandshake(err error) {
+	for _, o := range m {
+		o.MITMHandshake(err)
+	}
+}
+
+// RoundTrip calls RoundTrip of each observer.
+func (m MultiObserver) RoundTrip(req *http.Request, res *http.Response, d time.Duration, err error) {
+	for _, o := range m {
+		o.RoundTrip(req, res, d, err)
+	}
+}
+
+// ModifierError calls ModifierError of each observer.
+func (m MultiObserver) ModifierError(req *http.Request, err error) {
+	for _, o := range m {
+		o.ModifierError(req, err)
+	}
+}
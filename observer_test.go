@@ -0,0 +1,137 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/martiantest"
+)
+
+// recordingObserver is an Observer that records the events it receives.
+type recordingObserver struct {
+	mu             sync.Mutex
+	opened, closed int
+	read, written  int64
+	statuses       []int
+	modifierErrors []error
+	closedc        chan bool
+}
+
+func (o *recordingObserver) ConnOpened() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.opened++
+}
+
+func (o *recordingObserver) ConnClosed(read, written int64) {
+	o.mu.Lock()
+	o.closed++
+	o.read += read
+	o.written += written
+	o.mu.Unlock()
+
+	o.closedc <- true
+}
+
+func (o *recordingObserver) MITMHandshake(err error) {}
+
+func (o *recordingObserver) RoundTrip(req *http.Request, res *http.Response, d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.statuses = append(o.statuses, res.StatusCode)
+}
+
+func (o *recordingObserver) ModifierError(req *http.Request, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.modifierErrors = append(o.modifierErrors, err)
+}
+
+func TestIntegrationObserver(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	l := newListener(t)
+	p := NewProxy()
+	defer p.Close()
+
+	o := &recordingObserver{closedc: make(chan bool, 1)}
+	p.Observer = o
+
+	tr := martiantest.NewTransport()
+	tr.Respond(204)
+	p.SetRoundTripper(tr)
+	p.SetTimeout(2 * time.Second)
+
+	merr := errors.New("modifier error")
+	tm := martiantest.NewModifier()
+	tm.ResponseError(merr)
+	p.SetResponseModifier(tm)
+
+	go serve(p, l)
+
+	conn, err := l.dial()
+	if err != nil {
+		t.Fatalf("net.Dial(): got %v, want no error", err)
+	}
+
+	raw := "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		t.Fatalf("conn.Write(): got %v, want no error", err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+	}
+	res.Body.Close()
+	conn.Close()
+
+	select {
+	case <-o.closedc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConnClosed: not called")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.opened != 1 || o.closed != 1 {
+		t.Errorf("connections opened, closed: got %d, %d, want 1, 1", o.opened, o.closed)
+	}
+	if got, want := o.read, int64(len(raw)); got != want {
+		t.Errorf("bytes read: got %d, want %d", got, want)
+	}
+	if o.written == 0 {
+		t.Errorf("bytes written: got 0, want > 0")
+	}
+	if len(o.statuses) != 1 || o.statuses[0] != 204 {
+		t.Errorf("round trip statuses: got %v, want [204]", o.statuses)
+	}
+	if len(o.modifierErrors) != 1 || o.modifierErrors[0] != merr {
+		t.Errorf("modifier errors: got %v, want [%v]", o.modifierErrors, merr)
+	}
+}
+
+func TestIntegrationObserverTransparentMITM(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	testTransparentMITM(t, func(p *Proxy) {
+		p.Observer = &recordingObserver{closedc: make(chan bool, 1)}
+	})
+}
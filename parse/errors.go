@@ -0,0 +1,177 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package parse
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Error is the error of parsing a modifier nested in a message, such as a
+// child of a fifo.Group or the modifier of a filter.
+type Error struct {
+	// Path is the JSON pointer (RFC 6901) of the message of the modifier
+	// within the parsed message, such as "/fifo.Group/modifiers/1".
+	Path string
+	// Modifier is the name of the modifier, such as "header.Modifier".
+	Modifier string
+	// Err is the error returned for the modifier.
+	Err error
+
+	// msg is the canonical form of the message whose parse returned the
+	// error, used to find the errors of nested messages that were already
+	// collected.
+	msg []byte
+}
+
+// Error returns the error message with the modifier and its path.
+func (e *Error) Error() string {
+	var uerr ErrUnknownModifier
+	if errors.As(e.Err, &uerr) {
+		return fmt.Sprintf("parse: unknown modifier: %s at %s", e.Modifier, e.Path)
+	}
+
+	return fmt.Sprintf("parse: %s at %s: %s", e.Modifier, e.Path, strings.TrimPrefix(e.Err.Error(), "parse: "))
+}
+
+// Unwrap returns the error returned for the modifier.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors is the error returned by FromJSON when nested modifiers fail to
+// parse. It holds the errors of all failed modifiers, rather than only the
+// first, in the order of arrays and of keys within objects.
+type Errors []*Error
+
+// Error returns the messages of the errors, one per line.
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors.
+func (errs Errors) Unwrap() []error {
+	u := make([]error, len(errs))
+	for i, e := range errs {
+		u[i] = e
+	}
+
+	return u
+}
+
+// modifierNode is a modifier message nested in another message.
+type modifierNode struct {
+	path string
+	name string
+	msg  map[string]any
+}
+
+// collect returns the errors of the modifiers nested in the message b, which
+// failed to parse with err. Each nested modifier is parsed on its own, so that
+// the errors of all of them are returned and not only the first. err is
+// returned if no nested modifier fails, as the modifier of b itself is at
+// fault.
+func collect(b []byte, err error) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var msg map[string]any
+	if d.Decode(&msg) != nil || len(msg) != 1 {
+		return err
+	}
+
+	var name string
+	var body any
+	for name, body = range msg {
+	}
+
+	// Errors of nested modifiers are collected when their own parse fails
+	// and are usually returned as is by the parse func of b.
+	var prev Errors
+	errors.As(err, &prev)
+
+	var errs Errors
+	for _, n := range nestedModifiers(body, "/"+EscapePointer(name), nil) {
+		cb, merr := json.Marshal(n.msg)
+		if merr != nil {
+			continue
+		}
+
+		cerr := error(prev)
+		if len(prev) == 0 || !bytes.Equal(prev[0].msg, cb) {
+			if _, cerr = FromJSON(cb); cerr == nil {
+				continue
+			}
+		}
+
+		var cerrs Errors
+		if !errors.As(cerr, &cerrs) {
+			errs = append(errs, &Error{Path: n.path, Modifier: n.name, Err: cerr})
+			continue
+		}
+		for _, e := range cerrs {
+			errs = append(errs, &Error{Path: n.path + e.Path, Modifier: e.Modifier, Err: e.Err})
+		}
+	}
+
+	if len(errs) == 0 {
+		return err
+	}
+
+	canon, _ := json.Marshal(msg)
+	for _, e := range errs {
+		e.msg = canon
+	}
+
+	return errs
+}
+
+// nestedModifiers appends the modifier messages in v, at path, to ns without
+// descending into them. A modifier message is an object with a single key
+// containing a dot, the name of the modifier, whose value is an object.
+func nestedModifiers(v any, path string, ns []modifierNode) []modifierNode {
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) == 1 {
+			for k, m := range v {
+				if _, ok := m.(map[string]any); ok && strings.Contains(k, ".") {
+					return append(ns, modifierNode{path: path, name: k, msg: v})
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			ns = nestedModifiers(v[k], path+"/"+EscapePointer(k), ns)
+		}
+	case []any:
+		for i, e := range v {
+			ns = nestedModifiers(e, path+"/"+strconv.Itoa(i), ns)
+		}
+	}
+
+	return ns
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// EscapePointer escapes key as a reference token of a JSON pointer, such as
+// the paths of the errors of FromJSON.
+func EscapePointer(key string) string {
+	return pointerEscaper.Replace(key)
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package parse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/martian/v3/martiantest"
+)
+
+func init() {
+	// parsetest.Group parses its modifiers and stops at the first error, as
+	// fifo.Group does.
+	Register("parsetest.Group", func(b []byte) (*Result, error) {
+		msg := &struct {
+			Modifiers []json.RawMessage `json:"modifiers"`
+		}{}
+		if err := json.Unmarshal(b, msg); err != nil {
+			return nil, err
+		}
+
+		for _, m := range msg.Modifiers {
+			if _, err := FromJSON(m); err != nil {
+				return nil, err
+			}
+		}
+
+		return NewResult(martiantest.NewModifier(), nil)
+	})
+
+	Register("parsetest.Scoped", func(b []byte) (*Result, error) {
+		msg := &struct {
+			Scope []ModifierType `json:"scope"`
+		}{}
+		if err := json.Unmarshal(b, msg); err != nil {
+			return nil, err
+		}
+
+		return NewResult(martiantest.NewModifier(), msg.Scope)
+	})
+}
+
+func TestFromJSONCollectsNestedErrors(t *testing.T) {
+	msg := []byte(`{
+	  "parsetest.Group": {
+	    "modifiers": [
+	      { "parsetest.Scoped": { "scope": ["request"] } },
+	      { "parsetest.Unknown": {} },
+	      {
+	        "parsetest.Group": {
+	          "modifiers": [
+	            { "parsetest.Scoped": { "scope": ["invalid"] } },
+	            { "parsetest.Scoped": {} },
+	            { "parsetest.Scoped": { "scope": "request" } }
+	          ]
+	        }
+	      }
+	    ]
+	  }
+	}`)
+
+	_, err := FromJSON(msg)
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("FromJSON(): got %v, want Errors", err)
+	}
+
+	want := []struct {
+		path, modifier string
+	}{
+		{"/parsetest.Group/modifiers/1", "parsetest.Unknown"},
+		{"/parsetest.Group/modifiers/2/parsetest.Group/modifiers/0", "parsetest.Scoped"},
+		{"/parsetest.Group/modifiers/2/parsetest.Group/modifiers/2", "parsetest.Scoped"},
+	}
+	if got := len(errs); got != len(want) {
+		t.Fatalf("len(errs): got %d, want %d: %v", got, len(want), errs)
+	}
+	for i, w := range want {
+		if got := errs[i].Path; got != w.path {
+			t.Errorf("errs[%d].Path: got %q, want %q", i, got, w.path)
+		}
+		if got := errs[i].Modifier; got != w.modifier {
+			t.Errorf("errs[%d].Modifier: got %q, want %q", i, got, w.modifier)
+		}
+	}
+
+	var uerr ErrUnknownModifier
+	if !errors.As(errs[0], &uerr) {
+		t.Errorf("errors.As(errs[0], ErrUnknownModifier): got false, want true")
+	}
+	if got, want := errs[0].Error(), "parse: unknown modifier: parsetest.Unknown at /parsetest.Group/modifiers/1"; got != want {
+		t.Errorf("errs[0].Error(): got %q, want %q", got, want)
+	}
+	if got, want := errs[1].Error(), fmt.Sprintf("parse: parsetest.Scoped at /parsetest.Group/modifiers/2/parsetest.Group/modifiers/0: %s", `invalid scope: invalid not in ["request", "response"]`); got != want {
+		t.Errorf("errs[1].Error(): got %q, want %q", got, want)
+	}
+}
+
+func TestFromJSONTopLevelErrorUnchanged(t *testing.T) {
+	msg := []byte(`{
+	  "parsetest.Scoped": { "scope": ["invalid"] }
+	}`)
+
+	_, err := FromJSON(msg)
+	if err == nil {
+		t.Fatal("FromJSON(): got nil, want error")
+	}
+
+	var errs Errors
+	if errors.As(err, &errs) {
+		t.Errorf("FromJSON(): got %v, want error other than Errors", err)
+	}
+}
+
+func TestFromJSONNestedPathEscaped(t *testing.T) {
+	msg := []byte(`{
+	  "parsetest.Group": {
+	    "modifiers": [
+	      { "parsetest.Scoped": { "scope": ["response"] } }
+	    ],
+	    "a/b~c": { "parsetest.Unknown": {} }
+	  }
+	}`)
+
+	// The parse func of parsetest.Group ignores "a/b~c", so the message
+	// parses.
+	if _, err := FromJSON(msg); err != nil {
+		t.Fatalf("FromJSON(): got %v, want no error", err)
+	}
+
+	msg = []byte(`{
+	  "parsetest.Group": {
+	    "modifiers": [
+	      { "parsetest.Scoped": { "scope": ["invalid"] } }
+	    ],
+	    "a/b~c": { "parsetest.Unknown": {} }
+	  }
+	}`)
+
+	_, err := FromJSON(msg)
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("FromJSON(): got %v, want Errors", err)
+	}
+	if got, want := len(errs), 2; got != want {
+		t.Fatalf("len(errs): got %d, want %d: %v", got, want, errs)
+	}
+	if got, want := errs[0].Path, "/parsetest.Group/a~1b~0c"; got != want {
+		t.Errorf("errs[0].Path: got %q, want %q", got, want)
+	}
+	if got, want := errs[1].Path, "/parsetest.Group/modifiers/0"; got != want {
+		t.Errorf("errs[1].Path: got %q, want %q", got, want)
+	}
+}
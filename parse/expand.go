@@ -0,0 +1,128 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package parse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Expand returns the JSON message b with the references in its string values
+// replaced, so that messages can be stored without embedded secrets:
+//
+//	${NAME}            the value of the environment variable NAME, which
+//	                   must be set
+//	${NAME:-default}   the value of NAME, or default if it is not set
+//	${file:path}       the content of the file at path, such as a mounted
+//	                   secret, without trailing newlines
+//	$$                 a literal $
+//
+// Other uses of $ are kept as is. Expand should be called once on a whole
+// message, before it is passed to FromJSON.
+func Expand(b []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for i := 0; i < len(b); {
+		if b[i] != '"' {
+			buf.WriteByte(b[i])
+			i++
+			continue
+		}
+
+		j := i + 1
+		for ; j < len(b) && b[j] != '"'; j++ {
+			if b[j] == '\\' {
+				j++
+			}
+		}
+		if j >= len(b) {
+			return nil, fmt.Errorf("parse: unterminated string in JSON message")
+		}
+		j++
+
+		lit := b[i:j]
+		i = j
+		if !bytes.ContainsRune(lit, '$') {
+			buf.Write(lit)
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(lit, &s); err != nil {
+			return nil, err
+		}
+		s, err := expandString(s)
+		if err != nil {
+			return nil, err
+		}
+		enc, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(enc)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// expandString replaces the references in s.
+func expandString(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		switch s[1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[2:]
+		case '{':
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", fmt.Errorf("parse: unterminated reference %q", s)
+			}
+			v, err := resolveReference(s[2:end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			s = s[end+1:]
+		default:
+			b.WriteByte('$')
+			s = s[1:]
+		}
+	}
+}
+
+// resolveReference returns the value of the reference ref, the text between
+// "${" and "}".
+func resolveReference(ref string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("parse: reading secret file: %v", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	name, def, hasDef := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", fmt.Errorf("parse: empty reference ${%s}", ref)
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v, nil
+	}
+	if hasDef {
+		return def, nil
+	}
+
+	return "", fmt.Errorf("parse: environment variable %s is not set", name)
+}
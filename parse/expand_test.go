@@ -0,0 +1,54 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package parse
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("MARTIAN_TEST_USER", "martian")
+	t.Setenv("MARTIAN_TEST_QUOTE", `a "quoted" value`)
+
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(secret, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	tt := []struct {
+		in   string
+		want string
+	}{
+		{`{"a": "no references", "n": 1}`, `{"a": "no references", "n": 1}`},
+		{`{"a": "${MARTIAN_TEST_USER}"}`, `{"a": "martian"}`},
+		{`{"a": "Basic ${MARTIAN_TEST_USER}:${file:` + secret + `}"}`, `{"a": "Basic martian:s3cr3t"}`},
+		{`{"a": "${MARTIAN_TEST_UNSET:-fallback}"}`, `{"a": "fallback"}`},
+		{`{"a": "${MARTIAN_TEST_QUOTE}"}`, `{"a": "a \"quoted\" value"}`},
+		{`{"a": "$$100, $5 and $${MARTIAN_TEST_USER}"}`, `{"a": "$100, $5 and ${MARTIAN_TEST_USER}"}`},
+		{`{"a": "escaped \" ${MARTIAN_TEST_USER}"}`, `{"a": "escaped \" martian"}`},
+	}
+
+	for _, tc := range tt {
+		got, err := Expand([]byte(tc.in))
+		if err != nil {
+			t.Errorf("Expand(%s): got %v, want no error", tc.in, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("Expand(%s): got %s, want %s", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{
+		`{"a": "${MARTIAN_TEST_UNSET}"}`,
+		`{"a": "${file:` + filepath.Join(t.TempDir(), "missing") + `}"}`,
+		`{"a": "${MARTIAN_TEST_USER"}`,
+		`{"a": "unterminated`,
+	} {
+		if got, err := Expand([]byte(in)); err == nil {
+			t.Errorf("Expand(%s): got %s, want error", in, got)
+		}
+	}
+}
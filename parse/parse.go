@@ -118,7 +118,20 @@ func Register(name string, parseFunc func(b []byte) (*Result, error)) {
 // and passing its modifier to the registered parseFunc. Returns a parse.Result containing
 // the top-level parsed modifier. If no parser has been registered with the given name
 // it returns an error of type ErrUnknownModifier.
+//
+// If modifiers nested in the message, such as the children of a fifo.Group,
+// fail to parse, it returns Errors with the errors of all of them and their
+// paths in the message.
 func FromJSON(b []byte) (*Result, error) {
+	r, err := fromJSON(b)
+	if err != nil {
+		return nil, collect(b, err)
+	}
+
+	return r, nil
+}
+
+func fromJSON(b []byte) (*Result, error) {
 	msg := make(map[string]json.RawMessage)
 	if err := json.Unmarshal(b, &msg); err != nil {
 		return nil, err
@@ -0,0 +1,142 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package parse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FromYAML parses a Modifier message written in YAML, such as:
+//
+//	fifo.Group:
+//	  scope: [request]
+//	  modifiers:
+//	    - header.Modifier:
+//	        name: Martian-Test
+//	        value: "true"
+//
+// The message is converted with YAMLToJSON and parsed with FromJSON.
+func FromYAML(b []byte) (*Result, error) {
+	j, err := YAMLToJSON(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return FromJSON(j)
+}
+
+// YAMLToJSON converts the first YAML document in b to JSON, keeping the order
+// of mapping keys. Plain scalars are resolved as by gopkg.in/yaml.v3, to
+// null, booleans, numbers or strings; other scalars, such as timestamps, are
+// converted to strings. Mapping keys must be scalars.
+func YAMLToJSON(b []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if doc.Kind == 0 {
+		buf.WriteString("null")
+		return buf.Bytes(), nil
+	}
+	if err := writeYAMLNode(buf, &doc, nil); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeYAMLNode writes n to buf as JSON. aliased holds the anchored nodes
+// being written through aliases, to reject aliases that contain themselves.
+func writeYAMLNode(buf *bytes.Buffer, n *yaml.Node, aliased []*yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return writeYAMLNode(buf, n.Content[0], aliased)
+	case yaml.AliasNode:
+		for _, a := range aliased {
+			if a == n.Alias {
+				return fmt.Errorf("yaml: line %d: alias %q contains itself", n.Line, n.Value)
+			}
+		}
+		return writeYAMLNode(buf, n.Alias, append(aliased, n.Alias))
+	case yaml.MappingNode:
+		seen := make(map[string]bool, len(n.Content)/2)
+		buf.WriteByte('{')
+		for i := 0; i < len(n.Content); i += 2 {
+			k := n.Content[i]
+			if k.Kind != yaml.ScalarNode {
+				return fmt.Errorf("yaml: line %d: mapping key is not a scalar", k.Line)
+			}
+			if seen[k.Value] {
+				return fmt.Errorf("yaml: line %d: duplicate key %q", k.Line, k.Value)
+			}
+			seen[k.Value] = true
+
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			kb, err := json.Marshal(k.Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(kb)
+			buf.WriteByte(':')
+			if err := writeYAMLNode(buf, n.Content[i+1], aliased); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, e := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeYAMLNode(buf, e, aliased); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		return writeYAMLScalar(buf, n)
+	default:
+		return fmt.Errorf("yaml: line %d: unexpected node kind %d", n.Line, n.Kind)
+	}
+
+	return nil
+}
+
+// writeYAMLScalar writes the scalar n to buf as JSON.
+func writeYAMLScalar(buf *bytes.Buffer, n *yaml.Node) error {
+	var v any
+	switch n.ShortTag() {
+	case "!!null":
+		v = nil
+	case "!!int", "!!float":
+		// Keep numbers that are valid JSON as written.
+		if json.Valid([]byte(n.Value)) {
+			buf.WriteString(n.Value)
+			return nil
+		}
+		fallthrough
+	case "!!bool":
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+	default:
+		v = n.Value
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("yaml: line %d: %v", n.Line, err)
+	}
+	buf.Write(b)
+
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package parse
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/martian/v3/martiantest"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	tt := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{
+			name: "block mapping",
+			yaml: "a: 1\nb: two\nc:\n",
+			want: `{"a":1,"b":"two","c":null}`,
+		},
+		{
+			name: "nested mapping and sequence",
+			yaml: `
+# comment
+---
+fifo.Group:
+  scope: [request, response]   # inline comment
+  modifiers:
+    - header.Modifier:
+        name: Martian-Test
+        value: "true"
+    - header.Modifier:
+        name: 'It''s'
+        value: "a # b"
+  aggregateErrors: false
+`,
+			want: `{"fifo.Group":{"scope":["request","response"],"modifiers":[` +
+				`{"header.Modifier":{"name":"Martian-Test","value":"true"}},` +
+				`{"header.Modifier":{"name":"It's","value":"a # b"}}],"aggregateErrors":false}}`,
+		},
+		{
+			name: "sequence indented as its key",
+			yaml: "a:\n- 1\n- x: y\n  z: 2.5\nb: ~\n",
+			want: `{"a":[1,{"x":"y","z":2.5}],"b":null}`,
+		},
+		{
+			name: "nested sequences",
+			yaml: "- - a\n  - b\n- []\n- {}\n",
+			want: `[["a","b"],[],{}]`,
+		},
+		{
+			name: "multi-line flow",
+			yaml: "{\n  \"a\": [1, 2,\n    3],\n  \"b\": {\"c\": \"d\"}\n}\n",
+			want: `{"a":[1,2,3],"b":{"c":"d"}}`,
+		},
+		{
+			name: "scalars",
+			yaml: "url: http://example.com:8080/path\nversion: 1.2.3\nescaped: \"tab\\there\\u00e9\"\nneg: -10\nexp: 1e3\nyes: yes\n",
+			want: `{"url":"http://example.com:8080/path","version":"1.2.3","escaped":"tab\there\u00e9","neg":-10,"exp":1e3,"yes":"yes"}`,
+		},
+		{
+			name: "literal block scalar",
+			yaml: "body: |\n  line 1\n\n    indented\nnext: x\n",
+			want: `{"body":"line 1\n\n  indented\n","next":"x"}`,
+		},
+		{
+			name: "anchors and aliases",
+			yaml: "headers: &h {name: Martian-Test, value: \"1\"}\nmodifiers: [*h, *h]\n",
+			want: `{"headers":{"name":"Martian-Test","value":"1"},"modifiers":[{"name":"Martian-Test","value":"1"},{"name":"Martian-Test","value":"1"}]}`,
+		},
+		{
+			name: "folded block scalar",
+			yaml: "body: >-\n  folded\n  text\n\n  para\n",
+			want: `{"body":"folded text\npara"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := YAMLToJSON([]byte(tc.yaml))
+			if err != nil {
+				t.Fatalf("YAMLToJSON(): got %v, want no error", err)
+			}
+
+			var g, w any
+			if err := json.Unmarshal(got, &g); err != nil {
+				t.Fatalf("json.Unmarshal(%s): got %v, want no error", got, err)
+			}
+			if err := json.Unmarshal([]byte(tc.want), &w); err != nil {
+				t.Fatalf("json.Unmarshal(want): got %v, want no error", err)
+			}
+			gb, _ := json.Marshal(g)
+			wb, _ := json.Marshal(w)
+			if string(gb) != string(wb) {
+				t.Errorf("YAMLToJSON(): got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestYAMLToJSONKeepsKeyOrder(t *testing.T) {
+	got, err := YAMLToJSON([]byte("b: 1\na: 2\n"))
+	if err != nil {
+		t.Fatalf("YAMLToJSON(): got %v, want no error", err)
+	}
+	if want := `{"b":1,"a":2}`; string(got) != want {
+		t.Errorf("YAMLToJSON(): got %s, want %s", got, want)
+	}
+}
+
+func TestYAMLToJSONErrors(t *testing.T) {
+	for _, yaml := range []string{
+		"a: 1\na: 2\n",
+		"a: 1\n  b: 2\n",
+		"a: [1, 2\n",
+		"a: \"unterminated\n",
+		"a: *undefined\n",
+		"? [complex]\n: 1\n",
+		"a: .inf\n",
+		"a: &a [1, *a]\n",
+		"a: 1\n- b\n",
+	} {
+		if got, err := YAMLToJSON([]byte(yaml)); err == nil {
+			t.Errorf("YAMLToJSON(%q): got %s, want error", yaml, got)
+		}
+	}
+}
+
+func TestFromYAML(t *testing.T) {
+	Register("martiantest.Modifier", func(b []byte) (*Result, error) {
+		msg := &struct {
+			Scope []ModifierType `json:"scope"`
+		}{}
+		if err := json.Unmarshal(b, msg); err != nil {
+			return nil, err
+		}
+
+		return NewResult(martiantest.NewModifier(), msg.Scope)
+	})
+
+	r, err := FromYAML([]byte("martiantest.Modifier:\n  scope:\n    - request\n"))
+	if err != nil {
+		t.Fatalf("FromYAML(): got %v, want no error", err)
+	}
+	if _, ok := r.RequestModifier().(*martiantest.Modifier); !ok {
+		t.Error("r.RequestModifier().(*martiantest.Modifier): got !ok, want ok")
+	}
+	if r.ResponseModifier() != nil {
+		t.Errorf("r.ResponseModifier(): got %v, want nil", r.ResponseModifier())
+	}
+}
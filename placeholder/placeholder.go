@@ -0,0 +1,186 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package placeholder provides a modifier that replaces media responses with
+// tiny placeholders.
+package placeholder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("placeholder.Modifier", modifierFromJSON)
+}
+
+// Kinds of media replaced by Modifier.
+const (
+	Image = "image"
+	Video = "video"
+	Audio = "audio"
+	Font  = "font"
+)
+
+// pixelGIF is a transparent 1x1 pixel GIF.
+var pixelGIF = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// pixelSVG is a transparent 1x1 pixel SVG image.
+var pixelSVG = []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"/>`)
+
+// fontTypes are the media types of fonts outside of the font/ top-level type.
+var fontTypes = map[string]bool{
+	"application/font-sfnt":         true,
+	"application/font-woff":         true,
+	"application/font-woff2":        true,
+	"application/vnd.ms-fontobject": true,
+	"application/x-font-opentype":   true,
+	"application/x-font-otf":        true,
+	"application/x-font-ttf":        true,
+	"application/x-font-woff":       true,
+}
+
+// Modifier is a response modifier that replaces image, video, audio and font
+// responses with tiny placeholders, to simulate text-only clients on
+// constrained networks and to speed up UI test runs. Images are replaced with
+// a transparent 1x1 pixel GIF, or SVG for SVG images; other media are
+// replaced with an empty body. The media kind is determined by the
+// Content-Type of the response.
+//
+// Only successful responses are replaced; partial content responses become
+// complete responses of the placeholder. The ETag and Last-Modified
+// validators are removed, so that clients do not revalidate the placeholders
+// against the original media.
+type Modifier struct {
+	kinds map[string]bool
+}
+
+type modifierJSON struct {
+	Kinds []string             `json:"kinds"`
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a Modifier replacing the media of kinds, which are
+// Image, Video, Audio or Font. Without kinds, all media are replaced.
+func NewModifier(kinds ...string) (*Modifier, error) {
+	if len(kinds) == 0 {
+		kinds = []string{Image, Video, Audio, Font}
+	}
+
+	m := &Modifier{kinds: make(map[string]bool)}
+	for _, k := range kinds {
+		switch k {
+		case Image, Video, Audio, Font:
+			m.kinds[k] = true
+		default:
+			return nil, fmt.Errorf("placeholder: unknown media kind %q", k)
+		}
+	}
+
+	return m, nil
+}
+
+// ModifyResponse replaces the body of res with a placeholder if it is media
+// of one of the kinds of the modifier.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		return nil
+	}
+	if res.Request != nil && res.Request.Method == http.MethodHead {
+		return nil
+	}
+
+	mt, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	kind := mediaKind(mt)
+	if !m.kinds[kind] {
+		return nil
+	}
+
+	var body []byte
+	if kind == Image {
+		if mt == "image/svg+xml" {
+			body = pixelSVG
+		} else {
+			body = pixelGIF
+			res.Header.Set("Content-Type", "image/gif")
+		}
+	}
+
+	if res.Body != nil {
+		res.Body.Close()
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.TransferEncoding = nil
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("ETag")
+	res.Header.Del("Last-Modified")
+
+	if res.StatusCode == http.StatusPartialContent {
+		res.StatusCode = http.StatusOK
+		res.Status = "200 OK"
+		res.Header.Del("Content-Range")
+	}
+
+	if res.Request != nil {
+		log.Debugf("placeholder.Modifier.ModifyResponse: %s: replaced %s", res.Request.URL, mt)
+	}
+
+	return nil
+}
+
+// mediaKind returns the kind of media of the media type mt, or "" if it is
+// not media.
+func mediaKind(mt string) string {
+	switch {
+	case strings.HasPrefix(mt, "image/"):
+		return Image
+	case strings.HasPrefix(mt, "video/"):
+		return Video
+	case strings.HasPrefix(mt, "audio/"):
+		return Audio
+	case strings.HasPrefix(mt, "font/"), fontTypes[mt]:
+		return Font
+	}
+
+	return ""
+}
+
+// modifierFromJSON builds a placeholder.Modifier from JSON. "kinds" are the
+// kinds of media to replace, of image, video, audio and font; by default all
+// media are replaced.
+//
+// Example JSON:
+//
+//	{
+//	  "placeholder.Modifier": {
+//	    "scope": ["response"],
+//	    "kinds": ["image", "font"]
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod, err := NewModifier(msg.Kinds...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
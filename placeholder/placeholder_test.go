@@ -0,0 +1,167 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package placeholder
+
+import (
+	"bytes"
+	"image/gif"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestModifier(t *testing.T) {
+	mod, err := NewModifier()
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	tt := []struct {
+		contentType string
+		wantType    string
+		want        string
+	}{
+		{"image/png", "image/gif", string(pixelGIF)},
+		{"image/svg+xml; charset=utf-8", "image/svg+xml; charset=utf-8", string(pixelSVG)},
+		{"video/mp4", "video/mp4", ""},
+		{"audio/mpeg", "audio/mpeg", ""},
+		{"font/woff2", "font/woff2", ""},
+		{"application/vnd.ms-fontobject", "application/vnd.ms-fontobject", ""},
+		{"text/html", "text/html", "original"},
+		{"application/javascript", "application/javascript", "original"},
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/media", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	for i, tc := range tt {
+		res := proxyutil.NewResponse(200, strings.NewReader("original"), req)
+		res.Header.Set("Content-Type", tc.contentType)
+		res.Header.Set("ETag", `"v1"`)
+		res.ContentLength = int64(len("original"))
+
+		if err := mod.ModifyResponse(res); err != nil {
+			t.Fatalf("%d. ModifyResponse(): got %v, want no error", i, err)
+		}
+
+		got, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. io.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%d. res.Body: got %q, want %q", i, got, tc.want)
+		}
+		if got := res.Header.Get("Content-Type"); got != tc.wantType {
+			t.Errorf("%d. Content-Type: got %q, want %q", i, got, tc.wantType)
+		}
+		if got, want := res.ContentLength, int64(len(tc.want)); got != want {
+			t.Errorf("%d. res.ContentLength: got %d, want %d", i, got, want)
+		}
+		if replaced := tc.want != "original"; replaced == (res.Header.Get("ETag") != "") {
+			t.Errorf("%d. ETag: got %q, want removed only if replaced", i, res.Header.Get("ETag"))
+		}
+	}
+}
+
+func TestModifierPlaceholderImage(t *testing.T) {
+	img, err := gif.Decode(bytes.NewReader(pixelGIF))
+	if err != nil {
+		t.Fatalf("gif.Decode(): got %v, want no error", err)
+	}
+	if b := img.Bounds(); b.Dx() != 1 || b.Dy() != 1 {
+		t.Errorf("img.Bounds(): got %v, want 1x1", b)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("img.At(0, 0): got alpha %d, want transparent", a)
+	}
+}
+
+func TestModifierKinds(t *testing.T) {
+	mod, err := NewModifier(Font)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/media", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(200, strings.NewReader("png"), req)
+	res.Header.Set("Content-Type", "image/png")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, _ := io.ReadAll(res.Body); string(got) != "png" {
+		t.Errorf("res.Body: got %q, want %q", got, "png")
+	}
+
+	// Partial content becomes a complete empty font.
+	res = proxyutil.NewResponse(206, strings.NewReader("ttf"), req)
+	res.Header.Set("Content-Type", "font/ttf")
+	res.Header.Set("Content-Range", "bytes 0-2/10")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, 200; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got := res.Header.Get("Content-Range"); got != "" {
+		t.Errorf("Content-Range: got %q, want none", got)
+	}
+
+	// Unsuccessful responses are left unchanged.
+	res = proxyutil.NewResponse(404, strings.NewReader("not found"), req)
+	res.Header.Set("Content-Type", "font/ttf")
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, _ := io.ReadAll(res.Body); string(got) != "not found" {
+		t.Errorf("res.Body: got %q, want %q", got, "not found")
+	}
+
+	if _, err := NewModifier("document"); err == nil {
+		t.Error("NewModifier(document): got nil, want error")
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"placeholder.Modifier": {
+			"scope": ["response"],
+			"kinds": ["video"]
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("r.ResponseModifier(): got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/media", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, strings.NewReader("webm"), req)
+	res.Header.Set("Content-Type", "video/webm")
+	if err := resmod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, _ := io.ReadAll(res.Body); len(got) != 0 {
+		t.Errorf("res.Body: got %q, want empty", got)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"placeholder.Modifier": {"scope": ["response"], "kinds": ["pdf"]}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for unknown kind")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package pluginloader loads additional modifiers from Go plugins, so that
+// custom modifiers can be configured with parse.FromJSON without forking the
+// proxy.
+//
+// A plugin is a main package built with "go build -buildmode=plugin" against
+// the same version of martian, and with the same toolchain, as the proxy
+// loading it. It registers its modifiers with parse.Register in its init
+// functions, just as the modifier packages of martian do:
+//
+//	package main
+//
+//	import "github.com/google/martian/v3/parse"
+//
+//	func init() {
+//		parse.Register("custom.Modifier", customModifierFromJSON)
+//	}
+//
+// A plugin may additionally export a function
+//
+//	func Init() error
+//
+// which is called once after the plugin is loaded; if it fails, Load returns
+// its error.
+//
+// Plugins are only supported on the platforms supported by the plugin
+// package, and only when built with cgo; elsewhere Load returns an error.
+package pluginloader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/martian/v3/log"
+)
+
+// InitSymbol is the name of the optional function called after a plugin is
+// loaded.
+const InitSymbol = "Init"
+
+var (
+	mu     sync.Mutex
+	loaded = make(map[string]bool)
+)
+
+// Load loads the plugin at path and calls its Init function, if any. Loading
+// a plugin that is already loaded does nothing.
+func Load(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("pluginloader: %s: %v", path, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if loaded[abs] {
+		return nil
+	}
+
+	p, err := plugin.Open(abs)
+	if err != nil {
+		return fmt.Errorf("pluginloader: %s: %v", path, err)
+	}
+
+	if sym, err := p.Lookup(InitSymbol); err == nil {
+		fn, ok := sym.(func() error)
+		if !ok {
+			return fmt.Errorf("pluginloader: %s: %s is %T, want func() error", path, InitSymbol, sym)
+		}
+		if err := fn(); err != nil {
+			return fmt.Errorf("pluginloader: %s: %s: %v", path, InitSymbol, err)
+		}
+	}
+
+	loaded[abs] = true
+	log.Infof("pluginloader: loaded %s", path)
+
+	return nil
+}
+
+// LoadDir loads the plugins with the .so extension in dir, in the order of
+// their names. It stops at the first plugin that fails to load.
+func LoadDir(dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("pluginloader: %v", err)
+	}
+
+	var names []string
+	for _, fi := range fis {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".so") {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := Load(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Loaded returns the absolute paths of the loaded plugins, sorted.
+func Loaded() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	paths := make([]string, 0, len(loaded))
+	for path := range loaded {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package pluginloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMissingPlugin(t *testing.T) {
+	if err := Load(filepath.Join(t.TempDir(), "missing.so")); err == nil {
+		t.Fatal("Load(): got nil, want error")
+	}
+
+	if got := len(Loaded()); got != 0 {
+		t.Errorf("len(Loaded()): got %d, want 0", got)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.so"), 0755); err != nil {
+		t.Fatalf("os.Mkdir(): got %v, want no error", err)
+	}
+
+	if err := LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir(): got %v, want no error", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "invalid.so"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	if err := LoadDir(dir); err == nil {
+		t.Fatal("LoadDir(): got nil, want error")
+	}
+
+	if err := LoadDir(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("LoadDir(missing): got nil, want error")
+	}
+}
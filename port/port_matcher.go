@@ -0,0 +1,78 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package port
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/martian/v3/filter"
+)
+
+func init() {
+	filter.RegisterCondition("port", matcherFromJSON)
+}
+
+// Matcher is a conditional evaluator of the port of request URLs to be used
+// in filters that take conditionals.
+type Matcher struct {
+	port int
+}
+
+type matcherJSON struct {
+	Port int `json:"port"`
+}
+
+// NewMatcher builds a new port matcher.
+func NewMatcher(port int) *Matcher {
+	return &Matcher{
+		port: port,
+	}
+}
+
+// matcherFromJSON builds a port.Matcher condition from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "port": 8080
+//	}
+func matcherFromJSON(b []byte) (filter.Condition, error) {
+	msg := &matcherJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return NewMatcher(msg.Port), nil
+}
+
+// MatchRequest returns true if the port of the request URL, explicit or
+// implied by its scheme, is m.port.
+func (m *Matcher) MatchRequest(req *http.Request) bool {
+	return m.matches(req.URL)
+}
+
+// MatchResponse returns true if the port of the request URL, explicit or
+// implied by its scheme, is m.port.
+func (m *Matcher) MatchResponse(res *http.Response) bool {
+	return m.matches(res.Request.URL)
+}
+
+func (m *Matcher) matches(u *url.URL) bool {
+	_, p, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		switch u.Scheme {
+		case "http":
+			return m.port == 80
+		case "https":
+			return m.port == 443
+		}
+		return false
+	}
+
+	pt, err := strconv.Atoi(p)
+	return err == nil && pt == m.port
+}
@@ -0,0 +1,237 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3/connmetric"
+	"github.com/google/martian/v3/log"
+)
+
+// defaultPreconnectIdleTimeout is the time connections established by
+// Preconnect are kept unless Proxy.PreconnectIdleTimeout is set.
+const defaultPreconnectIdleTimeout = 10 * time.Second
+
+// ticketWait is the time Preconnect waits for TLS 1.3 session tickets, which
+// servers send after the handshake.
+const ticketWait = 200 * time.Millisecond
+
+// warmConns holds connections established ahead of time, by address.
+type warmConns struct {
+	mu    sync.Mutex
+	conns map[string][]net.Conn
+}
+
+func (w *warmConns) put(addr string, conn net.Conn, ttl time.Duration) {
+	w.mu.Lock()
+	if w.conns == nil {
+		w.conns = make(map[string][]net.Conn)
+	}
+	w.conns[addr] = append(w.conns[addr], conn)
+	w.mu.Unlock()
+
+	// Servers close idle connections, drop the connection before it goes
+	// stale.
+	time.AfterFunc(ttl, func() {
+		if w.remove(addr, conn) {
+			conn.Close()
+		}
+	})
+}
+
+func (w *warmConns) take(addr string) net.Conn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	conns := w.conns[addr]
+	if len(conns) == 0 {
+		return nil
+	}
+
+	conn := conns[0]
+	if len(conns) == 1 {
+		delete(w.conns, addr)
+	} else {
+		w.conns[addr] = conns[1:]
+	}
+
+	return conn
+}
+
+func (w *warmConns) remove(addr string, conn net.Conn) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	conns := w.conns[addr]
+	for i, c := range conns {
+		if c == conn {
+			w.conns[addr] = append(conns[:i:i], conns[i+1:]...)
+			if len(w.conns[addr]) == 0 {
+				delete(w.conns, addr)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *warmConns) closeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, conns := range w.conns {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	w.conns = nil
+}
+
+// Preconnect resolves and connects to each of origins, such as
+// "https://example.com", before requests are sent to them, so that the
+// latency of the first requests is not dominated by connection setup. This is
+// meant to be called before a test starts.
+//
+// For each origin a TCP connection to the origin, or to its upstream proxy, is
+// established and handed out to the first connection attempt to the same
+// address within PreconnectIdleTimeout. For HTTPS origins that are not
+// reached through an upstream proxy, a TLS handshake is performed as well so
+// that the first request resumes the TLS session; this requires the round
+// tripper to be an *http.Transport, whose TLS config gets a client session
+// cache if it has none.
+//
+// Errors of the individual origins are returned as a *MultiError.
+func (p *Proxy) Preconnect(ctx context.Context, origins ...string) error {
+	tr, _ := p.roundTripper.(*http.Transport)
+	if tr != nil {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		if tr.TLSClientConfig.ClientSessionCache == nil {
+			tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		}
+	}
+
+	merr := NewMultiError()
+	var wg sync.WaitGroup
+	for _, origin := range origins {
+		wg.Add(1)
+		go func(origin string) {
+			defer wg.Done()
+
+			if err := p.preconnect(ctx, tr, origin); err != nil {
+				merr.Add(fmt.Errorf("martian: preconnect to %s: %w", origin, err))
+			}
+		}(origin)
+	}
+	wg.Wait()
+
+	if merr.Empty() {
+		return nil
+	}
+
+	return merr
+}
+
+func (p *Proxy) preconnect(ctx context.Context, tr *http.Transport, origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid origin %q, want http or https URL", origin)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	addr := canonicalAddr(u)
+	proxied := false
+	if p.proxyURL != nil {
+		proxyURL, err := p.proxyURL(req)
+		if err != nil {
+			return err
+		}
+		if proxyURL != nil {
+			addr = canonicalAddr(proxyURL)
+			proxied = true
+		}
+	}
+
+	if u.Scheme == "https" && !proxied && tr != nil {
+		if err := p.resumableSession(ctx, tr, u.Hostname(), addr); err != nil {
+			return err
+		}
+	}
+
+	conn, err := p.dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	ttl := p.PreconnectIdleTimeout
+	if ttl <= 0 {
+		ttl = defaultPreconnectIdleTimeout
+	}
+	p.warm.put(addr, conn, ttl)
+
+	log.Debugf("martian: preconnected to %s", addr)
+
+	return nil
+}
+
+// resumableSession performs a TLS handshake with the server at addr to store
+// a session in the client session cache of tr.
+func (p *Proxy) resumableSession(ctx context.Context, tr *http.Transport, serverName, addr string) error {
+	conn, err := p.dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	tc := tr.TLSClientConfig.Clone()
+	tc.ServerName = serverName
+
+	tlsconn := tls.Client(conn, tc)
+	if err := connmetric.TLSHandshake(ctx, tlsconn); err != nil {
+		return err
+	}
+
+	// TLS 1.3 session tickets are processed when reading.
+	if tlsconn.ConnectionState().Version == tls.VersionTLS13 {
+		tlsconn.SetReadDeadline(time.Now().Add(ticketWait))
+		tlsconn.Read(make([]byte, 1))
+	}
+
+	return nil
+}
+
+// canonicalAddr returns the host:port of u, with the default port of the
+// scheme if u has none.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch strings.ToLower(u.Scheme) {
+		case "https":
+			port = "443"
+		case "socks5":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
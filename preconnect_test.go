@@ -0,0 +1,101 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPreconnect(t *testing.T) {
+	var conns int32
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	s.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	s.StartTLS()
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+
+	p := NewProxy()
+	defer p.Close()
+
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}
+	p.SetRoundTripper(tr)
+
+	if err := p.Preconnect(context.Background(), s.URL, "ftp://example.com"); err == nil {
+		t.Fatal("Preconnect(): got nil error, want error for invalid origin")
+	}
+
+	// The TLS handshake for the session and the warm connection, which the
+	// server may not have accepted yet.
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&conns) < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := atomic.LoadInt32(&conns), int32(2); got != want {
+		t.Fatalf("connections: got %d, want %d", got, want)
+	}
+
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("tr.RoundTrip(): got %v, want no error", err)
+	}
+	res.Body.Close()
+
+	if got, want := atomic.LoadInt32(&conns), int32(2); got != want {
+		t.Errorf("connections: got %d, want %d", got, want)
+	}
+	if !res.TLS.DidResume {
+		t.Error("res.TLS.DidResume: got false, want true")
+	}
+}
+
+func TestPreconnectIdleTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): got %v, want no error", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	p := NewProxy()
+	defer p.Close()
+	p.PreconnectIdleTimeout = 20 * time.Millisecond
+
+	u := &url.URL{Scheme: "http", Host: l.Addr().String()}
+	if err := p.Preconnect(context.Background(), u.String()); err != nil {
+		t.Fatalf("Preconnect(): got %v, want no error", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if c := p.warm.take(l.Addr().String()); c != nil {
+		t.Error("p.warm.take(): got connection, want nil after idle timeout")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package preview generates size-capped previews of message bodies, so that
+// consumers of captured traffic can list messages without downloading their
+// full payloads.
+package preview
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"mime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	// Register decoders for image previews.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Kinds of previews.
+const (
+	Empty  = "empty"
+	Text   = "text"
+	JSON   = "json"
+	Image  = "image"
+	Binary = "binary"
+)
+
+// Preview is a summary of a message body.
+type Preview struct {
+	// Kind is the kind of body, one of Empty, Text, JSON, Image or Binary.
+	Kind string `json:"kind"`
+	// MimeType is the media type of the body without parameters.
+	MimeType string `json:"mimeType,omitempty"`
+	// Size is the size of the body in bytes.
+	Size int `json:"size"`
+	// Text is an excerpt of text and JSON bodies.
+	Text string `json:"text,omitempty"`
+	// Truncated reports whether Text is shorter than the body.
+	Truncated bool `json:"truncated,omitempty"`
+	// JSON is the summary of the structure of JSON bodies.
+	JSON *Node `json:"json,omitempty"`
+	// Image holds the metadata of image bodies.
+	Image *ImageInfo `json:"image,omitempty"`
+}
+
+// Node summarizes a JSON value.
+type Node struct {
+	// Type is the JSON type: "object", "array", "string", "number", "boolean"
+	// or "null".
+	Type string `json:"type"`
+	// Length is the number of fields of objects and elements of arrays.
+	Length int `json:"length,omitempty"`
+	// Fields holds the summaries of the fields of objects in key order, up to
+	// the configured depth and number of fields.
+	Fields []*Field `json:"fields,omitempty"`
+	// Items is the summary of the first element of arrays.
+	Items *Node `json:"items,omitempty"`
+}
+
+// Field is a named field of a JSON object.
+type Field struct {
+	Name  string `json:"name"`
+	Value *Node  `json:"value"`
+}
+
+// ImageInfo holds the metadata of an image.
+type ImageInfo struct {
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type config struct {
+	maxText   int
+	maxDepth  int
+	maxFields int
+}
+
+// Option is a configurable setting for previews.
+type Option func(*config)
+
+// MaxText sets the maximum length in bytes of text excerpts. Defaults to 256.
+func MaxText(n int) Option {
+	return func(c *config) {
+		c.maxText = n
+	}
+}
+
+// MaxDepth sets the depth up to which JSON values are summarized. Defaults
+// to 2.
+func MaxDepth(n int) Option {
+	return func(c *config) {
+		c.maxDepth = n
+	}
+}
+
+// MaxFields sets the maximum number of fields summarized per JSON object.
+// Defaults to 20.
+func MaxFields(n int) Option {
+	return func(c *config) {
+		c.maxFields = n
+	}
+}
+
+// New returns a preview of body with the media type contentType.
+func New(contentType string, body []byte, opts ...Option) *Preview {
+	c := &config{
+		maxText:   256,
+		maxDepth:  2,
+		maxFields: 20,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	p := &Preview{
+		MimeType: mt,
+		Size:     len(body),
+	}
+
+	if len(body) == 0 {
+		p.Kind = Empty
+		return p
+	}
+
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(body)); err == nil {
+		p.Kind = Image
+		p.Image = &ImageInfo{
+			Format: format,
+			Width:  cfg.Width,
+			Height: cfg.Height,
+		}
+		return p
+	}
+	if strings.HasPrefix(mt, "image/") {
+		p.Kind = Binary
+		return p
+	}
+
+	if isJSON(mt) || mt == "" {
+		var v any
+		if err := json.Unmarshal(body, &v); err == nil {
+			p.Kind = JSON
+			p.JSON = c.node(v, 0)
+			p.Text, p.Truncated = excerpt(body, c.maxText)
+			return p
+		}
+	}
+
+	if isText(mt, body) {
+		p.Kind = Text
+		p.Text, p.Truncated = excerpt(body, c.maxText)
+		return p
+	}
+
+	p.Kind = Binary
+	return p
+}
+
+func isJSON(mt string) bool {
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+func isText(mt string, body []byte) bool {
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "+xml"),
+		mt == "application/xml",
+		mt == "application/javascript",
+		mt == "application/x-www-form-urlencoded":
+		return true
+	}
+
+	if !utf8.Valid(body) {
+		return false
+	}
+	for _, r := range string(body) {
+		if r < ' ' && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// excerpt returns at most n bytes of body, cut at a rune boundary.
+func excerpt(body []byte, n int) (string, bool) {
+	if len(body) <= n {
+		return string(body), false
+	}
+
+	b := body[:n]
+	for len(b) > 0 && !utf8.Valid(b) {
+		b = b[:len(b)-1]
+	}
+
+	return string(b), true
+}
+
+func (c *config) node(v any, depth int) *Node {
+	switch tv := v.(type) {
+	case map[string]any:
+		n := &Node{
+			Type:   "object",
+			Length: len(tv),
+		}
+		if depth >= c.maxDepth {
+			return n
+		}
+
+		keys := make([]string, 0, len(tv))
+		for k := range tv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if len(keys) > c.maxFields {
+			keys = keys[:c.maxFields]
+		}
+
+		for _, k := range keys {
+			n.Fields = append(n.Fields, &Field{
+				Name:  k,
+				Value: c.node(tv[k], depth+1),
+			})
+		}
+		return n
+	case []any:
+		n := &Node{
+			Type:   "array",
+			Length: len(tv),
+		}
+		if depth < c.maxDepth && len(tv) > 0 {
+			n.Items = c.node(tv[0], depth+1)
+		}
+		return n
+	case string:
+		return &Node{Type: "string"}
+	case float64:
+		return &Node{Type: "number"}
+	case bool:
+		return &Node{Type: "boolean"}
+	default:
+		return &Node{Type: "null"}
+	}
+}
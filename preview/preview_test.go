@@ -0,0 +1,117 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package preview
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestNewEmpty(t *testing.T) {
+	p := New("text/plain", nil)
+	if got, want := p.Kind, Empty; got != want {
+		t.Errorf("p.Kind: got %q, want %q", got, want)
+	}
+}
+
+func TestNewText(t *testing.T) {
+	body := []byte(strings.Repeat("a", 300))
+
+	p := New("text/plain; charset=utf-8", body)
+	if got, want := p.Kind, Text; got != want {
+		t.Errorf("p.Kind: got %q, want %q", got, want)
+	}
+	if got, want := p.MimeType, "text/plain"; got != want {
+		t.Errorf("p.MimeType: got %q, want %q", got, want)
+	}
+	if got, want := p.Size, 300; got != want {
+		t.Errorf("p.Size: got %d, want %d", got, want)
+	}
+	if got, want := len(p.Text), 256; got != want {
+		t.Errorf("len(p.Text): got %d, want %d", got, want)
+	}
+	if !p.Truncated {
+		t.Error("p.Truncated: got false, want true")
+	}
+
+	// Excerpts are cut at rune boundaries.
+	p = New("text/plain", []byte("ééé"), MaxText(3))
+	if got, want := p.Text, "é"; got != want {
+		t.Errorf("p.Text: got %q, want %q", got, want)
+	}
+}
+
+func TestNewJSON(t *testing.T) {
+	body := []byte(`{"items": [{"id": 1, "name": "a"}], "total": 1, "next": null, "meta": {"deep": {"deeper": true}}}`)
+
+	p := New("application/json", body)
+	if got, want := p.Kind, JSON; got != want {
+		t.Fatalf("p.Kind: got %q, want %q", got, want)
+	}
+
+	n := p.JSON
+	if got, want := n.Type, "object"; got != want {
+		t.Errorf("n.Type: got %q, want %q", got, want)
+	}
+	if got, want := n.Length, 4; got != want {
+		t.Errorf("n.Length: got %d, want %d", got, want)
+	}
+
+	var names []string
+	for _, f := range n.Fields {
+		names = append(names, f.Name)
+	}
+	if got, want := strings.Join(names, ","), "items,meta,next,total"; got != want {
+		t.Errorf("n.Fields: got %q, want %q", got, want)
+	}
+
+	items := n.Fields[0].Value
+	if got, want := items.Type, "array"; got != want {
+		t.Errorf("items.Type: got %q, want %q", got, want)
+	}
+	if items.Items == nil || items.Items.Type != "object" {
+		t.Errorf("items.Items: got %+v, want object", items.Items)
+	}
+	if got := items.Items.Fields; got != nil {
+		t.Errorf("items.Items.Fields: got %v, want nil beyond max depth", got)
+	}
+
+	p = New("application/json", body, MaxFields(1), MaxDepth(1))
+	if got, want := len(p.JSON.Fields), 1; got != want {
+		t.Errorf("len(p.JSON.Fields): got %d, want %d", got, want)
+	}
+	if got := p.JSON.Fields[0].Value.Items; got != nil {
+		t.Errorf("p.JSON.Fields[0].Value.Items: got %+v, want nil beyond max depth", got)
+	}
+}
+
+func TestNewImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatalf("png.Encode(): got %v, want no error", err)
+	}
+
+	p := New("image/png", buf.Bytes())
+	if got, want := p.Kind, Image; got != want {
+		t.Fatalf("p.Kind: got %q, want %q", got, want)
+	}
+	if got, want := *p.Image, (ImageInfo{Format: "png", Width: 3, Height: 2}); got != want {
+		t.Errorf("p.Image: got %+v, want %+v", got, want)
+	}
+	if p.Text != "" {
+		t.Errorf("p.Text: got %q, want empty", p.Text)
+	}
+}
+
+func TestNewBinary(t *testing.T) {
+	p := New("application/octet-stream", []byte{0x00, 0xff, 0x10})
+	if got, want := p.Kind, Binary; got != want {
+		t.Errorf("p.Kind: got %q, want %q", got, want)
+	}
+	if p.Text != "" {
+		t.Errorf("p.Text: got %q, want empty", p.Text)
+	}
+}
@@ -18,8 +18,11 @@ package priority
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/parse"
@@ -35,12 +38,14 @@ var (
 type priorityRequestModifier struct {
 	reqmod   martian.RequestModifier
 	priority int64
+	id       string
 }
 
 // priorityResponseModifier is a response modifier with a priority.
 type priorityResponseModifier struct {
 	resmod   martian.ResponseModifier
 	priority int64
+	id       string
 }
 
 // Group is a group of request and response modifiers ordered by their priority.
@@ -50,15 +55,21 @@ type Group struct {
 
 	resmu   sync.RWMutex
 	resmods []*priorityResponseModifier
+
+	recoverPanics bool
+	timeout       time.Duration
 }
 
 type groupJSON struct {
-	Modifiers []modifierJSON       `json:"modifiers"`
-	Scope     []parse.ModifierType `json:"scope"`
+	Modifiers     []modifierJSON       `json:"modifiers"`
+	Scope         []parse.ModifierType `json:"scope"`
+	RecoverPanics bool                 `json:"recoverPanics"`
+	TimeoutMs     int64                `json:"modifierTimeoutMs"`
 }
 
 type modifierJSON struct {
 	Priority int64           `json:"priority"`
+	ID       string          `json:"id"`
 	Modifier json.RawMessage `json:"modifier"`
 }
 
@@ -71,17 +82,34 @@ func NewGroup() *Group {
 	return &Group{}
 }
 
+// SetRecoverPanics sets whether panics of the modifiers of the group are
+// recovered and returned as errors naming the modifier, so that a misbehaving
+// modifier cannot crash the proxy. By default, panics are not recovered.
+func (pg *Group) SetRecoverPanics(recoverPanics bool) {
+	pg.recoverPanics = recoverPanics
+}
+
+// SetModifierTimeout sets the time each modifier of the group may run. A
+// modifier that runs longer fails with an error naming it, while it keeps
+// running in the background. A positive timeout also recovers panics, see
+// SetRecoverPanics. By default, modifiers run without limit.
+func (pg *Group) SetModifierTimeout(timeout time.Duration) {
+	pg.timeout = timeout
+}
+
 // AddRequestModifier adds a RequestModifier with the given priority.
 //
 // If a modifier is added with a priority that is equal to an existing priority
 // the newer modifier will be added before the existing modifier in the chain.
-func (pg *Group) AddRequestModifier(reqmod martian.RequestModifier, priority int64) {
+// An optional ID identifies the modifier for UpdatePriorities.
+func (pg *Group) AddRequestModifier(reqmod martian.RequestModifier, priority int64, id ...string) {
 	pg.reqmu.Lock()
 	defer pg.reqmu.Unlock()
 
 	preqmod := &priorityRequestModifier{
 		reqmod:   reqmod,
 		priority: priority,
+		id:       optionalID(id),
 	}
 
 	for i, m := range pg.reqmods {
@@ -120,13 +148,15 @@ func (pg *Group) RemoveRequestModifier(reqmod martian.RequestModifier) error {
 //
 // If a modifier is added with a priority that is equal to an existing priority
 // the newer modifier will be added before the existing modifier in the chain.
-func (pg *Group) AddResponseModifier(resmod martian.ResponseModifier, priority int64) {
+// An optional ID identifies the modifier for UpdatePriorities.
+func (pg *Group) AddResponseModifier(resmod martian.ResponseModifier, priority int64, id ...string) {
 	pg.resmu.Lock()
 	defer pg.resmu.Unlock()
 
 	presmod := &priorityResponseModifier{
 		resmod:   resmod,
 		priority: priority,
+		id:       optionalID(id),
 	}
 
 	for i, m := range pg.resmods {
@@ -161,6 +191,161 @@ func (pg *Group) RemoveResponseModifier(resmod martian.ResponseModifier) error {
 	return ErrModifierNotFound
 }
 
+func optionalID(id []string) string {
+	if len(id) == 0 {
+		return ""
+	}
+
+	return id[0]
+}
+
+// Entry describes a modifier of a Group.
+type Entry struct {
+	// ID is the ID the modifier was added with, if any.
+	ID string `json:"id,omitempty"`
+	// Type is the type of the modifier.
+	Type string `json:"type"`
+	// Priority is the priority of the modifier.
+	Priority int64 `json:"priority"`
+	// Modifier is the modifier.
+	Modifier any `json:"-"`
+}
+
+// Snapshot lists the modifiers of a Group in the order they run.
+type Snapshot struct {
+	Request  []Entry `json:"request"`
+	Response []Entry `json:"response"`
+}
+
+// Snapshot returns the modifiers of the group and their priorities, as of a
+// single point in time.
+func (pg *Group) Snapshot() *Snapshot {
+	pg.reqmu.RLock()
+	defer pg.reqmu.RUnlock()
+	pg.resmu.RLock()
+	defer pg.resmu.RUnlock()
+
+	s := &Snapshot{
+		Request:  make([]Entry, 0, len(pg.reqmods)),
+		Response: make([]Entry, 0, len(pg.resmods)),
+	}
+	for _, m := range pg.reqmods {
+		s.Request = append(s.Request, Entry{
+			ID:       m.id,
+			Type:     fmt.Sprintf("%T", m.reqmod),
+			Priority: m.priority,
+			Modifier: m.reqmod,
+		})
+	}
+	for _, m := range pg.resmods {
+		s.Response = append(s.Response, Entry{
+			ID:       m.id,
+			Type:     fmt.Sprintf("%T", m.resmod),
+			Priority: m.priority,
+			Modifier: m.resmod,
+		})
+	}
+
+	return s
+}
+
+// UpdatePriority sets the priority of mod, as a request modifier, a response
+// modifier or both, and moves it accordingly. Modifiers with equal
+// priorities keep their relative order. Returns ErrModifierNotFound if mod
+// is not in the group.
+func (pg *Group) UpdatePriority(mod any, priority int64) error {
+	return pg.update(func(reqmod martian.RequestModifier, resmod martian.ResponseModifier, _ string) (int64, bool) {
+		if (reqmod != nil && any(reqmod) == mod) || (resmod != nil && any(resmod) == mod) {
+			return priority, true
+		}
+		return 0, false
+	}, nil)
+}
+
+// UpdatePriorities sets the priorities of the modifiers added with the IDs
+// in priorities, and reorders the group, in a single step: requests and
+// responses see the group either before or after the update. Modifiers with
+// equal priorities keep their relative order. If an ID matches no modifier,
+// the group is left unchanged and an error wrapping ErrModifierNotFound is
+// returned.
+func (pg *Group) UpdatePriorities(priorities map[string]int64) error {
+	found := make(map[string]bool, len(priorities))
+	return pg.update(func(_ martian.RequestModifier, _ martian.ResponseModifier, id string) (int64, bool) {
+		p, ok := priorities[id]
+		if id == "" || !ok {
+			return 0, false
+		}
+		found[id] = true
+		return p, true
+	}, func() error {
+		for id := range priorities {
+			if !found[id] {
+				return fmt.Errorf("priority: %q: %w", id, ErrModifierNotFound)
+			}
+		}
+		return nil
+	})
+}
+
+// update sets the priorities returned by match for the modifiers it matches
+// and reorders the group. If no modifier matches, or check fails, the group
+// is left unchanged.
+func (pg *Group) update(match func(martian.RequestModifier, martian.ResponseModifier, string) (int64, bool), check func() error) error {
+	pg.reqmu.Lock()
+	defer pg.reqmu.Unlock()
+	pg.resmu.Lock()
+	defer pg.resmu.Unlock()
+
+	reqprio := make(map[int]int64)
+	for i, m := range pg.reqmods {
+		if p, ok := match(m.reqmod, nil, m.id); ok {
+			reqprio[i] = p
+		}
+	}
+	resprio := make(map[int]int64)
+	for i, m := range pg.resmods {
+		if p, ok := match(nil, m.resmod, m.id); ok {
+			resprio[i] = p
+		}
+	}
+
+	if len(reqprio) == 0 && len(resprio) == 0 {
+		return ErrModifierNotFound
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+
+	// The modifiers are replaced rather than updated in place, since a
+	// Snapshot may share them.
+	reqmods := make([]*priorityRequestModifier, len(pg.reqmods))
+	for i, m := range pg.reqmods {
+		if p, ok := reqprio[i]; ok {
+			m = &priorityRequestModifier{reqmod: m.reqmod, priority: p, id: m.id}
+		}
+		reqmods[i] = m
+	}
+	sort.SliceStable(reqmods, func(i, j int) bool {
+		return reqmods[i].priority > reqmods[j].priority
+	})
+
+	resmods := make([]*priorityResponseModifier, len(pg.resmods))
+	for i, m := range pg.resmods {
+		if p, ok := resprio[i]; ok {
+			m = &priorityResponseModifier{resmod: m.resmod, priority: p, id: m.id}
+		}
+		resmods[i] = m
+	}
+	sort.SliceStable(resmods, func(i, j int) bool {
+		return resmods[i].priority > resmods[j].priority
+	})
+
+	pg.reqmods, pg.resmods = reqmods, resmods
+	return nil
+}
+
 // ModifyRequest modifies the request. Modifiers are run in descending order of
 // their priority. If an error is returned by a RequestModifier the error is
 // returned and no further modifiers are run.
@@ -169,7 +354,7 @@ func (pg *Group) ModifyRequest(req *http.Request) error {
 	defer pg.reqmu.RUnlock()
 
 	for _, m := range pg.reqmods {
-		if err := m.reqmod.ModifyRequest(req); err != nil {
+		if err := pg.modifyRequest(m.reqmod, req); err != nil {
 			return err
 		}
 	}
@@ -185,7 +370,7 @@ func (pg *Group) ModifyResponse(res *http.Response) error {
 	defer pg.resmu.RUnlock()
 
 	for _, m := range pg.resmods {
-		if err := m.resmod.ModifyResponse(res); err != nil {
+		if err := pg.modifyResponse(m.resmod, res); err != nil {
 			return err
 		}
 	}
@@ -193,15 +378,34 @@ func (pg *Group) ModifyResponse(res *http.Response) error {
 	return nil
 }
 
+func (pg *Group) modifyRequest(reqmod martian.RequestModifier, req *http.Request) error {
+	if !pg.recoverPanics && pg.timeout <= 0 {
+		return reqmod.ModifyRequest(req)
+	}
+
+	return martian.GuardRequest(reqmod, req, pg.timeout)
+}
+
+func (pg *Group) modifyResponse(resmod martian.ResponseModifier, res *http.Response) error {
+	if !pg.recoverPanics && pg.timeout <= 0 {
+		return resmod.ModifyResponse(res)
+	}
+
+	return martian.GuardResponse(resmod, res, pg.timeout)
+}
+
 // groupFromJSON builds a priority.Group from JSON.
 //
 // Example JSON:
 // {
 //   "priority.Group": {
 //     "scope": ["request", "response"],
+//     "recoverPanics": true,
+//     "modifierTimeoutMs": 500,
 //     "modifiers": [
 //       {
 //         "priority": 100, // Will run first.
+//         "id": "first", // Optional, for UpdatePriorities.
 //         "modifier": { ... },
 //       },
 //       {
@@ -217,7 +421,13 @@ func groupFromJSON(b []byte) (*parse.Result, error) {
 		return nil, err
 	}
 
+	if msg.TimeoutMs < 0 {
+		return nil, errors.New("priority: modifierTimeoutMs must not be negative")
+	}
+
 	pg := NewGroup()
+	pg.SetRecoverPanics(msg.RecoverPanics)
+	pg.SetModifierTimeout(time.Duration(msg.TimeoutMs) * time.Millisecond)
 
 	for _, m := range msg.Modifiers {
 		r, err := parse.FromJSON(m.Modifier)
@@ -227,12 +437,12 @@ func groupFromJSON(b []byte) (*parse.Result, error) {
 
 		reqmod := r.RequestModifier()
 		if reqmod != nil {
-			pg.AddRequestModifier(reqmod, m.Priority)
+			pg.AddRequestModifier(reqmod, m.Priority, m.ID)
 		}
 
 		resmod := r.ResponseModifier()
 		if resmod != nil {
-			pg.AddResponseModifier(resmod, m.Priority)
+			pg.AddResponseModifier(resmod, m.Priority, m.ID)
 		}
 	}
 
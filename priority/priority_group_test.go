@@ -19,7 +19,9 @@ import (
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/martiantest"
 	"github.com/google/martian/v3/parse"
 	"github.com/google/martian/v3/proxyutil"
@@ -241,3 +243,110 @@ func TestGroupFromJSON(t *testing.T) {
 		t.Errorf("res.Header.Get(%q): got %q, want %q", "Y-Testing", got, want)
 	}
 }
+
+func TestPriorityGroupGuardsModifiers(t *testing.T) {
+	pg := NewGroup()
+
+	pg.AddResponseModifier(martian.ResponseModifierFunc(func(*http.Response) error { panic("boom") }), 100)
+	tm := martiantest.NewModifier()
+	pg.AddResponseModifier(tm, 0)
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+
+	pg.SetRecoverPanics(true)
+	err = pg.ModifyResponse(res)
+	if want := "martian: martian.ResponseModifierFunc panicked: boom"; err == nil || err.Error() != want {
+		t.Errorf("pg.ModifyResponse(): got %v, want %q", err, want)
+	}
+	if tm.ResponseModified() {
+		t.Error("tm.ResponseModified(): got true, want false")
+	}
+
+	pg.SetRecoverPanics(false)
+	pg.SetModifierTimeout(10 * time.Millisecond)
+	if err := pg.ModifyResponse(res); err == nil {
+		t.Error("pg.ModifyResponse(): got nil, want panic error with a timeout")
+	}
+}
+
+func TestPriorityGroupUpdatePriority(t *testing.T) {
+	var order []string
+
+	pg := NewGroup()
+
+	mods := make(map[string]*martiantest.Modifier)
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		tm := martiantest.NewModifier()
+		tm.RequestFunc(func(*http.Request) {
+			order = append(order, name)
+		})
+		mods[name] = tm
+	}
+	pg.AddRequestModifier(mods["c"], 0)
+	pg.AddRequestModifier(mods["b"], 50)
+	pg.AddRequestModifier(mods["a"], 100)
+
+	if err := pg.UpdatePriority(martiantest.NewModifier(), 10); err != ErrModifierNotFound {
+		t.Fatalf("UpdatePriority(): got %v, want ErrModifierNotFound", err)
+	}
+	// c moves ahead of b, and stays behind a as it keeps its relative order
+	// among modifiers of equal priority.
+	if err := pg.UpdatePriority(mods["c"], 100); err != nil {
+		t.Fatalf("UpdatePriority(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := pg.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := order, []string{"a", "c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reflect.DeepEqual(%v, %v): got false, want true", got, want)
+	}
+}
+
+func TestPriorityGroupUpdatePriorities(t *testing.T) {
+	pg := NewGroup()
+
+	tm1 := martiantest.NewModifier()
+	pg.AddRequestModifier(tm1, 100, "first")
+	pg.AddResponseModifier(tm1, 100, "first")
+	tm2 := martiantest.NewModifier()
+	pg.AddRequestModifier(tm2, 50, "second")
+	pg.AddResponseModifier(tm2, 50, "second")
+
+	before := pg.Snapshot()
+
+	err := pg.UpdatePriorities(map[string]int64{"second": 200, "missing": 0})
+	if !errors.Is(err, ErrModifierNotFound) {
+		t.Fatalf("UpdatePriorities(): got %v, want ErrModifierNotFound", err)
+	}
+	if got := pg.Snapshot(); !reflect.DeepEqual(got, before) {
+		t.Errorf("Snapshot(): got %v, want %v", got, before)
+	}
+
+	if err := pg.UpdatePriorities(map[string]int64{"second": 200}); err != nil {
+		t.Fatalf("UpdatePriorities(): got %v, want no error", err)
+	}
+
+	s := pg.Snapshot()
+	for _, entries := range [][]Entry{s.Request, s.Response} {
+		want := []Entry{
+			{ID: "second", Type: "*martiantest.Modifier", Priority: 200, Modifier: tm2},
+			{ID: "first", Type: "*martiantest.Modifier", Priority: 100, Modifier: tm1},
+		}
+		if !reflect.DeepEqual(entries, want) {
+			t.Errorf("Snapshot(): got %v, want %v", entries, want)
+		}
+	}
+	if got, want := before.Request[1].Priority, int64(50); got != want {
+		t.Errorf("before.Request[1].Priority: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package priority
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/martian/v3/log"
+)
+
+type handler struct {
+	pg *Group
+}
+
+type prioritiesJSON struct {
+	Priorities map[string]int64 `json:"priorities"`
+}
+
+// NewHandler returns an http.Handler that lists and reorders the modifiers of
+// pg. GET requests return the Snapshot of the group as JSON. POST requests are
+// expected to provide a JSON message in the body mapping the IDs of modifiers
+// to their new priorities, which are applied with UpdatePriorities:
+//
+//	{
+//	  "priorities": {
+//	    "auth": 100,
+//	    "logging": 0
+//	  }
+//	}
+//
+// The response to a POST request is the Snapshot after the update.
+func NewHandler(pg *Group) http.Handler {
+	return &handler{
+		pg: pg,
+	}
+}
+
+// ServeHTTP lists or reorders the modifiers of the group.
+func (h *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "POST":
+		msg := &prioritiesJSON{}
+		if err := json.NewDecoder(req.Body).Decode(msg); err != nil {
+			http.Error(rw, err.Error(), 400)
+			log.Errorf("priority: error parsing JSON: %v", err)
+			return
+		}
+
+		if err := h.pg.UpdatePriorities(msg.Priorities); err != nil {
+			http.Error(rw, err.Error(), 400)
+			log.Errorf("priority: error updating priorities: %v", err)
+			return
+		}
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		rw.WriteHeader(405)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(h.pg.Snapshot()); err != nil {
+		log.Errorf("priority: error writing JSON response: %v", err)
+	}
+}
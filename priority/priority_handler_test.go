@@ -0,0 +1,64 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package priority
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/martiantest"
+)
+
+func TestHandler(t *testing.T) {
+	pg := NewGroup()
+	pg.AddRequestModifier(martiantest.NewModifier(), 100, "first")
+	pg.AddRequestModifier(martiantest.NewModifier(), 50, "second")
+
+	h := NewHandler(pg)
+
+	tt := []struct {
+		method string
+		body   string
+		want   int
+		ids    []string
+	}{
+		{"PUT", "", 405, nil},
+		{"POST", "not json", 400, nil},
+		{"POST", `{"priorities": {"third": 10}}`, 400, nil},
+		{"GET", "", 200, []string{"first", "second"}},
+		{"POST", `{"priorities": {"second": 200}}`, 200, []string{"second", "first"}},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest(tc.method, "/priorities", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+
+		if got := rw.Code; got != tc.want {
+			t.Errorf("%d. rw.Code: got %d, want %d", i, got, tc.want)
+		}
+		if tc.ids == nil {
+			continue
+		}
+
+		s := &Snapshot{}
+		if err := json.Unmarshal(rw.Body.Bytes(), s); err != nil {
+			t.Fatalf("%d. json.Unmarshal(): got %v, want no error", i, err)
+		}
+		if got, want := len(s.Request), len(tc.ids); got != want {
+			t.Fatalf("%d. len(s.Request): got %d, want %d", i, got, want)
+		}
+		for j, id := range tc.ids {
+			if got := s.Request[j].ID; got != id {
+				t.Errorf("%d. s.Request[%d].ID: got %q, want %q", i, j, got, id)
+			}
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -31,6 +32,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/martian/v3/connmetric"
 	"github.com/google/martian/v3/dialvia"
 	"github.com/google/martian/v3/log"
 	"github.com/google/martian/v3/mitm"
@@ -103,10 +105,83 @@ type Proxy struct {
 	// CloseAfterReply closes the connection after the response has been sent.
 	CloseAfterReply bool
 
+	// MaxHeaderBytes is the maximum number of bytes of the request line and
+	// header of requests. Larger requests are answered with 431 Request Header
+	// Fields Too Large. If zero, http.DefaultMaxHeaderBytes is used. It applies
+	// to connections accepted by Serve only; with Handler, the
+	// MaxHeaderBytes of the http.Server applies.
+	MaxHeaderBytes int
+
+	// MaxHeaderCount is the maximum number of header fields of requests.
+	// Requests with more fields are answered with 431 Request Header Fields
+	// Too Large. If zero, the number of fields is not limited.
+	MaxHeaderCount int
+
+	// MaxBufferedBodyBytes is the maximum number of bytes of request bodies
+	// the proxy reads into memory, such as chunked bodies with StrictParsing
+	// and bodies of requests with ExpectContinueBuffer.
+	// Larger requests are answered with 413 Content Too Large. If zero,
+	// 10 MB is used.
+	MaxBufferedBodyBytes int64
+
+	// StrictParsing rejects requests that could be interpreted differently by
+	// the proxy and the origin, so that the proxy cannot be used for request
+	// smuggling: requests with bare LF line endings, obs-fold header lines,
+	// both Content-Length and Transfer-Encoding, multiple or invalid
+	// Content-Length fields, or malformed chunk sizes and extensions are
+	// answered with 400 Bad Request. Chunked request bodies are read entirely,
+	// up to MaxBufferedBodyBytes, and forwarded with a Content-Length.
+	// Request headers larger than 64 KB are answered with 431 Request Header
+	// Fields Too Large. It applies to connections accepted by Serve only.
+	StrictParsing bool
+
+	// ExpectContinue determines how requests with "Expect: 100-continue" are
+	// handled. Defaults to ExpectContinueRelay.
+	ExpectContinue ExpectContinueMode
+
+	// PreserveHeaderOrder sends requests to their destination with the header
+	// fields in the order and casing they were received in, instead of the
+	// canonical casing and sorted order of net/http. Such requests are sent
+	// over a new connection each, using the dial func and TLS config of the
+	// round tripper. Requests sent via an upstream proxy and upgrade requests
+	// are sent by the round tripper as usual. It applies to connections
+	// accepted by Serve only, and only when the round tripper is an
+	// *http.Transport.
+	PreserveHeaderOrder bool
+
+	// WireCapture, if set, receives the exact bytes read from and written to
+	// client connections accepted by Serve and to upstream connections dialed
+	// for requests. Bytes of TLS connections are captured as sent, the
+	// plaintext of MITM'd connections is captured separately.
+	WireCapture WireCapturer
+
+	// Observer, if set, receives events of connections and requests, such as
+	// to export metrics.
+	Observer Observer
+
+	// PreconnectIdleTimeout is the maximum duration connections established
+	// by Preconnect are kept before they are used. If zero, 10 seconds is
+	// used.
+	PreconnectIdleTimeout time.Duration
+
+	// FollowRedirects is the maximum number of redirects the proxy follows
+	// itself, returning the final response to the client instead of the
+	// redirect. The redirects followed are recorded in the Context of the
+	// request under RedirectsKey. Request modifiers can override it per
+	// request with Context.FollowRedirects. If zero, redirects are returned
+	// to the client.
+	FollowRedirects int
+
 	roundTripper http.RoundTripper
 	dial         func(context.Context, string, string) (net.Conn, error)
+	connTracker  connmetric.Tracker
+	mitmMu       sync.RWMutex
 	mitm         *mitm.Config
 	proxyURL     func(*http.Request) (*url.URL, error)
+	certsMu      sync.RWMutex
+	clientCerts  map[string]*tls.Certificate
+	tlsPolicy    *mitm.TLSPolicy
+	warm         warmConns
 	conns        sync.WaitGroup
 	connsMu      sync.Mutex // protects conns.Add/Wait from concurrent access
 	closing      chan bool
@@ -150,7 +225,129 @@ func (p *Proxy) SetRoundTripper(rt http.RoundTripper) {
 		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
 		tr.Proxy = p.proxyURL
 		tr.DialContext = p.dial
+		p.setClientCertificateHook(tr)
+		p.applyTLSPolicy(tr)
+	}
+}
+
+// SetUpstreamTLSPolicy sets the TLS versions, cipher suites and curves offered
+// to destination servers and upstream proxies.
+//
+// The policy is applied to the round tripper when it is an *http.Transport,
+// and to the HTTP/2 connections of MITMed tunnels.
+func (p *Proxy) SetUpstreamTLSPolicy(policy mitm.TLSPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	p.tlsPolicy = &policy
+
+	if tr, ok := p.roundTripper.(*http.Transport); ok {
+		p.applyTLSPolicy(tr)
+	}
+
+	return nil
+}
+
+func (p *Proxy) applyTLSPolicy(tr *http.Transport) {
+	if p.tlsPolicy == nil {
+		return
+	}
+
+	if tr.TLSClientConfig == nil {
+		tr.TLSClientConfig = &tls.Config{}
+	}
+	p.tlsPolicy.Apply(tr.TLSClientConfig)
+}
+
+// SetUpstreamClientCertificate sets the client certificate presented to the
+// destination server host when it requests one during the TLS handshake, so
+// that servers requiring mutual TLS can be MITMed. A nil cert removes the
+// certificate for host.
+//
+// The certificate is used by the round tripper when it is an *http.Transport,
+// and by the HTTP/2 connections of MITMed tunnels.
+func (p *Proxy) SetUpstreamClientCertificate(host string, cert *tls.Certificate) {
+	host = strings.ToLower(host)
+
+	p.certsMu.Lock()
+	if cert == nil {
+		delete(p.clientCerts, host)
+	} else {
+		if p.clientCerts == nil {
+			p.clientCerts = make(map[string]*tls.Certificate)
+		}
+		p.clientCerts[host] = cert
+	}
+	p.certsMu.Unlock()
+
+	if tr, ok := p.roundTripper.(*http.Transport); ok {
+		p.setClientCertificateHook(tr)
+	}
+}
+
+type upstreamHostKey struct{}
+
+// setClientCertificateHook makes tr select the client certificate by the
+// destination host of the request, falling back to the certificates of the
+// transport TLS config.
+func (p *Proxy) setClientCertificateHook(tr *http.Transport) {
+	p.certsMu.RLock()
+	defer p.certsMu.RUnlock()
+
+	if len(p.clientCerts) == 0 {
+		return
+	}
+
+	if tr.TLSClientConfig == nil {
+		tr.TLSClientConfig = &tls.Config{}
+	}
+	certs := tr.TLSClientConfig.Certificates
+	tr.TLSClientConfig.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if host, ok := cri.Context().Value(upstreamHostKey{}).(string); ok {
+			if cert := p.clientCertificate(host); cert != nil {
+				return cert, nil
+			}
+		}
+		if len(certs) > 0 {
+			return &certs[0], nil
+		}
+
+		return &tls.Certificate{}, nil
+	}
+}
+
+// upstreamTLSConfig returns the TLS config of connections to the destination
+// server host that are dialed outside the round tripper, such as those of
+// MITMed HTTP/2 tunnels. It is based on the TLS config of the round tripper if
+// it is an *http.Transport, or on the upstream TLS policy otherwise, and
+// presents the client certificate set for host, if any.
+func (p *Proxy) upstreamTLSConfig(host string) *tls.Config {
+	var tc *tls.Config
+	if tr, ok := p.roundTripper.(*http.Transport); ok && tr.TLSClientConfig != nil {
+		tc = tr.TLSClientConfig.Clone()
+		// The hook of the transport selects the certificate by the host of
+		// the request, which is not known to the handshake.
+		tc.GetClientCertificate = nil
+	} else {
+		tc = &tls.Config{}
+		if p.tlsPolicy != nil {
+			p.tlsPolicy.Apply(tc)
+		}
 	}
+
+	if cert := p.clientCertificate(host); cert != nil {
+		tc.Certificates = []tls.Certificate{*cert}
+	}
+
+	return tc
+}
+
+func (p *Proxy) clientCertificate(host string) *tls.Certificate {
+	p.certsMu.RLock()
+	defer p.certsMu.RUnlock()
+
+	return p.clientCerts[strings.ToLower(host)]
 }
 
 // SetUpstreamProxy sets the proxy that receives requests from this proxy.
@@ -167,19 +364,47 @@ func (p *Proxy) SetUpstreamProxyFunc(f func(*http.Request) (*url.URL, error)) {
 	}
 }
 
-// SetMITM sets the config to use for MITMing of CONNECT requests.
+// SetMITM sets the config to use for MITMing of CONNECT requests. It is safe
+// to call while the proxy is serving; the config is used for CONNECT requests
+// received afterwards, established tunnels keep their config.
 func (p *Proxy) SetMITM(config *mitm.Config) {
+	p.mitmMu.Lock()
+	defer p.mitmMu.Unlock()
+
 	p.mitm = config
 }
 
+func (p *Proxy) mitmConfig() *mitm.Config {
+	p.mitmMu.RLock()
+	defer p.mitmMu.RUnlock()
+
+	return p.mitm
+}
+
 // SetDialContext sets the dial func used to establish a connection.
 func (p *Proxy) SetDialContext(dial func(context.Context, string, string) (net.Conn, error)) {
-	p.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+	rawDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
 		c, e := dial(ctx, network, addr)
 		nosigpipe.IgnoreSIGPIPE(c)
 		return c, e
 	}
 
+	p.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if strings.HasPrefix(network, "tcp") {
+			if c := p.warm.take(addr); c != nil {
+				return c, nil
+			}
+		}
+
+		d := rawDial
+		if t := p.connTracker; t != nil {
+			d = connmetric.Dialer(rawDial, t)
+		}
+
+		c, e := d(ctx, network, addr)
+		return p.captureUpstream(ctx, c), e
+	}
+
 	if tr, ok := p.roundTripper.(*http.Transport); ok {
 		tr.DialContext = p.dial
 	}
@@ -192,6 +417,7 @@ func (p *Proxy) Close() {
 	log.Infof("martian: closing down proxy")
 
 	close(p.closing)
+	p.warm.closeAll()
 
 	log.Infof("martian: waiting for connections to close")
 	p.connsMu.Lock()
@@ -277,6 +503,8 @@ func (p *Proxy) Serve(l net.Listener) error {
 }
 
 func (p *Proxy) handleLoop(conn net.Conn) {
+	conn = p.trackConn(conn)
+
 	p.connsMu.Lock()
 	p.conns.Add(1)
 	p.connsMu.Unlock()
@@ -286,24 +514,38 @@ func (p *Proxy) handleLoop(conn net.Conn) {
 		return
 	}
 
+	rsize := 4096
+	if p.StrictParsing || p.PreserveHeaderOrder {
+		rsize = strictHeaderBytes
+	}
+
 	var (
-		brw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+		brw = bufio.NewReadWriter(bufio.NewReaderSize(conn, rsize), bufio.NewWriter(conn))
 		s   = newSession(conn, brw)
 		ctx = withSession(s)
 	)
+	defer s.finishWire()
+	oconn, closed := p.observeConn(conn)
+	defer closed()
+	if wconn := p.captureWire(s, WireClient, false, oconn); wconn != conn {
+		conn = wconn
+		s.setConn(conn, brw)
+		brw.Writer.Reset(conn)
+	}
+	brw.Reader.Reset(s.limitHeader(conn))
 
 	const maxConsecutiveErrors = 5
 	errors := 0
 	for {
 		if err := p.handle(ctx, conn, brw); err != nil {
 			if isCloseable(err) {
-				log.Debugf("martian: closing connection: %v", conn.RemoteAddr())
+				ctx.logger().Debugf("martian: closing connection: %v", conn.RemoteAddr())
 				return
 			}
 
 			errors++
 			if errors >= maxConsecutiveErrors {
-				log.Errorf("martian: closing connection after %d consecutive errors: %v", errors, err)
+				ctx.logger().Errorf("martian: closing connection after %d consecutive errors: %v", errors, err)
 				return
 			}
 		} else {
@@ -311,7 +553,7 @@ func (p *Proxy) handleLoop(conn net.Conn) {
 		}
 
 		if s.Hijacked() {
-			log.Debugf("martian: closing connection: %v", conn.RemoteAddr())
+			ctx.logger().Debugf("martian: closing connection: %v", conn.RemoteAddr())
 			return
 		}
 	}
@@ -338,15 +580,58 @@ func (p *Proxy) readRequest(ctx *Context, conn net.Conn, brw *bufio.ReadWriter)
 	}
 
 	if deadlineErr := conn.SetReadDeadline(hdrDeadline); deadlineErr != nil {
-		log.Errorf("martian: can't set read header deadline: %v", deadlineErr)
+		ctx.logger().Errorf("martian: can't set read header deadline: %v", deadlineErr)
+	}
+
+	// Like http.Server, allows for the read buffer on top of the limit.
+	hl := ctx.Session().headerLimit
+	if hl != nil {
+		hl.setLimit(p.maxHeaderBytes() + int64(brw.Reader.Size()))
 	}
 
-	req, err = http.ReadRequest(brw.Reader)
+	var order []string
+	if p.StrictParsing || p.PreserveHeaderOrder {
+		raw, perr := peekHeader(brw.Reader)
+		if perr == nil && p.PreserveHeaderOrder {
+			order = parseHeaderOrder(raw)
+		}
+		if p.StrictParsing {
+			if err = perr; err == nil {
+				err = checkHeader(raw)
+			}
+		}
+		switch {
+		case err == errHeaderTooLarge:
+			ctx.logger().Errorf("martian: rejecting request: %v", err)
+			return nil, rejectRequest(brw, http.StatusRequestHeaderFieldsTooLarge)
+		case err != nil && !isCloseable(err) && err != io.ErrUnexpectedEOF:
+			ctx.logger().Errorf("martian: rejecting request: %v", err)
+			return nil, rejectRequest(brw, http.StatusBadRequest)
+		}
+	}
+
+	if err == nil {
+		req, err = http.ReadRequest(brw.Reader)
+	}
+	if hl != nil {
+		hit := hl.hit
+		hl.setLimit(-1)
+		if hit {
+			ctx.logger().Errorf("martian: rejecting request: header exceeds %d bytes", p.maxHeaderBytes())
+			return nil, rejectRequest(brw, http.StatusRequestHeaderFieldsTooLarge)
+		}
+	}
+	if err == nil {
+		if cerr := p.checkHeaderCount(req); cerr != nil {
+			ctx.logger().Errorf("martian: rejecting request: %v", cerr)
+			return nil, rejectRequest(brw, http.StatusRequestHeaderFieldsTooLarge)
+		}
+	}
 	if err != nil {
 		if isCloseable(err) {
-			log.Debugf("martian: connection closed prematurely: %v", err)
+			ctx.logger().Debugf("martian: connection closed prematurely: %v", err)
 		} else {
-			log.Errorf("martian: failed to read request: %v", err)
+			ctx.logger().Errorf("martian: failed to read request: %v", err)
 		}
 		if cw, ok := asCloseWriter(conn); ok {
 			cw.CloseWrite()
@@ -359,12 +644,25 @@ func (p *Proxy) readRequest(ctx *Context, conn net.Conn, brw *bufio.ReadWriter)
 		}
 
 		req = req.WithContext(ctx.addToContext(req.Context()))
+		if order != nil {
+			req = req.WithContext(context.WithValue(req.Context(), headerOrderKey{}, order))
+		}
+	}
+
+	if err == nil && p.StrictParsing {
+		if nerr := normalizeChunked(req, brw.Reader, p.maxBufferedBodyBytes()); nerr != nil {
+			ctx.logger().Errorf("martian: rejecting request: %v", nerr)
+			if nerr == errBodyTooLarge {
+				return nil, rejectRequest(brw, http.StatusRequestEntityTooLarge)
+			}
+			return nil, rejectRequest(brw, http.StatusBadRequest)
+		}
 	}
 
 	// Adjust the read deadline if necessary.
 	if !hdrDeadline.Equal(wholeReqDeadline) {
 		if deadlineErr := conn.SetReadDeadline(wholeReqDeadline); deadlineErr != nil {
-			log.Errorf("martian: can't set read deadline: %v", deadlineErr)
+			ctx.logger().Errorf("martian: can't set read deadline: %v", deadlineErr)
 		}
 	}
 
@@ -372,41 +670,70 @@ func (p *Proxy) readRequest(ctx *Context, conn net.Conn, brw *bufio.ReadWriter)
 }
 
 func (p *Proxy) handleConnectRequest(ctx *Context, req *http.Request, session *Session, brw *bufio.ReadWriter, conn net.Conn) error {
+	var abort error
 	if err := p.reqmod.ModifyRequest(req); err != nil {
-		log.Errorf("martian: error modifying CONNECT request: %v", err)
+		ctx.logger().Errorf("martian: error modifying CONNECT request: %v", err)
+		p.observeModifierError(req, err)
 		p.warning(req.Header, err)
+		if IsAbort(err) {
+			abort = err
+		}
 	}
 	if session.Hijacked() {
-		log.Debugf("martian: connection hijacked by request modifier")
+		ctx.logger().Debugf("martian: connection hijacked by request modifier")
 		return nil
 	}
 
-	if p.mitm != nil {
-		log.Debugf("martian: attempting MITM for connection: %s / %s", req.Host, req.URL.String())
+	if mc := p.mitmConfig(); mc != nil && abort == nil {
+		ctx.logger().Debugf("martian: attempting MITM for connection: %s / %s", req.Host, req.URL.String())
+		session.SetConnectHost(req.Host)
 
 		res := proxyutil.NewResponse(200, nil, req)
 
 		if err := p.resmod.ModifyResponse(res); err != nil {
-			log.Errorf("martian: error modifying CONNECT response: %v", err)
+			ctx.logger().Errorf("martian: error modifying CONNECT response: %v", err)
+			p.observeModifierError(req, err)
 			p.warning(res.Header, err)
+			if IsAbort(err) {
+				abort = err
+				res = p.errorResponse(req, err)
+				p.warning(res.Header, err)
+				defer res.Body.Close()
+			}
 		}
 		if session.Hijacked() {
-			log.Debugf("martian: connection hijacked by response modifier")
+			ctx.logger().Debugf("martian: connection hijacked by response modifier")
 			return nil
 		}
 
 		if err := res.Write(brw); err != nil {
-			log.Errorf("martian: got error while writing response back to client: %v", err)
+			ctx.logger().Errorf("martian: got error while writing response back to client: %v", err)
 		}
 		if err := brw.Flush(); err != nil {
-			log.Errorf("martian: got error while flushing response back to client: %v", err)
+			ctx.logger().Errorf("martian: got error while flushing response back to client: %v", err)
+		}
+		if abort != nil {
+			return errClose
 		}
 
-		log.Debugf("martian: completed MITM for connection: %s", req.Host)
+		ctx.logger().Debugf("martian: completed MITM for connection: %s", req.Host)
+
+		if peekECH(brw.Reader) {
+			switch mc.ECHPolicy() {
+			case mitm.ECHPassthrough:
+				ctx.logger().Infof("martian: ClientHello for %s offers ECH, passing connection through", req.Host)
+				return p.passthrough(ctx, req, brw, conn)
+			case mitm.ECHReject:
+				ctx.logger().Infof("martian: ClientHello for %s offers ECH, closing connection", req.Host)
+				return errClose
+			default:
+				ctx.logger().Debugf("martian: ClientHello for %s offers ECH, MITMing it like any other", req.Host)
+			}
+		}
 
 		b := make([]byte, 1)
 		if _, err := brw.Read(b); err != nil {
-			log.Errorf("martian: error peeking message through CONNECT tunnel to determine type: %v", err)
+			ctx.logger().Errorf("martian: error peeking message through CONNECT tunnel to determine type: %v", err)
 		}
 
 		// Drain all of the rest of the buffered data.
@@ -418,41 +745,45 @@ func (p *Proxy) handleConnectRequest(ctx *Context, req *http.Request, session *S
 		if b[0] == 22 {
 			// Prepend the previously read data to be read again by
 			// http.ReadRequest.
-			tlsconn := tls.Server(&peekedConn{conn, io.MultiReader(bytes.NewReader(b), bytes.NewReader(buf), conn)}, p.mitm.TLSForHost(req.Host))
+			tlsconn := tls.Server(&peekedConn{conn, io.MultiReader(bytes.NewReader(b), bytes.NewReader(buf), conn)}, mc.TLSForHost(req.Host))
 
-			if err := tlsconn.Handshake(); err != nil {
-				p.mitm.HandshakeErrorCallback(req, err)
+			err := tlsconn.HandshakeContext(req.Context())
+			p.observeHandshake(err)
+			if err != nil {
+				mc.HandshakeErrorCallback(req, err)
 				return err
 			}
 			if tlsconn.ConnectionState().NegotiatedProtocol == "h2" {
-				return p.mitm.H2Config().Proxy(p.closing, tlsconn, req.URL)
+				return mc.H2Config().ProxyWithDialer(p.closing, tlsconn, req.URL, p.connectDialer(req), p.upstreamTLSConfig(req.URL.Hostname()))
 			}
 
 			var nconn net.Conn
-			nconn = tlsconn
+			nconn = p.captureWire(session, WireClient, true, tlsconn)
 			// If the original connection is a traffic shaped connection, wrap the tls
 			// connection inside a traffic shaped connection too.
 			if ptsconn, ok := conn.(*trafficshape.Conn); ok {
 				nconn = ptsconn.Listener.GetTrafficShapedConn(tlsconn)
 			}
 			brw.Writer.Reset(nconn)
-			brw.Reader.Reset(nconn)
+			brw.Reader.Reset(session.limitHeader(nconn))
 			return p.handle(ctx, nconn, brw)
 		}
 
 		// Prepend the previously read data to be read again by http.ReadRequest.
-		brw.Reader.Reset(io.MultiReader(bytes.NewReader(b), bytes.NewReader(buf), conn))
+		brw.Reader.Reset(session.limitHeader(io.MultiReader(bytes.NewReader(b), bytes.NewReader(buf), conn)))
 		return p.handle(ctx, conn, brw)
 	}
 
-	log.Debugf("martian: attempting to establish CONNECT tunnel: %s", req.URL.Host)
+	ctx.logger().Debugf("martian: attempting to establish CONNECT tunnel: %s", req.URL.Host)
 	var (
 		res  *http.Response
 		cr   io.Reader
 		cw   io.WriteCloser
 		cerr error
 	)
-	if p.ConnectPassthrough {
+	if abort != nil {
+		cerr = abort
+	} else if p.ConnectPassthrough {
 		pr, pw := io.Pipe()
 		req.Body = pr
 		defer req.Body.Close()
@@ -479,81 +810,128 @@ func (p *Proxy) handleConnectRequest(ctx *Context, req *http.Request, session *S
 	}
 
 	if cerr != nil {
-		log.Errorf("martian: failed to CONNECT: %v", cerr)
+		ctx.logger().Errorf("martian: failed to CONNECT: %v", cerr)
 		res = p.errorResponse(req, cerr)
 		p.warning(res.Header, cerr)
 	}
 	defer res.Body.Close()
 
 	if err := p.resmod.ModifyResponse(res); err != nil {
-		log.Errorf("martian: error modifying CONNECT response: %v", err)
+		ctx.logger().Errorf("martian: error modifying CONNECT response: %v", err)
+		p.observeModifierError(req, err)
 		p.warning(res.Header, err)
+		if IsAbort(err) {
+			res = p.errorResponse(req, err)
+			p.warning(res.Header, err)
+			defer res.Body.Close()
+		}
 	}
 	if session.Hijacked() {
-		log.Debugf("martian: connection hijacked by response modifier")
+		ctx.logger().Debugf("martian: connection hijacked by response modifier")
 		return nil
 	}
 
 	if res.StatusCode != 200 {
 		if cerr == nil {
-			log.Errorf("martian: CONNECT rejected with status code: %d", res.StatusCode)
+			ctx.logger().Errorf("martian: CONNECT rejected with status code: %d", res.StatusCode)
 		}
 		if err := res.Write(brw); err != nil {
-			log.Errorf("martian: got error while writing response back to client: %v", err)
+			ctx.logger().Errorf("martian: got error while writing response back to client: %v", err)
 		}
 		err := brw.Flush()
 		if err != nil {
-			log.Errorf("martian: got error while flushing response back to client: %v", err)
+			ctx.logger().Errorf("martian: got error while flushing response back to client: %v", err)
 		}
 		return err
 	}
 
 	res.ContentLength = -1
 
-	if err := p.tunnel("CONNECT", res, brw, conn, cw, cr); err != nil {
-		log.Errorf("martian: CONNECT tunnel: %w", err)
+	if err := p.tunnel(ctx, "CONNECT", res, brw, conn, cw, cr); err != nil {
+		ctx.logger().Errorf("martian: CONNECT tunnel: %v", err)
 	}
 
 	return errClose
 }
 
-func (p *Proxy) handleUpgradeResponse(res *http.Response, brw *bufio.ReadWriter, conn net.Conn) error {
+func (p *Proxy) handleUpgradeResponse(ctx *Context, res *http.Response, brw *bufio.ReadWriter, conn net.Conn) error {
 	resUpType := upgradeType(res.Header)
 
 	uconn, ok := res.Body.(io.ReadWriteCloser)
 	if !ok {
-		log.Errorf("martian: internal error: switching protocols response with non-writable body")
+		ctx.logger().Errorf("martian: internal error: switching protocols response with non-writable body")
 		return errClose
 	}
 
 	res.Body = nil
 
-	if err := p.tunnel(resUpType, res, brw, conn, uconn, uconn); err != nil {
-		log.Errorf("martian: %s tunnel: %w", resUpType, err)
+	if err := p.tunnel(ctx, resUpType, res, brw, conn, uconn, uconn); err != nil {
+		ctx.logger().Errorf("martian: %s tunnel: %v", resUpType, err)
 	}
 
 	return errClose
 }
 
-func (p *Proxy) tunnel(name string, res *http.Response, brw *bufio.ReadWriter, conn net.Conn, cw io.Writer, cr io.Reader) error {
+// peekECH reports whether the next TLS record of r is a ClientHello offering
+// Encrypted Client Hello, without consuming it.
+func peekECH(r *bufio.Reader) bool {
+	hdr, err := r.Peek(5)
+	if err != nil || hdr[0] != 22 {
+		return false
+	}
+
+	// ClientHellos larger than the buffer are not inspected.
+	rec, err := r.Peek(5 + int(binary.BigEndian.Uint16(hdr[3:5])))
+	if err != nil {
+		return false
+	}
+
+	return mitm.HasECH(rec)
+}
+
+// passthrough tunnels the connection of a CONNECT request, for which the
+// response has already been written, to its destination.
+func (p *Proxy) passthrough(ctx *Context, req *http.Request, brw *bufio.ReadWriter, conn net.Conn) error {
+	cconn, err := p.connectDialer(req)(req.Context(), "tcp", req.URL.Host)
+	if err != nil {
+		ctx.logger().Errorf("martian: failed to CONNECT: %v", err)
+		return errClose
+	}
+	defer cconn.Close()
+
+	if err := p.splice(ctx, "CONNECT", brw, conn, cconn, cconn); err != nil {
+		ctx.logger().Errorf("martian: CONNECT tunnel: %v", err)
+	}
+
+	return errClose
+}
+
+func (p *Proxy) tunnel(ctx *Context, name string, res *http.Response, brw *bufio.ReadWriter, conn net.Conn, cw io.Writer, cr io.Reader) error {
 	if err := res.Write(brw); err != nil {
 		return fmt.Errorf("got error while writing response back to client: %w", err)
 	}
 	if err := brw.Flush(); err != nil {
 		return fmt.Errorf("got error while flushing response back to client: %w", err)
 	}
+
+	return p.splice(ctx, name, brw, conn, cw, cr)
+}
+
+// splice copies data between conn and cw/cr until both directions are done,
+// starting with the data buffered in brw.
+func (p *Proxy) splice(ctx *Context, name string, brw *bufio.ReadWriter, conn net.Conn, cw io.Writer, cr io.Reader) error {
 	if err := drainBuffer(cw, brw.Reader); err != nil {
 		return fmt.Errorf("got error while draining read buffer: %w", err)
 	}
 
 	donec := make(chan bool, 2)
-	go copySync("outbound "+name, cw, conn, donec)
-	go copySync("inbound "+name, conn, cr, donec)
+	go copySync(ctx.logger(), "outbound "+name, cw, conn, donec)
+	go copySync(ctx.logger(), "inbound "+name, conn, cr, donec)
 
-	log.Debugf("martian: switched protocols, proxying %s traffic", name)
+	ctx.logger().Debugf("martian: switched protocols, proxying %s traffic", name)
 	<-donec
 	<-donec
-	log.Debugf("martian: closed %s tunnel", name)
+	ctx.logger().Debugf("martian: closed %s tunnel", name)
 
 	return nil
 }
@@ -576,28 +954,30 @@ var copyBufPool = sync.Pool{
 	},
 }
 
-func copySync(name string, w io.Writer, r io.Reader, donec chan<- bool) {
+// copySync copies r to w, closing the write side of w when done, and logs to
+// lg.
+func copySync(lg log.Logger, name string, w io.Writer, r io.Reader, donec chan<- bool) {
 	bufp := copyBufPool.Get().(*[]byte)
 	buf := *bufp
 	defer copyBufPool.Put(bufp)
 
 	if _, err := io.CopyBuffer(w, r, buf); err != nil && err != io.EOF {
-		log.Errorf("martian: failed to copy %s tunnel: %v", name, err)
+		lg.Errorf("martian: failed to copy %s tunnel: %v", name, err)
 	}
 	if cw, ok := asCloseWriter(w); ok {
 		cw.CloseWrite()
 	} else if pw, ok := w.(*io.PipeWriter); ok {
 		pw.Close()
 	} else {
-		log.Errorf("martian: cannot close write side of %s tunnel (%T)", name, w)
+		lg.Errorf("martian: cannot close write side of %s tunnel (%T)", name, w)
 	}
 
-	log.Debugf("martian: %s tunnel finished copying", name)
+	lg.Debugf("martian: %s tunnel finished copying", name)
 	donec <- true
 }
 
 func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error {
-	log.Debugf("martian: waiting for request: %v", conn.RemoteAddr())
+	ctx.logger().Debugf("martian: waiting for request: %v", conn.RemoteAddr())
 
 	session := ctx.Session()
 	ctx = withSession(session)
@@ -608,21 +988,12 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 	}
 	defer req.Body.Close()
 
-	if tsconn, ok := conn.(*trafficshape.Conn); ok {
-		wrconn := tsconn.GetWrappedConn()
-		if sconn, ok := wrconn.(*tls.Conn); ok {
-			session.MarkSecure()
-
-			cs := sconn.ConnectionState()
-			req.TLS = &cs
-		}
-	}
-
-	if tconn, ok := conn.(*tls.Conn); ok {
+	if tconn, ok := tlsConn(conn); ok {
 		session.MarkSecure()
 
 		cs := tconn.ConnectionState()
 		req.TLS = &cs
+		setClientCertificate(session, &cs)
 	}
 
 	req.RemoteAddr = conn.RemoteAddr().String()
@@ -634,6 +1005,19 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 		return p.handleConnectRequest(ctx, req, session, brw, conn)
 	}
 
+	if err := p.expectContinue(req, func() error {
+		if err := writeContinue(brw); err != nil {
+			return err
+		}
+		return brw.Flush()
+	}); err != nil {
+		ctx.logger().Errorf("martian: %v", err)
+		if err == errBodyTooLarge {
+			return rejectRequest(brw, http.StatusRequestEntityTooLarge)
+		}
+		return errClose
+	}
+
 	if req.URL.Scheme == "" {
 		req.URL.Scheme = "http"
 		if session.IsSecure() {
@@ -641,21 +1025,27 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 		}
 	} else if req.URL.Scheme == "http" {
 		if session.IsSecure() && !p.AllowHTTP {
-			log.Infof("martian: forcing HTTPS inside secure session")
+			ctx.logger().Infof("martian: forcing HTTPS inside secure session")
 			req.URL.Scheme = "https"
 		}
 	}
 
 	reqUpType := upgradeType(req.Header)
 	if reqUpType != "" {
-		log.Debugf("martian: upgrade request: %s", reqUpType)
+		ctx.logger().Debugf("martian: upgrade request: %s", reqUpType)
 	}
+	var res *http.Response
 	if err := p.reqmod.ModifyRequest(req); err != nil {
-		log.Errorf("martian: error modifying request: %v", err)
+		ctx.logger().Errorf("martian: error modifying request: %v", err)
+		p.observeModifierError(req, err)
 		p.warning(req.Header, err)
+		if IsAbort(err) {
+			res = p.errorResponse(req, err)
+			p.warning(res.Header, err)
+		}
 	}
 	if session.Hijacked() {
-		log.Debugf("martian: connection hijacked by request modifier")
+		ctx.logger().Debugf("martian: connection hijacked by request modifier")
 		return nil
 	}
 
@@ -667,11 +1057,17 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 	}
 
 	// perform the HTTP roundtrip
-	res, err := p.roundTrip(ctx, req)
-	if err != nil {
-		log.Errorf("martian: failed to round trip: %v", err)
-		res = p.errorResponse(req, err)
-		p.warning(res.Header, err)
+	if res == nil {
+		var err error
+		res, err = p.roundTrip(ctx, req)
+		if err == nil {
+			res, err = p.followRedirects(ctx, req, res)
+		}
+		if err != nil {
+			ctx.logger().Errorf("martian: failed to round trip: %v", err)
+			res = p.errorResponse(req, err)
+			p.warning(res.Header, err)
+		}
 	}
 	defer res.Body.Close()
 
@@ -681,14 +1077,21 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 
 	resUpType := upgradeType(res.Header)
 	if resUpType != "" {
-		log.Debugf("martian: upgrade response: %s", resUpType)
+		ctx.logger().Debugf("martian: upgrade response: %s", resUpType)
 	}
 	if err := p.resmod.ModifyResponse(res); err != nil {
-		log.Errorf("martian: error modifying response: %v", err)
+		ctx.logger().Errorf("martian: error modifying response: %v", err)
+		p.observeModifierError(req, err)
 		p.warning(res.Header, err)
+		if IsAbort(err) {
+			res = p.errorResponse(req, err)
+			p.warning(res.Header, err)
+			defer res.Body.Close()
+			resUpType = ""
+		}
 	}
 	if session.Hijacked() {
-		log.Debugf("martian: connection hijacked by response modifier")
+		ctx.logger().Debugf("martian: connection hijacked by response modifier")
 		return nil
 	}
 
@@ -701,7 +1104,7 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 
 	var closing error
 	if !req.ProtoAtLeast(1, 1) || req.Close || res.Close || p.Closing() {
-		log.Debugf("martian: received close request: %v", req.RemoteAddr)
+		ctx.logger().Debugf("martian: received close request: %v", req.RemoteAddr)
 		res.Close = true
 		closing = errClose
 	}
@@ -736,7 +1139,7 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 						ptsconn.Context.Buckets.WriteBucket.SetCapacity(
 							ptsconn.Context.ThrottleContext.Bandwidth)
 					}
-					log.Infof(
+					ctx.logger().Infof(
 						"trafficshape: Request %s with Range Start: %d matches a Shaping request %s. Enforcing Traffic shaping.",
 						req.URL, rangeStart, urlregex)
 				}
@@ -747,12 +1150,12 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 
 	// deal with 101 Switching Protocols responses: (WebSocket, h2c, etc)
 	if res.StatusCode == 101 {
-		return p.handleUpgradeResponse(res, brw, conn)
+		return p.handleUpgradeResponse(ctx, res, brw, conn)
 	}
 
 	if p.WriteTimeout > 0 {
 		if deadlineErr := conn.SetWriteDeadline(time.Now().Add(p.WriteTimeout)); deadlineErr != nil {
-			log.Errorf("martian: can't set write deadline: %v", deadlineErr)
+			ctx.logger().Errorf("martian: can't set write deadline: %v", deadlineErr)
 		}
 	}
 
@@ -765,7 +1168,7 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 		err = res.Write(brw)
 	}
 	if err != nil {
-		log.Errorf("martian: got error while writing response back to client: %v", err)
+		ctx.logger().Errorf("martian: got error while writing response back to client: %v", err)
 		if _, ok := err.(*trafficshape.ErrForceClose); ok {
 			closing = errClose
 		}
@@ -775,7 +1178,7 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 	}
 	err = brw.Flush()
 	if err != nil {
-		log.Errorf("martian: got error while flushing response back to client: %v", err)
+		ctx.logger().Errorf("martian: got error while flushing response back to client: %v", err)
 		if _, ok := err.(*trafficshape.ErrForceClose); ok {
 			closing = errClose
 		}
@@ -787,6 +1190,35 @@ func (p *Proxy) handle(ctx *Context, conn net.Conn, brw *bufio.ReadWriter) error
 	return closing
 }
 
+// setClientCertificate sets the verified client certificate of a TLS
+// connection on the session.
+// tlsConn returns the TLS connection of the client wrapped by conn, such as
+// by traffic shaping, conn tracking, the observer or wire capture, if any.
+func tlsConn(conn net.Conn) (*tls.Conn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			return c, true
+		case *trafficshape.Conn:
+			conn = c.GetWrappedConn()
+		case *connmetric.InstrumentedConn:
+			conn = c.Conn
+		case *observedConn:
+			conn = c.Conn
+		case *wireConn:
+			conn = c.Conn
+		default:
+			return nil, false
+		}
+	}
+}
+
+func setClientCertificate(session *Session, cs *tls.ConnectionState) {
+	if len(cs.VerifiedChains) > 0 && session.ClientCertificate() == nil {
+		session.SetClientCertificate(cs.PeerCertificates[0])
+	}
+}
+
 // A peekedConn subverts the net.Conn.Read implementation, primarily so that
 // sniffed bytes can be transparently prepended.
 type peekedConn struct {
@@ -799,13 +1231,52 @@ type peekedConn struct {
 // be read again.
 func (c *peekedConn) Read(buf []byte) (int, error) { return c.r.Read(buf) }
 
-func (p *Proxy) roundTrip(ctx *Context, req *http.Request) (*http.Response, error) {
+func (p *Proxy) roundTrip(ctx *Context, req *http.Request) (res *http.Response, err error) {
 	if ctx.SkippingRoundTrip() {
-		log.Debugf("martian: skipping round trip")
+		ctx.logger().Debugf("martian: skipping round trip")
 		return proxyutil.NewResponse(200, nil, req), nil
 	}
 
-	return p.roundTripper.RoundTrip(req)
+	if o := p.Observer; o != nil {
+		start := time.Now()
+		defer func() {
+			o.RoundTrip(req, res, time.Since(start), err)
+		}()
+	}
+
+	tt := newTimingTrace()
+	defer func() {
+		ctx.Set(RoundTripTimingKey, tt.done())
+	}()
+
+	creq := req.WithContext(tt.withTrace(req.Context()))
+	defer func() {
+		if res != nil {
+			res.Request = req
+		}
+	}()
+
+	if p.PreserveHeaderOrder {
+		if res, ok, err := p.orderedRoundTrip(creq); ok {
+			return res, err
+		}
+	}
+
+	if req.URL.Scheme == "https" && p.hasClientCertificates() {
+		creq = creq.WithContext(context.WithValue(creq.Context(), upstreamHostKey{}, req.URL.Hostname()))
+	}
+	if p.connTracker != nil {
+		creq = creq.WithContext(connmetric.WithTLSTrace(creq.Context()))
+	}
+
+	return p.roundTripper.RoundTrip(creq)
+}
+
+func (p *Proxy) hasClientCertificates() bool {
+	p.certsMu.RLock()
+	defer p.certsMu.RUnlock()
+
+	return len(p.clientCerts) > 0
 }
 
 func (p *Proxy) warning(h http.Header, err error) {
@@ -853,6 +1324,40 @@ func (p *Proxy) connect(req *http.Request) (*http.Response, net.Conn, error) {
 	}
 }
 
+// connectDialer returns a dial function that establishes a connection to the
+// target of the CONNECT request req, honoring the upstream proxy.
+func (p *Proxy) connectDialer(req *http.Request) func(context.Context, string, string) (net.Conn, error) {
+	return func(context.Context, string, string) (net.Conn, error) {
+		res, conn, err := p.connect(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != 200 {
+			res.Body.Close()
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, fmt.Errorf("martian: CONNECT rejected with status code: %d", res.StatusCode)
+		}
+
+		return conn, nil
+	}
+}
+
+// DialTunnel connects to addr like to the target of a CONNECT request, with
+// the dial func of the proxy and through the upstream proxy, if any. It can
+// be used with mitm.NewOriginCertificateFetcher.
+func (p *Proxy) DialTunnel(ctx context.Context, network, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+
+	return p.connectDialer(req.WithContext(ctx))(ctx, network, addr)
+}
+
 func (p *Proxy) connectHTTP(req *http.Request, proxyURL *url.URL) (res *http.Response, conn net.Conn, err error) {
 	log.Debugf("martian: CONNECT with upstream HTTP proxy: %s", proxyURL.Host)
 
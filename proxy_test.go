@@ -17,6 +17,7 @@ package martian
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -26,16 +27,20 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/martian/v3/h2"
 	"github.com/google/martian/v3/log"
 	"github.com/google/martian/v3/martiantest"
 	"github.com/google/martian/v3/mitm"
 	"github.com/google/martian/v3/proxyutil"
+	"golang.org/x/net/http2"
 )
 
 type tempError struct{}
@@ -358,6 +363,473 @@ func TestIntegrationHTTP100Continue(t *testing.T) {
 	}
 }
 
+func TestIntegrationExpectContinueModes(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	for _, mode := range []ExpectContinueMode{ExpectContinueImmediate, ExpectContinueBuffer} {
+		mode := mode
+		t.Run(mode.String(), func(t *testing.T) {
+			t.Parallel()
+
+			l := newListener(t)
+			p := NewProxy()
+			defer p.Close()
+
+			p.ExpectContinue = mode
+			p.SetTimeout(2 * time.Second)
+
+			type upstream struct {
+				expect string
+				body   string
+			}
+			upc := make(chan upstream, 1)
+			tr := martiantest.NewTransport()
+			tr.Func(func(req *http.Request) (*http.Response, error) {
+				body, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				upc <- upstream{req.Header.Get("Expect"), string(body)}
+				return proxyutil.NewResponse(200, nil, req), nil
+			})
+			p.SetRoundTripper(tr)
+
+			go serve(p, l)
+
+			conn, err := l.dial()
+			if err != nil {
+				t.Fatalf("net.Dial(): got %v, want no error", err)
+			}
+			defer conn.Close()
+
+			raw := "POST http://example.com/ HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Content-Length: 12\r\n" +
+				"Expect: 100-continue\r\n\r\n"
+			if _, err := conn.Write([]byte(raw)); err != nil {
+				t.Fatalf("conn.Write(headers): got %v, want no error", err)
+			}
+
+			// The proxy answers before the body is sent.
+			br := bufio.NewReader(conn)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			res, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+			}
+			if got, want := res.StatusCode, 100; got != want {
+				t.Fatalf("res.StatusCode: got %d, want %d", got, want)
+			}
+			conn.SetReadDeadline(time.Time{})
+
+			if _, err := conn.Write([]byte("body content")); err != nil {
+				t.Fatalf("conn.Write(body): got %v, want no error", err)
+			}
+
+			res, err = http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+			}
+			defer res.Body.Close()
+
+			if got, want := res.StatusCode, 200; got != want {
+				t.Errorf("res.StatusCode: got %d, want %d", got, want)
+			}
+
+			up := <-upc
+			if got, want := up.expect, ""; got != want {
+				t.Errorf("upstream Expect: got %q, want %q", got, want)
+			}
+			if got, want := up.body, "body content"; got != want {
+				t.Errorf("upstream body: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIntegrationExpectContinueBufferTooLarge(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	tests := []struct {
+		name         string
+		raw          string
+		wantContinue bool
+	}{
+		{
+			name: "content length",
+			raw: "POST http://example.com/ HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Content-Length: 12\r\n" +
+				"Expect: 100-continue\r\n\r\n",
+		},
+		{
+			name: "chunked",
+			raw: "POST http://example.com/ HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"Expect: 100-continue\r\n\r\n",
+			wantContinue: true,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newListener(t)
+			p := NewProxy()
+			defer p.Close()
+
+			p.ExpectContinue = ExpectContinueBuffer
+			p.MaxBufferedBodyBytes = 8
+			p.SetTimeout(2 * time.Second)
+
+			forwarded := make(chan bool, 1)
+			tr := martiantest.NewTransport()
+			tr.Func(func(req *http.Request) (*http.Response, error) {
+				forwarded <- true
+				return proxyutil.NewResponse(200, nil, req), nil
+			})
+			p.SetRoundTripper(tr)
+
+			go serve(p, l)
+
+			conn, err := l.dial()
+			if err != nil {
+				t.Fatalf("net.Dial(): got %v, want no error", err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte(tc.raw)); err != nil {
+				t.Fatalf("conn.Write(headers): got %v, want no error", err)
+			}
+
+			br := bufio.NewReader(conn)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			res, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+			}
+			if tc.wantContinue {
+				if got, want := res.StatusCode, 100; got != want {
+					t.Fatalf("res.StatusCode: got %d, want %d", got, want)
+				}
+				if _, err := conn.Write([]byte("c\r\nbody content\r\n0\r\n\r\n")); err != nil {
+					t.Fatalf("conn.Write(body): got %v, want no error", err)
+				}
+				if res, err = http.ReadResponse(br, nil); err != nil {
+					t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+				}
+			}
+			defer res.Body.Close()
+
+			if got, want := res.StatusCode, 413; got != want {
+				t.Errorf("res.StatusCode: got %d, want %d", got, want)
+			}
+			if len(forwarded) != 0 {
+				t.Error("request forwarded, want rejected")
+			}
+		})
+	}
+}
+
+func TestParseExpectContinueMode(t *testing.T) {
+	for _, want := range []ExpectContinueMode{ExpectContinueRelay, ExpectContinueImmediate, ExpectContinueBuffer} {
+		got, err := ParseExpectContinueMode(want.String())
+		if err != nil {
+			t.Fatalf("ParseExpectContinueMode(%q): got %v, want no error", want, err)
+		}
+		if got != want {
+			t.Errorf("ParseExpectContinueMode(%q): got %v, want %v", want, got, want)
+		}
+	}
+
+	if _, err := ParseExpectContinueMode("unknown"); err == nil {
+		t.Errorf("ParseExpectContinueMode(%q): got nil error, want error", "unknown")
+	}
+}
+
+func TestIntegrationStrictParsing(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	tests := []struct {
+		name       string
+		raw        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "smuggling",
+			raw: "POST http://example.com/ HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Content-Length: 4\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"0\r\n\r\n",
+			wantStatus: 400,
+		},
+		{
+			name: "chunked",
+			raw: "POST http://example.com/ HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"5;ext=1\r\nhello\r\n0\r\n\r\n",
+			wantStatus: 200,
+			wantBody:   "hello",
+		},
+		{
+			name: "invalid chunk extension",
+			raw: "POST http://example.com/ HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"5;\"ext\r\nhello\r\n0\r\n\r\n",
+			wantStatus: 400,
+		},
+		{
+			name: "chunked body too large",
+			raw: "POST http://example.com/ HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n10\r\n" + strings.Repeat("a", 16) + "\r\n0\r\n\r\n",
+			wantStatus: 413,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newListener(t)
+			p := NewProxy()
+			defer p.Close()
+
+			p.StrictParsing = true
+			p.MaxBufferedBodyBytes = 16
+			p.SetTimeout(2 * time.Second)
+
+			type upstream struct {
+				contentLength    int64
+				transferEncoding []string
+				body             string
+			}
+			upc := make(chan upstream, 1)
+			tr := martiantest.NewTransport()
+			tr.Func(func(req *http.Request) (*http.Response, error) {
+				body, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				upc <- upstream{req.ContentLength, req.TransferEncoding, string(body)}
+				return proxyutil.NewResponse(200, nil, req), nil
+			})
+			p.SetRoundTripper(tr)
+
+			go serve(p, l)
+
+			conn, err := l.dial()
+			if err != nil {
+				t.Fatalf("net.Dial(): got %v, want no error", err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte(tc.raw)); err != nil {
+				t.Fatalf("conn.Write(): got %v, want no error", err)
+			}
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+			}
+			defer res.Body.Close()
+
+			if got, want := res.StatusCode, tc.wantStatus; got != want {
+				t.Fatalf("res.StatusCode: got %d, want %d", got, want)
+			}
+			if tc.wantStatus != 200 {
+				if len(upc) != 0 {
+					t.Error("request forwarded, want rejected")
+				}
+				return
+			}
+
+			up := <-upc
+			if got, want := up.contentLength, int64(len(tc.wantBody)); got != want {
+				t.Errorf("upstream ContentLength: got %d, want %d", got, want)
+			}
+			if got := up.transferEncoding; len(got) != 0 {
+				t.Errorf("upstream TransferEncoding: got %v, want none", got)
+			}
+			if got, want := up.body, tc.wantBody; got != want {
+				t.Errorf("upstream body: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIntegrationHeaderLimits(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+	}{
+		{
+			name:       "within limits",
+			header:     "X-Test: 1\r\n",
+			wantStatus: 200,
+		},
+		{
+			name:       "too large",
+			header:     "X-Test: " + strings.Repeat("a", 8<<10) + "\r\n",
+			wantStatus: 431,
+		},
+		{
+			name:       "too many",
+			header:     strings.Repeat("X-Test: 1\r\n", 11),
+			wantStatus: 431,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newListener(t)
+			p := NewProxy()
+			defer p.Close()
+
+			p.MaxHeaderBytes = 1 << 10
+			p.MaxHeaderCount = 10
+			p.SetTimeout(2 * time.Second)
+
+			tr := martiantest.NewTransport()
+			p.SetRoundTripper(tr)
+
+			go serve(p, l)
+
+			conn, err := l.dial()
+			if err != nil {
+				t.Fatalf("net.Dial(): got %v, want no error", err)
+			}
+			defer conn.Close()
+
+			raw := "GET http://example.com/ HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				tc.header + "\r\n"
+			if _, err := conn.Write([]byte(raw)); err != nil {
+				t.Fatalf("conn.Write(): got %v, want no error", err)
+			}
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+			}
+			defer res.Body.Close()
+
+			if got, want := res.StatusCode, tc.wantStatus; got != want {
+				t.Errorf("res.StatusCode: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestIntegrationPreserveHeaderOrder(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	// The origin echoes the raw request header.
+	ol, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): got %v, want no error", err)
+	}
+	defer ol.Close()
+
+	go func() {
+		conn, err := ol.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		var header string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\r\n" {
+				break
+			}
+			header += line
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(header), header)
+	}()
+
+	l := newListener(t)
+	p := NewProxy()
+	defer p.Close()
+
+	p.PreserveHeaderOrder = true
+	p.SetTimeout(2 * time.Second)
+
+	go serve(p, l)
+
+	conn, err := l.dial()
+	if err != nil {
+		t.Fatalf("net.Dial(): got %v, want no error", err)
+	}
+	defer conn.Close()
+
+	host := ol.Addr().String()
+	fmt.Fprintf(conn, "GET http://%s/path HTTP/1.1\r\n"+
+		"x-second: 2\r\n"+
+		"HOST: %s\r\n"+
+		"X-FIRST: 1\r\n"+
+		"x-second: 3\r\n\r\n", host, host)
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+	}
+	defer res.Body.Close()
+
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	want := "GET /path HTTP/1.1\r\n" +
+		"x-second: 2\r\n" +
+		"HOST: " + host + "\r\n" +
+		"X-FIRST: 1\r\n" +
+		"x-second: 3\r\n"
+	if string(got) != want {
+		t.Errorf("origin request header: got %q, want %q", got, want)
+	}
+}
+
 func TestIntegrationHTTP101SwitchingProtocols(t *testing.T) {
 	t.Parallel()
 
@@ -903,6 +1375,49 @@ func TestIntegrationConnect(t *testing.T) {
 	}
 }
 
+func TestDialTunnelUpstreamProxy(t *testing.T) {
+	t.Parallel()
+
+	ul, err := net.Listen("tcp", "[::]:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): got %v, want no error", err)
+	}
+
+	upstream := NewProxy()
+	defer upstream.Close()
+
+	ca, priv, err := mitm.NewAuthority("martian.upstream", "Martian Authority", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+	mc, err := mitm.NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("mitm.NewConfig(): got %v, want no error", err)
+	}
+	upstream.SetMITM(mc)
+
+	go upstream.Serve(ul)
+
+	proxy := NewProxy()
+	defer proxy.Close()
+
+	proxy.SetUpstreamProxy(&url.URL{
+		Scheme: "http",
+		Host:   ul.Addr().String(),
+	})
+
+	// The certificate is fetched through the upstream proxy, which MITMs the
+	// connection.
+	f := mitm.NewOriginCertificateFetcher(proxy.DialTunnel)
+	cert, err := f.Fetch(context.Background(), "example.com:443", "example.com")
+	if err != nil {
+		t.Fatalf("f.Fetch(): got %v, want no error", err)
+	}
+	if got, want := cert.Issuer.CommonName, "martian.upstream"; got != want {
+		t.Errorf("cert.Issuer.CommonName: got %q, want %q", got, want)
+	}
+}
+
 func TestIntegrationConnectUpstreamProxy(t *testing.T) {
 	t.Parallel()
 
@@ -1091,6 +1606,7 @@ func TestIntegrationMITM(t *testing.T) {
 	tr.Func(func(req *http.Request) (*http.Response, error) {
 		res := proxyutil.NewResponse(200, nil, req)
 		res.Header.Set("Request-Scheme", req.URL.Scheme)
+		res.Header.Set("Connect-Host", NewContext(req).Session().ConnectHost())
 
 		return res, nil
 	})
@@ -1183,11 +1699,137 @@ func TestIntegrationMITM(t *testing.T) {
 	if got, want := res.Header.Get("Request-Scheme"), "https"; got != want {
 		t.Errorf("res.Header.Get(%q): got %q, want %q", "Request-Scheme", got, want)
 	}
+	if got, want := res.Header.Get("Connect-Host"), "example.com:443"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Connect-Host", got, want)
+	}
 	if got, want := res.Header.Get("Warning"), reserr.Error(); !strings.Contains(got, want) {
 		t.Errorf("res.Header.Get(%q): got %q, want to contain %q", "Warning", got, want)
 	}
 }
 
+// echClientHello returns a TLS record holding a minimal ClientHello that
+// offers Encrypted Client Hello.
+func echClientHello() []byte {
+	hs := []byte{0x03, 0x03}
+	hs = append(hs, make([]byte, 32)...)
+	hs = append(hs, 0, 0, 2, 0x13, 0x01, 1, 0)
+	hs = append(hs, 0, 5, 0xfe, 0x0d, 0, 1, 0)
+
+	msg := append([]byte{1, 0, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+
+	return append([]byte{22, 0x03, 0x01, byte(len(msg) >> 8), byte(len(msg))}, msg...)
+}
+
+func TestIntegrationMITMECH(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	ca, priv, err := mitm.NewAuthority("martian.proxy", "Martian Authority", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+
+	// Destination server that records the first bytes of the tunnel.
+	dl, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): got %v, want no error", err)
+	}
+	defer dl.Close()
+
+	hello := echClientHello()
+	gotc := make(chan []byte, 1)
+	go func() {
+		conn, err := dl.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, len(hello))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		gotc <- b
+		conn.Write([]byte("passthrough"))
+	}()
+
+	tt := []struct {
+		policy mitm.ECHPolicy
+		want   string
+	}{
+		{mitm.ECHPassthrough, "passthrough"},
+		{mitm.ECHReject, ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			l := newListener(t)
+			p := NewProxy()
+			defer p.Close()
+
+			mc, err := mitm.NewConfig(ca, priv)
+			if err != nil {
+				t.Fatalf("mitm.NewConfig(): got %v, want no error", err)
+			}
+			mc.SetECHPolicy(tc.policy)
+			p.SetMITM(mc)
+
+			// Force the tunnel to dial the destination server.
+			tm := martiantest.NewModifier()
+			tm.RequestFunc(func(req *http.Request) {
+				if req.Method == "CONNECT" {
+					req.URL.Host = dl.Addr().String()
+				}
+			})
+			p.SetRequestModifier(tm)
+
+			go serve(p, l)
+
+			conn, err := l.dial()
+			if err != nil {
+				t.Fatalf("net.Dial(): got %v, want no error", err)
+			}
+			defer conn.Close()
+
+			req, err := http.NewRequest("CONNECT", "//example.com:443", nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			if err := req.Write(conn); err != nil {
+				t.Fatalf("req.Write(): got %v, want no error", err)
+			}
+
+			br := bufio.NewReader(conn)
+			res, err := http.ReadResponse(br, req)
+			if err != nil {
+				t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+			}
+			if got, want := res.StatusCode, 200; got != want {
+				t.Fatalf("res.StatusCode: got %d, want %d", got, want)
+			}
+
+			if _, err := conn.Write(hello); err != nil {
+				t.Fatalf("conn.Write(): got %v, want no error", err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			got, _ := io.ReadAll(br)
+			if string(got) != tc.want {
+				t.Errorf("tunnel: got %q, want %q", got, tc.want)
+			}
+
+			if tc.want != "" {
+				if b := <-gotc; !bytes.Equal(b, hello) {
+					t.Errorf("destination: got %x, want %x", b, hello)
+				}
+			}
+		})
+	}
+}
+
 func TestIntegrationTransparentHTTP(t *testing.T) {
 	t.Parallel()
 
@@ -1251,6 +1893,15 @@ func TestIntegrationTransparentMITM(t *testing.T) {
 		t.Skip("skipping in handler mode")
 	}
 
+	testTransparentMITM(t, func(*Proxy) {})
+}
+
+// testTransparentMITM serves a proxy configured by configure on a TLS
+// listener and checks that requests written directly to it, without CONNECT,
+// are handled as HTTPS requests.
+func testTransparentMITM(t *testing.T, configure func(p *Proxy)) {
+	t.Helper()
+
 	ca, priv, err := mitm.NewAuthority("martian.proxy", "Martian Authority", 2*time.Hour)
 	if err != nil {
 		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
@@ -1274,6 +1925,7 @@ func TestIntegrationTransparentMITM(t *testing.T) {
 
 	p := NewProxy()
 	defer p.Close()
+	configure(p)
 
 	tr := martiantest.NewTransport()
 	tr.Func(func(req *http.Request) (*http.Response, error) {
@@ -1438,6 +2090,91 @@ func TestIntegrationSkipRoundTrip(t *testing.T) {
 	}
 }
 
+func TestIntegrationAbort(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		reqerr    error
+		reserr    error
+		wantTrips int32
+	}{
+		{
+			name:      "request",
+			reqerr:    Abort(errors.New("request modifier error")),
+			wantTrips: 0,
+		},
+		{
+			name:      "response",
+			reserr:    Abort(errors.New("response modifier error")),
+			wantTrips: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newListener(t)
+			p := NewProxy()
+			defer p.Close()
+
+			var trips int32
+			tr := martiantest.NewTransport()
+			tr.Func(func(req *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&trips, 1)
+				return proxyutil.NewResponse(200, nil, req), nil
+			})
+			p.SetRoundTripper(tr)
+			p.SetTimeout(200 * time.Millisecond)
+
+			tm := martiantest.NewModifier()
+			tm.RequestError(tc.reqerr)
+			tm.ResponseError(tc.reserr)
+			p.SetRequestModifier(tm)
+			p.SetResponseModifier(tm)
+
+			go serve(p, l)
+
+			conn, err := l.dial()
+			if err != nil {
+				t.Fatalf("net.Dial(): got %v, want no error", err)
+			}
+			defer conn.Close()
+
+			req, err := http.NewRequest("GET", "http://example.com", nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			if err := req.WriteProxy(conn); err != nil {
+				t.Fatalf("req.WriteProxy(): got %v, want no error", err)
+			}
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), req)
+			if err != nil {
+				t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+			}
+			defer res.Body.Close()
+
+			if got, want := res.StatusCode, 502; got != want {
+				t.Errorf("res.StatusCode: got %d, want %d", got, want)
+			}
+			if got, want := atomic.LoadInt32(&trips), tc.wantTrips; got != want {
+				t.Errorf("round trips: got %d, want %d", got, want)
+			}
+
+			err = tc.reqerr
+			if err == nil {
+				err = tc.reserr
+			}
+			if got, want := res.Header.Get("Warning"), err.Error(); !strings.Contains(got, want) {
+				t.Errorf("res.Header.Get(%q): got %q, want to contain %q", "Warning", got, want)
+			}
+		})
+	}
+}
+
 func TestHTTPThroughConnectWithMITM(t *testing.T) {
 	t.Parallel()
 
@@ -1704,3 +2441,201 @@ func TestReadHeaderTimeout(t *testing.T) {
 		t.Fatalf("conn.Write(): got %v, want EOF", err)
 	}
 }
+
+func TestSetUpstreamTLSPolicy(t *testing.T) {
+	p := NewProxy()
+	defer p.Close()
+
+	if err := p.SetUpstreamTLSPolicy(mitm.TLSPolicy{MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Fatal("SetUpstreamTLSPolicy(): got nil error, want error for min version above max version")
+	}
+
+	policy := mitm.TLSPolicy{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519},
+	}
+	if err := p.SetUpstreamTLSPolicy(policy); err != nil {
+		t.Fatalf("SetUpstreamTLSPolicy(): got %v, want no error", err)
+	}
+
+	// The policy is applied to round trippers set later.
+	tr := &http.Transport{}
+	p.SetRoundTripper(tr)
+
+	if got, want := tr.TLSClientConfig.MinVersion, uint16(tls.VersionTLS12); got != want {
+		t.Errorf("tr.TLSClientConfig.MinVersion: got 0x%04x, want 0x%04x", got, want)
+	}
+	if got := tr.TLSClientConfig.CurvePreferences; len(got) != 1 || got[0] != tls.X25519 {
+		t.Errorf("tr.TLSClientConfig.CurvePreferences: got %v, want [X25519]", got)
+	}
+}
+
+func TestIntegrationUpstreamClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	ca, priv, err := mitm.NewAuthority("martian.client", "Martian Client", time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.PeerCertificates) > 0 {
+			rw.Header().Set("Client-Certificate", req.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	l := newListener(t)
+	p := NewProxy()
+	defer p.Close()
+
+	p.SetRoundTripper(&http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	})
+	p.SetUpstreamClientCertificate("127.0.0.1", &tls.Certificate{
+		Certificate: [][]byte{ca.Raw},
+		PrivateKey:  priv,
+	})
+
+	go serve(p, l)
+
+	conn, err := l.dial()
+	if err != nil {
+		t.Fatalf("net.Dial(): got %v, want no error", err)
+	}
+	defer conn.Close()
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	if err := req.WriteProxy(conn); err != nil {
+		t.Fatalf("req.WriteProxy(): got %v, want no error", err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+	}
+	defer res.Body.Close()
+
+	if got, want := res.StatusCode, 200; got != want {
+		t.Fatalf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("Client-Certificate"), "martian.client"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Client-Certificate", got, want)
+	}
+}
+
+func TestIntegrationUpstreamClientCertificateHTTP2(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	ca, priv, err := mitm.NewAuthority("martian.client", "Martian Client", time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Proto", req.Proto)
+		if len(req.TLS.PeerCertificates) > 0 {
+			rw.Header().Set("Client-Certificate", req.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	}))
+	ts.EnableHTTP2 = true
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	l := newListener(t)
+	p := NewProxy()
+	defer p.Close()
+
+	p.SetRoundTripper(&http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	})
+	p.SetUpstreamClientCertificate("127.0.0.1", &tls.Certificate{
+		Certificate: [][]byte{ca.Raw},
+		PrivateKey:  priv,
+	})
+
+	mca, mpriv, err := mitm.NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+	mc, err := mitm.NewConfig(mca, mpriv)
+	if err != nil {
+		t.Fatalf("mitm.NewConfig(): got %v, want no error", err)
+	}
+	mc.SetH2Config(&h2.Config{
+		AllowedHostsFilter: func(string) bool { return true },
+	})
+	p.SetMITM(mc)
+
+	go serve(p, l)
+
+	conn, err := l.dial()
+	if err != nil {
+		t.Fatalf("net.Dial(): got %v, want no error", err)
+	}
+	defer conn.Close()
+
+	addr := ts.Listener.Addr().String()
+	req, err := http.NewRequest("CONNECT", "//"+addr, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("req.Write(): got %v, want no error", err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, 200; got != want {
+		t.Fatalf("res.StatusCode: got %d, want %d", got, want)
+	}
+
+	tlsconn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+	})
+	defer tlsconn.Close()
+	if err := tlsconn.Handshake(); err != nil {
+		t.Fatalf("tlsconn.Handshake(): got %v, want no error", err)
+	}
+	if got, want := tlsconn.ConnectionState().NegotiatedProtocol, "h2"; got != want {
+		t.Fatalf("NegotiatedProtocol: got %q, want %q", got, want)
+	}
+
+	cc, err := (&http2.Transport{}).NewClientConn(tlsconn)
+	if err != nil {
+		t.Fatalf("NewClientConn(): got %v, want no error", err)
+	}
+	req, err = http.NewRequest("GET", "https://"+addr+"/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res, err = cc.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("cc.RoundTrip(): got %v, want no error", err)
+	}
+	defer res.Body.Close()
+
+	if got, want := res.Header.Get("Proto"), "HTTP/2.0"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Proto", got, want)
+	}
+	if got, want := res.Header.Get("Client-Certificate"), "martian.client"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Client-Certificate", got, want)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package querystring
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("querystring.Regex", regexModifierFromJSON)
+}
+
+// RegexModifier is a request modifier that removes, renames and rewrites
+// query parameters selected by regular expressions. Rules are applied in the
+// order they were added, each to the result of the previous ones.
+// Replacements may refer to capture groups, as in regexp.Regexp.Expand.
+//
+// Unlike Modifier, RegexModifier keeps the order and the encoding of the
+// parameters it does not change, unless sorting or normalization is enabled.
+type RegexModifier struct {
+	rules     []regexRule
+	sort      bool
+	normalize bool
+}
+
+type regexRule struct {
+	action string
+	name   *regexp.Regexp
+	value  *regexp.Regexp
+	repl   string
+}
+
+type regexRuleJSON struct {
+	Action      string `json:"action"`
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Replacement string `json:"replacement"`
+}
+
+type regexModifierJSON struct {
+	Rules     []regexRuleJSON      `json:"rules"`
+	Sort      bool                 `json:"sort"`
+	Normalize bool                 `json:"normalize"`
+	Scope     []parse.ModifierType `json:"scope"`
+}
+
+// param is a query parameter. raw is its text in the original query, or empty
+// once the parameter was changed.
+type param struct {
+	name, value string
+	raw         string
+}
+
+// NewRegexModifier returns a RegexModifier without rules.
+func NewRegexModifier() *RegexModifier {
+	return &RegexModifier{}
+}
+
+// Remove removes the parameters whose name matches name.
+func (m *RegexModifier) Remove(name *regexp.Regexp) {
+	m.rules = append(m.rules, regexRule{action: "remove", name: name})
+}
+
+// Rename replaces the matches of name in the names of parameters with repl.
+func (m *RegexModifier) Rename(name *regexp.Regexp, repl string) {
+	m.rules = append(m.rules, regexRule{action: "rename", name: name, repl: repl})
+}
+
+// Rewrite replaces the matches of value in the values of the parameters whose
+// name matches name with repl.
+func (m *RegexModifier) Rewrite(name, value *regexp.Regexp, repl string) {
+	m.rules = append(m.rules, regexRule{action: "rewrite", name: name, value: value, repl: repl})
+}
+
+// SetSort sets whether parameters are sorted by name. Parameters with the
+// same name keep their order.
+func (m *RegexModifier) SetSort(sort bool) {
+	m.sort = sort
+}
+
+// SetNormalize sets whether all parameters are re-encoded in the canonical
+// form of url.QueryEscape, and parameters without name are dropped.
+func (m *RegexModifier) SetNormalize(normalize bool) {
+	m.normalize = normalize
+}
+
+// ModifyRequest applies the rules to the query of req.
+func (m *RegexModifier) ModifyRequest(req *http.Request) error {
+	if req.URL.RawQuery == "" {
+		return nil
+	}
+
+	params := parseParams(req.URL.RawQuery)
+	for _, r := range m.rules {
+		params = r.apply(params)
+	}
+
+	if m.sort {
+		sort.SliceStable(params, func(i, j int) bool {
+			return params[i].name < params[j].name
+		})
+	}
+
+	parts := make([]string, 0, len(params))
+	for _, p := range params {
+		switch {
+		case m.normalize && p.name == "":
+			continue
+		case p.raw != "" && !m.normalize:
+			parts = append(parts, p.raw)
+		default:
+			parts = append(parts, url.QueryEscape(p.name)+"="+url.QueryEscape(p.value))
+		}
+	}
+	req.URL.RawQuery = strings.Join(parts, "&")
+
+	return nil
+}
+
+func (r regexRule) apply(params []param) []param {
+	out := params[:0]
+	for _, p := range params {
+		if !r.name.MatchString(p.name) {
+			out = append(out, p)
+			continue
+		}
+
+		switch r.action {
+		case "remove":
+			continue
+		case "rename":
+			if name := r.name.ReplaceAllString(p.name, r.repl); name != p.name {
+				p.name, p.raw = name, ""
+			}
+		case "rewrite":
+			if value := r.value.ReplaceAllString(p.value, r.repl); value != p.value {
+				p.value, p.raw = value, ""
+			}
+		}
+		out = append(out, p)
+	}
+
+	return out
+}
+
+// parseParams splits query into its parameters in order. Parameters that
+// cannot be unescaped keep their escaped text.
+func parseParams(query string) []param {
+	var params []param
+	for _, raw := range strings.Split(query, "&") {
+		if raw == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(raw, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		params = append(params, param{name: name, value: value, raw: raw})
+	}
+
+	return params
+}
+
+// regexModifierFromJSON builds a querystring.Regex modifier from JSON.
+// "rules" are applied in order; each has an "action" of remove, rename or
+// rewrite and a "name" regular expression selecting parameters. rename
+// replaces the matches of "name" in parameter names with "replacement", and
+// rewrite replaces the matches of "value" in parameter values with
+// "replacement". "sort" sorts the parameters by name and "normalize"
+// re-encodes them canonically.
+//
+// Example JSON:
+//
+//	{
+//	  "querystring.Regex": {
+//	    "scope": ["request"],
+//	    "rules": [
+//	      {"action": "remove", "name": "^utm_"},
+//	      {"action": "rename", "name": "^legacy_(.*)$", "replacement": "$1"},
+//	      {"action": "rewrite", "name": "^user$", "value": "^(\\d+)$", "replacement": "id-$1"}
+//	    ],
+//	    "sort": true
+//	  }
+//	}
+func regexModifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &regexModifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mod := NewRegexModifier()
+	for i, r := range msg.Rules {
+		name, err := regexp.Compile(r.Name)
+		if err != nil {
+			return nil, fmt.Errorf("querystring: rule %d: invalid name %q: %w", i, r.Name, err)
+		}
+
+		switch r.Action {
+		case "remove":
+			mod.Remove(name)
+		case "rename":
+			mod.Rename(name, r.Replacement)
+		case "rewrite":
+			if r.Value == "" {
+				return nil, fmt.Errorf("querystring: rule %d: rewrite requires value", i)
+			}
+			value, err := regexp.Compile(r.Value)
+			if err != nil {
+				return nil, fmt.Errorf("querystring: rule %d: invalid value %q: %w", i, r.Value, err)
+			}
+			mod.Rewrite(name, value, r.Replacement)
+		default:
+			return nil, fmt.Errorf("querystring: rule %d: unknown action %q", i, r.Action)
+		}
+	}
+	mod.SetSort(msg.Sort)
+	mod.SetNormalize(msg.Normalize)
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package querystring
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func TestRegexModifier(t *testing.T) {
+	tt := []struct {
+		name  string
+		set   func(m *RegexModifier)
+		query string
+		want  string
+	}{
+		{
+			name:  "no rules keeps query",
+			set:   func(m *RegexModifier) {},
+			query: "b=2&a=%7e&flag",
+			want:  "b=2&a=%7e&flag",
+		},
+		{
+			name:  "remove",
+			set:   func(m *RegexModifier) { m.Remove(regexp.MustCompile("^utm_")) },
+			query: "q=go&utm_source=mail&utm_medium=x&page=2",
+			want:  "q=go&page=2",
+		},
+		{
+			name: "rename with capture",
+			set: func(m *RegexModifier) {
+				m.Rename(regexp.MustCompile("^legacy_(.*)$"), "$1")
+			},
+			query: "legacy_user=1&keep=%7e",
+			want:  "user=1&keep=%7e",
+		},
+		{
+			name: "rewrite with capture",
+			set: func(m *RegexModifier) {
+				m.Rewrite(regexp.MustCompile("^user$"), regexp.MustCompile(`^(\d+)$`), "id-$1")
+			},
+			query: "user=42&user=bob&other=42",
+			want:  "user=id-42&user=bob&other=42",
+		},
+		{
+			name: "rules chain",
+			set: func(m *RegexModifier) {
+				m.Rename(regexp.MustCompile("^q$"), "query")
+				m.Rewrite(regexp.MustCompile("^query$"), regexp.MustCompile(" "), "+")
+			},
+			query: "q=a+b",
+			want:  "query=a%2Bb",
+		},
+		{
+			name:  "sort",
+			set:   func(m *RegexModifier) { m.SetSort(true) },
+			query: "c=1&a=2&b=3&a=1",
+			want:  "a=2&a=1&b=3&c=1",
+		},
+		{
+			name:  "normalize",
+			set:   func(m *RegexModifier) { m.SetNormalize(true) },
+			query: "a=%7e&&=x&b=hello%20world&flag",
+			want:  "a=~&b=hello+world&flag=",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mod := NewRegexModifier()
+			tc.set(mod)
+
+			req, err := http.NewRequest("GET", "http://example.com/?"+tc.query, nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			if err := mod.ModifyRequest(req); err != nil {
+				t.Fatalf("ModifyRequest(): got %v, want no error", err)
+			}
+			if got := req.URL.RawQuery; got != tc.want {
+				t.Errorf("req.URL.RawQuery: got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRegexModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"querystring.Regex": {
+			"scope": ["request"],
+			"rules": [
+				{"action": "remove", "name": "^utm_"},
+				{"action": "rename", "name": "^legacy_(.*)$", "replacement": "$1"},
+				{"action": "rewrite", "name": "^user$", "value": "^(\\d+)$", "replacement": "id-$1"}
+			],
+			"sort": true
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("r.RequestModifier(): got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/?z=1&utm_source=x&legacy_user=7", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.URL.RawQuery, "user=id-7&z=1"; got != want {
+		t.Errorf("req.URL.RawQuery: got %q, want %q", got, want)
+	}
+
+	for _, msg := range []string{
+		`{"querystring.Regex": {"scope": ["request"], "rules": [{"action": "drop", "name": "a"}]}}`,
+		`{"querystring.Regex": {"scope": ["request"], "rules": [{"action": "remove", "name": "("}]}}`,
+		`{"querystring.Regex": {"scope": ["request"], "rules": [{"action": "rewrite", "name": "a"}]}}`,
+	} {
+		if _, err := parse.FromJSON([]byte(msg)); err == nil {
+			t.Errorf("parse.FromJSON(%s): got nil, want error", msg)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"io"
+	"net/http"
+)
+
+// RedirectsKey is the key of the []*Redirect followed by the proxy for a
+// request in its Context, see Proxy.FollowRedirects. It is set before the
+// response modifiers run, only if a redirect was followed.
+const RedirectsKey = "martian.Redirects"
+
+// maxRedirectDrain is the maximum number of bytes of the body of a followed
+// redirect response that are read, so that its connection can be reused.
+const maxRedirectDrain = 2 << 10
+
+// Redirect is a redirect response that the proxy followed.
+type Redirect struct {
+	// Method and URL are the method and URL of the request that was
+	// redirected.
+	Method string
+	URL    string
+	// StatusCode is the status code of the redirect response.
+	StatusCode int
+	// Location is the absolute URL the request was redirected to.
+	Location string
+}
+
+// FollowRedirects makes the proxy follow up to max redirects of the response
+// to the current request, overriding Proxy.FollowRedirects. A max of zero
+// returns redirects to the client.
+func (ctx *Context) FollowRedirects(max int) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	ctx.followRedirects = &max
+}
+
+// FollowingRedirects returns the maximum number of redirects to follow set by
+// FollowRedirects, or false if it was not called.
+func (ctx *Context) FollowingRedirects() (int, bool) {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	if ctx.followRedirects == nil {
+		return 0, false
+	}
+	return *ctx.followRedirects, true
+}
+
+// followRedirects follows up to the configured number of redirects starting
+// at res, the response to req, and returns the last response. The redirects
+// followed are recorded in ctx under RedirectsKey.
+func (p *Proxy) followRedirects(ctx *Context, req *http.Request, res *http.Response) (*http.Response, error) {
+	max := p.FollowRedirects
+	if n, ok := ctx.FollowingRedirects(); ok {
+		max = n
+	}
+
+	var chain []*Redirect
+	defer func() {
+		if len(chain) > 0 {
+			ctx.Set(RedirectsKey, chain)
+		}
+	}()
+
+	for cur := req; len(chain) < max; {
+		next := redirectRequest(cur, res)
+		if next == nil {
+			break
+		}
+
+		chain = append(chain, &Redirect{
+			Method:     cur.Method,
+			URL:        cur.URL.String(),
+			StatusCode: res.StatusCode,
+			Location:   next.URL.String(),
+		})
+		ctx.logger().Debugf("martian: following redirect: %d %s -> %s", res.StatusCode, cur.URL, next.URL)
+
+		io.CopyN(io.Discard, res.Body, maxRedirectDrain)
+		res.Body.Close()
+
+		var err error
+		if res, err = p.roundTrip(ctx, next); err != nil {
+			return nil, err
+		}
+		cur = next
+	}
+
+	return res, nil
+}
+
+// redirectRequest returns the request following the redirect response res to
+// req, or nil if res is not a redirect or it cannot be followed. Like
+// http.Client, it changes the method to GET for 301, 302 and 303 responses to
+// requests other than GET and HEAD, and drops credentials when redirected to
+// another host. Since the body of req was sent already, 307 and 308 responses
+// to requests with a body are not followed.
+func redirectRequest(req *http.Request, res *http.Response) *http.Request {
+	var keepMethod bool
+	switch res.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		keepMethod = req.Method == http.MethodGet || req.Method == http.MethodHead
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if req.Body != nil && req.Body != http.NoBody {
+			return nil
+		}
+		keepMethod = true
+	default:
+		return nil
+	}
+
+	loc := res.Header.Get("Location")
+	if loc == "" {
+		return nil
+	}
+	u, err := req.URL.Parse(loc)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+
+	next := req.Clone(req.Context())
+	next.URL = u
+	next.Host = u.Host
+	next.RequestURI = ""
+	if !keepMethod {
+		next.Method = http.MethodGet
+		next.Body = http.NoBody
+		next.ContentLength = 0
+		next.Header.Del("Content-Type")
+		next.Header.Del("Content-Length")
+		next.Header.Del("Content-Encoding")
+	}
+	if u.Host != req.URL.Host {
+		for _, h := range []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"} {
+			next.Header.Del(h)
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package redirect provides a request modifier that makes the proxy follow
+// redirects itself.
+package redirect
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("redirect.Follow", followFromJSON)
+}
+
+// Follow is a request modifier that makes the proxy follow up to a number of
+// redirects of the response to the request, and return the final response to
+// the client. Used in a filter, it enables following redirects for some
+// requests only. The redirects followed are recorded in the context of the
+// request under martian.RedirectsKey.
+type Follow struct {
+	max int
+}
+
+type followJSON struct {
+	Max   int                  `json:"max"`
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// NewFollow returns a Follow modifier that follows up to max redirects. A max
+// of zero returns redirects to the client, overriding
+// martian.Proxy.FollowRedirects.
+func NewFollow(max int) *Follow {
+	return &Follow{max: max}
+}
+
+// ModifyRequest sets the maximum number of redirects followed for req.
+func (f *Follow) ModifyRequest(req *http.Request) error {
+	if ctx := martian.NewContext(req); ctx != nil {
+		ctx.FollowRedirects(f.max)
+	}
+
+	return nil
+}
+
+// followFromJSON builds a redirect.Follow modifier from JSON. "max" is the
+// maximum number of redirects followed, 10 if not set.
+//
+// Example JSON:
+//
+//	{
+//	  "redirect.Follow": {
+//	    "scope": ["request"],
+//	    "max": 5
+//	  }
+//	}
+func followFromJSON(b []byte) (*parse.Result, error) {
+	msg := &followJSON{Max: 10}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if msg.Max < 0 {
+		return nil, errors.New("redirect: max must not be negative")
+	}
+
+	return parse.NewResult(NewFollow(msg.Max), msg.Scope)
+}
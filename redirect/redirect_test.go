@@ -0,0 +1,61 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package redirect
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+)
+
+func TestFollowFromJSON(t *testing.T) {
+	tt := []struct {
+		msg  string
+		want int
+	}{
+		{`{"redirect.Follow": {"scope": ["request"], "max": 3}}`, 3},
+		{`{"redirect.Follow": {"scope": ["request"]}}`, 10},
+		{`{"redirect.Follow": {"scope": ["request"], "max": 0}}`, 0},
+	}
+
+	for i, tc := range tt {
+		r, err := parse.FromJSON([]byte(tc.msg))
+		if err != nil {
+			t.Fatalf("%d. parse.FromJSON(): got %v, want no error", i, err)
+		}
+
+		reqmod, ok := r.RequestModifier().(*Follow)
+		if !ok {
+			t.Fatalf("%d. r.RequestModifier(): got %T, want *Follow", i, r.RequestModifier())
+		}
+		if got := reqmod.max; got != tc.want {
+			t.Errorf("%d. max: got %d, want %d", i, got, tc.want)
+		}
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"redirect.Follow": {"scope": ["request"], "max": -1}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for negative max")
+	}
+}
+
+func TestFollowModifyRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	// Without a context, the request is left unchanged.
+	if err := NewFollow(3).ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	ctx := martian.TestContext(req, nil, nil)
+	if err := NewFollow(3).ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, ok := ctx.FollowingRedirects(); !ok || got != 3 {
+		t.Errorf("ctx.FollowingRedirects(): got %d, %t, want 3, true", got, ok)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3/martiantest"
+)
+
+func TestIntegrationFollowRedirects(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/a":
+			http.Redirect(rw, req, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(rw, req, "/c?done=1", http.StatusTemporaryRedirect)
+		default:
+			io.WriteString(rw, req.Method+" "+req.URL.String())
+		}
+	}))
+	defer s.Close()
+
+	tt := []struct {
+		name       string
+		follow     int
+		ctxFollow  int
+		wantStatus int
+		wantBody   string
+		wantChain  int
+	}{
+		{name: "disabled", wantStatus: 302, wantChain: 0},
+		{name: "all", follow: 5, wantStatus: 200, wantBody: "GET /c?done=1", wantChain: 2},
+		{name: "limited", follow: 1, wantStatus: 307, wantChain: 1},
+		{name: "context", ctxFollow: 5, wantStatus: 200, wantBody: "GET /c?done=1", wantChain: 2},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newListener(t)
+			p := NewProxy()
+			defer p.Close()
+			p.FollowRedirects = tc.follow
+
+			tm := martiantest.NewModifier()
+			tm.RequestFunc(func(req *http.Request) {
+				if tc.ctxFollow > 0 {
+					NewContext(req).FollowRedirects(tc.ctxFollow)
+				}
+			})
+			var chain []*Redirect
+			tm.ResponseFunc(func(res *http.Response) {
+				if v, ok := NewContext(res.Request).Get(RedirectsKey); ok {
+					chain = v.([]*Redirect)
+				}
+			})
+			p.SetRequestModifier(tm)
+			p.SetResponseModifier(tm)
+
+			go serve(p, l)
+
+			conn, err := l.dial()
+			if err != nil {
+				t.Fatalf("net.Dial(): got %v, want no error", err)
+			}
+			defer conn.Close()
+
+			req, err := http.NewRequest("POST", s.URL+"/a", strings.NewReader("body"))
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+			if err := req.WriteProxy(conn); err != nil {
+				t.Fatalf("req.WriteProxy(): got %v, want no error", err)
+			}
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), req)
+			if err != nil {
+				t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+			}
+			defer res.Body.Close()
+
+			if got, want := res.StatusCode, tc.wantStatus; got != want {
+				t.Errorf("res.StatusCode: got %d, want %d", got, want)
+			}
+			if tc.wantBody != "" {
+				body, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Fatalf("io.ReadAll(): got %v, want no error", err)
+				}
+				if got, want := string(body), tc.wantBody; got != want {
+					t.Errorf("res.Body: got %q, want %q", got, want)
+				}
+			}
+
+			if got, want := len(chain), tc.wantChain; got != want {
+				t.Fatalf("len(chain): got %d, want %d", got, want)
+			}
+			if len(chain) > 0 {
+				want := &Redirect{Method: "POST", URL: s.URL + "/a", StatusCode: 302, Location: s.URL + "/b"}
+				if got := chain[0]; *got != *want {
+					t.Errorf("chain[0]: got %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedirectRequest(t *testing.T) {
+	newResponse := func(code int, loc string) *http.Response {
+		res := &http.Response{StatusCode: code, Header: http.Header{}}
+		res.Header.Set("Location", loc)
+		return res
+	}
+
+	req, err := http.NewRequest("PUT", "http://example.com/a", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Content-Type", "text/plain")
+
+	next := redirectRequest(req, newResponse(303, "/b"))
+	if next == nil {
+		t.Fatal("redirectRequest(303): got nil, want request")
+	}
+	if got, want := next.Method, "GET"; got != want {
+		t.Errorf("next.Method: got %q, want %q", got, want)
+	}
+	if got := next.Header.Get("Content-Type"); got != "" {
+		t.Errorf("next.Header.Get(%q): got %q, want none", "Content-Type", got)
+	}
+	if got, want := next.Header.Get("Authorization"), "Bearer token"; got != want {
+		t.Errorf("next.Header.Get(%q): got %q, want %q", "Authorization", got, want)
+	}
+
+	next = redirectRequest(req, newResponse(308, "https://other.example/b"))
+	if next == nil {
+		t.Fatal("redirectRequest(308): got nil, want request")
+	}
+	if got, want := next.Method, "PUT"; got != want {
+		t.Errorf("next.Method: got %q, want %q", got, want)
+	}
+	if got, want := next.Host, "other.example"; got != want {
+		t.Errorf("next.Host: got %q, want %q", got, want)
+	}
+	if got := next.Header.Get("Authorization"); got != "" {
+		t.Errorf("next.Header.Get(%q): got %q, want none for another host", "Authorization", got)
+	}
+
+	for _, res := range []*http.Response{
+		newResponse(200, "/b"),
+		newResponse(304, "/b"),
+		newResponse(302, ""),
+		newResponse(302, "ftp://example.com/"),
+	} {
+		if next := redirectRequest(req, res); next != nil {
+			t.Errorf("redirectRequest(%d, %q): got %s, want nil", res.StatusCode, res.Header.Get("Location"), next.URL)
+		}
+	}
+
+	req.Body = io.NopCloser(strings.NewReader("body"))
+	if next := redirectRequest(req, newResponse(307, "/b")); next != nil {
+		t.Errorf("redirectRequest(307): got %s, want nil for request with body", next.URL)
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package remote provides a modifier that forwards requests and responses to
+// an ExternalModifier gRPC service, so that modification logic can live in
+// another process, written in any language.
+//
+// For each request or response, the modifier opens a stream of the
+// ModifyRequest or ModifyResponse method, sends the head of the message
+// followed by its body in chunks, and closes the sending side. The service
+// replies with the complete head of the modified message, whose fields and
+// headers replace those of the message, followed by the new body if the
+// head sets replace_body. The service may reply before it has received the
+// whole body; the rest of the body is then left as it is.
+//
+// The service is defined in remotepb/remote.proto.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/remote/remotepb"
+)
+
+// DefaultTimeout is the default time a remote modification may take.
+const DefaultTimeout = time.Second
+
+// chunkSize is the size of the body chunks sent to the service.
+const chunkSize = 32 << 10
+
+func init() {
+	parse.Register("remote.Modifier", modifierFromJSON)
+}
+
+// Modifier forwards requests and responses to an ExternalModifier service and
+// applies the modifications it returns.
+type Modifier struct {
+	client  remotepb.ExternalModifierClient
+	timeout time.Duration
+}
+
+type modifierJSON struct {
+	Target    string               `json:"target"`
+	TLS       bool                 `json:"tls"`
+	TimeoutMs int64                `json:"timeoutMs"`
+	Scope     []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier returns a Modifier calling the ExternalModifier service over
+// cc.
+func NewModifier(cc grpc.ClientConnInterface) *Modifier {
+	return &Modifier{
+		client:  remotepb.NewExternalModifierClient(cc),
+		timeout: DefaultTimeout,
+	}
+}
+
+// SetTimeout sets the time a remote modification may take, including the
+// transfer of the bodies. A timeout of zero disables the limit.
+func (m *Modifier) SetTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
+// ModifyRequest sends req to the service and applies the modified request it
+// returns.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	ctx, cancel := m.context(req.Context())
+	defer cancel()
+
+	s, err := m.client.ModifyRequest(ctx)
+	if err != nil {
+		return fmt.Errorf("remote: %v", err)
+	}
+
+	var head *remotepb.RequestHead
+	body, replaced, err := exchange(cancel, &stream{
+		sendHead: func() error {
+			return s.Send(&remotepb.RequestPart{Part: &remotepb.RequestPart_Head{Head: requestHead(req)}})
+		},
+		sendBody: func(b []byte) error {
+			return s.Send(&remotepb.RequestPart{Part: &remotepb.RequestPart_Body{Body: b}})
+		},
+		closeSend:   s.CloseSend,
+		replaceBody: func() bool { return head.ReplaceBody },
+		recv: func() (bool, []byte, error) {
+			p, err := s.Recv()
+			if err != nil {
+				return false, nil, err
+			}
+			if h := p.GetHead(); h != nil {
+				head = h
+				return true, nil, nil
+			}
+			return false, p.GetBody(), nil
+		},
+	}, &req.Body)
+	if err != nil {
+		return err
+	}
+
+	if head.Method != "" {
+		req.Method = head.Method
+	}
+	if head.Url != "" {
+		u, err := url.Parse(head.Url)
+		if err != nil {
+			return fmt.Errorf("remote: invalid url: %v", err)
+		}
+		req.URL = u
+		req.Host = u.Host
+	}
+	if head.Host != "" {
+		req.Host = head.Host
+	}
+	req.Header = fromHeaders(head.Headers)
+	if replaced {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	if head.SkipRoundTrip {
+		ctx := martian.NewContext(req)
+		if ctx == nil {
+			return errors.New("remote: skip_round_trip: request has no context")
+		}
+		ctx.SkipRoundTrip()
+	}
+
+	return nil
+}
+
+// ModifyResponse sends res and its request to the service and applies the
+// modified response it returns.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	parent := context.Background()
+	if res.Request != nil {
+		parent = res.Request.Context()
+	}
+	ctx, cancel := m.context(parent)
+	defer cancel()
+
+	s, err := m.client.ModifyResponse(ctx)
+	if err != nil {
+		return fmt.Errorf("remote: %v", err)
+	}
+
+	var head *remotepb.ResponseHead
+	body, replaced, err := exchange(cancel, &stream{
+		sendHead: func() error {
+			h := &remotepb.ResponseHead{
+				Status:  int32(res.StatusCode),
+				Headers: toHeaders(res.Header),
+			}
+			if res.Request != nil {
+				h.Request = requestHead(res.Request)
+			}
+			return s.Send(&remotepb.ResponsePart{Part: &remotepb.ResponsePart_Head{Head: h}})
+		},
+		sendBody: func(b []byte) error {
+			return s.Send(&remotepb.ResponsePart{Part: &remotepb.ResponsePart_Body{Body: b}})
+		},
+		closeSend:   s.CloseSend,
+		replaceBody: func() bool { return head.ReplaceBody },
+		recv: func() (bool, []byte, error) {
+			p, err := s.Recv()
+			if err != nil {
+				return false, nil, err
+			}
+			if h := p.GetHead(); h != nil {
+				head = h
+				return true, nil, nil
+			}
+			return false, p.GetBody(), nil
+		},
+	}, &res.Body)
+	if err != nil {
+		return err
+	}
+
+	if head.Status != 0 {
+		if head.Status < 100 || head.Status > 999 {
+			return fmt.Errorf("remote: invalid status %d", head.Status)
+		}
+		res.StatusCode = int(head.Status)
+		res.Status = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	res.Header = fromHeaders(head.Headers)
+	if replaced {
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		res.ContentLength = int64(len(body))
+		res.TransferEncoding = nil
+	}
+
+	return nil
+}
+
+func (m *Modifier) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if m.timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, m.timeout)
+}
+
+// stream adapts the streams of both methods to exchange.
+type stream struct {
+	sendHead  func() error
+	sendBody  func([]byte) error
+	closeSend func() error
+	// recv receives a part, reporting whether it is a head or returning
+	// the body chunk it holds.
+	recv func() (bool, []byte, error)
+	// replaceBody reports whether the head received sets replace_body.
+	replaceBody func() bool
+}
+
+// exchange sends the head and body of a message to the service and receives
+// the head and new body it returns. replaced reports whether the service
+// replaces the body; if it does not, the body is left unchanged. cancel
+// cancels the stream.
+func exchange(cancel context.CancelFunc, s *stream, body *io.ReadCloser) (b []byte, replaced bool, err error) {
+	orig := *body
+	if orig == http.NoBody {
+		orig = nil
+	}
+	sent := &bytes.Buffer{}
+	done := make(chan error, 1)
+
+	go func() {
+		err := send(s, orig, sent)
+		if err != nil {
+			// The service would wait for the rest of the body.
+			cancel()
+		}
+		done <- err
+	}()
+
+	b, replaced, err = recv(s)
+	if err != nil {
+		// Stop the sender before the body is restored.
+		cancel()
+	}
+	serr := <-done
+
+	if replaced && err == nil && serr == nil {
+		if orig != nil {
+			orig.Close()
+		}
+	} else if orig != nil {
+		*body = &restoredBody{
+			Reader: io.MultiReader(bytes.NewReader(sent.Bytes()), orig),
+			Closer: orig,
+		}
+	}
+
+	if serr != nil {
+		return nil, false, serr
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, replaced, nil
+}
+
+// send sends the head and the chunks of body, which are copied to sent. The
+// service may end the stream before the whole body is sent.
+func send(s *stream, body io.Reader, sent *bytes.Buffer) error {
+	if err := s.sendHead(); err != nil {
+		return ignoreEOF(err)
+	}
+
+	if body != nil {
+		buf := make([]byte, chunkSize)
+		for {
+			n, rerr := body.Read(buf)
+			if n > 0 {
+				sent.Write(buf[:n])
+				if err := s.sendBody(buf[:n]); err != nil {
+					return ignoreEOF(err)
+				}
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return fmt.Errorf("remote: reading body: %v", rerr)
+			}
+		}
+	}
+
+	return ignoreEOF(s.closeSend())
+}
+
+// ignoreEOF ignores io.EOF, which Send returns once the stream has ended; the
+// status of the stream is then returned by Recv.
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("remote: %v", err)
+	}
+	return nil
+}
+
+// recv receives the head and the body chunks that follow it until the
+// service ends the stream, and reports whether the body is replaced.
+func recv(s *stream) ([]byte, bool, error) {
+	head, _, err := s.recv()
+	if err != nil {
+		return nil, false, fmt.Errorf("remote: %v", err)
+	}
+	if !head {
+		return nil, false, errors.New("remote: body part before head")
+	}
+
+	replace := s.replaceBody()
+	var body []byte
+	for {
+		head, b, err := s.recv()
+		if err == io.EOF {
+			return body, replace, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("remote: %v", err)
+		}
+		if head {
+			return nil, false, errors.New("remote: more than one head")
+		}
+		if !replace {
+			return nil, false, errors.New("remote: body part without replace_body")
+		}
+		body = append(body, b...)
+	}
+}
+
+type restoredBody struct {
+	io.Reader
+	io.Closer
+}
+
+func requestHead(req *http.Request) *remotepb.RequestHead {
+	return &remotepb.RequestHead{
+		Method:     req.Method,
+		Url:        req.URL.String(),
+		Host:       req.Host,
+		RemoteAddr: req.RemoteAddr,
+		Headers:    toHeaders(req.Header),
+	}
+}
+
+// toHeaders converts h to header fields sorted by name.
+func toHeaders(h http.Header) []*remotepb.Header {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hs := make([]*remotepb.Header, 0, len(names))
+	for _, name := range names {
+		hs = append(hs, &remotepb.Header{Name: name, Values: h[name]})
+	}
+	return hs
+}
+
+func fromHeaders(hs []*remotepb.Header) http.Header {
+	h := make(http.Header, len(hs))
+	for _, f := range hs {
+		for _, v := range f.Values {
+			h.Add(f.Name, v)
+		}
+	}
+	return h
+}
+
+// modifierFromJSON builds a remote.Modifier from JSON. "target" is the
+// address of the service, which is called over TLS if "tls" is true, and in
+// plaintext otherwise. "timeoutMs" overrides the default timeout.
+//
+// Example JSON:
+//
+//	{
+//	  "remote.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "target": "localhost:9090",
+//	    "timeoutMs": 500
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if msg.Target == "" {
+		return nil, errors.New("remote: target must be set")
+	}
+	if msg.TimeoutMs < 0 {
+		return nil, errors.New("remote: timeoutMs must not be negative")
+	}
+
+	creds := insecure.NewCredentials()
+	if msg.TLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	cc, err := grpc.Dial(msg.Target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("remote: %v", err)
+	}
+
+	mod := NewModifier(cc)
+	if msg.TimeoutMs > 0 {
+		mod.SetTimeout(time.Duration(msg.TimeoutMs) * time.Millisecond)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,318 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+	"github.com/google/martian/v3/remote/remotepb"
+)
+
+type testServer struct {
+	remotepb.UnimplementedExternalModifierServer
+	modifyRequest  func(remotepb.ExternalModifier_ModifyRequestServer) error
+	modifyResponse func(remotepb.ExternalModifier_ModifyResponseServer) error
+}
+
+func (s *testServer) ModifyRequest(stream remotepb.ExternalModifier_ModifyRequestServer) error {
+	return s.modifyRequest(stream)
+}
+
+func (s *testServer) ModifyResponse(stream remotepb.ExternalModifier_ModifyResponseServer) error {
+	return s.modifyResponse(stream)
+}
+
+// newTestModifier returns a Modifier calling srv over an in-memory
+// connection.
+func newTestModifier(t *testing.T, srv *testServer) *Modifier {
+	t.Helper()
+
+	l := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	remotepb.RegisterExternalModifierServer(s, srv)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.Dial(): got %v, want no error", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	return NewModifier(cc)
+}
+
+// recvRequest receives the head and the whole body of a request.
+func recvRequest(stream remotepb.ExternalModifier_ModifyRequestServer) (*remotepb.RequestHead, []byte, error) {
+	p, err := stream.Recv()
+	if err != nil {
+		return nil, nil, err
+	}
+	head := p.GetHead()
+
+	var body []byte
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			return head, body, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		body = append(body, p.GetBody()...)
+	}
+}
+
+func TestModifyRequest(t *testing.T) {
+	m := newTestModifier(t, &testServer{
+		modifyRequest: func(stream remotepb.ExternalModifier_ModifyRequestServer) error {
+			head, body, err := recvRequest(stream)
+			if err != nil {
+				return err
+			}
+			if head.Method != "POST" || head.Url != "http://example.com/path" || head.RemoteAddr != "192.0.2.1:1234" {
+				return status.Errorf(codes.InvalidArgument, "unexpected head %v", head)
+			}
+
+			head.Method = "PUT"
+			head.Headers = append(head.Headers, &remotepb.Header{Name: "X-Remote", Values: []string{"true"}})
+			head.ReplaceBody = true
+			head.SkipRoundTrip = true
+			if err := stream.Send(&remotepb.RequestPart{Part: &remotepb.RequestPart_Head{Head: head}}); err != nil {
+				return err
+			}
+			return stream.Send(&remotepb.RequestPart{Part: &remotepb.RequestPart_Body{Body: bytes.ToUpper(body)}})
+		},
+	})
+
+	req, err := http.NewRequest("POST", "http://example.com/path", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Keep", "true")
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got, want := req.Method, "PUT"; got != want {
+		t.Errorf("req.Method: got %q, want %q", got, want)
+	}
+	if got, want := req.Header.Get("X-Keep"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Keep", got, want)
+	}
+	if got, want := req.Header.Get("X-Remote"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Remote", got, want)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "BODY"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+	if got, want := req.ContentLength, int64(4); got != want {
+		t.Errorf("req.ContentLength: got %d, want %d", got, want)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got false, want true")
+	}
+}
+
+func TestModifyRequestBodyUnchanged(t *testing.T) {
+	m := newTestModifier(t, &testServer{
+		modifyRequest: func(stream remotepb.ExternalModifier_ModifyRequestServer) error {
+			// Reply to the head without waiting for the body.
+			p, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			return stream.Send(p)
+		},
+	})
+
+	body := strings.Repeat("0123456789", 100000)
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if string(got) != body {
+		t.Errorf("req.Body: got %d bytes, want the %d bytes of the original body", len(got), len(body))
+	}
+	if got, want := req.ContentLength, int64(len(body)); got != want {
+		t.Errorf("req.ContentLength: got %d, want %d", got, want)
+	}
+}
+
+func TestModifyResponse(t *testing.T) {
+	m := newTestModifier(t, &testServer{
+		modifyResponse: func(stream remotepb.ExternalModifier_ModifyResponseServer) error {
+			p, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			head := p.GetHead()
+			if head.GetRequest().GetUrl() != "http://example.com/missing" {
+				return status.Errorf(codes.InvalidArgument, "unexpected request %v", head.Request)
+			}
+
+			// Replace the body with an empty one.
+			return stream.Send(&remotepb.ResponsePart{Part: &remotepb.ResponsePart_Head{Head: &remotepb.ResponseHead{
+				Status:      204,
+				ReplaceBody: true,
+			}}})
+		},
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com/missing", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(404, strings.NewReader("not found"), req)
+	res.Header.Set("X-Remove", "true")
+
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	if got, want := res.StatusCode, 204; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got := res.Header.Get("X-Remove"); got != "" {
+		t.Errorf("res.Header.Get(%q): got %q, want no value", "X-Remove", got)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("res.Body: got %q, want empty body", got)
+	}
+}
+
+func TestModifyRequestErrors(t *testing.T) {
+	tt := []struct {
+		modifyRequest func(remotepb.ExternalModifier_ModifyRequestServer) error
+		want          string
+	}{
+		{
+			modifyRequest: func(remotepb.ExternalModifier_ModifyRequestServer) error {
+				return status.Error(codes.Internal, "boom")
+			},
+			want: "remote: rpc error: code = Internal desc = boom",
+		},
+		{
+			modifyRequest: func(stream remotepb.ExternalModifier_ModifyRequestServer) error {
+				if err := stream.Send(&remotepb.RequestPart{Part: &remotepb.RequestPart_Head{Head: &remotepb.RequestHead{}}}); err != nil {
+					return err
+				}
+				return stream.Send(&remotepb.RequestPart{Part: &remotepb.RequestPart_Body{Body: []byte("body")}})
+			},
+			want: "remote: body part without replace_body",
+		},
+		{
+			modifyRequest: func(stream remotepb.ExternalModifier_ModifyRequestServer) error {
+				return stream.Send(&remotepb.RequestPart{Part: &remotepb.RequestPart_Body{Body: []byte("body")}})
+			},
+			want: "remote: body part before head",
+		},
+		{
+			modifyRequest: func(stream remotepb.ExternalModifier_ModifyRequestServer) error {
+				<-stream.Context().Done()
+				return stream.Context().Err()
+			},
+			want: "remote: rpc error: code = DeadlineExceeded desc = context deadline exceeded",
+		},
+	}
+
+	for i, tc := range tt {
+		m := newTestModifier(t, &testServer{modifyRequest: tc.modifyRequest})
+		m.SetTimeout(100 * time.Millisecond)
+
+		req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("body"))
+		if err != nil {
+			t.Fatalf("http.NewRequest(): got %v, want no error", err)
+		}
+		req.Header.Set("X-Keep", "true")
+
+		err = m.ModifyRequest(req)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%d. ModifyRequest(): got error %v, want %q", i, err, tc.want)
+		}
+
+		// The request is left unchanged.
+		if got, want := req.Header.Get("X-Keep"), "true"; got != want {
+			t.Errorf("%d. req.Header.Get(%q): got %q, want %q", i, "X-Keep", got, want)
+		}
+		got, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if want := "body"; string(got) != want {
+			t.Errorf("%d. req.Body: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+	  "remote.Modifier": {
+	    "scope": ["response"],
+	    "target": "localhost:9090",
+	    "timeoutMs": 250
+	  }
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	if reqmod := r.RequestModifier(); reqmod != nil {
+		t.Error("r.RequestModifier(): got not nil, want nil")
+	}
+	resmod := r.ResponseModifier()
+	if resmod == nil {
+		t.Fatal("r.ResponseModifier(): got nil, want not nil")
+	}
+
+	m := resmod.(*Modifier)
+	if got, want := m.timeout, 250*time.Millisecond; got != want {
+		t.Errorf("m.timeout: got %v, want %v", got, want)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"remote.Modifier": {"scope": ["request"]}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for missing target")
+	}
+}
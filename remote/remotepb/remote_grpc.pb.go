@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package remotepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ExternalModifierClient is the client API for ExternalModifier service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ExternalModifierClient interface {
+	// The proxy sends the head of a request followed by its body in chunks,
+	// and the modifier returns the head of the modified request followed by
+	// its body, if replaced.
+	ModifyRequest(ctx context.Context, opts ...grpc.CallOption) (ExternalModifier_ModifyRequestClient, error)
+	// The proxy sends the head of a response followed by its body in chunks,
+	// and the modifier returns the head of the modified response followed by
+	// its body, if replaced.
+	ModifyResponse(ctx context.Context, opts ...grpc.CallOption) (ExternalModifier_ModifyResponseClient, error)
+}
+
+type externalModifierClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExternalModifierClient(cc grpc.ClientConnInterface) ExternalModifierClient {
+	return &externalModifierClient{cc}
+}
+
+func (c *externalModifierClient) ModifyRequest(ctx context.Context, opts ...grpc.CallOption) (ExternalModifier_ModifyRequestClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ExternalModifier_ServiceDesc.Streams[0], "/martian.remote.ExternalModifier/ModifyRequest", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &externalModifierModifyRequestClient{stream}
+	return x, nil
+}
+
+type ExternalModifier_ModifyRequestClient interface {
+	Send(*RequestPart) error
+	Recv() (*RequestPart, error)
+	grpc.ClientStream
+}
+
+type externalModifierModifyRequestClient struct {
+	grpc.ClientStream
+}
+
+func (x *externalModifierModifyRequestClient) Send(m *RequestPart) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *externalModifierModifyRequestClient) Recv() (*RequestPart, error) {
+	m := new(RequestPart)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *externalModifierClient) ModifyResponse(ctx context.Context, opts ...grpc.CallOption) (ExternalModifier_ModifyResponseClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ExternalModifier_ServiceDesc.Streams[1], "/martian.remote.ExternalModifier/ModifyResponse", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &externalModifierModifyResponseClient{stream}
+	return x, nil
+}
+
+type ExternalModifier_ModifyResponseClient interface {
+	Send(*ResponsePart) error
+	Recv() (*ResponsePart, error)
+	grpc.ClientStream
+}
+
+type externalModifierModifyResponseClient struct {
+	grpc.ClientStream
+}
+
+func (x *externalModifierModifyResponseClient) Send(m *ResponsePart) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *externalModifierModifyResponseClient) Recv() (*ResponsePart, error) {
+	m := new(ResponsePart)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExternalModifierServer is the server API for ExternalModifier service.
+// All implementations must embed UnimplementedExternalModifierServer
+// for forward compatibility
+type ExternalModifierServer interface {
+	// The proxy sends the head of a request followed by its body in chunks,
+	// and the modifier returns the head of the modified request followed by
+	// its body, if replaced.
+	ModifyRequest(ExternalModifier_ModifyRequestServer) error
+	// The proxy sends the head of a response followed by its body in chunks,
+	// and the modifier returns the head of the modified response followed by
+	// its body, if replaced.
+	ModifyResponse(ExternalModifier_ModifyResponseServer) error
+	mustEmbedUnimplementedExternalModifierServer()
+}
+
+// UnimplementedExternalModifierServer must be embedded to have forward compatible implementations.
+type UnimplementedExternalModifierServer struct {
+}
+
+func (UnimplementedExternalModifierServer) ModifyRequest(ExternalModifier_ModifyRequestServer) error {
+	return status.Errorf(codes.Unimplemented, "method ModifyRequest not implemented")
+}
+func (UnimplementedExternalModifierServer) ModifyResponse(ExternalModifier_ModifyResponseServer) error {
+	return status.Errorf(codes.Unimplemented, "method ModifyResponse not implemented")
+}
+func (UnimplementedExternalModifierServer) mustEmbedUnimplementedExternalModifierServer() {}
+
+// UnsafeExternalModifierServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExternalModifierServer will
+// result in compilation errors.
+type UnsafeExternalModifierServer interface {
+	mustEmbedUnimplementedExternalModifierServer()
+}
+
+func RegisterExternalModifierServer(s grpc.ServiceRegistrar, srv ExternalModifierServer) {
+	s.RegisterService(&ExternalModifier_ServiceDesc, srv)
+}
+
+func _ExternalModifier_ModifyRequest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ExternalModifierServer).ModifyRequest(&externalModifierModifyRequestServer{stream})
+}
+
+type ExternalModifier_ModifyRequestServer interface {
+	Send(*RequestPart) error
+	Recv() (*RequestPart, error)
+	grpc.ServerStream
+}
+
+type externalModifierModifyRequestServer struct {
+	grpc.ServerStream
+}
+
+func (x *externalModifierModifyRequestServer) Send(m *RequestPart) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *externalModifierModifyRequestServer) Recv() (*RequestPart, error) {
+	m := new(RequestPart)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ExternalModifier_ModifyResponse_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ExternalModifierServer).ModifyResponse(&externalModifierModifyResponseServer{stream})
+}
+
+type ExternalModifier_ModifyResponseServer interface {
+	Send(*ResponsePart) error
+	Recv() (*ResponsePart, error)
+	grpc.ServerStream
+}
+
+type externalModifierModifyResponseServer struct {
+	grpc.ServerStream
+}
+
+func (x *externalModifierModifyResponseServer) Send(m *ResponsePart) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *externalModifierModifyResponseServer) Recv() (*ResponsePart, error) {
+	m := new(ResponsePart)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExternalModifier_ServiceDesc is the grpc.ServiceDesc for ExternalModifier service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ExternalModifier_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "martian.remote.ExternalModifier",
+	HandlerType: (*ExternalModifierServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ModifyRequest",
+			Handler:       _ExternalModifier_ModifyRequest_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ModifyResponse",
+			Handler:       _ExternalModifier_ModifyResponse_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "remote.proto",
+}
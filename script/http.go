@@ -0,0 +1,379 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package script
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/google/martian/v3"
+	"go.starlark.net/starlark"
+)
+
+var requestAttrs = []string{"body", "headers", "host", "method", "path", "query", "remote_addr", "scheme", "skip_round_trip", "url"}
+
+// requestValue exposes an HTTP request to a script.
+type requestValue struct {
+	req *http.Request
+	// readOnly is set for the request of a response.
+	readOnly bool
+}
+
+var (
+	_ starlark.HasAttrs    = (*requestValue)(nil)
+	_ starlark.HasSetField = (*requestValue)(nil)
+)
+
+func (r *requestValue) Type() string          { return "request" }
+func (r *requestValue) Freeze()               {}
+func (r *requestValue) Truth() starlark.Bool  { return starlark.True }
+func (r *requestValue) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: request") }
+
+func (r *requestValue) String() string {
+	return fmt.Sprintf("<request %s %s>", r.req.Method, r.req.URL)
+}
+
+// AttrNames returns the names of the fields and methods of the request.
+func (r *requestValue) AttrNames() []string {
+	return requestAttrs
+}
+
+// Attr returns the fields and methods of the request.
+func (r *requestValue) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "method":
+		return starlark.String(r.req.Method), nil
+	case "url":
+		return starlark.String(r.req.URL.String()), nil
+	case "scheme":
+		return starlark.String(r.req.URL.Scheme), nil
+	case "host":
+		return starlark.String(r.req.Host), nil
+	case "path":
+		return starlark.String(r.req.URL.Path), nil
+	case "query":
+		return starlark.String(r.req.URL.RawQuery), nil
+	case "remote_addr":
+		return starlark.String(r.req.RemoteAddr), nil
+	case "headers":
+		return &headerValue{h: r.req.Header, readOnly: r.readOnly}, nil
+	case "body":
+		b, err := readBody(&r.req.Body)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.String(b), nil
+	case "skip_round_trip":
+		if r.readOnly {
+			return nil, nil
+		}
+		return starlark.NewBuiltin(name, requestSkipRoundTrip).BindReceiver(r), nil
+	}
+
+	return nil, nil
+}
+
+// SetField sets the fields of the request.
+func (r *requestValue) SetField(name string, v starlark.Value) error {
+	if r.readOnly {
+		return fmt.Errorf("cannot assign to field %s of the request of a response", name)
+	}
+
+	s, ok := v.(starlark.String)
+	if !ok {
+		return fmt.Errorf("cannot assign %s to field %s, want string", v.Type(), name)
+	}
+
+	switch name {
+	case "body":
+		if r.req.Body != nil {
+			r.req.Body.Close()
+		}
+		r.req.Body = ioutil.NopCloser(bytes.NewReader([]byte(s)))
+		r.req.ContentLength = int64(len(s))
+		r.req.Header.Del("Content-Encoding")
+	case "method":
+		r.req.Method = string(s)
+	case "url":
+		u, err := url.Parse(string(s))
+		if err != nil {
+			return err
+		}
+		r.req.URL = u
+		r.req.Host = u.Host
+	case "scheme":
+		r.req.URL.Scheme = string(s)
+	case "host":
+		r.req.URL.Host = string(s)
+		r.req.Host = string(s)
+	case "path":
+		r.req.URL.Path = string(s)
+		r.req.URL.RawPath = ""
+	case "query":
+		r.req.URL.RawQuery = string(s)
+	default:
+		return fmt.Errorf("cannot assign to field %s of request", name)
+	}
+
+	return nil
+}
+
+func requestSkipRoundTrip(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+
+	ctx := martian.NewContext(b.Receiver().(*requestValue).req)
+	if ctx == nil {
+		return nil, fmt.Errorf("%s: request has no context", b.Name())
+	}
+	ctx.SkipRoundTrip()
+
+	return starlark.None, nil
+}
+
+var responseAttrs = []string{"body", "headers", "request", "status"}
+
+// responseValue exposes an HTTP response to a script.
+type responseValue struct {
+	res *http.Response
+}
+
+var (
+	_ starlark.HasAttrs    = (*responseValue)(nil)
+	_ starlark.HasSetField = (*responseValue)(nil)
+)
+
+func (r *responseValue) Type() string          { return "response" }
+func (r *responseValue) Freeze()               {}
+func (r *responseValue) Truth() starlark.Bool  { return starlark.True }
+func (r *responseValue) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: response") }
+
+func (r *responseValue) String() string {
+	return fmt.Sprintf("<response %d>", r.res.StatusCode)
+}
+
+// AttrNames returns the names of the fields of the response.
+func (r *responseValue) AttrNames() []string {
+	return responseAttrs
+}
+
+// Attr returns the fields of the response.
+func (r *responseValue) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "status":
+		return starlark.MakeInt(r.res.StatusCode), nil
+	case "headers":
+		return &headerValue{h: r.res.Header}, nil
+	case "body":
+		b, err := readBody(&r.res.Body)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.String(b), nil
+	case "request":
+		if r.res.Request == nil {
+			return starlark.None, nil
+		}
+		return &requestValue{req: r.res.Request, readOnly: true}, nil
+	}
+
+	return nil, nil
+}
+
+// SetField sets the fields of the response.
+func (r *responseValue) SetField(name string, v starlark.Value) error {
+	switch name {
+	case "status":
+		i, ok := v.(starlark.Int)
+		if !ok {
+			return fmt.Errorf("cannot assign %s to field status, want int", v.Type())
+		}
+		code, ok := i.Int64()
+		if !ok || code < 100 || code > 999 {
+			return fmt.Errorf("invalid status %s", i)
+		}
+		r.res.StatusCode = int(code)
+		r.res.Status = fmt.Sprintf("%d %s", code, http.StatusText(int(code)))
+	case "body":
+		b, ok := v.(starlark.String)
+		if !ok {
+			return fmt.Errorf("cannot assign %s to field body, want string", v.Type())
+		}
+		if r.res.Body != nil {
+			r.res.Body.Close()
+		}
+		r.res.Body = ioutil.NopCloser(bytes.NewReader([]byte(b)))
+		r.res.ContentLength = int64(len(b))
+		r.res.TransferEncoding = nil
+		r.res.Header.Del("Content-Encoding")
+	default:
+		return fmt.Errorf("cannot assign to field %s of response", name)
+	}
+
+	return nil
+}
+
+// readBody reads the body, which is replaced with the bytes read so that it
+// can be read again.
+func readBody(body *io.ReadCloser) (string, error) {
+	if *body == nil || *body == http.NoBody {
+		return "", nil
+	}
+
+	b, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	*body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return "", fmt.Errorf("reading body: %v", err)
+	}
+
+	return string(b), nil
+}
+
+var (
+	headerAttrs         = []string{"add", "delete", "get", "keys", "set", "values"}
+	readOnlyHeaderAttrs = []string{"get", "keys", "values"}
+)
+
+// headerValue exposes the header of a request or response to a script.
+type headerValue struct {
+	h        http.Header
+	readOnly bool
+}
+
+var (
+	_ starlark.HasAttrs = (*headerValue)(nil)
+	_ starlark.Mapping  = (*headerValue)(nil)
+)
+
+func (h *headerValue) Type() string          { return "headers" }
+func (h *headerValue) Freeze()               {}
+func (h *headerValue) Truth() starlark.Bool  { return starlark.Bool(len(h.h) > 0) }
+func (h *headerValue) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: headers") }
+
+func (h *headerValue) String() string {
+	return fmt.Sprintf("<headers %v>", h.h)
+}
+
+// Get returns the first value of the field named k, so that the header
+// supports "name in headers" and headers[name].
+func (h *headerValue) Get(k starlark.Value) (starlark.Value, bool, error) {
+	name, ok := k.(starlark.String)
+	if !ok {
+		return nil, false, fmt.Errorf("header name must be string, not %s", k.Type())
+	}
+
+	vs := h.h.Values(string(name))
+	if len(vs) == 0 {
+		return nil, false, nil
+	}
+	return starlark.String(vs[0]), true, nil
+}
+
+// AttrNames returns the names of the methods of the header.
+func (h *headerValue) AttrNames() []string {
+	if h.readOnly {
+		return readOnlyHeaderAttrs
+	}
+	return headerAttrs
+}
+
+// Attr returns the methods of the header.
+func (h *headerValue) Attr(name string) (starlark.Value, error) {
+	var fn func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error)
+	switch name {
+	case "get":
+		fn = headerGet
+	case "values":
+		fn = headerValues
+	case "keys":
+		fn = headerKeys
+	case "set", "add", "delete":
+		if h.readOnly {
+			return nil, nil
+		}
+		fn = headerSet
+	default:
+		return nil, nil
+	}
+
+	return starlark.NewBuiltin(name, fn).BindReceiver(h), nil
+}
+
+func headerGet(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var dflt starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "default?", &dflt); err != nil {
+		return nil, err
+	}
+
+	vs := b.Receiver().(*headerValue).h.Values(name)
+	if len(vs) > 0 {
+		return starlark.String(vs[0]), nil
+	}
+	return dflt, nil
+}
+
+func headerValues(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+
+	vs := b.Receiver().(*headerValue).h.Values(name)
+	l := make([]starlark.Value, len(vs))
+	for i, v := range vs {
+		l[i] = starlark.String(v)
+	}
+	return starlark.NewList(l), nil
+}
+
+func headerKeys(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+
+	h := b.Receiver().(*headerValue).h
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	l := make([]starlark.Value, len(names))
+	for i, name := range names {
+		l[i] = starlark.String(name)
+	}
+	return starlark.NewList(l), nil
+}
+
+// headerSet implements set, add and delete.
+func headerSet(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	h := b.Receiver().(*headerValue).h
+
+	var name, value string
+	if b.Name() == "delete" {
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+			return nil, err
+		}
+		h.Del(name)
+		return starlark.None, nil
+	}
+
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "value", &value); err != nil {
+		return nil, err
+	}
+	if b.Name() == "set" {
+		h.Set(name, value)
+	} else {
+		h.Add(name, value)
+	}
+
+	return starlark.None, nil
+}
@@ -0,0 +1,231 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package script provides a modifier that runs a user-provided script on
+// requests and responses, so that simple transformations can be configured
+// without recompiling the proxy.
+//
+// Scripts are written in Starlark, a dialect of Python, as implemented by
+// go.starlark.net with its default dialect options: while loops, recursion
+// and reassigning globals are not allowed, and load is not supported.
+//
+// A script defines the function modify_request(req), modify_response(res) or
+// both, which are called for each request and response. The globals of a
+// script are frozen once it is loaded, so that calls do not share state. A
+// call fails if it executes more than a million steps.
+//
+// A request has the fields method, url, scheme, host, path, query and body,
+// which may be assigned strings, the read-only field remote_addr, the field
+// headers and the method skip_round_trip(), which skips the round trip of
+// the request. A response has the fields status, an int, and body, which may
+// be assigned, the field headers and the field request, the read-only request
+// of the response. Headers have the methods get(name, default=None),
+// values(name), keys(), set(name, value), add(name, value) and delete(name),
+// and support "name in headers". Bodies are strings of the bytes of the
+// message as sent, without decoding its Content-Encoding; assigning a body
+// sets the Content-Length and removes the Content-Encoding.
+//
+// For example:
+//
+//	def modify_request(req):
+//	    if req.path.startswith("/api/"):
+//	        req.headers.set("Authorization", "Bearer test")
+//	    if req.method == "OPTIONS":
+//	        req.skip_round_trip()
+//
+//	def modify_response(res):
+//	    if res.status == 404 and res.request.path == "/health":
+//	        res.status = 200
+//	        res.body = "ok"
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// maxSteps is the maximum number of steps a call of a script executes.
+const maxSteps = 1000000
+
+func init() {
+	parse.Register("script.Modifier", modifierFromJSON)
+}
+
+// Modifier runs the functions of a script on requests and responses.
+type Modifier struct {
+	reqfn *starlark.Function
+	resfn *starlark.Function
+}
+
+type modifierJSON struct {
+	Script string               `json:"script"`
+	File   string               `json:"file"`
+	Scope  []parse.ModifierType `json:"scope"`
+}
+
+// NewModifier loads the script src and returns a Modifier calling its
+// modify_request and modify_response functions. It returns an error if the
+// script fails to parse or its top-level statements fail.
+func NewModifier(src string) (*Modifier, error) {
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, newThread("load"), "script", src, nil)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+
+	m := &Modifier{}
+	for name, fn := range map[string]**starlark.Function{
+		"modify_request":  &m.reqfn,
+		"modify_response": &m.resfn,
+	} {
+		v, ok := globals[name]
+		if !ok {
+			continue
+		}
+		f, ok := v.(*starlark.Function)
+		if !ok || !takesOneArg(f) {
+			return nil, fmt.Errorf("script: %s must be a function of one argument", name)
+		}
+		*fn = f
+	}
+
+	return m, nil
+}
+
+// takesOneArg reports whether f can be called with a single positional
+// argument.
+func takesOneArg(f *starlark.Function) bool {
+	n := f.NumParams() - f.NumKwonlyParams()
+	if f.HasVarargs() {
+		n--
+	}
+	if f.HasKwargs() {
+		n--
+	}
+	if n == 0 && !f.HasVarargs() {
+		return false
+	}
+	for i := 1; i < n; i++ {
+		if f.ParamDefault(i) == nil {
+			return false
+		}
+	}
+	for i := n; i < n+f.NumKwonlyParams(); i++ {
+		if f.ParamDefault(i) == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ModifyRequest calls the modify_request function of the script, if any,
+// with req.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	if m.reqfn == nil {
+		return nil
+	}
+
+	_, err := starlark.Call(newThread("modify_request"), m.reqfn, starlark.Tuple{&requestValue{req: req}}, nil)
+	return scriptError(err)
+}
+
+// ModifyResponse calls the modify_response function of the script, if any,
+// with res.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if m.resfn == nil {
+		return nil
+	}
+
+	_, err := starlark.Call(newThread("modify_response"), m.resfn, starlark.Tuple{&responseValue{res: res}}, nil)
+	return scriptError(err)
+}
+
+// newThread returns a thread for a call of a script, printing to the log.
+func newThread(name string) *starlark.Thread {
+	th := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Infof("script: %s", msg)
+		},
+	}
+	th.SetMaxExecutionSteps(maxSteps)
+
+	return th
+}
+
+// scriptError returns err prefixed with the line of the script it occurred
+// on, if known.
+func scriptError(err error) error {
+	var (
+		line int32
+		msg  string
+	)
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *starlark.EvalError:
+		// The innermost frames may be builtins, which have no position.
+		for i := range e.CallStack {
+			if fr := e.CallStack.At(i); fr.Pos.Line > 0 {
+				line = fr.Pos.Line
+				break
+			}
+		}
+		msg = e.Msg
+	case syntax.Error:
+		line, msg = e.Pos.Line, e.Msg
+	case resolve.ErrorList:
+		line, msg = e[0].Pos.Line, e[0].Msg
+	default:
+		return fmt.Errorf("script: %v", err)
+	}
+
+	if line == 0 {
+		return fmt.Errorf("script: %s", msg)
+	}
+	return fmt.Errorf("script: line %d: %s", line, msg)
+}
+
+// modifierFromJSON builds a script.Modifier from JSON. The script is given
+// inline with "script" or read from "file".
+//
+// Example JSON:
+//
+//	{
+//	  "script.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "script": "def modify_request(req):\n    req.headers.set(\"X-Scripted\", \"true\")\n"
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	src := msg.Script
+	switch {
+	case msg.File != "" && msg.Script != "":
+		return nil, fmt.Errorf("script: only one of script and file may be set")
+	case msg.File != "":
+		b, err := ioutil.ReadFile(msg.File)
+		if err != nil {
+			return nil, fmt.Errorf("script: %v", err)
+		}
+		src = string(b)
+	}
+
+	mod, err := NewModifier(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,238 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package script
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestModifyRequest(t *testing.T) {
+	m, err := NewModifier(`
+HOSTS = {"old.example.com": "new.example.com"}
+
+def modify_request(req):
+    req.host = HOSTS.get(req.host, req.host)
+    if "X-Remove" in req.headers:
+        req.headers.delete("X-Remove")
+    req.headers.add("Via", "script")
+    if req.method == "POST":
+        req.body = req.body.upper()
+    if req.path == "/skip":
+        req.skip_round_trip()
+`)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("POST", "http://old.example.com/skip?a=b", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-Remove", "true")
+	req.Header.Set("Via", "1.1 martian")
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got, want := req.Host, "new.example.com"; got != want {
+		t.Errorf("req.Host: got %q, want %q", got, want)
+	}
+	if got, want := req.URL.String(), "http://new.example.com/skip?a=b"; got != want {
+		t.Errorf("req.URL: got %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Remove"); got != "" {
+		t.Errorf("req.Header.Get(%q): got %q, want no value", "X-Remove", got)
+	}
+	if got, want := req.Header.Values("Via"), []string{"1.1 martian", "script"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("req.Header.Values(%q): got %v, want %v", "Via", got, want)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "BODY"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+	if got, want := req.ContentLength, int64(4); got != want {
+		t.Errorf("req.ContentLength: got %d, want %d", got, want)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got false, want true")
+	}
+}
+
+func TestModifyResponse(t *testing.T) {
+	m, err := NewModifier(`
+def modify_response(res):
+    if res.status == 404 and res.request.path == "/health":
+        res.status = 200
+        res.body = "ok"
+    res.headers.set("X-Status", str(res.status))
+`)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/health", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(404, strings.NewReader("not found"), req)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	if got, want := res.StatusCode, 200; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("X-Status"), "200"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Status", got, want)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "ok"; string(got) != want {
+		t.Errorf("res.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestModifyResponseReadOnlyRequest(t *testing.T) {
+	m, err := NewModifier(`
+def modify_response(res):
+    res.request.path = "/other"
+`)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(200, nil, req)
+
+	err = m.ModifyResponse(res)
+	if err == nil {
+		t.Fatal("ModifyResponse(): got nil, want error")
+	}
+	if got, want := err.Error(), "script: line 3: cannot assign to field path of the request of a response"; got != want {
+		t.Errorf("err.Error(): got %q, want %q", got, want)
+	}
+}
+
+func TestNewModifierErrors(t *testing.T) {
+	tt := []struct {
+		src  string
+		want string
+	}{
+		{"def modify_request(req)\n    pass", `script: line 2: got newline, want ':'`},
+		{"fail(\"bad config\")", `script: line 1: fail: bad config`},
+		{"modify_request = 1", `script: modify_request must be a function of one argument`},
+		{"def modify_response():\n    pass", `script: modify_response must be a function of one argument`},
+	}
+
+	for i, tc := range tt {
+		_, err := NewModifier(tc.src)
+		if err == nil {
+			t.Errorf("%d. NewModifier(): got nil, want error", i)
+			continue
+		}
+		if got := err.Error(); got != tc.want {
+			t.Errorf("%d. NewModifier(): got error %q, want %q", i, got, tc.want)
+		}
+	}
+}
+
+func TestModifierMaxSteps(t *testing.T) {
+	m, err := NewModifier(`
+def modify_request(req):
+    for i in range(10000000):
+        pass
+`)
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := m.ModifyRequest(req); err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("ModifyRequest(): got %v, want too many steps error", err)
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+	  "script.Modifier": {
+	    "scope": ["request"],
+	    "script": "def modify_request(req):\n    req.headers.set(\"X-Scripted\", \"true\")\n"
+	  }
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("r.RequestModifier(): got nil, want not nil")
+	}
+	if resmod := r.ResponseModifier(); resmod != nil {
+		t.Error("r.ResponseModifier(): got not nil, want nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Scripted"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Scripted", got, want)
+	}
+}
+
+func TestModifierFromJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "modify.star")
+	src := "def modify_response(res):\n    res.status = 201\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	msg := []byte(`{
+	  "script.Modifier": {
+	    "scope": ["response"],
+	    "file": "` + path + `"
+	  }
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(200, nil, nil)
+	if err := r.ResponseModifier().ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+	if got, want := res.StatusCode, 201; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+}
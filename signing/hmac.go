@@ -0,0 +1,244 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/martian/v3/parse"
+)
+
+// DefaultSignatureHeader is the default header that carries the signature.
+const DefaultSignatureHeader = "X-Signature"
+
+// DefaultTimestampHeader is the default header that carries the time of
+// signing, when it is signed.
+const DefaultTimestampHeader = "X-Timestamp"
+
+var hashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func init() {
+	parse.Register("signing.HMAC", hmacFromJSON)
+}
+
+// HMACModifier signs requests with an HMAC of their components, joined by
+// newlines, and sets the signature in a header. The components are:
+//
+//	method       the request method
+//	path         the escaped path of the URL
+//	query        the raw query of the URL
+//	host         the host the request is sent to
+//	body         the body
+//	timestamp    the time of signing, in seconds since the Unix epoch, which
+//	             is also set in the timestamp header
+//	header:Name  the values of the header Name, joined by commas
+type HMACModifier struct {
+	key             []byte
+	hash            func() hash.Hash
+	components      []string
+	header          string
+	prefix          string
+	timestampHeader string
+	base64          bool
+	maxBody         int64
+}
+
+type hmacJSON struct {
+	Key             string               `json:"key"`
+	Hash            string               `json:"hash"`
+	Components      []string             `json:"components"`
+	Header          string               `json:"header"`
+	Prefix          string               `json:"prefix"`
+	TimestampHeader string               `json:"timestampHeader"`
+	Encoding        string               `json:"encoding"`
+	MaxBodyBytes    int64                `json:"maxBodyBytes"`
+	Scope           []parse.ModifierType `json:"scope"`
+}
+
+// NewHMACModifier returns an HMACModifier that signs the method, path, query
+// and body of requests with an HMAC-SHA256 keyed with key, and sets the
+// signature hex encoded in the X-Signature header.
+func NewHMACModifier(key []byte) *HMACModifier {
+	return &HMACModifier{
+		key:             key,
+		hash:            sha256.New,
+		components:      []string{"method", "path", "query", "body"},
+		header:          DefaultSignatureHeader,
+		timestampHeader: DefaultTimestampHeader,
+		maxBody:         DefaultMaxBodySize,
+	}
+}
+
+// SetHash sets the hash function of the HMAC: sha1, sha256 or sha512.
+func (m *HMACModifier) SetHash(name string) error {
+	h, ok := hashes[name]
+	if !ok {
+		return fmt.Errorf("signing: unknown hash %q", name)
+	}
+	m.hash = h
+
+	return nil
+}
+
+// SetComponents sets the components of requests that are signed, in order.
+func (m *HMACModifier) SetComponents(components ...string) error {
+	for _, c := range components {
+		switch {
+		case c == "method", c == "path", c == "query", c == "host", c == "body", c == "timestamp":
+		case strings.HasPrefix(c, "header:") && len(c) > len("header:"):
+		default:
+			return fmt.Errorf("signing: unknown component %q", c)
+		}
+	}
+	m.components = components
+
+	return nil
+}
+
+// SetHeader sets the header that carries the signature, and the prefix of
+// the signature in it, such as "sha256=".
+func (m *HMACModifier) SetHeader(name, prefix string) {
+	m.header = name
+	m.prefix = prefix
+}
+
+// SetTimestampHeader sets the header that carries the time of signing when
+// the timestamp is a component.
+func (m *HMACModifier) SetTimestampHeader(name string) {
+	m.timestampHeader = name
+}
+
+// SetBase64 sets whether the signature is base64 encoded rather than hex
+// encoded.
+func (m *HMACModifier) SetBase64(base64 bool) {
+	m.base64 = base64
+}
+
+// SetMaxBodySize sets the size of the largest body that is signed; requests
+// with larger bodies fail.
+func (m *HMACModifier) SetMaxBodySize(size int64) {
+	m.maxBody = size
+}
+
+// ModifyRequest signs req and sets the signature header.
+func (m *HMACModifier) ModifyRequest(req *http.Request) error {
+	values := make([]string, len(m.components))
+	for i, c := range m.components {
+		switch c {
+		case "method":
+			values[i] = req.Method
+		case "path":
+			values[i] = req.URL.EscapedPath()
+		case "query":
+			values[i] = req.URL.RawQuery
+		case "host":
+			values[i] = requestHost(req)
+		case "body":
+			b, err := readBody(req, m.maxBody)
+			if err != nil {
+				return fmt.Errorf("signing: %v", err)
+			}
+			values[i] = string(b)
+		case "timestamp":
+			values[i] = strconv.FormatInt(clock().Unix(), 10)
+			req.Header.Set(m.timestampHeader, values[i])
+		default:
+			values[i] = strings.Join(req.Header.Values(strings.TrimPrefix(c, "header:")), ",")
+		}
+	}
+
+	mac := hmac.New(m.hash, m.key)
+	mac.Write([]byte(strings.Join(values, "\n")))
+	sum := mac.Sum(nil)
+
+	sig := hex.EncodeToString(sum)
+	if m.base64 {
+		sig = base64.StdEncoding.EncodeToString(sum)
+	}
+	req.Header.Set(m.header, m.prefix+sig)
+
+	return nil
+}
+
+// hmacFromJSON builds a signing.HMACModifier from JSON. "key" is the signing
+// key, best given as a reference to a secret file, see parse.Expand. "hash"
+// is sha1, sha256 or sha512, "encoding" is hex or base64, and the other
+// fields override the defaults of NewHMACModifier.
+//
+// Example JSON:
+//
+//	{
+//	  "signing.HMAC": {
+//	    "scope": ["request"],
+//	    "key": "${file:/run/secrets/api-key}",
+//	    "hash": "sha256",
+//	    "components": ["method", "path", "timestamp", "header:Content-Type", "body"],
+//	    "header": "X-Hub-Signature",
+//	    "prefix": "sha256=",
+//	    "timestampHeader": "X-Hub-Timestamp",
+//	    "encoding": "hex",
+//	    "maxBodyBytes": 1048576
+//	  }
+//	}
+func hmacFromJSON(b []byte) (*parse.Result, error) {
+	msg := &hmacJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if msg.Key == "" {
+		return nil, errors.New("signing: key must be set")
+	}
+	if msg.MaxBodyBytes < 0 {
+		return nil, errors.New("signing: maxBodyBytes must not be negative")
+	}
+
+	mod := NewHMACModifier([]byte(msg.Key))
+	if msg.Hash != "" {
+		if err := mod.SetHash(msg.Hash); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Components != nil {
+		if err := mod.SetComponents(msg.Components...); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Header != "" || msg.Prefix != "" {
+		header := msg.Header
+		if header == "" {
+			header = DefaultSignatureHeader
+		}
+		mod.SetHeader(header, msg.Prefix)
+	}
+	if msg.TimestampHeader != "" {
+		mod.SetTimestampHeader(msg.TimestampHeader)
+	}
+	switch msg.Encoding {
+	case "", "hex":
+	case "base64":
+		mod.SetBase64(true)
+	default:
+		return nil, fmt.Errorf("signing: unknown encoding %q", msg.Encoding)
+	}
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+)
+
+func TestHMACModifierDefaults(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://example.com/path?q=1", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	mod := NewHMACModifier([]byte("key"))
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("POST\n/path\nq=1\nbody"))
+	if got, want := req.Header.Get("X-Signature"), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Signature", got, want)
+	}
+
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "body"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestHMACModifierComponents(t *testing.T) {
+	setClock(t, time.Unix(1500000000, 0))
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Add("X-Tenant", "a")
+	req.Header.Add("X-Tenant", "b")
+
+	mod := NewHMACModifier([]byte("key"))
+	if err := mod.SetHash("sha512"); err != nil {
+		t.Fatalf("SetHash(): got %v, want no error", err)
+	}
+	if err := mod.SetComponents("host", "timestamp", "header:X-Tenant", "header:X-Missing"); err != nil {
+		t.Fatalf("SetComponents(): got %v, want no error", err)
+	}
+	mod.SetHeader("Authorization", "HMAC ")
+	mod.SetTimestampHeader("X-Signed-At")
+	mod.SetBase64(true)
+
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got, want := req.Header.Get("X-Signed-At"), "1500000000"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Signed-At", got, want)
+	}
+
+	mac := hmac.New(sha512.New, []byte("key"))
+	mac.Write([]byte("example.com\n1500000000\na,b\n"))
+	if got, want := req.Header.Get("Authorization"), "HMAC "+base64.StdEncoding.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "Authorization", got, want)
+	}
+}
+
+func TestHMACModifierErrors(t *testing.T) {
+	mod := NewHMACModifier([]byte("key"))
+	if err := mod.SetHash("md5"); err == nil {
+		t.Errorf("SetHash(%q): got nil, want error", "md5")
+	}
+	if err := mod.SetComponents("method", "cookie"); err == nil {
+		t.Error("SetComponents(): got nil, want error")
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("too long"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	mod.SetMaxBodySize(4)
+	if err := mod.ModifyRequest(req); err == nil {
+		t.Error("ModifyRequest(): got nil, want error for body over limit")
+	}
+}
+
+func TestHMACFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"signing.HMAC": {
+			"scope": ["request"],
+			"key": "key",
+			"hash": "sha256",
+			"components": ["method", "path"],
+			"header": "X-Hub-Signature",
+			"prefix": "sha256="
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/hook", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("GET\n/hook"))
+	if got, want := req.Header.Get("X-Hub-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Hub-Signature", got, want)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"signing.HMAC": {"scope": ["request"], "key": "key", "encoding": "base32"}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for unknown encoding")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package signing provides modifiers that sign requests on their way to APIs
+// that require request signatures: HMACModifier signs configurable parts of
+// the request with a shared key, and SigV4Modifier signs it with AWS
+// Signature Version 4.
+//
+// Signing a body requires reading it into memory, up to a limit, before the
+// request is sent.
+package signing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// DefaultMaxBodySize is the default size of the largest body that is signed.
+const DefaultMaxBodySize = 10 << 20
+
+// readBody reads the body of req, which is restored so that it can be read
+// again. It fails if the body is larger than max.
+func readBody(req *http.Request, max int64) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	orig := req.Body
+	b, err := ioutil.ReadAll(io.LimitReader(orig, max+1))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), orig), orig}
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %v", err)
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("body exceeds limit of %d bytes", max)
+	}
+
+	return b, nil
+}
+
+// requestHost returns the host the request is sent to.
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+
+	return req.URL.Host
+}
+
+// clock returns the current time; tests replace it.
+var clock = time.Now
@@ -0,0 +1,285 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/martian/v3/parse"
+)
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat  = "20060102T150405Z"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateHeader    = "X-Amz-Date"
+	amzTokenHeader   = "X-Amz-Security-Token"
+	amzContentHeader = "X-Amz-Content-Sha256"
+)
+
+func init() {
+	parse.Register("signing.SigV4", sigV4FromJSON)
+}
+
+// SigV4Modifier signs requests with AWS Signature Version 4, setting the
+// Authorization and X-Amz-Date headers, and X-Amz-Security-Token for
+// temporary credentials. It signs the host, the Content-Type and Content-MD5
+// headers and the X-Amz- headers of the request. Requests to s3 also carry
+// the hash of their payload in X-Amz-Content-Sha256, and their path is not
+// escaped again.
+type SigV4Modifier struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+	service         string
+	unsigned        bool
+	maxBody         int64
+}
+
+type sigV4JSON struct {
+	AccessKeyID     string               `json:"accessKeyId"`
+	SecretAccessKey string               `json:"secretAccessKey"`
+	SessionToken    string               `json:"sessionToken"`
+	Region          string               `json:"region"`
+	Service         string               `json:"service"`
+	UnsignedPayload bool                 `json:"unsignedPayload"`
+	MaxBodyBytes    int64                `json:"maxBodyBytes"`
+	Scope           []parse.ModifierType `json:"scope"`
+}
+
+// NewSigV4Modifier returns a SigV4Modifier that signs requests to service in
+// region with the given credentials.
+func NewSigV4Modifier(accessKeyID, secretAccessKey, region, service string) *SigV4Modifier {
+	return &SigV4Modifier{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		region:          region,
+		service:         service,
+		maxBody:         DefaultMaxBodySize,
+	}
+}
+
+// SetSessionToken sets the session token of temporary credentials.
+func (m *SigV4Modifier) SetSessionToken(token string) {
+	m.sessionToken = token
+}
+
+// SetUnsignedPayload sets whether the body is left out of the signature, as
+// s3 allows, so that it does not need to be read into memory.
+func (m *SigV4Modifier) SetUnsignedPayload(unsigned bool) {
+	m.unsigned = unsigned
+}
+
+// SetMaxBodySize sets the size of the largest body that is signed; requests
+// with larger bodies fail.
+func (m *SigV4Modifier) SetMaxBodySize(size int64) {
+	m.maxBody = size
+}
+
+// ModifyRequest signs req.
+func (m *SigV4Modifier) ModifyRequest(req *http.Request) error {
+	payload := unsignedPayload
+	if !m.unsigned {
+		b, err := readBody(req, m.maxBody)
+		if err != nil {
+			return fmt.Errorf("signing: %v", err)
+		}
+		sum := sha256.Sum256(b)
+		payload = hex.EncodeToString(sum[:])
+	}
+
+	now := clock().UTC()
+	date := now.Format("20060102")
+	req.Header.Set(amzDateHeader, now.Format(sigV4TimeFormat))
+	if m.sessionToken != "" {
+		req.Header.Set(amzTokenHeader, m.sessionToken)
+	}
+	if m.service == "s3" || m.unsigned {
+		req.Header.Set(amzContentHeader, payload)
+	}
+
+	headers, signed := m.canonicalHeaders(req)
+	creq := strings.Join([]string{
+		req.Method,
+		m.canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		headers,
+		signed,
+		payload,
+	}, "\n")
+
+	scope := strings.Join([]string{date, m.region, m.service, "aws4_request"}, "/")
+	csum := sha256.Sum256([]byte(creq))
+	sts := strings.Join([]string{
+		sigV4Algorithm,
+		now.Format(sigV4TimeFormat),
+		scope,
+		hex.EncodeToString(csum[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+m.secretAccessKey), date)
+	for _, s := range []string{m.region, m.service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, sts))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, m.accessKeyID, scope, signed, sig))
+
+	return nil
+}
+
+// canonicalURI returns the path of u, escaped once for s3 and twice for other
+// services.
+func (m *SigV4Modifier) canonicalURI(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	if m.service == "s3" {
+		return p
+	}
+
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = uriEncode(seg)
+	}
+
+	return strings.Join(segs, "/")
+}
+
+// canonicalHeaders returns the canonical headers of req, each followed by a
+// newline, and the names of the signed headers.
+func (m *SigV4Modifier) canonicalHeaders(req *http.Request) (string, string) {
+	values := map[string]string{
+		"host": requestHost(req),
+	}
+	for name, vs := range req.Header {
+		lname := strings.ToLower(name)
+		if lname != "content-type" && lname != "content-md5" && !strings.HasPrefix(lname, "x-amz-") {
+			continue
+		}
+
+		trimmed := make([]string, len(vs))
+		for i, v := range vs {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[lname] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(values[name])
+		b.WriteByte('\n')
+	}
+
+	return b.String(), strings.Join(names, ";")
+}
+
+// canonicalQuery returns the query of u with its parameters sorted and
+// escaped.
+func canonicalQuery(u *url.URL) string {
+	query, _ := url.ParseQuery(u.RawQuery)
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, k := range keys {
+		vs := append([]string(nil), query[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			params = append(params, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// uriEncode escapes every byte of s other than the unreserved characters of
+// RFC 3986.
+func uriEncode(s string) string {
+	const hexdigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexdigits[c>>4])
+			b.WriteByte(hexdigits[c&0xf])
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sigV4FromJSON builds a signing.SigV4Modifier from JSON. The credentials are
+// best given as references to environment variables or secret files, see
+// parse.Expand.
+//
+// Example JSON:
+//
+//	{
+//	  "signing.SigV4": {
+//	    "scope": ["request"],
+//	    "accessKeyId": "${AWS_ACCESS_KEY_ID}",
+//	    "secretAccessKey": "${AWS_SECRET_ACCESS_KEY}",
+//	    "sessionToken": "${AWS_SESSION_TOKEN:-}",
+//	    "region": "us-east-1",
+//	    "service": "s3",
+//	    "unsignedPayload": true
+//	  }
+//	}
+func sigV4FromJSON(b []byte) (*parse.Result, error) {
+	msg := &sigV4JSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if msg.AccessKeyID == "" || msg.SecretAccessKey == "" || msg.Region == "" || msg.Service == "" {
+		return nil, errors.New("signing: accessKeyId, secretAccessKey, region and service must be set")
+	}
+	if msg.MaxBodyBytes < 0 {
+		return nil, errors.New("signing: maxBodyBytes must not be negative")
+	}
+
+	mod := NewSigV4Modifier(msg.AccessKeyID, msg.SecretAccessKey, msg.Region, msg.Service)
+	mod.SetSessionToken(msg.SessionToken)
+	mod.SetUnsignedPayload(msg.UnsignedPayload)
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package signing
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+)
+
+// setClock sets the time of signing to t for the duration of the test.
+func setClock(t *testing.T, now time.Time) {
+	t.Helper()
+
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+}
+
+func TestSigV4ModifierTestSuite(t *testing.T) {
+	// Vectors of the AWS Signature Version 4 test suite.
+	setClock(t, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	tt := []struct {
+		url     string
+		service string
+		headers map[string]string
+		want    string
+	}{
+		{
+			url:     "http://example.amazonaws.com/",
+			service: "service",
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			url:     "http://example.amazonaws.com/?Param2=value2&Param1=value1",
+			service: "service",
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			url:     "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			service: "iam",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+
+	for i, tc := range tt {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+
+		mod := NewSigV4Modifier("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", tc.service)
+		if err := mod.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+
+		if got, want := req.Header.Get("X-Amz-Date"), "20150830T123600Z"; got != want {
+			t.Errorf("%d. req.Header.Get(%q): got %q, want %q", i, "X-Amz-Date", got, want)
+		}
+		if got := req.Header.Get("Authorization"); got != tc.want {
+			t.Errorf("%d. req.Header.Get(%q): got %q, want %q", i, "Authorization", got, tc.want)
+		}
+	}
+}
+
+func TestSigV4ModifierS3(t *testing.T) {
+	setClock(t, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	req, err := http.NewRequest("PUT", "http://bucket.s3.amazonaws.com/a%20key", strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	mod := NewSigV4Modifier("AKIDEXAMPLE", "secret", "us-east-1", "s3")
+	mod.SetSessionToken("token")
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	// The SHA-256 of "content".
+	if got, want := req.Header.Get("X-Amz-Content-Sha256"), "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Amz-Content-Sha256", got, want)
+	}
+	if got, want := req.Header.Get("X-Amz-Security-Token"), "token"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Amz-Security-Token", got, want)
+	}
+	if got, want := req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,"; !strings.Contains(got, want) {
+		t.Errorf("req.Header.Get(%q): got %q, want it to contain %q", "Authorization", got, want)
+	}
+	if got, want := mod.canonicalURI(req.URL), "/a%20key"; got != want {
+		t.Errorf("mod.canonicalURI(): got %q, want %q", got, want)
+	}
+
+	mod.SetUnsignedPayload(true)
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Amz-Content-Sha256"), "UNSIGNED-PAYLOAD"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Amz-Content-Sha256", got, want)
+	}
+}
+
+func TestSigV4ModifierEscapesPathTwice(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.amazonaws.com/a%20b/c", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+
+	mod := NewSigV4Modifier("AKIDEXAMPLE", "secret", "us-east-1", "service")
+	if got, want := mod.canonicalURI(req.URL), "/a%2520b/c"; got != want {
+		t.Errorf("mod.canonicalURI(): got %q, want %q", got, want)
+	}
+}
+
+func TestSigV4FromJSON(t *testing.T) {
+	msg := []byte(`{
+		"signing.SigV4": {
+			"scope": ["request"],
+			"accessKeyId": "AKIDEXAMPLE",
+			"secretAccessKey": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			"region": "us-east-1",
+			"service": "service"
+		}
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("reqmod: got nil, want not nil")
+	}
+	if _, ok := reqmod.(*SigV4Modifier); !ok {
+		t.Fatalf("reqmod.(*SigV4Modifier): got %T, want *SigV4Modifier", reqmod)
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"signing.SigV4": {"scope": ["request"], "region": "us-east-1"}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for missing credentials")
+	}
+}
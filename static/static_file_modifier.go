@@ -18,11 +18,10 @@
 package static
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"net/http"
@@ -32,6 +31,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/parse"
@@ -42,14 +42,26 @@ import (
 type Modifier struct {
 	rootPath      string
 	explicitPaths map[string]string
+	indexFiles    []string
 }
 
 type staticJSON struct {
 	ExplicitPaths map[string]string    `json:"explicitPaths"`
 	RootPath      string               `json:"rootPath"`
+	IndexFiles    []string             `json:"indexFiles"`
 	Scope         []parse.ModifierType `json:"scope"`
 }
 
+// byteRange is a range of a file, as requested by the Range header.
+type byteRange struct {
+	start, length int64
+}
+
+var (
+	errInvalidRange = errors.New("static: invalid range")
+	errNoOverlap    = errors.New("static: range does not overlap file")
+)
+
 func init() {
 	parse.Register("static.Modifier", modifierFromJSON)
 }
@@ -60,6 +72,7 @@ func NewModifier(rootPath string) *Modifier {
 	return &Modifier{
 		rootPath:      path.Clean(rootPath),
 		explicitPaths: make(map[string]string),
+		indexFiles:    []string{"index.html"},
 	}
 }
 
@@ -78,148 +91,373 @@ func (s *Modifier) ModifyRequest(req *http.Request) error {
 // (keyed by res.Request.URL.Path).  In the case that the file cannot be found, the response
 // will be a 404. ModifyResponse will return a 404 for any path that is defined in s.explictPaths
 // and that does not exist locally, even if that file does exist in s.rootPath.
+//
+// Paths that resolve outside of s.rootPath, including through symbolic links,
+// are treated as not found. A path that names a directory is served from the
+// first of the index files that exists in it.
+//
+// The response carries ETag and Last-Modified validators and is a 304 when
+// the request's If-None-Match or If-Modified-Since header matches them. Range
+// requests, optionally conditional on If-Range, are answered with a 206, or a
+// 416 when none of the ranges overlap the file.
 func (s *Modifier) ModifyResponse(res *http.Response) error {
-	reqpth := filepath.Clean(res.Request.URL.Path)
-	fpth := filepath.Join(s.rootPath, reqpth)
+	reqpth := path.Clean("/" + res.Request.URL.Path)
 
-	if _, ok := s.explicitPaths[reqpth]; ok {
-		fpth = filepath.Join(s.rootPath, s.explicitPaths[reqpth])
+	relpth := reqpth
+	if ep, ok := s.explicitPaths[reqpth]; ok {
+		relpth = ep
 	}
+	fpth := filepath.Join(s.rootPath, filepath.FromSlash(path.Clean("/"+relpth)))
 
-	f, err := os.Open(fpth)
-	switch {
-	case os.IsNotExist(err):
+	info, err := os.Stat(fpth)
+	if err == nil && info.IsDir() {
+		fpth, info, err = s.indexFile(fpth)
+	}
+	if err != nil {
+		return fileError(res, err)
+	}
+
+	if !s.contains(fpth) {
 		res.StatusCode = http.StatusNotFound
 		return nil
-	case os.IsPermission(err):
-		// This is returning a StatusUnauthorized to reflect that the Martian does
-		// not have the appropriate permissions on the local file system.  This is a
-		// deviation from the standard assumption around an HTTP 401 response.
-		res.StatusCode = http.StatusUnauthorized
-		return err
-	case err != nil:
-		res.StatusCode = http.StatusInternalServerError
-		return err
 	}
 
-	res.Body.Close()
-
-	info, err := f.Stat()
+	f, err := os.Open(fpth)
 	if err != nil {
-		res.StatusCode = http.StatusInternalServerError
-		return err
+		return fileError(res, err)
 	}
 
+	res.Body.Close()
+	res.Body = http.NoBody
+	res.ContentLength = 0
+
 	contentType := mime.TypeByExtension(filepath.Ext(fpth))
-	res.Header.Set("Content-Type", contentType)
+	if contentType == "" {
+		// Sniff the content type from the start of the file, as net/http does.
+		buf := make([]byte, 512)
+		n, err := f.ReadAt(buf, 0)
+		if err != nil && err != io.EOF {
+			f.Close()
+			res.StatusCode = http.StatusInternalServerError
+			return err
+		}
+		contentType = http.DetectContentType(buf[:n])
+	}
 
-	// If no range request header is present, return the file as the response body.
-	if res.Request.Header.Get("Range") == "" {
-		res.ContentLength = info.Size()
-		res.Body = f
+	size := info.Size()
+	modtime := info.ModTime()
+	etag := fmt.Sprintf(`"%x-%x"`, modtime.UnixNano(), size)
+
+	res.Header.Set("Accept-Ranges", "bytes")
+	res.Header.Set("ETag", etag)
+	res.Header.Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
 
+	if notModified(res.Request, etag, modtime) {
+		f.Close()
+		res.StatusCode = http.StatusNotModified
 		return nil
 	}
 
-	rh := res.Request.Header.Get("Range")
-	rh = strings.ToLower(rh)
-	sranges := strings.Split(strings.TrimLeft(rh, "bytes="), ",")
-	var ranges [][]int
-	for _, rng := range sranges {
-		if strings.HasSuffix(rng, "-") {
-			rng = fmt.Sprintf("%s%d", rng, info.Size()-1)
-		}
+	res.Header.Set("Content-Type", contentType)
 
-		rs := strings.Split(rng, "-")
-		if len(rs) != 2 {
+	var ranges []byteRange
+	if rh := res.Request.Header.Get("Range"); rh != "" && rangeApplies(res.Request, etag, modtime) {
+		ranges, err = parseRange(rh, size)
+		if err != nil {
+			f.Close()
 			res.StatusCode = http.StatusRequestedRangeNotSatisfiable
+			res.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
 			return nil
 		}
-		start, err := strconv.Atoi(strings.TrimSpace(rs[0]))
-		if err != nil {
-			return err
-		}
 
-		end, err := strconv.Atoi(strings.TrimSpace(rs[1]))
-		if err != nil {
-			return err
+		// Serve the whole file rather than ranges that add up to more than it.
+		var total int64
+		for _, rng := range ranges {
+			total += rng.length
 		}
-
-		if start > end {
-			res.StatusCode = http.StatusRequestedRangeNotSatisfiable
-			return nil
+		if total > size {
+			ranges = nil
 		}
+	}
 
-		ranges = append(ranges, []int{start, end})
+	switch len(ranges) {
+	case 0:
+		res.ContentLength = size
+		res.Body = f
+	case 1:
+		rng := ranges[0]
+		res.StatusCode = http.StatusPartialContent
+		res.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.start+rng.length-1, size))
+		res.ContentLength = rng.length
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(f, rng.start, rng.length), f}
+	default:
+		// Stream the parts, as http.ServeContent does, rather than buffering
+		// them. Closing the body stops the writer and closes the file.
+		pr, pw := io.Pipe()
+		mpw := multipart.NewWriter(pw)
+		go func() {
+			defer f.Close()
+
+			for _, rng := range ranges {
+				part, err := mpw.CreatePart(rangeHeader(rng, contentType, size))
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				if _, err := io.Copy(part, io.NewSectionReader(f, rng.start, rng.length)); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			mpw.Close()
+			pw.Close()
+		}()
+
+		res.StatusCode = http.StatusPartialContent
+		res.ContentLength = multipartSize(ranges, contentType, size)
+		res.Body = pr
+		res.Header.Set("Content-Type", fmt.Sprintf("multipart/byteranges; boundary=%s", mpw.Boundary()))
 	}
 
-	// Range request.
-	res.StatusCode = http.StatusPartialContent
+	if res.Request.Method == http.MethodHead {
+		res.Body.Close()
+		res.Body = http.NoBody
+	}
 
-	// Single range request.
-	if len(ranges) == 1 {
-		start := ranges[0][0]
-		end := ranges[0][1]
-		length := end - start + 1
-		seg := make([]byte, length)
+	return nil
+}
 
-		switch n, err := f.ReadAt(seg, int64(start)); err {
-		case nil, io.EOF:
-			res.ContentLength = int64(n)
-		default:
-			return err
+// rangeHeader returns the header of the part of a multipart/byteranges body
+// for rng.
+func rangeHeader(rng byteRange, contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.start+rng.length-1, size)},
+	}
+}
+
+// multipartSize returns the size of the multipart/byteranges body for ranges,
+// without reading them.
+func multipartSize(ranges []byteRange, contentType string, size int64) int64 {
+	var cw countingWriter
+	mpw := multipart.NewWriter(&cw)
+
+	var n int64
+	for _, rng := range ranges {
+		mpw.CreatePart(rangeHeader(rng, contentType, size))
+		n += rng.length
+	}
+	mpw.Close()
+
+	return n + int64(cw)
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// SetExplicitPathMappings sets an optional mapping of request paths to local
+// file paths rooted at s.rootPath.
+func (s *Modifier) SetExplicitPathMappings(ep map[string]string) {
+	s.explicitPaths = ep
+}
+
+// SetIndexFiles sets the names of the files, in order of preference, that are
+// served for a request path that names a directory. By default it is
+// index.html. With no index files, directories are not found.
+func (s *Modifier) SetIndexFiles(names ...string) {
+	s.indexFiles = names
+}
+
+// indexFile returns the path and info of the first index file in dir.
+func (s *Modifier) indexFile(dir string) (string, os.FileInfo, error) {
+	for _, name := range s.indexFiles {
+		fpth := filepath.Join(dir, name)
+		info, err := os.Stat(fpth)
+		if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+			continue
 		}
+		return fpth, info, err
+	}
+
+	return "", nil, os.ErrNotExist
+}
 
-		res.Body = ioutil.NopCloser(bytes.NewReader(seg))
-		res.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size()))
+// contains reports whether fpth, with its symbolic links evaluated, is within
+// s.rootPath.
+func (s *Modifier) contains(fpth string) bool {
+	root, err := filepath.EvalSymlinks(s.rootPath)
+	if err != nil {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(fpth)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
 
+// fileError sets the status code of res for an error opening a file and
+// returns the error to surface, if any.
+func fileError(res *http.Response, err error) error {
+	switch {
+	case os.IsNotExist(err):
+		res.StatusCode = http.StatusNotFound
 		return nil
+	case os.IsPermission(err):
+		// This is returning a StatusUnauthorized to reflect that the Martian does
+		// not have the appropriate permissions on the local file system.  This is a
+		// deviation from the standard assumption around an HTTP 401 response.
+		res.StatusCode = http.StatusUnauthorized
+		return err
+	default:
+		res.StatusCode = http.StatusInternalServerError
+		return err
 	}
+}
 
-	// Multipart range request.
-	var mpbody bytes.Buffer
-	mpw := multipart.NewWriter(&mpbody)
+// notModified reports whether a GET or HEAD request is conditional on
+// validators that the file still matches.
+func notModified(req *http.Request, etag string, modtime time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
 
-	for _, rng := range ranges {
-		start, end := rng[0], rng[1]
-		mimeh := make(textproto.MIMEHeader)
-		mimeh.Set("Content-Type", contentType)
-		mimeh.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size()))
-
-		length := end - start + 1
-		seg := make([]byte, length)
-
-		switch n, err := f.ReadAt(seg, int64(start)); err {
-		case nil, io.EOF:
-			res.ContentLength = int64(n)
-		default:
-			return err
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = textproto.TrimString(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
 		}
+		return false
+	}
 
-		pw, err := mpw.CreatePart(mimeh)
-		if err != nil {
-			return err
-		}
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
 
-		if _, err := pw.Write(seg); err != nil {
-			return err
-		}
+	return !modtime.Truncate(time.Second).After(ims)
+}
+
+// rangeApplies reports whether the Range header of req is to be honored given
+// its If-Range header, which must match the file exactly.
+func rangeApplies(req *http.Request, etag string, modtime time.Time) bool {
+	ir := textproto.TrimString(req.Header.Get("If-Range"))
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return ir == etag
 	}
-	mpw.Close()
 
-	res.ContentLength = int64(len(mpbody.Bytes()))
-	res.Body = ioutil.NopCloser(bytes.NewReader(mpbody.Bytes()))
-	res.Header.Set("Content-Type", fmt.Sprintf("multipart/byteranges; boundary=%s", mpw.Boundary()))
+	t, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
 
-	return nil
+	return modtime.Truncate(time.Second).Equal(t)
 }
 
-// SetExplicitPathMappings sets an optional mapping of request paths to local
-// file paths rooted at s.rootPath.
-func (s *Modifier) SetExplicitPathMappings(ep map[string]string) {
-	s.explicitPaths = ep
+// parseRange parses a Range header of the form "bytes=0-99,200-,-50" into the
+// ranges of a file of size bytes that it requests. It returns errNoOverlap if
+// none of the ranges start within the file.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(prefix):], ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		first, last = textproto.TrimString(first), textproto.TrimString(last)
+
+		var rng byteRange
+		if first == "" {
+			// A suffix range, "-n", requests the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n > size {
+				n = size
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			rng = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			if start >= size {
+				noOverlap = true
+				continue
+			}
+
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			rng = byteRange{start: start, length: end - start + 1}
+		}
+
+		ranges = append(ranges, rng)
+	}
+
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+
+	return ranges, nil
 }
 
+// modifierFromJSON builds a static.Modifier from JSON.
+//
+// Example JSON:
+//
+//	{
+//	  "static.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "rootPath": "/var/www/fixtures",
+//	    "explicitPaths": {
+//	      "/": "/home.html"
+//	    },
+//	    "indexFiles": ["index.html", "index.htm"]
+//	  }
+//	}
 func modifierFromJSON(b []byte) (*parse.Result, error) {
 	msg := &staticJSON{}
 	if err := json.Unmarshal(b, msg); err != nil {
@@ -228,5 +466,9 @@ func modifierFromJSON(b []byte) (*parse.Result, error) {
 
 	mod := NewModifier(msg.RootPath)
 	mod.SetExplicitPathMappings(msg.ExplicitPaths)
+	if msg.IndexFiles != nil {
+		mod.SetIndexFiles(msg.IndexFiles...)
+	}
+
 	return parse.NewResult(mod, msg.Scope)
 }
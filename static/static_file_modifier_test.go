@@ -17,11 +17,16 @@ package static
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/parse"
@@ -441,3 +446,267 @@ func TestStaticModifierSingleRangeRequest(t *testing.T) {
 		t.Errorf("res.Header.Get('Content-Type'): got %v, want %v", got, want)
 	}
 }
+
+// serve runs mod on a GET request for pth with the given headers.
+func serve(t *testing.T, mod *Modifier, pth string, headers map[string]string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", pth, nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): got %v, want no error", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	martian.TestContext(req, nil, nil)
+
+	if err := mod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	res := proxyutil.NewResponse(http.StatusOK, nil, req)
+	if err := mod.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	return res
+}
+
+func TestStaticModifierPathTraversal(t *testing.T) {
+	tmpdir := t.TempDir()
+	root := filepath.Join(tmpdir, "root")
+	if err := os.Mkdir(root, 0777); err != nil {
+		t.Fatalf("os.Mkdir(): got %v, want no error", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "secret.txt"), []byte("secret"), 0777); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+	if err := os.Symlink(filepath.Join(tmpdir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatalf("os.Symlink(): got %v, want no error", err)
+	}
+
+	mod := NewModifier(root)
+	mod.SetExplicitPathMappings(map[string]string{"/mapped.txt": "../secret.txt"})
+
+	for i, pth := range []string{"/../secret.txt", "/%2e%2e/secret.txt", "/mapped.txt", "/link.txt"} {
+		res := serve(t, mod, pth, nil)
+		if got, want := res.StatusCode, http.StatusNotFound; got != want {
+			t.Errorf("%d. res.StatusCode for %q: got %v, want %v", i, pth, got, want)
+		}
+	}
+}
+
+func TestStaticModifierIndexFiles(t *testing.T) {
+	tmpdir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpdir, "docs"), 0777); err != nil {
+		t.Fatalf("os.MkdirAll(): got %v, want no error", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "docs", "index.htm"), []byte("<p>docs</p>"), 0777); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	mod := NewModifier(tmpdir)
+
+	res := serve(t, mod, "/docs/", nil)
+	if got, want := res.StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("res.StatusCode: got %v, want %v", got, want)
+	}
+
+	mod.SetIndexFiles("index.html", "index.htm")
+
+	res = serve(t, mod, "/docs/", nil)
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("res.StatusCode: got %v, want %v", got, want)
+	}
+	if got, want := res.Header.Get("Content-Type"), "text/html; charset=utf-8"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Content-Type", got, want)
+	}
+
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	res.Body.Close()
+
+	if want := []byte("<p>docs</p>"); !bytes.Equal(got, want) {
+		t.Errorf("res.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestStaticModifierSniffsContentType(t *testing.T) {
+	tmpdir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "page"), []byte("<!DOCTYPE html><html></html>"), 0777); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	res := serve(t, NewModifier(tmpdir), "/page", nil)
+	if got, want := res.Header.Get("Content-Type"), "text/html; charset=utf-8"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Content-Type", got, want)
+	}
+
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	res.Body.Close()
+
+	if want := []byte("<!DOCTYPE html><html></html>"); !bytes.Equal(got, want) {
+		t.Errorf("res.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestStaticModifierConditionalRequests(t *testing.T) {
+	tmpdir := t.TempDir()
+	fpth := filepath.Join(tmpdir, "sfmtest.txt")
+	if err := ioutil.WriteFile(fpth, []byte("0123456789"), 0777); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+	modtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(fpth, modtime, modtime); err != nil {
+		t.Fatalf("os.Chtimes(): got %v, want no error", err)
+	}
+
+	mod := NewModifier(tmpdir)
+
+	res := serve(t, mod, "/sfmtest.txt", nil)
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("res.Header.Get(\"ETag\"): got empty, want ETag")
+	}
+	if got, want := res.Header.Get("Last-Modified"), "Thu, 02 Jan 2020 03:04:05 GMT"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Last-Modified", got, want)
+	}
+	res.Body.Close()
+
+	tt := []struct {
+		headers map[string]string
+		want    int
+	}{
+		{map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified},
+		{map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{map[string]string{"If-Modified-Since": "Thu, 02 Jan 2020 03:04:05 GMT"}, http.StatusNotModified},
+		{map[string]string{"If-Modified-Since": "Thu, 02 Jan 2020 03:04:04 GMT"}, http.StatusOK},
+		// If-None-Match takes precedence over If-Modified-Since.
+		{map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": "Thu, 02 Jan 2020 03:04:05 GMT"}, http.StatusOK},
+	}
+
+	for i, tc := range tt {
+		res := serve(t, mod, "/sfmtest.txt", tc.headers)
+		if got := res.StatusCode; got != tc.want {
+			t.Errorf("%d. res.StatusCode: got %v, want %v", i, got, tc.want)
+		}
+		if got, want := res.Header.Get("ETag"), etag; got != want {
+			t.Errorf("%d. res.Header.Get(%q): got %q, want %q", i, "ETag", got, want)
+		}
+		res.Body.Close()
+	}
+}
+
+func TestStaticModifierRanges(t *testing.T) {
+	tmpdir := t.TempDir()
+	fpth := filepath.Join(tmpdir, "sfmtest.txt")
+	if err := ioutil.WriteFile(fpth, []byte("0123456789"), 0777); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+	modtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(fpth, modtime, modtime); err != nil {
+		t.Fatalf("os.Chtimes(): got %v, want no error", err)
+	}
+
+	mod := NewModifier(tmpdir)
+
+	tt := []struct {
+		headers      map[string]string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "bytes 7-9/10", "789"},
+		{map[string]string{"Range": "bytes=6-"}, http.StatusPartialContent, "bytes 6-9/10", "6789"},
+		{map[string]string{"Range": "bytes=8-20"}, http.StatusPartialContent, "bytes 8-9/10", "89"},
+		{map[string]string{"Range": "bytes=10-"}, http.StatusRequestedRangeNotSatisfiable, "bytes */10", ""},
+		{map[string]string{"Range": "bytes=5-2"}, http.StatusRequestedRangeNotSatisfiable, "bytes */10", ""},
+		{map[string]string{"Range": "bytes=0-1", "If-Range": `"stale"`}, http.StatusOK, "", "0123456789"},
+		{map[string]string{"Range": "bytes=0-1", "If-Range": "Thu, 02 Jan 2020 03:04:05 GMT"}, http.StatusPartialContent, "bytes 0-1/10", "01"},
+	}
+
+	for i, tc := range tt {
+		res := serve(t, mod, "/sfmtest.txt", tc.headers)
+		if got := res.StatusCode; got != tc.status {
+			t.Errorf("%d. res.StatusCode: got %v, want %v", i, got, tc.status)
+		}
+		if got := res.Header.Get("Content-Range"); got != tc.contentRange {
+			t.Errorf("%d. res.Header.Get(%q): got %q, want %q", i, "Content-Range", got, tc.contentRange)
+		}
+
+		got, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		res.Body.Close()
+
+		if string(got) != tc.body {
+			t.Errorf("%d. res.Body: got %q, want %q", i, got, tc.body)
+		}
+		if got, want := res.ContentLength, int64(len(tc.body)); got != want {
+			t.Errorf("%d. res.ContentLength: got %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestStaticModifierMultipleRanges(t *testing.T) {
+	tmpdir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(tmpdir, "sfmtest.txt"), []byte("0123456789"), 0777); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	res := serve(t, NewModifier(tmpdir), "/sfmtest.txt", map[string]string{"Range": "bytes=0-1,5-6"})
+	defer res.Body.Close()
+
+	if got, want := res.StatusCode, http.StatusPartialContent; got != want {
+		t.Fatalf("res.StatusCode: got %v, want %v", got, want)
+	}
+	mt, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("mime.ParseMediaType(): got %v, want no error", err)
+	}
+	if got, want := mt, "multipart/byteranges"; got != want {
+		t.Fatalf("media type: got %q, want %q", got, want)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if got, want := res.ContentLength, int64(len(body)); got != want {
+		t.Errorf("res.ContentLength: got %d, want %d", got, want)
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for i, want := range []struct {
+		contentRange string
+		body         string
+	}{
+		{"bytes 0-1/10", "01"},
+		{"bytes 5-6/10", "56"},
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("%d. mr.NextPart(): got %v, want no error", i, err)
+		}
+		if got := part.Header.Get("Content-Range"); got != want.contentRange {
+			t.Errorf("%d. part.Header.Get(%q): got %q, want %q", i, "Content-Range", got, want.contentRange)
+		}
+		got, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+		}
+		if string(got) != want.body {
+			t.Errorf("%d. part body: got %q, want %q", i, got, want.body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("mr.NextPart(): got %v, want %v", err, io.EOF)
+	}
+}
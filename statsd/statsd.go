@@ -0,0 +1,287 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package statsd emits per-request and per-connection statistics of a proxy
+// to a StatsD or DogStatsD endpoint.
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/connmetric"
+	"github.com/google/martian/v3/log"
+)
+
+// Format is the wire format of emitted metrics.
+type Format int
+
+const (
+	// StatsD is the plain StatsD format. It has no tags, so the values of
+	// per-metric tags, such as the status code, are appended to the metric
+	// name and constant tags are dropped.
+	StatsD Format = iota
+	// DogStatsD is the DogStatsD format, which appends tags to metrics.
+	DogStatsD
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case StatsD:
+		return "statsd"
+	case DogStatsD:
+		return "dogstatsd"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// ParseFormat returns the format named s, "statsd" or "dogstatsd".
+func ParseFormat(s string) (Format, error) {
+	for _, f := range []Format{StatsD, DogStatsD} {
+		if f.String() == s {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("statsd: unknown format %q", s)
+}
+
+// Emitter sends metrics to a StatsD endpoint over UDP, one metric per packet.
+// It implements martian.Observer, to be set as martian.Proxy.Observer, for
+// client connections and requests, and connmetric.Tracker, to be set with
+// martian.Proxy.SetConnTracker, for upstream connections.
+//
+// The emitted metrics, with the prefix set by SetPrefix, are:
+//
+//	requests               count of round trips, tagged by method and status
+//	round_trip             timing of round trips to the response headers
+//	modifier_errors        count of errors returned by modifiers
+//	mitm_handshakes        count of client TLS handshakes, tagged by result
+//	client.connections     count of accepted client connections
+//	client.active          gauge of open client connections
+//	client.bytes_read      count of bytes read from client connections
+//	client.bytes_written   count of bytes written to client connections
+//	upstream.connections   count of upstream connections, tagged by host and result
+//	upstream.duration      timing of upstream connections, tagged by host
+//	upstream.dns           timing of the host resolution of upstream connections
+//	upstream.connect       timing of the TCP connect of upstream connections
+//	upstream.tls_handshake timing of the TLS handshake of upstream connections
+//	upstream.bytes_read    count of bytes read from upstream connections
+//	upstream.bytes_written count of bytes written to upstream connections
+//
+// The metrics of requests, requests, round_trip and modifier_errors, are
+// also tagged by the session labels set with SetLabels.
+type Emitter struct {
+	conn net.Conn
+
+	mu     sync.RWMutex
+	format Format
+	prefix string
+	tags   []string
+	labels []string
+}
+
+var (
+	_ martian.Observer   = (*Emitter)(nil)
+	_ connmetric.Tracker = (*Emitter)(nil)
+)
+
+// New returns an Emitter sending to the StatsD endpoint at the UDP address
+// addr, such as "localhost:8125", in the StatsD format.
+func New(addr string) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Emitter{
+		conn:   conn,
+		prefix: "martian.",
+	}, nil
+}
+
+// SetFormat sets the wire format of metrics.
+func (e *Emitter) SetFormat(f Format) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.format = f
+}
+
+// SetPrefix sets the prefix of metric names, "martian." by default.
+func (e *Emitter) SetPrefix(prefix string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.prefix = prefix
+}
+
+// SetTags sets tags, such as "env:ci", added to every metric in the DogStatsD
+// format.
+func (e *Emitter) SetTags(tags ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.tags = append([]string(nil), tags...)
+}
+
+// SetLabels sets the keys of the session labels, such as "device" or "build",
+// with which the metrics of requests are tagged. Requests without a label
+// are tagged with "none".
+func (e *Emitter) SetLabels(keys ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.labels = append([]string(nil), keys...)
+}
+
+// Close closes the connection to the endpoint.
+func (e *Emitter) Close() error {
+	return e.conn.Close()
+}
+
+// ConnOpened emits the accepted client connection.
+func (e *Emitter) ConnOpened() {
+	e.emit("client.connections", "1", "c")
+	e.emit("client.active", "+1", "g")
+}
+
+// ConnClosed emits the closed client connection and its bytes.
+func (e *Emitter) ConnClosed(read, written int64) {
+	e.emit("client.active", "-1", "g")
+	e.emit("client.bytes_read", strconv.FormatInt(read, 10), "c")
+	e.emit("client.bytes_written", strconv.FormatInt(written, 10), "c")
+}
+
+// MITMHandshake emits the client TLS handshake.
+func (e *Emitter) MITMHandshake(err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	e.emit("mitm_handshakes", "1", "c", "result:"+result)
+}
+
+// RoundTrip emits the round trip and its latency.
+func (e *Emitter) RoundTrip(req *http.Request, res *http.Response, d time.Duration, err error) {
+	status := "error"
+	if err == nil && res != nil {
+		status = strconv.Itoa(res.StatusCode)
+	}
+
+	labels := e.labelTags(req)
+	e.emit("requests", "1", "c", append([]string{"method:" + req.Method, "status:" + status}, labels...)...)
+	e.emit("round_trip", millis(d), "ms", append([]string{"method:" + req.Method}, labels...)...)
+}
+
+// ModifierError emits the modifier error.
+func (e *Emitter) ModifierError(req *http.Request, err error) {
+	e.emit("modifier_errors", "1", "c", e.labelTags(req)...)
+}
+
+// labelTags returns the tags of the session labels of req.
+func (e *Emitter) labelTags(req *http.Request) []string {
+	e.mu.RLock()
+	keys := e.labels
+	e.mu.RUnlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var labels map[string]string
+	if ctx := martian.NewContext(req); ctx != nil {
+		labels = ctx.Session().Labels()
+	}
+	tags := make([]string, len(keys))
+	for i, k := range keys {
+		v, ok := labels[k]
+		if !ok {
+			v = "none"
+		}
+		tags[i] = sanitize(k) + ":" + sanitize(v)
+	}
+
+	return tags
+}
+
+// TrackConn emits the upstream connection s. Accepted client connections
+// are emitted by the Observer methods instead and are ignored.
+func (e *Emitter) TrackConn(s *connmetric.StatsEntry) {
+	if s.Accepted {
+		return
+	}
+
+	host := "host:" + s.Host
+	if s.Err != nil && s.BytesRead == 0 && s.BytesWritten == 0 {
+		e.emit("upstream.connections", "1", "c", host, "result:error")
+		return
+	}
+
+	e.emit("upstream.connections", "1", "c", host, "result:success")
+	e.emit("upstream.duration", millis(s.Duration), "ms", host)
+	e.emitPhase("upstream.dns", s.DNS, host)
+	e.emitPhase("upstream.connect", s.Connect, host)
+	e.emitPhase("upstream.tls_handshake", s.TLSHandshake, host)
+	e.emit("upstream.bytes_read", strconv.FormatInt(s.BytesRead, 10), "c", host)
+	e.emit("upstream.bytes_written", strconv.FormatInt(s.BytesWritten, 10), "c", host)
+}
+
+// emitPhase emits the timing d of a phase of establishing a connection, if
+// it happened.
+func (e *Emitter) emitPhase(name string, d time.Duration, tags ...string) {
+	if d > 0 {
+		e.emit(name, millis(d), "ms", tags...)
+	}
+}
+
+// emit sends the metric name with value and type typ. tags are "key:value"
+// pairs.
+func (e *Emitter) emit(name, value, typ string, tags ...string) {
+	e.mu.RLock()
+	format, prefix, constTags := e.format, e.prefix, e.tags
+	e.mu.RUnlock()
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteString(name)
+	if format == StatsD {
+		for _, t := range tags {
+			_, v, _ := strings.Cut(t, ":")
+			b.WriteByte('.')
+			b.WriteString(sanitize(v))
+		}
+	}
+	fmt.Fprintf(&b, ":%s|%s", value, typ)
+	if format == DogStatsD && len(constTags)+len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(append(append([]string(nil), constTags...), tags...), ","))
+	}
+
+	if _, err := e.conn.Write([]byte(b.String())); err != nil {
+		log.Debugf("statsd: failed to send metric %s: %v", name, err)
+	}
+}
+
+// sanitize replaces the characters of s that are reserved in StatsD metric
+// names.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', '#', ',', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
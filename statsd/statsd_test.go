@@ -0,0 +1,119 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package statsd
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/connmetric"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+func TestParseFormat(t *testing.T) {
+	for _, want := range []Format{StatsD, DogStatsD} {
+		got, err := ParseFormat(want.String())
+		if err != nil {
+			t.Fatalf("ParseFormat(%q): got %v, want no error", want, err)
+		}
+		if got != want {
+			t.Errorf("ParseFormat(%q): got %v, want %v", want, got, want)
+		}
+	}
+
+	if _, err := ParseFormat("graphite"); err == nil {
+		t.Errorf("ParseFormat(%q): got nil error, want error", "graphite")
+	}
+}
+
+func TestEmitter(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket(): got %v, want no error", err)
+	}
+	defer pc.Close()
+
+	e, err := New(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("New(): got %v, want no error", err)
+	}
+	defer e.Close()
+
+	recv := func() string {
+		t.Helper()
+
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 1024)
+		n, _, err := pc.ReadFrom(b)
+		if err != nil {
+			t.Fatalf("pc.ReadFrom(): got %v, want no error", err)
+		}
+		return string(b[:n])
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(404, nil, req)
+
+	e.RoundTrip(req, res, 1500*time.Microsecond, nil)
+	for _, want := range []string{
+		"martian.requests.GET.404:1|c",
+		"martian.round_trip.GET:1.5|ms",
+	} {
+		if got := recv(); got != want {
+			t.Errorf("metric: got %q, want %q", got, want)
+		}
+	}
+
+	e.SetLabels("device", "build")
+	martian.TestContext(req, nil, nil).Session().SetLabel("device", "pixel7")
+
+	e.RoundTrip(req, res, time.Millisecond, nil)
+	e.ModifierError(req, errors.New("modifier error"))
+	for _, want := range []string{
+		"martian.requests.GET.404.pixel7.none:1|c",
+		"martian.round_trip.GET.pixel7.none:1|ms",
+		"martian.modifier_errors.pixel7.none:1|c",
+	} {
+		if got := recv(); got != want {
+			t.Errorf("metric: got %q, want %q", got, want)
+		}
+	}
+
+	e.SetLabels()
+	e.SetFormat(DogStatsD)
+	e.SetPrefix("proxy.")
+	e.SetTags("env:ci")
+
+	e.RoundTrip(req, nil, time.Millisecond, errors.New("connection refused"))
+	e.TrackConn(&connmetric.StatsEntry{
+		Host:         "example.com",
+		Duration:     2 * time.Second,
+		Connect:      3 * time.Millisecond,
+		BytesRead:    10,
+		BytesWritten: 20,
+	})
+	e.SetLabels("device")
+	e.RoundTrip(req, res, time.Millisecond, nil)
+	for _, want := range []string{
+		"proxy.requests:1|c|#env:ci,method:GET,status:error",
+		"proxy.round_trip:1|ms|#env:ci,method:GET",
+		"proxy.upstream.connections:1|c|#env:ci,host:example.com,result:success",
+		"proxy.upstream.duration:2000|ms|#env:ci,host:example.com",
+		"proxy.upstream.connect:3|ms|#env:ci,host:example.com",
+		"proxy.upstream.bytes_read:10|c|#env:ci,host:example.com",
+		"proxy.upstream.bytes_written:20|c|#env:ci,host:example.com",
+		"proxy.requests:1|c|#env:ci,method:GET,status:404,device:pixel7",
+		"proxy.round_trip:1|ms|#env:ci,method:GET,device:pixel7",
+	} {
+		if got := recv(); got != want {
+			t.Errorf("metric: got %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+// strictHeaderBytes is the maximum size of request headers with
+// Proxy.StrictParsing.
+const strictHeaderBytes = 64 << 10
+
+// errHeaderTooLarge is returned when the request header does not fit in the
+// read buffer.
+var errHeaderTooLarge = errors.New("request header too large")
+
+// errBodyTooLarge is returned when a request body that is read into memory
+// exceeds Proxy.MaxBufferedBodyBytes.
+var errBodyTooLarge = errors.New("request body too large")
+
+// peekHeader returns the request line and header of the next request in r,
+// including the terminating empty line, without consuming them.
+func peekHeader(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, _ := r.Peek(r.Buffered())
+		// The header ends at the first empty line, which is checked for a
+		// bare LF later.
+		if i := bytes.Index(b, []byte("\n\r\n")); i >= 0 {
+			if j := bytes.Index(b, []byte("\n\n")); j >= 0 && j < i {
+				return b[:j+2], nil
+			}
+			return b[:i+3], nil
+		}
+		if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+			return b[:i+2], nil
+		}
+		if r.Buffered() == r.Size() {
+			return nil, errHeaderTooLarge
+		}
+
+		// Blocks until more data is available.
+		if _, err := r.Peek(r.Buffered() + 1); err != nil {
+			if err == io.EOF && r.Buffered() > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// checkHeader checks the raw request line and header of a request for
+// ambiguities that are used to smuggle requests: bare LF line endings,
+// obs-fold continuation lines, malformed field names, and conflicting or
+// invalid Content-Length and Transfer-Encoding fields.
+func checkHeader(raw []byte) error {
+	// The last two elements are the empty line and the empty string after it.
+	lines := strings.Split(string(raw), "\n")
+	for i, l := range lines[:len(lines)-1] {
+		if !strings.HasSuffix(l, "\r") {
+			return fmt.Errorf("line %d is not terminated by CRLF", i+1)
+		}
+		lines[i] = l[:len(l)-1]
+	}
+	lines = lines[:len(lines)-2]
+
+	if len(lines) == 0 || len(strings.Split(lines[0], " ")) != 3 {
+		return errors.New("malformed request line")
+	}
+
+	var cl, te []string
+	for _, l := range lines[1:] {
+		if l == "" || l[0] == ' ' || l[0] == '\t' {
+			return errors.New("obsolete line folding in header")
+		}
+
+		name, value, ok := strings.Cut(l, ":")
+		if !ok || !isToken(name) {
+			return fmt.Errorf("malformed header field %q", l)
+		}
+		value = strings.Trim(value, " \t")
+
+		switch textproto.CanonicalMIMEHeaderKey(name) {
+		case "Content-Length":
+			cl = append(cl, value)
+		case "Transfer-Encoding":
+			te = append(te, value)
+		}
+	}
+
+	if len(cl) > 0 && len(te) > 0 {
+		return errors.New("both Content-Length and Transfer-Encoding present")
+	}
+	if len(cl) > 1 {
+		return errors.New("multiple Content-Length fields")
+	}
+	if len(cl) == 1 {
+		if _, err := strconv.ParseUint(cl[0], 10, 63); err != nil {
+			return fmt.Errorf("invalid Content-Length %q", cl[0])
+		}
+	}
+	if len(te) > 1 || len(te) == 1 && !strings.EqualFold(te[0], "chunked") {
+		return fmt.Errorf("unsupported Transfer-Encoding %q", strings.Join(te, ", "))
+	}
+
+	return nil
+}
+
+// readStrictChunked decodes a chunked body from r, rejecting malformed chunk
+// sizes, chunk extensions and line endings. Trailer fields are added to
+// trailer. If the decoded body exceeds max bytes, errBodyTooLarge is returned
+// before the chunk that would exceed it is read.
+func readStrictChunked(r *bufio.Reader, trailer http.Header, max int64) ([]byte, error) {
+	var body bytes.Buffer
+	for {
+		line, err := readCRLFLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		size, ext, hasExt := strings.Cut(line, ";")
+		if hasExt && !checkChunkExtensions(ext) {
+			return nil, fmt.Errorf("invalid chunk extension %q", ext)
+		}
+		n, err := strconv.ParseUint(size, 16, 63)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size %q", size)
+		}
+
+		if n == 0 {
+			break
+		}
+		if n > uint64(max-int64(body.Len())) {
+			return nil, errBodyTooLarge
+		}
+		if _, err := io.CopyN(&body, r, int64(n)); err != nil {
+			return nil, err
+		}
+		if line, err := readCRLFLine(r); err != nil || line != "" {
+			return nil, errors.New("chunk data not terminated by CRLF")
+		}
+	}
+
+	for {
+		line, err := readCRLFLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return body.Bytes(), nil
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !isToken(name) {
+			return nil, fmt.Errorf("malformed trailer field %q", line)
+		}
+		if trailer != nil {
+			trailer.Add(name, strings.Trim(value, " \t"))
+		}
+	}
+}
+
+// readCRLFLine reads a line terminated by CRLF and returns it without the line
+// ending.
+func readCRLFLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("line not terminated by CRLF")
+	}
+
+	return line[:len(line)-2], nil
+}
+
+// checkChunkExtensions reports whether ext, the part of a chunk size line
+// after the first semicolon, is a valid list of chunk extensions, see
+// RFC 9112, section 7.1.1.
+func checkChunkExtensions(ext string) bool {
+	for _, e := range strings.Split(ext, ";") {
+		name, value, hasValue := strings.Cut(e, "=")
+		if !isToken(strings.Trim(name, " \t")) {
+			return false
+		}
+		if !hasValue {
+			continue
+		}
+
+		value = strings.Trim(value, " \t")
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			if strings.ContainsAny(value[1:len(value)-1], "\"\r\n") {
+				return false
+			}
+			continue
+		}
+		if !isToken(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isToken reports whether s is an RFC 9110 token.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeChunked replaces the chunked body of req, which must not have been
+// read yet, with the body decoded strictly from r, so that the request is
+// forwarded with a Content-Length. Bodies larger than max bytes are rejected
+// with errBodyTooLarge.
+func normalizeChunked(req *http.Request, r *bufio.Reader, max int64) error {
+	if len(req.TransferEncoding) == 0 {
+		return nil
+	}
+
+	body, err := readStrictChunked(r, req.Trailer, max)
+	if err != nil {
+		return err
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+	req.Header.Del("Transfer-Encoding")
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return nil
+}
+
+// rejectRequest writes a response with the status code to the client and
+// returns errClose, so that the connection is closed.
+func rejectRequest(brw *bufio.ReadWriter, code int) error {
+	res := proxyutil.NewResponse(code, nil, nil)
+	res.Close = true
+	if err := res.Write(brw); err != nil {
+		log.Errorf("martian: got error while writing response back to client: %v", err)
+	}
+	if err := brw.Flush(); err != nil {
+		log.Errorf("martian: got error while flushing response back to client: %v", err)
+	}
+
+	return errClose
+}
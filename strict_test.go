@@ -0,0 +1,163 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			raw:  "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\n",
+		},
+		{
+			name: "chunked",
+			raw:  "POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n",
+		},
+		{
+			name:    "content length and transfer encoding",
+			raw:     "POST / HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "multiple content lengths",
+			raw:     "POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid content length",
+			raw:     "POST / HTTP/1.1\r\nContent-Length: +5\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported transfer encoding",
+			raw:     "POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "obs-fold",
+			raw:     "GET / HTTP/1.1\r\nX-Folded: a\r\n b\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "bare LF",
+			raw:     "GET / HTTP/1.1\nHost: example.com\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "space before colon",
+			raw:     "GET / HTTP/1.1\r\nHost : example.com\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed request line",
+			raw:     "GET  / HTTP/1.1\r\n\r\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := peekHeader(bufio.NewReader(strings.NewReader(tc.raw + "body")))
+			if err != nil {
+				t.Fatalf("peekHeader(): got %v, want no error", err)
+			}
+			if got, want := string(raw), tc.raw; got != want && !tc.wantErr {
+				t.Fatalf("peekHeader(): got %q, want %q", got, want)
+			}
+
+			if err := checkHeader(raw); (err != nil) != tc.wantErr {
+				t.Errorf("checkHeader(): got %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPeekHeaderTooLarge(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nX-Large: " + strings.Repeat("a", 64) + "\r\n\r\n"
+	if _, err := peekHeader(bufio.NewReaderSize(strings.NewReader(raw), 16)); err != errHeaderTooLarge {
+		t.Errorf("peekHeader(): got %v, want %v", err, errHeaderTooLarge)
+	}
+}
+
+func TestReadStrictChunkedTooLarge(t *testing.T) {
+	body := "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+	if _, err := readStrictChunked(bufio.NewReader(strings.NewReader(body)), nil, 10); err != errBodyTooLarge {
+		t.Errorf("readStrictChunked(): got %v, want %v", err, errBodyTooLarge)
+	}
+	if _, err := readStrictChunked(bufio.NewReader(strings.NewReader(body)), nil, 11); err != nil {
+		t.Errorf("readStrictChunked(): got %v, want no error", err)
+	}
+}
+
+func TestReadStrictChunked(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		wantErr  bool
+		trailers http.Header
+	}{
+		{
+			name: "valid",
+			body: "5\r\nhello\r\n6;name=value;quoted=\"a b\"\r\n world\r\n0\r\nX-Trailer: t\r\n\r\n",
+			want: "hello world",
+			trailers: http.Header{
+				"X-Trailer": []string{"t"},
+			},
+		},
+		{
+			name:    "invalid extension",
+			body:    "5;\"ext\"\r\nhello\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty extension",
+			body:    "5;\r\nhello\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "bare LF",
+			body:    "5\nhello\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid size",
+			body:    "0x5\r\nhello\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "data too long",
+			body:    "4\r\nhello\r\n0\r\n\r\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			trailer := http.Header{}
+			got, err := readStrictChunked(bufio.NewReader(strings.NewReader(tc.body)), trailer, 1<<10)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("readStrictChunked(): got %v, want error %t", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if string(got) != tc.want {
+				t.Errorf("readStrictChunked(): got %q, want %q", got, tc.want)
+			}
+			if got, want := trailer.Get("X-Trailer"), tc.trailers.Get("X-Trailer"); got != want {
+				t.Errorf("trailer.Get(%q): got %q, want %q", "X-Trailer", got, want)
+			}
+		})
+	}
+}
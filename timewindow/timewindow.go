@@ -0,0 +1,297 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package timewindow provides a filter and a matcher that enable modifiers
+// only during configured time windows, for instance to inject failures on a
+// schedule or to serve maintenance responses.
+package timewindow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/martian/v3/filter"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("timewindow.Filter", filterFromJSON)
+	filter.RegisterCondition("timewindow", matcherFromJSON)
+}
+
+// Window is a daily time window. Start and End are times of day in the
+// "15:04" format; the window includes Start and excludes End, and wraps past
+// midnight if End is before Start. If Days is not empty, the window only
+// opens on the listed days of the week, given by their three-letter English
+// names ("mon"); a window wrapping past midnight is named by the day it opens.
+type Window struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+type window struct {
+	days       uint8
+	start, end time.Duration
+}
+
+func parseWindow(w Window) (*window, error) {
+	pw := &window{}
+	for _, d := range w.Days {
+		i := indexFold(dowField.names, d)
+		if i < 0 {
+			return nil, fmt.Errorf("timewindow: invalid day %q", d)
+		}
+		pw.days |= 1 << uint(i)
+	}
+	if len(w.Days) == 0 {
+		pw.days = 1<<7 - 1
+	}
+
+	var err error
+	if pw.start, err = parseTimeOfDay(w.Start); err != nil {
+		return nil, err
+	}
+	if pw.end, err = parseTimeOfDay(w.End); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+func indexFold(names []string, s string) int {
+	for i, name := range names {
+		if strings.EqualFold(name, s) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("timewindow: invalid time of day %q: %v", s, err)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains returns whether t is in the window.
+func (w *window) contains(t time.Time) bool {
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+
+	if w.start <= w.end {
+		return w.opensOn(day) && tod >= w.start && tod < w.end
+	}
+
+	// The window wraps past midnight: it is either open since today's start,
+	// or still open from yesterday.
+	if tod >= w.start {
+		return w.opensOn(day)
+	}
+	return tod < w.end && w.opensOn((day+6)%7)
+}
+
+func (w *window) opensOn(day time.Weekday) bool {
+	return w.days&(1<<uint(day)) != 0
+}
+
+// Matcher is a conditional evaluator of the current time to be used in
+// filters that take conditionals. It matches while the time is in any of its
+// windows or matches any of its cron expressions.
+type Matcher struct {
+	windows []*window
+	crons   []*cron
+	loc     *time.Location
+}
+
+type matcherJSON struct {
+	Windows  []Window `json:"windows"`
+	Cron     []string `json:"cron"`
+	Location string   `json:"location"`
+}
+
+// NewMatcher returns a matcher with no windows, which never matches, that
+// evaluates times in UTC.
+func NewMatcher() *Matcher {
+	return &Matcher{
+		loc: time.UTC,
+	}
+}
+
+// AddWindow adds a daily window.
+func (m *Matcher) AddWindow(w Window) error {
+	pw, err := parseWindow(w)
+	if err != nil {
+		return err
+	}
+
+	m.windows = append(m.windows, pw)
+	return nil
+}
+
+// AddCron adds a cron expression of five fields: minute, hour, day of month,
+// month and day of week. The matcher matches during every minute that the
+// expression matches, so that "* 2-3 * * sun" matches on Sundays from 2:00 to
+// 3:59.
+func (m *Matcher) AddCron(expr string) error {
+	c, err := parseCron(expr)
+	if err != nil {
+		return err
+	}
+
+	m.crons = append(m.crons, c)
+	return nil
+}
+
+// SetLocation sets the time zone that windows and cron expressions are
+// evaluated in.
+func (m *Matcher) SetLocation(loc *time.Location) {
+	m.loc = loc
+}
+
+// Match returns whether t is in any of the windows or matches any of the cron
+// expressions.
+func (m *Matcher) Match(t time.Time) bool {
+	t = t.In(m.loc)
+	for _, w := range m.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	for _, c := range m.crons {
+		if c.matches(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchRequest returns whether the current time matches.
+func (m *Matcher) MatchRequest(*http.Request) bool {
+	return m.Match(time.Now())
+}
+
+// MatchResponse returns whether the current time matches.
+func (m *Matcher) MatchResponse(*http.Response) bool {
+	return m.Match(time.Now())
+}
+
+// matcherFromJSON builds a timewindow.Matcher condition from JSON. The
+// location is an IANA time zone name and defaults to UTC.
+//
+// Example JSON:
+//
+//	{
+//	  "windows": [
+//	    { "days": ["sat", "sun"], "start": "22:00", "end": "02:00" }
+//	  ],
+//	  "cron": ["*/10 9-17 * * mon-fri"],
+//	  "location": "Europe/Berlin"
+//	}
+func matcherFromJSON(b []byte) (filter.Condition, error) {
+	msg := &matcherJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	return newMatcher(msg)
+}
+
+func newMatcher(msg *matcherJSON) (*Matcher, error) {
+	m := NewMatcher()
+	for _, w := range msg.Windows {
+		if err := m.AddWindow(w); err != nil {
+			return nil, err
+		}
+	}
+	for _, expr := range msg.Cron {
+		if err := m.AddCron(expr); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Location != "" {
+		loc, err := time.LoadLocation(msg.Location)
+		if err != nil {
+			return nil, fmt.Errorf("timewindow: %v", err)
+		}
+		m.SetLocation(loc)
+	}
+
+	return m, nil
+}
+
+// Filter runs modifiers iff the current time matches a Matcher.
+type Filter struct {
+	*filter.Filter
+}
+
+type filterJSON struct {
+	matcherJSON
+	Modifier     json.RawMessage      `json:"modifier"`
+	ElseModifier json.RawMessage      `json:"else"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+// NewFilter builds a filter that runs modifiers iff the current time matches
+// m.
+func NewFilter(m *Matcher) *Filter {
+	f := filter.New()
+	f.SetRequestCondition(m)
+	f.SetResponseCondition(m)
+	return &Filter{f}
+}
+
+// filterFromJSON builds a timewindow.Filter from JSON, with the fields of
+// the matcher as described by matcherFromJSON.
+//
+// Example JSON:
+//
+//	{
+//	  "timewindow.Filter": {
+//	    "scope": ["request"],
+//	    "cron": ["0-14 * * * *"],
+//	    "modifier": { ... },
+//	    "else": { ... }
+//	  }
+//	}
+func filterFromJSON(b []byte) (*parse.Result, error) {
+	msg := &filterJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	m, err := newMatcher(&msg.matcherJSON)
+	if err != nil {
+		return nil, err
+	}
+	filter := NewFilter(m)
+
+	r, err := parse.FromJSON(msg.Modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.RequestWhenTrue(r.RequestModifier())
+	filter.ResponseWhenTrue(r.ResponseModifier())
+
+	if len(msg.ElseModifier) > 0 {
+		em, err := parse.FromJSON(msg.ElseModifier)
+		if err != nil {
+			return nil, err
+		}
+
+		if em != nil {
+			filter.RequestWhenFalse(em.RequestModifier())
+			filter.ResponseWhenFalse(em.ResponseModifier())
+		}
+	}
+
+	return parse.NewResult(filter, msg.Scope)
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package timewindow
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+
+	// Import to register header.Modifier with JSON parser.
+	_ "github.com/google/martian/v3/header"
+)
+
+// date returns a time in June 2023, when the 4th is a Sunday.
+func date(day, hour, min int) time.Time {
+	return time.Date(2023, time.June, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestCron(t *testing.T) {
+	tt := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", date(5, 12, 0), true},
+		{"*/15 * * * *", date(5, 12, 30), true},
+		{"*/15 * * * *", date(5, 12, 31), false},
+		{"5/20 * * * *", date(5, 12, 45), true},
+		{"0-14 9-17 * * *", date(5, 17, 14), true},
+		{"0-14 9-17 * * *", date(5, 18, 0), false},
+		{"* * * * mon-fri", date(4, 12, 0), false},
+		{"* * * * MON-FRI", date(5, 12, 0), true},
+		{"* * * * 7", date(4, 12, 0), true},
+		{"* * * jun *", date(4, 12, 0), true},
+		{"* * 1,15 jul *", date(15, 12, 0), false},
+		// Either a day of the month or a day of the week matches.
+		{"* * 1 * sun", date(4, 12, 0), true},
+		{"* * 1 * sun", date(1, 12, 0), true},
+		{"* * 1 * sun", date(2, 12, 0), false},
+		{"* * 1 * *", date(2, 12, 0), false},
+	}
+
+	for i, tc := range tt {
+		c, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%d. parseCron(%q): got %v, want no error", i, tc.expr, err)
+		}
+		if got := c.matches(tc.t); got != tc.want {
+			t.Errorf("%d. parseCron(%q).matches(%v): got %t, want %t", i, tc.expr, tc.t, got, tc.want)
+		}
+	}
+
+	for i, expr := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * foo *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%d. parseCron(%q): got nil, want error", i, expr)
+		}
+	}
+}
+
+func TestMatcherWindows(t *testing.T) {
+	m := NewMatcher()
+	if err := m.AddWindow(Window{Start: "09:00", End: "17:00"}); err != nil {
+		t.Fatalf("m.AddWindow(): got %v, want no error", err)
+	}
+	if err := m.AddWindow(Window{Days: []string{"sat"}, Start: "22:00", End: "02:00"}); err != nil {
+		t.Fatalf("m.AddWindow(): got %v, want no error", err)
+	}
+
+	tt := []struct {
+		t    time.Time
+		want bool
+	}{
+		{date(5, 9, 0), true},
+		{date(5, 16, 59), true},
+		{date(5, 17, 0), false},
+		{date(5, 8, 59), false},
+		// Saturday night, into Sunday.
+		{date(3, 23, 0), true},
+		{date(4, 1, 59), true},
+		{date(4, 2, 0), false},
+		{date(4, 23, 0), false},
+		{date(3, 1, 0), false},
+	}
+
+	for i, tc := range tt {
+		if got := m.Match(tc.t); got != tc.want {
+			t.Errorf("%d. m.Match(%v): got %t, want %t", i, tc.t, got, tc.want)
+		}
+	}
+
+	for i, w := range []Window{
+		{Start: "9:00", End: "25:00"},
+		{Start: "noon", End: "13:00"},
+		{Days: []string{"someday"}, Start: "09:00", End: "17:00"},
+	} {
+		if err := m.AddWindow(w); err == nil {
+			t.Errorf("%d. m.AddWindow(%v): got nil, want error", i, w)
+		}
+	}
+}
+
+func TestMatcherLocation(t *testing.T) {
+	m := NewMatcher()
+	if err := m.AddCron("* 9 * * *"); err != nil {
+		t.Fatalf("m.AddCron(): got %v, want no error", err)
+	}
+
+	if got, want := m.Match(date(5, 9, 30)), true; got != want {
+		t.Errorf("m.Match(): got %t, want %t", got, want)
+	}
+
+	m.SetLocation(time.FixedZone("UTC+2", 2*60*60))
+	if got, want := m.Match(date(5, 9, 30)), false; got != want {
+		t.Errorf("m.Match(): got %t, want %t", got, want)
+	}
+	if got, want := m.Match(date(5, 7, 30)), true; got != want {
+		t.Errorf("m.Match(): got %t, want %t", got, want)
+	}
+}
+
+func TestFilterFromJSON(t *testing.T) {
+	tt := []struct {
+		cond string
+		want string
+	}{
+		{`"cron": ["* * * * *"]`, "true"},
+		{`"windows": [{"start": "00:00", "end": "00:00"}]`, "false"},
+	}
+
+	for i, tc := range tt {
+		msg := []byte(`{
+      "timewindow.Filter": {
+        "scope": ["request"],
+        ` + tc.cond + `,
+        "location": "UTC",
+        "modifier": {
+          "header.Modifier": {
+            "scope": ["request"],
+            "name": "X-Maintenance",
+            "value": "true"
+          }
+        },
+        "else": {
+          "header.Modifier": {
+            "scope": ["request"],
+            "name": "X-Maintenance",
+            "value": "false"
+          }
+        }
+      }
+    }`)
+
+		r, err := parse.FromJSON(msg)
+		if err != nil {
+			t.Fatalf("%d. parse.FromJSON(): got %v, want no error", i, err)
+		}
+
+		reqmod := r.RequestModifier()
+		if reqmod == nil {
+			t.Fatalf("%d. reqmod: got nil, want not nil", i)
+		}
+
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		if err != nil {
+			t.Fatalf("%d. http.NewRequest(): got %v, want no error", i, err)
+		}
+		if err := reqmod.ModifyRequest(req); err != nil {
+			t.Fatalf("%d. ModifyRequest(): got %v, want no error", i, err)
+		}
+		if got := req.Header.Get("X-Maintenance"); got != tc.want {
+			t.Errorf("%d. req.Header.Get(%q): got %q, want %q", i, "X-Maintenance", got, tc.want)
+		}
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"timewindow.Filter": {"location": "Nowhere/Special", "modifier": {}}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/martian/v3/connmetric"
+)
+
+// RoundTripTimingKey is the key of the *RoundTripTiming of the round trip of
+// a request in its Context. It is set when the round trip returns, before the
+// response modifiers run.
+const RoundTripTimingKey = "martian.RoundTripTiming"
+
+// RoundTripTiming holds the times of the events of the round trip of a
+// request, as reported by net/http/httptrace. Times of events that did not
+// happen, such as the DNS resolution and connect when a connection is
+// reused, are zero.
+type RoundTripTiming struct {
+	// Start is the time the round trip started, End the time it returned
+	// with the response headers or an error.
+	Start time.Time
+	End   time.Time
+
+	// GetConn is the time a connection was requested, GotConn the time it
+	// was obtained. Reused reports whether the connection was used for a
+	// previous request and WasIdle whether it was idle before.
+	GetConn time.Time
+	GotConn time.Time
+	Reused  bool
+	WasIdle bool
+
+	// LocalAddr and RemoteAddr are the addresses of the connection, and
+	// ConnID identifies it: it is the ID of the connmetric.InstrumentedConn
+	// when connections are tracked, otherwise the local address.
+	LocalAddr  string
+	RemoteAddr string
+	ConnID     string
+
+	DNSStart          time.Time
+	DNSDone           time.Time
+	ConnectStart      time.Time
+	ConnectDone       time.Time
+	TLSHandshakeStart time.Time
+	TLSHandshakeDone  time.Time
+
+	// WroteHeaders and WroteRequest are the times the request headers and
+	// the whole request were written, GotFirstResponseByte the time the
+	// first byte of the response headers was read.
+	WroteHeaders         time.Time
+	WroteRequest         time.Time
+	GotFirstResponseByte time.Time
+}
+
+// DNS returns the duration of the DNS resolution.
+func (t *RoundTripTiming) DNS() time.Duration {
+	return between(t.DNSStart, t.DNSDone)
+}
+
+// Connect returns the duration of the TCP connect.
+func (t *RoundTripTiming) Connect() time.Duration {
+	return between(t.ConnectStart, t.ConnectDone)
+}
+
+// TLSHandshake returns the duration of the TLS handshake.
+func (t *RoundTripTiming) TLSHandshake() time.Duration {
+	return between(t.TLSHandshakeStart, t.TLSHandshakeDone)
+}
+
+// TTFB returns the time to the first byte of the response, from the time the
+// request was written.
+func (t *RoundTripTiming) TTFB() time.Duration {
+	return between(t.WroteRequest, t.GotFirstResponseByte)
+}
+
+// Total returns the duration of the round trip.
+func (t *RoundTripTiming) Total() time.Duration {
+	return between(t.Start, t.End)
+}
+
+// between returns the duration from start to end, or 0 if either is zero.
+func between(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// RoundTripTiming returns the timing of the round trip of the request, if
+// it has been made.
+func (ctx *Context) RoundTripTiming() (*RoundTripTiming, bool) {
+	v, ok := ctx.Get(RoundTripTimingKey)
+	if !ok {
+		return nil, false
+	}
+	t, ok := v.(*RoundTripTiming)
+	return t, ok
+}
+
+// timingTrace records the events of a round trip reported by its
+// httptrace.ClientTrace, which are reported concurrently.
+type timingTrace struct {
+	mu sync.Mutex
+	t  RoundTripTiming
+}
+
+func newTimingTrace() *timingTrace {
+	return &timingTrace{t: RoundTripTiming{Start: time.Now()}}
+}
+
+// withTrace returns ctx with the trace recording the round trip events.
+func (tt *timingTrace) withTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { tt.now(&tt.t.GetConn) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			tt.mu.Lock()
+			defer tt.mu.Unlock()
+
+			tt.t.GotConn = time.Now()
+			tt.t.Reused = info.Reused
+			tt.t.WasIdle = info.WasIdle
+			if c := info.Conn; c != nil {
+				tt.t.LocalAddr = connmetric.AddrString(c.LocalAddr())
+				tt.t.RemoteAddr = connmetric.AddrString(c.RemoteAddr())
+				tt.t.ConnID = connID(c)
+			}
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { tt.now(&tt.t.DNSStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { tt.now(&tt.t.DNSDone) },
+		ConnectStart:         func(string, string) { tt.first(&tt.t.ConnectStart) },
+		ConnectDone:          func(string, string, error) { tt.now(&tt.t.ConnectDone) },
+		TLSHandshakeStart:    func() { tt.now(&tt.t.TLSHandshakeStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tt.now(&tt.t.TLSHandshakeDone) },
+		WroteHeaders:         func() { tt.now(&tt.t.WroteHeaders) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { tt.now(&tt.t.WroteRequest) },
+		GotFirstResponseByte: func() { tt.now(&tt.t.GotFirstResponseByte) },
+	})
+}
+
+// connID returns the ID of the connmetric.InstrumentedConn underlying c, or
+// the local address of c if there is none.
+func connID(c net.Conn) string {
+	for nc := c; nc != nil; {
+		switch v := nc.(type) {
+		case *connmetric.InstrumentedConn:
+			return strconv.FormatUint(v.Stats().ID, 10)
+		case interface{ NetConn() net.Conn }:
+			nc = v.NetConn()
+		default:
+			nc = nil
+		}
+	}
+
+	return connmetric.AddrString(c.LocalAddr())
+}
+
+// now sets *at to the current time.
+func (tt *timingTrace) now(at *time.Time) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	*at = time.Now()
+}
+
+// first sets *at to the current time, unless it is set. Connects to several
+// addresses may race, the first to start is the one timed.
+func (tt *timingTrace) first(at *time.Time) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	if at.IsZero() {
+		*at = time.Now()
+	}
+}
+
+// done ends the round trip and returns a copy of its timing.
+func (tt *timingTrace) done() *RoundTripTiming {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	t := tt.t
+	t.End = time.Now()
+	return &t
+}
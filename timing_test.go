@@ -0,0 +1,101 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIntegrationRoundTripTiming(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		rw.WriteHeader(204)
+	}))
+	defer srv.Close()
+
+	l := newListener(t)
+	p := NewProxy()
+	defer p.Close()
+
+	timings := make(chan *RoundTripTiming, 1)
+	p.SetResponseModifier(ResponseModifierFunc(func(res *http.Response) error {
+		rt, ok := NewContext(res.Request).RoundTripTiming()
+		if !ok {
+			return fmt.Errorf("no round trip timing")
+		}
+		timings <- rt
+		return nil
+	}))
+
+	go serve(p, l)
+
+	conn, err := l.dial()
+	if err != nil {
+		t.Fatalf("net.Dial(): got %v, want no error", err)
+	}
+	defer conn.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("Connection", "close")
+	if err := req.WriteProxy(conn); err != nil {
+		t.Fatalf("req.WriteProxy(): got %v, want no error", err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+	}
+	res.Body.Close()
+	if got, want := res.StatusCode, 204; got != want {
+		t.Fatalf("res.StatusCode: got %d, want %d", got, want)
+	}
+
+	var rt *RoundTripTiming
+	select {
+	case rt = <-timings:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RoundTripTiming: not set")
+	}
+
+	for name, at := range map[string]time.Time{
+		"Start":                rt.Start,
+		"GetConn":              rt.GetConn,
+		"ConnectStart":         rt.ConnectStart,
+		"ConnectDone":          rt.ConnectDone,
+		"GotConn":              rt.GotConn,
+		"WroteRequest":         rt.WroteRequest,
+		"GotFirstResponseByte": rt.GotFirstResponseByte,
+		"End":                  rt.End,
+	} {
+		if at.IsZero() {
+			t.Errorf("rt.%s: got zero time, want non-zero", name)
+		}
+	}
+	if rt.Reused {
+		t.Error("rt.Reused: got true, want false")
+	}
+	if got, want := rt.TTFB(), 10*time.Millisecond; got < want {
+		t.Errorf("rt.TTFB(): got %v, want >= %v", got, want)
+	}
+	if rt.Total() < rt.TTFB() {
+		t.Errorf("rt.Total(): got %v, want >= TTFB %v", rt.Total(), rt.TTFB())
+	}
+	if got := rt.TLSHandshake(); got != 0 {
+		t.Errorf("rt.TLSHandshake(): got %v, want 0", got)
+	}
+	if got, want := rt.RemoteAddr, srv.Listener.Addr().String(); got != want {
+		t.Errorf("rt.RemoteAddr: got %q, want %q", got, want)
+	}
+	if rt.LocalAddr == "" || rt.ConnID != rt.LocalAddr {
+		t.Errorf("rt.ConnID: got %q, want local address %q", rt.ConnID, rt.LocalAddr)
+	}
+}
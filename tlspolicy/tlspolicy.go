@@ -0,0 +1,184 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package tlspolicy registers the "tlspolicy.Policy" JSON message, so that
+// the TLS versions, cipher suites and curves of client-facing MITM
+// connections and of upstream connections can be set from the same
+// configuration as modifiers.
+package tlspolicy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/mitm"
+	"github.com/google/martian/v3/parse"
+)
+
+func init() {
+	parse.Register("tlspolicy.Policy", policyFromJSON)
+}
+
+var (
+	mu    sync.RWMutex
+	mc    *mitm.Config
+	proxy *martian.Proxy
+)
+
+// SetMITM sets the MITM config whose client-facing connections are
+// configured by the "mitm" object of "tlspolicy.Policy" messages.
+func SetMITM(config *mitm.Config) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	mc = config
+}
+
+// SetProxy sets the proxy whose upstream connections are configured by the
+// "upstream" object of "tlspolicy.Policy" messages.
+func SetProxy(p *martian.Proxy) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	proxy = p
+}
+
+// Modifier holds the policies of a parsed "tlspolicy.Policy" message. The
+// policies are applied when the message is parsed, the modifier does not
+// change requests or responses.
+type Modifier struct {
+	mitm     *mitm.TLSPolicy
+	upstream *mitm.TLSPolicy
+}
+
+type policyJSON struct {
+	MITM     *tlsPolicyJSON       `json:"mitm"`
+	Upstream *tlsPolicyJSON       `json:"upstream"`
+	Scope    []parse.ModifierType `json:"scope"`
+}
+
+type tlsPolicyJSON struct {
+	MinVersion   string   `json:"minVersion"`
+	MaxVersion   string   `json:"maxVersion"`
+	CipherSuites []string `json:"cipherSuites"`
+	Curves       []string `json:"curves"`
+}
+
+// MITM returns the applied policy of client-facing connections, or nil.
+func (m *Modifier) MITM() *mitm.TLSPolicy {
+	return m.mitm
+}
+
+// Upstream returns the applied policy of upstream connections, or nil.
+func (m *Modifier) Upstream() *mitm.TLSPolicy {
+	return m.upstream
+}
+
+// ModifyRequest is a no-op.
+func (m *Modifier) ModifyRequest(*http.Request) error {
+	return nil
+}
+
+// ModifyResponse is a no-op.
+func (m *Modifier) ModifyResponse(*http.Response) error {
+	return nil
+}
+
+func (msg *tlsPolicyJSON) policy() (*mitm.TLSPolicy, error) {
+	p := &mitm.TLSPolicy{}
+
+	var err error
+	if msg.MinVersion != "" {
+		if p.MinVersion, err = mitm.ParseTLSVersion(msg.MinVersion); err != nil {
+			return nil, err
+		}
+	}
+	if msg.MaxVersion != "" {
+		if p.MaxVersion, err = mitm.ParseTLSVersion(msg.MaxVersion); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range msg.CipherSuites {
+		id, err := mitm.ParseCipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		p.CipherSuites = append(p.CipherSuites, id)
+	}
+	for _, name := range msg.Curves {
+		c, err := mitm.ParseCurve(name)
+		if err != nil {
+			return nil, err
+		}
+		p.CurvePreferences = append(p.CurvePreferences, c)
+	}
+
+	return p, p.Validate()
+}
+
+// policyFromJSON applies the TLS policies in the JSON message to the MITM
+// config set with SetMITM and the proxy set with SetProxy, and returns a
+// no-op modifier. Versions are "1.0" to "1.3", cipher suites use their
+// standard names and curves are "X25519", "P-256", "P-384", "P-521" or a
+// decimal curve ID.
+//
+// Example JSON:
+//
+//	{
+//	  "tlspolicy.Policy": {
+//	    "mitm": {
+//	      "minVersion": "1.2",
+//	      "cipherSuites": ["TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"],
+//	      "curves": ["X25519", "P-256"]
+//	    },
+//	    "upstream": {
+//	      "minVersion": "1.3"
+//	    }
+//	  }
+//	}
+func policyFromJSON(b []byte) (*parse.Result, error) {
+	msg := &policyJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	c, p := mc, proxy
+	mu.RUnlock()
+
+	m := &Modifier{}
+
+	if msg.MITM != nil {
+		if c == nil {
+			return nil, errors.New("tlspolicy: MITM is not enabled")
+		}
+
+		policy, err := msg.MITM.policy()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.SetTLSPolicy(*policy); err != nil {
+			return nil, err
+		}
+		m.mitm = policy
+	}
+
+	if msg.Upstream != nil {
+		if p == nil {
+			return nil, errors.New("tlspolicy: proxy is not set")
+		}
+
+		policy, err := msg.Upstream.policy()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.SetUpstreamTLSPolicy(*policy); err != nil {
+			return nil, err
+		}
+		m.upstream = policy
+	}
+
+	return parse.NewResult(m, msg.Scope)
+}
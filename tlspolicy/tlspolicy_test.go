@@ -0,0 +1,111 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/mitm"
+	"github.com/google/martian/v3/parse"
+)
+
+func TestPolicyFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"tlspolicy.Policy": {
+			"mitm": {
+				"minVersion": "1.2",
+				"maxVersion": "1.2",
+				"cipherSuites": ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"],
+				"curves": ["X25519", "P-256"]
+			},
+			"upstream": {
+				"minVersion": "1.3"
+			},
+			"scope": ["request"]
+		}
+	}`)
+
+	SetMITM(nil)
+	SetProxy(nil)
+	if _, err := parse.FromJSON(msg); err == nil {
+		t.Fatal("parse.FromJSON(): got nil error, want error without MITM config")
+	}
+
+	ca, priv, err := mitm.NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+	c, err := mitm.NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("mitm.NewConfig(): got %v, want no error", err)
+	}
+	p := martian.NewProxy()
+	defer p.Close()
+
+	SetMITM(c)
+	SetProxy(p)
+	defer SetMITM(nil)
+	defer SetProxy(nil)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	m, ok := r.RequestModifier().(*Modifier)
+	if !ok {
+		t.Fatalf("r.RequestModifier(): got %T, want *Modifier", r.RequestModifier())
+	}
+	if m.MITM() == nil || m.Upstream() == nil {
+		t.Fatalf("m.MITM(), m.Upstream(): got %v, %v, want policies", m.MITM(), m.Upstream())
+	}
+
+	tc := c.TLS()
+	if got, want := tc.MinVersion, uint16(tls.VersionTLS12); got != want {
+		t.Errorf("tc.MinVersion: got 0x%04x, want 0x%04x", got, want)
+	}
+	if got, want := tc.MaxVersion, uint16(tls.VersionTLS12); got != want {
+		t.Errorf("tc.MaxVersion: got 0x%04x, want 0x%04x", got, want)
+	}
+	if got, want := tc.CipherSuites, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tc.CipherSuites: got %v, want %v", got, want)
+	}
+	if got, want := tc.CurvePreferences, []tls.CurveID{tls.X25519, tls.CurveP256}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tc.CurvePreferences: got %v, want %v", got, want)
+	}
+
+	tr := p.GetRoundTripper().(*http.Transport)
+	if got, want := tr.TLSClientConfig.MinVersion, uint16(tls.VersionTLS13); got != want {
+		t.Errorf("tr.TLSClientConfig.MinVersion: got 0x%04x, want 0x%04x", got, want)
+	}
+}
+
+func TestPolicyFromJSONInvalid(t *testing.T) {
+	ca, priv, err := mitm.NewAuthority("martian.proxy", "Martian Authority", time.Hour)
+	if err != nil {
+		t.Fatalf("mitm.NewAuthority(): got %v, want no error", err)
+	}
+	c, err := mitm.NewConfig(ca, priv)
+	if err != nil {
+		t.Fatalf("mitm.NewConfig(): got %v, want no error", err)
+	}
+	SetMITM(c)
+	defer SetMITM(nil)
+
+	for _, policy := range []string{
+		`{"minVersion": "1.4"}`,
+		`{"minVersion": "1.3", "maxVersion": "1.2"}`,
+		`{"cipherSuites": ["TLS_NOT_A_CIPHER"]}`,
+		`{"curves": ["P-999"]}`,
+	} {
+		msg := []byte(`{"tlspolicy.Policy": {"mitm": ` + policy + `}}`)
+		if _, err := parse.FromJSON(msg); err == nil {
+			t.Errorf("parse.FromJSON(%s): got nil error, want error", policy)
+		}
+	}
+}
@@ -20,7 +20,6 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"time"
 
 	"github.com/google/martian/v3/log"
 )
@@ -185,46 +184,11 @@ func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	defaults := receivedConfig.Trafficshape.Defaults
-	if defaults == nil {
-		defaults = &Default{}
-	}
-
-	if defaults.Bandwidth.Up < 0 || defaults.Bandwidth.Down < 0 || defaults.Latency < 0 {
-		http.Error(rw, "Error: Invalid Defaults", 400)
-		return
-	}
-
-	if defaults.Bandwidth.Up == 0 {
-		defaults.Bandwidth.Up = DefaultBitrate / 8
-	}
-	if defaults.Bandwidth.Down == 0 {
-		defaults.Bandwidth.Down = DefaultBitrate / 8
-	}
-
-	// Parse and verify the received shapes.
-	if err := parseShapes(receivedConfig.Trafficshape); err != nil {
+	if err := h.l.Configure(receivedConfig.Trafficshape); err != nil {
 		http.Error(rw, err.Error(), 400)
 		return
 	}
 
-	// Update the Listener with the new traffic shape.
-	h.l.Shapes.Lock()
-
-	h.l.Shapes.LastModifiedTime = time.Now()
-	h.l.ReadBucket.SetCapacity(defaults.Bandwidth.Down)
-	h.l.WriteBucket.SetCapacity(defaults.Bandwidth.Up)
-	h.l.SetLatency(time.Duration(defaults.Latency) * time.Millisecond)
-	h.l.SetDefaults(defaults)
-
-	h.l.Shapes.M = make(map[string]*urlShape)
-	for _, shape := range receivedConfig.Trafficshape.Shapes {
-		h.l.Shapes.M[shape.URLRegex] = &urlShape{Shape: shape}
-	}
-	// Update the time that the map was last modified to the current time.
-	h.l.Shapes.LastModifiedTime = time.Now()
-	h.l.Shapes.Unlock()
-
 	rw.WriteHeader(http.StatusOK)
 	io.WriteString(rw, bodystr)
 }
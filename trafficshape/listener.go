@@ -15,6 +15,7 @@
 package trafficshape
 
 import (
+	"errors"
 	"net"
 	"sync"
 	"time"
@@ -187,6 +188,50 @@ func (l *Listener) SetLatency(latency time.Duration) {
 	l.latency = latency
 }
 
+// Configure verifies ts and replaces the defaults and the shapes of the
+// listener with it.
+func (l *Listener) Configure(ts *Trafficshape) error {
+	defaults := ts.Defaults
+	if defaults == nil {
+		defaults = &Default{}
+	}
+
+	if defaults.Bandwidth.Up < 0 || defaults.Bandwidth.Down < 0 || defaults.Latency < 0 {
+		return errors.New("Error: Invalid Defaults")
+	}
+
+	if defaults.Bandwidth.Up == 0 {
+		defaults.Bandwidth.Up = DefaultBitrate / 8
+	}
+	if defaults.Bandwidth.Down == 0 {
+		defaults.Bandwidth.Down = DefaultBitrate / 8
+	}
+
+	// Parse and verify the received shapes.
+	if err := parseShapes(ts); err != nil {
+		return err
+	}
+
+	// Update the Listener with the new traffic shape.
+	l.Shapes.Lock()
+
+	l.Shapes.LastModifiedTime = time.Now()
+	l.ReadBucket.SetCapacity(defaults.Bandwidth.Down)
+	l.WriteBucket.SetCapacity(defaults.Bandwidth.Up)
+	l.SetLatency(time.Duration(defaults.Latency) * time.Millisecond)
+	l.SetDefaults(defaults)
+
+	l.Shapes.M = make(map[string]*urlShape)
+	for _, shape := range ts.Shapes {
+		l.Shapes.M[shape.URLRegex] = &urlShape{Shape: shape}
+	}
+	// Update the time that the map was last modified to the current time.
+	l.Shapes.LastModifiedTime = time.Now()
+	l.Shapes.Unlock()
+
+	return nil
+}
+
 // GetTrafficShapedConn takes in a normal connection and returns a traffic shaped connection.
 func (l *Listener) GetTrafficShapedConn(oc net.Conn) *Conn {
 	if tsconn, ok := oc.(*Conn); ok {
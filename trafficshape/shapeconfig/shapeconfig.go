@@ -0,0 +1,111 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package shapeconfig registers the "trafficshape.Listener" JSON message, so
+// that traffic shapes can be applied from the same configuration as
+// modifiers.
+package shapeconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/trafficshape"
+)
+
+func init() {
+	parse.Register("trafficshape.Listener", listenerFromJSON)
+}
+
+var (
+	mu       sync.RWMutex
+	listener *trafficshape.Listener
+)
+
+// SetListener sets the listener that is configured by "trafficshape.Listener"
+// messages. A nil listener makes parsing such messages fail.
+func SetListener(l *trafficshape.Listener) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	listener = l
+}
+
+// Modifier holds the traffic shape of a parsed "trafficshape.Listener"
+// message. The shape is applied to the listener when the message is parsed,
+// the modifier does not change requests or responses.
+type Modifier struct {
+	ts *trafficshape.Trafficshape
+}
+
+type listenerJSON struct {
+	trafficshape.Trafficshape
+	Scope []parse.ModifierType `json:"scope"`
+}
+
+// Trafficshape returns the applied traffic shape.
+func (m *Modifier) Trafficshape() *trafficshape.Trafficshape {
+	return m.ts
+}
+
+// ModifyRequest is a no-op.
+func (m *Modifier) ModifyRequest(*http.Request) error {
+	return nil
+}
+
+// ModifyResponse is a no-op.
+func (m *Modifier) ModifyResponse(*http.Response) error {
+	return nil
+}
+
+// listenerFromJSON applies the traffic shape in the JSON message to the
+// listener set with SetListener and returns a no-op modifier. The message has
+// the same format as the "trafficshape" object of the traffic shaping API.
+//
+// Example JSON:
+//
+//	{
+//	  "trafficshape.Listener": {
+//	    "default": {
+//	      "bandwidth": {
+//	        "up": 100000,
+//	        "down": 100000
+//	      },
+//	      "latency": 100
+//	    },
+//	    "shapes": [
+//	      {
+//	        "url_regex": "example\\.com/video",
+//	        "throttles": [
+//	          {
+//	            "bytes": "1000-",
+//	            "bandwidth": 5000
+//	          }
+//	        ]
+//	      }
+//	    ]
+//	  }
+//	}
+func listenerFromJSON(b []byte) (*parse.Result, error) {
+	msg := &listenerJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	l := listener
+	mu.RUnlock()
+
+	if l == nil {
+		return nil, errors.New("shapeconfig: traffic shaping is not enabled")
+	}
+
+	ts := &msg.Trafficshape
+	if err := l.Configure(ts); err != nil {
+		return nil, err
+	}
+
+	return parse.NewResult(&Modifier{ts: ts}, msg.Scope)
+}
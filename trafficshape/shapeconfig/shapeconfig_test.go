@@ -0,0 +1,78 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package shapeconfig
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/trafficshape"
+)
+
+func TestListenerFromJSON(t *testing.T) {
+	msg := []byte(`{
+		"trafficshape.Listener": {
+			"default": {
+				"bandwidth": {
+					"up": 1000,
+					"down": 2000
+				},
+				"latency": 100
+			},
+			"shapes": [
+				{
+					"url_regex": "example\\.com",
+					"throttles": [
+						{
+							"bytes": "500-",
+							"bandwidth": 100
+						}
+					]
+				}
+			],
+			"scope": ["request"]
+		}
+	}`)
+
+	SetListener(nil)
+	if _, err := parse.FromJSON(msg); err == nil {
+		t.Fatal("parse.FromJSON(): got nil error, want error without listener")
+	}
+
+	nl, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): got %v, want no error", err)
+	}
+	defer nl.Close()
+
+	l := trafficshape.NewListener(nl)
+	SetListener(l)
+	defer SetListener(nil)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+
+	if r.RequestModifier() == nil {
+		t.Fatal("r.RequestModifier(): got nil, want modifier")
+	}
+	if r.ResponseModifier() != nil {
+		t.Errorf("r.ResponseModifier(): got %v, want nil", r.ResponseModifier())
+	}
+
+	if got, want := l.Latency(), 100*time.Millisecond; got != want {
+		t.Errorf("l.Latency(): got %v, want %v", got, want)
+	}
+	if got, want := l.WriteBitrate(), int64(8000); got != want {
+		t.Errorf("l.WriteBitrate(): got %d, want %d", got, want)
+	}
+	if got, want := l.ReadBitrate(), int64(16000); got != want {
+		t.Errorf("l.ReadBitrate(): got %d, want %d", got, want)
+	}
+	if _, ok := l.Shapes.M[`example\.com`]; !ok {
+		t.Errorf("l.Shapes.M[%q]: got no shape, want shape", `example\.com`)
+	}
+}
@@ -0,0 +1,483 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package wasm provides a modifier that runs WebAssembly modules on requests
+// and responses, so that third-party modifiers run sandboxed, with their own
+// memory and limits on memory and time.
+//
+// A module exports its memory as "memory", a function "alloc" of type
+// (i32) -> i32 that returns a buffer of the given size in its memory, and
+// the functions "modify_request", "modify_response" or both, of type
+// (i32, i32) -> i64. The proxy writes a JSON view of the request or
+// response to a buffer returned by alloc and calls the function with its
+// address and size. The function returns the address of a JSON result in
+// the high 32 bits and its size in the low 32 bits, or 0 to leave the
+// message unchanged. A module may import the function "martian" "log" of
+// type (i32, i32) -> (), which logs the string at the given address and
+// size. Modules may import nothing else, so WASI modules are not supported.
+// Modules are compiled and run by github.com/tetratelabs/wazero.
+//
+// A request is viewed as:
+//
+//	{
+//	  "method": "GET",
+//	  "url": "http://example.com/path?query",
+//	  "host": "example.com",
+//	  "remoteAddr": "192.0.2.1:1234",
+//	  "headers": {"Name": ["value"]},
+//	  "body": "<base64>"
+//	}
+//
+// and a response as:
+//
+//	{
+//	  "status": 200,
+//	  "headers": {"Name": ["value"]},
+//	  "body": "<base64>",
+//	  "request": {...}
+//	}
+//
+// A result has the same fields, all optional: the fields that are present
+// replace those of the message, with "headers" replacing all headers. The
+// result of modify_request may also set "skipRoundTrip" to true.
+//
+// Each call runs in a new instance of the module, so calls do not share
+// state. A call fails if it runs longer than the timeout of the modifier,
+// and memory.grow fails if it would grow the memory of the instance beyond
+// its limit. Modules whose memory requires more than the limit fail to run.
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// DefaultTimeout is the default time a call into a module may take.
+const DefaultTimeout = 100 * time.Millisecond
+
+// DefaultMemoryLimit is the default size of the memory of a module.
+const DefaultMemoryLimit = 16 << 20
+
+// pageSize is the size of a page of linear memory.
+const pageSize = 65536
+
+// maxPages is the maximum number of pages of a 32-bit linear memory.
+const maxPages = 65536
+
+func init() {
+	parse.Register("wasm.Modifier", modifierFromJSON)
+}
+
+// Modifier runs the functions of a WebAssembly module on requests and
+// responses.
+type Modifier struct {
+	name string
+	b    []byte
+	// cache holds the compiled module, shared by the runtimes of the calls.
+	cache    wazero.CompilationCache
+	reqfn    bool
+	resfn    bool
+	timeout  time.Duration
+	memPages uint32
+}
+
+type modifierJSON struct {
+	File           string               `json:"file"`
+	TimeoutMs      int64                `json:"timeoutMs"`
+	MaxMemoryBytes int64                `json:"maxMemoryBytes"`
+	Scope          []parse.ModifierType `json:"scope"`
+}
+
+type requestView struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Host       string      `json:"host"`
+	RemoteAddr string      `json:"remoteAddr"`
+	Headers    http.Header `json:"headers"`
+	Body       []byte      `json:"body"`
+}
+
+type responseView struct {
+	Status  int          `json:"status"`
+	Headers http.Header  `json:"headers"`
+	Body    []byte       `json:"body"`
+	Request *requestView `json:"request,omitempty"`
+}
+
+type requestResult struct {
+	Method        *string     `json:"method"`
+	URL           *string     `json:"url"`
+	Host          *string     `json:"host"`
+	Headers       http.Header `json:"headers"`
+	Body          *[]byte     `json:"body"`
+	SkipRoundTrip bool        `json:"skipRoundTrip"`
+}
+
+type responseResult struct {
+	Status  *int        `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    *[]byte     `json:"body"`
+}
+
+var (
+	allocType  = funcType{params: []api.ValueType{api.ValueTypeI32}, results: []api.ValueType{api.ValueTypeI32}}
+	modifyType = funcType{params: []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}, results: []api.ValueType{api.ValueTypeI64}}
+)
+
+type funcType struct {
+	params  []api.ValueType
+	results []api.ValueType
+}
+
+func (t funcType) matches(fn api.FunctionDefinition) bool {
+	return bytes.Equal(t.params, fn.ParamTypes()) && bytes.Equal(t.results, fn.ResultTypes())
+}
+
+// NewModifier compiles the WebAssembly module b and returns a Modifier
+// calling its modify_request and modify_response functions. name names the
+// module in logs and errors.
+func NewModifier(name string, b []byte) (*Modifier, error) {
+	mod := &Modifier{
+		name:    name,
+		b:       b,
+		cache:   wazero.NewCompilationCache(),
+		timeout: DefaultTimeout,
+	}
+	mod.SetMemoryLimit(DefaultMemoryLimit)
+
+	ctx := context.Background()
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(mod.cache))
+	defer rt.Close(ctx)
+
+	cm, err := rt.CompileModule(ctx, b)
+	if err != nil {
+		return nil, mod.wrap(err)
+	}
+
+	for _, fn := range cm.ImportedFunctions() {
+		if m, n, _ := fn.Import(); m != "martian" || n != "log" {
+			return nil, fmt.Errorf("wasm: %s: module imports %s.%s", name, m, n)
+		}
+	}
+	for _, mem := range cm.ImportedMemories() {
+		m, n, _ := mem.Import()
+		return nil, fmt.Errorf("wasm: %s: module imports %s.%s", name, m, n)
+	}
+
+	fns := cm.ExportedFunctions()
+	for fn, found := range map[string]*bool{
+		"modify_request":  &mod.reqfn,
+		"modify_response": &mod.resfn,
+	} {
+		if err := checkExport(fns, fn, modifyType); err == nil {
+			*found = true
+		} else if _, ok := fns[fn]; ok {
+			return nil, mod.wrap(err)
+		}
+	}
+	if !mod.reqfn && !mod.resfn {
+		return nil, fmt.Errorf("wasm: %s: module exports neither modify_request nor modify_response", name)
+	}
+	if err := checkExport(fns, "alloc", allocType); err != nil {
+		return nil, mod.wrap(err)
+	}
+	if _, ok := cm.ExportedMemories()["memory"]; !ok {
+		return nil, fmt.Errorf("wasm: %s: module does not export memory", name)
+	}
+
+	return mod, nil
+}
+
+func checkExport(fns map[string]api.FunctionDefinition, name string, want funcType) error {
+	fn, ok := fns[name]
+	if !ok {
+		return fmt.Errorf("module does not export function %s", name)
+	}
+	if !want.matches(fn) {
+		return fmt.Errorf("function %s has the wrong type", name)
+	}
+	return nil
+}
+
+// SetTimeout sets the time a call into the module may take, including its
+// instantiation. A timeout of zero disables the limit.
+func (m *Modifier) SetTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
+// SetMemoryLimit sets the size of the memory of the module, rounded down to
+// a number of 64KiB pages.
+func (m *Modifier) SetMemoryLimit(size int64) {
+	pages := size / pageSize
+	if pages > maxPages {
+		pages = maxPages
+	}
+	m.memPages = uint32(pages)
+}
+
+// call instantiates the module and calls its function fn with the JSON of
+// view. It decodes the result into res, and reports whether there is one.
+func (m *Modifier) call(fn string, view, res any) (bool, error) {
+	ctx := context.Background()
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	// Each call has its own runtime, as the memory limit is set per
+	// runtime; the compiled module is shared through the cache.
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCompilationCache(m.cache).
+		WithMemoryLimitPages(m.memPages).
+		WithCloseOnContextDone(true))
+	defer rt.Close(context.Background())
+
+	if _, err := rt.NewHostModuleBuilder("martian").
+		NewFunctionBuilder().WithFunc(m.hostLog).Export("log").
+		Instantiate(ctx); err != nil {
+		return false, m.wrap(err)
+	}
+	cm, err := rt.CompileModule(ctx, m.b)
+	if err != nil {
+		return false, m.wrap(err)
+	}
+	in, err := rt.InstantiateModule(ctx, cm, wazero.NewModuleConfig().WithName("").WithStartFunctions())
+	if err != nil {
+		return false, m.wrap(err)
+	}
+
+	b, err := json.Marshal(view)
+	if err != nil {
+		return false, err
+	}
+	r, err := in.ExportedFunction("alloc").Call(ctx, uint64(len(b)))
+	if err != nil {
+		return false, m.wrap(err)
+	}
+	ptr := uint32(r[0])
+	if !in.Memory().Write(ptr, b) {
+		return false, fmt.Errorf("wasm: %s: alloc: buffer out of bounds", m.name)
+	}
+
+	if r, err = in.ExportedFunction(fn).Call(ctx, uint64(ptr), uint64(len(b))); err != nil {
+		return false, m.wrap(err)
+	}
+	if r[0] == 0 {
+		return false, nil
+	}
+
+	out, ok := in.Memory().Read(uint32(r[0]>>32), uint32(r[0]))
+	if !ok {
+		return false, fmt.Errorf("wasm: %s: %s: result out of bounds", m.name, fn)
+	}
+	if err := json.Unmarshal(out, res); err != nil {
+		return false, fmt.Errorf("wasm: %s: %s: invalid result: %v", m.name, fn, err)
+	}
+
+	return true, nil
+}
+
+func (m *Modifier) wrap(err error) error {
+	var exit *sys.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == sys.ExitCodeDeadlineExceeded {
+		return fmt.Errorf("wasm: %s: execution exceeded timeout of %v", m.name, m.timeout)
+	}
+	return fmt.Errorf("wasm: %s: %v", m.name, err)
+}
+
+func (m *Modifier) hostLog(ctx context.Context, mod api.Module, ptr, size uint32) {
+	b, ok := mod.Memory().Read(ptr, size)
+	if !ok {
+		panic(fmt.Errorf("log: message out of bounds"))
+	}
+	log.Infof("wasm: %s: %s", m.name, b)
+}
+
+// ModifyRequest calls the modify_request function of the module, if any,
+// with a view of req, and applies its result.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	if !m.reqfn {
+		return nil
+	}
+
+	view, err := viewRequest(req)
+	if err != nil {
+		return err
+	}
+	res := &requestResult{}
+	ok, err := m.call("modify_request", view, res)
+	if err != nil || !ok {
+		return err
+	}
+
+	if res.Method != nil {
+		req.Method = *res.Method
+	}
+	if res.URL != nil {
+		u, err := url.Parse(*res.URL)
+		if err != nil {
+			return fmt.Errorf("wasm: %s: invalid url: %v", m.name, err)
+		}
+		req.URL = u
+		req.Host = u.Host
+	}
+	if res.Host != nil {
+		req.Host = *res.Host
+	}
+	if res.Headers != nil {
+		req.Header = res.Headers
+	}
+	if res.Body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(*res.Body))
+		req.ContentLength = int64(len(*res.Body))
+	}
+	if res.SkipRoundTrip {
+		ctx := martian.NewContext(req)
+		if ctx == nil {
+			return fmt.Errorf("wasm: %s: skipRoundTrip: request has no context", m.name)
+		}
+		ctx.SkipRoundTrip()
+	}
+
+	return nil
+}
+
+// ModifyResponse calls the modify_response function of the module, if any,
+// with a view of res, and applies its result.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	if !m.resfn {
+		return nil
+	}
+
+	body, err := readBody(&res.Body)
+	if err != nil {
+		return err
+	}
+	view := &responseView{
+		Status:  res.StatusCode,
+		Headers: res.Header,
+		Body:    body,
+	}
+	if res.Request != nil {
+		if view.Request, err = viewRequest(res.Request); err != nil {
+			return err
+		}
+	}
+
+	r := &responseResult{}
+	ok, err := m.call("modify_response", view, r)
+	if err != nil || !ok {
+		return err
+	}
+
+	if r.Status != nil {
+		if *r.Status < 100 || *r.Status > 999 {
+			return fmt.Errorf("wasm: %s: invalid status %d", m.name, *r.Status)
+		}
+		res.StatusCode = *r.Status
+		res.Status = fmt.Sprintf("%d %s", *r.Status, http.StatusText(*r.Status))
+	}
+	if r.Headers != nil {
+		res.Header = r.Headers
+	}
+	if r.Body != nil {
+		res.Body = ioutil.NopCloser(bytes.NewReader(*r.Body))
+		res.ContentLength = int64(len(*r.Body))
+		res.TransferEncoding = nil
+	}
+
+	return nil
+}
+
+func viewRequest(req *http.Request) (*requestView, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &requestView{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Host:       req.Host,
+		RemoteAddr: req.RemoteAddr,
+		Headers:    req.Header,
+		Body:       body,
+	}, nil
+}
+
+// readBody reads the body, which is replaced with the bytes read so that it
+// can be read again.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	*body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("wasm: reading body: %v", err)
+	}
+
+	return b, nil
+}
+
+// modifierFromJSON builds a wasm.Modifier from JSON. The module is read from
+// "file". "timeoutMs" and "maxMemoryBytes" override the default limits of
+// each call.
+//
+// Example JSON:
+//
+//	{
+//	  "wasm.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "file": "/etc/martian/modifier.wasm",
+//	    "timeoutMs": 50,
+//	    "maxMemoryBytes": 8388608
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if msg.File == "" {
+		return nil, fmt.Errorf("wasm: file must be set")
+	}
+	if msg.TimeoutMs < 0 || msg.MaxMemoryBytes < 0 {
+		return nil, fmt.Errorf("wasm: timeoutMs and maxMemoryBytes must not be negative")
+	}
+
+	wb, err := ioutil.ReadFile(msg.File)
+	if err != nil {
+		return nil, fmt.Errorf("wasm: %v", err)
+	}
+	mod, err := NewModifier(filepath.Base(msg.File), wb)
+	if err != nil {
+		return nil, err
+	}
+	if msg.TimeoutMs > 0 {
+		mod.SetTimeout(time.Duration(msg.TimeoutMs) * time.Millisecond)
+	}
+	if msg.MaxMemoryBytes > 0 {
+		mod.SetMemoryLimit(msg.MaxMemoryBytes)
+	}
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,358 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package wasm
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+// Value types and export kinds of the binary format.
+const (
+	typeI32    byte = 0x7f
+	typeI64    byte = 0x7e
+	kindFunc   byte = 0x00
+	kindMemory byte = 0x02
+)
+
+// The helpers below assemble binary modules for tests.
+
+func uleb(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func sleb(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func cat(bs ...[]byte) []byte {
+	var b []byte
+	for _, x := range bs {
+		b = append(b, x...)
+	}
+	return b
+}
+
+func vec(items ...[]byte) []byte {
+	return cat(uleb(uint64(len(items))), cat(items...))
+}
+
+func name(s string) []byte {
+	return cat(uleb(uint64(len(s))), []byte(s))
+}
+
+func section(id byte, contents []byte) []byte {
+	return cat([]byte{id}, uleb(uint64(len(contents))), contents)
+}
+
+func wasmModule(sections ...[]byte) []byte {
+	return cat([]byte("\x00asm\x01\x00\x00\x00"), cat(sections...))
+}
+
+func sig(params, results []byte) []byte {
+	return cat([]byte{0x60}, vec(splitBytes(params)...), vec(splitBytes(results)...))
+}
+
+func splitBytes(b []byte) [][]byte {
+	var items [][]byte
+	for _, c := range b {
+		items = append(items, []byte{c})
+	}
+	return items
+}
+
+// body returns the code of a function with locals of type i32 and the
+// instructions ins, which must end with 0x0b.
+func body(i32Locals int, ins ...byte) []byte {
+	locals := vec()
+	if i32Locals > 0 {
+		locals = vec(cat(uleb(uint64(i32Locals)), []byte{typeI32}))
+	}
+	b := cat(locals, ins)
+	return cat(uleb(uint64(len(b))), b)
+}
+
+func exportFunc(n string, idx uint32) []byte {
+	return cat(name(n), []byte{kindFunc}, uleb(uint64(idx)))
+}
+
+// modifierModule returns a module whose modify_request logs its argument
+// and returns reqResult, and whose modify_response returns resResult. If
+// spin is true, modify_request loops forever instead.
+func modifierModule(reqResult, resResult string, spin bool) []byte {
+	const reqAt, resAt = 1024, 2048
+
+	modifyRequest := cat(
+		[]byte{0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x42},
+		sleb(reqAt<<32|int64(len(reqResult))),
+		[]byte{0x0b},
+	)
+	if reqResult == "" {
+		modifyRequest = []byte{0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x42, 0x00, 0x0b}
+	}
+	if spin {
+		modifyRequest = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00, 0x0b}
+	}
+
+	return wasmModule(
+		section(1, vec(
+			sig([]byte{typeI32, typeI32}, nil),
+			sig([]byte{typeI32}, []byte{typeI32}),
+			sig([]byte{typeI32, typeI32}, []byte{typeI64}),
+		)),
+		section(2, vec(cat(name("martian"), name("log"), []byte{kindFunc, 0x00}))),
+		section(3, vec([]byte{1}, []byte{2}, []byte{2})),
+		section(5, vec([]byte{0x00, 0x01})),
+		// The next free address for alloc.
+		section(6, vec(cat([]byte{typeI32, 0x01, 0x41}, sleb(4096), []byte{0x0b}))),
+		section(7, vec(
+			cat(name("memory"), []byte{kindMemory, 0x00}),
+			exportFunc("alloc", 1),
+			exportFunc("modify_request", 2),
+			exportFunc("modify_response", 3),
+		)),
+		section(10, vec(
+			// alloc: return the next free address and advance it by n.
+			body(0, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b),
+			body(0, modifyRequest...),
+			body(0, cat([]byte{0x42}, sleb(resAt<<32|int64(len(resResult))), []byte{0x0b})...),
+		)),
+		section(11, vec(
+			cat([]byte{0x00, 0x41}, sleb(reqAt), []byte{0x0b}, name(reqResult)),
+			cat([]byte{0x00, 0x41}, sleb(resAt), []byte{0x0b}, name(resResult)),
+		)),
+	)
+}
+
+func TestModifyRequest(t *testing.T) {
+	m, err := NewModifier("test.wasm", modifierModule(
+		`{"method":"PUT","headers":{"X-Wasm":["true"]},"body":"aGk=","skipRoundTrip":true}`, `{}`, false))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-Remove", "true")
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got, want := req.Method, "PUT"; got != want {
+		t.Errorf("req.Method: got %q, want %q", got, want)
+	}
+	if got, want := req.Header.Get("X-Wasm"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Wasm", got, want)
+	}
+	if got := req.Header.Get("X-Remove"); got != "" {
+		t.Errorf("req.Header.Get(%q): got %q, want no value", "X-Remove", got)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "hi"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+	if got, want := req.ContentLength, int64(2); got != want {
+		t.Errorf("req.ContentLength: got %d, want %d", got, want)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Error("ctx.SkippingRoundTrip(): got false, want true")
+	}
+}
+
+func TestModifyRequestUnchanged(t *testing.T) {
+	m, err := NewModifier("test.wasm", modifierModule("", `{}`, false))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-Keep", "true")
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got, want := req.Header.Get("X-Keep"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Keep", got, want)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "body"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestModifyResponse(t *testing.T) {
+	m, err := NewModifier("test.wasm", modifierModule("", `{"status":201,"body":"b2s="}`, false))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(404, strings.NewReader("not found"), req)
+	res.Header.Set("X-Keep", "true")
+
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	if got, want := res.StatusCode, 201; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("X-Keep"), "true"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Keep", got, want)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "ok"; string(got) != want {
+		t.Errorf("res.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestModifierLimits(t *testing.T) {
+	m, err := NewModifier("spin.wasm", modifierModule("", `{}`, true))
+	if err != nil {
+		t.Fatalf("NewModifier(): got %v, want no error", err)
+	}
+	m.SetTimeout(10 * time.Millisecond)
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := m.ModifyRequest(req); err == nil || err.Error() != "wasm: spin.wasm: execution exceeded timeout of 10ms" {
+		t.Errorf("ModifyRequest(): got %v, want timeout error", err)
+	}
+
+	m.SetMemoryLimit(pageSize - 1)
+	if err := m.ModifyRequest(req); err == nil || !strings.Contains(err.Error(), "min 1 pages (64 Ki) over limit of 0 pages") {
+		t.Errorf("ModifyRequest(): got %v, want memory limit error", err)
+	}
+}
+
+func TestNewModifierErrors(t *testing.T) {
+	tt := []struct {
+		b    []byte
+		want string
+	}{
+		{[]byte("not wasm"), "wasm: test.wasm: invalid magic number"},
+		{wasmModule(
+			section(1, vec(sig(nil, nil))),
+			section(2, vec(cat(name("env"), name("abort"), []byte{kindFunc, 0x00}))),
+		), "wasm: test.wasm: module imports env.abort"},
+		{wasmModule(), "wasm: test.wasm: module exports neither modify_request nor modify_response"},
+		{wasmModule(
+			section(1, vec(sig(nil, nil))),
+			section(3, vec([]byte{0})),
+			section(7, vec(exportFunc("modify_request", 0))),
+			section(10, vec(body(0, 0x0b))),
+		), "wasm: test.wasm: function modify_request has the wrong type"},
+		{wasmModule(
+			section(1, vec(sig([]byte{typeI32, typeI32}, []byte{typeI64}))),
+			section(3, vec([]byte{0})),
+			section(7, vec(exportFunc("modify_response", 0))),
+			section(10, vec(body(0, 0x42, 0x00, 0x0b))),
+		), "wasm: test.wasm: module does not export function alloc"},
+	}
+
+	for i, tc := range tt {
+		_, err := NewModifier("test.wasm", tc.b)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%d. NewModifier(): got error %v, want %q", i, err, tc.want)
+		}
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "modifier.wasm")
+	if err := ioutil.WriteFile(path, modifierModule(`{"headers":{"X-Wasm":["true"]}}`, `{}`, false), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile(): got %v, want no error", err)
+	}
+
+	msg := []byte(`{
+	  "wasm.Modifier": {
+	    "scope": ["request"],
+	    "file": "` + path + `",
+	    "timeoutMs": 50,
+	    "maxMemoryBytes": 1048576
+	  }
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("r.RequestModifier(): got nil, want not nil")
+	}
+	if resmod := r.ResponseModifier(); resmod != nil {
+		t.Error("r.ResponseModifier(): got not nil, want nil")
+	}
+
+	m := reqmod.(*Modifier)
+	if got, want := m.name, "modifier.wasm"; got != want {
+		t.Errorf("m.name: got %q, want %q", got, want)
+	}
+	if got, want := m.timeout, 50*time.Millisecond; got != want {
+		t.Errorf("m.timeout: got %v, want %v", got, want)
+	}
+	if got, want := m.memPages, uint32(16); got != want {
+		t.Errorf("m.memPages: got %d, want %d", got, want)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	if err := reqmod.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if got, want := req.Header.Get("X-Wasm"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Wasm", got, want)
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+// Package webhook provides a modifier that calls out to an HTTP endpoint for
+// each request or response and applies the mutations it returns.
+//
+// The modifier POSTs a JSON view of the message to the endpoint. For a
+// request, the view is
+//
+//	{
+//	  "request": {
+//	    "method": "GET",
+//	    "url": "http://example.com/path?query",
+//	    "host": "example.com",
+//	    "remoteAddr": "192.0.2.1:1234",
+//	    "headers": {"Name": ["value"]},
+//	    "body": "<base64>",
+//	    "labels": {"device": "pixel7"}
+//	  }
+//	}
+//
+// where "labels" are the labels of the session, if any. For a response, the
+// view additionally has
+//
+//	"response": {
+//	  "status": 200,
+//	  "headers": {"Name": ["value"]},
+//	  "body": "<base64>"
+//	}
+//
+// The endpoint replies with 204 No Content to leave the message unchanged,
+// or with 200 OK and the mutations to apply:
+//
+//	{
+//	  "setHeaders": {"Name": ["value"]},
+//	  "removeHeaders": ["Name"],
+//	  "body": "<base64>",
+//	  "status": 403
+//	}
+//
+// All fields are optional. "setHeaders" replaces the values of the headers
+// named, and "body" replaces the body. "status" sets the status of a
+// response; in reply to a request, it short-circuits the request: the round
+// trip is skipped, the proxy responds with the status, and the headers and
+// body of the mutations apply to that response instead of the request.
+//
+// If the callout fails, by default the error is logged and the message is
+// left unchanged. When the modifier fails closed, the error aborts the
+// exchange instead, see martian.Abort.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/parse"
+)
+
+// DefaultTimeout is the default time a callout may take.
+const DefaultTimeout = time.Second
+
+// DefaultMaxBodySize is the default size of the largest body sent to the
+// endpoint.
+const DefaultMaxBodySize = 1 << 20
+
+const contextKey = "webhook.Response"
+
+func init() {
+	parse.Register("webhook.Modifier", modifierFromJSON)
+}
+
+// Modifier calls out to a webhook endpoint for requests and responses.
+type Modifier struct {
+	url        string
+	client     *http.Client
+	timeout    time.Duration
+	maxBody    int64
+	failClosed bool
+}
+
+type modifierJSON struct {
+	URL          string               `json:"url"`
+	TimeoutMs    int64                `json:"timeoutMs"`
+	MaxBodyBytes int64                `json:"maxBodyBytes"`
+	FailClosed   bool                 `json:"failClosed"`
+	Scope        []parse.ModifierType `json:"scope"`
+}
+
+type requestView struct {
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Host       string            `json:"host"`
+	RemoteAddr string            `json:"remoteAddr"`
+	Headers    http.Header       `json:"headers"`
+	Body       []byte            `json:"body"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+type responseView struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    []byte      `json:"body"`
+}
+
+type calloutJSON struct {
+	Request  *requestView  `json:"request,omitempty"`
+	Response *responseView `json:"response,omitempty"`
+}
+
+type mutations struct {
+	SetHeaders    http.Header `json:"setHeaders"`
+	RemoveHeaders []string    `json:"removeHeaders"`
+	Body          *[]byte     `json:"body"`
+	Status        int         `json:"status"`
+}
+
+// NewModifier returns a Modifier calling out to the endpoint at url.
+func NewModifier(url string) *Modifier {
+	return &Modifier{
+		url:     url,
+		client:  &http.Client{},
+		timeout: DefaultTimeout,
+		maxBody: DefaultMaxBodySize,
+	}
+}
+
+// SetTimeout sets the time a callout may take. A timeout of zero disables
+// the limit.
+func (m *Modifier) SetTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
+// SetMaxBodySize sets the size of the largest body sent to the endpoint;
+// larger bodies fail the callout.
+func (m *Modifier) SetMaxBodySize(size int64) {
+	m.maxBody = size
+}
+
+// SetFailClosed sets the error policy. When true, a failed callout aborts
+// the exchange. When false, the error is logged and the message is left
+// unchanged. By default, the Modifier fails open.
+func (m *Modifier) SetFailClosed(failClosed bool) {
+	m.failClosed = failClosed
+}
+
+// ModifyRequest calls out to the endpoint with a view of req and applies the
+// mutations returned.
+func (m *Modifier) ModifyRequest(req *http.Request) error {
+	view, err := m.viewRequest(req)
+	if err != nil {
+		return m.fail(err)
+	}
+	mut, err := m.callout(req.Context(), &calloutJSON{Request: view})
+	if err != nil || mut == nil {
+		return m.fail(err)
+	}
+
+	if mut.Status != 0 {
+		ctx := martian.NewContext(req)
+		if ctx == nil {
+			return m.fail(errors.New("status: request has no context"))
+		}
+		ctx.Set(contextKey, mut)
+		ctx.SkipRoundTrip()
+		return nil
+	}
+
+	mut.apply(req.Header, &req.Body, &req.ContentLength)
+	return nil
+}
+
+// ModifyResponse calls out to the endpoint with a view of res and its
+// request and applies the mutations returned. If the request was
+// short-circuited by the Modifier, it applies the mutations returned for the
+// request instead.
+func (m *Modifier) ModifyResponse(res *http.Response) error {
+	parent := context.Background()
+	if res.Request != nil {
+		parent = res.Request.Context()
+
+		if ctx := martian.NewContext(res.Request); ctx != nil {
+			if v, ok := ctx.Get(contextKey); ok {
+				return m.applyResponse(res, v.(*mutations))
+			}
+		}
+	}
+
+	view := &calloutJSON{
+		Response: &responseView{
+			Status:  res.StatusCode,
+			Headers: res.Header,
+		},
+	}
+	var err error
+	if res.Request != nil {
+		if view.Request, err = m.viewRequest(res.Request); err != nil {
+			return m.fail(err)
+		}
+	}
+	if view.Response.Body, err = m.readBody(&res.Body); err != nil {
+		return m.fail(err)
+	}
+
+	mut, err := m.callout(parent, view)
+	if err != nil || mut == nil {
+		return m.fail(err)
+	}
+
+	return m.applyResponse(res, mut)
+}
+
+func (m *Modifier) applyResponse(res *http.Response, mut *mutations) error {
+	if mut.Status != 0 {
+		if mut.Status < 100 || mut.Status > 999 {
+			return m.fail(fmt.Errorf("invalid status %d", mut.Status))
+		}
+		res.StatusCode = mut.Status
+		res.Status = fmt.Sprintf("%d %s", mut.Status, http.StatusText(mut.Status))
+	}
+	if mut.apply(res.Header, &res.Body, &res.ContentLength) {
+		res.TransferEncoding = nil
+	}
+
+	return nil
+}
+
+// apply applies the header and body mutations, and reports whether the body
+// is replaced.
+func (mut *mutations) apply(h http.Header, body *io.ReadCloser, length *int64) bool {
+	for _, name := range mut.RemoveHeaders {
+		h.Del(name)
+	}
+	for name, values := range mut.SetHeaders {
+		h.Del(name)
+		for _, v := range values {
+			h.Add(name, v)
+		}
+	}
+
+	if mut.Body == nil {
+		return false
+	}
+	if *body != nil {
+		(*body).Close()
+	}
+	*body = ioutil.NopCloser(bytes.NewReader(*mut.Body))
+	*length = int64(len(*mut.Body))
+	return true
+}
+
+// fail returns err according to the error policy.
+func (m *Modifier) fail(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	err = fmt.Errorf("webhook: %s: %v", m.url, err)
+	if m.failClosed {
+		return martian.Abort(err)
+	}
+
+	log.Errorf("%v", err)
+	return nil
+}
+
+// callout posts view to the endpoint and returns the mutations it replies
+// with, or nil if it replies with no content.
+func (m *Modifier) callout(parent context.Context, view *calloutJSON) (*mutations, error) {
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	if m.timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, m.timeout)
+	}
+	defer cancel()
+
+	b, err := json.Marshal(view)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", m.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	mut := &mutations{}
+	if err := json.NewDecoder(res.Body).Decode(mut); err != nil {
+		return nil, fmt.Errorf("invalid reply: %v", err)
+	}
+
+	return mut, nil
+}
+
+func (m *Modifier) viewRequest(req *http.Request) (*requestView, error) {
+	body, err := m.readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &requestView{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Host:       req.Host,
+		RemoteAddr: req.RemoteAddr,
+		Headers:    req.Header,
+		Body:       body,
+	}
+	if ctx := martian.NewContext(req); ctx != nil {
+		view.Labels = ctx.Session().Labels()
+	}
+
+	return view, nil
+}
+
+// readBody reads the body, which is restored so that it can be read again.
+// It fails if the body is larger than the limit of the Modifier.
+func (m *Modifier) readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+
+	orig := *body
+	b, err := ioutil.ReadAll(io.LimitReader(orig, m.maxBody+1))
+	*body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), orig), orig}
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %v", err)
+	}
+	if int64(len(b)) > m.maxBody {
+		return nil, fmt.Errorf("body exceeds limit of %d bytes", m.maxBody)
+	}
+
+	return b, nil
+}
+
+// modifierFromJSON builds a webhook.Modifier from JSON. "url" is the
+// endpoint called out to. "timeoutMs" and "maxBodyBytes" override the
+// default limits, and "failClosed" makes failed callouts abort the exchange.
+//
+// Example JSON:
+//
+//	{
+//	  "webhook.Modifier": {
+//	    "scope": ["request", "response"],
+//	    "url": "http://localhost:8081/hook",
+//	    "timeoutMs": 500,
+//	    "maxBodyBytes": 65536,
+//	    "failClosed": true
+//	  }
+//	}
+func modifierFromJSON(b []byte) (*parse.Result, error) {
+	msg := &modifierJSON{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	if msg.URL == "" {
+		return nil, errors.New("webhook: url must be set")
+	}
+	if msg.TimeoutMs < 0 || msg.MaxBodyBytes < 0 {
+		return nil, errors.New("webhook: timeoutMs and maxBodyBytes must not be negative")
+	}
+
+	mod := NewModifier(msg.URL)
+	if msg.TimeoutMs > 0 {
+		mod.SetTimeout(time.Duration(msg.TimeoutMs) * time.Millisecond)
+	}
+	if msg.MaxBodyBytes > 0 {
+		mod.SetMaxBodySize(msg.MaxBodyBytes)
+	}
+	mod.SetFailClosed(msg.FailClosed)
+
+	return parse.NewResult(mod, msg.Scope)
+}
@@ -0,0 +1,307 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3"
+	"github.com/google/martian/v3/parse"
+	"github.com/google/martian/v3/proxyutil"
+)
+
+// newEndpoint returns a webhook endpoint that records the callouts it
+// receives and replies with reply, or with no content if reply is empty.
+func newEndpoint(t *testing.T, reply string) (*httptest.Server, *[]calloutJSON) {
+	t.Helper()
+
+	var callouts []calloutJSON
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var c calloutJSON
+		if err := json.NewDecoder(req.Body).Decode(&c); err != nil {
+			t.Errorf("json.Decode(): got %v, want no error", err)
+		}
+		callouts = append(callouts, c)
+
+		if reply == "" {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(reply))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &callouts
+}
+
+func TestModifyRequest(t *testing.T) {
+	srv, callouts := newEndpoint(t, `{
+	  "setHeaders": {"X-Webhook": ["true"]},
+	  "removeHeaders": ["X-Remove"],
+	  "body": "aGk="
+	}`)
+	m := NewModifier(srv.URL)
+
+	req, err := http.NewRequest("POST", "http://example.com/path", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-Remove", "true")
+	req.Header.Set("X-Keep", "true")
+	martian.TestContext(req, nil, nil).Session().SetLabel("device", "pixel7")
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got, want := len(*callouts), 1; got != want {
+		t.Fatalf("len(callouts): got %d, want %d", got, want)
+	}
+	c := (*callouts)[0]
+	if c.Request == nil || c.Response != nil {
+		t.Fatalf("callout: got %+v, want request only", c)
+	}
+	if got, want := c.Request.URL, "http://example.com/path"; got != want {
+		t.Errorf("callout.Request.URL: got %q, want %q", got, want)
+	}
+	if got, want := string(c.Request.Body), "body"; got != want {
+		t.Errorf("callout.Request.Body: got %q, want %q", got, want)
+	}
+	if got, want := c.Request.Labels["device"], "pixel7"; got != want {
+		t.Errorf("callout.Request.Labels[%q]: got %q, want %q", "device", got, want)
+	}
+
+	if got, want := req.Header.Get("X-Webhook"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Webhook", got, want)
+	}
+	if got, want := req.Header.Get("X-Keep"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Keep", got, want)
+	}
+	if got := req.Header.Get("X-Remove"); got != "" {
+		t.Errorf("req.Header.Get(%q): got %q, want no value", "X-Remove", got)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "hi"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+	if got, want := req.ContentLength, int64(2); got != want {
+		t.Errorf("req.ContentLength: got %d, want %d", got, want)
+	}
+}
+
+func TestModifyRequestShortCircuit(t *testing.T) {
+	srv, callouts := newEndpoint(t, `{"status": 403, "setHeaders": {"X-Blocked": ["true"]}, "body": "bm8="}`)
+	m := NewModifier(srv.URL)
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	ctx := martian.TestContext(req, nil, nil)
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+	if !ctx.SkippingRoundTrip() {
+		t.Fatal("ctx.SkippingRoundTrip(): got false, want true")
+	}
+	if got := req.Header.Get("X-Blocked"); got != "" {
+		t.Errorf("req.Header.Get(%q): got %q, want no value", "X-Blocked", got)
+	}
+
+	res := proxyutil.NewResponse(200, nil, req)
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	// The response is not called out for.
+	if got, want := len(*callouts), 1; got != want {
+		t.Errorf("len(callouts): got %d, want %d", got, want)
+	}
+	if got, want := res.StatusCode, 403; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("X-Blocked"), "true"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "X-Blocked", got, want)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "no"; string(got) != want {
+		t.Errorf("res.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestModifyResponse(t *testing.T) {
+	srv, callouts := newEndpoint(t, `{"status": 200, "setHeaders": {"Cache-Control": ["no-store"]}}`)
+	m := NewModifier(srv.URL)
+
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	res := proxyutil.NewResponse(404, strings.NewReader("not found"), req)
+
+	if err := m.ModifyResponse(res); err != nil {
+		t.Fatalf("ModifyResponse(): got %v, want no error", err)
+	}
+
+	c := (*callouts)[0]
+	if c.Request == nil || c.Response == nil {
+		t.Fatalf("callout: got %+v, want request and response", c)
+	}
+	if got, want := c.Response.Status, 404; got != want {
+		t.Errorf("callout.Response.Status: got %d, want %d", got, want)
+	}
+	if got, want := string(c.Response.Body), "not found"; got != want {
+		t.Errorf("callout.Response.Body: got %q, want %q", got, want)
+	}
+
+	if got, want := res.StatusCode, 200; got != want {
+		t.Errorf("res.StatusCode: got %d, want %d", got, want)
+	}
+	if got, want := res.Header.Get("Cache-Control"), "no-store"; got != want {
+		t.Errorf("res.Header.Get(%q): got %q, want %q", "Cache-Control", got, want)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "not found"; string(got) != want {
+		t.Errorf("res.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestModifyNoContent(t *testing.T) {
+	srv, _ := newEndpoint(t, "")
+	m := NewModifier(srv.URL)
+
+	req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("http.NewRequest(): got %v, want no error", err)
+	}
+	req.Header.Set("X-Keep", "true")
+
+	if err := m.ModifyRequest(req); err != nil {
+		t.Fatalf("ModifyRequest(): got %v, want no error", err)
+	}
+
+	if got, want := req.Header.Get("X-Keep"), "true"; got != want {
+		t.Errorf("req.Header.Get(%q): got %q, want %q", "X-Keep", got, want)
+	}
+	got, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	if want := "body"; string(got) != want {
+		t.Errorf("req.Body: got %q, want %q", got, want)
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// The context is only canceled once the body has been read.
+		ioutil.ReadAll(req.Body)
+		<-req.Context().Done()
+	}))
+	defer slow.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "broken", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	tt := []struct {
+		url     string
+		maxBody int64
+		want    string
+	}{
+		{slow.URL, DefaultMaxBodySize, "context deadline exceeded"},
+		{broken.URL, DefaultMaxBodySize, "unexpected status 500"},
+		{broken.URL, 2, "body exceeds limit of 2 bytes"},
+	}
+
+	for i, tc := range tt {
+		for _, failClosed := range []bool{false, true} {
+			m := NewModifier(tc.url)
+			m.SetTimeout(50 * time.Millisecond)
+			m.SetMaxBodySize(tc.maxBody)
+			m.SetFailClosed(failClosed)
+
+			req, err := http.NewRequest("POST", "http://example.com/", strings.NewReader("body"))
+			if err != nil {
+				t.Fatalf("http.NewRequest(): got %v, want no error", err)
+			}
+
+			err = m.ModifyRequest(req)
+			if !failClosed {
+				if err != nil {
+					t.Errorf("%d. ModifyRequest(): got %v, want no error when failing open", i, err)
+				}
+			} else if !martian.IsAbort(err) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("%d. ModifyRequest(): got %v, want abort error containing %q", i, err, tc.want)
+			}
+
+			// The request is left unchanged.
+			got, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("%d. ioutil.ReadAll(): got %v, want no error", i, err)
+			}
+			if want := "body"; string(got) != want {
+				t.Errorf("%d. req.Body: got %q, want %q", i, got, want)
+			}
+		}
+	}
+}
+
+func TestModifierFromJSON(t *testing.T) {
+	msg := []byte(`{
+	  "webhook.Modifier": {
+	    "scope": ["request", "response"],
+	    "url": "http://localhost:8081/hook",
+	    "timeoutMs": 500,
+	    "maxBodyBytes": 65536,
+	    "failClosed": true
+	  }
+	}`)
+
+	r, err := parse.FromJSON(msg)
+	if err != nil {
+		t.Fatalf("parse.FromJSON(): got %v, want no error", err)
+	}
+	reqmod := r.RequestModifier()
+	if reqmod == nil {
+		t.Fatal("r.RequestModifier(): got nil, want not nil")
+	}
+	if resmod := r.ResponseModifier(); resmod == nil {
+		t.Error("r.ResponseModifier(): got nil, want not nil")
+	}
+
+	m := reqmod.(*Modifier)
+	if got, want := m.url, "http://localhost:8081/hook"; got != want {
+		t.Errorf("m.url: got %q, want %q", got, want)
+	}
+	if got, want := m.timeout, 500*time.Millisecond; got != want {
+		t.Errorf("m.timeout: got %v, want %v", got, want)
+	}
+	if got, want := m.maxBody, int64(65536); got != want {
+		t.Errorf("m.maxBody: got %d, want %d", got, want)
+	}
+	if !m.failClosed {
+		t.Error("m.failClosed: got false, want true")
+	}
+
+	if _, err := parse.FromJSON([]byte(`{"webhook.Modifier": {"scope": ["request"]}}`)); err == nil {
+		t.Error("parse.FromJSON(): got nil, want error for missing url")
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/martian/v3/log"
+	"github.com/google/martian/v3/trafficshape"
+)
+
+// WirePeer is the peer of a connection captured by a WireCapturer.
+type WirePeer int
+
+const (
+	// WireClient is a connection from a client to the proxy.
+	WireClient WirePeer = iota
+	// WireUpstream is a connection from the proxy to a destination server or
+	// upstream proxy.
+	WireUpstream
+)
+
+// String returns the name of the peer.
+func (p WirePeer) String() string {
+	switch p {
+	case WireClient:
+		return "client"
+	case WireUpstream:
+		return "upstream"
+	default:
+		return fmt.Sprintf("WirePeer(%d)", int(p))
+	}
+}
+
+// WireConn identifies a connection captured by a WireCapturer.
+type WireConn struct {
+	// Session is the session the connection belongs to. Upstream connections
+	// belong to the session of the request they were dialed for, even when
+	// they are reused for requests of other sessions.
+	Session *Session
+	Peer    WirePeer
+	// ID is unique among the connections captured by the process.
+	ID uint64
+	// Decrypted is set for the plaintext of a MITM'd TLS connection, which is
+	// captured in addition to the encrypted bytes of the underlying
+	// connection.
+	Decrypted bool
+}
+
+// WireCapturer receives the exact bytes read from and written to the
+// connections of sessions, see Proxy.WireCapture. Reads and writes of a
+// connection happen on different goroutines, so methods must be safe for
+// concurrent use.
+type WireCapturer interface {
+	// Capture is called with the bytes read from c if read is set, or
+	// written to c otherwise. b must not be retained.
+	Capture(c *WireConn, read bool, b []byte)
+
+	// Close is called once no more bytes are captured for c.
+	Close(c *WireConn)
+}
+
+// WireCaptureFunc is a WireCapturer that calls the func for the captured
+// bytes.
+type WireCaptureFunc func(c *WireConn, read bool, b []byte)
+
+// Capture calls f(c, read, b).
+func (f WireCaptureFunc) Capture(c *WireConn, read bool, b []byte) {
+	f(c, read, b)
+}
+
+// Close does nothing.
+func (f WireCaptureFunc) Close(*WireConn) {}
+
+var nextWireConnID atomic.Uint64
+
+// captureWire returns conn wrapped so that its bytes are captured by
+// p.WireCapture, or conn if capturing is disabled.
+func (p *Proxy) captureWire(s *Session, peer WirePeer, decrypted bool, conn net.Conn) net.Conn {
+	if p.WireCapture == nil || s == nil || conn == nil {
+		return conn
+	}
+	// Traffic shaping relies on the type of the connection.
+	if _, ok := conn.(*trafficshape.Conn); ok {
+		return conn
+	}
+
+	wc := &wireConn{
+		Conn: conn,
+		wc: &WireConn{
+			Session:   s,
+			Peer:      peer,
+			ID:        nextWireConnID.Add(1),
+			Decrypted: decrypted,
+		},
+		capture: p.WireCapture,
+	}
+	if peer == WireClient {
+		s.addWireConn(wc)
+	}
+
+	return wc
+}
+
+// captureUpstream returns the upstream conn dialed with ctx wrapped by
+// captureWire, if ctx belongs to a request of a session.
+func (p *Proxy) captureUpstream(ctx context.Context, conn net.Conn) net.Conn {
+	if p.WireCapture == nil || ctx == nil {
+		return conn
+	}
+	mctx, ok := ctx.Value(marianKey).(*Context)
+	if !ok {
+		return conn
+	}
+
+	return p.captureWire(mctx.Session(), WireUpstream, false, conn)
+}
+
+// wireConn is a net.Conn whose bytes are captured.
+type wireConn struct {
+	net.Conn
+	wc      *WireConn
+	capture WireCapturer
+	once    sync.Once
+}
+
+func (c *wireConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.capture.Capture(c.wc, true, b[:n])
+	}
+	return n, err
+}
+
+func (c *wireConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.capture.Capture(c.wc, false, b[:n])
+	}
+	return n, err
+}
+
+// NetConn returns the underlying connection.
+func (c *wireConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *wireConn) Close() error {
+	c.finish()
+	return c.Conn.Close()
+}
+
+// finish ends capturing, the underlying connection is not closed.
+func (c *wireConn) finish() {
+	c.once.Do(func() {
+		c.capture.Close(c.wc)
+	})
+}
+
+func (s *Session) addWireConn(c *wireConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.wireConns = append(s.wireConns, c)
+}
+
+// finishWire ends capturing the client connections of the session.
+func (s *Session) finishWire() {
+	s.mu.Lock()
+	conns := s.wireConns
+	s.wireConns = nil
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.finish()
+	}
+}
+
+// NewWireCaptureDir returns a WireCapturer that writes the bytes of each
+// connection to files in dir, which must exist. The files are named
+// "<session>-<peer>-<conn>-read" and "<session>-<peer>-<conn>-written", with
+// the session ID, the peer, and the connection ID, suffixed with
+// "-decrypted" for the plaintext of MITM'd TLS connections.
+func NewWireCaptureDir(dir string) WireCapturer {
+	return &wireCaptureDir{
+		dir:   dir,
+		files: make(map[wireFileKey]*os.File),
+	}
+}
+
+type wireFileKey struct {
+	id   uint64
+	read bool
+}
+
+type wireCaptureDir struct {
+	dir string
+
+	mu    sync.Mutex
+	files map[wireFileKey]*os.File
+}
+
+func (d *wireCaptureDir) Capture(c *WireConn, read bool, b []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	k := wireFileKey{id: c.ID, read: read}
+	f, ok := d.files[k]
+	if !ok {
+		var err error
+		f, err = os.Create(filepath.Join(d.dir, wireFileName(c, read)))
+		if err != nil {
+			log.Errorf("martian: failed to create wire capture file: %v", err)
+		}
+		// A nil file is stored so that creating it is not retried.
+		d.files[k] = f
+	}
+	if f == nil {
+		return
+	}
+
+	if _, err := f.Write(b); err != nil {
+		log.Errorf("martian: failed to write wire capture file: %v", err)
+	}
+}
+
+func (d *wireCaptureDir) Close(c *WireConn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, read := range []bool{true, false} {
+		k := wireFileKey{id: c.ID, read: read}
+		if f := d.files[k]; f != nil {
+			f.Close()
+		}
+		delete(d.files, k)
+	}
+}
+
+func wireFileName(c *WireConn, read bool) string {
+	name := fmt.Sprintf("%s-%s-%d", c.Session.ID(), c.Peer, c.ID)
+	if read {
+		name += "-read"
+	} else {
+		name += "-written"
+	}
+	if c.Decrypted {
+		name += "-decrypted"
+	}
+	return name
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Sauce Labs, Inc. All rights reserved.
+
+package martian
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIntegrationWireCapture(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	var (
+		mu       sync.Mutex
+		captured = make(map[string]*bytes.Buffer)
+	)
+	l := newListener(t)
+	p := NewProxy()
+	defer p.Close()
+
+	p.WireCapture = WireCaptureFunc(func(c *WireConn, read bool, b []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		k := fmt.Sprintf("%s-%t", c.Peer, read)
+		if captured[k] == nil {
+			captured[k] = &bytes.Buffer{}
+		}
+		captured[k].Write(b)
+	})
+	p.SetTimeout(2 * time.Second)
+
+	go serve(p, l)
+
+	conn, err := l.dial()
+	if err != nil {
+		t.Fatalf("net.Dial(): got %v, want no error", err)
+	}
+	defer conn.Close()
+
+	raw := "GET " + s.URL + "/path HTTP/1.1\r\nHost: " + s.Listener.Addr().String() + "\r\n\r\n"
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		t.Fatalf("conn.Write(): got %v, want no error", err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("http.ReadResponse(): got %v, want no error", err)
+	}
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Fatalf("ioutil.ReadAll(): got %v, want no error", err)
+	}
+	res.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "client-true", want: raw},
+		{key: "client-false", want: "HTTP/1.1 200 OK\r\n"},
+		{key: "upstream-false", want: "GET /path HTTP/1.1\r\n"},
+		{key: "upstream-true", want: "\r\n\r\nhello"},
+	}
+	for _, tc := range tests {
+		got := captured[tc.key].String()
+		if !strings.Contains(got, tc.want) {
+			t.Errorf("captured %s: got %q, want to contain %q", tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestWireCaptureDir(t *testing.T) {
+	dir := t.TempDir()
+	wc := NewWireCaptureDir(dir)
+
+	c := &WireConn{
+		Session: newSession(nil, nil),
+		Peer:    WireUpstream,
+		ID:      7,
+	}
+	wc.Capture(c, false, []byte("GET / "))
+	wc.Capture(c, false, []byte("HTTP/1.1\r\n"))
+	wc.Capture(c, true, []byte("HTTP/1.1 200 OK\r\n"))
+	wc.Close(c)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: c.Session.ID() + "-upstream-7-written", want: "GET / HTTP/1.1\r\n"},
+		{name: c.Session.ID() + "-upstream-7-read", want: "HTTP/1.1 200 OK\r\n"},
+	}
+	for _, tc := range tests {
+		got, err := os.ReadFile(filepath.Join(dir, tc.name))
+		if err != nil {
+			t.Fatalf("os.ReadFile(%q): got %v, want no error", tc.name, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIntegrationWireCaptureTransparentMITM(t *testing.T) {
+	t.Parallel()
+
+	if *withHandler {
+		t.Skip("skipping in handler mode")
+	}
+
+	testTransparentMITM(t, func(p *Proxy) {
+		p.WireCapture = WireCaptureFunc(func(*WireConn, bool, []byte) {})
+	})
+}